@@ -7,6 +7,7 @@ import (
 	"github.com/gorilla/websocket"
 
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
@@ -25,6 +26,8 @@ func DefaultConnectionFactory(url string, origin string) (WSConnection, error) {
 	logger.WithField("url", url).Info("Connecting to")
 
 	header := http.Header{"Origin": []string{origin}}
+	// the compressed message bodies are decompressed transparently, when parsed
+	header.Set(protocol.AcceptEncodingHeader, strings.Join(protocol.Encodings, ", "))
 	conn, _, err := websocket.DefaultDialer.Dial(url, header)
 	if err != nil {
 		return nil, err
@@ -41,7 +44,10 @@ type Client interface {
 	Close()
 
 	Subscribe(path string) error
+	SubscribeFunc(path string, bufferSize int, handler func(*protocol.Message)) (*Subscription, error)
+	SubscribeWithFetch(path string, sinceID uint64) (<-chan struct{}, error)
 	Unsubscribe(path string) error
+	Fetch(path string, start int64, count int) (<-chan *protocol.Message, error)
 
 	Send(path string, body string, header string) error
 	SendBytes(path string, body []byte, header string) error
@@ -50,6 +56,10 @@ type Client interface {
 	Messages() chan *protocol.Message
 	StatusMessages() chan *protocol.NotificationMessage
 	Errors() chan *protocol.NotificationMessage
+	Gaps() chan Gap
+
+	SetGapDetection(enabled bool)
+	LastID(path string) uint64
 
 	SetWSConnectionFactory(WSConnectionFactory)
 	IsConnected() bool
@@ -69,6 +79,21 @@ type client struct {
 	wSConnectionFactory func(url string, origin string) (WSConnection, error)
 	// flag, to indicate if the client is connected
 	connected bool
+
+	// writeMu serializes the writes to the connection
+	writeMu sync.Mutex
+
+	// topics are the subscribed topics, tracking the IDs of their messages
+	topicsMu     sync.Mutex
+	topics       map[protocol.Path]*topic
+	gapDetection bool
+	gaps         chan Gap
+
+	// fetches are the pending fetches, by topic (guarded by topicsMu)
+	fetches map[protocol.Path]*fetch
+
+	// subscriptions are the subscriptions with a handler, by topic (guarded by topicsMu)
+	subscriptions map[protocol.Path]*Subscription
 }
 
 // Open is a shortcut for New() and Start()
@@ -84,6 +109,10 @@ func New(url, origin string, channelSize int, autoReconnect bool) Client {
 		messages:       make(chan *protocol.Message, channelSize),
 		statusMessages: make(chan *protocol.NotificationMessage, channelSize),
 		errors:         make(chan *protocol.NotificationMessage, channelSize),
+		gaps:           make(chan Gap, channelSize),
+		topics:         make(map[protocol.Path]*topic),
+		fetches:        make(map[protocol.Path]*fetch),
+		subscriptions:  make(map[protocol.Path]*Subscription),
 		url:            url,
 		origin:         origin,
 		shouldStopChan: make(chan bool, 1),
@@ -148,6 +177,8 @@ func (c *client) startWithReconnect() {
 		} else {
 			c.setIsConnected(true)
 			logger.Warn("Reconnected again")
+			c.resubscribeAll()
+			c.resubscribeSubscriptions()
 		}
 	}
 }
@@ -157,6 +188,7 @@ func (c *client) readLoop() error {
 		_, msg, err := c.ws.ReadMessage()
 		if err != nil {
 			c.setIsConnected(false)
+			c.fetchesAborted()
 			if c.shouldStop() {
 				return nil
 			}
@@ -167,7 +199,7 @@ func (c *client) readLoop() error {
 			return err
 		}
 
-		logger.WithField("msg", string(msg)).Debug("Raw >")
+		logger.WithField("msg", protocol.Redact(msg)).Debug("Raw >")
 		c.handleIncomingMessage(msg)
 	}
 }
@@ -195,8 +227,23 @@ func (c *client) handleIncomingMessage(msg []byte) {
 
 	switch message := parsed.(type) {
 	case *protocol.Message:
-		c.messages <- message
+		if f := c.fetchOf(message.Path); f != nil {
+			f.messages <- message
+		} else if s := c.subscriptionOf(message.Path); s != nil {
+			s.push(message)
+		} else if c.track(message) {
+			c.messages <- message
+		}
 	case *protocol.NotificationMessage:
+		switch message.Name {
+		case protocol.SUCCESS_FETCH_START:
+			c.subscriptionFetchStarted(message.Arg)
+		case protocol.SUCCESS_FETCH_END:
+			c.fetchDone(protocol.Path(message.Arg))
+			c.fetchEnded(protocol.Path(message.Arg))
+		case protocol.ERROR_UNSUBSCRIBED:
+			c.canceled(message.Arg)
+		}
 		if message.IsError {
 			select {
 			case c.errors <- message:
@@ -212,21 +259,25 @@ func (c *client) handleIncomingMessage(msg []byte) {
 }
 
 func (c *client) Subscribe(path string) error {
-	cmd := &protocol.Cmd{
-		Name: protocol.CmdReceive,
-		Arg:  path,
+	if fields := strings.Fields(path); len(fields) > 0 {
+		if err := c.subscribed(protocol.Path(fields[0])); err != nil {
+			return err
+		}
 	}
-	err := c.ws.WriteMessage(websocket.BinaryMessage, cmd.Bytes())
-	return err
+	return c.writeCmd(protocol.CmdReceive, path)
 }
 
 func (c *client) Unsubscribe(path string) error {
+	c.unsubscribed(protocol.Path(path))
+	return c.writeCmd(protocol.CmdCancel, path)
+}
+
+func (c *client) writeCmd(name, arg string) error {
 	cmd := &protocol.Cmd{
-		Name: protocol.CmdCancel,
-		Arg:  path,
+		Name: name,
+		Arg:  arg,
 	}
-	err := c.ws.WriteMessage(websocket.BinaryMessage, cmd.Bytes())
-	return err
+	return c.WriteRawMessage(cmd.Bytes())
 }
 
 func (c *client) Send(path string, body string, header string) error {
@@ -245,6 +296,8 @@ func (c *client) SendBytes(path string, body []byte, header string) error {
 }
 
 func (c *client) WriteRawMessage(message []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
 	return c.ws.WriteMessage(websocket.BinaryMessage, message)
 }
 
@@ -261,6 +314,7 @@ func (c *client) Errors() chan *protocol.NotificationMessage {
 }
 
 func (c *client) Close() {
+	c.closeSubscriptions()
 	c.shouldStopChan <- true
 	c.ws.Close()
 }
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// ErrFetchPending is returned when fetching or subscribing to a topic with a pending fetch, or fetching
+// or subscribing with a handler to a subscribed topic: the server holds a single subscription for each topic
+// of a connection.
+var ErrFetchPending = errors.New("The topic is already being fetched or subscribed.")
+
+// fetch is a pending fetch of the stored messages of a topic.
+type fetch struct {
+	path     protocol.Path
+	messages chan *protocol.Message
+}
+
+// Fetch requests the stored messages of a topic (including its subtopics) from the ID start,
+// or the last ones if start is negative, and at most count messages (all of them, if 0).
+// The fetched messages are sent to the returned channel instead of the Messages channel,
+// and the channel is closed when the fetch is done (or the connection is lost).
+// The topic must not overlap a subscription, as the messages of both can not be told apart.
+func (c *client) Fetch(path string, start int64, count int) (<-chan *protocol.Message, error) {
+	p := protocol.Path(path)
+	c.topicsMu.Lock()
+	if _, fetching := c.fetches[p]; fetching {
+		c.topicsMu.Unlock()
+		return nil, ErrFetchPending
+	}
+	_, tracked := c.topics[p]
+	_, subscribed := c.subscriptions[p]
+	if tracked || subscribed {
+		c.topicsMu.Unlock()
+		return nil, ErrFetchPending
+	}
+	f := &fetch{path: p, messages: make(chan *protocol.Message, cap(c.messages))}
+	c.fetches[p] = f
+	c.topicsMu.Unlock()
+
+	if err := c.writeCmd(protocol.CmdReceive, fmt.Sprintf("%s %d %d", path, start, count)); err != nil {
+		c.fetchDone(p)
+		return nil, err
+	}
+	return f.messages, nil
+}
+
+// SubscribeWithFetch subscribes to a topic, after fetching its stored messages from the ID sinceID:
+// all of them are sent to the Messages channel, and the returned channel is closed when the stored
+// messages were all sent (the following ones being published after the subscription).
+func (c *client) SubscribeWithFetch(path string, sinceID uint64) (<-chan struct{}, error) {
+	p := protocol.Path(path)
+	c.topicsMu.Lock()
+	_, fetching := c.fetches[p]
+	_, subscribed := c.subscriptions[p]
+	if fetching || subscribed {
+		c.topicsMu.Unlock()
+		return nil, ErrFetchPending
+	}
+	t, ok := c.topics[p]
+	if !ok {
+		t = &topic{path: p}
+		c.topics[p] = t
+	}
+	if sinceID > 0 && t.lastID < sinceID-1 {
+		t.lastID = sinceID - 1
+	}
+	caughtUp := make(chan struct{})
+	t.caughtUp = caughtUp
+	c.topicsMu.Unlock()
+
+	if err := c.writeCmd(protocol.CmdReceive, fmt.Sprintf("%s %d", path, sinceID)); err != nil {
+		return nil, err
+	}
+	return caughtUp, nil
+}
+
+// fetchOf returns the pending fetch of a message (the most specific one), or nil.
+func (c *client) fetchOf(path protocol.Path) *fetch {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+	var found *fetch
+	for p, f := range c.fetches {
+		if path.InTopic(p) && (found == nil || len(p) > len(found.path)) {
+			found = f
+		}
+	}
+	return found
+}
+
+// fetchDone closes the channel of the pending fetch of a topic, if any.
+func (c *client) fetchDone(path protocol.Path) {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+	if f, ok := c.fetches[path]; ok {
+		close(f.messages)
+		delete(c.fetches, path)
+	}
+}
+
+// fetchesAborted closes the channels of all the pending fetches, when the connection is lost.
+func (c *client) fetchesAborted() {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+	for path, f := range c.fetches {
+		close(f.messages)
+		delete(c.fetches, path)
+	}
+}
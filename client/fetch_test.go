@@ -0,0 +1,100 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestFetch(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	c := New("url", "origin", 10, false).(*client)
+	connMock := NewMockWSConnection(ctrl)
+	c.ws = connMock
+
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /foo -2 2"))
+	fetched, err := c.Fetch("/foo", -2, 2)
+	a.NoError(err)
+
+	// a topic is fetched once at a time
+	_, err = c.Fetch("/foo", 0, 0)
+	a.Equal(ErrFetchPending, err)
+	a.Equal(ErrFetchPending, c.Subscribe("/foo"))
+
+	// the fetched messages are sent to the channel of the fetch, which is closed at its end
+	c.handleIncomingMessage([]byte("#" + protocol.SUCCESS_FETCH_START + " /foo 2"))
+	c.handleIncomingMessage((&protocol.Message{ID: 4, Path: "/foo/bar"}).Bytes())
+	c.handleIncomingMessage((&protocol.Message{ID: 5, Path: "/foo"}).Bytes())
+	c.handleIncomingMessage((&protocol.Message{ID: 1, Path: "/other"}).Bytes())
+	c.handleIncomingMessage([]byte("#" + protocol.SUCCESS_FETCH_END + " /foo"))
+
+	var ids []uint64
+	for m := range fetched {
+		ids = append(ids, m.ID)
+	}
+	a.Equal([]uint64{4, 5}, ids)
+	a.Equal(1, len(c.Messages()))
+
+	// a subscribed topic can not be fetched
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /foo"))
+	a.NoError(c.Subscribe("/foo"))
+	_, err = c.Fetch("/foo", 0, 10)
+	a.Equal(ErrFetchPending, err)
+}
+
+func TestFetch_AbortedByTheConnection(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	c := New("url", "origin", 10, false).(*client)
+	connMock := NewMockWSConnection(ctrl)
+	c.ws = connMock
+
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /foo 0 0"))
+	fetched, err := c.Fetch("/foo", 0, 0)
+	a.NoError(err)
+
+	c.fetchesAborted()
+	_, open := <-fetched
+	a.False(open)
+}
+
+func TestSubscribeWithFetch(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	c := New("url", "origin", 10, false).(*client)
+	connMock := NewMockWSConnection(ctrl)
+	c.ws = connMock
+	c.SetGapDetection(true)
+
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /foo 3"))
+	caughtUp, err := c.SubscribeWithFetch("/foo", 3)
+	a.NoError(err)
+	a.Equal(uint64(2), c.LastID("/foo"))
+
+	c.handleIncomingMessage((&protocol.Message{ID: 3, Path: "/foo"}).Bytes())
+	c.handleIncomingMessage((&protocol.Message{ID: 4, Path: "/foo"}).Bytes())
+	select {
+	case <-caughtUp:
+		a.Fail("caught up before the end of the fetch")
+	default:
+	}
+
+	// the fetched and the following messages are sent to the Messages channel
+	c.handleIncomingMessage([]byte("#" + protocol.SUCCESS_FETCH_END + " /foo"))
+	_, open := <-caughtUp
+	a.False(open)
+	c.handleIncomingMessage((&protocol.Message{ID: 5, Path: "/foo"}).Bytes())
+	a.Equal(3, len(c.Messages()))
+	a.Equal(uint64(5), c.LastID("/foo"))
+}
@@ -0,0 +1,260 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// Gap is a range of message IDs of a topic, which the client did not receive and could not fetch again:
+// the messages were deleted or expired meanwhile, or are not delivered to the client (e.g. filtered for other users).
+type Gap struct {
+	Path protocol.Path
+	From uint64
+	To   uint64
+}
+
+func (g Gap) String() string {
+	return fmt.Sprintf("%s %d-%d", g.Path, g.From, g.To)
+}
+
+// topic is the state of a subscribed topic.
+type topic struct {
+	path protocol.Path
+
+	// lastID is the highest ID of the received messages
+	lastID uint64
+
+	// missing are the gaps in the IDs of the received messages, while they are fetched again
+	missing []Gap
+	filling bool
+
+	// fillTo is the last ID fetched again by the pending fill (0, if up to the last message after a reconnection)
+	fillTo uint64
+
+	// caughtUp is closed at the end of the fetch of a subscription with fetch
+	caughtUp chan struct{}
+}
+
+// contiguous returns true if the IDs of the messages of the topic are contiguous, so that a gap in them is detected:
+// the IDs are sequences of the first level of the topics, so the gaps of a subtopic are the messages of the others.
+func (t *topic) contiguous() bool {
+	return !strings.Contains(strings.Trim(string(t.path), "/"), "/")
+}
+
+// fill removes the ID of a received message from the missing gaps, and returns false if it was not missing.
+func (t *topic) fill(id uint64) bool {
+	for i, gap := range t.missing {
+		if id < gap.From || id > gap.To {
+			continue
+		}
+		var rest []Gap
+		if id > gap.From {
+			rest = append(rest, Gap{gap.Path, gap.From, id - 1})
+		}
+		if id < gap.To {
+			rest = append(rest, Gap{gap.Path, id + 1, gap.To})
+		}
+		t.missing = append(t.missing[:i], append(rest, t.missing[i+1:]...)...)
+		return true
+	}
+	return false
+}
+
+// SetGapDetection enables the tracking of the IDs of the received messages, by subscribed topic:
+// the messages received twice are dropped, the gaps in the IDs of a topic are fetched again one after the other
+// (keeping the subscription), and the topics are subscribed again after a reconnection from their last received ID.
+// The gaps which could not be filled are sent to the Gaps channel.
+// The gaps are only detected for the first level topics (e.g. `/foo`, but not `/foo/bar`).
+func (c *client) SetGapDetection(enabled bool) {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+	c.gapDetection = enabled
+}
+
+// LastID returns the highest ID of the messages received from the subscribed topic (0, if none).
+func (c *client) LastID(path string) uint64 {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+	if t, ok := c.topics[protocol.Path(path)]; ok {
+		return t.lastID
+	}
+	return 0
+}
+
+func (c *client) Gaps() chan Gap {
+	return c.gaps
+}
+
+// subscribed starts tracking the IDs of the messages of a topic.
+func (c *client) subscribed(path protocol.Path) error {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+	_, fetching := c.fetches[path]
+	_, subscribed := c.subscriptions[path]
+	if fetching || subscribed {
+		return ErrFetchPending
+	}
+	if _, ok := c.topics[path]; !ok {
+		c.topics[path] = &topic{path: path}
+	}
+	return nil
+}
+
+func (c *client) unsubscribed(path protocol.Path) {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+	delete(c.topics, path)
+}
+
+// topicOf returns the subscribed topic of a message (the most specific one), or nil.
+func (c *client) topicOf(path protocol.Path) *topic {
+	var found *topic
+	for p, t := range c.topics {
+		if path.InTopic(p) && (found == nil || len(p) > len(found.path)) {
+			found = t
+		}
+	}
+	return found
+}
+
+// track records the ID of a received message, and returns false if the message was already received.
+// A gap in the IDs is fetched again (only its range), unless another gap of the topic is being fetched.
+func (c *client) track(m *protocol.Message) bool {
+	c.topicsMu.Lock()
+	t := c.topicOf(m.Path)
+	if t == nil {
+		c.topicsMu.Unlock()
+		return true
+	}
+	if !c.gapDetection {
+		if m.ID > t.lastID {
+			t.lastID = m.ID
+		}
+		c.topicsMu.Unlock()
+		return true
+	}
+
+	var fill *Gap
+	switch {
+	case m.ID <= t.lastID:
+		if !t.fill(m.ID) {
+			c.topicsMu.Unlock()
+			return false
+		}
+	case t.lastID > 0 && m.ID > t.lastID+1 && t.contiguous():
+		gap := Gap{t.path, t.lastID + 1, m.ID - 1}
+		logger.WithField("gap", gap.String()).Warn("Missing messages, fetching them again")
+		t.missing = append(t.missing, gap)
+		if !t.filling {
+			t.filling, t.fillTo = true, gap.To
+			fill = &gap
+		}
+		t.lastID = m.ID
+	default:
+		t.lastID = m.ID
+	}
+	c.topicsMu.Unlock()
+
+	if fill != nil {
+		c.fetchGap(*fill)
+	}
+	return true
+}
+
+// fetchEnded signals the end of the fetch of a subscription with fetch, or of a gap,
+// reports the gaps of a topic which were not filled by fetching its messages again,
+// and fetches the next gap detected meanwhile, if any.
+func (c *client) fetchEnded(path protocol.Path) {
+	c.topicsMu.Lock()
+	t, ok := c.topics[path]
+	if !ok {
+		c.topicsMu.Unlock()
+		return
+	}
+	if t.caughtUp != nil {
+		close(t.caughtUp)
+		t.caughtUp = nil
+	}
+	if !t.filling {
+		c.topicsMu.Unlock()
+		return
+	}
+	var pending []Gap
+	for _, gap := range t.missing {
+		if t.fillTo > 0 && gap.From > t.fillTo {
+			pending = append(pending, gap)
+			continue
+		}
+		logger.WithField("gap", gap.String()).Error("Missing messages which could not be fetched again")
+		select {
+		case c.gaps <- gap:
+		default:
+		}
+	}
+	t.missing = pending
+	t.filling = false
+	var next *Gap
+	if len(pending) > 0 {
+		t.filling, t.fillTo = true, pending[0].To
+		next = &pending[0]
+	}
+	c.topicsMu.Unlock()
+
+	if next != nil {
+		c.fetchGap(*next)
+	}
+}
+
+// resubscribeAll subscribes again to the topics after a reconnection, from their last received ID.
+func (c *client) resubscribeAll() {
+	c.topicsMu.Lock()
+	if !c.gapDetection {
+		c.topicsMu.Unlock()
+		return
+	}
+	starts := make(map[protocol.Path]uint64, len(c.topics))
+	for path, t := range c.topics {
+		switch {
+		case len(t.missing) > 0:
+			// the gaps being fetched when the connection was lost are fetched again
+			starts[path] = t.missing[0].From
+			t.filling, t.fillTo = true, 0
+		case t.lastID > 0:
+			starts[path] = t.lastID + 1
+		default:
+			starts[path] = 0
+		}
+	}
+	c.topicsMu.Unlock()
+
+	for path, start := range starts {
+		arg := string(path)
+		if start > 0 {
+			arg = fmt.Sprintf("%s %d", path, start)
+		}
+		if err := c.writeCmd(protocol.CmdReceive, arg); err != nil {
+			logger.WithError(err).WithField("path", path).Error("Error subscribing again after a reconnection")
+		}
+	}
+}
+
+// fetchGap fetches the messages of a gap again (`+ <path> <start> <count>`), next to the subscription of the topic:
+// they are tracked like the other messages of the topic, and the end of the fetch is handled by fetchEnded.
+func (c *client) fetchGap(gap Gap) {
+	if err := c.writeCmd(protocol.CmdReceive, fmt.Sprintf("%s %d %d", gap.Path, gap.From, gap.To-gap.From+1)); err != nil {
+		logger.WithError(err).WithField("gap", gap.String()).Error("Error fetching the missing messages")
+	}
+}
+
+// resubscribe replaces the subscription of a topic by one fetching its messages from the given ID.
+func (c *client) resubscribe(path protocol.Path, from uint64) {
+	err := c.writeCmd(protocol.CmdCancel, string(path))
+	if err == nil {
+		err = c.writeCmd(protocol.CmdReceive, fmt.Sprintf("%s %d", path, from))
+	}
+	if err != nil {
+		logger.WithError(err).WithField("path", path).Error("Error fetching the missing messages")
+	}
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestGapDetection(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	c := New("url", "origin", 10, false).(*client)
+	connMock := NewMockWSConnection(ctrl)
+	c.ws = connMock
+	c.SetGapDetection(true)
+
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /foo"))
+	a.NoError(c.Subscribe("/foo"))
+
+	receive := func(ids ...uint64) {
+		for _, id := range ids {
+			c.handleIncomingMessage((&protocol.Message{ID: id, Path: "/foo/bar"}).Bytes())
+		}
+	}
+	received := func() (ids []uint64) {
+		for len(c.Messages()) > 0 {
+			ids = append(ids, (<-c.Messages()).ID)
+		}
+		return ids
+	}
+
+	// only the range of a gap is fetched again, keeping the subscription
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /foo 3 2"))
+	receive(1, 2, 5)
+	a.Equal([]uint64{1, 2, 5}, received())
+	a.Equal(uint64(5), c.LastID("/foo"))
+
+	// the messages received twice are dropped
+	receive(2, 3, 5, 6)
+	a.Equal([]uint64{3, 6}, received())
+
+	// a gap detected during the fetch of another one waits for its end
+	receive(9)
+	a.Equal([]uint64{9}, received())
+
+	// the gaps which were not filled are reported at the end of the fetch, and the next gap is fetched
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /foo 7 2"))
+	c.handleIncomingMessage([]byte("#" + protocol.SUCCESS_FETCH_END + " /foo"))
+	a.Equal(1, len(c.Gaps()))
+	a.Equal(Gap{"/foo", 4, 4}, <-c.Gaps())
+
+	receive(7)
+	a.Equal([]uint64{7}, received())
+	c.handleIncomingMessage([]byte("#" + protocol.SUCCESS_FETCH_END + " /foo"))
+	a.Equal(1, len(c.Gaps()))
+	a.Equal(Gap{"/foo", 8, 8}, <-c.Gaps())
+
+	// the topics are subscribed again from their last ID after a reconnection
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /foo 10"))
+	c.resubscribeAll()
+}
+
+func TestGapDetection_Disabled(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	c := New("url", "origin", 10, false).(*client)
+	connMock := NewMockWSConnection(ctrl)
+	c.ws = connMock
+
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /foo/bar"))
+	a.NoError(c.Subscribe("/foo/bar"))
+	for _, id := range []uint64{1, 5, 5} {
+		c.handleIncomingMessage((&protocol.Message{ID: id, Path: "/foo/bar"}).Bytes())
+	}
+	a.Equal(3, len(c.Messages()))
+	a.Equal(uint64(5), c.LastID("/foo/bar"))
+	a.Equal(uint64(0), c.LastID("/other"))
+	c.resubscribeAll()
+}
+
+func TestTopic_Fill(t *testing.T) {
+	a := assert.New(t)
+
+	tp := &topic{path: "/foo", missing: []Gap{{"/foo", 3, 6}, {"/foo", 9, 9}}}
+	a.True(tp.fill(4))
+	a.Equal([]Gap{{"/foo", 3, 3}, {"/foo", 5, 6}, {"/foo", 9, 9}}, tp.missing)
+	a.True(tp.fill(9))
+	a.True(tp.fill(3))
+	a.False(tp.fill(7))
+	a.Equal([]Gap{{"/foo", 5, 6}}, tp.missing)
+	a.True(tp.contiguous())
+	a.False((&topic{path: "/foo/bar"}).contiguous())
+}
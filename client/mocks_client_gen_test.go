@@ -101,6 +101,27 @@ func (_mr *_MockClientRecorder) Errors() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Errors")
 }
 
+func (_m *MockClient) Fetch(_param0 string, _param1 int64, _param2 int) (<-chan *protocol.Message, error) {
+	ret := _m.ctrl.Call(_m, "Fetch", _param0, _param1, _param2)
+	ret0, _ := ret[0].(<-chan *protocol.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockClientRecorder) Fetch(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Fetch", arg0, arg1, arg2)
+}
+
+func (_m *MockClient) Gaps() chan Gap {
+	ret := _m.ctrl.Call(_m, "Gaps")
+	ret0, _ := ret[0].(chan Gap)
+	return ret0
+}
+
+func (_mr *_MockClientRecorder) Gaps() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Gaps")
+}
+
 func (_m *MockClient) IsConnected() bool {
 	ret := _m.ctrl.Call(_m, "IsConnected")
 	ret0, _ := ret[0].(bool)
@@ -111,6 +132,16 @@ func (_mr *_MockClientRecorder) IsConnected() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsConnected")
 }
 
+func (_m *MockClient) LastID(_param0 string) uint64 {
+	ret := _m.ctrl.Call(_m, "LastID", _param0)
+	ret0, _ := ret[0].(uint64)
+	return ret0
+}
+
+func (_mr *_MockClientRecorder) LastID(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "LastID", arg0)
+}
+
 func (_m *MockClient) Messages() chan *protocol.Message {
 	ret := _m.ctrl.Call(_m, "Messages")
 	ret0, _ := ret[0].(chan *protocol.Message)
@@ -141,6 +172,14 @@ func (_mr *_MockClientRecorder) SendBytes(arg0, arg1, arg2 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SendBytes", arg0, arg1, arg2)
 }
 
+func (_m *MockClient) SetGapDetection(_param0 bool) {
+	_m.ctrl.Call(_m, "SetGapDetection", _param0)
+}
+
+func (_mr *_MockClientRecorder) SetGapDetection(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetGapDetection", arg0)
+}
+
 func (_m *MockClient) SetWSConnectionFactory(_param0 WSConnectionFactory) {
 	_m.ctrl.Call(_m, "SetWSConnectionFactory", _param0)
 }
@@ -179,6 +218,28 @@ func (_mr *_MockClientRecorder) Subscribe(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Subscribe", arg0)
 }
 
+func (_m *MockClient) SubscribeFunc(_param0 string, _param1 int, _param2 func(*protocol.Message)) (*Subscription, error) {
+	ret := _m.ctrl.Call(_m, "SubscribeFunc", _param0, _param1, _param2)
+	ret0, _ := ret[0].(*Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockClientRecorder) SubscribeFunc(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SubscribeFunc", arg0, arg1, arg2)
+}
+
+func (_m *MockClient) SubscribeWithFetch(_param0 string, _param1 uint64) (<-chan struct{}, error) {
+	ret := _m.ctrl.Call(_m, "SubscribeWithFetch", _param0, _param1)
+	ret0, _ := ret[0].(<-chan struct{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockClientRecorder) SubscribeWithFetch(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SubscribeWithFetch", arg0, arg1)
+}
+
 func (_m *MockClient) Unsubscribe(_param0 string) error {
 	ret := _m.ctrl.Call(_m, "Unsubscribe", _param0)
 	ret0, _ := ret[0].(error)
@@ -0,0 +1,254 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// Subscription is a subscription to a topic multiplexed over the connection of the client, whose messages are
+// passed to its handler by its own goroutine, in order: a slow handler does not hold back the other subscriptions.
+// When its buffer is full, the following messages are dropped, and fetched again from the store once the handler
+// caught up (by subscribing again from the first dropped message). It is subscribed again after a reconnection.
+type Subscription struct {
+	c       *client
+	path    protocol.Path
+	handler func(*protocol.Message)
+	queue   chan *protocol.Message
+	done    chan struct{}
+
+	mu sync.Mutex
+	// lastID is the ID of the last queued message
+	lastID uint64
+	// behind is set when a message was dropped, until the handler caught up
+	behind bool
+	// resuming is set while subscribing again, until the fetch of the dropped messages starts
+	resuming bool
+	closed   bool
+	// reason is the reason for which the server canceled the subscription, if it did
+	reason string
+}
+
+// SubscribeFunc subscribes to a topic, passing its messages to the handler instead of the Messages channel,
+// with a buffer of bufferSize messages waiting for the handler.
+func (c *client) SubscribeFunc(path string, bufferSize int, handler func(*protocol.Message)) (*Subscription, error) {
+	s := &Subscription{
+		c:       c,
+		path:    protocol.Path(path),
+		handler: handler,
+		queue:   make(chan *protocol.Message, bufferSize),
+		done:    make(chan struct{}),
+	}
+
+	c.topicsMu.Lock()
+	_, subscribed := c.subscriptions[s.path]
+	_, fetching := c.fetches[s.path]
+	_, tracked := c.topics[s.path]
+	if subscribed || fetching || tracked {
+		c.topicsMu.Unlock()
+		return nil, ErrFetchPending
+	}
+	c.subscriptions[s.path] = s
+	c.topicsMu.Unlock()
+
+	if err := c.writeCmd(protocol.CmdReceive, path); err != nil {
+		c.removeSubscription(s)
+		return nil, err
+	}
+	go s.loop()
+	return s, nil
+}
+
+// Path returns the topic of the subscription.
+func (s *Subscription) Path() string {
+	return string(s.path)
+}
+
+// Unsubscribe cancels the subscription, keeping the connection and the other subscriptions.
+// The messages waiting for the handler are dropped.
+func (s *Subscription) Unsubscribe() error {
+	if !s.close("") {
+		return nil
+	}
+	s.c.removeSubscription(s)
+	return s.c.writeCmd(protocol.CmdCancel, string(s.path))
+}
+
+// Done returns a channel which is closed when the subscription is canceled: by Unsubscribe, by closing the client,
+// or by the server (e.g. when the authentication expired), with the reason returned by Reason.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Reason returns the reason for which the server canceled the subscription (e.g. protocol.UnsubscribedAccessRevoked),
+// or an empty string.
+func (s *Subscription) Reason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reason
+}
+
+func (s *Subscription) close(reason string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.closed = true
+	s.reason = reason
+	close(s.done)
+	return true
+}
+
+// push queues a received message for the handler, without blocking.
+func (s *Subscription) push(m *protocol.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.behind || s.resuming || m.ID <= s.lastID {
+		return
+	}
+	select {
+	case s.queue <- m:
+		s.lastID = m.ID
+	default:
+		logger.WithField("path", s.path).WithField("id", m.ID).Warn("Subscription buffer full, fetching the messages again later")
+		s.behind = true
+	}
+}
+
+func (s *Subscription) loop() {
+	for {
+		select {
+		case m := <-s.queue:
+			s.handler(m)
+			continue
+		case <-s.done:
+			return
+		default:
+		}
+
+		// the handler caught up: the dropped messages are fetched again
+		s.catchUp()
+		select {
+		case m := <-s.queue:
+			s.handler(m)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Subscription) catchUp() {
+	s.mu.Lock()
+	if !s.behind || s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.behind = false
+	s.resuming = true
+	from := s.lastID + 1
+	s.mu.Unlock()
+
+	s.c.resubscribe(s.path, from)
+}
+
+// fetchStarted ends the resuming of the subscription: the messages received meanwhile were sent
+// by the replaced subscription of the server, and are fetched again.
+func (s *Subscription) fetchStarted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resuming = false
+}
+
+// resumeArg returns the argument of the receive command subscribing again after a reconnection.
+func (s *Subscription) resumeArg() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.behind = false
+	if s.lastID == 0 {
+		return string(s.path)
+	}
+	s.resuming = true
+	return fmt.Sprintf("%s %d", s.path, s.lastID+1)
+}
+
+// subscriptionOf returns the subscription of a message (the most specific one), or nil.
+func (c *client) subscriptionOf(path protocol.Path) *Subscription {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+	var found *Subscription
+	for p, s := range c.subscriptions {
+		if path.InTopic(p) && (found == nil || len(p) > len(found.path)) {
+			found = s
+		}
+	}
+	return found
+}
+
+func (c *client) removeSubscription(s *Subscription) {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+	if c.subscriptions[s.path] == s {
+		delete(c.subscriptions, s.path)
+	}
+}
+
+// subscriptionFetchStarted handles the start of a fetch (with the argument `<path> <count>`).
+func (c *client) subscriptionFetchStarted(arg string) {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return
+	}
+	c.topicsMu.Lock()
+	s := c.subscriptions[protocol.Path(fields[0])]
+	c.topicsMu.Unlock()
+	if s != nil {
+		s.fetchStarted()
+	}
+}
+
+// resubscribeSubscriptions subscribes again to the subscriptions after a reconnection.
+func (c *client) resubscribeSubscriptions() {
+	c.topicsMu.Lock()
+	subscriptions := make([]*Subscription, 0, len(c.subscriptions))
+	for _, s := range c.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	c.topicsMu.Unlock()
+
+	for _, s := range subscriptions {
+		if err := c.writeCmd(protocol.CmdReceive, s.resumeArg()); err != nil {
+			logger.WithError(err).WithField("path", s.path).Error("Error subscribing again after a reconnection")
+		}
+	}
+}
+
+// closeSubscriptions stops the goroutines of the subscriptions, when the client is closed.
+func (c *client) closeSubscriptions() {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+	for path, s := range c.subscriptions {
+		s.close("")
+		delete(c.subscriptions, path)
+	}
+}
+
+// canceled forgets a subscription canceled by the server (see protocol.ERROR_UNSUBSCRIBED),
+// so that it is not subscribed again after a reconnection.
+func (c *client) canceled(arg string) {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return
+	}
+	path := protocol.Path(fields[0])
+	c.unsubscribed(path)
+
+	c.topicsMu.Lock()
+	s := c.subscriptions[path]
+	c.topicsMu.Unlock()
+	if s != nil && s.close(strings.Join(fields[1:], " ")) {
+		c.removeSubscription(s)
+	}
+}
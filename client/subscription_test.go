@@ -0,0 +1,118 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestSubscribeFunc_IndependentFlowControl(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	c := New("url", "origin", 10, false).(*client)
+	connMock := NewMockWSConnection(ctrl)
+	c.ws = connMock
+
+	handled := make(chan uint64, 10)
+	release := make(chan struct{})
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /slow"))
+	slow, err := c.SubscribeFunc("/slow", 2, func(m *protocol.Message) {
+		handled <- m.ID
+		<-release
+	})
+	a.NoError(err)
+	a.Equal("/slow", slow.Path())
+
+	fastHandled := make(chan uint64, 10)
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /fast"))
+	fast, err := c.SubscribeFunc("/fast", 1, func(m *protocol.Message) {
+		fastHandled <- m.ID
+	})
+	a.NoError(err)
+
+	_, err = c.SubscribeFunc("/fast", 1, func(m *protocol.Message) {})
+	a.Equal(ErrFetchPending, err)
+
+	receive := func(path string, ids ...uint64) {
+		for _, id := range ids {
+			c.handleIncomingMessage((&protocol.Message{ID: id, Path: protocol.Path(path)}).Bytes())
+		}
+	}
+	receive("/slow", 1)
+	a.Equal(uint64(1), <-handled)
+
+	// the messages beyond the buffer of the slow subscription are dropped, without holding back the other one
+	receive("/slow", 2, 3, 4)
+	receive("/fast", 10)
+	select {
+	case id := <-fastHandled:
+		a.Equal(uint64(10), id)
+	case <-time.After(time.Second):
+		a.Fail("the fast subscription is held back")
+	}
+
+	// the dropped messages are fetched again once the handler caught up
+	resubscribed := make(chan struct{})
+	cancel := connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("- /slow"))
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /slow 4")).After(cancel).
+		Do(func(int, []byte) { close(resubscribed) })
+	close(release)
+	a.Equal(uint64(2), <-handled)
+	a.Equal(uint64(3), <-handled)
+	<-resubscribed
+
+	// the messages of the replaced subscription are dropped until the fetch starts
+	receive("/slow", 5)
+	c.handleIncomingMessage([]byte("#" + protocol.SUCCESS_FETCH_START + " /slow 2"))
+	receive("/slow", 4, 5)
+	a.Equal(uint64(4), <-handled)
+	a.Equal(uint64(5), <-handled)
+
+	// a subscription is canceled without closing the connection
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("- /fast"))
+	a.NoError(fast.Unsubscribe())
+	a.NoError(fast.Unsubscribe())
+	receive("/fast", 11)
+	a.Equal(1, len(c.Messages()))
+
+	// the subscriptions are subscribed again after a reconnection, from their last message
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /slow 6"))
+	c.resubscribeSubscriptions()
+	c.closeSubscriptions()
+}
+
+func TestSubscribeFunc_CanceledByServer(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	c := New("url", "origin", 10, false).(*client)
+	connMock := NewMockWSConnection(ctrl)
+	c.ws = connMock
+
+	connMock.EXPECT().WriteMessage(websocket.BinaryMessage, []byte("+ /foo"))
+	s, err := c.SubscribeFunc("/foo", 1, func(m *protocol.Message) {})
+	a.NoError(err)
+
+	c.handleIncomingMessage([]byte("!" + protocol.ERROR_UNSUBSCRIBED + " /foo " + protocol.UnsubscribedAccessRevoked))
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		a.Fail("the subscription is not canceled")
+	}
+	a.Equal(protocol.UnsubscribedAccessRevoked, s.Reason())
+
+	// the client is notified, and the subscription is not subscribed again after a reconnection
+	n := <-c.Errors()
+	a.Equal(protocol.ERROR_UNSUBSCRIBED, n.Name)
+	a.Equal("/foo access-revoked", n.Arg)
+	c.resubscribeSubscriptions()
+	a.Nil(c.subscriptionOf("/foo"))
+}
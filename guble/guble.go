@@ -0,0 +1,204 @@
+// Package guble embeds a guble server into another Go program.
+//
+// The embedding program publishes and subscribes through the Go API,
+// and can serve the websocket and REST APIs on its own http.ServeMux:
+//
+//	g, err := guble.New(guble.Config{StoragePath: "/var/lib/myapp/messages"})
+//	if err != nil {
+//		return err
+//	}
+//	if err := g.Start(); err != nil {
+//		return err
+//	}
+//	defer g.Stop()
+//
+//	g.Mount(mux, "/guble")
+//	s, err := g.Subscribe("/news")
+//	...
+//	g.Publish("/news", []byte("Hello"))
+package guble
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/xid"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/rest"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/store/dummystore"
+	"github.com/smancke/guble/server/store/filestore"
+	"github.com/smancke/guble/server/websocket"
+)
+
+const defaultChannelSize = 100
+
+// ErrNotStarted is returned when publishing or subscribing before Start.
+var ErrNotStarted = errors.New("Guble is not started.")
+
+// Config is the configuration of an embedded guble.
+// All the fields are optional.
+type Config struct {
+	// StoragePath is the existing directory of the message store.
+	// If empty, the messages are not persisted (and can not be fetched).
+	StoragePath string
+
+	// MessageStore replaces the message store in the StoragePath
+	MessageStore store.MessageStore
+
+	// KVStore stores the state of the server, e.g. the subscriptions of the connectors (default: in memory)
+	KVStore kvstore.KVStore
+
+	// AccessManager checks the permissions of the users (default: everything is allowed)
+	AccessManager auth.AccessManager
+
+	// HeaderLimits restricts the headers of the published messages
+	HeaderLimits router.HeaderLimits
+
+	// ChannelSize is the number of messages buffered by each subscription (default: 100)
+	ChannelSize int
+
+	// Origins are the origins allowed to connect a mounted websocket, as patterns (default: all the origins)
+	Origins []string
+}
+
+// Guble is an embedded guble server.
+type Guble struct {
+	config  Config
+	router  router.Router
+	service *service.Service
+	started int32
+}
+
+// New returns a new embedded guble, which has to be started before using it.
+func New(config Config) (*Guble, error) {
+	if config.KVStore == nil {
+		config.KVStore = kvstore.NewMemoryKVStore()
+	}
+	if config.MessageStore == nil {
+		if config.StoragePath == "" {
+			config.MessageStore = dummystore.New(config.KVStore)
+		} else {
+			if info, err := os.Stat(config.StoragePath); err != nil || !info.IsDir() {
+				return nil, fmt.Errorf("The storage path %q is no existing directory.", config.StoragePath)
+			}
+			config.MessageStore = filestore.New(config.StoragePath)
+		}
+	}
+	if config.AccessManager == nil {
+		config.AccessManager = auth.NewAllowAllAccessManager(true)
+	}
+	if config.ChannelSize <= 0 {
+		config.ChannelSize = defaultChannelSize
+	}
+
+	r := router.New(config.AccessManager, config.MessageStore, config.KVStore, nil)
+	r.(router.Interceptable).AddInterceptor("header-limits", config.HeaderLimits)
+
+	s := service.New(r, nil)
+	s.RegisterModules(0, 6, config.KVStore, config.MessageStore)
+
+	return &Guble{
+		config:  config,
+		router:  r,
+		service: s,
+	}, nil
+}
+
+// Start starts the stores and the router.
+func (g *Guble) Start() error {
+	if err := g.service.Start(); err != nil {
+		g.service.Stop()
+		return err
+	}
+	atomic.StoreInt32(&g.started, 1)
+	logger.Info("Started embedded guble")
+	return nil
+}
+
+// Stop stops the router and the stores, closing the channels of the open subscriptions.
+func (g *Guble) Stop() error {
+	atomic.StoreInt32(&g.started, 0)
+	logger.Info("Stopping embedded guble")
+	return g.service.Stop()
+}
+
+// Router returns the router, e.g. for adding interceptors or connectors.
+func (g *Guble) Router() router.Router {
+	return g.router
+}
+
+// Publish publishes a body on a topic.
+func (g *Guble) Publish(path protocol.Path, body []byte) error {
+	return g.PublishMessage(&protocol.Message{Path: path, Body: body})
+}
+
+// PublishMessage publishes a message, which gets its ID and publishing time from guble.
+func (g *Guble) PublishMessage(m *protocol.Message) error {
+	if !g.isStarted() {
+		return ErrNotStarted
+	}
+	return g.router.HandleMessage(m)
+}
+
+// Subscribe subscribes to a topic and its subtopics, on behalf of a user (which can be empty).
+func (g *Guble) Subscribe(path protocol.Path, userID string) (*Subscription, error) {
+	if !g.isStarted() {
+		return nil, ErrNotStarted
+	}
+	route, err := g.router.Subscribe(router.NewRoute(router.RouteConfig{
+		RouteParams: router.RouteParams{"application_id": xid.New().String(), "user_id": userID},
+		Path:        path,
+		ChannelSize: g.config.ChannelSize,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return &Subscription{route: route, router: g.router}, nil
+}
+
+// Mount serves the websocket API on `<prefix>/stream/` and the REST API on `<prefix>/api/` of the mux.
+func (g *Guble) Mount(mux *http.ServeMux, prefix string) error {
+	prefix = strings.TrimSuffix(prefix, "/")
+	wsHandler, err := websocket.NewWSHandler(g.router, prefix+"/stream/", websocket.Config{Origins: &g.config.Origins})
+	if err != nil {
+		return err
+	}
+	for _, e := range []service.Endpoint{wsHandler, rest.NewRestMessageAPI(g.router, prefix+"/api/")} {
+		logger.WithField("prefix", e.GetPrefix()).Info("Mounting endpoint")
+		mux.Handle(e.GetPrefix(), e)
+	}
+	return nil
+}
+
+func (g *Guble) isStarted() bool {
+	return atomic.LoadInt32(&g.started) == 1
+}
+
+// Subscription receives the messages of a topic.
+type Subscription struct {
+	route  *router.Route
+	router router.Router
+}
+
+// Messages returns the channel receiving the messages.
+// The channel is closed when the subscription is cancelled, or when its buffer is full,
+// because the messages are not received fast enough.
+func (s *Subscription) Messages() <-chan *protocol.Message {
+	return s.route.MessagesChannel()
+}
+
+// Cancel ends the subscription.
+func (s *Subscription) Cancel() {
+	s.router.Unsubscribe(s.route)
+	s.route.Close()
+}
@@ -0,0 +1,80 @@
+package guble
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuble_PublishAndSubscribe(t *testing.T) {
+	defer testutil.ResetDefaultRegistryHealthCheck()
+	a := assert.New(t)
+
+	g, err := New(Config{HeaderLimits: router.HeaderLimits{MaxKeys: 1}})
+	a.NoError(err)
+
+	_, err = g.Subscribe("/news", "marvin")
+	a.Equal(ErrNotStarted, err)
+	a.Equal(ErrNotStarted, g.Publish("/news", []byte("Hello")))
+
+	a.NoError(g.Start())
+	defer g.Stop()
+
+	s, err := g.Subscribe("/news", "marvin")
+	a.NoError(err)
+
+	a.NoError(g.Publish("/news/sport", []byte("Hello")))
+	a.Error(g.PublishMessage(&protocol.Message{Path: "/news", HeaderJSON: `{"a": 1, "b": 2}`}))
+	receive(a, s, "Hello")
+
+	// the APIs are served on the mux of the embedding program
+	mux := http.NewServeMux()
+	a.NoError(g.Mount(mux, "/guble/"))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/guble/api/message/news", "text/plain", bytes.NewBufferString("Hello REST"))
+	a.NoError(err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	a.Equal("OK", string(body))
+	m := receive(a, s, "Hello REST")
+	a.Equal("text/plain", m.ContentType)
+
+	s.Cancel()
+	a.NoError(g.Publish("/news", []byte("Nobody listens")))
+	_, open := <-s.Messages()
+	a.False(open)
+}
+
+func TestNew_InvalidStoragePath(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := New(Config{StoragePath: "/not/existing/directory"})
+	a.Error(err)
+
+	dir, err := ioutil.TempDir("", "guble_test")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	_, err = New(Config{StoragePath: dir})
+	a.NoError(err)
+}
+
+func receive(a *assert.Assertions, s *Subscription, body string) *protocol.Message {
+	select {
+	case m := <-s.Messages():
+		a.Equal(body, string(m.Body))
+		return m
+	case <-time.After(time.Second):
+		a.Fail("Message not received", body)
+		return nil
+	}
+}
@@ -0,0 +1,7 @@
+package guble
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "guble")
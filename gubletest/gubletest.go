@@ -0,0 +1,157 @@
+// Package gubletest runs an in-process guble server for the integration tests of the applications using guble:
+// the messages are kept in memory, and the websocket and REST APIs are served on a random local port.
+//
+//	s := gubletest.NewServer(t)
+//	defer s.Close()
+//
+//	sub := s.Subscribe("/news")
+//	resp, err := http.Post(s.URL+"/api/message/news", "text/plain", strings.NewReader("Hello"))
+//	...
+//	sub.AssertReceived("Hello")
+package gubletest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/client"
+	"github.com/smancke/guble/guble"
+	"github.com/smancke/guble/protocol"
+)
+
+// DefaultTimeout is the time during which a Subscriber waits for an expected message.
+var DefaultTimeout = time.Second
+
+// Server is an in-process guble server, embedded with the guble package.
+type Server struct {
+	*guble.Guble
+
+	// URL is the base URL of the REST API (`<URL>/api/`), e.g. "http://127.0.0.1:53123"
+	URL string
+
+	// WSURL is the base URL of the websockets (`<WSURL>user/<user ID>`), e.g. "ws://127.0.0.1:53123/stream/"
+	WSURL string
+
+	t          testing.TB
+	httpServer *httptest.Server
+}
+
+// NewServer starts a new server, failing the test if it can not be started.
+// The server has to be closed at the end of the test.
+func NewServer(t testing.TB) *Server {
+	return NewServerWithConfig(t, guble.Config{})
+}
+
+// NewServerWithConfig starts a new server with the configuration of the embedded guble
+// (e.g. with an AccessManager, or HeaderLimits), failing the test if it can not be started.
+func NewServerWithConfig(t testing.TB, config guble.Config) *Server {
+	g, err := guble.New(config)
+	if err != nil {
+		t.Fatalf("Could not create the guble server: %v", err)
+	}
+	if err := g.Start(); err != nil {
+		t.Fatalf("Could not start the guble server: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	if err := g.Mount(mux, "/"); err != nil {
+		g.Stop()
+		t.Fatalf("Could not mount the guble APIs: %v", err)
+	}
+	httpServer := httptest.NewServer(mux)
+
+	return &Server{
+		Guble:      g,
+		URL:        httpServer.URL,
+		WSURL:      "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/stream/",
+		t:          t,
+		httpServer: httpServer,
+	}
+}
+
+// Close stops the HTTP server and guble.
+func (s *Server) Close() {
+	s.httpServer.Close()
+	if err := s.Stop(); err != nil {
+		s.t.Errorf("Error stopping the guble server: %v", err)
+	}
+}
+
+// Publish publishes a body on a topic with the Go API, failing the test on error.
+func (s *Server) Publish(topic string, body string) {
+	if err := s.Guble.Publish(protocol.Path(topic), []byte(body)); err != nil {
+		s.t.Fatalf("Could not publish on %s: %v", topic, err)
+	}
+}
+
+// Subscribe subscribes to a topic (and its subtopics) with the Go API, failing the test on error.
+func (s *Server) Subscribe(topic string) *Subscriber {
+	sub, err := s.Guble.Subscribe(protocol.Path(topic), "")
+	if err != nil {
+		s.t.Fatalf("Could not subscribe to %s: %v", topic, err)
+	}
+	return &Subscriber{
+		Subscription: sub,
+		Timeout:      DefaultTimeout,
+		t:            s.t,
+		topic:        topic,
+	}
+}
+
+// NewClient connects a websocket client of the user, failing the test on error.
+// The client has to be closed at the end of the test.
+func (s *Server) NewClient(userID string) client.Client {
+	c, err := client.Open(s.WSURL+"user/"+userID, "http://localhost", 100, false)
+	if err != nil {
+		s.t.Fatalf("Could not connect the websocket of %s: %v", userID, err)
+	}
+	return c
+}
+
+// Subscriber receives the messages of a topic, and asserts their deliveries.
+type Subscriber struct {
+	*guble.Subscription
+
+	// Timeout is the time during which an expected message is waited for (default: DefaultTimeout)
+	Timeout time.Duration
+
+	t     testing.TB
+	topic string
+}
+
+// Receive returns the next message, failing the test if none is received within the timeout.
+func (s *Subscriber) Receive() *protocol.Message {
+	select {
+	case m, ok := <-s.Messages():
+		if !ok {
+			s.t.Fatalf("The subscription to %s was closed", s.topic)
+		}
+		return m
+	case <-time.After(s.Timeout):
+		s.t.Fatalf("No message received on %s within %v", s.topic, s.Timeout)
+		return nil
+	}
+}
+
+// AssertReceived asserts that the next messages have the given bodies, in order.
+func (s *Subscriber) AssertReceived(bodies ...string) {
+	for _, body := range bodies {
+		if m := s.Receive(); string(m.Body) != body {
+			s.t.Errorf("Expected the message %q on %s, but received %q", body, s.topic, m.Body)
+		}
+	}
+}
+
+// AssertNothingReceived asserts that no message is received during the given duration.
+func (s *Subscriber) AssertNothingReceived(d time.Duration) {
+	select {
+	case m, ok := <-s.Messages():
+		if ok {
+			s.t.Errorf("Expected no message on %s, but received %q", s.topic, m.Body)
+		}
+	case <-time.After(d):
+	}
+}
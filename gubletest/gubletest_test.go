@@ -0,0 +1,58 @@
+package gubletest
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_PublishAndAssertDeliveries(t *testing.T) {
+	a := assert.New(t)
+
+	s := NewServer(t)
+	defer s.Close()
+
+	sub := s.Subscribe("/news")
+	s.Publish("/news/sport", "Hello")
+	sub.AssertReceived("Hello")
+
+	// the REST API is served on a random port
+	resp, err := http.Post(s.URL+"/api/message/news", "text/plain", bytes.NewBufferString("Hello REST"))
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	sub.AssertReceived("Hello REST")
+
+	s.Publish("/other", "Not for news")
+	sub.AssertNothingReceived(20 * time.Millisecond)
+}
+
+func TestServer_WebsocketClient(t *testing.T) {
+	a := assert.New(t)
+
+	s := NewServer(t)
+	defer s.Close()
+
+	c := s.NewClient("marvin")
+	defer c.Close()
+
+	sub := s.Subscribe("/chat")
+	a.NoError(c.Send("/chat", "Hello from the websocket", ""))
+	sub.AssertReceived("Hello from the websocket")
+}
+
+func TestServers_AreIndependent(t *testing.T) {
+	a := assert.New(t)
+
+	first := NewServer(t)
+	defer first.Close()
+	second := NewServer(t)
+	defer second.Close()
+	a.NotEqual(first.URL, second.URL)
+
+	sub := second.Subscribe("/news")
+	first.Publish("/news", "Only on the first server")
+	sub.AssertNothingReceived(20 * time.Millisecond)
+}
@@ -11,6 +11,10 @@ const (
 	CmdSend    = ">"
 	CmdReceive = "+"
 	CmdCancel  = "-"
+	CmdAck     = "*"
+	CmdAuth    = "@"
+	CmdCredit  = "$"
+	CmdRead    = "^"
 )
 
 // Cmd is a representation of a command, which the client sends to the server
@@ -0,0 +1,142 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The content encodings of the compressed message bodies
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// Encodings are the supported content encodings, in the order of preference.
+var Encodings = []string{EncodingZstd, EncodingGzip}
+
+// AcceptEncodingHeader is the header by which a client connecting a websocket negotiates the compression
+// of the message bodies, with the comma separated list of the content encodings it decompresses (e.g. `gzip, zstd`).
+// The messages are sent with their bodies as stored otherwise, and a compressed body is marked by the content encoding
+// as the last field of the metadata: `/foo,42,user01,phone01,{},1420110000,0,application/json,gzip`.
+const AcceptEncodingHeader = "X-Guble-Accept-Encoding"
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// IsEncoding returns true if the content encoding is supported.
+func IsEncoding(encoding string) bool {
+	for _, e := range Encodings {
+		if e == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+// AcceptedEncodings returns the supported content encodings of the value of an AcceptEncodingHeader.
+func AcceptedEncodings(header string) []string {
+	var encodings []string
+	for _, e := range strings.Split(header, ",") {
+		// the quality values are ignored
+		if i := strings.IndexByte(e, ';'); i >= 0 {
+			e = e[:i]
+		}
+		if e = strings.ToLower(strings.TrimSpace(e)); IsEncoding(e) {
+			encodings = append(encodings, e)
+		}
+	}
+	return encodings
+}
+
+// Compress compresses the data with the content encoding.
+func Compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		buff := &bytes.Buffer{}
+		w := gzip.NewWriter(buff)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buff.Bytes(), nil
+	case EncodingZstd:
+		return zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+// Decompress decompresses the data compressed with the content encoding.
+func Decompress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case EncodingZstd:
+		return zstdDecoder.DecodeAll(data, nil)
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+// Compress serializes the message with its body compressed by the content encoding, and keeps this serialization,
+// which is returned by CompressedBytes from then on. The message itself keeps its uncompressed body.
+// As for Freeze, the message must not be changed after Compress.
+func (msg *Message) Compress(encoding string) ([]byte, error) {
+	body, err := Compress(encoding, msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	compressed := *msg
+	compressed.Body = body
+	compressed.encoding = encoding
+
+	msg.compressed = compressed.encode()
+	msg.compression = encoding
+	return msg.compressed, nil
+}
+
+// CompressedBytes returns the serialization of the message with its compressed body and the content encoding,
+// if the message was compressed, or else its serialization and an empty encoding.
+func (msg *Message) CompressedBytes() ([]byte, string) {
+	if msg.compressed != nil {
+		return msg.compressed, msg.compression
+	}
+	return msg.Bytes(), ""
+}
+
+// RawEncoding returns the content encoding of the body of a serialized message, or an empty string if not compressed,
+// without parsing the message.
+func RawEncoding(message []byte) string {
+	meta := message
+	if i := bytes.IndexByte(meta, '\n'); i >= 0 {
+		meta = meta[:i]
+	}
+	if i := bytes.LastIndexByte(meta, ','); i >= 0 && IsEncoding(string(meta[i+1:])) {
+		return string(meta[i+1:])
+	}
+	return ""
+}
+
+// Decompressed returns the serialization of a message with its body uncompressed.
+func Decompressed(message []byte) ([]byte, error) {
+	if RawEncoding(message) == "" {
+		return message, nil
+	}
+	msg, err := ParseMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage_Compress(t *testing.T) {
+	body := []byte(strings.Repeat(`{"name":"guble","value":42},`, 100))
+
+	for _, encoding := range Encodings {
+		t.Run(encoding, func(t *testing.T) {
+			a := assert.New(t)
+
+			msg := &Message{
+				ID:          42,
+				Path:        "/foo",
+				Time:        1420110000,
+				HeaderJSON:  `{"Correlation-Id":"7sdks723ksgqn"}`,
+				ContentType: ContentTypeJSON,
+				Body:        body,
+			}
+			compressed, err := msg.Compress(encoding)
+			a.NoError(err)
+			msg.Freeze()
+
+			a.True(len(compressed) < len(body))
+			a.Equal(encoding, RawEncoding(compressed))
+			a.Equal("/foo,42,,,,1420110000,0,application/json,"+encoding, strings.SplitN(string(compressed), "\n", 2)[0])
+
+			raw, e := msg.CompressedBytes()
+			a.Equal(compressed, raw)
+			a.Equal(encoding, e)
+
+			// the message itself keeps its uncompressed body
+			a.Equal(body, msg.Body)
+			a.Equal("", RawEncoding(msg.Bytes()))
+			a.Equal("/foo,42,,,,1420110000,0,application/json", msg.Metadata())
+
+			parsed, err := ParseMessage(compressed)
+			a.NoError(err)
+			a.Equal(body, parsed.Body)
+			a.Equal(ContentTypeJSON, parsed.ContentType)
+			a.Equal(msg.HeaderJSON, parsed.HeaderJSON)
+			a.Equal(msg.Bytes(), parsed.Bytes())
+
+			decompressed, err := Decompressed(compressed)
+			a.NoError(err)
+			a.Equal(msg.Bytes(), decompressed)
+		})
+	}
+}
+
+func TestMessage_CompressWithoutContentType(t *testing.T) {
+	a := assert.New(t)
+
+	msg := &Message{ID: 1, Path: "/foo", Body: []byte("Hello World")}
+	compressed, err := msg.Compress(EncodingGzip)
+	a.NoError(err)
+	a.Equal("/foo,1,,,,0,0,,gzip", strings.SplitN(string(compressed), "\n", 2)[0])
+
+	parsed, err := ParseMessage(compressed)
+	a.NoError(err)
+	a.Equal("", parsed.ContentType)
+	a.Equal("Hello World", string(parsed.Body))
+
+	raw, encoding := (&Message{ID: 2, Path: "/foo"}).CompressedBytes()
+	a.Equal("/foo,2,,,,0,0", string(raw))
+	a.Equal("", encoding)
+}
+
+func TestParseMessage_CorruptCompressedBody(t *testing.T) {
+	_, err := ParseMessage([]byte("/foo,1,,,,0,0,text/plain,gzip\n\nnot compressed"))
+	assert.Error(t, err)
+}
+
+func TestAcceptedEncodings(t *testing.T) {
+	a := assert.New(t)
+	a.Equal([]string{"gzip", "zstd"}, AcceptedEncodings("gzip, ZSTD;q=0.5, br"))
+	a.Nil(AcceptedEncodings(""))
+	a.Nil(AcceptedEncodings("deflate"))
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -36,17 +37,61 @@ type Message struct {
 	// The header line of the message (optional). If set, then it has to be a valid JSON object structure.
 	HeaderJSON string
 
+	// The content type of the body (optional), e.g. `application/json`, `application/x-protobuf` or `text/plain`
+	ContentType string
+
 	// The message payload
 	Body []byte
 
 	// Used in cluster mode to identify a guble node
 	NodeID uint8
+
+	// The time at which the router of this node received the message, for measuring its delivery latency (not serialized)
+	ReceivedAt time.Time
+
+	// The serialized message, shared by all the deliveries after Freeze
+	encoded []byte
+
+	// The content encoding of the body, while it is compressed
+	encoding string
+
+	// The serialization with the compressed body and its content encoding, after Compress
+	compressed  []byte
+	compression string
 }
 
 type MessageDeliveryCallback func(*Message)
 
+// Common content types of the message bodies
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeText     = "text/plain"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// MediaType returns the content type of the message in lower case, without its parameters (e.g. the charset).
+func (msg *Message) MediaType() string {
+	mediaType := msg.ContentType
+	if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+		mediaType = mediaType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+// IsJSON returns true if the content type of the message is JSON, or is not known.
+func (msg *Message) IsJSON() bool {
+	mediaType := msg.MediaType()
+	return mediaType == "" || mediaType == ContentTypeJSON || strings.HasSuffix(mediaType, "+json")
+}
+
 // Metadata returns the first line of a serialized message, without the newline
 func (msg *Message) Metadata() string {
+	if msg.encoded != nil {
+		if i := bytes.IndexByte(msg.encoded, '\n'); i >= 0 {
+			return string(msg.encoded[:i])
+		}
+		return string(msg.encoded)
+	}
 	buff := &bytes.Buffer{}
 	msg.writeMetadata(buff)
 	return string(buff.Bytes())
@@ -60,9 +105,25 @@ func (msg *Message) BodyAsString() string {
 	return string(msg.Body)
 }
 
-// Bytes serializes the message into a byte slice
+// Freeze serializes the message once: from then on, Bytes returns the same byte slice,
+// so that the message is not serialized again for each subscriber it is delivered to.
+// The message must not be changed after Freeze, and the returned bytes must not be modified.
+func (msg *Message) Freeze() {
+	msg.encoded = msg.encode()
+}
+
+// Bytes serializes the message into a byte slice.
+// For a frozen message, the shared serialization is returned.
 func (msg *Message) Bytes() []byte {
+	if msg.encoded != nil {
+		return msg.encoded
+	}
+	return msg.encode()
+}
+
+func (msg *Message) encode() []byte {
 	buff := &bytes.Buffer{}
+	buff.Grow(msg.encodedSizeHint())
 
 	msg.writeMetadata(buff)
 
@@ -82,6 +143,16 @@ func (msg *Message) Bytes() []byte {
 	return buff.Bytes()
 }
 
+// encodedSizeHint returns the approximate size of the serialized message, for allocating the buffer only once
+func (msg *Message) encodedSizeHint() int {
+	// the separators, the numbers and the filters are estimated
+	size := 64 + len(msg.Path) + len(msg.UserID) + len(msg.ApplicationID) + len(msg.ContentType) + len(msg.HeaderJSON) + len(msg.Body)
+	for k, v := range msg.Filters {
+		size += len(k) + len(v) + 6
+	}
+	return size
+}
+
 func (msg *Message) writeMetadata(buff *bytes.Buffer) {
 	buff.WriteString(string(msg.Path))
 	buff.WriteString(",")
@@ -96,6 +167,16 @@ func (msg *Message) writeMetadata(buff *bytes.Buffer) {
 	buff.WriteString(strconv.FormatInt(msg.Time, 10))
 	buff.WriteString(",")
 	buff.WriteString(strconv.FormatUint(uint64(msg.NodeID), 10))
+	// the content type is an optional last field, so that the messages without it keep their format
+	if msg.ContentType != "" || msg.encoding != "" {
+		buff.WriteString(",")
+		buff.WriteString(msg.ContentType)
+	}
+	// the content encoding of a compressed body follows, as last field
+	if msg.encoding != "" {
+		buff.WriteString(",")
+		buff.WriteString(msg.encoding)
+	}
 }
 
 func (msg *Message) encodeFilters() []byte {
@@ -136,9 +217,31 @@ const (
 	SUCCESS_FETCH_END     = "fetch-end"
 	SUCCESS_SUBSCRIBED_TO = "subscribed-to"
 	SUCCESS_CANCELED      = "canceled"
+	SUCCESS_AUTHENTICATED = "authenticated"
+	SUCCESS_READ          = "read"
 	ERROR_SUBSCRIBED_TO   = "error-subscribed-to"
 	ERROR_BAD_REQUEST     = "error-bad-request"
 	ERROR_INTERNAL_SERVER = "error-server-internal"
+	ERROR_AUTH_FAILED     = "error-auth-failed"
+	ERROR_AUTH_EXPIRED    = "error-auth-expired"
+	ERROR_DRAINING        = "error-server-draining"
+	ERROR_DISCONNECTED    = "error-disconnected"
+	ERROR_UNSUBSCRIBED    = "error-unsubscribed"
+)
+
+// The reasons for which the server cancels a subscription, following its path in the argument of
+// an ERROR_UNSUBSCRIBED notification (e.g. `!error-unsubscribed /foo access-revoked`)
+const (
+	// UnsubscribedExpired is the reason of the subscriptions canceled when the authentication expires
+	// (e.g. the signed URL with which the subscription was allowed)
+	UnsubscribedExpired = "expired"
+
+	// UnsubscribedAdmin is the reason of the subscriptions canceled by an admin
+	UnsubscribedAdmin = "admin"
+
+	// UnsubscribedAccessRevoked is the reason of the subscriptions canceled when the user is not allowed
+	// to read their topic anymore
+	UnsubscribedAccessRevoked = "access-revoked"
 )
 
 // NotificationMessage is a representation of a status messages or error message, sent from the server
@@ -195,10 +298,10 @@ func ParseMessage(message []byte) (*Message, error) {
 		return nil, fmt.Errorf("empty message")
 	}
 
-	meta := strings.Split(parts[0], ",")
+	meta := strings.SplitN(parts[0], ",", 8)
 
-	if len(meta) != 7 {
-		return nil, fmt.Errorf("message metadata has to have 7 fields, but was %v", parts[0])
+	if len(meta) < 7 {
+		return nil, fmt.Errorf("message metadata has to have 7 or 8 fields, but was %v", parts[0])
 	}
 
 	if len(meta[0]) == 0 || meta[0][0] != '/' {
@@ -228,6 +331,13 @@ func ParseMessage(message []byte) (*Message, error) {
 		Time:          publishingTime,
 		NodeID:        uint8(nodeID),
 	}
+	if len(meta) == 8 {
+		msg.ContentType = meta[7]
+		if i := strings.LastIndexByte(msg.ContentType, ','); i >= 0 && IsEncoding(msg.ContentType[i+1:]) {
+			msg.encoding = msg.ContentType[i+1:]
+			msg.ContentType = msg.ContentType[:i]
+		}
+	}
 	msg.decodeFilters([]byte(meta[4]))
 
 	if len(parts) >= 2 {
@@ -238,6 +348,16 @@ func ParseMessage(message []byte) (*Message, error) {
 		msg.Body = []byte(parts[2])
 	}
 
+	// a compressed body is decompressed transparently
+	if msg.encoding != "" {
+		body, err := Decompress(msg.encoding, msg.Body)
+		if err != nil {
+			return nil, fmt.Errorf("message body could not be decompressed (%s): %v", msg.encoding, err)
+		}
+		msg.Body = body
+		msg.encoding = ""
+	}
+
 	return msg, nil
 }
 
@@ -62,6 +62,26 @@ func TestSerializeANormalMessage(t *testing.T) {
 	assert.Equal(t, strings.SplitN(aNormalMessage, "\n", 2)[0], msg.Metadata())
 }
 
+func TestSerializeAFrozenMessage(t *testing.T) {
+	a := assert.New(t)
+
+	msg, err := ParseMessage([]byte(aNormalMessage))
+	a.NoError(err)
+
+	msg.Freeze()
+
+	// the serialisation is shared
+	data := msg.Bytes()
+	a.Equal(aNormalMessage, string(data))
+	a.True(&data[0] == &msg.Bytes()[0])
+
+	a.Equal(strings.SplitN(aNormalMessage, "\n", 2)[0], msg.Metadata())
+
+	minimal := &Message{ID: uint64(42), Path: Path("/"), Time: unixTime.Unix()}
+	minimal.Freeze()
+	a.Equal(aMinimalMessage, minimal.Metadata())
+}
+
 func TestSerializeAMinimalMessage(t *testing.T) {
 	msg := &Message{
 		ID:   uint64(42),
@@ -102,6 +122,35 @@ func TestParsingAMinimalMessage(t *testing.T) {
 	assert.Equal("", string(msg.Body))
 }
 
+func TestMessage_ContentType(t *testing.T) {
+	a := assert.New(t)
+
+	msg := &Message{
+		ID:          uint64(42),
+		Path:        Path("/foo"),
+		Time:        unixTime.Unix(),
+		ContentType: "Text/Plain; charset=utf-8",
+		Body:        []byte("Hello World"),
+	}
+	a.Equal("/foo,42,,,,1420110000,0,Text/Plain; charset=utf-8\n\nHello World", string(msg.Bytes()))
+	a.Equal(ContentTypeText, msg.MediaType())
+	a.False(msg.IsJSON())
+
+	parsed, err := ParseMessage(msg.Bytes())
+	a.NoError(err)
+	a.Equal(msg.ContentType, parsed.ContentType)
+	a.Equal("Hello World", string(parsed.Body))
+
+	// the messages without content type are unchanged
+	parsed, err = ParseMessage([]byte(aNormalMessage))
+	a.NoError(err)
+	a.Equal("", parsed.ContentType)
+	a.True(parsed.IsJSON())
+
+	a.True((&Message{ContentType: "application/vnd.api+json"}).IsJSON())
+	a.False((&Message{ContentType: ContentTypeProtobuf}).IsJSON())
+}
+
 func TestErrorsOnParsingMessages(t *testing.T) {
 	assert := assert.New(t)
 
@@ -16,3 +16,31 @@ func (path Path) Partition() string {
 func (path Path) RemovePrefixSlash() string {
 	return strings.TrimPrefix(string(path), "/")
 }
+
+// InTopic returns true if the path is the topic, or one of its subtopics.
+func (path Path) InTopic(topic Path) bool {
+	return path == topic || strings.HasPrefix(string(path), strings.TrimSuffix(string(topic), "/")+"/")
+}
+
+// UserTargetPrefix is the first level of the topics publishing a message to a user (e.g. `/user:alice`):
+// the message is delivered to all the subscriptions of the user, whatever their topic.
+const UserTargetPrefix = "user:"
+
+// UserPath returns the topic publishing a message to a user.
+func UserPath(userID string) Path {
+	return Path("/" + UserTargetPrefix + userID)
+}
+
+// TargetUser returns the user to whom the messages of the path are published,
+// if it is a user target (`user:<userID>`, or `/user:<userID>`).
+func (path Path) TargetUser() (string, bool) {
+	p := path.RemovePrefixSlash()
+	if !strings.HasPrefix(p, UserTargetPrefix) {
+		return "", false
+	}
+	userID := p[len(UserTargetPrefix):]
+	if userID == "" || strings.Contains(userID, "/") {
+		return "", false
+	}
+	return userID, true
+}
@@ -0,0 +1,33 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPath_TargetUser(t *testing.T) {
+	a := assert.New(t)
+
+	for _, path := range []Path{"user:alice", "/user:alice", UserPath("alice")} {
+		userID, ok := path.TargetUser()
+		a.True(ok, string(path))
+		a.Equal("alice", userID)
+	}
+	for _, path := range []Path{"/users/alice", "/user:", "/user:alice/devices", "/foo/user:alice"} {
+		_, ok := path.TargetUser()
+		a.False(ok, string(path))
+	}
+	a.Equal("user:alice", UserPath("alice").Partition())
+}
+
+func TestPath_InTopic(t *testing.T) {
+	a := assert.New(t)
+
+	a.True(Path("/foo").InTopic("/foo"))
+	a.True(Path("/foo/bar").InTopic("/foo"))
+	a.True(Path("/foo/bar").InTopic("/foo/"))
+	a.True(Path("/foo/bar").InTopic("/"))
+	a.False(Path("/foobar").InTopic("/foo"))
+	a.False(Path("/foo").InTopic("/foo/bar"))
+}
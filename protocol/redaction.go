@@ -0,0 +1,108 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+const (
+	// RedactHeader is the header flag of the messages whose bodies are redacted, whatever their topic:
+	// `{"Redact": true}`, i.e. `X-Guble-Redact: true` when publishing through the REST API.
+	RedactHeader = "Redact"
+
+	// RedactedBody replaces the redacted bodies.
+	RedactedBody = "[REDACTED]"
+)
+
+var (
+	redactionMu    sync.RWMutex
+	redactedTopics []string
+)
+
+// SetRedactedTopics sets the patterns of the topics whose message bodies are redacted in the logs and the admin views,
+// e.g. `/users/*` (a pattern matching a topic matches its subtopics as well).
+func SetRedactedTopics(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil || !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("Invalid redacted topic pattern %q", pattern)
+		}
+	}
+	redactionMu.Lock()
+	defer redactionMu.Unlock()
+	redactedTopics = patterns
+	return nil
+}
+
+// Redacted returns true if the body of the message must not appear in the logs and the admin views,
+// because of its topic or its header flag.
+func (msg *Message) Redacted() bool {
+	return isRedactedTopic(msg.Path) || isRedactedHeader(msg.HeaderJSON)
+}
+
+// RedactedBody returns the body of the message for the logs, masked if it is redacted.
+func (msg *Message) RedactedBody() string {
+	if msg.Redacted() {
+		return RedactedBody
+	}
+	return string(msg.Body)
+}
+
+// Redact returns the raw data for the logs: a serialized message with its body masked if it is redacted,
+// or any other data (e.g. a notification) unchanged.
+func Redact(raw []byte) string {
+	if len(raw) == 0 || raw[0] != '/' {
+		return string(raw)
+	}
+	msg, err := ParseMessage(raw)
+	if err != nil {
+		return RedactedBody
+	}
+	if !msg.Redacted() {
+		return string(raw)
+	}
+	return msg.Metadata() + "\n" + msg.HeaderJSON + "\n" + RedactedBody
+}
+
+func isRedactedTopic(topic Path) bool {
+	redactionMu.RLock()
+	defer redactionMu.RUnlock()
+
+	for _, pattern := range redactedTopics {
+		// the topic, and its parent topics
+		for p := string(topic); p != "" && p != "/"; p = p[:strings.LastIndex(p, "/")] {
+			if matched, _ := path.Match(pattern, p); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isRedactedHeader(headerJSON string) bool {
+	return headerFlag(headerJSON, RedactHeader)
+}
+
+// HeaderFlag returns true if the header of the message sets the flag with the given name (case-insensitive),
+// as JSON boolean or string, e.g. `{"Redact": true}` or `{"redact": "true"}`.
+func (msg *Message) HeaderFlag(name string) bool {
+	return headerFlag(msg.HeaderJSON, name)
+}
+
+func headerFlag(headerJSON string, name string) bool {
+	if !strings.Contains(strings.ToLower(headerJSON), strings.ToLower(name)) {
+		return false
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal([]byte(headerJSON), &header); err != nil {
+		return false
+	}
+	for key, value := range header {
+		if strings.EqualFold(key, name) && (value == true || value == "true") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetRedactedTopics_InvalidPattern(t *testing.T) {
+	a := assert.New(t)
+	defer SetRedactedTopics(nil)
+
+	a.Error(SetRedactedTopics([]string{"users/*"}))
+	a.Error(SetRedactedTopics([]string{"/users/["}))
+	a.NoError(SetRedactedTopics([]string{"/users/*", "/payments"}))
+}
+
+func TestMessage_Redacted(t *testing.T) {
+	a := assert.New(t)
+	defer SetRedactedTopics(nil)
+	a.NoError(SetRedactedTopics([]string{"/users/*", "/payments"}))
+
+	cases := []struct {
+		path       Path
+		headerJSON string
+		redacted   bool
+	}{
+		{"/news", "", false},
+		{"/users/alice", "", true},
+		{"/users/alice/inbox", "", true},
+		{"/users", "", false},
+		{"/payments", "", true},
+		{"/payments/42", "", true},
+		{"/news", `{"Redact": true}`, true},
+		{"/news", `{"redact": "true"}`, true},
+		{"/news", `{"Redact": false}`, false},
+		{"/news", `{"Redacted": true}`, false},
+	}
+	for _, c := range cases {
+		msg := &Message{Path: c.path, HeaderJSON: c.headerJSON, Body: []byte("secret")}
+		a.Equal(c.redacted, msg.Redacted(), "%s %s", c.path, c.headerJSON)
+		if c.redacted {
+			a.Equal(RedactedBody, msg.RedactedBody())
+		} else {
+			a.Equal("secret", msg.RedactedBody())
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	a := assert.New(t)
+	defer SetRedactedTopics(nil)
+
+	// not redacted
+	a.Equal(aNormalMessage, Redact([]byte(aNormalMessage)))
+	a.Equal(aConnectedNotification, Redact([]byte(aConnectedNotification)))
+
+	a.NoError(SetRedactedTopics([]string{"/foo"}))
+	redacted := Redact([]byte(aNormalMessage))
+	a.NotContains(redacted, "Hello World")
+	a.Contains(redacted, "/foo/bar,42,user01,phone01")
+	a.Contains(redacted, `"Correlation-Id": "7sdks723ksgqn"`)
+	a.Contains(redacted, RedactedBody)
+
+	// notifications are never redacted
+	a.Equal(aConnectedNotification, Redact([]byte(aConnectedNotification)))
+}
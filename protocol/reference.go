@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SupersedesHeader is the header field of a message updating an earlier message of its partition, given by its ID:
+	// `{"Supersedes": 42}`, i.e. `X-Guble-Supersedes: 42` when publishing through the REST API.
+	SupersedesHeader = "Supersedes"
+
+	// DeletesHeader is the header field of a message deleting an earlier message of its partition (a tombstone),
+	// given by its ID: `{"Deletes": 42}`, i.e. `X-Guble-Deletes: 42` when publishing through the REST API.
+	DeletesHeader = "Deletes"
+)
+
+// Reference returns the ID of the earlier message updated or deleted by the message, and true if it is deleted.
+// The ID is 0 if the message does not reference another one.
+// An error is returned if the referenced ID is no positive number, or if the message both updates and deletes.
+func (msg *Message) Reference() (uint64, bool, error) {
+	lower := strings.ToLower(msg.HeaderJSON)
+	if !strings.Contains(lower, strings.ToLower(SupersedesHeader)) && !strings.Contains(lower, strings.ToLower(DeletesHeader)) {
+		return 0, false, nil
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal([]byte(msg.HeaderJSON), &header); err != nil {
+		return 0, false, nil
+	}
+
+	var (
+		id      uint64
+		deleted bool
+	)
+	for key, value := range header {
+		isDelete := strings.EqualFold(key, DeletesHeader)
+		if !isDelete && !strings.EqualFold(key, SupersedesHeader) {
+			continue
+		}
+		if id != 0 {
+			return 0, false, fmt.Errorf("A message can only reference a single message, with %s or %s", SupersedesHeader, DeletesHeader)
+		}
+		referenced, err := referencedID(value)
+		if err != nil {
+			return 0, false, fmt.Errorf("%s has to be a message ID, but was %v", key, value)
+		}
+		id, deleted = referenced, isDelete
+	}
+	return id, deleted, nil
+}
+
+// referencedID parses a message ID given as JSON number or string.
+func referencedID(value interface{}) (uint64, error) {
+	var id uint64
+	switch v := value.(type) {
+	case float64:
+		if v < 1 || v != float64(uint64(v)) {
+			return 0, fmt.Errorf("invalid ID %v", v)
+		}
+		id = uint64(v)
+	case string:
+		parsed, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		id = parsed
+	default:
+		return 0, fmt.Errorf("invalid ID %v", v)
+	}
+	if id == 0 {
+		return 0, fmt.Errorf("invalid ID 0")
+	}
+	return id, nil
+}
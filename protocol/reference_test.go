@@ -0,0 +1,34 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage_Reference(t *testing.T) {
+	a := assert.New(t)
+
+	for header, expected := range map[string]struct {
+		id      uint64
+		deleted bool
+	}{
+		``:                          {0, false},
+		`{"Key": "Value"}`:          {0, false},
+		`{"Supersedes": 42}`:        {42, false},
+		`{"supersedes": "42"}`:      {42, false},
+		`{"Deletes": "7"}`:          {7, true},
+		`{"Deletes": 7, "Key": ""}`: {7, true},
+	} {
+		id, deleted, err := (&Message{HeaderJSON: header}).Reference()
+		a.NoError(err, header)
+		a.Equal(expected.id, id, header)
+		a.Equal(expected.deleted, deleted, header)
+	}
+
+	for _, header := range []string{`{"Supersedes": 0}`, `{"Supersedes": -1}`, `{"Deletes": "x"}`,
+		`{"Deletes": 1.5}`, `{"Supersedes": 1, "Deletes": 2}`} {
+		_, _, err := (&Message{HeaderJSON: header}).Reference()
+		a.Error(err, header)
+	}
+}
@@ -186,6 +186,12 @@ $MOCKGEN -package rest \
       github.com/smancke/guble/server/router \
       Router &
 
+# server/notify Mocks
+$MOCKGEN -package notify \
+      -destination server/notify/mocks_router_gen_test.go \
+      github.com/smancke/guble/server/router \
+      Router &
+
 # server/sms Mocks
 $MOCKGEN -package sms \
       -destination server/sms/mocks_sender_gen_test.go \
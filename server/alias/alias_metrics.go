@@ -0,0 +1,12 @@
+package alias
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns             = metrics.NS("alias")
+	mTotalResolved = ns.NewInt("total_resolved_paths")
+	mTotalCopied   = ns.NewInt("total_copied_messages")
+	mTotalAliases  = ns.NewInt("current_aliases")
+)
@@ -0,0 +1,7 @@
+package alias
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "alias")
@@ -0,0 +1,377 @@
+// Package alias maps the topics to other ones, so that the paths used by the deployed clients keep working
+// when the topics are reorganized.
+package alias
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store"
+)
+
+// kvSchema is the KVStore schema holding the targets of the aliases, keyed by alias path.
+const kvSchema = "topic_aliases"
+
+var (
+	errInvalidTarget = errors.New("The target has to be a topic path, other than the alias and its subtopics or parents.")
+	errChainedAlias  = errors.New("The target is an alias itself.")
+)
+
+// Alias is the mapping of a topic (and of its subtopics) to a target topic.
+type Alias struct {
+	Alias  protocol.Path `json:"alias"`
+	Target protocol.Path `json:"target"`
+}
+
+// Registry maps the topics of the published messages and of the subscriptions to the targets of their aliases:
+// an alias of a topic applies to its subtopics too (`/old/a` is mapped to `/new/a` by the alias of `/old` to `/new`),
+// unless they have an alias of their own. The messages delivered to the subscribers have the paths of the targets.
+// The aliases are managed by an admin endpoint:
+// `GET <prefix>` lists the aliases, `GET <prefix><topic>` returns the alias of the topic,
+// `PUT` (or `POST`) with `{"target": "/new/path"}` creates it, and `DELETE` removes it.
+// With `{"target": "/new/path", "rename": true}`, the topic is renamed: the messages stored in the topic
+// (and in its subtopics) are copied to the target, before the alias is created.
+type Registry struct {
+	router  router.Router
+	kvstore kvstore.KVStore
+	prefix  string
+
+	mu      sync.RWMutex
+	aliases map[protocol.Path]protocol.Path
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRegistry returns a new Registry, mapping the topics handled by the router.
+func NewRegistry(router router.Router, prefix string) (*Registry, error) {
+	kvs, err := router.KVStore()
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{
+		router:  router,
+		kvstore: kvs,
+		prefix:  prefix,
+		aliases: make(map[protocol.Path]protocol.Path),
+	}, nil
+}
+
+// Start loads the aliases, and begins mapping the topics.
+func (r *Registry) Start() error {
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	for entry := range r.kvstore.Iterate(r.ctx, kvSchema, "", 0) {
+		a, err := parse([]byte(entry[1]))
+		if err != nil {
+			logger.WithField("alias", entry[0]).WithError(err).Error("Ignoring invalid stored alias")
+			continue
+		}
+		r.set(protocol.Path(entry[0]), a.Target)
+	}
+
+	if w, ok := r.kvstore.(kvstore.Watcher); ok {
+		r.wg.Add(1)
+		go r.watch(w)
+	}
+
+	if res, ok := r.router.(router.Resolvable); ok {
+		res.SetResolver(r)
+	} else {
+		logger.Error("The router does not accept a resolver, the aliases will not be applied")
+	}
+	logger.WithField("aliases", len(r.aliases)).Info("Started alias registry")
+	return nil
+}
+
+// Stop stops watching the alias changes.
+func (r *Registry) Stop() error {
+	r.cancel()
+	r.wg.Wait()
+	logger.Info("Stopped alias registry")
+	return nil
+}
+
+// Resolve returns the path mapped by the alias of the topic, or of its nearest parent having one.
+// It is a part of the router.Resolver implementation.
+func (r *Registry) Resolve(path protocol.Path) protocol.Path {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.aliases) == 0 {
+		return path
+	}
+	topic := strings.TrimSuffix(string(path), "/")
+	for topic != "" {
+		if target, ok := r.aliases[protocol.Path(topic)]; ok {
+			mTotalResolved.Add(1)
+			return target + path[len(topic):]
+		}
+		topic = topic[:strings.LastIndex(topic, "/")]
+	}
+	return path
+}
+
+// watch applies the alias changes done by other guble nodes, until the registry is stopped.
+func (r *Registry) watch(w kvstore.Watcher) {
+	defer r.wg.Done()
+
+	changesC, err := w.Watch(r.ctx, kvSchema)
+	if err != nil {
+		logger.WithError(err).Info("Not watching the alias changes")
+		return
+	}
+	for change := range changesC {
+		topic := protocol.Path(change.Key)
+		if change.Deleted {
+			r.set(topic, "")
+			continue
+		}
+		data, exist, err := r.kvstore.Get(kvSchema, change.Key)
+		if err != nil || !exist {
+			continue
+		}
+		a, err := parse(data)
+		if err != nil {
+			logger.WithField("alias", topic).WithError(err).Error("Ignoring invalid alias change")
+			continue
+		}
+		r.set(topic, a.Target)
+	}
+}
+
+// set caches the target of an alias, or removes the alias if the target is empty.
+func (r *Registry) set(topic, target protocol.Path) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if target == "" {
+		delete(r.aliases, topic)
+	} else {
+		r.aliases[topic] = target
+	}
+	mTotalAliases.Set(int64(len(r.aliases)))
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (r *Registry) GetPrefix() string {
+	return r.prefix
+}
+
+// ServeHTTP manages the aliases of the topics.
+// It is a part of the service.endpoint implementation.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	topic := strings.Trim(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(r.prefix, "/")), "/")
+	if topic == "" {
+		if req.Method != http.MethodGet {
+			http.Error(w, `{"error": "Missing topic."}`, http.StatusBadRequest)
+			return
+		}
+		r.listAliases(w)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		r.getAlias(w, protocol.Path("/"+topic))
+	case http.MethodPut, http.MethodPost:
+		r.putAlias(w, req, protocol.Path("/"+topic))
+	case http.MethodDelete:
+		r.deleteAlias(w, protocol.Path("/"+topic))
+	default:
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET, PUT, POST and DELETE are accepted."}`, http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) listAliases(w http.ResponseWriter) {
+	r.mu.RLock()
+	aliases := make(map[protocol.Path]protocol.Path, len(r.aliases))
+	for topic, target := range r.aliases {
+		aliases[topic] = target
+	}
+	r.mu.RUnlock()
+
+	json.NewEncoder(w).Encode(aliases)
+}
+
+func (r *Registry) getAlias(w http.ResponseWriter, topic protocol.Path) {
+	r.mu.RLock()
+	target, exist := r.aliases[topic]
+	r.mu.RUnlock()
+
+	if !exist {
+		http.Error(w, `{"error": "Alias not found."}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(&Alias{Alias: topic, Target: target})
+}
+
+// putRequest is the body of the requests creating an alias.
+type putRequest struct {
+	Target protocol.Path `json:"target"`
+	Rename bool          `json:"rename"`
+}
+
+func (r *Registry) putAlias(w http.ResponseWriter, req *http.Request, topic protocol.Path) {
+	var put putRequest
+	if err := json.NewDecoder(req.Body).Decode(&put); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	put.Target = protocol.Path(strings.TrimSuffix(string(put.Target), "/"))
+	if err := r.validate(topic, put.Target); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	var copied int
+	if put.Rename {
+		var err error
+		if copied, err = r.copyHistory(topic, put.Target); err != nil {
+			logger.WithError(err).WithField("alias", topic).Error("Error copying the messages of a renamed topic")
+			body, _ := json.Marshal(map[string]interface{}{"error": err.Error(), "copied": copied})
+			http.Error(w, string(body), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data, _ := json.Marshal(&Alias{Alias: topic, Target: put.Target})
+	if err := r.kvstore.Put(kvSchema, string(topic), data); err != nil {
+		logger.WithError(err).WithField("alias", topic).Error("Error storing the alias")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	r.set(topic, put.Target)
+	logger.WithField("alias", topic).WithField("target", put.Target).WithField("copied", copied).Info("Created alias")
+
+	if put.Rename {
+		fmt.Fprintf(w, `{"alias": %q, "target": %q, "copied": %d}`, topic, put.Target, copied)
+		return
+	}
+	w.Write(data)
+}
+
+func (r *Registry) deleteAlias(w http.ResponseWriter, topic protocol.Path) {
+	if err := r.kvstore.Delete(kvSchema, string(topic)); err != nil {
+		logger.WithError(err).WithField("alias", topic).Error("Error deleting the alias")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	r.set(topic, "")
+	logger.WithField("alias", topic).Info("Removed alias")
+	fmt.Fprintf(w, `{"deleted": %q}`, topic)
+}
+
+// validate checks that the target is a topic outside of the alias, which is not an alias itself
+// (so that the aliases are resolved in a single step).
+func (r *Registry) validate(topic, target protocol.Path) error {
+	if !strings.HasPrefix(string(target), "/") || len(target) < 2 ||
+		target.InTopic(topic) || topic.InTopic(target) {
+		return errInvalidTarget
+	}
+	if r.Resolve(target) != target {
+		return errChainedAlias
+	}
+	return nil
+}
+
+// copyHistory copies the messages stored in the topic (and in its subtopics) to the target topic, in their order,
+// with new ids in the partition of the target, keeping their original times. It returns the number of copied messages.
+func (r *Registry) copyHistory(topic, target protocol.Path) (int, error) {
+	messageStore, err := r.router.MessageStore()
+	if err != nil {
+		return 0, err
+	}
+	partitions, err := messageStore.Partitions()
+	if err != nil {
+		return 0, err
+	}
+	var partition store.MessagePartition
+	for _, p := range partitions {
+		if p.Name() == topic.Partition() {
+			partition = p
+		}
+	}
+	if partition == nil {
+		return 0, nil
+	}
+
+	// the messages are read before copying them, as the target can be in the same partition
+	messages, err := fetchTopic(partition, topic)
+	if err != nil {
+		return 0, err
+	}
+
+	var nodeID uint8
+	if r.router.Cluster() != nil {
+		nodeID = r.router.Cluster().Config.ID
+	}
+	targetPartition := target.Partition()
+	for i, m := range messages {
+		id, _, err := messageStore.GenerateNextMsgID(targetPartition, nodeID)
+		if err != nil {
+			return i, err
+		}
+		m.ID = id
+		m.NodeID = nodeID
+		m.Path = target + m.Path[len(topic):]
+		if err := messageStore.Store(targetPartition, id, m.Bytes()); err != nil {
+			return i, err
+		}
+		mTotalCopied.Add(1)
+	}
+	return len(messages), nil
+}
+
+// fetchTopic returns the messages of a partition which are in the topic (or in its subtopics), in the order of their ids.
+func fetchTopic(partition store.MessagePartition, topic protocol.Path) ([]*protocol.Message, error) {
+	req := store.NewFetchRequest(partition.Name(), 0, 0, store.DirectionForward, math.MaxInt32)
+	req.Init()
+	partition.Fetch(req)
+
+	var messages []*protocol.Message
+	for {
+		select {
+		case <-req.StartC:
+		case fetched, open := <-req.MessageC:
+			if !open {
+				return messages, nil
+			}
+			m, err := protocol.ParseMessage(fetched.Message)
+			if err != nil {
+				logger.WithError(err).WithField("id", fetched.ID).Error("Skipping a stored message which cannot be parsed")
+				continue
+			}
+			if m.Path.InTopic(topic) {
+				messages = append(messages, m)
+			}
+		case err := <-req.ErrorC:
+			return messages, err
+		}
+	}
+}
+
+// parse decodes a stored alias.
+func parse(data []byte) (*Alias, error) {
+	var a Alias
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, err
+	}
+	if a.Target == "" {
+		return nil, errInvalidTarget
+	}
+	return &a, nil
+}
@@ -0,0 +1,140 @@
+package alias
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/dummystore"
+	"github.com/smancke/guble/server/store/filestore"
+)
+
+func TestRegistry_MapsTheAliasesToTheirTargets(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	a.NoError(kvs.Put(kvSchema, "/stored", []byte(`{"alias": "/stored", "target": "/restored"}`)))
+	r := router.New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil)
+	a.NoError(r.(service.Startable).Start())
+	defer r.(service.Stopable).Stop()
+
+	registry, err := NewRegistry(r, "/admin/aliases/")
+	a.NoError(err)
+	a.NoError(registry.Start())
+	defer registry.Stop()
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		registry.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodPut, "/admin/aliases/old", `{"target": "/new/"}`)
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"alias": "/old", "target": "/new"}`, w.Body.String())
+
+	// the targets inside the alias, and the chained aliases are refused
+	a.Equal(http.StatusBadRequest, serve(http.MethodPut, "/admin/aliases/other", `{"target": "/other/sub"}`).Code)
+	a.Equal(http.StatusBadRequest, serve(http.MethodPut, "/admin/aliases/other", `{"target": "/old/sub"}`).Code)
+	a.Equal(http.StatusBadRequest, serve(http.MethodPut, "/admin/aliases/other", `{"target": "no-path"}`).Code)
+
+	w = serve(http.MethodGet, "/admin/aliases/", "")
+	a.JSONEq(`{"/old": "/new", "/stored": "/restored"}`, w.Body.String())
+	w = serve(http.MethodGet, "/admin/aliases/old", "")
+	a.JSONEq(`{"alias": "/old", "target": "/new"}`, w.Body.String())
+
+	// the subtopics of an alias are mapped to the subtopics of its target
+	a.Equal(protocol.Path("/new/a"), registry.Resolve("/old/a"))
+	a.Equal(protocol.Path("/older/a"), registry.Resolve("/older/a"))
+
+	route := router.NewRoute(router.RouteConfig{
+		Path:        "/old/a",
+		RouteParams: router.RouteParams{"application_id": "app", "user_id": "user"},
+		ChannelSize: 10,
+	})
+	_, err = r.Subscribe(route)
+	a.NoError(err)
+	a.Equal(protocol.Path("/new/a"), route.Path)
+
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/old/a", Body: []byte("published to the alias")}))
+	select {
+	case m := <-route.MessagesChannel():
+		a.Equal(protocol.Path("/new/a"), m.Path)
+		a.Equal("published to the alias", string(m.Body))
+	case <-time.After(time.Second):
+		a.Fail("The message was not delivered")
+	}
+
+	w = serve(http.MethodDelete, "/admin/aliases/old", "")
+	a.JSONEq(`{"deleted": "/old"}`, w.Body.String())
+	a.Equal(protocol.Path("/old/a"), registry.Resolve("/old/a"))
+	a.Equal(http.StatusNotFound, serve(http.MethodGet, "/admin/aliases/old", "").Code)
+}
+
+func TestRegistry_RenameCopiesTheStoredMessages(t *testing.T) {
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_alias_test")
+	defer os.RemoveAll(dir)
+
+	kvs := kvstore.NewMemoryKVStore()
+	messageStore := filestore.New(dir)
+	defer messageStore.Stop()
+	r := router.New(auth.NewAllowAllAccessManager(true), messageStore, kvs, nil)
+	a.NoError(r.(service.Startable).Start())
+	defer r.(service.Stopable).Stop()
+
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/old/a", Body: []byte("first")}))
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/older", Body: []byte("other topic")}))
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/old/b", Body: []byte("second")}))
+
+	registry, err := NewRegistry(r, "/admin/aliases/")
+	a.NoError(err)
+	a.NoError(registry.Start())
+	defer registry.Stop()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/admin/aliases/old", strings.NewReader(`{"target": "/new", "rename": true}`))
+	registry.ServeHTTP(w, req)
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"alias": "/old", "target": "/new", "copied": 2}`, w.Body.String())
+
+	// the history is carried over, in its order
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/old/a", Body: []byte("third")}))
+	partition, err := messageStore.Partition("new")
+	a.NoError(err)
+	messages, err := fetchTopic(partition, "/new")
+	a.NoError(err)
+	var copied []string
+	for _, m := range messages {
+		copied = append(copied, string(m.Path)+":"+string(m.Body))
+	}
+	a.Equal([]string{"/new/a:first", "/new/b:second", "/new/a:third"}, copied)
+
+	// the original messages are kept
+	partition, err = messageStore.Partition("old")
+	a.NoError(err)
+	messages, err = fetchTopic(partition, "/old")
+	a.NoError(err)
+	a.Len(messages, 2)
+
+	var stored Alias
+	data, exist, err := kvs.Get(kvSchema, "/old")
+	a.True(exist)
+	a.NoError(err)
+	a.NoError(json.Unmarshal(data, &stored))
+	a.Equal(protocol.Path("/new"), stored.Target)
+}
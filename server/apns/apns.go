@@ -4,9 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/sideshow/apns2"
+	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/connector"
 	"github.com/smancke/guble/server/metrics"
 	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/throttle"
 	"time"
 )
 
@@ -30,6 +32,17 @@ type Config struct {
 	Workers             *int
 	Prefix              *string
 	IntervalMetrics     *bool
+	Collapse            *connector.CollapseWindows
+	HealthCheck         *time.Duration
+	MaxFailures         *int
+	Templates           connector.Renderer
+	BreakerFailures     *int
+	BreakerProbe        *time.Duration
+	Proxy               *string
+	Authenticator       auth.Authenticator
+	Limiter             *throttle.Limiter
+	Lazy                *bool
+	SharedRoutes        *bool
 }
 
 // apns is the private struct for handling the communication with APNS
@@ -40,6 +53,32 @@ type apns struct {
 
 // New creates a new connector.ResponsiveConnector without starting it
 func New(router router.Router, sender connector.Sender, config Config) (connector.ResponsiveConnector, error) {
+	var collapse connector.CollapseWindows
+	if config.Collapse != nil {
+		collapse = *config.Collapse
+	}
+	healthCheck := connector.HealthCheckConfig{TokenParam: deviceIDKey}
+	if config.HealthCheck != nil {
+		healthCheck.Interval = *config.HealthCheck
+	}
+	if config.MaxFailures != nil {
+		healthCheck.MaxFailures = *config.MaxFailures
+	}
+	var lazy bool
+	if config.Lazy != nil {
+		lazy = *config.Lazy
+	}
+	var sharedRoutes bool
+	if config.SharedRoutes != nil {
+		sharedRoutes = *config.SharedRoutes
+	}
+	var breaker connector.BreakerConfig
+	if config.BreakerFailures != nil {
+		breaker.Failures = *config.BreakerFailures
+	}
+	if config.BreakerProbe != nil {
+		breaker.Probe = *config.BreakerProbe
+	}
 	baseConn, err := connector.NewConnector(
 		router,
 		sender,
@@ -49,6 +88,32 @@ func New(router router.Router, sender connector.Sender, config Config) (connecto
 			Prefix:     *config.Prefix,
 			URLPattern: fmt.Sprintf("/{%s}/{%s}/{%s:.*}", deviceIDKey, userIDKey, connector.TopicParam),
 			Workers:    *config.Workers,
+			Collapse:   collapse,
+
+			// the devices with development or TestFlight builds are subscribed with ?environment=sandbox,
+			// and the devices are subscribed with their locale, in which the templated messages are rendered
+			QueryParams: []string{environmentKey, connector.LocaleParam},
+			Renderer:    config.Templates,
+
+			// the sending is paused while APNS is failing (the rejected pushes are not failures of APNS)
+			Breaker: breaker,
+
+			// APNS can not check a token without pushing, so the dead tokens are found by their failed pushes
+			HealthCheck: healthCheck,
+			Devices:     connector.DevicesConfig{UserParam: userIDKey, DeviceParam: deviceIDKey},
+
+			// the subscriptions can only be changed by their users (or admins), if the requests are authenticated
+			Authenticator: config.Authenticator,
+			Limiter:       config.Limiter,
+
+			// the delivered notifications are not pushed again after a restart
+			ProviderMessageID: providerMessageID,
+
+			// the routes of the idle devices are only created by their first notification
+			Lazy: lazy,
+
+			// the devices subscribed to a topic can share a single router route
+			SharedRoutes: sharedRoutes,
 		},
 	)
 	if err != nil {
@@ -81,6 +146,7 @@ func (a *apns) startMetrics() {
 	mTotalSendNetworkErrors.Set(0)
 	mTotalSendRetryCloseTLS.Set(0)
 	mTotalSendRetryUnrecoverable.Set(0)
+	mErrors.Reset()
 
 	if *a.IntervalMetrics {
 		a.startIntervalMetric(mMinute, time.Minute)
@@ -98,6 +164,7 @@ func (a *apns) HandleResponse(request connector.Request, responseIface interface
 	if errSend != nil {
 		logger.WithField("error", errSend.Error()).WithField("error_type", errSend).Error("error when trying to send APNS notification")
 		mTotalSendErrors.Add(1)
+		mErrors.Add(metrics.SendError)
 		if *a.IntervalMetrics && metadata != nil {
 			addToLatenciesAndCountsMaps(currentTotalErrorsLatenciesKey, currentTotalErrorsKey, metadata.Latency)
 		}
@@ -111,6 +178,12 @@ func (a *apns) HandleResponse(request connector.Request, responseIface interface
 	messageID := request.Message().ID
 	subscriber := request.Subscriber()
 	subscriber.SetLastID(messageID)
+	if r.Sent() {
+		subscriber.SetFailures(0)
+		subscriber.SetLastSuccess(time.Now())
+	} else {
+		subscriber.SetFailures(subscriber.Failures() + 1)
+	}
 	if err := a.Manager().Update(subscriber); err != nil {
 		logger.WithField("error", err.Error()).Error("Manager could not update subscription")
 		mTotalResponseInternalErrors.Add(1)
@@ -126,6 +199,7 @@ func (a *apns) HandleResponse(request connector.Request, responseIface interface
 	}
 	logger.Error("APNS notification was not sent")
 	logger.WithField("id", r.ApnsID).WithField("reason", r.Reason).Info("APNS notification was not sent - details")
+	mErrors.Add(r.Reason)
 	switch r.Reason {
 	case
 		apns2.ReasonMissingDeviceToken,
@@ -145,3 +219,12 @@ func (a *apns) HandleResponse(request connector.Request, responseIface interface
 	}
 	return nil
 }
+
+// providerMessageID returns the APNS ID of a delivered notification, or "" if it was not delivered.
+func providerMessageID(response interface{}) string {
+	r, ok := response.(*apns2.Response)
+	if !ok || !r.Sent() {
+		return ""
+	}
+	return r.ApnsID
+}
@@ -4,9 +4,11 @@ import (
 	"crypto/tls"
 	"github.com/sideshow/apns2"
 	"github.com/sideshow/apns2/certificate"
+	"github.com/smancke/guble/server/proxy"
 	"golang.org/x/net/http2"
 	"net"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 )
@@ -25,8 +27,10 @@ type closable interface {
 	CloseTLS()
 }
 
-func newPusher(c Config) (Pusher, error) {
-	logger.Info("creating new apns pusher")
+// newPushers returns the pushers of the production and of the sandbox environments, using the same certificate.
+// The connections to an environment are only opened when pushing to one of its devices.
+func newPushers(c Config) (map[string]Pusher, error) {
+	logger.Info("creating new apns pushers")
 
 	var (
 		cert    tls.Certificate
@@ -40,33 +44,39 @@ func newPusher(c Config) (Pusher, error) {
 	if errCert != nil {
 		return nil, errCert
 	}
-
-	var clientFactory func(certificate tls.Certificate) *apns2Client
-	if *c.Production {
-		clientFactory = newProductionClient
-	} else {
-		clientFactory = newDevelopmentClient
+	var proxyURL *url.URL
+	if c.Proxy != nil {
+		var err error
+		if proxyURL, err = proxy.Parse(*c.Proxy); err != nil {
+			return nil, err
+		}
+		if proxyURL != nil {
+			logger.WithField("proxy", proxy.Redacted(proxyURL)).Info("Connecting to APNS through a proxy")
+		}
 	}
 
 	apns2.TLSDialTimeout = tlsDialTimeout
 	apns2.HTTPClientTimeout = httpClientTimeout
 
-	logger.Info("created new apns pusher")
+	logger.Info("created new apns pushers")
 
-	return clientFactory(cert), nil
+	return map[string]Pusher{
+		EnvironmentProduction: newProductionClient(cert, proxyURL),
+		EnvironmentSandbox:    newDevelopmentClient(cert, proxyURL),
+	}, nil
 }
 
-func newProductionClient(certificate tls.Certificate) *apns2Client {
+func newProductionClient(certificate tls.Certificate, proxyURL *url.URL) *apns2Client {
 	logger.Info("APNS Pusher in Production mode")
-	c := newApns2Client(certificate)
+	c := newApns2Client(certificate, proxyURL)
 	c.Production()
 	logger.WithField("apns_url", c.Host).Info("APNS Pusher in Production mode url")
 	return c
 }
 
-func newDevelopmentClient(certificate tls.Certificate) *apns2Client {
+func newDevelopmentClient(certificate tls.Certificate, proxyURL *url.URL) *apns2Client {
 	logger.Info("APNS Pusher in Development mode")
-	c := newApns2Client(certificate)
+	c := newApns2Client(certificate, proxyURL)
 	c.Development()
 	logger.WithField("apns_url", c.Host).Info("APNS Pusher in Development mode url")
 	return c
@@ -79,7 +89,8 @@ type apns2Client struct {
 	mu      sync.Mutex
 }
 
-func newApns2Client(certificate tls.Certificate) *apns2Client {
+// newApns2Client returns a client connecting to APNS directly, or through the proxy if it is not nil.
+func newApns2Client(certificate tls.Certificate, proxyURL *url.URL) *apns2Client {
 	logger.Info("creating new apns2client")
 
 	c := &apns2Client{}
@@ -93,7 +104,7 @@ func newApns2Client(certificate tls.Certificate) *apns2Client {
 	transport := &http2.Transport{
 		TLSClientConfig: tlsConfig,
 		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
-			conn, err := tls.DialWithDialer(&net.Dialer{Timeout: tlsDialTimeout, KeepAlive: 2 * time.Second}, network, addr, cfg)
+			conn, err := dialTLS(proxyURL, network, addr, cfg)
 
 			c.mu.Lock()
 			defer c.mu.Unlock()
@@ -118,6 +129,30 @@ func newApns2Client(certificate tls.Certificate) *apns2Client {
 	return c
 }
 
+// dialTLS opens a TLS connection to APNS, directly or through the proxy.
+func dialTLS(proxyURL *url.URL, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	if proxyURL == nil {
+		return tls.DialWithDialer(&net.Dialer{Timeout: tlsDialTimeout, KeepAlive: 2 * time.Second}, network, addr, cfg)
+	}
+	conn, err := proxy.Dial(proxyURL, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ServerName == "" {
+		host, _, _ := net.SplitHostPort(addr)
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+	tlsConn := tls.Client(conn, cfg)
+	tlsConn.SetDeadline(time.Now().Add(tlsDialTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
 // interface closable used used by apns_sender
 func (c *apns2Client) CloseTLS() {
 	c.mu.Lock()
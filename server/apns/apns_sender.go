@@ -1,11 +1,16 @@
 package apns
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/jpillora/backoff"
 	"github.com/sideshow/apns2"
+	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/router"
 	"net"
+	"strings"
 	"time"
 )
 
@@ -13,46 +18,101 @@ const (
 	// deviceIDKey is the key name set on the route params to identify the application
 	deviceIDKey = "device_token"
 	userIDKey   = "user_id"
+
+	// environmentKey is the key name set on the route params to select the APNS environment of a device
+	environmentKey = "environment"
+
+	// EnvironmentProduction is the APNS environment of the devices with the apps from the App Store
+	EnvironmentProduction = "production"
+
+	// EnvironmentSandbox is the APNS environment of the devices with development or TestFlight builds
+	EnvironmentSandbox = "sandbox"
 )
 
 var (
 	errPusherInvalidParams = errors.New("Invalid parameters of APNS Pusher")
 	ErrRetryFailed         = errors.New("Retry failed")
+	ErrUnknownEnvironment  = errors.New("Unknown APNS environment of the subscription.")
 )
 
 type sender struct {
-	client   Pusher
+	// pushers are the pushers of the APNS environments
+	pushers map[string]Pusher
+
+	// environment is the APNS environment of the subscriptions which do not select one
+	environment string
+
 	appTopic string
 }
 
+// NewSender returns a sender maintaining the clients of both APNS environments:
+// each notification is pushed to the environment of the device subscription,
+// or to the production environment (if configured) or the sandbox otherwise.
 func NewSender(config Config) (connector.Sender, error) {
-	pusher, err := newPusher(config)
+	pushers, err := newPushers(config)
 	if err != nil {
 		logger.WithField("error", err.Error()).Error("APNS Pusher creation error")
 		return nil, err
 	}
-	return NewSenderUsingPusher(pusher, *config.AppTopic)
+	environment := EnvironmentSandbox
+	if config.Production != nil && *config.Production {
+		environment = EnvironmentProduction
+	}
+	return NewSenderUsingPushers(pushers[EnvironmentProduction], pushers[EnvironmentSandbox], environment, *config.AppTopic)
 }
 
+// NewSenderUsingPusher returns a sender pushing all the notifications with the given pusher.
 func NewSenderUsingPusher(pusher Pusher, appTopic string) (connector.Sender, error) {
-	if pusher == nil || appTopic == "" {
+	return NewSenderUsingPushers(pusher, pusher, EnvironmentProduction, appTopic)
+}
+
+// NewSenderUsingPushers returns a sender pushing the notifications with the pusher of the environment of each subscription,
+// and with the pusher of the given default environment for the subscriptions without one.
+func NewSenderUsingPushers(production, sandbox Pusher, environment string, appTopic string) (connector.Sender, error) {
+	if production == nil || sandbox == nil || appTopic == "" {
 		return nil, errPusherInvalidParams
 	}
+	if environment != EnvironmentProduction && environment != EnvironmentSandbox {
+		return nil, ErrUnknownEnvironment
+	}
 	return &sender{
-		client:   pusher,
-		appTopic: appTopic,
+		pushers: map[string]Pusher{
+			EnvironmentProduction: production,
+			EnvironmentSandbox:    sandbox,
+		},
+		environment: environment,
+		appTopic:    appTopic,
 	}, nil
 }
 
+// pusher returns the pusher of the APNS environment of a subscription.
+func (s sender) pusher(route *router.Route) (Pusher, error) {
+	environment := route.Get(environmentKey)
+	if environment == "" {
+		environment = s.environment
+	}
+	pusher, ok := s.pushers[environment]
+	if !ok {
+		return nil, ErrUnknownEnvironment
+	}
+	return pusher, nil
+}
+
 func (s sender) Send(request connector.Request) (interface{}, error) {
-	deviceToken := request.Subscriber().Route().Get(deviceIDKey)
+	route := request.Subscriber().Route()
+	deviceToken := route.Get(deviceIDKey)
+	client, err := s.pusher(route)
+	if err != nil {
+		logger.WithField("deviceToken", deviceToken).WithField("environment", route.Get(environmentKey)).Error("Unknown APNS environment")
+		return nil, err
+	}
 	logger.WithField("deviceToken", deviceToken).Info("Trying to push a message to APNS")
 	push := func() (interface{}, error) {
-		return s.client.Push(&apns2.Notification{
+		return client.Push(&apns2.Notification{
 			Priority:    apns2.PriorityHigh,
 			Topic:       s.appTopic,
 			DeviceToken: deviceToken,
-			Payload:     request.Message().Body,
+			Payload:     payload(request.Message()),
 		})
 	}
 	withRetry := &retryable{
@@ -66,7 +126,7 @@ func (s sender) Send(request connector.Request) (interface{}, error) {
 	}
 	result, err := withRetry.execute(push)
 	if err != nil && err == ErrRetryFailed {
-		if closable, ok := s.client.(closable); ok {
+		if closable, ok := client.(closable); ok {
 			logger.Warn("Close TLS and retry again")
 			mTotalSendRetryCloseTLS.Add(1)
 			closable.CloseTLS()
@@ -79,6 +139,18 @@ func (s sender) Send(request connector.Request) (interface{}, error) {
 	return result, err
 }
 
+// Summary returns the notification delivered after the quiet hours of a subscription,
+// in place of the messages received during the window.
+// It is a part of the connector.Summarizer implementation.
+func (s sender) Summary(last *protocol.Message, count int) *protocol.Message {
+	return &protocol.Message{
+		ID:   last.ID,
+		Path: last.Path,
+		Time: last.Time,
+		Body: []byte(fmt.Sprintf(`{"aps":{"alert":"%d new notifications"},"quiet_hours_summary":%d}`, count, count)),
+	}
+}
+
 type retryable struct {
 	backoff.Backoff
 	maxTries int
@@ -104,3 +176,25 @@ func (r *retryable) execute(op func() (interface{}, error)) (interface{}, error)
 		}
 	}
 }
+
+// payload returns the APNS payload of a message: the JSON bodies are pushed as they are,
+// a text is pushed as alert, and the bodies of the other content types are passed in a silent notification.
+func payload(m *protocol.Message) []byte {
+	if m.IsJSON() {
+		return m.Body
+	}
+	var p interface{}
+	if strings.HasPrefix(m.MediaType(), "text/") {
+		p = map[string]interface{}{
+			"aps": map[string]interface{}{"alert": string(m.Body)},
+		}
+	} else {
+		p = map[string]interface{}{
+			"aps":          map[string]interface{}{"content-available": 1},
+			"message":      m.Body,
+			"content_type": m.ContentType,
+		}
+	}
+	data, _ := json.Marshal(p)
+	return data
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/connector"
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/testutil"
 	"github.com/stretchr/testify/assert"
@@ -88,6 +89,58 @@ func TestSender_Send(t *testing.T) {
 	a.Nil(rsp)
 }
 
+func TestSender_SendToEnvironmentOfSubscription(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	production := NewMockPusher(testutil.MockCtrl)
+	sandbox := NewMockPusher(testutil.MockCtrl)
+	s, err := NewSenderUsingPushers(production, sandbox, EnvironmentProduction, "com.myapp")
+	a.NoError(err)
+
+	request := func(environment string) connector.Request {
+		routeParams := router.RouteParams{deviceIDKey: "1234"}
+		if environment != "" {
+			routeParams[environmentKey] = environment
+		}
+		route := router.NewRoute(router.RouteConfig{Path: protocol.Path("path"), RouteParams: routeParams})
+
+		mSubscriber := NewMockSubscriber(testutil.MockCtrl)
+		mSubscriber.EXPECT().Route().Return(route).AnyTimes()
+		mRequest := NewMockRequest(testutil.MockCtrl)
+		mRequest.EXPECT().Subscriber().Return(mSubscriber).AnyTimes()
+		mRequest.EXPECT().Message().Return(&protocol.Message{Body: []byte("{}")}).AnyTimes()
+		return mRequest
+	}
+
+	// the subscriptions without an environment are pushed to the default one
+	production.EXPECT().Push(gomock.Any()).Return(nil, nil).Times(2)
+	sandbox.EXPECT().Push(gomock.Any()).Return(nil, nil)
+
+	_, err = s.Send(request(""))
+	a.NoError(err)
+	_, err = s.Send(request(EnvironmentProduction))
+	a.NoError(err)
+	_, err = s.Send(request(EnvironmentSandbox))
+	a.NoError(err)
+
+	_, err = s.Send(request("staging"))
+	a.Equal(ErrUnknownEnvironment, err)
+
+	_, err = NewSenderUsingPushers(production, sandbox, "staging", "com.myapp")
+	a.Equal(ErrUnknownEnvironment, err)
+}
+
+func Test_payload(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(`{"aps":{"alert":"Hello"}}`, string(payload(&protocol.Message{Body: []byte(`{"aps":{"alert":"Hello"}}`)})))
+	a.Equal(`{"aps":{"alert":"Hello"}}`, string(payload(&protocol.Message{ContentType: "text/plain", Body: []byte("Hello")})))
+	a.JSONEq(`{"aps":{"content-available":1},"message":"CCo=","content_type":"application/x-protobuf"}`,
+		string(payload(&protocol.Message{ContentType: protocol.ContentTypeProtobuf, Body: []byte{8, 42}})))
+}
+
 func TestSender_Retry(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
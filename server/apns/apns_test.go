@@ -2,10 +2,13 @@ package apns
 
 import (
 	"errors"
+	"expvar"
+	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/sideshow/apns2"
 	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/kvstore"
 	"github.com/smancke/guble/testutil"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -64,9 +67,12 @@ func TestConn_HandleResponse(t *testing.T) {
 
 	mSubscriber := NewMockSubscriber(testutil.MockCtrl)
 	mSubscriber.EXPECT().SetLastID(gomock.Any())
+	mSubscriber.EXPECT().SetFailures(0)
+	mSubscriber.EXPECT().SetLastSuccess(gomock.Any())
 	mSubscriber.EXPECT().Key().Return("key").AnyTimes()
 	mSubscriber.EXPECT().Encode().Return([]byte("{}"), nil).AnyTimes()
-	mKVS.EXPECT().Put(schema, "key", []byte("{}")).Times(2)
+	mKVS.EXPECT().Batch(schema, []kvstore.Operation{{Key: "key", Value: []byte("{}")}})
+	mKVS.EXPECT().Put(schema, "key", []byte("{}"))
 
 	c.Manager().Add(mSubscriber)
 
@@ -109,10 +115,13 @@ func TestNew_HandleResponseHandleSubscriber(t *testing.T) {
 		}
 		mSubscriber := NewMockSubscriber(testutil.MockCtrl)
 		mSubscriber.EXPECT().SetLastID(gomock.Any())
+		mSubscriber.EXPECT().Failures().Return(2)
+		mSubscriber.EXPECT().SetFailures(3)
 		mSubscriber.EXPECT().Cancel()
 		mSubscriber.EXPECT().Key().Return("key").AnyTimes()
 		mSubscriber.EXPECT().Encode().Return([]byte("{}"), nil).AnyTimes()
-		mKVS.EXPECT().Put(schema, "key", []byte("{}")).Times(2)
+		mKVS.EXPECT().Batch(schema, []kvstore.Operation{{Key: "key", Value: []byte("{}")}})
+		mKVS.EXPECT().Put(schema, "key", []byte("{}"))
 		mKVS.EXPECT().Delete(schema, "key")
 
 		c.Manager().Add(mSubscriber)
@@ -132,6 +141,7 @@ func TestNew_HandleResponseHandleSubscriber(t *testing.T) {
 
 		//then
 		a.NoError(err)
+		a.Contains(mErrors.(expvar.Var).String(), fmt.Sprintf(`"%s": {"total": `, reason))
 	}
 }
 
@@ -172,10 +182,13 @@ func TestNew_HandleResponseDoNotHandleSubscriber(t *testing.T) {
 
 		mSubscriber := NewMockSubscriber(testutil.MockCtrl)
 		mSubscriber.EXPECT().SetLastID(gomock.Any())
+		mSubscriber.EXPECT().Failures().Return(2)
+		mSubscriber.EXPECT().SetFailures(3)
 		mSubscriber.EXPECT().Key().Return("key").AnyTimes()
 		mSubscriber.EXPECT().Encode().Return([]byte("{}"), nil).AnyTimes()
 		mSubscriber.EXPECT().Cancel()
-		mKVS.EXPECT().Put(schema, "key", []byte("{}")).Times(2)
+		mKVS.EXPECT().Batch(schema, []kvstore.Operation{{Key: "key", Value: []byte("{}")}})
+		mKVS.EXPECT().Put(schema, "key", []byte("{}"))
 		mKVS.EXPECT().Delete(schema, "key")
 
 		c.Manager().Add(mSubscriber)
@@ -9,6 +9,7 @@ import (
 	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/connector"
 	"github.com/smancke/guble/server/router"
+	"time"
 )
 
 // Mock of Sender interface
@@ -124,6 +125,16 @@ func (_mr *_MockSubscriberRecorder) Encode() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Encode")
 }
 
+func (_m *MockSubscriber) Failures() int {
+	ret := _m.ctrl.Call(_m, "Failures")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+func (_mr *_MockSubscriberRecorder) Failures() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Failures")
+}
+
 func (_m *MockSubscriber) Filter(_param0 map[string]string) bool {
 	ret := _m.ctrl.Call(_m, "Filter", _param0)
 	ret0, _ := ret[0].(bool)
@@ -144,6 +155,26 @@ func (_mr *_MockSubscriberRecorder) Key() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Key")
 }
 
+func (_m *MockSubscriber) LastID() uint64 {
+	ret := _m.ctrl.Call(_m, "LastID")
+	ret0, _ := ret[0].(uint64)
+	return ret0
+}
+
+func (_mr *_MockSubscriberRecorder) LastID() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "LastID")
+}
+
+func (_m *MockSubscriber) LastSuccess() time.Time {
+	ret := _m.ctrl.Call(_m, "LastSuccess")
+	ret0, _ := ret[0].(time.Time)
+	return ret0
+}
+
+func (_mr *_MockSubscriberRecorder) LastSuccess() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "LastSuccess")
+}
+
 func (_m *MockSubscriber) Loop(_param0 context.Context, _param1 connector.Queue) error {
 	ret := _m.ctrl.Call(_m, "Loop", _param0, _param1)
 	ret0, _ := ret[0].(error)
@@ -154,6 +185,16 @@ func (_mr *_MockSubscriberRecorder) Loop(arg0, arg1 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Loop", arg0, arg1)
 }
 
+func (_m *MockSubscriber) QuietHours() *connector.QuietHours {
+	ret := _m.ctrl.Call(_m, "QuietHours")
+	ret0, _ := ret[0].(*connector.QuietHours)
+	return ret0
+}
+
+func (_mr *_MockSubscriberRecorder) QuietHours() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "QuietHours")
+}
+
 func (_m *MockSubscriber) Reset() error {
 	ret := _m.ctrl.Call(_m, "Reset")
 	ret0, _ := ret[0].(error)
@@ -174,6 +215,22 @@ func (_mr *_MockSubscriberRecorder) Route() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Route")
 }
 
+func (_m *MockSubscriber) SetFailures(_param0 int) {
+	_m.ctrl.Call(_m, "SetFailures", _param0)
+}
+
+func (_mr *_MockSubscriberRecorder) SetFailures(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetFailures", arg0)
+}
+
+func (_m *MockSubscriber) SetLastSuccess(_param0 time.Time) {
+	_m.ctrl.Call(_m, "SetLastSuccess", _param0)
+}
+
+func (_mr *_MockSubscriberRecorder) SetLastSuccess(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetLastSuccess", arg0)
+}
+
 func (_m *MockSubscriber) SetLastID(_param0 uint64) {
 	_m.ctrl.Call(_m, "SetLastID", _param0)
 }
@@ -181,3 +238,11 @@ func (_m *MockSubscriber) SetLastID(_param0 uint64) {
 func (_mr *_MockSubscriberRecorder) SetLastID(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetLastID", arg0)
 }
+
+func (_m *MockSubscriber) SetQuietHours(_param0 *connector.QuietHours) {
+	_m.ctrl.Call(_m, "SetQuietHours", _param0)
+}
+
+func (_mr *_MockSubscriberRecorder) SetQuietHours(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetQuietHours", arg0)
+}
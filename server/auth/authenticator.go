@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// AdminScope is the scope of the identities allowed to act on behalf of all the users.
+	AdminScope = "admin"
+
+	// BackendScope is the scope of the backends of the applications (e.g. allowed to request the websocket tickets).
+	BackendScope = "backend"
+)
+
+var ErrInvalidToken = errors.New("Invalid or expired token.")
+
+// Identity is the identity of an authenticated user.
+type Identity struct {
+	UserID string `json:"user_id"`
+
+	// Expires is the time at which the authentication expires (zero, if never)
+	Expires time.Time `json:"expires"`
+
+	// Scopes are the additional permissions of the user (e.g. AdminScope)
+	Scopes []string `json:"scopes"`
+}
+
+// HasScope returns true if the identity holds the scope.
+func (i *Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CanActAs returns true if the identity is the given user, or holds the admin scope.
+func (i *Identity) CanActAs(userID string) bool {
+	return (userID != "" && i.UserID == userID) || i.HasScope(AdminScope)
+}
+
+// Authenticator allows to provide a custom authentication of the users by tokens.
+type Authenticator interface {
+	// Authenticate returns the identity of the user of the token.
+	Authenticate(token string) (*Identity, error)
+}
+
+// RequestToken returns the token given as `Authorization: Bearer <token>` header of the request,
+// or the credentials given as `Authorization: Basic <credentials>` header (see LDAPAuthenticator).
+func RequestToken(req *http.Request) string {
+	header := req.Header.Get("Authorization")
+	for _, scheme := range []string{"Bearer ", "Basic "} {
+		if strings.HasPrefix(header, scheme) {
+			return strings.TrimPrefix(header, scheme)
+		}
+	}
+	return ""
+}
+
+// RestAuthenticator is a url authenticating the tokens.
+type RestAuthenticator string
+
+// NewRestAuthenticator returns a new RestAuthenticator.
+func NewRestAuthenticator(url string) RestAuthenticator {
+	return RestAuthenticator(url)
+}
+
+// Authenticate is an implementation of the Authenticator interface.
+// The token is sent as `Authorization: Bearer <token>` header of a GET request to the url, which answers a valid token
+// with `200 OK` and `{"user_id": "user01", "expires": "2017-03-01T11:00:00Z", "scopes": ["admin"]}`
+// (the expiration and the scopes are optional).
+func (ra RestAuthenticator) Authenticate(token string) (*Identity, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	req, err := http.NewRequest(http.MethodGet, string(ra), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.WithError(err).WithField("module", "RestAuthenticator").Warn("Authentication request failed")
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.WithField("httpCode", resp.StatusCode).Info("Token not authenticated")
+		return nil, ErrInvalidToken
+	}
+
+	identity := new(Identity)
+	if err := json.NewDecoder(resp.Body).Decode(identity); err != nil {
+		return nil, err
+	}
+	if !identity.Expires.IsZero() && !identity.Expires.After(time.Now()) {
+		return nil, ErrInvalidToken
+	}
+	return identity, nil
+}
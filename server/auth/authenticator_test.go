@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_RestAuthenticator(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer valid":
+			w.Write([]byte(`{"user_id": "user01", "expires": "2100-01-01T00:00:00Z"}`))
+		case "Bearer admin":
+			w.Write([]byte(`{"user_id": "ops", "scopes": ["admin"]}`))
+		case "Bearer stale":
+			w.Write([]byte(`{"user_id": "user01", "expires": "2017-01-01T00:00:00Z"}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	a := assert.New(t)
+	ra := NewRestAuthenticator(ts.URL)
+	identity, err := ra.Authenticate("valid")
+	a.NoError(err)
+	a.Equal("user01", identity.UserID)
+	a.Equal(2100, identity.Expires.Year())
+	a.True(identity.CanActAs("user01"))
+	a.False(identity.CanActAs("user02"))
+	a.False(identity.CanActAs(""))
+
+	identity, err = ra.Authenticate("admin")
+	a.NoError(err)
+	a.True(identity.Expires.IsZero())
+	a.True(identity.HasScope(AdminScope))
+	a.True(identity.CanActAs("user02"))
+
+	_, err = ra.Authenticate("stale")
+	a.Equal(ErrInvalidToken, err)
+	_, err = ra.Authenticate("forged")
+	a.Equal(ErrInvalidToken, err)
+	_, err = ra.Authenticate("")
+	a.Equal(ErrInvalidToken, err)
+}
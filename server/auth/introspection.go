@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// IntrospectionAuthenticator authenticates the OAuth2 access tokens with the introspection endpoint
+// of the authorization server (RFC 7662).
+type IntrospectionAuthenticator struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+}
+
+// NewIntrospectionAuthenticator returns a new IntrospectionAuthenticator, authenticating itself
+// with the given client credentials (if any).
+func NewIntrospectionAuthenticator(url, clientID, clientSecret string) *IntrospectionAuthenticator {
+	return &IntrospectionAuthenticator{URL: url, ClientID: clientID, ClientSecret: clientSecret}
+}
+
+// introspection is the response of the introspection endpoint.
+type introspection struct {
+	Active   bool   `json:"active"`
+	Subject  string `json:"sub"`
+	Username string `json:"username"`
+	Expires  int64  `json:"exp"`
+	Scope    string `json:"scope"`
+}
+
+// Authenticate is an implementation of the Authenticator interface.
+// The user of an active token is its subject (`sub`), or its `username` if the server does not return the subject,
+// and its scopes are the space-separated `scope`.
+func (ia *IntrospectionAuthenticator) Authenticate(token string) (*Identity, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest(http.MethodPost, ia.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if ia.ClientID != "" {
+		req.SetBasicAuth(ia.ClientID, ia.ClientSecret)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.WithError(err).WithField("module", "IntrospectionAuthenticator").Warn("Introspection request failed")
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.WithField("httpCode", resp.StatusCode).Warn("Introspection request refused")
+		return nil, ErrInvalidToken
+	}
+
+	var result introspection
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	identity := &Identity{UserID: result.Subject, Scopes: strings.Fields(result.Scope)}
+	if identity.UserID == "" {
+		identity.UserID = result.Username
+	}
+	if result.Expires > 0 {
+		identity.Expires = time.Unix(result.Expires, 0)
+	}
+	if !result.Active || identity.UserID == "" || (!identity.Expires.IsZero() && !identity.Expires.After(time.Now())) {
+		return nil, ErrInvalidToken
+	}
+	return identity, nil
+}
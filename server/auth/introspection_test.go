@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_IntrospectionAuthenticator(t *testing.T) {
+	a := assert.New(t)
+	exp := time.Now().Add(time.Hour).Unix()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if clientID, secret, _ := r.BasicAuth(); clientID != "guble" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		a.Equal(http.MethodPost, r.Method)
+		a.Equal("access_token", r.FormValue("token_type_hint"))
+		switch r.FormValue("token") {
+		case "valid":
+			fmt.Fprintf(w, `{"active": true, "sub": "user01", "username": "jdoe", "exp": %d, "scope": "read admin"}`, exp)
+		case "username":
+			w.Write([]byte(`{"active": true, "username": "jdoe"}`))
+		case "stale":
+			w.Write([]byte(`{"active": true, "sub": "user01", "exp": 1483228800}`))
+		default:
+			w.Write([]byte(`{"active": false}`))
+		}
+	}))
+	defer ts.Close()
+
+	ia := NewIntrospectionAuthenticator(ts.URL, "guble", "secret")
+	identity, err := ia.Authenticate("valid")
+	a.NoError(err)
+	a.Equal("user01", identity.UserID)
+	a.Equal(exp, identity.Expires.Unix())
+	a.Equal([]string{"read", "admin"}, identity.Scopes)
+	a.True(identity.HasScope(AdminScope))
+
+	identity, err = ia.Authenticate("username")
+	a.NoError(err)
+	a.Equal("jdoe", identity.UserID)
+	a.True(identity.Expires.IsZero())
+
+	for _, token := range []string{"stale", "revoked", ""} {
+		_, err = ia.Authenticate(token)
+		a.Equal(ErrInvalidToken, err, token)
+	}
+
+	// a server refusing the client credentials
+	_, err = NewIntrospectionAuthenticator(ts.URL, "guble", "wrong").Authenticate("valid")
+	a.Equal(ErrInvalidToken, err)
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLDAPTimeout is the default timeout of the binds.
+	DefaultLDAPTimeout = 5 * time.Second
+
+	// the BER tags of the LDAP messages (RFC 4511)
+	berSequence      = 0x30
+	berInteger       = 0x02
+	berOctetString   = 0x04
+	berEnumerated    = 0x0a
+	ldapBindRequest  = 0x60
+	ldapBindResponse = 0x61
+	ldapUnbind       = 0x42
+	ldapSimpleAuth   = 0x80
+
+	ldapVersion            = 3
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+)
+
+var errBER = errors.New("Malformed LDAP response.")
+
+// LDAPAuthenticator authenticates the users by a simple bind to an LDAP directory, with their credentials:
+// the base64 encoding of `<user>:<password>`, given as `Authorization: Basic <credentials>` header
+// (or as token of a websocket).
+type LDAPAuthenticator struct {
+	// URL is the directory server: ldap://host[:389] or ldaps://host[:636]
+	URL *url.URL
+
+	// UserDN is the template of the distinguished names of the users, e.g. "uid=%s,ou=people,dc=example,dc=com"
+	UserDN string
+
+	Timeout time.Duration
+
+	tlsConfig *tls.Config
+}
+
+// NewLDAPAuthenticator returns a new LDAPAuthenticator, binding with the DN template to the directory server.
+func NewLDAPAuthenticator(rawurl, userDN string) (*LDAPAuthenticator, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("Unsupported LDAP scheme %q (ldap or ldaps expected)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Missing host of the LDAP server %q", rawurl)
+	}
+	if strings.Count(userDN, "%s") != 1 {
+		return nil, fmt.Errorf(`The LDAP user DN %q must contain the user as "%%s"`, userDN)
+	}
+	return &LDAPAuthenticator{URL: u, UserDN: userDN, Timeout: DefaultLDAPTimeout}, nil
+}
+
+// Authenticate is an implementation of the Authenticator interface.
+// The user is authenticated if the directory accepts the bind, and the identity never expires.
+func (la *LDAPAuthenticator) Authenticate(token string) (*Identity, error) {
+	decoded, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	credentials := strings.SplitN(string(decoded), ":", 2)
+	// an empty password would be an anonymous bind, accepted by most directories
+	if len(credentials) != 2 || credentials[0] == "" || credentials[1] == "" {
+		return nil, ErrInvalidToken
+	}
+	if err := la.bind(fmt.Sprintf(la.UserDN, escapeDN(credentials[0])), credentials[1]); err != nil {
+		return nil, err
+	}
+	return &Identity{UserID: credentials[0]}, nil
+}
+
+// bind connects to the directory, and binds with the DN and password.
+func (la *LDAPAuthenticator) bind(dn, password string) error {
+	conn, err := la.dial()
+	if err != nil {
+		logger.WithError(err).WithField("module", "LDAPAuthenticator").Warn("Connecting the LDAP server failed")
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(la.Timeout))
+
+	request := berEncode(berSequence,
+		berInt(berInteger, 1),
+		berEncode(ldapBindRequest,
+			berInt(berInteger, ldapVersion),
+			berEncode(berOctetString, []byte(dn)),
+			berEncode(ldapSimpleAuth, []byte(password))))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	resultCode, message, err := readBindResponse(bufio.NewReader(conn))
+	if err != nil {
+		return err
+	}
+	conn.Write(berEncode(berSequence, berInt(berInteger, 2), berEncode(ldapUnbind)))
+
+	switch resultCode {
+	case ldapSuccess:
+		return nil
+	case ldapInvalidCredentials:
+		return ErrInvalidToken
+	}
+	return fmt.Errorf("LDAP bind failed with result code %d: %s", resultCode, message)
+}
+
+func (la *LDAPAuthenticator) dial() (net.Conn, error) {
+	host := la.URL.Host
+	if la.URL.Port() == "" {
+		host = net.JoinHostPort(la.URL.Hostname(), map[string]string{"ldap": "389", "ldaps": "636"}[la.URL.Scheme])
+	}
+	dialer := &net.Dialer{Timeout: la.Timeout}
+	if la.URL.Scheme == "ldaps" {
+		config := la.tlsConfig
+		if config == nil {
+			config = &tls.Config{ServerName: la.URL.Hostname()}
+		}
+		return tls.DialWithDialer(dialer, "tcp", host, config)
+	}
+	return dialer.Dial("tcp", host)
+}
+
+// readBindResponse returns the result code and the diagnostic message of a bind response.
+func readBindResponse(r *bufio.Reader) (int, string, error) {
+	tag, content, err := berRead(r)
+	if err != nil {
+		return 0, "", err
+	}
+	message, err := berChildren(content)
+	if tag != berSequence || err != nil || len(message) < 2 || message[1].tag != ldapBindResponse {
+		return 0, "", errBER
+	}
+	response, err := berChildren(message[1].content)
+	if err != nil || len(response) < 3 || response[0].tag != berEnumerated {
+		return 0, "", errBER
+	}
+	return berToInt(response[0].content), string(response[2].content), nil
+}
+
+// escapeDN escapes the special characters of a value of a distinguished name (RFC 4514).
+func escapeDN(value string) string {
+	var b bytes.Buffer
+	for i, c := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, c),
+			(c == '#' || c == ' ') && i == 0,
+			c == ' ' && i == len(value)-1:
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+type berElement struct {
+	tag     byte
+	content []byte
+}
+
+// berEncode returns the BER element with the tag and the concatenated contents.
+func berEncode(tag byte, contents ...[]byte) []byte {
+	content := bytes.Join(contents, nil)
+	var length []byte
+	if len(content) < 0x80 {
+		length = []byte{byte(len(content))}
+	} else {
+		for l := len(content); l > 0; l >>= 8 {
+			length = append([]byte{byte(l)}, length...)
+		}
+		length = append([]byte{0x80 | byte(len(length))}, length...)
+	}
+	return append(append([]byte{tag}, length...), content...)
+}
+
+// berInt returns the BER element of a small non-negative integer.
+func berInt(tag byte, value int) []byte {
+	content := []byte{byte(value)}
+	for value >>= 8; value > 0; value >>= 8 {
+		content = append([]byte{byte(value)}, content...)
+	}
+	if content[0]&0x80 != 0 {
+		content = append([]byte{0}, content...)
+	}
+	return berEncode(tag, content)
+}
+
+func berToInt(content []byte) int {
+	value := 0
+	for _, b := range content {
+		value = value<<8 | int(b)
+	}
+	return value
+}
+
+// berRead reads a BER element.
+func berRead(r *bufio.Reader) (byte, []byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 3 {
+			return 0, nil, errBER
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return tag, content, nil
+}
+
+// berChildren returns the elements of a constructed BER content.
+func berChildren(content []byte) ([]berElement, error) {
+	var children []berElement
+	r := bufio.NewReader(bytes.NewReader(content))
+	for {
+		tag, child, err := berRead(r)
+		if err == io.EOF {
+			return children, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, berElement{tag, child})
+	}
+}
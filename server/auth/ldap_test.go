@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveLDAP answers the bind requests with success for the password "secret", and invalid credentials otherwise,
+// and sends the DNs of the binds through the channel.
+func serveLDAP(l net.Listener, dnC chan string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_, content, err := berRead(bufio.NewReader(conn))
+			if err != nil {
+				return
+			}
+			message, _ := berChildren(content)
+			request, _ := berChildren(message[1].content)
+			dnC <- string(request[1].content)
+			resultCode, diagnostic := ldapSuccess, ""
+			if string(request[2].content) != "secret" {
+				resultCode, diagnostic = ldapInvalidCredentials, "invalid credentials"
+			}
+			conn.Write(berEncode(berSequence,
+				berInt(berInteger, 1),
+				berEncode(ldapBindResponse,
+					berInt(berEnumerated, resultCode),
+					berEncode(berOctetString),
+					berEncode(berOctetString, []byte(diagnostic)))))
+		}()
+	}
+}
+
+func credentials(user, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+}
+
+func Test_LDAPAuthenticator(t *testing.T) {
+	a := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	defer l.Close()
+	dnC := make(chan string, 10)
+	go serveLDAP(l, dnC)
+
+	la, err := NewLDAPAuthenticator("ldap://"+l.Addr().String(), "uid=%s,ou=people,dc=example,dc=com")
+	a.NoError(err)
+
+	identity, err := la.Authenticate(credentials("jdoe", "secret"))
+	a.NoError(err)
+	a.Equal("jdoe", identity.UserID)
+	a.True(identity.Expires.IsZero())
+	a.Equal("uid=jdoe,ou=people,dc=example,dc=com", <-dnC)
+
+	_, err = la.Authenticate(credentials("jdoe", "wrong"))
+	a.Equal(ErrInvalidToken, err)
+	<-dnC
+
+	// the special characters can not inject other DN attributes
+	_, err = la.Authenticate(credentials("x,ou=admins", "secret"))
+	a.NoError(err)
+	a.Equal(`uid=x\,ou\=admins,ou=people,dc=example,dc=com`, <-dnC)
+
+	// no anonymous or malformed binds are attempted
+	for _, token := range []string{credentials("jdoe", ""), credentials("", "secret"), "not base64", ""} {
+		_, err = la.Authenticate(token)
+		a.Equal(ErrInvalidToken, err, token)
+	}
+	a.Len(dnC, 0)
+}
+
+func Test_NewLDAPAuthenticator(t *testing.T) {
+	a := assert.New(t)
+
+	la, err := NewLDAPAuthenticator("ldaps://ldap.example.com", "uid=%s,dc=example,dc=com")
+	a.NoError(err)
+	a.Equal("ldap.example.com", la.URL.Host)
+
+	for _, invalid := range [][2]string{
+		{"http://ldap.example.com", "uid=%s,dc=example,dc=com"},
+		{"ldap://", "uid=%s,dc=example,dc=com"},
+		{"ldap://ldap.example.com", "dc=example,dc=com"},
+	} {
+		_, err = NewLDAPAuthenticator(invalid[0], invalid[1])
+		a.Error(err, invalid[0])
+	}
+
+	a.Equal(`\#jdoe\ `, escapeDN("#jdoe "))
+	a.Equal(`a\+b\"c\\d\<e\>f\;`, escapeDN(`a+b"c\d<e>f;`))
+}
@@ -199,18 +199,18 @@ func (params *benchParams) throughputAPNS() {
 	}
 }
 
-var createModulesWebsocketAndMockAPNSPusher = func(receiveC chan bool, simulatedLatency time.Duration) func(router router.Router) []interface{} {
-	return func(router router.Router) []interface{} {
+var createModulesWebsocketAndMockAPNSPusher = func(receiveC chan bool, simulatedLatency time.Duration) func(router router.Router, config *GubleConfig) []interface{} {
+	return func(router router.Router, config *GubleConfig) []interface{} {
 		var modules []interface{}
 
-		if wsHandler, err := websocket.NewWSHandler(router, "/stream/"); err != nil {
+		if wsHandler, err := websocket.NewWSHandler(router, "/stream/", websocket.Config{}); err != nil {
 			logger.WithError(err).Error("Error loading WSHandler module")
 		} else {
 			modules = append(modules, wsHandler)
 		}
 
-		if *Config.APNS.Enabled {
-			if *Config.APNS.AppTopic == "" {
+		if *config.APNS.Enabled {
+			if *config.APNS.AppTopic == "" {
 				logger.Panic("The Mobile App Topic (usually the bundle-id) has to be provided when APNS is enabled")
 			}
 
@@ -227,11 +227,11 @@ var createModulesWebsocketAndMockAPNSPusher = func(receiveC chan bool, simulated
 					return nil, nil
 				}).Return(rsp, nil).AnyTimes()
 
-			apnsSender, err := apns.NewSenderUsingPusher(mPusher, *Config.APNS.AppTopic)
+			apnsSender, err := apns.NewSenderUsingPusher(mPusher, *config.APNS.AppTopic)
 			if err != nil {
 				logger.Panic("APNS Sender could not be created")
 			}
-			if apnsConn, err := apns.New(router, apnsSender, Config.APNS); err != nil {
+			if apnsConn, err := apns.New(router, apnsSender, config.APNS); err != nil {
 				logger.WithError(err).Error("Error creating APNS connector")
 			} else {
 				modules = append(modules, apnsConn)
@@ -0,0 +1,205 @@
+// Package campaign sends a message to a set of targets in the background, at a limited rate.
+package campaign
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+)
+
+const (
+	DefaultPrefix = "/campaigns/"
+
+	// DefaultRate is the number of messages per second published by all the campaigns together
+	DefaultRate = 100
+
+	// schema is the database schema of the campaigns, stored by id
+	schema = "campaign"
+
+	// userIDKey is the filter selecting the subscriptions of a user
+	userIDKey = "user_id"
+)
+
+// The statuses of a campaign.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusCancelled = "cancelled"
+)
+
+var ErrInvalidCampaign = errors.New("Invalid campaign.")
+
+// Campaign is a message published to a set of targets: the subscribers of a topic (optionally selected by filters),
+// or a list of users, to each of whom the message is published on the topic with the user id as filter.
+type Campaign struct {
+	ID    string `json:"id"`
+	Topic string `json:"topic"`
+
+	// Message is the body of the messages: a JSON string is sent as text, any other JSON value as it is
+	Message json.RawMessage `json:"message"`
+
+	UserIDs []string          `json:"user_ids,omitempty"`
+	Filters map[string]string `json:"filters,omitempty"`
+
+	// Rate is the number of messages per second of the campaign (0: only limited by the rate of all the campaigns)
+	Rate float64 `json:"rate,omitempty"`
+
+	Status  string    `json:"status"`
+	Created time.Time `json:"created"`
+
+	// Total is the number of messages of the campaign, which are queued, sent or failed
+	Total  int `json:"total"`
+	Queued int `json:"queued"`
+	Sent   int `json:"sent"`
+	Failed int `json:"failed"`
+
+	// NodeID is the guble node executing the campaign (0 when not clustered)
+	NodeID uint8 `json:"node_id,omitempty"`
+}
+
+// validate checks a submitted campaign.
+func (c *Campaign) validate() error {
+	if c.Topic == "" {
+		return fmt.Errorf("%v Missing topic", ErrInvalidCampaign)
+	}
+	if !strings.HasPrefix(c.Topic, "/") {
+		c.Topic = "/" + c.Topic
+	}
+	if len(c.Message) == 0 {
+		return fmt.Errorf("%v Missing message", ErrInvalidCampaign)
+	}
+	for i, userID := range c.UserIDs {
+		if userID == "" {
+			return fmt.Errorf("%v Empty user id at index %d", ErrInvalidCampaign, i)
+		}
+	}
+	if c.Rate < 0 {
+		return fmt.Errorf("%v Negative rate", ErrInvalidCampaign)
+	}
+	return nil
+}
+
+// total returns the number of messages of the campaign: one per user, or else a single one for the topic.
+func (c *Campaign) total() int {
+	if len(c.UserIDs) > 0 {
+		return len(c.UserIDs)
+	}
+	return 1
+}
+
+// next returns the index of the next message to publish.
+func (c *Campaign) next() int {
+	return c.Sent + c.Failed
+}
+
+// message returns the i-th message of the campaign.
+func (c *Campaign) message(i int) *protocol.Message {
+	m := &protocol.Message{
+		Path:          protocol.Path(c.Topic),
+		ApplicationID: "campaign-" + c.ID,
+		HeaderJSON:    fmt.Sprintf(`{"Campaign":%q}`, c.ID),
+	}
+	var text string
+	if err := json.Unmarshal(c.Message, &text); err == nil {
+		m.Body = []byte(text)
+		m.ContentType = protocol.ContentTypeText
+	} else {
+		m.Body = []byte(c.Message)
+		m.ContentType = protocol.ContentTypeJSON
+	}
+
+	for key, value := range c.Filters {
+		m.SetFilter(key, value)
+	}
+	if len(c.UserIDs) > 0 {
+		m.SetFilter(userIDKey, c.UserIDs[i])
+	}
+	return m
+}
+
+func decodeCampaign(data []byte) (*Campaign, error) {
+	c := new(Campaign)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadCampaign returns the stored campaign, or nil if it does not exist.
+func loadCampaign(kvs kvstore.KVStore, id string) (*Campaign, error) {
+	data, exists, err := kvs.Get(schema, id)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return decodeCampaign(data)
+}
+
+// loadCampaigns returns all the stored campaigns.
+func loadCampaigns(ctx context.Context, kvs kvstore.KVStore) ([]*Campaign, error) {
+	// the iteration is stopped when returning early (e.g. on a decoding error)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var campaigns []*Campaign
+	for entry := range kvs.Iterate(ctx, schema, "", 0) {
+		c, err := decodeCampaign([]byte(entry[1]))
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, c)
+	}
+	return campaigns, ctx.Err()
+}
+
+// limiter spaces the messages at a rate per second (no limit, if the rate is not positive).
+// It can be shared by several campaigns.
+type limiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newLimiter(rate float64) *limiter {
+	l := &limiter{}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return l
+}
+
+// wait blocks until the next message can be published, or the context is done.
+func (l *limiter) wait(ctx context.Context) error {
+	if l.interval == 0 {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
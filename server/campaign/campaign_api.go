@@ -0,0 +1,106 @@
+package campaign
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// GetPrefix returns the prefix of the campaigns API.
+// It is a part of the service.endpoint implementation.
+func (c *campaigner) GetPrefix() string {
+	return *c.config.Prefix
+}
+
+// ServeHTTP manages the campaigns: POST on `<prefix>` submits a campaign and GET lists the campaigns,
+// GET on `<prefix><id>` returns the status of a campaign and DELETE cancels it.
+// It is a part of the service.endpoint implementation.
+func (c *campaigner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(c.GetPrefix(), "/")), "/")
+	if strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		c.postCampaign(w, r)
+	case id == "" && r.Method == http.MethodGet:
+		c.listCampaigns(w)
+	case id != "" && r.Method == http.MethodGet:
+		c.getCampaign(w, id)
+	case id != "" && r.Method == http.MethodDelete:
+		c.deleteCampaign(w, id)
+	default:
+		http.Error(w, `{"error": "Method not allowed."}`, http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *campaigner) postCampaign(w http.ResponseWriter, r *http.Request) {
+	campaign := new(Campaign)
+	if err := json.NewDecoder(r.Body).Decode(campaign); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "json body could not be decoded: %s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := campaign.validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := c.submit(campaign); err != nil {
+		c.logger.WithField("error", err.Error()).Error("Error storing the campaign")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+
+	c.logger.WithField("id", campaign.ID).WithField("total", campaign.Total).Info("Campaign submitted")
+	submitted, _ := c.get(campaign.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(submitted)
+}
+
+func (c *campaigner) listCampaigns(w http.ResponseWriter) {
+	campaigns, err := c.list()
+	if err != nil {
+		c.logger.WithField("error", err.Error()).Error("Error loading the campaigns")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	sort.Sort(byCreation(campaigns))
+	if campaigns == nil {
+		campaigns = []*Campaign{}
+	}
+	json.NewEncoder(w).Encode(campaigns)
+}
+
+func (c *campaigner) getCampaign(w http.ResponseWriter, id string) {
+	campaign, err := c.get(id)
+	if err != nil {
+		c.logger.WithField("error", err.Error()).WithField("id", id).Error("Error loading the campaign")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	if campaign == nil {
+		http.Error(w, `{"error": "Campaign not found."}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(campaign)
+}
+
+func (c *campaigner) deleteCampaign(w http.ResponseWriter, id string) {
+	if !c.cancelCampaign(id) {
+		http.Error(w, `{"error": "Campaign not running on this node."}`, http.StatusConflict)
+		return
+	}
+	c.getCampaign(w, id)
+}
+
+// byCreation sorts the campaigns by their creation time.
+type byCreation []*Campaign
+
+func (s byCreation) Len() int           { return len(s) }
+func (s byCreation) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCreation) Less(i, j int) bool { return s[i].Created.Before(s[j].Created) }
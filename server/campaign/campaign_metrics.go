@@ -0,0 +1,12 @@
+package campaign
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns                   = metrics.NS("campaign")
+	mTotalCampaigns      = ns.NewInt("total_campaigns")
+	mTotalSentMessages   = ns.NewInt("total_sent_messages")
+	mTotalFailedMessages = ns.NewInt("total_failed_messages")
+)
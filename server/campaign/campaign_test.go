@@ -0,0 +1,216 @@
+package campaign
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCampaigner_CampaignOfUsers(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	c, routerMock, _ := newTestCampaigner(t, 0)
+	a.NoError(c.Start())
+	defer c.Stop()
+
+	var mu sync.Mutex
+	var messages []*protocol.Message
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(m *protocol.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		messages = append(messages, m)
+		return nil
+	}).Return(nil).Times(2)
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Return(errors.New("rejected"))
+
+	recorder := serve(c, http.MethodPost, "/campaigns/",
+		`{"topic": "news", "message": {"notification": {"body": "Hi"}}, "user_ids": ["u1", "u2", "u3"], "filters": {"connector": "fcm"}}`)
+	a.Equal(http.StatusAccepted, recorder.Code)
+	submitted := decodeCampaignResponse(t, recorder)
+	a.NotEmpty(submitted.ID)
+	a.Equal(3, submitted.Total)
+
+	campaign := waitForStatus(t, c, submitted.ID, StatusDone)
+	a.Equal(0, campaign.Queued)
+	a.Equal(2, campaign.Sent)
+	a.Equal(1, campaign.Failed)
+
+	mu.Lock()
+	a.Equal(2, len(messages))
+	a.Equal(protocol.Path("/news"), messages[0].Path)
+	a.Equal(`{"notification": {"body": "Hi"}}`, string(messages[0].Body))
+	a.Equal(protocol.ContentTypeJSON, messages[0].ContentType)
+	a.Equal(map[string]string{"user_id": "u1", "connector": "fcm"}, messages[0].Filters)
+	a.Equal("u2", messages[1].Filters["user_id"])
+	mu.Unlock()
+
+	// the final progress is stored, and the status is returned by the API
+	stored, err := loadCampaign(c.kvstore, submitted.ID)
+	a.NoError(err)
+	a.Equal(StatusDone, stored.Status)
+
+	recorder = serve(c, http.MethodGet, "/campaigns/"+submitted.ID, "")
+	a.Equal(http.StatusOK, recorder.Code)
+	a.Equal(2, decodeCampaignResponse(t, recorder).Sent)
+
+	recorder = serve(c, http.MethodGet, "/campaigns/", "")
+	a.Equal(http.StatusOK, recorder.Code)
+	var campaigns []*Campaign
+	a.NoError(json.NewDecoder(recorder.Body).Decode(&campaigns))
+	a.Equal(1, len(campaigns))
+}
+
+func TestCampaigner_ResumesAfterRestart(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	c, routerMock, kvs := newTestCampaigner(t, 0)
+	storeTestCampaign(t, kvs, &Campaign{ID: "resumed", Topic: "/news", Message: []byte(`"Hello"`),
+		UserIDs: []string{"u1", "u2", "u3"}, Status: StatusRunning, Total: 3, Queued: 2, Sent: 1})
+	storeTestCampaign(t, kvs, &Campaign{ID: "done", Topic: "/news", Message: []byte(`"Hello"`),
+		Status: StatusDone, Total: 1, Sent: 1})
+	storeTestCampaign(t, kvs, &Campaign{ID: "other-node", Topic: "/news", Message: []byte(`"Hello"`),
+		Status: StatusRunning, Total: 1, Queued: 1, NodeID: 2})
+
+	for _, userID := range []string{"u2", "u3"} {
+		expectedUserID := userID
+		routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(m *protocol.Message) error {
+			a.Equal(expectedUserID, m.Filters["user_id"])
+			a.Equal("Hello", string(m.Body))
+			a.Equal(protocol.ContentTypeText, m.ContentType)
+			return nil
+		}).Return(nil)
+	}
+
+	a.NoError(c.Start())
+	campaign := waitForStatus(t, c, "resumed", StatusDone)
+	a.NoError(c.Stop())
+	a.Equal(3, campaign.Sent)
+}
+
+func TestCampaigner_Cancel(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	c, routerMock, _ := newTestCampaigner(t, 0)
+	a.NoError(c.Start())
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Return(nil).AnyTimes()
+
+	recorder := serve(c, http.MethodPost, "/campaigns/",
+		`{"topic": "/news", "message": "Hello", "user_ids": ["u1", "u2", "u3", "u4", "u5"], "rate": 20}`)
+	a.Equal(http.StatusAccepted, recorder.Code)
+	id := decodeCampaignResponse(t, recorder).ID
+
+	time.Sleep(70 * time.Millisecond)
+	recorder = serve(c, http.MethodDelete, "/campaigns/"+id, "")
+	a.Equal(http.StatusOK, recorder.Code)
+	a.Equal(StatusCancelled, decodeCampaignResponse(t, recorder).Status)
+
+	// a cancelled campaign is not resumed
+	a.NoError(c.Stop())
+	stored, err := loadCampaign(c.kvstore, id)
+	a.NoError(err)
+	a.Equal(StatusCancelled, stored.Status)
+	a.True(stored.Queued > 0)
+	a.Equal(5, stored.Queued+stored.Sent)
+
+	recorder = serve(c, http.MethodDelete, "/campaigns/"+id, "")
+	a.Equal(http.StatusConflict, recorder.Code)
+}
+
+func TestCampaigner_APIErrors(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	c, _, _ := newTestCampaigner(t, 0)
+	a.NoError(c.Start())
+	defer c.Stop()
+
+	a.Equal(http.StatusBadRequest, serve(c, http.MethodPost, "/campaigns/", `not json`).Code)
+	a.Equal(http.StatusBadRequest, serve(c, http.MethodPost, "/campaigns/", `{"message": "Hello"}`).Code)
+	a.Equal(http.StatusBadRequest, serve(c, http.MethodPost, "/campaigns/", `{"topic": "/news"}`).Code)
+	a.Equal(http.StatusBadRequest, serve(c, http.MethodPost, "/campaigns/", `{"topic": "/news", "message": "Hello", "user_ids": [""]}`).Code)
+	a.Equal(http.StatusNotFound, serve(c, http.MethodGet, "/campaigns/unknown", "").Code)
+	a.Equal(http.StatusNotFound, serve(c, http.MethodGet, "/campaigns/unknown/more", "").Code)
+	a.Equal(http.StatusMethodNotAllowed, serve(c, http.MethodPut, "/campaigns/", "").Code)
+
+	recorder := serve(c, http.MethodGet, "/campaigns/", "")
+	a.Equal(http.StatusOK, recorder.Code)
+	a.Equal("[]\n", recorder.Body.String())
+}
+
+func Test_limiter(t *testing.T) {
+	a := assert.New(t)
+
+	l := newLimiter(100)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		a.NoError(l.wait(context.Background()))
+	}
+	a.True(time.Since(start) >= 40*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.Error(newLimiter(1).wait(ctx))
+	a.Error(newLimiter(0).wait(ctx))
+}
+
+func newTestCampaigner(t *testing.T, rate float64) (*campaigner, *MockRouter, kvstore.KVStore) {
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	kvs := kvstore.NewMemoryKVStore()
+	routerMock.EXPECT().KVStore().Return(kvs, nil).AnyTimes()
+	routerMock.EXPECT().Cluster().Return(nil).AnyTimes()
+
+	c, err := New(routerMock, Config{Rate: &rate})
+	assert.NoError(t, err)
+	return c, routerMock, kvs
+}
+
+func storeTestCampaign(t *testing.T, kvs kvstore.KVStore, c *Campaign) {
+	data, err := json.Marshal(c)
+	assert.NoError(t, err)
+	assert.NoError(t, kvs.Put(schema, c.ID, data))
+}
+
+func serve(c *campaigner, method, path, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	c.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func decodeCampaignResponse(t *testing.T, recorder *httptest.ResponseRecorder) *Campaign {
+	c := new(Campaign)
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(c))
+	return c
+}
+
+// waitForStatus returns the campaign once it has the status, or fails after a second.
+func waitForStatus(t *testing.T, c *campaigner, id, status string) *Campaign {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		campaign, err := c.get(id)
+		assert.NoError(t, err)
+		if campaign != nil && campaign.Status == status {
+			return campaign
+		}
+	}
+	assert.FailNow(t, "campaign has not the expected status", status)
+	return nil
+}
@@ -0,0 +1,259 @@
+package campaign
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rs/xid"
+
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+)
+
+// storeEvery is the number of published messages after which the progress of a campaign is stored:
+// after a restart, at most these messages are published again.
+const storeEvery = 100
+
+// Config is used for configuring the campaigns.
+type Config struct {
+	Enabled *bool
+	Prefix  *string
+
+	// Rate is the number of messages per second published by all the campaigns together (0: no limit)
+	Rate *float64
+}
+
+// campaigner executes the campaigns in the background, each in its own goroutine,
+// and resumes the campaigns which were not finished when the guble node stopped.
+type campaigner struct {
+	config  Config
+	router  router.Router
+	kvstore kvstore.KVStore
+
+	// limiter is shared by all the campaigns
+	limiter *limiter
+
+	// campaigns are the campaigns executed by this node, and cancels stops the running ones, by id
+	campaigns map[string]*Campaign
+	cancels   map[string]context.CancelFunc
+	mu        sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	logger *log.Entry
+}
+
+// New returns the campaigner, publishing the messages of the campaigns in the router.
+func New(router router.Router, config Config) (*campaigner, error) {
+	kvs, err := router.KVStore()
+	if err != nil {
+		return nil, err
+	}
+	if config.Prefix == nil {
+		prefix := DefaultPrefix
+		config.Prefix = &prefix
+	}
+	var rate float64
+	if config.Rate != nil {
+		rate = *config.Rate
+	}
+	return &campaigner{
+		config:    config,
+		router:    router,
+		kvstore:   kvs,
+		limiter:   newLimiter(rate),
+		campaigns: make(map[string]*Campaign),
+		cancels:   make(map[string]context.CancelFunc),
+		logger:    logger.WithField("name", "campaigner"),
+	}, nil
+}
+
+// Start resumes the unfinished campaigns of this node.
+func (c *campaigner) Start() error {
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	campaigns, err := loadCampaigns(c.ctx, c.kvstore)
+	if err != nil {
+		return err
+	}
+	for _, campaign := range campaigns {
+		if campaign.NodeID != c.nodeID() {
+			continue
+		}
+		if campaign.Status == StatusQueued || campaign.Status == StatusRunning {
+			c.logger.WithField("id", campaign.ID).WithField("next", campaign.next()).Info("Resuming campaign")
+			c.start(campaign)
+		}
+	}
+	c.logger.Info("Started campaigner")
+	return nil
+}
+
+// Stop interrupts the running campaigns, which are resumed by the next start.
+func (c *campaigner) Stop() error {
+	c.cancel()
+	c.wg.Wait()
+	c.logger.Info("Stopped campaigner")
+	return nil
+}
+
+func (c *campaigner) nodeID() uint8 {
+	if cluster := c.router.Cluster(); cluster != nil {
+		return cluster.Config.ID
+	}
+	return 0
+}
+
+// submit stores a new (validated) campaign, and starts it.
+func (c *campaigner) submit(campaign *Campaign) error {
+	campaign.ID = xid.New().String()
+	campaign.Status = StatusQueued
+	campaign.Created = time.Now().UTC()
+	campaign.Total = campaign.total()
+	campaign.Queued = campaign.Total
+	campaign.Sent = 0
+	campaign.Failed = 0
+	campaign.NodeID = c.nodeID()
+
+	if err := c.store(campaign); err != nil {
+		return err
+	}
+	mTotalCampaigns.Add(1)
+	c.start(campaign)
+	return nil
+}
+
+func (c *campaigner) start(campaign *Campaign) {
+	ctx, cancel := context.WithCancel(c.ctx)
+
+	c.mu.Lock()
+	c.campaigns[campaign.ID] = campaign
+	c.cancels[campaign.ID] = cancel
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go c.run(ctx, campaign)
+}
+
+// run publishes the remaining messages of a campaign, until it is done or its context is cancelled.
+func (c *campaigner) run(ctx context.Context, campaign *Campaign) {
+	defer c.wg.Done()
+
+	c.mu.Lock()
+	if campaign.Status == StatusQueued {
+		campaign.Status = StatusRunning
+	}
+	next := campaign.next()
+	c.mu.Unlock()
+
+	campaignLimiter := newLimiter(campaign.Rate)
+	for i := next; i < campaign.Total; i++ {
+		if campaignLimiter.wait(ctx) != nil || c.limiter.wait(ctx) != nil {
+			break
+		}
+
+		err := c.router.HandleMessage(campaign.message(i))
+
+		c.mu.Lock()
+		if err != nil {
+			campaign.Failed++
+		} else {
+			campaign.Sent++
+		}
+		campaign.Queued--
+		c.mu.Unlock()
+
+		if err != nil {
+			mTotalFailedMessages.Add(1)
+			c.logger.WithField("error", err.Error()).WithField("id", campaign.ID).Debug("Error publishing campaign message")
+		} else {
+			mTotalSentMessages.Add(1)
+		}
+		if (i+1)%storeEvery == 0 {
+			c.storeProgress(campaign)
+		}
+	}
+
+	c.mu.Lock()
+	if campaign.Queued == 0 {
+		campaign.Status = StatusDone
+	}
+	delete(c.cancels, campaign.ID)
+	c.mu.Unlock()
+
+	c.storeProgress(campaign)
+	c.logger.WithFields(log.Fields{
+		"id":     campaign.ID,
+		"status": campaign.Status,
+		"sent":   campaign.Sent,
+		"failed": campaign.Failed,
+	}).Info("Campaign stopped")
+}
+
+// cancelCampaign stops a running campaign, and returns false if it is not running on this node.
+func (c *campaigner) cancelCampaign(id string) bool {
+	c.mu.Lock()
+	cancel, running := c.cancels[id]
+	if running {
+		c.campaigns[id].Status = StatusCancelled
+	}
+	c.mu.Unlock()
+
+	if running {
+		cancel()
+	}
+	return running
+}
+
+// get returns a copy of the campaign, as executed by this node or else as stored.
+func (c *campaigner) get(id string) (*Campaign, error) {
+	c.mu.Lock()
+	campaign, ok := c.campaigns[id]
+	if ok {
+		copied := *campaign
+		c.mu.Unlock()
+		return &copied, nil
+	}
+	c.mu.Unlock()
+
+	return loadCampaign(c.kvstore, id)
+}
+
+// list returns all the campaigns, with the current progress of the ones executed by this node.
+func (c *campaigner) list() ([]*Campaign, error) {
+	campaigns, err := loadCampaigns(context.Background(), c.kvstore)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, campaign := range campaigns {
+		if current, ok := c.campaigns[campaign.ID]; ok {
+			copied := *current
+			campaigns[i] = &copied
+		}
+	}
+	return campaigns, nil
+}
+
+func (c *campaigner) storeProgress(campaign *Campaign) {
+	if err := c.store(campaign); err != nil {
+		c.logger.WithField("error", err.Error()).WithField("id", campaign.ID).Error("Error storing the progress of the campaign")
+	}
+}
+
+func (c *campaigner) store(campaign *Campaign) error {
+	c.mu.Lock()
+	data, err := json.Marshal(campaign)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.kvstore.Put(schema, campaign.ID, data)
+}
@@ -0,0 +1,7 @@
+package campaign
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "campaign")
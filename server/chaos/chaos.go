@@ -0,0 +1,154 @@
+// Package chaos injects faults in a running server (dropped cluster messages, slow message store, failing APNS),
+// so that the clients and the operators can test their behaviour against realistic failures.
+// The faults are only injected if the server is started with `--chaos`, and they are changed by an admin endpoint.
+package chaos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrInjectedFault is the error of the operations failed by the Injector.
+var ErrInjectedFault = errors.New("Fault injected by the chaos mode.")
+
+// Faults are the faults injected in the server, given to the admin endpoint as JSON:
+// `{"cluster_drop_rate": 0.1, "store_delay": "200ms", "apns_failure_rate": 0.5}`.
+type Faults struct {
+	// ClusterDropRate is the fraction (between 0 and 1) of the received cluster messages which are dropped
+	ClusterDropRate float64 `json:"cluster_drop_rate"`
+
+	// StoreDelay is the delay of the writes of the message store (e.g. "200ms")
+	StoreDelay string `json:"store_delay"`
+
+	// APNSFailureRate is the fraction (between 0 and 1) of the APNS sends which fail
+	APNSFailureRate float64 `json:"apns_failure_rate"`
+}
+
+func (f Faults) validate() (time.Duration, error) {
+	if f.ClusterDropRate < 0 || f.ClusterDropRate > 1 || f.APNSFailureRate < 0 || f.APNSFailureRate > 1 {
+		return 0, errors.New("the rates have to be between 0 and 1")
+	}
+	if f.StoreDelay == "" {
+		return 0, nil
+	}
+	delay, err := time.ParseDuration(f.StoreDelay)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("invalid store delay %q", f.StoreDelay)
+	}
+	return delay, nil
+}
+
+// Injector decides which operations fail, according to the current faults (none, initially).
+// It is an admin endpoint: `GET <prefix>` returns the current faults, and `PUT <prefix>` replaces them.
+// A nil Injector injects no faults.
+type Injector struct {
+	prefix string
+
+	mu         sync.RWMutex
+	faults     Faults
+	storeDelay time.Duration
+}
+
+// New returns a new Injector, without faults.
+func New(prefix string) *Injector {
+	return &Injector{prefix: prefix}
+}
+
+// Faults returns the current faults.
+func (i *Injector) Faults() Faults {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.faults
+}
+
+// SetFaults replaces the current faults, if they are valid.
+func (i *Injector) SetFaults(faults Faults) error {
+	delay, err := faults.validate()
+	if err != nil {
+		return err
+	}
+	i.mu.Lock()
+	i.faults = faults
+	i.storeDelay = delay
+	i.mu.Unlock()
+
+	logger.WithField("faults", faults).Warn("Injecting faults")
+	return nil
+}
+
+// DropClusterMessage returns true if a received cluster message has to be dropped.
+func (i *Injector) DropClusterMessage() bool {
+	if i == nil {
+		return false
+	}
+	i.mu.RLock()
+	rate := i.faults.ClusterDropRate
+	i.mu.RUnlock()
+	return happens(rate)
+}
+
+// DelayStore waits for the delay of the store writes.
+func (i *Injector) DelayStore() {
+	if i == nil {
+		return
+	}
+	i.mu.RLock()
+	delay := i.storeDelay
+	i.mu.RUnlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// FailAPNSSend returns ErrInjectedFault if an APNS send has to fail.
+func (i *Injector) FailAPNSSend() error {
+	if i == nil {
+		return nil
+	}
+	i.mu.RLock()
+	rate := i.faults.APNSFailureRate
+	i.mu.RUnlock()
+	if happens(rate) {
+		return ErrInjectedFault
+	}
+	return nil
+}
+
+func happens(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (i *Injector) GetPrefix() string {
+	return i.prefix
+}
+
+// ServeHTTP is an http.Handler.
+// It is a part of the service.endpoint implementation.
+func (i *Injector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var faults Faults
+		if err := json.NewDecoder(r.Body).Decode(&faults); err != nil {
+			http.Error(w, `{"error": "Invalid faults."}`, http.StatusBadRequest)
+			return
+		}
+		if err := i.SetFaults(faults); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET and PUT are accepted."}`, http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(i.Faults())
+}
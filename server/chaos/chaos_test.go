@@ -0,0 +1,87 @@
+package chaos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/store/dummystore"
+)
+
+func TestInjector_ServeHTTP(t *testing.T) {
+	a := assert.New(t)
+	injector := New("/admin/chaos/")
+
+	w := httptest.NewRecorder()
+	injector.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/chaos/", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"cluster_drop_rate": 0, "store_delay": "", "apns_failure_rate": 0}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	injector.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/admin/chaos/",
+		strings.NewReader(`{"cluster_drop_rate": 1, "store_delay": "20ms", "apns_failure_rate": 0.5}`)))
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal(Faults{ClusterDropRate: 1, StoreDelay: "20ms", APNSFailureRate: 0.5}, injector.Faults())
+	a.True(injector.DropClusterMessage())
+
+	// invalid faults are refused, keeping the current ones
+	for _, body := range []string{`{"cluster_drop_rate": 2}`, `{"store_delay": "soon"}`, `not json`} {
+		w = httptest.NewRecorder()
+		injector.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/admin/chaos/", strings.NewReader(body)))
+		a.Equal(http.StatusBadRequest, w.Code, body)
+	}
+	a.Equal("20ms", injector.Faults().StoreDelay)
+
+	w = httptest.NewRecorder()
+	injector.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/admin/chaos/", nil))
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestInjector_NilInjectsNoFaults(t *testing.T) {
+	a := assert.New(t)
+	var injector *Injector
+	a.False(injector.DropClusterMessage())
+	a.NoError(injector.FailAPNSSend())
+	injector.DelayStore()
+}
+
+func TestMessageStore_DelaysTheWrites(t *testing.T) {
+	a := assert.New(t)
+	injector := New("/admin/chaos/")
+	a.NoError(injector.SetFaults(Faults{StoreDelay: "50ms"}))
+
+	ms := NewMessageStore(dummystore.New(kvstore.NewMemoryKVStore()), injector)
+	start := time.Now()
+	_, err := ms.StoreMessage(&protocol.Message{Path: "/foo", Body: []byte("bar")}, 0)
+	a.NoError(err)
+	a.True(time.Since(start) >= 50*time.Millisecond)
+}
+
+type senderFunc func(connector.Request) (interface{}, error)
+
+func (f senderFunc) Send(request connector.Request) (interface{}, error) {
+	return f(request)
+}
+
+func TestAPNSSender_FailsTheSends(t *testing.T) {
+	a := assert.New(t)
+	injector := New("/admin/chaos/")
+	sender := NewAPNSSender(senderFunc(func(connector.Request) (interface{}, error) {
+		return "sent", nil
+	}), injector)
+
+	response, err := sender.Send(nil)
+	a.NoError(err)
+	a.Equal("sent", response)
+
+	a.NoError(injector.SetFaults(Faults{APNSFailureRate: 1}))
+	_, err = sender.Send(nil)
+	a.Equal(ErrInjectedFault, err)
+}
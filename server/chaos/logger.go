@@ -0,0 +1,7 @@
+package chaos
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "chaos")
@@ -0,0 +1,36 @@
+package chaos
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/connector"
+)
+
+// apnsSender is a connector.Sender of APNS failing the sends at the APNS failure rate of the Injector.
+type apnsSender struct {
+	connector.Sender
+	injector *Injector
+}
+
+// NewAPNSSender returns the APNS sender, with the faults of the injector.
+func NewAPNSSender(sender connector.Sender, injector *Injector) connector.Sender {
+	return &apnsSender{
+		Sender:   sender,
+		injector: injector,
+	}
+}
+
+func (s *apnsSender) Send(request connector.Request) (interface{}, error) {
+	if err := s.injector.FailAPNSSend(); err != nil {
+		return nil, err
+	}
+	return s.Sender.Send(request)
+}
+
+// Summary summarizes the messages of the quiet hours with the APNS sender.
+// It is a part of the connector.Summarizer implementation.
+func (s *apnsSender) Summary(last *protocol.Message, count int) *protocol.Message {
+	if summarizer, ok := s.Sender.(connector.Summarizer); ok {
+		return summarizer.Summary(last, count)
+	}
+	return last
+}
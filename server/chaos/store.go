@@ -0,0 +1,40 @@
+package chaos
+
+import (
+	"github.com/docker/distribution/health"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+)
+
+// messageStore is a store.MessageStore delaying its writes by the store delay of the Injector.
+type messageStore struct {
+	store.MessageStore
+	injector *Injector
+}
+
+// NewMessageStore returns the message store, with the faults of the injector.
+func NewMessageStore(ms store.MessageStore, injector *Injector) store.MessageStore {
+	return &messageStore{
+		MessageStore: ms,
+		injector:     injector,
+	}
+}
+
+func (ms *messageStore) Store(partition string, messageID uint64, data []byte) error {
+	ms.injector.DelayStore()
+	return ms.MessageStore.Store(partition, messageID, data)
+}
+
+func (ms *messageStore) StoreMessage(message *protocol.Message, nodeID uint8) (int, error) {
+	ms.injector.DelayStore()
+	return ms.MessageStore.StoreMessage(message, nodeID)
+}
+
+// Check is a part of the health.Checker implementation, if the wrapped message store implements it.
+func (ms *messageStore) Check() error {
+	if checker, ok := ms.MessageStore.(health.Checker); ok {
+		return checker.Check()
+	}
+	return nil
+}
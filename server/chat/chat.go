@@ -0,0 +1,120 @@
+// Package chat posts the messages of selected topics to the incoming webhooks of Slack or Microsoft Teams channels,
+// e.g. the operational alerts flowing through guble to the channels of the on-call teams.
+// Each route is a forwarder of its topic to its webhook, sending the message formatted by a template.
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/smancke/guble/server/forward"
+	"github.com/smancke/guble/server/router"
+)
+
+const (
+	// Slack and Teams are the kinds of the webhooks
+	Slack = "slack"
+	Teams = "teams"
+
+	// summaryLength is the maximum length of the summary of a Teams card (shown in the notifications)
+	summaryLength = 80
+)
+
+// Config is used for configuring the chat routes.
+type Config struct {
+	Routes      *[]string
+	Format      *string
+	Retries     *int
+	Timeout     *time.Duration
+	Connections *int
+}
+
+// Route posts the messages of a topic (including its subtopics) to a webhook.
+type Route struct {
+	Topic string
+	Kind  string
+	URL   string
+}
+
+// ParseRoute parses the specification of a route: `<topic>=<slack|teams>:<webhook URL>`.
+func ParseRoute(spec string) (Route, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) == 2 {
+		webhook := strings.SplitN(parts[1], ":", 2)
+		if len(webhook) == 2 && (webhook[0] == Slack || webhook[0] == Teams) && strings.HasPrefix(webhook[1], "https://") &&
+			!strings.Contains(webhook[1], "{{") {
+			return Route{Topic: parts[0], Kind: webhook[0], URL: webhook[1]}, nil
+		}
+	}
+	// the specification is not logged, as the URL of a webhook is a secret
+	return Route{}, fmt.Errorf("Invalid chat route: expected <topic>=<slack|teams>:<https webhook URL>")
+}
+
+// New returns the forwarders of the routes (without starting them).
+func New(router router.Router, config Config) ([]*forward.Forwarder, error) {
+	format, err := forward.ParseTemplate("chat format", *config.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	forwarders := make([]*forward.Forwarder, 0, len(*config.Routes))
+	for _, spec := range *config.Routes {
+		route, err := ParseRoute(spec)
+		if err != nil {
+			return nil, err
+		}
+		// the URL, without actions, is a template of the forwarder
+		url := route.URL
+		method, contentType := "POST", "application/json"
+		f, err := forward.New(router, forward.Config{
+			Topic:       &route.Topic,
+			URL:         &url,
+			Method:      &method,
+			ContentType: &contentType,
+			Retries:     config.Retries,
+			Timeout:     config.Timeout,
+			Connections: config.Connections,
+			Render:      payload(route.Kind, format),
+		})
+		if err != nil {
+			return nil, err
+		}
+		forwarders = append(forwarders, f)
+	}
+	return forwarders, nil
+}
+
+// payload returns the function rendering the JSON payload of a webhook of the kind, with the formatted message as text.
+func payload(kind string, format *template.Template) func(forward.Fields) ([]byte, error) {
+	return func(fields forward.Fields) ([]byte, error) {
+		var buff bytes.Buffer
+		if err := format.Execute(&buff, fields); err != nil {
+			return nil, err
+		}
+		text := buff.String()
+		if kind == Teams {
+			return json.Marshal(map[string]string{
+				"@type":    "MessageCard",
+				"@context": "https://schema.org/extensions",
+				"summary":  summary(text),
+				"text":     text,
+			})
+		}
+		return json.Marshal(map[string]string{"text": text})
+	}
+}
+
+// summary returns the first line of the text, shortened to summaryLength characters.
+func summary(text string) string {
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	if runes := []rune(text); len(runes) > summaryLength {
+		text = string(runes[:summaryLength-1]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,92 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/server/forward"
+)
+
+func TestParseRoute(t *testing.T) {
+	a := assert.New(t)
+
+	route, err := ParseRoute("/alerts/db=slack:https://hooks.slack.com/services/T0/B0/secret")
+	a.NoError(err)
+	a.Equal(Route{Topic: "/alerts/db", Kind: Slack, URL: "https://hooks.slack.com/services/T0/B0/secret"}, route)
+
+	route, err = ParseRoute("/alerts=teams:https://example.webhook.office.com/webhookb2/secret")
+	a.NoError(err)
+	a.Equal(Teams, route.Kind)
+
+	for _, spec := range []string{
+		"/alerts",
+		"/alerts=https://hooks.slack.com/services/T0/B0/secret",
+		"/alerts=irc:https://example.com",
+		"/alerts=slack:http://hooks.slack.com/services/T0/B0/secret",
+		"/alerts=slack:https://example.com/{{.Topic}}",
+	} {
+		_, err := ParseRoute(spec)
+		a.Error(err, spec)
+		a.False(strings.Contains(err.Error(), "secret"), "The webhook URL should not be in the error")
+	}
+}
+
+func TestPayload(t *testing.T) {
+	a := assert.New(t)
+
+	format, err := forward.ParseTemplate("chat format", "*{{.Header.severity}}* {{.Topic}}\n{{.Body}}")
+	a.NoError(err)
+	fields := forward.Fields{
+		Topic:  "/alerts/db",
+		Header: map[string]interface{}{"severity": "critical"},
+		Body:   "The replication lag is 42s",
+	}
+
+	slack, err := payload(Slack, format)(fields)
+	a.NoError(err)
+	a.JSONEq(`{"text": "*critical* /alerts/db\nThe replication lag is 42s"}`, string(slack))
+
+	teams, err := payload(Teams, format)(fields)
+	a.NoError(err)
+	a.JSONEq(`{
+		"@type": "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary": "*critical* /alerts/db",
+		"text": "*critical* /alerts/db\nThe replication lag is 42s"
+	}`, string(teams))
+
+	_, err = payload(Slack, format)(forward.Fields{Topic: "/alerts", Header: map[string]interface{}{}})
+	a.Error(err, "A missing header should fail")
+}
+
+func TestSummary(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("first line", summary("first line\nsecond line"))
+	long := summary(strings.Repeat("ä", 100))
+	a.Equal(summaryLength, len([]rune(long)))
+	a.True(strings.HasSuffix(long, "…"))
+}
+
+func TestNew(t *testing.T) {
+	a := assert.New(t)
+
+	routes := []string{
+		"/alerts/db=slack:https://hooks.slack.com/services/T0/B0/secret",
+		"/alerts=teams:https://example.webhook.office.com/webhookb2/secret",
+	}
+	format, retries, timeout, connections := "{{.Body}}", 3, time.Second, 2
+	config := Config{Routes: &routes, Format: &format, Retries: &retries, Timeout: &timeout, Connections: &connections}
+
+	forwarders, err := New(nil, config)
+	a.NoError(err)
+	a.Len(forwarders, 2)
+
+	invalid := "{{.Body"
+	config.Format = &invalid
+	_, err = New(nil, config)
+	a.Error(err)
+}
@@ -0,0 +1,230 @@
+// Package cloudevents maps the guble messages to the CloudEvents 1.0 format, for the interoperability with
+// the consumers and producers of CloudEvents (e.g. Knative, EventBridge), in the HTTP binary mode (the attributes
+// of the event as `ce-` headers, the data as body) and in the structured mode (the event as a JSON document).
+// The attributes of an event are kept in the header of its message, prefixed by `ce_` (e.g. `{"ce_type": "order.created"}`).
+package cloudevents
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+)
+
+const (
+	// SpecVersion is the supported version of the CloudEvents specification
+	SpecVersion = "1.0"
+
+	// ContentType is the content type of an event in the structured mode
+	ContentType = "application/cloudevents+json"
+
+	// HeaderPrefix prefixes the attributes of an event in the header of its message
+	HeaderPrefix = "ce_"
+
+	// DefaultType is the type of the events of the messages which were not published as events
+	DefaultType = "guble.message"
+
+	// httpPrefix prefixes the attributes of an event in the HTTP headers, in the binary mode
+	httpPrefix = "Ce-"
+)
+
+var ErrInvalidEvent = errors.New("Invalid CloudEvent: the attributes specversion 1.0, id, source and type are required.")
+
+// Event is a CloudEvent: its context attributes (e.g. `id`, `source`, `type`, `subject`, `time`, the extensions),
+// its data and the content type of the data.
+type Event struct {
+	Attributes      map[string]string
+	DataContentType string
+	Data            []byte
+}
+
+// IsStructured returns true if the content type is the one of an event in the structured mode.
+func IsStructured(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.ToLower(mediaType) == ContentType
+}
+
+// IsBinary returns true if the HTTP headers hold an event in the binary mode.
+func IsBinary(header http.Header) bool {
+	return header.Get(httpPrefix+"Specversion") != ""
+}
+
+// ParseStructured returns the event of a JSON document.
+func ParseStructured(data []byte) (*Event, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, ErrInvalidEvent
+	}
+
+	e := &Event{Attributes: make(map[string]string)}
+	for name, raw := range fields {
+		switch name {
+		case "data":
+			e.Data = raw
+		case "data_base64":
+			var encoded string
+			if err := json.Unmarshal(raw, &encoded); err != nil {
+				return nil, ErrInvalidEvent
+			}
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, ErrInvalidEvent
+			}
+			e.Data = decoded
+		case "datacontenttype":
+			if err := json.Unmarshal(raw, &e.DataContentType); err != nil {
+				return nil, ErrInvalidEvent
+			}
+		default:
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				// the extensions can be numbers or booleans
+				s = string(raw)
+			}
+			e.Attributes[strings.ToLower(name)] = s
+		}
+	}
+
+	// the data of a non JSON content type is a JSON string
+	if _, ok := fields["data"]; ok && !isJSON(e.DataContentType) {
+		var s string
+		if err := json.Unmarshal(e.Data, &s); err != nil {
+			return nil, ErrInvalidEvent
+		}
+		e.Data = []byte(s)
+	}
+	return e, e.validate()
+}
+
+// ParseBinary returns the event of the HTTP headers and body of a request in the binary mode.
+func ParseBinary(header http.Header, body []byte) (*Event, error) {
+	e := &Event{
+		Attributes:      make(map[string]string),
+		DataContentType: header.Get("Content-Type"),
+		Data:            body,
+	}
+	for key, values := range header {
+		if len(values) > 0 && len(key) > len(httpPrefix) && strings.EqualFold(key[:len(httpPrefix)], httpPrefix) {
+			e.Attributes[strings.ToLower(key[len(httpPrefix):])] = values[0]
+		}
+	}
+	return e, e.validate()
+}
+
+func (e *Event) validate() error {
+	if e.Attributes["specversion"] != SpecVersion ||
+		e.Attributes["id"] == "" || e.Attributes["source"] == "" || e.Attributes["type"] == "" {
+		return ErrInvalidEvent
+	}
+	return nil
+}
+
+// Apply sets the data of the event and its content type as body and content type of the message,
+// and adds the attributes of the event to the header of the message.
+func (e *Event) Apply(m *protocol.Message) error {
+	header := make(map[string]interface{})
+	if m.HeaderJSON != "" {
+		if err := json.Unmarshal([]byte(m.HeaderJSON), &header); err != nil {
+			return err
+		}
+	}
+	for name, value := range e.Attributes {
+		if name != "specversion" {
+			header[HeaderPrefix+name] = value
+		}
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	m.HeaderJSON = string(data)
+	m.Body = e.Data
+	m.ContentType = e.DataContentType
+	return nil
+}
+
+// FromMessage returns the event of a message: the attributes kept in its header, the required attributes
+// of a message which was not published as an event being derived from the message (its topic and ID as source and id).
+func FromMessage(m *protocol.Message) *Event {
+	e := &Event{
+		Attributes: map[string]string{
+			"specversion": SpecVersion,
+			"id":          fmt.Sprintf("%s/%d", m.Path.Partition(), m.ID),
+			"source":      string(m.Path),
+			"type":        DefaultType,
+		},
+		DataContentType: m.ContentType,
+		Data:            m.Body,
+	}
+	if m.Time > 0 {
+		e.Attributes["time"] = time.Unix(m.Time, 0).UTC().Format(time.RFC3339)
+	}
+
+	var header map[string]interface{}
+	if m.HeaderJSON != "" && json.Unmarshal([]byte(m.HeaderJSON), &header) == nil {
+		for key, value := range header {
+			if strings.HasPrefix(key, HeaderPrefix) && len(key) > len(HeaderPrefix) {
+				e.Attributes[key[len(HeaderPrefix):]] = fmt.Sprint(value)
+			}
+		}
+	}
+	e.Attributes["specversion"] = SpecVersion
+	return e
+}
+
+// SetBinaryHeader sets the attributes of the event as HTTP headers, in the binary mode.
+func (e *Event) SetBinaryHeader(header http.Header) {
+	for name, value := range e.Attributes {
+		header.Set(httpPrefix+name, value)
+	}
+	if e.DataContentType != "" {
+		header.Set("Content-Type", e.DataContentType)
+	}
+}
+
+// MarshalStructured returns the JSON document of the event, in the structured mode.
+// The data of a JSON content type is embedded as JSON, the text data as a string, and the other data in base64.
+func (e *Event) MarshalStructured() ([]byte, error) {
+	fields := make(map[string]interface{}, len(e.Attributes)+2)
+	for name, value := range e.Attributes {
+		fields[name] = value
+	}
+	if e.DataContentType != "" {
+		fields["datacontenttype"] = e.DataContentType
+	}
+	switch {
+	case len(e.Data) == 0:
+	case isJSON(e.DataContentType) && json.Valid(e.Data):
+		fields["data"] = json.RawMessage(e.Data)
+	case isText(e.DataContentType):
+		fields["data"] = string(e.Data)
+	default:
+		fields["data_base64"] = base64.StdEncoding.EncodeToString(e.Data)
+	}
+
+	var buff bytes.Buffer
+	enc := json.NewEncoder(&buff)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fields); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buff.Bytes(), []byte("\n")), nil
+}
+
+// isJSON returns true for a JSON content type, or an unknown one (the data of an event is then JSON).
+func isJSON(contentType string) bool {
+	m := &protocol.Message{ContentType: contentType}
+	return m.IsJSON()
+}
+
+func isText(contentType string) bool {
+	m := &protocol.Message{ContentType: contentType}
+	return strings.HasPrefix(m.MediaType(), "text/") || m.MediaType() == "application/xml"
+}
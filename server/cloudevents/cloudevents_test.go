@@ -0,0 +1,156 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+)
+
+func TestIsStructured(t *testing.T) {
+	a := assert.New(t)
+
+	a.True(IsStructured("application/cloudevents+json"))
+	a.True(IsStructured("Application/CloudEvents+JSON; charset=utf-8"))
+	a.False(IsStructured("application/json"))
+	a.False(IsStructured(""))
+}
+
+func TestParseStructured(t *testing.T) {
+	a := assert.New(t)
+
+	e, err := ParseStructured([]byte(`{
+		"specversion": "1.0", "id": "42", "source": "/shop", "type": "order.created",
+		"datacontenttype": "application/json", "data": {"order": 42}, "priority": 3
+	}`))
+	a.NoError(err)
+	a.Equal("application/json", e.DataContentType)
+	a.JSONEq(`{"order": 42}`, string(e.Data))
+	a.Equal("order.created", e.Attributes["type"])
+	a.Equal("3", e.Attributes["priority"])
+
+	e, err = ParseStructured([]byte(`{"specversion": "1.0", "id": "1", "source": "/s", "type": "t",
+		"datacontenttype": "text/plain", "data": "hello"}`))
+	a.NoError(err)
+	a.Equal("hello", string(e.Data))
+
+	e, err = ParseStructured([]byte(`{"specversion": "1.0", "id": "1", "source": "/s", "type": "t",
+		"datacontenttype": "application/octet-stream", "data_base64": "AAEC"}`))
+	a.NoError(err)
+	a.Equal([]byte{0, 1, 2}, e.Data)
+
+	for _, invalid := range []string{
+		`not json`,
+		`{"specversion": "0.3", "id": "1", "source": "/s", "type": "t"}`,
+		`{"specversion": "1.0", "source": "/s", "type": "t"}`,
+		`{"specversion": "1.0", "id": "1", "source": "/s", "type": "t", "data_base64": "%%%"}`,
+	} {
+		_, err := ParseStructured([]byte(invalid))
+		a.Equal(ErrInvalidEvent, err, invalid)
+	}
+}
+
+func TestParseBinary(t *testing.T) {
+	a := assert.New(t)
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Ce-Specversion", "1.0")
+	header.Set("Ce-Id", "42")
+	header.Set("Ce-Source", "/shop")
+	header.Set("Ce-Type", "order.created")
+	header.Set("X-Other", "ignored")
+	a.True(IsBinary(header))
+
+	e, err := ParseBinary(header, []byte(`{"order": 42}`))
+	a.NoError(err)
+	a.Equal(map[string]string{"specversion": "1.0", "id": "42", "source": "/shop", "type": "order.created"}, e.Attributes)
+	a.Equal("application/json", e.DataContentType)
+
+	header.Del("Ce-Type")
+	_, err = ParseBinary(header, nil)
+	a.Equal(ErrInvalidEvent, err)
+}
+
+func TestEvent_Apply(t *testing.T) {
+	a := assert.New(t)
+
+	e := &Event{
+		Attributes:      map[string]string{"specversion": "1.0", "id": "42", "source": "/shop", "type": "order.created"},
+		DataContentType: "application/json",
+		Data:            []byte(`{"order": 42}`),
+	}
+	m := &protocol.Message{Path: "/orders", HeaderJSON: `{"shop": "berlin"}`, Body: []byte("event")}
+	a.NoError(e.Apply(m))
+	a.JSONEq(`{"shop": "berlin", "ce_id": "42", "ce_source": "/shop", "ce_type": "order.created"}`, m.HeaderJSON)
+	a.Equal(`{"order": 42}`, string(m.Body))
+	a.Equal("application/json", m.ContentType)
+}
+
+func TestFromMessage(t *testing.T) {
+	a := assert.New(t)
+
+	e := FromMessage(&protocol.Message{ID: 7, Path: "/orders/new", Time: 1420110000, ContentType: "text/plain", Body: []byte("hi")})
+	a.Equal(map[string]string{
+		"specversion": "1.0",
+		"id":          "orders/7",
+		"source":      "/orders/new",
+		"type":        DefaultType,
+		"time":        "2015-01-01T11:00:00Z",
+	}, e.Attributes)
+	a.Equal("text/plain", e.DataContentType)
+
+	// the attributes of a message published as an event are kept
+	e = FromMessage(&protocol.Message{ID: 7, Path: "/orders", HeaderJSON: `{"ce_id": "42", "ce_type": "order.created", "shop": "berlin"}`})
+	a.Equal("42", e.Attributes["id"])
+	a.Equal("order.created", e.Attributes["type"])
+	a.Equal("/orders", e.Attributes["source"])
+	a.NotContains(e.Attributes, "shop")
+}
+
+func TestEvent_SetBinaryHeader(t *testing.T) {
+	a := assert.New(t)
+
+	e := &Event{Attributes: map[string]string{"specversion": "1.0", "id": "42"}, DataContentType: "text/plain"}
+	header := http.Header{}
+	e.SetBinaryHeader(header)
+	a.Equal("1.0", header.Get("ce-specversion"))
+	a.Equal("42", header.Get("ce-id"))
+	a.Equal("text/plain", header.Get("Content-Type"))
+}
+
+func TestEvent_MarshalStructured(t *testing.T) {
+	a := assert.New(t)
+
+	attributes := map[string]string{"specversion": "1.0", "id": "42", "source": "/shop", "type": "order.created"}
+	for _, test := range []struct {
+		contentType string
+		data        []byte
+		expected    string
+	}{
+		{"application/json", []byte(`{"order": 42}`), `, "data": {"order": 42}, "datacontenttype": "application/json"`},
+		{"text/plain", []byte(`<b>hi</b>`), `, "data": "<b>hi</b>", "datacontenttype": "text/plain"`},
+		{"application/octet-stream", []byte{0, 1, 2}, `, "data_base64": "AAEC", "datacontenttype": "application/octet-stream"`},
+		{"", nil, ""},
+	} {
+		data, err := (&Event{Attributes: attributes, DataContentType: test.contentType, Data: test.data}).MarshalStructured()
+		a.NoError(err)
+		a.JSONEq(`{"specversion": "1.0", "id": "42", "source": "/shop", "type": "order.created"`+test.expected+`}`, string(data))
+
+		// the document is parsed back to the same event
+		e, err := ParseStructured(data)
+		a.NoError(err)
+		a.Equal(attributes, e.Attributes)
+		if len(test.data) > 0 && test.contentType != "application/json" {
+			a.Equal(test.data, e.Data)
+		}
+	}
+
+	var fields map[string]interface{}
+	data, _ := (&Event{Attributes: attributes}).MarshalStructured()
+	a.NoError(json.Unmarshal(data, &fields))
+	a.NotContains(fields, "data")
+}
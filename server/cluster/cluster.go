@@ -1,9 +1,11 @@
 package cluster
 
 import (
+	"io"
 	"io/ioutil"
 
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/queue"
 	"github.com/smancke/guble/server/store"
 
 	log "github.com/Sirupsen/logrus"
@@ -13,19 +15,77 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"sync"
+	"time"
 )
 
+const broadcastsCapacity = 1000
+
 var (
 	ErrNodeNotFound = errors.New("Node not found.")
 )
 
 // Config is a struct used by the local node when creating and running the guble cluster
 type Config struct {
-	ID                   uint8
-	Host                 string
-	Port                 int
+	ID   uint8
+	Host string
+	Port int
+
+	// AdvertiseHost and AdvertisePort are the address at which the other nodes reach this node,
+	// if it differs from the bound one (e.g. behind the NAT of a container); the default port is Port
+	AdvertiseHost string
+	AdvertisePort int
+
 	Remotes              []*net.TCPAddr
 	HealthScoreThreshold int
+
+	// JoinTimeout is the time during which the node retries to join the remotes when it starts,
+	// with an exponential backoff (0: the start fails if no remote is reached at the first attempt)
+	JoinTimeout time.Duration
+
+	// Replica is set for a read-only node, which receives the messages of the other nodes,
+	// but does not accept publishing
+	Replica bool
+
+	// FetchTimeout is the time during which a fetch waits for the missing messages of the other nodes
+	// (0: the fetches are served by the local store only)
+	FetchTimeout time.Duration
+
+	// Zone is the availability zone of the node, and Weight its capacity relative to the other nodes (optional):
+	// the broadcasts are sent to the nodes of the same zone first, and to the nodes of higher weight first
+	Zone   string
+	Weight int
+
+	// CrossZoneFanout is the maximum number of nodes of another zone to which a message is sent directly,
+	// which relay it to the other nodes of their zone (0: the messages are sent to all the nodes directly)
+	CrossZoneFanout int
+
+	// DataPort is the local port on which the messages of the users are replicated, separately from the memberlist port
+	// carrying the membership and the control messages (0: all the messages are sent on the memberlist port)
+	DataPort int
+
+	// DataURL is the URL of the replication websocket of this node (see ReplicationEndpoint), announced to the other nodes,
+	// which then replicate their messages over websockets, for the networks only allowing HTTP(S) between the nodes.
+	// The websockets are authenticated with the DataSecret shared by the nodes.
+	DataURL    string
+	DataSecret string
+
+	// ControlRate and DataRate are the maximum numbers of messages per second sent on the control
+	// and the data planes (0: no limit)
+	ControlRate float64
+	DataRate    float64
+
+	// Codec is the name of the codec encoding the messages sent to the other nodes: MsgpackCodec (the default)
+	// or ProtobufCodec. The messages of the other nodes are decoded whatever their codec.
+	Codec string
+
+	// Faults can drop the received messages, for testing (optional, see package chaos)
+	Faults faults
+}
+
+// faults is the part of the chaos.Injector used by the cluster.
+type faults interface {
+	DropClusterMessage() bool
 }
 
 // router interface specify only the methods we require in cluster from the Router
@@ -46,44 +106,140 @@ type Cluster struct {
 	Router router
 
 	name       string
+	codec      messageCodec
 	memberlist *memberlist.Memberlist
-	broadcasts [][]byte
+	broadcasts *queue.Queue
+
+	// nextBroadcast is the broadcast which did not fit in the last GetBroadcasts, guarded by broadcastsMu
+	nextBroadcast []byte
+	broadcastsMu  sync.Mutex
 
 	numJoins   int
 	numLeaves  int
 	numUpdates int
 
+	// metas are the metadata of the members, by node name, kept by the memberlist events
+	// (memberlist.Members can not be called from the delegates, which are notified holding the lock of the nodes)
+	metas   map[string]nodeMeta
+	metasMu sync.Mutex
+
 	synchronizer *synchronizer
+
+	// the control and data planes of the sent messages, and the connections of the data port
+	control       *plane
+	data          *plane
+	dataListener  net.Listener
+	dataMu        sync.Mutex
+	dataConns     map[string]*dataConn
+	dataReceivers map[io.Closer]struct{}
+	wsConns       map[string]*wsDataConn
+
+	// rejoinC signals that the node may have lost all its peers, and stopC stops the rejoin loop
+	rejoinC chan struct{}
+	stopC   chan struct{}
+	wg      sync.WaitGroup
+
+	// the lags of the messages received from the other nodes
+	replication *replicationLags
+
+	// the pending fetches from the other nodes, by request ID
+	fetchSeq       uint64
+	fetches        map[uint64]chan *fetchResponse
+	fetchesMu      sync.Mutex
+	storeMissingMu sync.Mutex
 }
 
-//New returns a new instance of the cluster, created using the given Config.
+// New returns a new instance of the cluster, created using the given Config.
 func New(config *Config) (*Cluster, error) {
+	if config.DataURL != "" && config.DataSecret == "" {
+		logger.WithField("dataURL", config.DataURL).Error(errMissingDataSecret.Error())
+		return nil, errMissingDataSecret
+	}
+	codec, err := codecByName(config.Codec)
+	if err != nil {
+		logger.WithField("codec", config.Codec).Error("Unknown codec of the cluster messages")
+		return nil, err
+	}
+
 	c := &Cluster{
 		Config: config,
 		name:   fmt.Sprintf("%d", config.ID),
+		codec:  codec,
+		broadcasts: queue.MustNew(queue.Config{
+			Name:     "cluster_broadcasts",
+			Capacity: broadcastsCapacity,
+			Policy:   queue.DropPolicy,
+		}),
+		fetches:     make(map[uint64]chan *fetchResponse),
+		metas:       make(map[string]nodeMeta),
+		replication: newReplicationLags(),
+		rejoinC:     make(chan struct{}, 1),
+		stopC:       make(chan struct{}),
+
+		control:       newPlane(controlPlaneSenders, config.ControlRate),
+		data:          newPlane(dataPlaneSenders, config.DataRate),
+		dataConns:     make(map[string]*dataConn),
+		dataReceivers: make(map[io.Closer]struct{}),
+		wsConns:       make(map[string]*wsDataConn),
 	}
 
 	memberlistConfig := memberlist.DefaultLANConfig()
 	memberlistConfig.Name = c.name
 	memberlistConfig.BindAddr = config.Host
 	memberlistConfig.BindPort = config.Port
+	addr, port, err := advertiseAddr(config)
+	if err != nil {
+		logger.WithField("error", err).Error("Invalid advertise address of the cluster node")
+		return nil, err
+	}
+	if addr != "" {
+		logger.WithField("addr", net.JoinHostPort(addr, strconv.Itoa(port))).Info("Advertising the cluster node")
+		memberlistConfig.AdvertiseAddr = addr
+		memberlistConfig.AdvertisePort = port
+	}
 
 	//TODO Cosmin temporarily disabling any logging from memberlist, we might want to enable it again using logrus?
 	memberlistConfig.LogOutput = ioutil.Discard
 
+	// the metadata of this node is announced when it is created, and the incompatible nodes are refused
+	memberlistConfig.Delegate = c
+	memberlistConfig.Alive = c
+
 	ml, err := memberlist.Create(memberlistConfig)
 	if err != nil {
 		logger.WithField("error", err).Error("Error when creating the internal memberlist of the cluster")
 		return nil, err
 	}
 	c.memberlist = ml
-	memberlistConfig.Delegate = c
 	memberlistConfig.Conflict = c
 	memberlistConfig.Events = c
 
 	return c, nil
 }
 
+// advertiseAddr returns the IP and port advertised to the other nodes (an empty IP, if the bound address is advertised).
+// The advertise host may be a name, resolved when the node is created.
+func advertiseAddr(config *Config) (string, int, error) {
+	if config.AdvertiseHost == "" {
+		if config.AdvertisePort > 0 {
+			return "", 0, errors.New("The advertise port requires an advertise host.")
+		}
+		return "", 0, nil
+	}
+	port := config.AdvertisePort
+	if port <= 0 {
+		port = config.Port
+	}
+	if ip := net.ParseIP(config.AdvertiseHost); ip != nil {
+		return ip.String(), port, nil
+	}
+	ipAddr, err := net.ResolveIPAddr("ip", config.AdvertiseHost)
+	if err != nil {
+		return "", 0, err
+	}
+	return ipAddr.IP.String(), port, nil
+}
+
 // Start the cluster module.
 func (cluster *Cluster) Start() error {
 	logger.WithField("remotes", cluster.Config.Remotes).Debug("Starting Cluster")
@@ -101,17 +257,18 @@ func (cluster *Cluster) Start() error {
 	}
 	cluster.synchronizer = synchronizer
 
-	num, err := cluster.memberlist.Join(cluster.remotesAsStrings())
-	if err != nil {
-		logger.WithField("error", err).Error("Error when this node wanted to join the cluster")
+	if err := cluster.listenData(); err != nil {
+		logger.WithError(err).Error("Error listening on the data port of the cluster node")
 		return err
 	}
-	if num == 0 {
-		errorMessage := "No remote hosts were successfully contacted when this node wanted to join the cluster"
-		logger.WithField("remotes", cluster.remotesAsStrings()).Error(errorMessage)
-		return errors.New(errorMessage)
+
+	if err := cluster.joinWithRetries(); err != nil {
+		return err
 	}
 
+	cluster.wg.Add(1)
+	go cluster.rejoinLoop()
+
 	logger.Debug("Started Cluster")
 
 	return nil
@@ -122,7 +279,11 @@ func (cluster *Cluster) Stop() error {
 	if cluster.synchronizer != nil {
 		close(cluster.synchronizer.stopC)
 	}
-	return cluster.memberlist.Shutdown()
+	close(cluster.stopC)
+	err := cluster.memberlist.Shutdown()
+	cluster.closeData()
+	cluster.wg.Wait()
+	return err
 }
 
 // Check returns a non-nil error if the health status of the cluster (as seen by this node) is not perfect.
@@ -181,17 +342,25 @@ func (cluster *Cluster) broadcastClusterMessage(cMessage *message) error {
 		return errors.New(errorMessage)
 	}
 
-	cMessageBytes, err := cMessage.encode()
+	cMessageBytes, err := cluster.encodeMessage(cMessage)
 	if err != nil {
 		logger.WithError(err).Error("Could not encode and broadcast cluster-message")
 		return err
 	}
 
-	for _, node := range cluster.memberlist.Members() {
-		if cluster.name == node.Name {
+	// only the guble messages are relayed across the zones
+	deliveries, nodes := cluster.broadcastPlan(cMessage.Type == mtGubleMessage)
+	for _, d := range deliveries {
+		if len(d.relayTo) == 0 {
+			go cluster.sendToNode(nodes[d.to], cMessageBytes)
 			continue
 		}
-		go cluster.sendToNode(node, cMessageBytes)
+		rmsg, err := cluster.newEncoderMessage(mtRelayMessage, &relayMessage{Targets: d.relayTo, Message: cMessage.Body})
+		if err != nil {
+			logger.WithError(err).Error("Could not encode the cluster relay message")
+			return err
+		}
+		go cluster.sendMessageToNode(nodes[d.to], rmsg)
 	}
 	return nil
 }
@@ -202,7 +371,7 @@ func (cluster *Cluster) sendToNode(node *memberlist.Node, msgBytes []byte) error
 		"to":   node.Name,
 	}).Debug("Sending cluster-message to a node")
 
-	err := cluster.memberlist.SendToTCP(node, msgBytes)
+	err := cluster.transmit(node, true, msgBytes)
 	if err != nil {
 		logger.WithFields(log.Fields{
 			"err":  err,
@@ -218,13 +387,13 @@ func (cluster *Cluster) sendToNode(node *memberlist.Node, msgBytes []byte) error
 func (cluster *Cluster) sendMessageToNode(node *memberlist.Node, cmsg *message) error {
 	logger.WithField("node", node.Name).Debug("Sending message to a node")
 
-	bytes, err := cmsg.encode()
+	bytes, err := cluster.encodeMessage(cmsg)
 	if err != nil {
 		logger.WithError(err).Error("Could not encode and broadcast cluster-message")
 		return err
 	}
 
-	if err = cluster.memberlist.SendToTCP(node, bytes); err != nil {
+	if err = cluster.transmit(node, isDataPlane(cmsg.Type), bytes); err != nil {
 		logger.WithField("node", node.Name).WithError(err).Error("Error send message to node")
 		return err
 	}
@@ -241,6 +410,16 @@ func (cluster *Cluster) sendMessageToNodeID(nodeID uint8, cmsg *message) error {
 	return cluster.sendMessageToNode(node, cmsg)
 }
 
+// otherNodes returns the members of the cluster, except this node.
+func (cluster *Cluster) otherNodes() (nodes []*memberlist.Node) {
+	for _, node := range cluster.memberlist.Members() {
+		if node.Name != cluster.name {
+			nodes = append(nodes, node)
+		}
+	}
+	return
+}
+
 func (cluster *Cluster) GetNodeByID(id uint8) *memberlist.Node {
 	name := strconv.FormatUint(uint64(id), 10)
 	for _, node := range cluster.memberlist.Members() {
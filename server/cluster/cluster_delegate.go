@@ -1,9 +1,12 @@
 package cluster
 
 import (
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/monitor"
 )
 
 // ======================================================
@@ -15,8 +18,12 @@ import (
 func (cluster *Cluster) NotifyMsg(data []byte) {
 	logger.WithField("msgAsBytes", data).Debug("NotifyMsg")
 
-	cmsg := new(message)
-	err := cmsg.decode(data)
+	if cluster.Config.Faults != nil && cluster.Config.Faults.DropClusterMessage() {
+		logger.Debug("NotifyMsg: Dropped cluster message")
+		return
+	}
+
+	cmsg, err := decodeMessage(data)
 	if err != nil {
 		logger.WithError(err).Error("Decoding of cluster message failed")
 		return
@@ -37,17 +44,50 @@ func (cluster *Cluster) NotifyMsg(data []byte) {
 	case mtSyncMessageRequest:
 		// cluster node is requesting to receive messages for sync
 		cluster.handleSyncMessageRequest(cmsg)
+	case mtFetchRequest:
+		cluster.handleFetchRequest(cmsg)
+	case mtFetchResponse:
+		cluster.handleFetchResponse(cmsg)
+	case mtRelayMessage:
+		cluster.handleRelayMessage(cmsg)
 	}
 }
 
+// GetBroadcasts returns the buffered broadcasts, within the given size limit.
+// A broadcast which does not fit is kept for the next call, unless it exceeds the limit on its own.
 func (cluster *Cluster) GetBroadcasts(overhead, limit int) [][]byte {
-	b := cluster.broadcasts
-	cluster.broadcasts = nil
-	return b
+	cluster.broadcastsMu.Lock()
+	defer cluster.broadcastsMu.Unlock()
+
+	var (
+		b    [][]byte
+		size int
+	)
+	for {
+		data := cluster.nextBroadcast
+		if data == nil {
+			select {
+			case e := <-cluster.broadcasts.C():
+				data = cluster.broadcasts.Take(e).([]byte)
+			default:
+				return b
+			}
+		}
+		cluster.nextBroadcast = nil
+		if size+overhead+len(data) <= limit {
+			b = append(b, data)
+			size += overhead + len(data)
+			continue
+		}
+		if len(b) == 0 {
+			logger.WithField("size", len(data)).WithField("limit", limit).Error("Dropped a broadcast exceeding the size limit")
+			continue
+		}
+		cluster.nextBroadcast = data
+		return b
+	}
 }
 
-func (cluster *Cluster) NodeMeta(limit int) []byte { return nil }
-
 func (cluster *Cluster) LocalState(join bool) []byte { return nil }
 
 func (cluster *Cluster) MergeRemoteState(s []byte, join bool) {}
@@ -62,6 +102,7 @@ func (cluster *Cluster) handleGubleMessage(cmsg *message) {
 		logger.WithField("err", err).Error("Parsing of guble-message contained in cluster-message failed")
 		return
 	}
+	cluster.replication.observe(cmsg.NodeID, message.Path.Partition(), monitor.MessageAge(message.Time, time.Now()))
 	cluster.Router.HandleMessage(message)
 }
 
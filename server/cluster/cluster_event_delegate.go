@@ -1,6 +1,8 @@
 package cluster
 
 import (
+	"strconv"
+
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/hashicorp/memberlist"
@@ -13,6 +15,7 @@ import (
 func (cluster *Cluster) NotifyJoin(node *memberlist.Node) {
 	cluster.numJoins++
 	cluster.eventLog(node, "Cluster Node Join")
+	cluster.setNodeMeta(node)
 
 	cluster.sendPartitions(node)
 }
@@ -20,11 +23,18 @@ func (cluster *Cluster) NotifyJoin(node *memberlist.Node) {
 func (cluster *Cluster) NotifyLeave(node *memberlist.Node) {
 	cluster.numLeaves++
 	cluster.eventLog(node, "Cluster Node Leave")
+	cluster.removeNodeMeta(node)
+
+	if id, err := strconv.ParseUint(node.Name, 10, 8); err == nil {
+		cluster.replication.remove(uint8(id))
+	}
+	cluster.lostPeers()
 }
 
 func (cluster *Cluster) NotifyUpdate(node *memberlist.Node) {
 	cluster.numUpdates++
 	cluster.eventLog(node, "Cluster Node Update")
+	cluster.setNodeMeta(node)
 }
 
 func (cluster *Cluster) eventLog(node *memberlist.Node, message string) {
@@ -201,6 +201,25 @@ func TestCluster_NotifyMsgShouldSimplyReturnWhenDecodingInvalidMessage(t *testin
 	//TODO Cosmin check that HandleMessage is not invoked (i.e. invalid message is not dispatched)
 }
 
+func TestCluster_GetBroadcastsWithinLimit(t *testing.T) {
+	a := assert.New(t)
+
+	config := testConfig()
+	node, err := New(&config)
+	a.NoError(err)
+
+	for _, data := range []string{"aaaa", "bbbb", "cccccccccccccccccccc", "dddd"} {
+		a.NoError(node.broadcasts.Push([]byte(data)))
+	}
+
+	// the third broadcast does not fit, and is kept for the next call
+	a.Equal([][]byte{[]byte("aaaa"), []byte("bbbb")}, node.GetBroadcasts(2, 15))
+
+	// a broadcast exceeding the limit on its own is dropped
+	a.Equal([][]byte{[]byte("dddd")}, node.GetBroadcasts(2, 15))
+	a.Nil(node.GetBroadcasts(2, 15))
+}
+
 func TestCluster_broadcastClusterMessage(t *testing.T) {
 	a := assert.New(t)
 
@@ -238,3 +257,24 @@ func (_ *dummyRouter) HandleMessage(pmsg *protocol.Message) error {
 func (d *dummyRouter) MessageStore() (store.MessageStore, error) {
 	return d.store, nil
 }
+
+func TestCluster_advertiseAddr(t *testing.T) {
+	a := assert.New(t)
+
+	addr, port, err := advertiseAddr(&Config{Port: 10000})
+	a.NoError(err)
+	a.Equal("", addr)
+
+	addr, port, err = advertiseAddr(&Config{Port: 10000, AdvertiseHost: "203.0.113.10"})
+	a.NoError(err)
+	a.Equal("203.0.113.10", addr)
+	a.Equal(10000, port)
+
+	addr, port, err = advertiseAddr(&Config{Port: 10000, AdvertiseHost: "localhost", AdvertisePort: 30000})
+	a.NoError(err)
+	a.NotNil(net.ParseIP(addr))
+	a.Equal(30000, port)
+
+	_, _, err = advertiseAddr(&Config{Port: 10000, AdvertisePort: 30000})
+	a.Error(err)
+}
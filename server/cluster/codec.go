@@ -1,6 +1,8 @@
 package cluster
 
 import (
+	"fmt"
+
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/ugorji/go/codec"
@@ -26,6 +28,15 @@ const (
 	mtSyncMessage
 
 	mtStringMessage
+
+	// Sent to request the messages of a node matching the fetch of a client
+	mtFetchRequest
+
+	// Sent in response to a fetch request, contains the matching messages of the node
+	mtFetchResponse
+
+	// Sent to a node of another zone, contains a guble message to pass on to other nodes of its zone
+	mtRelayMessage
 )
 
 type encoder interface {
@@ -46,18 +57,23 @@ func (cmsg *message) encode() ([]byte, error) {
 	logger.WithFields(log.Fields{
 		"nodeID": cmsg.NodeID,
 		"type":   cmsg.Type,
-		"body":   string(cmsg.Body),
+		"size":   len(cmsg.Body),
 	}).Debug("Encoding cluster message")
 	return encode(cmsg)
 }
 
+// String returns the cluster message for the logs, without its body.
+func (cmsg *message) String() string {
+	return fmt.Sprintf("{NodeID: %d, Type: %d, size: %d}", cmsg.NodeID, cmsg.Type, len(cmsg.Body))
+}
+
 func (cmsg *message) decode(data []byte) error {
-	logger.WithField("data", string(data)).Debug("decode")
+	logger.WithField("size", len(data)).Debug("decode")
 	return decode(cmsg, data)
 }
 
 func encode(entity interface{}) ([]byte, error) {
-	logger.WithField("entity", entity).Debug("Encoding")
+	logger.WithField("entity", fmt.Sprintf("%T", entity)).Debug("Encoding")
 
 	var bytes []byte
 	encoder := codec.NewEncoderBytes(&bytes, h)
@@ -72,7 +88,7 @@ func encode(entity interface{}) ([]byte, error) {
 }
 
 func decode(o interface{}, data []byte) error {
-	logger.WithField("data", string(data)).Debug("Decoding")
+	logger.WithField("size", len(data)).Debug("Decoding")
 
 	decoder := codec.NewDecoderBytes(data, h)
 
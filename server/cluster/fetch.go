@@ -0,0 +1,241 @@
+package cluster
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/server/store"
+)
+
+// DefaultFetchTimeout is the default time during which a node waits for the messages of the other nodes,
+// when serving a fetch.
+const DefaultFetchTimeout = 500 * time.Millisecond
+
+// fetchRequest is sent to the other nodes, for retrieving their messages matching the fetch of a client.
+type fetchRequest struct {
+	RequestID uint64
+	Partition string
+	StartID   uint64
+	EndID     uint64
+	Direction store.FetchDirection
+	Count     int
+}
+
+func (fr *fetchRequest) encode() ([]byte, error) {
+	return encode(fr)
+}
+
+func (fr *fetchRequest) decode(data []byte) error {
+	return decode(fr, data)
+}
+
+// storeRequest returns a new request fetching the same messages in a message store.
+func (fr *fetchRequest) storeRequest() *store.FetchRequest {
+	req := store.NewFetchRequest(fr.Partition, fr.StartID, fr.EndID, fr.Direction, fr.Count)
+	req.Init()
+	return req
+}
+
+// fetchResponse is the answer of a node to a fetchRequest, with its matching messages.
+type fetchResponse struct {
+	RequestID uint64
+	Messages  []fetchedMessage
+}
+
+type fetchedMessage struct {
+	ID      uint64
+	Message []byte
+}
+
+func (fr *fetchResponse) encode() ([]byte, error) {
+	return encode(fr)
+}
+
+func (fr *fetchResponse) decode(data []byte) error {
+	return decode(fr, data)
+}
+
+// FetchMissing retrieves the messages of the other nodes matching the fetch request, and stores the ones missing
+// from the local store (e.g. stored before this node joined the cluster), so that the request can then be served
+// by the local store without gaps. The other nodes are waited for at most the fetch timeout of the config.
+// It returns the number of messages added to the local store.
+func (cluster *Cluster) FetchMissing(req *store.FetchRequest) (int, error) {
+	nodes := cluster.otherNodes()
+	if cluster.Config.FetchTimeout <= 0 || len(nodes) == 0 {
+		return 0, nil
+	}
+
+	fr := &fetchRequest{
+		RequestID: atomic.AddUint64(&cluster.fetchSeq, 1),
+		Partition: req.Partition,
+		StartID:   req.StartID,
+		EndID:     req.EndID,
+		Direction: req.Direction,
+		Count:     req.Count,
+	}
+	cmsg, err := cluster.newEncoderMessage(mtFetchRequest, fr)
+	if err != nil {
+		logger.WithError(err).Error("Error creating cluster fetch request")
+		return 0, err
+	}
+
+	responsesC := make(chan *fetchResponse, len(nodes))
+	cluster.fetchesMu.Lock()
+	cluster.fetches[fr.RequestID] = responsesC
+	cluster.fetchesMu.Unlock()
+	defer func() {
+		cluster.fetchesMu.Lock()
+		delete(cluster.fetches, fr.RequestID)
+		cluster.fetchesMu.Unlock()
+	}()
+
+	sent := 0
+	for _, node := range nodes {
+		if err := cluster.sendMessageToNode(node, cmsg); err == nil {
+			sent++
+		}
+	}
+
+	remote := make(map[uint64][]byte)
+	timer := time.NewTimer(cluster.Config.FetchTimeout)
+	defer timer.Stop()
+WAIT:
+	for received := 0; received < sent; received++ {
+		select {
+		case resp := <-responsesC:
+			for _, m := range resp.Messages {
+				remote[m.ID] = m.Message
+			}
+		case <-timer.C:
+			logger.WithFields(log.Fields{
+				"partition": fr.Partition,
+				"received":  received,
+				"nodes":     sent,
+			}).Warn("Timeout waiting for the messages of the other nodes")
+			break WAIT
+		}
+	}
+	if len(remote) == 0 {
+		return 0, nil
+	}
+
+	return cluster.storeMissing(fr, remote)
+}
+
+// storeMissing stores the messages received from the other nodes, which are not in the local store yet.
+func (cluster *Cluster) storeMissing(fr *fetchRequest, remote map[uint64][]byte) (int, error) {
+	messageStore, err := cluster.Router.MessageStore()
+	if err != nil {
+		return 0, err
+	}
+
+	// concurrent fetches must not store the same messages twice
+	cluster.storeMissingMu.Lock()
+	defer cluster.storeMissingMu.Unlock()
+
+	local, err := fetchAll(messageStore, fr.storeRequest())
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range local {
+		delete(remote, m.ID)
+	}
+
+	ids := make([]uint64, 0, len(remote))
+	for id := range remote {
+		ids = append(ids, id)
+	}
+	sort.Sort(idList(ids))
+
+	for i, id := range ids {
+		if err := messageStore.Store(fr.Partition, id, remote[id]); err != nil {
+			logger.WithError(err).WithField("messageID", id).Error("Error storing a message fetched from the cluster")
+			return i, err
+		}
+	}
+	if len(ids) > 0 {
+		logger.WithFields(log.Fields{
+			"partition": fr.Partition,
+			"count":     len(ids),
+		}).Info("Stored the missing messages fetched from the cluster")
+	}
+	return len(ids), nil
+}
+
+// handles message received with type `mtFetchRequest`, answering with the matching messages of the local store
+func (cluster *Cluster) handleFetchRequest(cmsg *message) {
+	fr := &fetchRequest{}
+	if err := fr.decode(cmsg.Body); err != nil {
+		logger.WithError(err).Error("Error decoding cluster fetch request")
+		return
+	}
+
+	go func() {
+		resp := &fetchResponse{RequestID: fr.RequestID}
+		messageStore, err := cluster.Router.MessageStore()
+		if err == nil {
+			var fetched []*store.FetchedMessage
+			fetched, err = fetchAll(messageStore, fr.storeRequest())
+			for _, m := range fetched {
+				resp.Messages = append(resp.Messages, fetchedMessage{ID: m.ID, Message: m.Message})
+			}
+		}
+		if err != nil {
+			// an empty response, so that the requesting node does not wait for the timeout
+			logger.WithError(err).WithField("partition", fr.Partition).Error("Error fetching messages for a node")
+		}
+
+		rmsg, err := cluster.newEncoderMessage(mtFetchResponse, resp)
+		if err != nil {
+			logger.WithError(err).Error("Error creating cluster fetch response")
+			return
+		}
+		if err := cluster.sendMessageToNodeID(cmsg.NodeID, rmsg); err != nil {
+			logger.WithError(err).WithField("nodeID", cmsg.NodeID).Error("Error sending cluster fetch response")
+		}
+	}()
+}
+
+// handles message received with type `mtFetchResponse`, passing it to the waiting fetch (if not timed out)
+func (cluster *Cluster) handleFetchResponse(cmsg *message) {
+	resp := &fetchResponse{}
+	if err := resp.decode(cmsg.Body); err != nil {
+		logger.WithError(err).Error("Error decoding cluster fetch response")
+		return
+	}
+
+	cluster.fetchesMu.Lock()
+	defer cluster.fetchesMu.Unlock()
+	if responsesC, ok := cluster.fetches[resp.RequestID]; ok {
+		// buffered for a response of each node
+		responsesC <- resp
+	}
+}
+
+// fetchAll returns all the messages of a fetch request in a message store.
+func fetchAll(messageStore store.MessageStore, req *store.FetchRequest) ([]*store.FetchedMessage, error) {
+	messageStore.Fetch(req)
+
+	var messages []*store.FetchedMessage
+	for {
+		select {
+		case <-req.StartC:
+		case m, open := <-req.MessageC:
+			if !open {
+				return messages, nil
+			}
+			messages = append(messages, m)
+		case err := <-req.ErrorC:
+			return messages, err
+		}
+	}
+}
+
+type idList []uint64
+
+func (l idList) Len() int           { return len(l) }
+func (l idList) Less(i, j int) bool { return l[i] < l[j] }
+func (l idList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
@@ -0,0 +1,66 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/server/store"
+)
+
+func TestCluster_FetchMissing(t *testing.T) {
+	a := assert.New(t)
+
+	config1 := testConfig()
+	config1.FetchTimeout = time.Second
+	node1, err := New(&config1)
+	a.NoError(err)
+	router1 := newDummyRouter(t)
+	node1.Router = router1
+	defer node1.Stop()
+	a.NoError(node1.Start())
+
+	config2 := testConfigAnother()
+	config2.FetchTimeout = time.Second
+	node2, err := New(&config2)
+	a.NoError(err)
+	router2 := newDummyRouter(t)
+	node2.Router = router2
+	defer node2.Stop()
+	a.NoError(node2.Start())
+
+	// the messages stored by node 1 only, and one of them by node 2 as well
+	a.NoError(router1.store.Store("foo", 1, []byte("one")))
+	a.NoError(router1.store.Store("foo", 2, []byte("two")))
+	a.NoError(router1.store.Store("foo", 3, []byte("three")))
+	a.NoError(router2.store.Store("foo", 2, []byte("two")))
+
+	req := store.NewFetchRequest("foo", 1, 0, store.DirectionForward, -1)
+	n, err := node2.FetchMissing(req)
+	a.NoError(err)
+	a.Equal(2, n)
+
+	local := store.NewFetchRequest("foo", 1, 0, store.DirectionForward, -1)
+	local.Init()
+	messages, err := fetchAll(router2.store, local)
+	a.NoError(err)
+	if a.Len(messages, 3) {
+		a.Equal(uint64(1), messages[0].ID)
+		a.Equal("one", string(messages[0].Message))
+		a.Equal(uint64(3), messages[2].ID)
+		a.Equal("three", string(messages[2].Message))
+	}
+
+	// nothing is missing anymore
+	n, err = node2.FetchMissing(req)
+	a.NoError(err)
+	a.Equal(0, n)
+
+	// the fetches are disabled without timeout
+	node2.Config.FetchTimeout = 0
+	a.NoError(router1.store.Store("foo", 4, []byte("four")))
+	n, err = node2.FetchMissing(req)
+	a.NoError(err)
+	a.Equal(0, n)
+}
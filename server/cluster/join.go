@@ -0,0 +1,107 @@
+package cluster
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jpillora/backoff"
+)
+
+// DefaultJoinTimeout is the default time during which a node retries to join the cluster when it starts.
+const DefaultJoinTimeout = time.Minute
+
+var (
+	// joinBackoffMin and joinBackoffMax bound the exponential backoff between the join attempts
+	joinBackoffMin = 500 * time.Millisecond
+	joinBackoffMax = 30 * time.Second
+
+	errNoRemoteJoined = errors.New("No remote hosts were successfully contacted when this node wanted to join the cluster")
+	errJoinStopped    = errors.New("The cluster was stopped while this node wanted to join it")
+)
+
+func newJoinBackoff() *backoff.Backoff {
+	return &backoff.Backoff{
+		Min:    joinBackoffMin,
+		Max:    joinBackoffMax,
+		Factor: 2,
+		Jitter: true,
+	}
+}
+
+// join contacts the remotes once, returning an error if none of them was reached.
+func (cluster *Cluster) join() error {
+	num, err := cluster.memberlist.Join(cluster.remotesAsStrings())
+	if err != nil {
+		logger.WithField("error", err).Error("Error when this node wanted to join the cluster")
+		return err
+	}
+	if num == 0 {
+		logger.WithField("remotes", cluster.remotesAsStrings()).Error(errNoRemoteJoined.Error())
+		return errNoRemoteJoined
+	}
+	return nil
+}
+
+// joinWithRetries retries to join the cluster with an exponential backoff and jitter, during the join timeout.
+// It returns the error of the last attempt if the node could not join meanwhile.
+func (cluster *Cluster) joinWithRetries() error {
+	deadline := time.Now().Add(cluster.Config.JoinTimeout)
+	b := newJoinBackoff()
+	for attempt := 1; ; attempt++ {
+		err := cluster.join()
+		// without remotes, the node can only join itself
+		if err == nil || len(cluster.Config.Remotes) == 0 {
+			return err
+		}
+		d := b.Duration()
+		if time.Now().Add(d).After(deadline) {
+			return err
+		}
+		logger.WithField("attempt", attempt).Warn("Retrying to join the cluster in ", d)
+		select {
+		case <-time.After(d):
+		case <-cluster.stopC:
+			return errJoinStopped
+		}
+	}
+}
+
+// lostPeers requests the node to join the cluster again, without blocking.
+// It is called when a node leaves the cluster, and the rejoin is only attempted if no other node is left.
+func (cluster *Cluster) lostPeers() {
+	select {
+	case cluster.rejoinC <- struct{}{}:
+	default:
+	}
+}
+
+// rejoinLoop joins the cluster again when this node lost all its peers (e.g. after a network partition),
+// retrying with an exponential backoff and jitter until it succeeds, or the cluster is stopped.
+func (cluster *Cluster) rejoinLoop() {
+	defer cluster.wg.Done()
+
+	for {
+		select {
+		case <-cluster.rejoinC:
+		case <-cluster.stopC:
+			return
+		}
+		if cluster.memberlist.NumMembers() > 1 || len(cluster.Config.Remotes) == 0 {
+			continue
+		}
+
+		logger.Warn("This node lost all the other nodes of the cluster, rejoining it")
+		b := newJoinBackoff()
+		for cluster.memberlist.NumMembers() <= 1 {
+			if err := cluster.join(); err == nil {
+				logger.WithField("members", cluster.memberlist.NumMembers()).Info("Rejoined the cluster")
+				break
+			}
+			select {
+			case <-time.After(b.Duration()):
+			case <-cluster.stopC:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package cluster
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fastJoinBackoff() func() {
+	min, max := joinBackoffMin, joinBackoffMax
+	joinBackoffMin, joinBackoffMax = 10*time.Millisecond, 100*time.Millisecond
+	return func() {
+		joinBackoffMin, joinBackoffMax = min, max
+	}
+}
+
+func TestCluster_StartRetriesToJoinUntilRemoteStarts(t *testing.T) {
+	a := assert.New(t)
+	defer fastJoinBackoff()()
+
+	portA, portB := basePort+index, basePort+index+1
+	index += 2
+
+	configA := Config{ID: 1, Host: "127.0.0.1", Port: portA, JoinTimeout: 5 * time.Second,
+		Remotes: []*net.TCPAddr{{IP: []byte{127, 0, 0, 1}, Port: portB}}}
+	nodeA, err := New(&configA)
+	a.NoError(err)
+	nodeA.Router = newDummyRouter(t)
+	defer nodeA.Stop()
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- nodeA.Start()
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	configB := Config{ID: 2, Host: "127.0.0.1", Port: portB,
+		Remotes: []*net.TCPAddr{{IP: []byte{127, 0, 0, 1}, Port: portA}}}
+	nodeB, err := New(&configB)
+	a.NoError(err)
+	nodeB.Router = newDummyRouter(t)
+	defer nodeB.Stop()
+	a.NoError(nodeB.Start())
+
+	select {
+	case err := <-errC:
+		a.NoError(err, "The node should join the remote once it is started")
+		a.Equal(2, nodeA.memberlist.NumMembers())
+	case <-time.After(5 * time.Second):
+		a.Fail("The node did not join the cluster")
+	}
+}
+
+func TestCluster_StartFailsAfterJoinTimeout(t *testing.T) {
+	a := assert.New(t)
+	defer fastJoinBackoff()()
+
+	config := Config{ID: 1, Host: "127.0.0.1", Port: basePort + index, JoinTimeout: 200 * time.Millisecond,
+		Remotes: []*net.TCPAddr{{IP: []byte{127, 0, 0, 1}, Port: basePort + index + 1}}}
+	index += 2
+	node, err := New(&config)
+	a.NoError(err)
+	node.Router = newDummyRouter(t)
+	defer node.Stop()
+
+	start := time.Now()
+	a.Error(node.Start())
+	a.True(time.Since(start) < 2*time.Second, "The join should not be retried after the timeout")
+
+	// the rejoin requests do not block
+	node.lostPeers()
+	node.lostPeers()
+}
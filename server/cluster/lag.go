@@ -0,0 +1,59 @@
+package cluster
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/server/monitor"
+)
+
+// replicationLags keeps the last replication lag of each partition, by peer: the age of the last message
+// received from the peer, when it was received (relying on the synchronized clocks of the nodes).
+type replicationLags struct {
+	sync.Mutex
+	lags map[uint8]map[string]time.Duration
+}
+
+func newReplicationLags() *replicationLags {
+	return &replicationLags{lags: make(map[uint8]map[string]time.Duration)}
+}
+
+func (r *replicationLags) observe(nodeID uint8, partition string, lag time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	partitions, ok := r.lags[nodeID]
+	if !ok {
+		partitions = make(map[string]time.Duration)
+		r.lags[nodeID] = partitions
+	}
+	partitions[partition] = lag
+}
+
+func (r *replicationLags) remove(nodeID uint8) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.lags, nodeID)
+}
+
+// Lags returns the replication lags of the partitions, by peer (named "node<ID>").
+// It is a part of the monitor.LagReporter implementation.
+func (cluster *Cluster) Lags() []monitor.Lag {
+	r := cluster.replication
+	r.Lock()
+	defer r.Unlock()
+
+	var lags []monitor.Lag
+	for nodeID, partitions := range r.lags {
+		for partition, lag := range partitions {
+			lags = append(lags, monitor.Lag{
+				Kind:      monitor.ReplicationLag,
+				Source:    "node" + strconv.Itoa(int(nodeID)),
+				Partition: partition,
+				Lag:       lag,
+			})
+		}
+	}
+	return lags
+}
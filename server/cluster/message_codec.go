@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// MsgpackCodec is the name of the codec encoding the cluster messages with msgpack, as all guble versions do.
+	MsgpackCodec = "msgpack"
+
+	// ProtobufCodec is the name of the codec encoding the cluster messages with protobuf (see message.proto).
+	ProtobufCodec = "protobuf"
+
+	// protocolVersion is the version of the cluster protocol spoken by this node, tagging the encoded messages.
+	protocolVersion byte = 2
+
+	// tagMarker starts the encoded messages tagged with their codec and version.
+	// It is never used by msgpack, so that the untagged messages of the older nodes are recognized.
+	tagMarker byte = 0xc1
+
+	tagSize = 3
+)
+
+var errUnknownCodec = errors.New("Unknown cluster codec.")
+
+// messageCodec encodes and decodes the messages exchanged by the nodes of the cluster.
+type messageCodec interface {
+	// id is the ID of the codec, in the tag of the encoded messages
+	id() byte
+
+	encodeMessage(cmsg *message) ([]byte, error)
+	decodeMessage(cmsg *message, data []byte) error
+}
+
+// messageCodecs are the available codecs, by name.
+var messageCodecs = map[string]messageCodec{
+	MsgpackCodec:  msgpackCodec{},
+	ProtobufCodec: protobufCodec{},
+}
+
+// codecByName returns the codec with the given name (msgpack, if empty).
+func codecByName(name string) (messageCodec, error) {
+	if name == "" {
+		name = MsgpackCodec
+	}
+	c, ok := messageCodecs[name]
+	if !ok {
+		return nil, errUnknownCodec
+	}
+	return c, nil
+}
+
+// encodeMessage encodes a cluster message with the codec of the node.
+// The msgpack messages are not tagged, so that they are understood by the nodes running older guble versions
+// during a rolling upgrade; the other codecs are used once all the nodes understand them (see sendingCodec).
+func (cluster *Cluster) encodeMessage(cmsg *message) ([]byte, error) {
+	c := cluster.sendingCodec()
+	data, err := c.encodeMessage(cmsg)
+	if err != nil || c.id() == (msgpackCodec{}).id() {
+		return data, err
+	}
+	return append([]byte{tagMarker, c.id(), protocolVersion}, data...), nil
+}
+
+// decodeMessage decodes a cluster message encoded by any node, whatever its codec.
+func decodeMessage(data []byte) (*message, error) {
+	cmsg := new(message)
+	if len(data) == 0 || data[0] != tagMarker {
+		return cmsg, cmsg.decode(data)
+	}
+	if len(data) < tagSize {
+		return nil, errors.New("Truncated cluster message tag.")
+	}
+	for _, c := range messageCodecs {
+		if c.id() == data[1] {
+			if data[2] > protocolVersion {
+				logger.WithField("version", data[2]).Debug("Decoding a message of a newer cluster protocol version")
+			}
+			return cmsg, c.decodeMessage(cmsg, data[tagSize:])
+		}
+	}
+	return nil, fmt.Errorf("Unsupported cluster codec %d (version %d).", data[1], data[2])
+}
+
+// msgpackCodec is the msgpack codec, used by all the guble versions.
+type msgpackCodec struct{}
+
+func (msgpackCodec) id() byte { return 0 }
+
+func (msgpackCodec) encodeMessage(cmsg *message) ([]byte, error) {
+	return cmsg.encode()
+}
+
+func (msgpackCodec) decodeMessage(cmsg *message, data []byte) error {
+	return cmsg.decode(data)
+}
+
+// protobufCodec encodes the cluster messages in the protobuf wire format (see message.proto).
+// The bodies keep the encoding of their type.
+type protobufCodec struct{}
+
+// the field numbers of the protobuf message, and the wire types of the protobuf format
+const (
+	pbNodeID = 1
+	pbType   = 2
+	pbBody   = 3
+
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+var errInvalidProtobuf = errors.New("Invalid protobuf cluster message.")
+
+func (protobufCodec) id() byte { return 1 }
+
+func (protobufCodec) encodeMessage(cmsg *message) ([]byte, error) {
+	data := make([]byte, 0, len(cmsg.Body)+3*binary.MaxVarintLen64)
+	data = appendVarint(data, pbNodeID<<3|pbVarint)
+	data = appendVarint(data, uint64(cmsg.NodeID))
+	data = appendVarint(data, pbType<<3|pbVarint)
+	data = appendVarint(data, uint64(cmsg.Type))
+	data = appendVarint(data, pbBody<<3|pbBytes)
+	data = appendVarint(data, uint64(len(cmsg.Body)))
+	return append(data, cmsg.Body...), nil
+}
+
+// decodeMessage decodes the known fields, skipping the fields added by newer versions.
+func (protobufCodec) decodeMessage(cmsg *message, data []byte) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errInvalidProtobuf
+		}
+		data = data[n:]
+
+		var (
+			value uint64
+			bytes []byte
+		)
+		switch key & 7 {
+		case pbVarint:
+			if value, n = binary.Uvarint(data); n <= 0 {
+				return errInvalidProtobuf
+			}
+			data = data[n:]
+		case pbBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return errInvalidProtobuf
+			}
+			bytes, data = data[n:n+int(length)], data[n+int(length):]
+		case pbFixed64, pbFixed32:
+			size := 8
+			if key&7 == pbFixed32 {
+				size = 4
+			}
+			if len(data) < size {
+				return errInvalidProtobuf
+			}
+			data = data[size:]
+		default:
+			return errInvalidProtobuf
+		}
+
+		switch key >> 3 {
+		case pbNodeID:
+			cmsg.NodeID = uint8(value)
+		case pbType:
+			cmsg.Type = messageType(value)
+		case pbBody:
+			cmsg.Body = append([]byte(nil), bytes...)
+		}
+	}
+	return nil
+}
+
+func appendVarint(data []byte, value uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], value)
+	return append(data, buf[:n]...)
+}
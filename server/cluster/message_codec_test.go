@@ -0,0 +1,67 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageCodecs_RoundTrip(t *testing.T) {
+	a := assert.New(t)
+
+	cmsg := &message{NodeID: 3, Type: mtFetchRequest, Body: []byte("body")}
+	for _, name := range []string{"", MsgpackCodec, ProtobufCodec} {
+		c, err := codecByName(name)
+		a.NoError(err)
+		cluster := &Cluster{codec: c}
+
+		data, err := cluster.encodeMessage(cmsg)
+		a.NoError(err)
+		decoded, err := decodeMessage(data)
+		a.NoError(err, name)
+		a.Equal(cmsg, decoded, name)
+	}
+
+	_, err := codecByName("json")
+	a.Equal(errUnknownCodec, err)
+}
+
+func TestMessageCodecs_Tagging(t *testing.T) {
+	a := assert.New(t)
+
+	cmsg := &message{NodeID: 1, Type: mtGubleMessage, Body: []byte("/foo,42")}
+
+	// the msgpack messages are not tagged, as by the older guble versions
+	legacy, err := cmsg.encode()
+	a.NoError(err)
+	data, err := (&Cluster{codec: msgpackCodec{}}).encodeMessage(cmsg)
+	a.NoError(err)
+	a.Equal(legacy, data)
+
+	data, err = (&Cluster{codec: protobufCodec{}}).encodeMessage(cmsg)
+	a.NoError(err)
+	a.Equal([]byte{tagMarker, 1, protocolVersion}, data[:tagSize])
+
+	// a message of an unknown codec is refused
+	data[1] = 9
+	_, err = decodeMessage(data)
+	a.Error(err)
+	_, err = decodeMessage([]byte{tagMarker})
+	a.Error(err)
+}
+
+func TestProtobufCodec_SkipsUnknownFields(t *testing.T) {
+	a := assert.New(t)
+
+	data, err := protobufCodec{}.encodeMessage(&message{NodeID: 2, Type: mtSyncMessage, Body: []byte("x")})
+	a.NoError(err)
+	// the fields 4 (varint), 5 (bytes) and 6 (fixed32), as added by a newer version
+	data = append(data, 4<<3|pbVarint, 0x96, 0x01, 5<<3|pbBytes, 2, 'a', 'b', 6<<3|pbFixed32, 1, 2, 3, 4)
+
+	cmsg := new(message)
+	a.NoError(protobufCodec{}.decodeMessage(cmsg, data))
+	a.Equal(&message{NodeID: 2, Type: mtSyncMessage, Body: []byte("x")}, cmsg)
+
+	// truncated
+	a.Equal(errInvalidProtobuf, protobufCodec{}.decodeMessage(new(message), data[:len(data)-2]))
+}
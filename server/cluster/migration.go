@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+var (
+	// ErrAlreadyClustered is returned when migrating a node which is already a member of a cluster.
+	ErrAlreadyClustered = errors.New("The node is already a member of a cluster")
+
+	errInvalidMigration = errors.New("The migration requires a strictly positive node ID and the address of a remote node")
+)
+
+// migrationRequest is the body of a migration request: the ID of the node in the cluster,
+// and the addresses of the other nodes.
+type migrationRequest struct {
+	NodeID  uint8    `json:"node_id"`
+	Remotes []string `json:"remotes"`
+}
+
+// Migrator converts a standalone node to a member of a cluster at runtime, without restarting it:
+// the cluster is attached to the router (giving cluster-aware IDs to the messages published afterwards),
+// and started, joining the remotes. The stored messages are then streamed to the other nodes by the synchronization,
+// as when any node joins the cluster.
+// After the migration, its endpoint reports the versions of the cluster, as the endpoint of a cluster node.
+type Migrator struct {
+	config Config
+	router router
+	attach func(*Cluster) error
+
+	mu      sync.Mutex
+	cluster *Cluster
+}
+
+// NewMigrator returns a new Migrator of a standalone node, creating the cluster with the given configuration
+// (whose ID and remotes are given by the migration request), and attaching it to the router with the given func.
+func NewMigrator(config Config, r router, attach func(*Cluster) error) *Migrator {
+	return &Migrator{
+		config: config,
+		router: r,
+		attach: attach,
+	}
+}
+
+// Migrate creates the cluster, attaches it to the router and joins the remotes.
+// The cluster is detached if it could not join them.
+func (m *Migrator) Migrate(nodeID uint8, remotes []*net.TCPAddr) (*Cluster, error) {
+	if nodeID == 0 || len(remotes) == 0 {
+		return nil, errInvalidMigration
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cluster != nil {
+		return nil, ErrAlreadyClustered
+	}
+
+	config := m.config
+	config.ID = nodeID
+	config.Remotes = remotes
+	cl, err := New(&config)
+	if err != nil {
+		return nil, err
+	}
+	cl.Router = m.router
+
+	// attached before joining, so that the messages of the other nodes are stored with their IDs
+	if err := m.attach(cl); err != nil {
+		cl.Stop()
+		return nil, err
+	}
+	if err := cl.Start(); err != nil {
+		m.attach(nil)
+		cl.Stop()
+		return nil, err
+	}
+	m.cluster = cl
+
+	logger.WithField("nodeID", nodeID).WithField("remotes", config.Remotes).Info("Migrated the standalone node to the cluster")
+	return cl, nil
+}
+
+// Cluster returns the cluster of the migrated node, or nil if it was not migrated.
+func (m *Migrator) Cluster() *Cluster {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cluster
+}
+
+// Stop stops the cluster of the migrated node.
+func (m *Migrator) Stop() error {
+	if cl := m.Cluster(); cl != nil {
+		return cl.Stop()
+	}
+	return nil
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (m *Migrator) GetPrefix() string {
+	return prefix
+}
+
+// ServeHTTP migrates the node with `POST`, and reports the versions of the cluster with `GET` once migrated.
+// It is a part of the service.endpoint implementation.
+func (m *Migrator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cl := m.Cluster()
+	switch req.Method {
+	case http.MethodGet:
+		if cl == nil {
+			http.Error(w, `{"error": "Standalone node."}`, http.StatusNotFound)
+			return
+		}
+		cl.ServeHTTP(w, req)
+	case http.MethodPost:
+		m.serveMigration(w, req)
+	default:
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET and POST are accepted."}`, http.StatusMethodNotAllowed)
+	}
+}
+
+func (m *Migrator) serveMigration(w http.ResponseWriter, req *http.Request) {
+	var mr migrationRequest
+	if err := json.NewDecoder(req.Body).Decode(&mr); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	remotes := make([]*net.TCPAddr, 0, len(mr.Remotes))
+	for _, remote := range mr.Remotes {
+		addr, err := net.ResolveTCPAddr("tcp", remote)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		remotes = append(remotes, addr)
+	}
+
+	cl, err := m.Migrate(mr.NodeID, remotes)
+	switch err {
+	case nil:
+		json.NewEncoder(w).Encode(cl.versions())
+	case errInvalidMigration:
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+	case ErrAlreadyClustered:
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+	default:
+		logger.WithError(err).Error("Could not migrate the standalone node to the cluster")
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadGateway)
+	}
+}
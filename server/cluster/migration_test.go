@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrator_JoinsTheClusterAtRuntime(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a cluster node
+	peerConfig := testConfig()
+	peer, err := New(&peerConfig)
+	a.NoError(err)
+	peer.Router = newDummyRouter(t)
+	a.NoError(peer.Start())
+	defer peer.Stop()
+
+	// and a standalone node
+	var attached *Cluster
+	m := NewMigrator(Config{Host: "127.0.0.1", Port: basePort + index}, newDummyRouter(t), func(cl *Cluster) error {
+		attached = cl
+		return nil
+	})
+	index++
+	defer m.Stop()
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, prefix, nil))
+	a.Equal(http.StatusNotFound, w.Code)
+
+	// when it is migrated
+	body := fmt.Sprintf(`{"node_id": %d, "remotes": ["127.0.0.1:%d"]}`, peerConfig.ID+1, peerConfig.Port)
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, prefix, strings.NewReader(body)))
+
+	// then it joined the cluster, with the cluster attached to its router
+	a.Equal(http.StatusOK, w.Code)
+	var versions clusterVersions
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &versions))
+	a.Equal(peerConfig.ID+1, versions.NodeID)
+	a.Len(versions.Nodes, 2)
+	a.Equal(m.Cluster(), attached)
+
+	// and it is not migrated again
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, prefix, strings.NewReader(body)))
+	a.Equal(http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, prefix, nil))
+	a.Equal(http.StatusOK, w.Code)
+}
+
+func TestMigrator_RejectsInvalidRequests(t *testing.T) {
+	a := assert.New(t)
+
+	m := NewMigrator(Config{}, nil, func(*Cluster) error {
+		a.Fail("No cluster should be attached")
+		return nil
+	})
+	for _, body := range []string{`{"node_id": 0, "remotes": ["127.0.0.1:10000"]}`, `{"node_id": 2}`, `{"node_id": 2, "remotes": ["::"]}`, `{`} {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, prefix, strings.NewReader(body)))
+		a.Equal(http.StatusBadRequest, w.Code, body)
+	}
+	a.Nil(m.Cluster())
+}
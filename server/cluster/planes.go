@@ -0,0 +1,275 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+const (
+	// controlPlaneSenders and dataPlaneSenders are the numbers of messages sent at once on each plane
+	controlPlaneSenders = 4
+	dataPlaneSenders    = 16
+
+	// maxDataFrameSize is the maximum size of a message received on the data port
+	maxDataFrameSize = 32 * 1024 * 1024
+
+	dataDialTimeout  = 10 * time.Second
+	dataWriteTimeout = 10 * time.Second
+)
+
+// plane is a class of the cluster traffic, sent with its own concurrency and rate limit:
+// the control plane (the synchronization of the partitions, the fetches) is not delayed by the messages
+// replicated on the data plane. A nil plane sends without limits.
+type plane struct {
+	slots   chan struct{}
+	limiter *rateLimiter
+}
+
+func newPlane(senders int, rate float64) *plane {
+	return &plane{
+		slots:   make(chan struct{}, senders),
+		limiter: newRateLimiter(rate),
+	}
+}
+
+// acquire waits for a free sender and for the rate limit of the plane.
+func (p *plane) acquire() {
+	if p == nil {
+		return
+	}
+	p.slots <- struct{}{}
+	p.limiter.wait()
+}
+
+func (p *plane) release() {
+	if p == nil {
+		return
+	}
+	<-p.slots
+}
+
+// isDataPlane returns true for the types of the messages replicating the messages of the users.
+func isDataPlane(t messageType) bool {
+	switch t {
+	case mtGubleMessage, mtStringMessage, mtRelayMessage, mtSyncMessage:
+		return true
+	}
+	return false
+}
+
+// rateLimiter is a token bucket pacing the messages sent on a plane. A nil rateLimiter does not limit.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	mu      sync.Mutex
+	tokens  float64
+	updated time.Time
+}
+
+// newRateLimiter returns a rateLimiter of the given number of messages per second (nil, if not positive),
+// allowing a burst of one second.
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	burst := math.Max(1, math.Ceil(rate))
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, updated: time.Now()}
+}
+
+// wait blocks until a message can be sent.
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.updated).Seconds()*l.rate)
+	l.updated = now
+	// the token is taken in advance, the following messages waiting for the next ones
+	l.tokens--
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(d)
+}
+
+// transmit sends an encoded cluster message to a node, on the control or the data plane.
+// The data plane messages are sent to the replication websocket of the node if it announced one,
+// or to its data port if both nodes have one, otherwise the messages are sent with memberlist.
+func (cluster *Cluster) transmit(node *memberlist.Node, data bool, msgBytes []byte) error {
+	p := cluster.control
+	if data {
+		p = cluster.data
+	}
+	p.acquire()
+	defer p.release()
+
+	if data {
+		meta := parseNodeMeta(node.Meta)
+		if meta.DataURL != "" && cluster.Config.DataSecret != "" {
+			return cluster.sendWebsocket(meta.DataURL, msgBytes)
+		}
+		if addr := cluster.dataAddr(node, meta); addr != "" {
+			return cluster.sendData(addr, msgBytes)
+		}
+	}
+	return cluster.memberlist.SendToTCP(node, msgBytes)
+}
+
+// dataAddr returns the address of the data port of a node, or an empty string if this node or the other one has none.
+func (cluster *Cluster) dataAddr(node *memberlist.Node, meta nodeMeta) string {
+	if cluster.Config.DataPort <= 0 || meta.DataPort <= 0 {
+		return ""
+	}
+	return net.JoinHostPort(node.Addr.String(), strconv.Itoa(meta.DataPort))
+}
+
+// dataConn is a persistent connection to the data port of a node, on which the messages are written as frames
+// prefixed by their size.
+type dataConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// sendData writes a message to the data port at the given address, dialing it if not connected.
+// The connection is closed after a failed write, and dialed again by the next message.
+func (cluster *Cluster) sendData(addr string, msgBytes []byte) error {
+	cluster.dataMu.Lock()
+	dc, ok := cluster.dataConns[addr]
+	if !ok {
+		dc = &dataConn{}
+		cluster.dataConns[addr] = dc
+	}
+	cluster.dataMu.Unlock()
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if dc.conn == nil {
+		conn, err := net.DialTimeout("tcp", addr, dataDialTimeout)
+		if err != nil {
+			return err
+		}
+		dc.conn = conn
+	}
+
+	frame := make([]byte, 4+len(msgBytes))
+	binary.BigEndian.PutUint32(frame, uint32(len(msgBytes)))
+	copy(frame[4:], msgBytes)
+	dc.conn.SetWriteDeadline(time.Now().Add(dataWriteTimeout))
+	if _, err := dc.conn.Write(frame); err != nil {
+		dc.conn.Close()
+		dc.conn = nil
+		return err
+	}
+	return nil
+}
+
+// listenData starts receiving the data plane messages of the other nodes on the data port, if configured.
+func (cluster *Cluster) listenData() error {
+	if cluster.Config.DataPort <= 0 {
+		return nil
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(cluster.Config.Host, strconv.Itoa(cluster.Config.DataPort)))
+	if err != nil {
+		return err
+	}
+	cluster.dataListener = l
+	logger.WithField("addr", l.Addr().String()).Info("Receiving the cluster messages on the data port")
+
+	cluster.wg.Add(1)
+	go cluster.acceptData(l)
+	return nil
+}
+
+func (cluster *Cluster) acceptData(l net.Listener) {
+	defer cluster.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			// closed when stopping
+			return
+		}
+		cluster.dataMu.Lock()
+		select {
+		case <-cluster.stopC:
+			// accepted while stopping, after the connections were closed
+			cluster.dataMu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		cluster.dataReceivers[conn] = struct{}{}
+		cluster.dataMu.Unlock()
+
+		cluster.wg.Add(1)
+		go cluster.receiveData(conn)
+	}
+}
+
+// receiveData dispatches the messages read from a connection to the data port, until it is closed.
+func (cluster *Cluster) receiveData(conn net.Conn) {
+	defer cluster.wg.Done()
+	defer func() {
+		conn.Close()
+		cluster.dataMu.Lock()
+		delete(cluster.dataReceivers, conn)
+		cluster.dataMu.Unlock()
+	}()
+
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n > maxDataFrameSize {
+			logger.WithField("size", n).WithField("from", conn.RemoteAddr().String()).Error("Closing the data connection sending a too large message")
+			return
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		cluster.NotifyMsg(data)
+	}
+}
+
+// closeData closes the data port and its connections, and the replication websockets.
+func (cluster *Cluster) closeData() {
+	if cluster.dataListener != nil {
+		cluster.dataListener.Close()
+	}
+	cluster.dataMu.Lock()
+	defer cluster.dataMu.Unlock()
+	for conn := range cluster.dataReceivers {
+		conn.Close()
+	}
+	for addr, dc := range cluster.dataConns {
+		dc.mu.Lock()
+		if dc.conn != nil {
+			dc.conn.Close()
+			dc.conn = nil
+		}
+		dc.mu.Unlock()
+		delete(cluster.dataConns, addr)
+	}
+	for url, wc := range cluster.wsConns {
+		wc.mu.Lock()
+		if wc.conn != nil {
+			wc.conn.Close()
+			wc.conn = nil
+		}
+		wc.mu.Unlock()
+		delete(cluster.wsConns, url)
+	}
+}
@@ -0,0 +1,98 @@
+package cluster
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+)
+
+type recordingRouter struct {
+	*dummyRouter
+	messages chan *protocol.Message
+}
+
+func (r *recordingRouter) HandleMessage(pmsg *protocol.Message) error {
+	r.messages <- pmsg
+	return nil
+}
+
+func TestIsDataPlane(t *testing.T) {
+	a := assert.New(t)
+
+	a.True(isDataPlane(mtGubleMessage))
+	a.True(isDataPlane(mtRelayMessage))
+	a.True(isDataPlane(mtSyncMessage))
+	a.False(isDataPlane(mtSyncPartitions))
+	a.False(isDataPlane(mtSyncMessageRequest))
+	a.False(isDataPlane(mtFetchRequest))
+	a.False(isDataPlane(mtFetchResponse))
+}
+
+func TestRateLimiter(t *testing.T) {
+	a := assert.New(t)
+
+	a.Nil(newRateLimiter(0))
+	newRateLimiter(0).wait()
+
+	// the burst is sent at once
+	l := newRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		l.wait()
+	}
+	a.True(time.Since(start) < 50*time.Millisecond)
+
+	// the following messages are paced
+	l.tokens, l.updated = 0, time.Now()
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		l.wait()
+	}
+	a.True(time.Since(start) >= 25*time.Millisecond)
+}
+
+func TestCluster_ReplicatesOnTheDataPort(t *testing.T) {
+	a := assert.New(t)
+
+	config1 := testConfig()
+	config1.DataPort = basePort + index
+	index++
+	node1, err := New(&config1)
+	a.NoError(err)
+	node1.Router = newDummyRouter(t)
+	defer node1.Stop()
+	a.NoError(node1.Start())
+
+	config2 := Config{ID: config1.ID + 1, Host: "127.0.0.1", Port: basePort + index, DataPort: basePort + index + 1,
+		Remotes: []*net.TCPAddr{{IP: []byte{127, 0, 0, 1}, Port: config1.Port}}}
+	index += 2
+	node2, err := New(&config2)
+	a.NoError(err)
+	router2 := &recordingRouter{dummyRouter: newDummyRouter(t), messages: make(chan *protocol.Message, 1)}
+	node2.Router = router2
+	defer node2.Stop()
+	a.NoError(node2.Start())
+
+	a.NoError(node1.BroadcastMessage(&protocol.Message{
+		ID:     1,
+		Path:   "/data",
+		Time:   time.Now().Unix(),
+		Body:   []byte("test"),
+		NodeID: config1.ID,
+	}))
+
+	select {
+	case m := <-router2.messages:
+		a.Equal("test", string(m.Body))
+	case <-time.After(2 * time.Second):
+		a.Fail("The message was not replicated")
+	}
+
+	node1.dataMu.Lock()
+	a.Len(node1.dataConns, 1, "The message should be sent to the data port")
+	node1.dataMu.Unlock()
+}
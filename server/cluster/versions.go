@@ -0,0 +1,216 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/hashicorp/memberlist"
+)
+
+const (
+	// minProtocolVersion is the oldest version of the cluster protocol with which this node works:
+	// the nodes of version 0 (the guble versions without versions) only understand the untagged msgpack messages.
+	minProtocolVersion byte = 0
+
+	// prefix is the admin endpoint reporting the versions of the nodes.
+	prefix = "/admin/cluster"
+)
+
+// nodeMeta is the metadata of a node, exchanged by memberlist.
+type nodeMeta struct {
+	// Version is the version of the cluster protocol spoken by the node
+	Version byte `json:"version"`
+
+	// MinVersion is the oldest version of the cluster protocol with which the node works
+	MinVersion byte `json:"min_version"`
+
+	// Zone is the availability zone of the node, and Weight its capacity (see planBroadcast)
+	Zone   string `json:"zone,omitempty"`
+	Weight int    `json:"weight,omitempty"`
+
+	// DataPort is the port on which the node receives the messages of the data plane (see transmit)
+	DataPort int `json:"data_port,omitempty"`
+
+	// DataURL is the URL of the replication websocket of the node (see ReplicationEndpoint)
+	DataURL string `json:"data_url,omitempty"`
+}
+
+// NodeMeta returns the metadata of this node, with the versions of its cluster protocol, its zone and weight.
+// It is a part of the memberlist.Delegate implementation.
+func (cluster *Cluster) NodeMeta(limit int) []byte {
+	data, err := json.Marshal(nodeMeta{
+		Version:    protocolVersion,
+		MinVersion: minProtocolVersion,
+		Zone:       cluster.Config.Zone,
+		Weight:     cluster.Config.Weight,
+		DataPort:   cluster.Config.DataPort,
+		DataURL:    cluster.Config.DataURL,
+	})
+	if err != nil || len(data) > limit {
+		logger.WithField("limit", limit).Error("The node metadata exceeds the limit")
+		return nil
+	}
+	return data
+}
+
+// NotifyAlive refuses the nodes whose cluster protocol is not compatible with the protocol of this node.
+// It is a part of the memberlist.AliveDelegate implementation.
+func (cluster *Cluster) NotifyAlive(node *memberlist.Node) error {
+	if err := compatible(parseNodeMeta(node.Meta)); err != nil {
+		logger.WithField("node", node.Name).WithError(err).Error("Refusing a cluster node")
+		return err
+	}
+	return nil
+}
+
+// parseNodeMeta decodes the metadata of a node; a node without metadata speaks the version 0.
+func parseNodeMeta(data []byte) nodeMeta {
+	var meta nodeMeta
+	if len(data) > 0 {
+		json.Unmarshal(data, &meta)
+	}
+	return meta
+}
+
+// compatible returns an error if a node with the given metadata cannot work with this node.
+func compatible(meta nodeMeta) error {
+	if meta.Version < minProtocolVersion {
+		return fmt.Errorf("The cluster protocol version %d of the node is older than the supported version %d.", meta.Version, minProtocolVersion)
+	}
+	if meta.MinVersion > protocolVersion {
+		return fmt.Errorf("The node requires the cluster protocol version %d, newer than the version %d.", meta.MinVersion, protocolVersion)
+	}
+	return nil
+}
+
+// negotiatedVersion returns the version of the cluster protocol spoken by all the nodes: the oldest one.
+func negotiatedVersion(metas []nodeMeta) byte {
+	version := protocolVersion
+	for _, meta := range metas {
+		if meta.Version < version {
+			version = meta.Version
+		}
+	}
+	return version
+}
+
+// setNodeMeta records the metadata of a node which joined the cluster, or was updated.
+func (cluster *Cluster) setNodeMeta(node *memberlist.Node) {
+	cluster.metasMu.Lock()
+	defer cluster.metasMu.Unlock()
+	if cluster.metas == nil {
+		cluster.metas = make(map[string]nodeMeta)
+	}
+	cluster.metas[node.Name] = parseNodeMeta(node.Meta)
+}
+
+// removeNodeMeta forgets the metadata of a node which left the cluster.
+func (cluster *Cluster) removeNodeMeta(node *memberlist.Node) {
+	cluster.metasMu.Lock()
+	defer cluster.metasMu.Unlock()
+	delete(cluster.metas, node.Name)
+}
+
+// nodeMetas returns the metadata of the members of the cluster, by node name.
+// They are recorded from the memberlist events, so that the messages can be encoded inside the event delegates.
+func (cluster *Cluster) nodeMetas() map[string]nodeMeta {
+	cluster.metasMu.Lock()
+	defer cluster.metasMu.Unlock()
+	metas := make(map[string]nodeMeta, len(cluster.metas))
+	for name, meta := range cluster.metas {
+		metas[name] = meta
+	}
+	return metas
+}
+
+// clusterVersion returns the version of the cluster protocol spoken by all the current members of the cluster.
+func (cluster *Cluster) clusterVersion() byte {
+	var metas []nodeMeta
+	for _, meta := range cluster.nodeMetas() {
+		metas = append(metas, meta)
+	}
+	return negotiatedVersion(metas)
+}
+
+// sendingCodec returns the codec encoding the messages sent by this node: the configured one,
+// downgraded to msgpack while the cluster has nodes which do not understand the tagged messages (version 0).
+func (cluster *Cluster) sendingCodec() messageCodec {
+	return cluster.codecFor(cluster.clusterVersion())
+}
+
+// codecFor returns the codec understood by the nodes of the given cluster protocol version.
+func (cluster *Cluster) codecFor(version byte) messageCodec {
+	if version < 1 {
+		return msgpackCodec{}
+	}
+	return cluster.codec
+}
+
+// nodeVersion is the state of a node reported by the admin endpoint.
+type nodeVersion struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	Version    byte   `json:"version"`
+	MinVersion byte   `json:"min_version"`
+	Zone       string `json:"zone,omitempty"`
+	Weight     int    `json:"weight,omitempty"`
+}
+
+// clusterVersions is the state of the cluster reported by the admin endpoint.
+type clusterVersions struct {
+	NodeID         uint8         `json:"node_id"`
+	Version        byte          `json:"version"`
+	ClusterVersion byte          `json:"cluster_version"`
+	MixedVersions  bool          `json:"mixed_versions"`
+	Codec          string        `json:"codec"`
+	Nodes          []nodeVersion `json:"nodes"`
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (cluster *Cluster) GetPrefix() string {
+	return prefix
+}
+
+// ServeHTTP reports the versions of the cluster protocol spoken by the nodes, e.g. during a rolling upgrade.
+// It is a part of the service.endpoint implementation.
+func (cluster *Cluster) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET is accepted."}`, http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(cluster.versions())
+}
+
+// versions returns the versions of the cluster protocol spoken by the nodes.
+func (cluster *Cluster) versions() clusterVersions {
+	versions := clusterVersions{
+		NodeID:         cluster.Config.ID,
+		Version:        protocolVersion,
+		ClusterVersion: cluster.clusterVersion(),
+		Nodes:          make([]nodeVersion, 0),
+	}
+	for name, codec := range messageCodecs {
+		if codec.id() == cluster.sendingCodec().id() {
+			versions.Codec = name
+		}
+	}
+	if cluster.memberlist != nil {
+		for _, node := range cluster.memberlist.Members() {
+			meta := parseNodeMeta(node.Meta)
+			versions.Nodes = append(versions.Nodes, nodeVersion{
+				Name:       node.Name,
+				Address:    node.Addr.String() + ":" + strconv.Itoa(int(node.Port)),
+				Version:    meta.Version,
+				MinVersion: meta.MinVersion,
+				Zone:       meta.Zone,
+				Weight:     meta.Weight,
+			})
+			versions.MixedVersions = versions.MixedVersions || meta.Version != protocolVersion
+		}
+	}
+	return versions
+}
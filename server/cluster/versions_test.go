@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeMeta_Compatibility(t *testing.T) {
+	a := assert.New(t)
+
+	node := &Cluster{Config: &Config{Zone: "eu-west-1a", Weight: 2}}
+	meta := parseNodeMeta(node.NodeMeta(512))
+	a.Equal(nodeMeta{Version: protocolVersion, MinVersion: minProtocolVersion, Zone: "eu-west-1a", Weight: 2}, meta)
+	a.Nil(node.NodeMeta(4))
+
+	// the nodes of the older guble versions have no metadata
+	a.Equal(nodeMeta{}, parseNodeMeta(nil))
+	a.NoError(compatible(nodeMeta{}))
+	a.NoError(compatible(meta))
+	a.Error(compatible(nodeMeta{Version: protocolVersion + 1, MinVersion: protocolVersion + 1}))
+
+	a.Equal(protocolVersion, negotiatedVersion(nil))
+	a.Equal(byte(0), negotiatedVersion([]nodeMeta{meta, {}}))
+}
+
+func TestCluster_NodeMetasFollowTheEvents(t *testing.T) {
+	a := assert.New(t)
+
+	node := &Cluster{Config: &Config{}}
+	current := &memberlist.Node{Name: "1", Meta: node.NodeMeta(512)}
+	old := &memberlist.Node{Name: "2"}
+
+	node.setNodeMeta(current)
+	a.Equal(protocolVersion, node.clusterVersion())
+	node.setNodeMeta(old)
+	a.Equal(byte(0), node.clusterVersion())
+	a.Equal(msgpackCodec{}, node.sendingCodec())
+	node.removeNodeMeta(old)
+	a.Equal(protocolVersion, node.clusterVersion())
+	a.Len(node.nodeMetas(), 1)
+}
+
+func TestCluster_DowngradesTheCodecForOlderNodes(t *testing.T) {
+	a := assert.New(t)
+
+	conf := testConfig()
+	conf.Codec = ProtobufCodec
+	node, err := New(&conf)
+	a.NoError(err)
+	node.Router = newDummyRouter(t)
+	a.NoError(node.Start())
+	defer node.Stop()
+
+	a.Equal(protocolVersion, node.clusterVersion())
+	a.Equal(protobufCodec{}, node.sendingCodec())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, prefix, nil)
+	node.ServeHTTP(w, req)
+	a.Equal(http.StatusOK, w.Code)
+	var versions clusterVersions
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &versions))
+	a.Equal(ProtobufCodec, versions.Codec)
+	a.False(versions.MixedVersions)
+	if a.Len(versions.Nodes, 1) {
+		a.Equal(protocolVersion, versions.Nodes[0].Version)
+	}
+
+	// with the nodes of the older guble versions
+	a.Equal(msgpackCodec{}, node.codecFor(0))
+}
@@ -0,0 +1,126 @@
+package cluster
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DataSecretHeader is the header with which a node authenticates to the replication websocket of another node.
+const DataSecretHeader = "X-Guble-Cluster-Secret"
+
+var (
+	errMissingDataSecret = errors.New("The replication over websockets requires a shared secret of the cluster nodes.")
+
+	replicationUpgrader = websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+)
+
+// wsDataConn is a persistent websocket to the replication endpoint of a node.
+type wsDataConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+// sendWebsocket writes a message to the replication websocket at the given URL, dialing it if not connected.
+// As on the data port, the websocket is closed after a failed write, and dialed again by the next message.
+func (cluster *Cluster) sendWebsocket(url string, msgBytes []byte) error {
+	cluster.dataMu.Lock()
+	wc, ok := cluster.wsConns[url]
+	if !ok {
+		wc = &wsDataConn{}
+		cluster.wsConns[url] = wc
+	}
+	cluster.dataMu.Unlock()
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if wc.conn == nil {
+		dialer := websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: dataDialTimeout,
+		}
+		header := http.Header{}
+		header.Set(DataSecretHeader, cluster.Config.DataSecret)
+		conn, _, err := dialer.Dial(url, header)
+		if err != nil {
+			return err
+		}
+		wc.conn = conn
+	}
+
+	wc.conn.SetWriteDeadline(time.Now().Add(dataWriteTimeout))
+	if err := wc.conn.WriteMessage(websocket.BinaryMessage, msgBytes); err != nil {
+		wc.conn.Close()
+		wc.conn = nil
+		return err
+	}
+	return nil
+}
+
+// ReplicationEndpoint receives the messages of the data plane over websockets, for the clusters whose nodes
+// can only reach each other with HTTP(S) (e.g. between datacenters).
+type ReplicationEndpoint struct {
+	cluster *Cluster
+	prefix  string
+}
+
+// ReplicationEndpoint returns the endpoint receiving the messages replicated over websockets, served with the given prefix.
+func (cluster *Cluster) ReplicationEndpoint(prefix string) *ReplicationEndpoint {
+	return &ReplicationEndpoint{cluster: cluster, prefix: prefix}
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (e *ReplicationEndpoint) GetPrefix() string {
+	return e.prefix
+}
+
+// ServeHTTP accepts the websockets of the other nodes authenticated with the shared secret,
+// and dispatches their messages until they are closed.
+// It is a part of the service.endpoint implementation.
+func (e *ReplicationEndpoint) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	cluster := e.cluster
+	secret := req.Header.Get(DataSecretHeader)
+	if cluster.Config.DataSecret == "" ||
+		subtle.ConstantTimeCompare([]byte(secret), []byte(cluster.Config.DataSecret)) != 1 {
+		http.Error(w, `{"error": "Unauthorized."}`, http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := replicationUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		logger.WithError(err).Error("Error on upgrading the replication websocket")
+		return
+	}
+	defer conn.Close()
+
+	cluster.dataMu.Lock()
+	select {
+	case <-cluster.stopC:
+		cluster.dataMu.Unlock()
+		return
+	default:
+	}
+	cluster.dataReceivers[conn] = struct{}{}
+	cluster.dataMu.Unlock()
+	defer func() {
+		cluster.dataMu.Lock()
+		delete(cluster.dataReceivers, conn)
+		cluster.dataMu.Unlock()
+	}()
+
+	conn.SetReadLimit(maxDataFrameSize)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		cluster.NotifyMsg(data)
+	}
+}
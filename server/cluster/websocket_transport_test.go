@@ -0,0 +1,81 @@
+package cluster
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+)
+
+func TestCluster_ReplicatesOverWebsockets(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a node receiving the replicated messages on a websocket
+	var endpoint http.Handler
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		endpoint.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	config1 := testConfig()
+	config1.DataSecret = "secret"
+	config1.DataURL = "ws" + strings.TrimPrefix(server.URL, "http") + "/cluster/replication"
+	node1, err := New(&config1)
+	a.NoError(err)
+	router1 := &recordingRouter{dummyRouter: newDummyRouter(t), messages: make(chan *protocol.Message, 1)}
+	node1.Router = router1
+	endpoint = node1.ReplicationEndpoint("/cluster/replication")
+	defer node1.Stop()
+	a.NoError(node1.Start())
+
+	// and a node with the shared secret
+	config2 := Config{ID: config1.ID + 1, Host: "127.0.0.1", Port: basePort + index, DataSecret: "secret",
+		Remotes: []*net.TCPAddr{{IP: []byte{127, 0, 0, 1}, Port: config1.Port}}}
+	index++
+	node2, err := New(&config2)
+	a.NoError(err)
+	node2.Router = newDummyRouter(t)
+	defer node2.Stop()
+	a.NoError(node2.Start())
+
+	// when a message is broadcast, it is replicated over the websocket
+	a.NoError(node2.BroadcastMessage(&protocol.Message{
+		ID:     1,
+		Path:   "/data",
+		Time:   time.Now().Unix(),
+		Body:   []byte("test"),
+		NodeID: config2.ID,
+	}))
+
+	select {
+	case m := <-router1.messages:
+		a.Equal("test", string(m.Body))
+	case <-time.After(2 * time.Second):
+		a.Fail("The message was not replicated")
+	}
+	node2.dataMu.Lock()
+	a.Len(node2.wsConns, 1, "The message should be sent on the websocket")
+	node2.dataMu.Unlock()
+}
+
+func TestReplicationEndpoint_RequiresTheSecret(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := New(&Config{DataURL: "wss://example.com/cluster/replication"})
+	a.Equal(errMissingDataSecret, err)
+
+	e := (&Cluster{Config: &Config{DataSecret: "secret"}}).ReplicationEndpoint("/cluster/replication")
+	a.Equal("/cluster/replication", e.GetPrefix())
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/cluster/replication", nil)
+	req.Header.Set(DataSecretHeader, "wrong")
+	e.ServeHTTP(w, req)
+	a.Equal(http.StatusUnauthorized, w.Code)
+}
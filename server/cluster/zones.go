@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"sort"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// relayVersion is the version of the cluster protocol from which the nodes relay the messages to their zone.
+const relayVersion byte = 2
+
+// relayMessage is a guble message sent to a node of another zone, which passes it on to the targets in its zone.
+type relayMessage struct {
+	Targets []string
+	Message []byte
+}
+
+func (rm *relayMessage) encode() ([]byte, error) {
+	return encode(rm)
+}
+
+func (rm *relayMessage) decode(data []byte) error {
+	return decode(rm, data)
+}
+
+// peer is a node of the cluster receiving the broadcasts, with its zone and weight.
+type peer struct {
+	name   string
+	zone   string
+	weight int
+}
+
+// delivery is the sending of a broadcast to a peer, which relays it to the relayTo peers of its zone.
+type delivery struct {
+	to      string
+	relayTo []string
+}
+
+// planBroadcast returns the deliveries of a broadcast by a node of the given zone: first to the peers of the same zone,
+// then to the peers of the other zones, the peers of higher weight first.
+// With a positive cross-zone fanout, a broadcast is sent to at most fanout peers of each other zone,
+// which relay it to the other peers of their zone, so that it crosses the zone boundary at most fanout times.
+// The peers without zone, and all the peers of a node without zone, are sent to directly.
+func planBroadcast(zone string, peers []peer, fanout int) []delivery {
+	zones := make(map[string][]peer)
+	var local []peer
+	for _, p := range peers {
+		if zone == "" || p.zone == "" || p.zone == zone {
+			local = append(local, p)
+		} else {
+			zones[p.zone] = append(zones[p.zone], p)
+		}
+	}
+
+	deliveries := make([]delivery, 0, len(peers))
+	sort.Sort(byWeight(local))
+	for _, p := range local {
+		deliveries = append(deliveries, delivery{to: p.name})
+	}
+
+	names := make([]string, 0, len(zones))
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		zonePeers := zones[name]
+		sort.Sort(byWeight(zonePeers))
+		if fanout <= 0 || len(zonePeers) <= fanout {
+			for _, p := range zonePeers {
+				deliveries = append(deliveries, delivery{to: p.name})
+			}
+			continue
+		}
+		relays := make([]delivery, fanout)
+		for i, p := range zonePeers {
+			if i < fanout {
+				relays[i].to = p.name
+			} else {
+				relays[i%fanout].relayTo = append(relays[i%fanout].relayTo, p.name)
+			}
+		}
+		deliveries = append(deliveries, relays...)
+	}
+	return deliveries
+}
+
+// byWeight orders the peers by decreasing weight, and by name.
+type byWeight []peer
+
+func (p byWeight) Len() int      { return len(p) }
+func (p byWeight) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byWeight) Less(i, j int) bool {
+	if p[i].weight != p[j].weight {
+		return p[i].weight > p[j].weight
+	}
+	return p[i].name < p[j].name
+}
+
+// broadcastPlan returns the deliveries of a broadcast to the other nodes, with the nodes by name.
+// The messages are only relayed if all the nodes of the cluster relay them.
+func (cluster *Cluster) broadcastPlan(relay bool) ([]delivery, map[string]*memberlist.Node) {
+	nodes := make(map[string]*memberlist.Node)
+	var peers []peer
+	for _, node := range cluster.otherNodes() {
+		meta := parseNodeMeta(node.Meta)
+		nodes[node.Name] = node
+		peers = append(peers, peer{name: node.Name, zone: meta.Zone, weight: meta.Weight})
+	}
+	fanout := cluster.Config.CrossZoneFanout
+	if !relay || cluster.clusterVersion() < relayVersion {
+		fanout = 0
+	}
+	return planBroadcast(cluster.Config.Zone, peers, fanout), nodes
+}
+
+// handles message received with type `mtRelayMessage`: the guble message is handled, and passed on to the targets
+func (cluster *Cluster) handleRelayMessage(cmsg *message) {
+	rm := &relayMessage{}
+	if err := rm.decode(cmsg.Body); err != nil {
+		logger.WithError(err).Error("Error decoding cluster relay message")
+		return
+	}
+	cluster.handleGubleMessage(&message{NodeID: cmsg.NodeID, Type: mtGubleMessage, Body: rm.Message})
+
+	// passed on with the ID of the origin node
+	data, err := cluster.encodeMessage(&message{NodeID: cmsg.NodeID, Type: mtGubleMessage, Body: rm.Message})
+	if err != nil {
+		logger.WithError(err).Error("Could not encode the relayed cluster-message")
+		return
+	}
+	for _, name := range rm.Targets {
+		for _, node := range cluster.otherNodes() {
+			if node.Name == name {
+				go cluster.sendToNode(node, data)
+			}
+		}
+	}
+}
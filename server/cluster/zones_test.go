@@ -0,0 +1,43 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanBroadcast(t *testing.T) {
+	a := assert.New(t)
+
+	peers := []peer{
+		{name: "5", zone: "b", weight: 1},
+		{name: "2", zone: "a", weight: 1},
+		{name: "3", zone: "a", weight: 2},
+		{name: "4", zone: "b", weight: 3},
+		{name: "6", zone: "b", weight: 1},
+		{name: "7", zone: "c"},
+		{name: "8"},
+	}
+
+	// the same zone first, then the other zones, by decreasing weight
+	a.Equal([]delivery{
+		{to: "3"}, {to: "2"}, {to: "8"},
+		{to: "4"}, {to: "5"}, {to: "6"},
+		{to: "7"},
+	}, planBroadcast("a", peers, 0))
+
+	// at most 2 nodes of another zone directly, relaying to the others
+	a.Equal([]delivery{
+		{to: "3"}, {to: "2"}, {to: "8"},
+		{to: "4", relayTo: []string{"6"}}, {to: "5"},
+		{to: "7"},
+	}, planBroadcast("a", peers, 2))
+	a.Equal([]delivery{
+		{to: "3"}, {to: "2"}, {to: "8"},
+		{to: "4", relayTo: []string{"5", "6"}},
+		{to: "7"},
+	}, planBroadcast("a", peers, 1))
+
+	// a node without zone sends to all the nodes directly
+	a.Len(planBroadcast("", peers, 1), len(peers))
+}
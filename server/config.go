@@ -10,20 +10,56 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/smancke/guble/server/apns"
+	"github.com/smancke/guble/server/campaign"
+	"github.com/smancke/guble/server/chaos"
+	"github.com/smancke/guble/server/chat"
+	"github.com/smancke/guble/server/cluster"
+	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/dummypush"
+	"github.com/smancke/guble/server/email"
 	"github.com/smancke/guble/server/fcm"
+	"github.com/smancke/guble/server/forward"
+	"github.com/smancke/guble/server/notify"
 	"github.com/smancke/guble/server/sms"
+	"github.com/smancke/guble/server/throttle"
+	"github.com/smancke/guble/server/websocket"
 )
 
 const (
 	defaultHttpListen      = ":8080"
 	defaultHealthEndpoint  = "/admin/healthcheck"
 	defaultMetricsEndpoint = "/admin/metrics"
+	defaultDrainEndpoint   = "/admin/drain"
+	defaultDrainTimeout    = "20s"
+	defaultStatsDPrefix    = "guble"
+	defaultStatsDInterval  = "10s"
 	defaultKVSBackend      = "file"
 	defaultMSBackend       = "file"
+	defaultMSSync          = "none"
+	defaultMSSyncInterval  = "1s"
+	defaultMSCacheMessages = "100"
+	defaultMSCacheSizeMB   = "64"
+	defaultMSMaxPartitions = "0"
+	defaultMSMaxSizeMB     = "0"
+	defaultMSEvictionIdle  = "10m"
+	defaultMSArchiveAge    = "168h"
+	defaultMSArchiveCache  = "4"
+	defaultHookTimeout     = "1s"
+	defaultHeaderMaxSize   = "8192"
+	defaultHeaderMaxKeys   = "64"
 	defaultStoragePath     = "/var/lib/guble"
 	defaultNodePort        = "10000"
+	defaultMaxFailures     = "5"
+	defaultBreakerFailures = "10"
+	defaultBreakerProbe    = "30s"
+	defaultBanAfter        = "100"
+	defaultLagInterval     = "30s"
+	defaultLagDelivery     = "5m"
+	defaultLagReplication  = "1m"
+	defaultDummyCapacity   = "1000"
 	development            = "dev"
 	integration            = "int"
 	preproduction          = "pre"
@@ -31,6 +67,10 @@ const (
 	memProfile             = "mem"
 	cpuProfile             = "cpu"
 	blockProfile           = "block"
+	authProviderNone       = "none"
+	authProviderREST       = "rest"
+	authProviderOAuth2     = "oauth2"
+	authProviderLDAP       = "ldap"
 )
 
 var (
@@ -50,11 +90,96 @@ type (
 		Password *string
 		DbName   *string
 	}
+	// MySQLConfig is used for configuring the MySQL connection.
+	MySQLConfig struct {
+		Host     *string
+		Port     *int
+		User     *string
+		Password *string
+		DbName   *string
+	}
+	// RedisConfig is used for configuring the Redis connection.
+	RedisConfig struct {
+		Addr          *string
+		Password      *string
+		DB            *int
+		Notifications *bool
+	}
+	// ArchiveConfig is used for configuring the archive of the old message files.
+	ArchiveConfig struct {
+		URL        *string
+		Age        *time.Duration
+		CacheFiles *int
+		Endpoint   *string
+		Region     *string
+		AccessKey  *string
+		SecretKey  *string
+	}
 	// ClusterConfig is used for configuring the cluster component.
 	ClusterConfig struct {
-		NodeID   *uint8
-		NodePort *int
-		Remotes  *tcpAddrList
+		NodeID            *uint8
+		NodePort          *int
+		NodeAdvertiseHost *string
+		NodeAdvertisePort *int
+		Remotes           *tcpAddrList
+		Replica           *bool
+		FetchTimeout      *time.Duration
+		JoinTimeout       *time.Duration
+		DataPort          *int
+		DataURL           *string
+		DataSecret        *string
+		ControlRate       *float64
+		DataRate          *float64
+		Codec             *string
+		Zone              *string
+		Weight            *int
+		CrossZoneFanout   *int
+	}
+	// AuthConfig is used for configuring the authentication of the users.
+	AuthConfig struct {
+		Provider     *string
+		URL          *string
+		ClientID     *string
+		ClientSecret *string
+		LDAPUserDN   *string
+	}
+	// ThrottleConfig is used for configuring the rate limiting of the publishing and subscribing requests.
+	ThrottleConfig struct {
+		Rate           *float64
+		Burst          *int
+		BanAfter       *int
+		Ban            *time.Duration
+		TrustForwarded *bool
+	}
+	// DeliveryConfig is used for configuring the goroutines delivering the messages to the routes.
+	DeliveryConfig struct {
+		WebsocketWorkers *int
+		ConnectorWorkers *int
+	}
+	// BroadcastConfig is used for configuring the delivery of the broadcast messages.
+	BroadcastConfig struct {
+		Topic  *string
+		Spread *time.Duration
+	}
+	// CompressionConfig is used for configuring the compression of the large message bodies.
+	CompressionConfig struct {
+		Encoding  *string
+		Threshold *int
+	}
+	// StatsDConfig is used for configuring the export of the metrics to StatsD.
+	StatsDConfig struct {
+		Address  *string
+		Prefix   *string
+		Tags     *[]string
+		Interval *time.Duration
+	}
+	// LagConfig is used for configuring the monitoring of the delivery and replication lags.
+	LagConfig struct {
+		Interval             *time.Duration
+		DeliveryThreshold    *time.Duration
+		ReplicationThreshold *time.Duration
+		URL                  *string
+		Topic                *string
 	}
 	// GubleConfig is used for configuring Guble server (including its modules / connectors).
 	GubleConfig struct {
@@ -63,163 +188,711 @@ type (
 		HttpListen      *string
 		KVS             *string
 		MS              *string
+		MSSync          *string
+		MSSyncInterval  *time.Duration
+		MSCacheMessages *int
+		MSCacheSizeMB   *int64
+		MSMaxPartitions *int
+		MSMaxSizeMB     *int64
+		MSEvictionIdle  *time.Duration
+		MSScan          *bool
+		Archive         ArchiveConfig
 		StoragePath     *string
 		HealthEndpoint  *string
 		MetricsEndpoint *string
+		MetricsSnapshot *time.Duration
+		DebugEndpoint   *string
+		DrainEndpoint   *string
+		DrainTimeout    *time.Duration
+		Chaos           *bool
+		StatsD          StatsDConfig
+		Lag             LagConfig
+		Hooks           *[]string
+		HookTimeout     *time.Duration
+		Proxy           *string
+		ConnectorAuth   *string
+		HeaderMaxSize   *int
+		HeaderMaxKeys   *int
+		RedactTopics    *[]string
+		EphemeralTopics *[]string
+		SearchTopics    *[]string
+		Delivery        DeliveryConfig
+		Broadcast       BroadcastConfig
+		Compression     CompressionConfig
 		Profile         *string
+		Auth            AuthConfig
 		Postgres        PostgresConfig
+		MySQL           MySQLConfig
+		Redis           RedisConfig
 		FCM             fcm.Config
 		APNS            apns.Config
+		DummyPush       dummypush.Config
+		Email           email.Config
+		External        *[]string
+		Forward         forward.Config
+		Chat            chat.Config
 		SMS             sms.Config
+		Notify          notify.Config
+		Campaign        campaign.Config
+		WS              websocket.Config
 		Cluster         ClusterConfig
+		Throttle        ThrottleConfig
+
+		// Faults injects the faults of the chaos-mode (set at the start, if enabled)
+		Faults *chaos.Injector
 	}
 )
 
 var (
 	parsed = false
 
-	// Config is the active configuration of guble (used when starting-up the server)
-	Config = &GubleConfig{
-		Log: kingpin.Flag("log", "Log level").
+	// Config is the active configuration of guble (used when starting-up the server),
+	// parsed from the command line of the process.
+	Config = newConfig(kingpin.CommandLine)
+)
+
+// NewConfig returns a configuration parsed from the given arguments (and the GUBLE_* environment variables),
+// independently of the command line of the process and of the active Config.
+func NewConfig(args []string) (*GubleConfig, error) {
+	app := kingpin.New("guble", "The guble server")
+	config := newConfig(app)
+	if _, err := app.Parse(args); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// newConfig returns a configuration, whose values are set by parsing the flags of the application.
+func newConfig(app *kingpin.Application) *GubleConfig {
+	return &GubleConfig{
+		Log: app.Flag("log", "Log level").
 			Default(log.ErrorLevel.String()).
 			Envar("GUBLE_LOG").
 			Enum(logLevels()...),
-		EnvName: kingpin.Flag("env", `Name of the environment on which the application is running`).
+		EnvName: app.Flag("env", `Name of the environment on which the application is running`).
 			Default(development).
 			Envar("GUBLE_ENV").
 			Enum(environments...),
-		HttpListen: kingpin.Flag("http", `The address to for the HTTP server to listen on (format: "[Host]:Port")`).
+		HttpListen: app.Flag("http", `The address to for the HTTP server to listen on (format: "[Host]:Port")`).
 			Default(defaultHttpListen).
 			Envar("GUBLE_HTTP_LISTEN").
 			String(),
-		KVS: kingpin.Flag("kvs", "The storage backend for the key-value store to use : file | memory | postgres ").
+		KVS: app.Flag("kvs", "The storage backend for the key-value store to use : file | memory | postgres | mysql | redis").
 			Default(defaultKVSBackend).
 			Envar("GUBLE_KVS").
 			String(),
-		MS: kingpin.Flag("ms", "The message storage backend : file | memory").
+		MS: app.Flag("ms", "The message storage backend : file | memory").
 			Default(defaultMSBackend).
 			HintOptions("file", "memory").
 			Envar("GUBLE_MS").
 			String(),
-		StoragePath: kingpin.Flag("storage-path", "The path for storing messages and key-value data if 'file' is selected").
+		MSSync: app.Flag("ms-sync", "The policy for flushing the message files to the disk, if 'file' is selected : none | always | interval").
+			Default(defaultMSSync).
+			Envar("GUBLE_MS_SYNC").
+			Enum("none", "always", "interval"),
+		MSSyncInterval: app.Flag("ms-sync-interval", "The interval for flushing the message files to the disk, if the 'interval' sync policy is selected").
+			Default(defaultMSSyncInterval).
+			Envar("GUBLE_MS_SYNC_INTERVAL").
+			Duration(),
+		MSCacheMessages: app.Flag("ms-cache-messages", "The number of most recent messages per partition kept in memory, if 'file' is selected (value for disabling the cache: 0)").
+			Default(defaultMSCacheMessages).
+			Envar("GUBLE_MS_CACHE_MESSAGES").
+			Int(),
+		MSCacheSizeMB: app.Flag("ms-cache-size", "The memory budget of the message cache in MB, shared by all the partitions").
+			Default(defaultMSCacheSizeMB).
+			Envar("GUBLE_MS_CACHE_SIZE").
+			Int64(),
+		MSMaxPartitions: app.Flag("ms-max-partitions", "The maximum number of partitions, if 'file' is selected (value for no limit: 0)").
+			Default(defaultMSMaxPartitions).
+			Envar("GUBLE_MS_MAX_PARTITIONS").
+			Int(),
+		MSMaxSizeMB: app.Flag("ms-max-size", "The maximum size of all the partitions on the disk in MB, if 'file' is selected (value for no limit: 0)").
+			Default(defaultMSMaxSizeMB).
+			Envar("GUBLE_MS_MAX_SIZE").
+			Int64(),
+		MSEvictionIdle: app.Flag("ms-eviction-idle", "The time after its last use, from which a partition may be evicted when a limit is reached").
+			Default(defaultMSEvictionIdle).
+			Envar("GUBLE_MS_EVICTION_IDLE").
+			Duration(),
+		MSScan: app.Flag("ms-scan", "Scan the last message file of every partition when loading it, truncating the messages not completely written before a crash").
+			Envar("GUBLE_MS_SCAN").
+			Bool(),
+		Archive: ArchiveConfig{
+			URL: app.Flag("ms-archive", `The URL of the archive for the old message files, if 'file' is selected : s3://bucket/prefix | gs://bucket/prefix | file:///path (value for disabling the archival: "")`).
+				Envar("GUBLE_MS_ARCHIVE").
+				String(),
+			Age: app.Flag("ms-archive-age", "The age from which the complete message files are moved into the archive").
+				Default(defaultMSArchiveAge).
+				Envar("GUBLE_MS_ARCHIVE_AGE").
+				Duration(),
+			CacheFiles: app.Flag("ms-archive-cache-files", "The number of archived message files per partition kept on the disk after fetching them").
+				Default(defaultMSArchiveCache).
+				Envar("GUBLE_MS_ARCHIVE_CACHE_FILES").
+				Int(),
+			Endpoint: app.Flag("ms-archive-endpoint", "The endpoint of the S3-compatible archive storage (default: according to the archive URL and region)").
+				Envar("GUBLE_MS_ARCHIVE_ENDPOINT").
+				String(),
+			Region: app.Flag("ms-archive-region", "The region of the archive bucket (default: us-east-1 for s3, auto for gs)").
+				Envar("GUBLE_MS_ARCHIVE_REGION").
+				String(),
+			AccessKey: app.Flag("ms-archive-access-key", "The access key of the archive storage (the HMAC key for gs)").
+				Envar("GUBLE_MS_ARCHIVE_ACCESS_KEY").
+				String(),
+			SecretKey: app.Flag("ms-archive-secret-key", "The secret key of the archive storage").
+				Envar("GUBLE_MS_ARCHIVE_SECRET_KEY").
+				String(),
+		},
+		StoragePath: app.Flag("storage-path", "The path for storing messages and key-value data if 'file' is selected").
 			Default(defaultStoragePath).
 			Envar("GUBLE_STORAGE_PATH").
 			ExistingDir(),
-		HealthEndpoint: kingpin.Flag("health-endpoint", `The health endpoint to be used by the HTTP server (value for disabling it: "")`).
+		HealthEndpoint: app.Flag("health-endpoint", `The health endpoint to be used by the HTTP server (value for disabling it: "")`).
 			Default(defaultHealthEndpoint).
 			Envar("GUBLE_HEALTH_ENDPOINT").
 			String(),
-		MetricsEndpoint: kingpin.Flag("metrics-endpoint", `The metrics endpoint to be used by the HTTP server (value for disabling it: "")`).
+		MetricsEndpoint: app.Flag("metrics-endpoint", `The metrics endpoint to be used by the HTTP server (value for disabling it: "")`).
 			Default(defaultMetricsEndpoint).
 			Envar("GUBLE_METRICS_ENDPOINT").
 			String(),
-		Profile: kingpin.Flag("profile", `The profiler to be used (default: none): mem | cpu | block`).
+		DebugEndpoint: app.Flag("debug-endpoint", `The endpoint of the pprof, expvar and goroutine dumps, requiring a token with the admin scope of the authentication provider, e.g. "/admin/debug/" (default: disabled)`).
+			Envar("GUBLE_DEBUG_ENDPOINT").
+			String(),
+		DrainEndpoint: app.Flag("drain-endpoint", `The endpoint draining the server before it is stopped, e.g. by a preStop hook (value for disabling it: "")`).
+			Default(defaultDrainEndpoint).
+			Envar("GUBLE_DRAIN_ENDPOINT").
+			String(),
+		DrainTimeout: app.Flag("drain-timeout", "The maximum duration of the draining, by the drain endpoint or on SIGTERM, in which the websockets are closed gracefully").
+			Default(defaultDrainTimeout).
+			Envar("GUBLE_DRAIN_TIMEOUT").
+			Duration(),
+		Chaos: app.Flag("chaos", "Start in chaos-mode, in which faults can be injected with the /admin/chaos/ endpoint, for testing").
+			Envar("GUBLE_CHAOS").
+			Bool(),
+		MetricsSnapshot: app.Flag("metrics-snapshot-interval", "The interval at which the counter metrics are persisted in the key-value store, keeping them across restarts (default: not persisted)").
+			Envar("GUBLE_METRICS_SNAPSHOT_INTERVAL").
+			Duration(),
+		StatsD: StatsDConfig{
+			Address: app.Flag("statsd", "The address (host:port) of the StatsD or DogStatsD server to which the metrics are exported over UDP (default: not exported)").
+				Envar("GUBLE_STATSD").
+				String(),
+			Prefix: app.Flag("statsd-prefix", "The prefix of the names of the metrics exported to StatsD").
+				Default(defaultStatsDPrefix).
+				Envar("GUBLE_STATSD_PREFIX").
+				String(),
+			Tags: stringListParser(app.Flag("statsd-tag", `A tag of the metrics exported to DogStatsD, e.g. "env:prod" (can be repeated)`).
+				Envar("GUBLE_STATSD_TAGS")),
+			Interval: app.Flag("statsd-interval", "The interval at which the metrics are exported to StatsD").
+				Default(defaultStatsDInterval).
+				Envar("GUBLE_STATSD_INTERVAL").
+				Duration(),
+		},
+		Lag: LagConfig{
+			Interval: app.Flag("lag-interval", "The interval at which the delivery and replication lags are checked").
+				Default(defaultLagInterval).
+				Envar("GUBLE_LAG_INTERVAL").
+				Duration(),
+			DeliveryThreshold: app.Flag("lag-delivery-threshold", "The age of the oldest message waiting in a connector above which an alert is raised (0: no alerts)").
+				Default(defaultLagDelivery).
+				Envar("GUBLE_LAG_DELIVERY_THRESHOLD").
+				Duration(),
+			ReplicationThreshold: app.Flag("lag-replication-threshold", "The replication lag of the messages of a cluster peer above which an alert is raised (0: no alerts)").
+				Default(defaultLagReplication).
+				Envar("GUBLE_LAG_REPLICATION_THRESHOLD").
+				Duration(),
+			URL: app.Flag("lag-alert-url", "The URL to which the lag alerts are posted as JSON").
+				Envar("GUBLE_LAG_ALERT_URL").
+				String(),
+			Topic: app.Flag("lag-alert-topic", `The topic to which the lag alerts are published, e.g. "/alerts"`).
+				Envar("GUBLE_LAG_ALERT_TOPIC").
+				String(),
+		},
+		Hooks: stringListParser(app.Flag("hook", "The URL of an external hook, intercepting the published messages before they are stored (can be repeated)").
+			Envar("GUBLE_HOOKS")),
+		HookTimeout: app.Flag("hook-timeout", "The timeout of the calls to the external hooks").
+			Default(defaultHookTimeout).
+			Envar("GUBLE_HOOK_TIMEOUT").
+			Duration(),
+		Proxy: app.Flag("proxy", `The URL of the proxy for the outbound traffic of the connectors and hooks : http://[user:password@]host:port | https://... | socks5://... (default: the HTTPS_PROXY environment variable)`).
+			Envar("GUBLE_PROXY").
+			String(),
+		ConnectorAuth: app.Flag("connector-auth-url", `The URL authenticating the tokens of the requests to the FCM and APNS endpoints, given as "Authorization: Bearer <token>" header (default: no authentication)`).
+			Envar("GUBLE_CONNECTOR_AUTH_URL").
+			String(),
+		HeaderMaxSize: app.Flag("header-max-size", "The maximum size in bytes of the header of a published message (value for no limit: 0)").
+			Default(defaultHeaderMaxSize).
+			Envar("GUBLE_HEADER_MAX_SIZE").
+			Int(),
+		HeaderMaxKeys: app.Flag("header-max-keys", "The maximum number of keys of the header of a published message (value for no limit: 0)").
+			Default(defaultHeaderMaxKeys).
+			Envar("GUBLE_HEADER_MAX_KEYS").
+			Int(),
+		RedactTopics: stringListParser(app.Flag("redact-topic", `The topic pattern (e.g. "/users/*") of the messages whose bodies are masked in the logs; can be repeated (messages with the "Redact" header flag are masked as well)`).
+			Envar("GUBLE_REDACT_TOPICS")),
+		EphemeralTopics: stringListParser(app.Flag("ephemeral-topic", `The topic (e.g. "/typing") whose messages are delivered to the current subscribers, but not stored; can be repeated (messages with the "Ephemeral" header flag are not stored either)`).
+			Envar("GUBLE_EPHEMERAL_TOPICS")),
+		SearchTopics: stringListParser(app.Flag("search-topic", `The topic (e.g. "/notifications") whose stored messages are indexed for the search endpoint /admin/search; can be repeated`).
+			Envar("GUBLE_SEARCH_TOPICS")),
+		Delivery: DeliveryConfig{
+			WebsocketWorkers: app.Flag("delivery-websocket-workers", "The number of goroutines delivering the messages to the websocket routes (0: delivered by the routing goroutine)").
+				Envar("GUBLE_DELIVERY_WEBSOCKET_WORKERS").
+				Default("0").
+				Int(),
+			ConnectorWorkers: app.Flag("delivery-connector-workers", "The number of goroutines delivering the messages to the routes of the connectors (0: delivered by the routing goroutine)").
+				Envar("GUBLE_DELIVERY_CONNECTOR_WORKERS").
+				Default("0").
+				Int(),
+		},
+		Broadcast: BroadcastConfig{
+			Topic: app.Flag("broadcast-topic", "The topic (matching its subtopics as well) whose messages are delivered to the subscribers over --broadcast-spread").
+				Envar("GUBLE_BROADCAST_TOPIC").
+				Default("/broadcast").
+				String(),
+			Spread: app.Flag("broadcast-spread", "The duration over which the messages of the broadcast topic are delivered to the subscribers of each node (0: at once)").
+				Envar("GUBLE_BROADCAST_SPREAD").
+				Default("0").
+				Duration(),
+		},
+		Compression: CompressionConfig{
+			Encoding: app.Flag("compression", "The content encoding with which the large message bodies are stored, and sent to the websockets accepting it (default: none): gzip | zstd").
+				Envar("GUBLE_COMPRESSION").
+				Default("").
+				Enum("gzip", "zstd", ""),
+			Threshold: app.Flag("compression-threshold", "The minimum size in bytes of the message bodies which are compressed").
+				Envar("GUBLE_COMPRESSION_THRESHOLD").
+				Default("1024").
+				Int(),
+		},
+		Profile: app.Flag("profile", `The profiler to be used (default: none): mem | cpu | block`).
 			Default("").
 			Envar("GUBLE_PROFILE").
 			Enum("mem", "cpu", "block", ""),
 		Postgres: PostgresConfig{
-			Host: kingpin.Flag("pg-host", "The PostgreSQL hostname").
+			Host: app.Flag("pg-host", "The PostgreSQL hostname").
 				Default("localhost").
 				Envar("GUBLE_PG_HOST").
 				String(),
-			Port: kingpin.Flag("pg-port", "The PostgreSQL port").
+			Port: app.Flag("pg-port", "The PostgreSQL port").
 				Default("5432").
 				Envar("GUBLE_PG_PORT").
 				Int(),
-			User: kingpin.Flag("pg-user", "The PostgreSQL user").
+			User: app.Flag("pg-user", "The PostgreSQL user").
 				Default("guble").
 				Envar("GUBLE_PG_USER").
 				String(),
-			Password: kingpin.Flag("pg-password", "The PostgreSQL password").
+			Password: app.Flag("pg-password", "The PostgreSQL password").
 				Default("guble").
 				Envar("GUBLE_PG_PASSWORD").
 				String(),
-			DbName: kingpin.Flag("pg-dbname", "The PostgreSQL database name").
+			DbName: app.Flag("pg-dbname", "The PostgreSQL database name").
 				Default("guble").
 				Envar("GUBLE_PG_DBNAME").
 				String(),
 		},
+		MySQL: MySQLConfig{
+			Host: app.Flag("mysql-host", "The MySQL hostname").
+				Default("localhost").
+				Envar("GUBLE_MYSQL_HOST").
+				String(),
+			Port: app.Flag("mysql-port", "The MySQL port").
+				Default("3306").
+				Envar("GUBLE_MYSQL_PORT").
+				Int(),
+			User: app.Flag("mysql-user", "The MySQL user").
+				Default("guble").
+				Envar("GUBLE_MYSQL_USER").
+				String(),
+			Password: app.Flag("mysql-password", "The MySQL password").
+				Default("guble").
+				Envar("GUBLE_MYSQL_PASSWORD").
+				String(),
+			DbName: app.Flag("mysql-dbname", "The MySQL database name").
+				Default("guble").
+				Envar("GUBLE_MYSQL_DBNAME").
+				String(),
+		},
+		Redis: RedisConfig{
+			Addr: app.Flag("redis-addr", `The Redis address (format: "Host:Port")`).
+				Default("localhost:6379").
+				Envar("GUBLE_REDIS_ADDR").
+				String(),
+			Password: app.Flag("redis-password", "The Redis password").
+				Envar("GUBLE_REDIS_PASSWORD").
+				String(),
+			DB: app.Flag("redis-db", "The Redis database number").
+				Default("0").
+				Envar("GUBLE_REDIS_DB").
+				Int(),
+			Notifications: app.Flag("redis-notifications", "Enable the Redis keyspace notifications, for watching the subscription changes done by other nodes").
+				Envar("GUBLE_REDIS_NOTIFICATIONS").
+				Bool(),
+		},
 		FCM: fcm.Config{
-			Enabled: kingpin.Flag("fcm", "Enable the Google Firebase Cloud Messaging connector").
+			Enabled: app.Flag("fcm", "Enable the Google Firebase Cloud Messaging connector").
 				Envar("GUBLE_FCM").
 				Bool(),
-			APIKey: kingpin.Flag("fcm-api-key", "The Google API Key for Google Firebase Cloud Messaging").
+			APIKey: app.Flag("fcm-api-key", "The Google API Key for Google Firebase Cloud Messaging").
 				Envar("GUBLE_FCM_API_KEY").
 				String(),
-			Workers: kingpin.Flag("fcm-workers", "The number of workers handling traffic with Firebase Cloud Messaging (default: number of CPUs)").
+			Workers: app.Flag("fcm-workers", "The number of workers handling traffic with Firebase Cloud Messaging (default: number of CPUs)").
 				Default(strconv.Itoa(runtime.NumCPU())).
 				Envar("GUBLE_FCM_WORKERS").
 				Int(),
-			Endpoint: kingpin.Flag("fcm-endpoint", "The Google Firebase Cloud Messaging endpoint").
+			Endpoint: app.Flag("fcm-endpoint", "The Google Firebase Cloud Messaging endpoint").
 				Default(defaultFCMEndpoint).
 				Envar("GUBLE_FCM_ENDPOINT").
 				String(),
-			Prefix: kingpin.Flag("fcm-prefix", "The FCM prefix / endpoint").
+			Prefix: app.Flag("fcm-prefix", "The FCM prefix / endpoint").
 				Envar("GUBLE_FCM_PREFIX").
 				Default("/fcm/").
 				String(),
+			Collapse: collapseWindowsParser(app.Flag("fcm-collapse", `The collapsing windows of the FCM messages, per topic (format: "/topic=30s /other=1m")`).
+				Envar("GUBLE_FCM_COLLAPSE")),
+			HealthCheck: app.Flag("fcm-health-check", "The interval of the health checks of the FCM device tokens, removing the dead ones (default: only on request)").
+				Envar("GUBLE_FCM_HEALTH_CHECK").
+				Duration(),
+			MaxFailures: app.Flag("fcm-max-failures", "The number of consecutive failed pushes after which an FCM device token is dead, if FCM can not check it (0: never)").
+				Default(defaultMaxFailures).
+				Envar("GUBLE_FCM_MAX_FAILURES").
+				Int(),
+			BreakerFailures: app.Flag("fcm-breaker-failures", "The number of consecutive failures of Firebase Cloud Messaging after which the sending is paused (0: never)").
+				Default(defaultBreakerFailures).
+				Envar("GUBLE_FCM_BREAKER_FAILURES").
+				Int(),
+			BreakerProbe: app.Flag("fcm-breaker-probe", "The interval at which a single message probes Firebase Cloud Messaging while the sending is paused").
+				Default(defaultBreakerProbe).
+				Envar("GUBLE_FCM_BREAKER_PROBE").
+				Duration(),
+			Lazy: app.Flag("fcm-lazy", "Create the router routes of the FCM subscriptions only on their first matching message, saving the resources of the idle devices").
+				Envar("GUBLE_FCM_LAZY").
+				Bool(),
+			SharedRoutes: app.Flag("fcm-shared-routes", "Share a single router route among the FCM subscriptions of a topic, instead of a route for each subscription").
+				Envar("GUBLE_FCM_SHARED_ROUTES").
+				Bool(),
+			Proxy: app.Flag("fcm-proxy", "The URL of the proxy for the traffic with Firebase Cloud Messaging (default: the global proxy)").
+				Envar("GUBLE_FCM_PROXY").
+				String(),
 			IntervalMetrics: &defaultFCMMetrics,
 		},
 		APNS: apns.Config{
-			Enabled: kingpin.Flag("apns", "Enable the APNS connector (by default, in Development mode)").
+			Enabled: app.Flag("apns", "Enable the APNS connector (by default, in Development mode)").
 				Envar("GUBLE_APNS").
 				Bool(),
-			Production: kingpin.Flag("apns-production", "Enable the APNS connector in Production mode").
+			Production: app.Flag("apns-production", "Push to the APNS production environment the devices not subscribed with an environment (default: sandbox)").
 				Envar("GUBLE_APNS_PRODUCTION").
 				Bool(),
-			CertificateFileName: kingpin.Flag("apns-cert-file", "The APNS certificate file name").
+			CertificateFileName: app.Flag("apns-cert-file", "The APNS certificate file name").
 				Envar("GUBLE_APNS_CERT_FILE").
 				String(),
-			CertificateBytes: kingpin.Flag("apns-cert-bytes", "The APNS certificate bytes, as a string of hex-values").
+			CertificateBytes: app.Flag("apns-cert-bytes", "The APNS certificate bytes, as a string of hex-values").
 				Envar("GUBLE_APNS_CERT_BYTES").
 				HexBytes(),
-			CertificatePassword: kingpin.Flag("apns-cert-password", "The APNS certificate password").
+			CertificatePassword: app.Flag("apns-cert-password", "The APNS certificate password").
 				Envar("GUBLE_APNS_CERT_PASSWORD").
 				String(),
-			AppTopic: kingpin.Flag("apns-app-topic", "The APNS topic (as used by the mobile application)").
+			AppTopic: app.Flag("apns-app-topic", "The APNS topic (as used by the mobile application)").
 				Envar("GUBLE_APNS_APP_TOPIC").
 				String(),
-			Prefix: kingpin.Flag("apns-prefix", "The APNS prefix / endpoint").
+			Prefix: app.Flag("apns-prefix", "The APNS prefix / endpoint").
 				Envar("GUBLE_APNS_PREFIX").
 				Default("/apns/").
 				String(),
-			Workers: kingpin.Flag("apns-workers", "The number of workers handling traffic with APNS (default: number of CPUs)").
+			Workers: app.Flag("apns-workers", "The number of workers handling traffic with APNS (default: number of CPUs)").
 				Default(strconv.Itoa(runtime.NumCPU())).
 				Envar("GUBLE_APNS_WORKERS").
 				Int(),
+			Collapse: collapseWindowsParser(app.Flag("apns-collapse", `The collapsing windows of the APNS messages, per topic (format: "/topic=30s /other=1m")`).
+				Envar("GUBLE_APNS_COLLAPSE")),
+			HealthCheck: app.Flag("apns-health-check", "The interval of the health checks of the APNS device tokens, removing the dead ones (default: only on request)").
+				Envar("GUBLE_APNS_HEALTH_CHECK").
+				Duration(),
+			MaxFailures: app.Flag("apns-max-failures", "The number of consecutive failed pushes after which an APNS device token is dead (0: never)").
+				Default(defaultMaxFailures).
+				Envar("GUBLE_APNS_MAX_FAILURES").
+				Int(),
+			BreakerFailures: app.Flag("apns-breaker-failures", "The number of consecutive failures of APNS after which the sending is paused (0: never)").
+				Default(defaultBreakerFailures).
+				Envar("GUBLE_APNS_BREAKER_FAILURES").
+				Int(),
+			BreakerProbe: app.Flag("apns-breaker-probe", "The interval at which a single message probes APNS while the sending is paused").
+				Default(defaultBreakerProbe).
+				Envar("GUBLE_APNS_BREAKER_PROBE").
+				Duration(),
+			Lazy: app.Flag("apns-lazy", "Create the router routes of the APNS subscriptions only on their first matching message, saving the resources of the idle devices").
+				Envar("GUBLE_APNS_LAZY").
+				Bool(),
+			SharedRoutes: app.Flag("apns-shared-routes", "Share a single router route among the APNS subscriptions of a topic, instead of a route for each subscription").
+				Envar("GUBLE_APNS_SHARED_ROUTES").
+				Bool(),
+			Proxy: app.Flag("apns-proxy", "The URL of the proxy for the traffic with APNS (default: the global proxy)").
+				Envar("GUBLE_APNS_PROXY").
+				String(),
 			IntervalMetrics: &defaultAPNSMetrics,
 		},
+		Forward: forward.Config{
+			Topic: app.Flag("forward-topic", "The topic (including its subtopics) whose messages are forwarded to --forward-url (default: none)").
+				Envar("GUBLE_FORWARD_TOPIC").
+				String(),
+			URL: app.Flag("forward-url", `The template of the URL to which the messages are forwarded (e.g. "https://example.com/orders/{{.Header.orderId}}")`).
+				Envar("GUBLE_FORWARD_URL").
+				String(),
+			Method: app.Flag("forward-method", "The HTTP method of the forwarded messages").
+				Default("POST").
+				Envar("GUBLE_FORWARD_METHOD").
+				String(),
+			Body: app.Flag("forward-body", `The template of the body of the forwarded messages (e.g. '{"text": {{json .Body}}}')`).
+				Default("{{.Body}}").
+				Envar("GUBLE_FORWARD_BODY").
+				String(),
+			ContentType: app.Flag("forward-content-type", "The content type of the forwarded messages").
+				Default("application/json").
+				Envar("GUBLE_FORWARD_CONTENT_TYPE").
+				String(),
+			Retries: app.Flag("forward-retries", "The number of retries of a message whose forwarding failed with a network error, a 5xx status or 429").
+				Default("3").
+				Envar("GUBLE_FORWARD_RETRIES").
+				Int(),
+			Timeout: app.Flag("forward-timeout", "The timeout of a forwarding request").
+				Default("10s").
+				Envar("GUBLE_FORWARD_TIMEOUT").
+				Duration(),
+			Connections: app.Flag("forward-connections", "The number of idle connections to the forwarding endpoint kept open for reuse").
+				Default("10").
+				Envar("GUBLE_FORWARD_CONNECTIONS").
+				Int(),
+			CloudEvents: app.Flag("forward-cloudevents", "Forward the messages as CloudEvents, in the binary or structured mode (default: plain requests)").
+				Envar("GUBLE_FORWARD_CLOUDEVENTS").
+				Enum("", forward.CloudEventsBinary, forward.CloudEventsStructured),
+		},
+		Email: email.Config{
+			Enabled: app.Flag("email", "Enable the inbound email gateway, publishing the emails posted by Mailgun to the topics of their recipients").
+				Envar("GUBLE_EMAIL").
+				Bool(),
+			Prefix: app.Flag("email-prefix", "The inbound email prefix / endpoint").
+				Default("/email/").
+				Envar("GUBLE_EMAIL_PREFIX").
+				String(),
+			Topic: app.Flag("email-topic", "The topic whose subtopics receive the inbound emails (e.g. /email/support for support@...)").
+				Default("/email").
+				Envar("GUBLE_EMAIL_TOPIC").
+				String(),
+			SigningKey: app.Flag("email-signing-key", "The key verifying the signatures of the posted emails (the Mailgun webhook signing key)").
+				Envar("GUBLE_EMAIL_SIGNING_KEY").
+				String(),
+		},
+		Chat: chat.Config{
+			Routes: stringListParser(app.Flag("chat-route", `The route "<topic>=<slack|teams>:<webhook URL>" posting the messages of the topic (including its subtopics) to a Slack or Teams channel; can be repeated`).
+				Envar("GUBLE_CHAT_ROUTES")),
+			Format: app.Flag("chat-format", "The template of the text of the messages posted to the chat channels").
+				Default("{{.Topic}}: {{.Body}}").
+				Envar("GUBLE_CHAT_FORMAT").
+				String(),
+			Retries: app.Flag("chat-retries", "The number of retries of a message whose posting to a chat channel failed").
+				Default("3").
+				Envar("GUBLE_CHAT_RETRIES").
+				Int(),
+			Timeout: app.Flag("chat-timeout", "The timeout of a request posting a message to a chat channel").
+				Default("10s").
+				Envar("GUBLE_CHAT_TIMEOUT").
+				Duration(),
+			Connections: app.Flag("chat-connections", "The number of idle connections to each chat webhook kept open for reuse").
+				Default("2").
+				Envar("GUBLE_CHAT_CONNECTIONS").
+				Int(),
+		},
+		External: stringListParser(app.Flag("external-connector", `The external connector "<name>=<command> [<arguments>]" whose process delivers the messages of the subscriptions of /<name>/; can be repeated`).
+			Envar("GUBLE_EXTERNAL_CONNECTORS")),
+		DummyPush: dummypush.Config{
+			Enabled: app.Flag("dummypush", "Enable the dummy push connector, recording its notifications (listed by /admin/dummypush/) instead of pushing them").
+				Envar("GUBLE_DUMMYPUSH").
+				Bool(),
+			Prefix: app.Flag("dummypush-prefix", "The dummy push prefix / endpoint").
+				Envar("GUBLE_DUMMYPUSH_PREFIX").
+				Default("/dummypush/").
+				String(),
+			Workers: app.Flag("dummypush-workers", "The number of workers recording the dummy push notifications (default: number of CPUs)").
+				Default(strconv.Itoa(runtime.NumCPU())).
+				Envar("GUBLE_DUMMYPUSH_WORKERS").
+				Int(),
+			Capacity: app.Flag("dummypush-capacity", "The number of recorded dummy push notifications kept, the oldest ones being forgotten").
+				Envar("GUBLE_DUMMYPUSH_CAPACITY").
+				Default(defaultDummyCapacity).
+				Int(),
+		},
 		Cluster: ClusterConfig{
-			NodeID: kingpin.Flag("node-id", "(cluster mode) This guble node's own ID: a strictly positive integer number which must be unique in cluster").
+			NodeID: app.Flag("node-id", "(cluster mode) This guble node's own ID: a strictly positive integer number which must be unique in cluster").
 				Envar("GUBLE_NODE_ID").Uint8(),
-			NodePort: kingpin.Flag("node-port", "(cluster mode) This guble node's own local port: a strictly positive integer number").
+			NodePort: app.Flag("node-port", "(cluster mode) This guble node's own local port: a strictly positive integer number").
 				Default(defaultNodePort).Envar("GUBLE_NODE_PORT").Int(),
-			Remotes: tcpAddrListParser(kingpin.Flag("remotes", `(cluster mode) The list of TCP addresses of some other guble nodes (format: "IP:port")`).
+			NodeAdvertiseHost: app.Flag("node-advertise-host", "(cluster mode) The IP or host name at which the other guble nodes reach this node, if it differs from the local one (e.g. behind a NAT)").
+				Envar("GUBLE_NODE_ADVERTISE_HOST").String(),
+			NodeAdvertisePort: app.Flag("node-advertise-port", "(cluster mode) The port at which the other guble nodes reach this node (default: the node port)").
+				Envar("GUBLE_NODE_ADVERTISE_PORT").Int(),
+			Remotes: tcpAddrListParser(app.Flag("remotes", `(cluster mode) The list of TCP addresses of some other guble nodes, separated by spaces or commas (format: "IP:port")`).
 				Envar("GUBLE_NODE_REMOTES")),
+			Replica: app.Flag("node-replica", "(cluster mode) Run this guble node as a read-only replica, serving fetches and subscriptions, but not accepting publishing").
+				Envar("GUBLE_NODE_REPLICA").Bool(),
+			FetchTimeout: app.Flag("node-fetch-timeout", "(cluster mode) The time during which a fetch waits for the messages of the other guble nodes missing locally (0: fetch from the local store only)").
+				Default(cluster.DefaultFetchTimeout.String()).Envar("GUBLE_NODE_FETCH_TIMEOUT").Duration(),
+			JoinTimeout: app.Flag("node-join-timeout", "(cluster mode) The time during which this guble node retries to join the remotes when it starts, with an exponential backoff (0: a single attempt)").
+				Default(cluster.DefaultJoinTimeout.String()).Envar("GUBLE_NODE_JOIN_TIMEOUT").Duration(),
+			DataPort: app.Flag("node-data-port", "(cluster mode) The local port on which the messages are replicated between the guble nodes, separately from the node port carrying the membership and the control messages (0: the node port)").
+				Envar("GUBLE_NODE_DATA_PORT").Int(),
+			DataURL: app.Flag("node-data-url", "(cluster mode) The URL at which the other guble nodes replicate their messages over a websocket to this node (path: /cluster/replication), for the networks only allowing HTTP(S) between the nodes").
+				Envar("GUBLE_NODE_DATA_URL").String(),
+			DataSecret: app.Flag("node-data-secret", "(cluster mode) The secret shared by the guble nodes, authenticating the websockets on which the messages are replicated").
+				Envar("GUBLE_NODE_DATA_SECRET").String(),
+			ControlRate: app.Flag("node-control-rate", "(cluster mode) The maximum number of control messages per second sent to the other guble nodes (0: no limit)").
+				Envar("GUBLE_NODE_CONTROL_RATE").Float64(),
+			DataRate: app.Flag("node-data-rate", "(cluster mode) The maximum number of replicated messages per second sent to the other guble nodes (0: no limit)").
+				Envar("GUBLE_NODE_DATA_RATE").Float64(),
+			Codec: app.Flag("node-codec", "(cluster mode) The encoding of the messages sent to the other guble nodes: msgpack | protobuf (the messages of the other nodes are decoded whatever their encoding)").
+				Default(cluster.MsgpackCodec).Envar("GUBLE_NODE_CODEC").Enum(cluster.MsgpackCodec, cluster.ProtobufCodec),
+			Zone: app.Flag("node-zone", "(cluster mode) The availability zone of this guble node (e.g. \"eu-west-1a\"): the messages are broadcast to the nodes of the same zone first").
+				Envar("GUBLE_NODE_ZONE").String(),
+			Weight: app.Flag("node-weight", "(cluster mode) The capacity of this guble node relative to the other nodes: the messages are broadcast to the nodes of higher weight first").
+				Default("1").Envar("GUBLE_NODE_WEIGHT").Int(),
+			CrossZoneFanout: app.Flag("node-cross-zone-fanout", "(cluster mode) The maximum number of nodes of another zone to which a message is broadcast directly, relaying it to the other nodes of their zone (0: no limit)").
+				Envar("GUBLE_NODE_CROSS_ZONE_FANOUT").Int(),
+		},
+		Auth: AuthConfig{
+			Provider: app.Flag("auth-provider", "The provider authenticating the users of the websockets, of the REST API and of the connectors : none | rest | oauth2 | ldap").
+				Envar("GUBLE_AUTH_PROVIDER").
+				Default(authProviderNone).
+				Enum(authProviderNone, authProviderREST, authProviderOAuth2, authProviderLDAP),
+			URL: app.Flag("auth-url", "The URL of the provider : the REST authentication endpoint, the OAuth2 token introspection endpoint, or the LDAP server (ldap://host:389 | ldaps://host:636)").
+				Envar("GUBLE_AUTH_URL").
+				String(),
+			ClientID: app.Flag("auth-client-id", "The OAuth2 client ID of guble, for the token introspection").
+				Envar("GUBLE_AUTH_CLIENT_ID").
+				String(),
+			ClientSecret: app.Flag("auth-client-secret", "The OAuth2 client secret of guble, for the token introspection").
+				Envar("GUBLE_AUTH_CLIENT_SECRET").
+				String(),
+			LDAPUserDN: app.Flag("auth-ldap-user-dn", `The distinguished name of the LDAP users, with the user as "%s" (e.g. "uid=%s,ou=people,dc=example,dc=com")`).
+				Envar("GUBLE_AUTH_LDAP_USER_DN").
+				String(),
+		},
+		Throttle: ThrottleConfig{
+			Rate: app.Flag("throttle-rate", "The number of publishing and subscribing requests per second allowed for each address and credential (0: no limit)").
+				Envar("GUBLE_THROTTLE_RATE").
+				Default("0").
+				Float64(),
+			Burst: app.Flag("throttle-burst", "The number of requests an address or credential can send at once (default: the rate)").
+				Envar("GUBLE_THROTTLE_BURST").
+				Int(),
+			BanAfter: app.Flag("throttle-ban-after", "The number of rejected requests after which an address or credential is banned (0: never)").
+				Envar("GUBLE_THROTTLE_BAN_AFTER").
+				Default(defaultBanAfter).
+				Int(),
+			Ban: app.Flag("throttle-ban", "The duration of the bans").
+				Envar("GUBLE_THROTTLE_BAN").
+				Default(throttle.DefaultBan.String()).
+				Duration(),
+			TrustForwarded: app.Flag("throttle-trust-forwarded", "Limit the address given by the X-Forwarded-For header (only behind a proxy setting it)").
+				Envar("GUBLE_THROTTLE_TRUST_FORWARDED").
+				Bool(),
 		},
 		SMS: sms.Config{
-			Enabled: kingpin.Flag("sms", "Enable the  SMS  gateway)").
+			Enabled: app.Flag("sms", "Enable the  SMS  gateway)").
 				Envar("GUBLE_SMS").
 				Bool(),
-			APIKey: kingpin.Flag("sms-api-key", "The Nexmo API Key for Sending sms").
+			Provider: app.Flag("sms-provider", "The default provider sending the sms : nexmo | twilio").
+				Default(sms.NexmoProvider).
+				Envar("GUBLE_SMS_PROVIDER").
+				Enum(sms.NexmoProvider, sms.TwilioProvider),
+			APIKey: app.Flag("sms-api-key", "The Nexmo API Key for Sending sms").
 				Envar("GUBLE_SMS_API_KEY").
 				String(),
-			APISecret: kingpin.Flag("sms-api-secret", "The Nexmo API Secret for Sending sms").
+			APISecret: app.Flag("sms-api-secret", "The Nexmo API Secret for Sending sms").
 				Envar("GUBLE_SMS_API_SECRET").
 				String(),
-			SMSTopic: kingpin.Flag("sms-topic", "The topic for sms route").
+			TwilioAccountSID: app.Flag("sms-twilio-account-sid", "The Twilio Account SID for Sending sms").
+				Envar("GUBLE_SMS_TWILIO_ACCOUNT_SID").
+				String(),
+			TwilioAuthToken: app.Flag("sms-twilio-auth-token", "The Twilio Auth Token for Sending sms").
+				Envar("GUBLE_SMS_TWILIO_AUTH_TOKEN").
+				String(),
+			SMSTopic: app.Flag("sms-topic", "The topic for sms route").
 				Envar("GUBLE_SMS_TOPIC").
 				Default(sms.SMSDefaultTopic).
 				String(),
+			Prefix: app.Flag("sms-prefix", "The SMS prefix / endpoint, receiving the delivery reports of the providers").
+				Envar("GUBLE_SMS_PREFIX").
+				Default(sms.SMSDefaultPrefix).
+				String(),
+			CallbackURL: app.Flag("sms-callback-url", `The public URL of this guble server, for requesting the delivery reports from the providers (e.g. "https://guble.example.com")`).
+				Envar("GUBLE_SMS_CALLBACK_URL").
+				String(),
 
-			Workers: kingpin.Flag("sms-workers", "The number of workers handling traffic with Nexmo sms endpoint(default: number of CPUs)").
+			Workers: app.Flag("sms-workers", "The number of workers handling traffic with Nexmo sms endpoint(default: number of CPUs)").
 				Default(strconv.Itoa(runtime.NumCPU())).
 				Envar("GUBLE_SMS_WORKERS").
 				Int(),
+			BreakerFailures: app.Flag("sms-breaker-failures", "The number of consecutive failures of the SMS provider after which the sending is paused (0: never)").
+				Default(defaultBreakerFailures).
+				Envar("GUBLE_SMS_BREAKER_FAILURES").
+				Int(),
+			BreakerProbe: app.Flag("sms-breaker-probe", "The interval at which a single message probes the SMS provider while the sending is paused").
+				Default(defaultBreakerProbe).
+				Envar("GUBLE_SMS_BREAKER_PROBE").
+				Duration(),
+			Proxy: app.Flag("sms-proxy", "The URL of the proxy for the traffic with the SMS providers (default: the global proxy)").
+				Envar("GUBLE_SMS_PROXY").
+				String(),
 			IntervalMetrics: &defaultSMSMetrics,
 		},
+		Notify: notify.Config{
+			Enabled: app.Flag("notify", "Enable the notification router, escalating the notifications over the channels of the users").
+				Envar("GUBLE_NOTIFY").
+				Bool(),
+			Prefix: app.Flag("notify-prefix", "The notification policies prefix / endpoint").
+				Envar("GUBLE_NOTIFY_PREFIX").
+				Default(notify.DefaultPrefix).
+				String(),
+			Topic: app.Flag("notify-topic", "The topic of the notifications, followed by the user id").
+				Envar("GUBLE_NOTIFY_TOPIC").
+				Default(notify.DefaultTopic).
+				String(),
+			SMSFrom: app.Flag("notify-sms-from", "The sender of the notifications delivered as sms").
+				Envar("GUBLE_NOTIFY_SMS_FROM").
+				Default(notify.DefaultSMSFrom).
+				String(),
+		},
+		Campaign: campaign.Config{
+			Enabled: app.Flag("campaign", "Enable the campaigns, publishing a message to a topic or a list of users in the background").
+				Envar("GUBLE_CAMPAIGN").
+				Bool(),
+			Prefix: app.Flag("campaign-prefix", "The campaigns prefix / endpoint").
+				Envar("GUBLE_CAMPAIGN_PREFIX").
+				Default(campaign.DefaultPrefix).
+				String(),
+			Rate: app.Flag("campaign-rate", "The number of messages per second published by all the campaigns together (0: no limit)").
+				Envar("GUBLE_CAMPAIGN_RATE").
+				Default(strconv.Itoa(campaign.DefaultRate)).
+				Float64(),
+		},
+		WS: websocket.Config{
+			Origins: stringListParser(app.Flag("ws-origin", `The origin allowed to connect a websocket, as a pattern (e.g. "https://*.example.com"); can be repeated (default: all the origins)`).
+				Envar("GUBLE_WS_ORIGINS")),
+			Tickets: app.Flag("ws-tickets", "Require a one-time ticket issued by the REST API for connecting a websocket").
+				Envar("GUBLE_WS_TICKETS").
+				Bool(),
+			TicketTTL: app.Flag("ws-ticket-ttl", "The time during which a websocket ticket can be used").
+				Envar("GUBLE_WS_TICKET_TTL").
+				Default(websocket.DefaultTicketTTL.String()).
+				Duration(),
+			AuthURL: app.Flag("ws-auth-url", `The URL authenticating the tokens of the websockets, given as "Authorization: Bearer <token>" header (default: no authentication)`).
+				Envar("GUBLE_WS_AUTH_URL").
+				String(),
+			TrustPathUser: app.Flag("ws-trust-path-user", "Trust the websocket clients to act as the user of their path, even if it differs from the user of their ticket or token (for compatibility)").
+				Envar("GUBLE_WS_TRUST_PATH_USER").
+				Bool(),
+			SigningKey: app.Flag("ws-signing-key", "The key of the signed URLs, allowing a websocket to subscribe to a single topic until their expiration without other credentials (default: no signed URLs)").
+				Envar("GUBLE_WS_SIGNING_KEY").
+				String(),
+		},
 	}
-)
+}
 
 func logLevels() (levels []string) {
 	for _, level := range log.AllLevels {
@@ -242,8 +915,11 @@ func parseConfig() {
 
 type tcpAddrList []*net.TCPAddr
 
+// Set parses the addresses, separated by spaces or commas.
 func (h *tcpAddrList) Set(value string) error {
-	addresses := strings.Split(value, " ")
+	addresses := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
 
 	// Reset the list also, when running tests we add to the same list and is incorrect
 	*h = make(tcpAddrList, 0)
@@ -271,3 +947,36 @@ func tcpAddrListParser(s kingpin.Settings) (target *tcpAddrList) {
 func (h *tcpAddrList) String() string {
 	return ""
 }
+
+// stringList is the value of a repeatable flag, whose values can be given as a comma-separated list as well
+// (e.g. in its environment variable).
+type stringList []string
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) IsCumulative() bool {
+	return true
+}
+
+func stringListParser(s kingpin.Settings) *[]string {
+	list := make(stringList, 0)
+	s.SetValue(&list)
+	return (*[]string)(&list)
+}
+
+func collapseWindowsParser(s kingpin.Settings) *connector.CollapseWindows {
+	windows := make(connector.CollapseWindows)
+	s.SetValue(&windows)
+	return &windows
+}
@@ -0,0 +1,163 @@
+package connector
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The states of a circuit breaker.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// DefaultBreakerProbe is the default interval of the probes of an open circuit breaker.
+const DefaultBreakerProbe = 30 * time.Second
+
+// BreakerConfig configures the circuit breaker pausing a connector during the outages of its provider.
+type BreakerConfig struct {
+	// Failures is the number of consecutive failures of the provider opening the breaker (0: no breaker)
+	Failures int
+
+	// Probe is the interval at which an open breaker lets a single request probe the provider
+	Probe time.Duration
+}
+
+// Breaker is a circuit breaker around the sender of a connector.
+// After a number of consecutive failures of the provider it opens, pausing the sending:
+// the requests wait in the queue of the connector (and the messages in the store),
+// instead of hammering the failing provider and burning the workers.
+// While open, a single request probes the provider at each interval, and the first success closes the breaker.
+// A nil Breaker is always closed.
+type Breaker struct {
+	name   string
+	config BreakerConfig
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	probeAt  time.Time
+
+	// changed is closed (and replaced) when the state changes, waking the waiting requests
+	changed chan struct{}
+
+	stateVar *expvar.String
+}
+
+// NewBreaker returns the circuit breaker of the named connector, or nil if it is not configured.
+func NewBreaker(name string, config BreakerConfig) *Breaker {
+	if config.Failures <= 0 {
+		return nil
+	}
+	if config.Probe <= 0 {
+		config.Probe = DefaultBreakerProbe
+	}
+	b := &Breaker{
+		name:     name,
+		config:   config,
+		state:    BreakerClosed,
+		changed:  make(chan struct{}),
+		stateVar: new(expvar.String),
+	}
+	b.stateVar.Set(BreakerClosed)
+	mBreakerStates.Set(name, b.stateVar)
+	return b
+}
+
+// Allow blocks until a request can be sent to the provider, and returns false if the context is done first.
+// A closed breaker allows all the requests; an open one allows only a probe, once its interval elapsed.
+func (b *Breaker) Allow(ctx context.Context) bool {
+	if b == nil {
+		return ctx.Err() == nil
+	}
+	for {
+		b.mu.Lock()
+		if b.state == BreakerClosed {
+			b.mu.Unlock()
+			return true
+		}
+		// while half-open, the requests wait for the result of the probe
+		wait := b.config.Probe
+		if b.state == BreakerOpen {
+			wait = b.probeAt.Sub(time.Now())
+			if wait <= 0 {
+				b.setState(BreakerHalfOpen)
+				b.mu.Unlock()
+				return true
+			}
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+		timer.Stop()
+	}
+}
+
+// Report records the result of a request sent to the provider:
+// a success closes the breaker, and a failure may open it (or opens it again after a failed probe).
+func (b *Breaker) Report(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			logger.WithField("name", b.name).Info("Provider recovered, closing the circuit breaker")
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.config.Failures) {
+		if b.state == BreakerClosed {
+			mBreakerTrips.Add(b.name, 1)
+			logger.WithField("name", b.name).WithField("failures", b.failures).WithField("error", err.Error()).
+				Warn("Provider failing, opening the circuit breaker")
+		}
+		b.probeAt = time.Now().Add(b.config.Probe)
+		b.setState(BreakerOpen)
+	}
+}
+
+// State returns the state of the breaker.
+func (b *Breaker) State() string {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Check returns an error while the breaker is not closed.
+// It is a part of the health.Checker implementation.
+func (b *Breaker) Check() error {
+	if state := b.State(); state != BreakerClosed {
+		return fmt.Errorf("The circuit breaker of %s is %s: its provider is failing", b.name, state)
+	}
+	return nil
+}
+
+// setState changes the state, and wakes the waiting requests. It is called with the lock held.
+func (b *Breaker) setState(state string) {
+	b.state = state
+	b.stateVar.Set(state)
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
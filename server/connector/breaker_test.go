@@ -0,0 +1,133 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+var errProvider = errors.New("provider unavailable")
+
+func TestBreaker_OpensAndProbes(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	b := NewBreaker("test", BreakerConfig{Failures: 3, Probe: 50 * time.Millisecond})
+	a.Equal(BreakerClosed, b.State())
+	a.NoError(b.Check())
+
+	// a success resets the consecutive failures
+	b.Report(errProvider)
+	b.Report(errProvider)
+	b.Report(nil)
+	b.Report(errProvider)
+	b.Report(errProvider)
+	a.Equal(BreakerClosed, b.State())
+
+	b.Report(errProvider)
+	a.Equal(BreakerOpen, b.State())
+	a.Error(b.Check())
+
+	// a single request probes the provider after the interval, and its failure opens the breaker again
+	start := time.Now()
+	a.True(b.Allow(ctx))
+	a.True(time.Since(start) >= 40*time.Millisecond)
+	a.Equal(BreakerHalfOpen, b.State())
+	b.Report(errProvider)
+	a.Equal(BreakerOpen, b.State())
+
+	a.True(b.Allow(ctx))
+	allowedC := make(chan bool)
+	go func() {
+		allowedC <- b.Allow(ctx)
+	}()
+	select {
+	case <-allowedC:
+		a.Fail("Only the probe should be allowed while half-open")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// the success of the probe closes the breaker, and resumes the waiting requests
+	b.Report(nil)
+	a.True(<-allowedC)
+	a.Equal(BreakerClosed, b.State())
+	a.NoError(b.Check())
+}
+
+func TestBreaker_AllowReturnsWhenContextDone(t *testing.T) {
+	a := assert.New(t)
+
+	b := NewBreaker("test", BreakerConfig{Failures: 1, Probe: time.Hour})
+	b.Report(errProvider)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	a.False(b.Allow(ctx))
+}
+
+func TestBreaker_NilIsAlwaysClosed(t *testing.T) {
+	a := assert.New(t)
+
+	b := NewBreaker("test", BreakerConfig{})
+	a.Nil(b)
+	b.Report(errProvider)
+	a.True(b.Allow(context.Background()))
+	a.Equal(BreakerClosed, b.State())
+	a.NoError(b.Check())
+}
+
+// senderFunc is a Sender sending with a function.
+type senderFunc func(request Request) (interface{}, error)
+
+func (f senderFunc) Send(request Request) (interface{}, error) {
+	return f(request)
+}
+
+func TestQueue_PausesWhileBreakerIsOpen(t *testing.T) {
+	a := assert.New(t)
+
+	var mu sync.Mutex
+	var sent []uint64
+	failing := true
+	sender := senderFunc(func(r Request) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, r.Message().ID)
+		if failing {
+			return nil, errProvider
+		}
+		return "ok", nil
+	})
+
+	breaker := NewBreaker("test", BreakerConfig{Failures: 2, Probe: 50 * time.Millisecond})
+	q := newQueue("test", sender, 1, breaker)
+	a.NoError(q.Start())
+
+	// the pushes block while the workers are paused
+	s := NewSubscriber("/news", map[string]string{"device_token": "device1"}, 0)
+	go func() {
+		for id := uint64(1); id <= 4; id++ {
+			q.Push(NewRequest(s, &protocol.Message{ID: id}))
+		}
+	}()
+
+	// the breaker opens after two failures, and the other requests wait for the probe
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	a.Equal([]uint64{1, 2}, sent)
+	failing = false
+	mu.Unlock()
+	a.Equal(BreakerOpen, breaker.State())
+
+	time.Sleep(80 * time.Millisecond)
+	mu.Lock()
+	a.Equal([]uint64{1, 2, 3, 4}, sent)
+	mu.Unlock()
+	a.Equal(BreakerClosed, breaker.State())
+	a.NoError(q.Stop())
+}
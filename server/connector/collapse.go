@@ -0,0 +1,146 @@
+package connector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// CollapseWindows are the collapsing windows of the topics (and of their subtopics).
+// It is set from a space separated list of `<topic>=<duration>`, e.g. `/news=30s /scores=5s`.
+type CollapseWindows map[protocol.Path]time.Duration
+
+func (cw *CollapseWindows) Set(value string) error {
+	windows := make(CollapseWindows)
+	for _, entry := range strings.Fields(value) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") {
+			return fmt.Errorf("Invalid collapse window %q, the format is <topic>=<duration>", entry)
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil || d <= 0 {
+			return fmt.Errorf("Invalid duration of collapse window %q", entry)
+		}
+		windows[protocol.Path(strings.TrimSuffix(parts[0], "/"))] = d
+	}
+	*cw = windows
+	return nil
+}
+
+func (cw *CollapseWindows) String() string {
+	entries := make([]string, 0, len(*cw))
+	for topic, d := range *cw {
+		entries = append(entries, fmt.Sprintf("%s=%s", topic, d))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, " ")
+}
+
+// window returns the collapsing window of the most specific topic matching the path, or 0.
+func (cw CollapseWindows) window(path protocol.Path) time.Duration {
+	var (
+		window  time.Duration
+		longest = -1
+	)
+	for topic, d := range cw {
+		if (path == topic || strings.HasPrefix(string(path), string(topic)+"/")) && len(topic) > longest {
+			window, longest = d, len(topic)
+		}
+	}
+	return window
+}
+
+// collapsingQueue collapses the requests of each subscriber whose topic has a collapsing window:
+// the first request is pushed at once, and only the latest of the requests received during the window
+// is pushed at its end (which opens a new window).
+// The collapsed requests are not lost on a restart: they follow the last id of the subscriber, and are fetched again.
+type collapsingQueue struct {
+	Queue
+	windows CollapseWindows
+
+	pending map[string]*collapse
+	stopped bool
+	mu      sync.Mutex
+}
+
+// collapse is the open window of a subscriber.
+type collapse struct {
+	latest Request
+	timer  *time.Timer
+}
+
+func newCollapsingQueue(q Queue, windows CollapseWindows) *collapsingQueue {
+	return &collapsingQueue{
+		Queue:   q,
+		windows: windows,
+		pending: make(map[string]*collapse),
+	}
+}
+
+func (q *collapsingQueue) Push(request Request) error {
+	window := q.windows.window(request.Subscriber().Route().Path)
+	if window <= 0 {
+		return q.Queue.Push(request)
+	}
+
+	key := request.Subscriber().Key()
+	q.mu.Lock()
+	if c, ok := q.pending[key]; ok {
+		c.latest = request
+		q.mu.Unlock()
+		return nil
+	}
+	q.open(key, window)
+	q.mu.Unlock()
+	return q.Queue.Push(request)
+}
+
+// open starts the window of a subscriber. It is called with the lock held.
+func (q *collapsingQueue) open(key string, window time.Duration) {
+	c := &collapse{}
+	c.timer = time.AfterFunc(window, func() { q.close(key, c, window) })
+	q.pending[key] = c
+}
+
+// close pushes the latest request received during the window, if any.
+func (q *collapsingQueue) close(key string, c *collapse, window time.Duration) {
+	q.mu.Lock()
+	if q.stopped || q.pending[key] != c {
+		q.mu.Unlock()
+		return
+	}
+	delete(q.pending, key)
+	latest := c.latest
+	if latest != nil {
+		q.open(key, window)
+	}
+	q.mu.Unlock()
+
+	if latest != nil {
+		q.Queue.Push(latest)
+	}
+}
+
+// Stop drops the open windows, and stops the underlying queue.
+func (q *collapsingQueue) Stop() error {
+	q.mu.Lock()
+	q.stopped = true
+	for key, c := range q.pending {
+		c.timer.Stop()
+		delete(q.pending, key)
+	}
+	q.mu.Unlock()
+	return q.Queue.Stop()
+}
+
+// Start starts the underlying queue (also after a Stop).
+func (q *collapsingQueue) Start() error {
+	q.mu.Lock()
+	q.stopped = false
+	q.mu.Unlock()
+	return q.Queue.Start()
+}
@@ -0,0 +1,69 @@
+package connector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollapseWindows_Set(t *testing.T) {
+	a := assert.New(t)
+
+	var windows CollapseWindows
+	a.NoError(windows.Set("/news=30s  /scores/=1m"))
+	a.Equal(CollapseWindows{"/news": 30 * time.Second, "/scores": time.Minute}, windows)
+	a.Equal("/news=30s /scores=1m0s", windows.String())
+
+	a.Equal(30*time.Second, windows.window("/news"))
+	a.Equal(30*time.Second, windows.window("/news/sport"))
+	a.Equal(time.Duration(0), windows.window("/newsletter"))
+
+	a.Error(windows.Set("/news"))
+	a.Error(windows.Set("news=30s"))
+	a.Error(windows.Set("/news=soon"))
+	a.Error(windows.Set("/news=-1s"))
+}
+
+func TestCollapsingQueue_PushesLatestAfterWindow(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	pushedC := make(chan Request, 10)
+	mQueue := NewMockQueue(testutil.MockCtrl)
+	mQueue.EXPECT().Push(gomock.Any()).Do(func(r Request) error {
+		pushedC <- r
+		return nil
+	}).Return(nil).AnyTimes()
+	mQueue.EXPECT().Stop().Return(nil)
+
+	q := newCollapsingQueue(mQueue, CollapseWindows{"/scores": 50 * time.Millisecond})
+	scores := NewSubscriber("/scores", map[string]string{"device_token": "device1"}, 0)
+	news := NewSubscriber("/news", map[string]string{"device_token": "device1"}, 0)
+
+	for id := uint64(1); id <= 3; id++ {
+		a.NoError(q.Push(NewRequest(scores, &protocol.Message{ID: id, Path: "/scores"})))
+	}
+	// the topics without window are not collapsed
+	a.NoError(q.Push(NewRequest(news, &protocol.Message{ID: 4, Path: "/news"})))
+
+	a.Equal(uint64(1), (<-pushedC).Message().ID)
+	a.Equal(uint64(4), (<-pushedC).Message().ID)
+	select {
+	case r := <-pushedC:
+		a.Equal(uint64(3), r.Message().ID)
+	case <-time.After(time.Second):
+		a.Fail("The latest message was not pushed after the window")
+	}
+
+	// a message received in the window opened by the latest push is collapsed too
+	a.NoError(q.Push(NewRequest(scores, &protocol.Message{ID: 5, Path: "/scores"})))
+	a.Equal(uint64(5), (<-pushedC).Message().ID)
+
+	a.NoError(q.Stop())
+	a.Equal(0, len(q.pending))
+}
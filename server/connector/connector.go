@@ -12,13 +12,18 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/monitor"
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/throttle"
 )
 
 const (
 	DefaultWorkers = 1
 	SubstitutePath = "/substitute/"
+	QuietHoursPath = "/quiet-hours"
 )
 
 var (
@@ -61,8 +66,15 @@ type Connector interface {
 	SenderSetter
 	ResponseHandlerSetter
 	Runner
+	DeviceLister
 	Manager() Manager
 	Context() context.Context
+
+	// Check returns an error while the circuit breaker of the connector is open
+	Check() error
+
+	// Lags returns the delivery lags of the partitions with messages waiting to be sent
+	Lags() []monitor.Lag
 }
 
 type ResponsiveConnector interface {
@@ -70,13 +82,31 @@ type ResponsiveConnector interface {
 	ResponseHandler
 }
 
+// changeApplier is implemented by the managers which can apply the changes of the KVStore done by other guble nodes.
+type changeApplier interface {
+	apply(kvstore.Change) (Subscriber, error)
+}
+
 type connector struct {
 	config  Config
 	sender  Sender
 	handler ResponseHandler
 	manager Manager
 	queue   Queue
+	breaker *Breaker
+	lags    *lagTracker
 	router  router.Router
+	kvstore kvstore.KVStore
+
+	// requests is the innermost queue, sending the requests to the provider
+	requests *requestQueue
+
+	// dormant holds the subscribers without route, in the lazy mode
+	dormant *dormantIndex
+
+	// groups are the groups of subscribers sharing a route, by topic (nil if the routes are not shared)
+	groups   map[protocol.Path]*routeGroup
+	groupsMu sync.Mutex
 
 	mux *mux.Router
 
@@ -85,6 +115,10 @@ type connector struct {
 
 	logger *log.Entry
 	wg     sync.WaitGroup
+
+	// healthMu serializes the health checks, and guards their last report
+	healthMu         sync.Mutex
+	lastHealthReport *HealthReport
 }
 
 type Config struct {
@@ -93,6 +127,44 @@ type Config struct {
 	Prefix     string
 	URLPattern string
 	Workers    int
+
+	// Collapse are the collapsing windows of the topics, for the messages sent to the same subscriber
+	Collapse CollapseWindows
+
+	// QueryParams are the query parameters of the subscription requests, which are stored as route params
+	// of the subscription in addition to the ones of the URL pattern (so they are a part of its key as well)
+	QueryParams []string
+
+	// HealthCheck configures the health checks of the device tokens of the subscriptions
+	HealthCheck HealthCheckConfig
+
+	// Renderer renders the messages in the locales of the subscribers (optional)
+	Renderer Renderer
+
+	// Devices configures the route params with which the devices of a user are listed
+	Devices DevicesConfig
+
+	// Breaker configures the circuit breaker pausing the sending during the outages of the provider
+	Breaker BreakerConfig
+
+	// Authenticator authenticates the requests of the HTTP endpoints (optional): the subscriptions can then only be
+	// changed with a token of their user, and the other endpoints require a token with the admin scope
+	Authenticator auth.Authenticator
+
+	// Limiter limits the rate of the subscribing requests (optional)
+	Limiter *throttle.Limiter
+
+	// ProviderMessageID enables the send records (optional): the messages delivered to a subscriber,
+	// identified in the responses by their provider message ID, are not sent again after a restart
+	ProviderMessageID ProviderMessageID
+
+	// Lazy enables the lazy mode (if supported by the router): the routes of the subscribers are only created
+	// by the first message matching them, so that the idle subscribers do not use the resources of a route
+	Lazy bool
+
+	// SharedRoutes enables a single router route for all the subscribers of a topic, passing its messages
+	// to the subscribers in the connector, so that the router does not hold and serve a route for each of them
+	SharedRoutes bool
 }
 
 func NewConnector(router router.Router, sender Sender, config Config) (Connector, error) {
@@ -105,13 +177,34 @@ func NewConnector(router router.Router, sender Sender, config Config) (Connector
 		config.Workers = DefaultWorkers
 	}
 
+	manager := NewManager(config.Schema, kvs)
+	breaker := NewBreaker(config.Name, config.Breaker)
+	requests := newQueue(config.Name, sender, config.Workers, breaker)
+	var queue Queue = requests
+	if config.ProviderMessageID != nil {
+		queue = newIdempotentQueue(queue, kvs, config.Schema, manager, config.ProviderMessageID)
+	}
+	if config.Renderer != nil {
+		queue = newRenderingQueue(queue, config.Renderer)
+	}
+	if len(config.Collapse) > 0 {
+		queue = newCollapsingQueue(queue, config.Collapse)
+	}
+
 	c := &connector{
-		config:  config,
-		sender:  sender,
-		manager: NewManager(config.Schema, kvs),
-		queue:   NewQueue(sender, config.Workers),
-		router:  router,
-		logger:  logger.WithField("name", config.Name),
+		config:   config,
+		sender:   sender,
+		manager:  manager,
+		queue:    queue,
+		lags:     requests.lags,
+		requests: requests,
+		breaker:  breaker,
+		router:   router,
+		kvstore:  kvs,
+		logger:   logger.WithField("name", config.Name),
+	}
+	if config.SharedRoutes {
+		c.groups = make(map[protocol.Path]*routeGroup)
 	}
 	c.initMuxRouter()
 	return c, nil
@@ -121,12 +214,30 @@ func (c *connector) initMuxRouter() {
 	muxRouter := mux.NewRouter()
 
 	baseRouter := muxRouter.PathPrefix(c.GetPrefix()).Subrouter()
-	baseRouter.Methods(http.MethodGet).HandlerFunc(c.GetList)
-	baseRouter.Methods(http.MethodPost).PathPrefix(SubstitutePath).HandlerFunc(c.Substitute)
+
+	healthRouter := baseRouter.Path(HealthCheckPath).Subrouter()
+	healthRouter.Methods(http.MethodGet).HandlerFunc(c.admin(c.GetHealthCheck))
+	healthRouter.Methods(http.MethodPost).HandlerFunc(c.admin(c.PostHealthCheck))
+
+	queueRouter := baseRouter.Path(QueuePath).Subrouter()
+	queueRouter.Methods(http.MethodGet).HandlerFunc(c.admin(c.GetQueue))
+	queueRouter.Methods(http.MethodDelete).HandlerFunc(c.admin(c.DeleteQueue))
+	baseRouter.Methods(http.MethodPost).Path(QueuePath + "/pause").HandlerFunc(c.admin(c.PauseQueue))
+	baseRouter.Methods(http.MethodPost).Path(QueuePath + "/resume").HandlerFunc(c.admin(c.ResumeQueue))
+
+	baseRouter.Methods(http.MethodGet).HandlerFunc(c.owner(c.GetList))
+	baseRouter.Methods(http.MethodPost).PathPrefix(SubstitutePath).HandlerFunc(c.admin(c.Substitute))
+
+	replayRouter := baseRouter.PathPrefix(ReplayPath).Subrouter().Path(c.config.URLPattern).Subrouter()
+	replayRouter.Methods(http.MethodPost).HandlerFunc(c.admin(c.Replay))
+
+	quietRouter := baseRouter.PathPrefix(QuietHoursPath).Subrouter().Path(c.config.URLPattern).Subrouter()
+	quietRouter.Methods(http.MethodPut).HandlerFunc(c.owner(c.PutQuietHours))
+	quietRouter.Methods(http.MethodDelete).HandlerFunc(c.owner(c.DeleteQuietHours))
 
 	subRouter := baseRouter.Path(c.config.URLPattern).Subrouter()
-	subRouter.Methods(http.MethodPost).HandlerFunc(c.Post)
-	subRouter.Methods(http.MethodDelete).HandlerFunc(c.Delete)
+	subRouter.Methods(http.MethodPost).HandlerFunc(c.config.Limiter.Limit(c.owner(c.Post)))
+	subRouter.Methods(http.MethodDelete).HandlerFunc(c.owner(c.Delete))
 	c.mux = muxRouter
 }
 
@@ -184,8 +295,7 @@ func (c *connector) Post(w http.ResponseWriter, req *http.Request) {
 		fmt.Fprintf(w, "Missing topic parameter.")
 		return
 	}
-	delete(params, TopicParam)
-	params[ConnectorParam] = c.config.Name
+	c.setParams(params, req)
 	c.logger.WithField("params", params).WithField("topic", topic).Info("Creating subscription")
 	subscriber, err := c.manager.Create(protocol.Path("/"+topic), params)
 	if err != nil {
@@ -196,7 +306,7 @@ func (c *connector) Post(w http.ResponseWriter, req *http.Request) {
 		}
 		return
 	}
-	go c.Run(subscriber)
+	c.start(subscriber)
 	c.logger.WithField("topic", topic).Info("Subscription created")
 	fmt.Fprintf(w, `{"subscribed":"/%v"}`, topic)
 }
@@ -210,8 +320,7 @@ func (c *connector) Delete(w http.ResponseWriter, req *http.Request) {
 		fmt.Fprintf(w, "Missing topic parameter.")
 		return
 	}
-	delete(params, TopicParam)
-	params[ConnectorParam] = c.config.Name
+	c.setParams(params, req)
 	c.logger.WithField("params", params).WithField("topic", topic).Info("Finding subscription to delete it")
 	subscriber := c.manager.Find(GenerateKey("/"+topic, params))
 	if subscriber == nil {
@@ -227,6 +336,20 @@ func (c *connector) Delete(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprintf(w, `{"unsubscribed":"/%v"}`, topic)
 }
 
+// setParams turns the variables of the URL pattern into the route params of a subscription:
+// the topic is removed, and the connector name and the configured query parameters are added.
+func (c *connector) setParams(params map[string]string, req *http.Request) {
+	delete(params, TopicParam)
+	params[ConnectorParam] = c.config.Name
+
+	query := req.URL.Query()
+	for _, name := range c.config.QueryParams {
+		if value := query.Get(name); value != "" {
+			params[name] = value
+		}
+	}
+}
+
 func (c *connector) Substitute(w http.ResponseWriter, req *http.Request) {
 	s := new(substitution)
 	err := json.NewDecoder(req.Body).Decode(&s)
@@ -242,19 +365,53 @@ func (c *connector) Substitute(w http.ResponseWriter, req *http.Request) {
 	filters := map[string]string{}
 	filters[s.FieldName] = s.OldValue
 	subscribers := c.manager.Filter(filters)
-	totalSubscribersUpdated := 0
-	for _, sub := range subscribers {
-		sub.Route().Set(s.FieldName, s.NewValue)
-		err = c.manager.Update(sub)
-		if err != nil {
-			http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusInternalServerError)
-			return
-		}
-		totalSubscribersUpdated++
+	// all the subscribers are stored in a single batch, so that a failure doesn't leave them partially substituted
+	err = c.manager.Substitute(subscribers, s.FieldName, s.NewValue)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusInternalServerError)
+		return
 	}
 
 	c.logger.WithField("subscribers", subscribers).WithField("req", s).Info("Substituted subscriber info ")
-	fmt.Fprintf(w, `{"modified":"%d"}`, totalSubscribersUpdated)
+	fmt.Fprintf(w, `{"modified":"%d"}`, len(subscribers))
+}
+
+// PutQuietHours sets the quiet hours of a subscriber, given as JSON body
+func (c *connector) PutQuietHours(w http.ResponseWriter, req *http.Request) {
+	qh := new(QuietHours)
+	if err := json.NewDecoder(req.Body).Decode(qh); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"json body could not be decoded: %s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := qh.validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	c.setQuietHours(w, req, qh)
+}
+
+// DeleteQuietHours removes the quiet hours of a subscriber
+func (c *connector) DeleteQuietHours(w http.ResponseWriter, req *http.Request) {
+	c.setQuietHours(w, req, nil)
+}
+
+func (c *connector) setQuietHours(w http.ResponseWriter, req *http.Request, qh *QuietHours) {
+	params := mux.Vars(req)
+	topic := params[TopicParam]
+	c.setParams(params, req)
+
+	subscriber := c.manager.Find(GenerateKey("/"+topic, params))
+	if subscriber == nil {
+		http.Error(w, `{"error":"subscription not found"}`, http.StatusNotFound)
+		return
+	}
+	subscriber.SetQuietHours(qh)
+	if err := c.manager.Update(subscriber); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"unknown error: %s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.logger.WithField("topic", topic).WithField("quietHours", qh).Info("Quiet hours of subscription changed")
+	json.NewEncoder(w).Encode(qh)
 }
 
 // Start will run start all current subscriptions and workers to process the messages
@@ -265,27 +422,82 @@ func (c *connector) Start() error {
 	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	c.logger.Info("Loading subscriptions")
-	err := c.manager.Load()
+	err := c.manager.Load(c.ctx)
 	if err != nil {
 		return err
 	}
 
+	if c.config.Lazy && c.dormant == nil {
+		if o, ok := c.router.(router.Observable); ok {
+			c.dormant = newDormantIndex()
+			o.AddObserver(c.config.Name, router.ObserverFunc(c.materialize))
+		} else {
+			c.logger.Warn("The router does not support the lazy mode")
+		}
+	}
+
 	c.logger.Info("Starting subscriptions")
 	for _, s := range c.manager.List() {
-		go c.Run(s)
+		c.start(s)
+	}
+	if c.dormant != nil {
+		c.logger.WithField("dormant", c.dormant.size()).Info("Subscriptions waiting for their first message")
+	}
+
+	if w, ok := c.kvstore.(kvstore.Watcher); ok {
+		if a, ok := c.manager.(changeApplier); ok {
+			c.wg.Add(1)
+			go c.watch(w, a)
+		}
+	}
+
+	if c.config.HealthCheck.Interval > 0 {
+		c.wg.Add(1)
+		go c.healthCheckLoop()
 	}
 
 	c.logger.Info("Started connector")
 	return nil
 }
 
+// watch applies the subscription changes done by other guble nodes, until the connector is stopped.
+func (c *connector) watch(w kvstore.Watcher, a changeApplier) {
+	defer c.wg.Done()
+
+	changesC, err := w.Watch(c.ctx, c.config.Schema)
+	if err != nil {
+		c.logger.WithField("error", err.Error()).Info("Not watching the subscription changes")
+		return
+	}
+	for change := range changesC {
+		s, err := a.apply(change)
+		if err != nil {
+			c.logger.WithField("error", err.Error()).WithField("key", change.Key).Error("Error applying subscription change")
+			continue
+		}
+		if s != nil {
+			c.start(s)
+		}
+	}
+}
+
+// start runs a subscriber, or adds it to the dormant subscribers in the lazy mode.
+func (c *connector) start(s Subscriber) {
+	if c.dormant != nil {
+		c.dormant.add(s)
+		return
+	}
+	go c.Run(s)
+}
+
 func (c *connector) Run(s Subscriber) {
 	c.wg.Add(1)
 	defer c.wg.Done()
 
 	var provideErr error
+	route := s.Route()
 	go func() {
-		err := s.Route().Provide(c.router, true)
+		err := c.provide(s, route)
 		if err != nil {
 			// cancel subscription loop if there is an error on the provider
 			provideErr = err
@@ -299,7 +511,7 @@ func (c *connector) Run(s Subscriber) {
 		// if context cancelled loop then unsubscribe the route from router
 		// in case it's been subscribed
 		if err == context.Canceled {
-			c.router.Unsubscribe(s.Route())
+			c.unsubscribe(s, route)
 			return
 		}
 
@@ -326,6 +538,29 @@ func (c *connector) Run(s Subscriber) {
 	}
 }
 
+// provide fetches the messages of a subscriber from its lastID, if any, and subscribes its route to the router,
+// or to the shared route of its topic.
+func (c *connector) provide(s Subscriber, route *router.Route) error {
+	if c.groups == nil {
+		return route.Provide(c.router, true)
+	}
+	if route.FetchRequest != nil {
+		if err := route.Provide(c.router, false); err != nil {
+			return err
+		}
+	}
+	return c.join(s, route)
+}
+
+// unsubscribe removes the route of a subscriber from the router, or from the shared route of its topic.
+func (c *connector) unsubscribe(s Subscriber, route *router.Route) {
+	if c.groups == nil {
+		c.router.Unsubscribe(route)
+		return
+	}
+	c.leave(s, route)
+}
+
 func (c *connector) restart(s Subscriber) error {
 	s.Cancel()
 	err := s.Reset()
@@ -338,6 +573,18 @@ func (c *connector) restart(s Subscriber) error {
 }
 
 // Stop the connector (the context, the queue, the subscription loops)
+// Check returns an error while the circuit breaker of the connector is open.
+// It is a part of the health.Checker implementation.
+func (c *connector) Check() error {
+	return c.breaker.Check()
+}
+
+// Lags returns the delivery lags of the connector, by partition.
+// It is a part of the monitor.LagReporter implementation.
+func (c *connector) Lags() []monitor.Lag {
+	return c.lags.lags(c.config.Name, time.Now())
+}
+
 func (c *connector) Stop() error {
 	c.logger.Info("Stopping connector")
 	c.cancel()
@@ -0,0 +1,18 @@
+package connector
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns = metrics.NS("connector")
+
+	// mBreakerStates are the states of the circuit breakers, by connector name
+	mBreakerStates = ns.NewMap("breaker_states")
+
+	// mBreakerTrips are the numbers of times the circuit breakers opened, by connector name
+	mBreakerTrips = ns.NewMap("breaker_trips")
+
+	// mPurgedRequests are the numbers of requests dropped by the purges of the queues, by connector name
+	mPurgedRequests = ns.NewMap("purged_requests")
+)
@@ -10,6 +10,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/testutil"
 	"github.com/stretchr/testify/assert"
@@ -38,7 +39,7 @@ func TestConnector_PostSubscription(t *testing.T) {
 		URLPattern: "/{device_token}/{user_id}/{topic:.*}",
 	}, true, false)
 
-	mocks.manager.EXPECT().Load().Return(nil)
+	mocks.manager.EXPECT().Load(gomock.Any()).Return(nil)
 	mocks.manager.EXPECT().List().Return(make([]Subscriber, 0))
 	err := conn.Start()
 	a.NoError(err)
@@ -84,14 +85,17 @@ func TestConnector_PostSubscriptionNoMocks(t *testing.T) {
 	}, false, false)
 
 	entriesC := make(chan [2]string)
-	mocks.kvstore.EXPECT().Iterate(gomock.Eq("schema"), gomock.Eq("")).Return(entriesC)
+	mocks.kvstore.EXPECT().Iterate(gomock.Any(), gomock.Eq("schema"), gomock.Eq(""), gomock.Eq(0)).Return(entriesC)
 	close(entriesC)
 
-	mocks.kvstore.EXPECT().Put(gomock.Eq("schema"), gomock.Eq(GenerateKey("/topic1", map[string]string{
-		"device_token": "device1",
-		"user_id":      "user1",
-		"connector":    "name",
-	})), gomock.Any())
+	mocks.kvstore.EXPECT().Batch(gomock.Eq("schema"), gomock.Any()).Do(func(schema string, ops []kvstore.Operation) {
+		a.Equal(1, len(ops))
+		a.Equal(GenerateKey("/topic1", map[string]string{
+			"device_token": "device1",
+			"user_id":      "user1",
+			"connector":    "name",
+		}), ops[0].Key)
+	})
 
 	mocks.router.EXPECT().Subscribe(gomock.Any())
 
@@ -135,6 +139,59 @@ func TestConnector_DeleteSubscription(t *testing.T) {
 	time.Sleep(200 * time.Millisecond)
 }
 
+// Ensure the configured query parameters are stored as route params of the subscription
+func TestConnector_SubscriptionWithQueryParams(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	conn, mocks := getTestConnector(t, Config{
+		Name:        "test",
+		Schema:      "test",
+		Prefix:      "/connector/",
+		URLPattern:  "/{device_token}/{user_id}/{topic:.*}",
+		QueryParams: []string{"environment"},
+	}, true, false)
+
+	mocks.manager.EXPECT().Load(gomock.Any()).Return(nil)
+	mocks.manager.EXPECT().List().Return(make([]Subscriber, 0))
+	a.NoError(conn.Start())
+	defer conn.Stop()
+
+	params := router.RouteParams{
+		"device_token": "device1",
+		"user_id":      "user1",
+		"connector":    "test",
+		"environment":  "sandbox",
+	}
+	subscriber := NewMockSubscriber(testutil.MockCtrl)
+	mocks.manager.EXPECT().Create(gomock.Eq(protocol.Path("/topic1")), gomock.Eq(params)).Return(subscriber, nil)
+
+	r := router.NewRoute(router.RouteConfig{Path: protocol.Path("topic1"), RouteParams: params})
+	subscriber.EXPECT().Loop(gomock.Any(), gomock.Any()).AnyTimes()
+	subscriber.EXPECT().Route().Return(r).AnyTimes()
+	mocks.router.EXPECT().Subscribe(gomock.Eq(r)).Return(r, nil).AnyTimes()
+
+	// the other query parameters are ignored
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/connector/device1/user1/topic1?environment=sandbox&other=x", nil)
+	a.NoError(err)
+	conn.ServeHTTP(recorder, req)
+	a.Equal(`{"subscribed":"/topic1"}`, recorder.Body.String())
+
+	// the subscription is found by the same params
+	mocks.manager.EXPECT().Find(gomock.Eq(GenerateKey("/topic1", params))).Return(subscriber)
+	mocks.manager.EXPECT().Remove(subscriber).Return(nil)
+
+	recorder = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodDelete, "/connector/device1/user1/topic1?environment=sandbox", nil)
+	a.NoError(err)
+	conn.ServeHTTP(recorder, req)
+	a.Equal(`{"unsubscribed":"/topic1"}`, recorder.Body.String())
+	time.Sleep(100 * time.Millisecond)
+}
+
 func TestConnector_GetList_And_Getters(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -203,9 +260,9 @@ func TestConnector_StartWithSubscriptions(t *testing.T) {
 	}, false, false)
 
 	entriesC := make(chan [2]string)
-	mocks.kvstore.EXPECT().Iterate(gomock.Eq("test"), gomock.Eq("")).Return(entriesC)
+	mocks.kvstore.EXPECT().Iterate(gomock.Any(), gomock.Eq("test"), gomock.Eq(""), gomock.Eq(0)).Return(entriesC)
 	close(entriesC)
-	mocks.kvstore.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any()).Times(4)
+	mocks.kvstore.EXPECT().Batch(gomock.Any(), gomock.Any()).Times(4)
 
 	err := conn.Start()
 	a.NoError(err)
@@ -250,9 +307,9 @@ func TestConnector_Substitute(t *testing.T) {
 	}, false, false)
 
 	entriesC := make(chan [2]string)
-	mocks.kvstore.EXPECT().Iterate(gomock.Eq("test"), gomock.Eq("")).Return(entriesC)
+	mocks.kvstore.EXPECT().Iterate(gomock.Any(), gomock.Eq("test"), gomock.Eq(""), gomock.Eq(0)).Return(entriesC)
 	close(entriesC)
-	mocks.kvstore.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any()).Times(4)
+	mocks.kvstore.EXPECT().Batch(gomock.Any(), gomock.Any()).Times(4)
 
 	err := conn.Start()
 	a.NoError(err)
@@ -273,13 +330,24 @@ func TestConnector_Substitute(t *testing.T) {
 			"new_value":"asgasgasgagasgaasg2"
 			}
 	`
-	mocks.kvstore.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any()).Times(1)
+	mocks.kvstore.EXPECT().Batch(gomock.Eq("test"), gomock.Any()).Do(func(schema string, ops []kvstore.Operation) {
+		a.Equal(1, len(ops))
+	})
 	recorder := httptest.NewRecorder()
 	req, err := http.NewRequest(http.MethodPost, "/connector"+SubstitutePath, strings.NewReader(postBody))
 	conn.ServeHTTP(recorder, req)
 
 	a.Equal(http.StatusOK, recorder.Code)
 	a.Equal(`{"modified":"1"}`, recorder.Body.String())
+
+	// the route of the subscriber is substituted after the batch
+	substituted := 0
+	for _, r := range routes {
+		if r.Get("device_token") == "asgasgasgagasgaasg2" {
+			substituted++
+		}
+	}
+	a.Equal(1, substituted)
 }
 
 func TestConnector_SubstituteWrongPostBody(t *testing.T) {
@@ -295,9 +363,9 @@ func TestConnector_SubstituteWrongPostBody(t *testing.T) {
 	}, false, false)
 
 	entriesC := make(chan [2]string)
-	mocks.kvstore.EXPECT().Iterate(gomock.Eq("test"), gomock.Eq("")).Return(entriesC)
+	mocks.kvstore.EXPECT().Iterate(gomock.Any(), gomock.Eq("test"), gomock.Eq(""), gomock.Eq(0)).Return(entriesC)
 	close(entriesC)
-	mocks.kvstore.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any()).Times(4)
+	mocks.kvstore.EXPECT().Batch(gomock.Any(), gomock.Any()).Times(4)
 
 	err := conn.Start()
 	a.NoError(err)
@@ -351,7 +419,7 @@ func TestConnector_StartAndStopWithoutSubscribers(t *testing.T) {
 		Prefix:     "/connector/",
 		URLPattern: "/{device_token}/{user_id}/{topic:.*}",
 	}, true, true)
-	mocks.manager.EXPECT().Load().Return(nil)
+	mocks.manager.EXPECT().Load(gomock.Any()).Return(nil)
 	mocks.manager.EXPECT().List().Return(nil)
 	mocks.queue.EXPECT().Start().Return(nil)
 	mocks.queue.EXPECT().Stop().Return(nil)
@@ -0,0 +1,93 @@
+package connector
+
+import (
+	"sort"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// DeviceLister is implemented by the connectors (and services) in which the users register their devices or channels.
+type DeviceLister interface {
+	// Devices returns the devices registered by the user, with their subscriptions
+	Devices(userID string) []Device
+}
+
+// DevicesConfig configures the listing of the devices of a user.
+type DevicesConfig struct {
+	// UserParam is the route param holding the user id ("" if the subscriptions are not bound to users)
+	UserParam string
+
+	// DeviceParam is the route param holding the device id (e.g. the APNS device id or the FCM token)
+	DeviceParam string
+}
+
+// Device is a device or a channel of a user (e.g. an APNS device id, a FCM token or a phone number).
+type Device struct {
+	Connector     string               `json:"connector"`
+	ID            string               `json:"id"`
+	Subscriptions []DeviceSubscription `json:"subscriptions"`
+}
+
+// DeviceSubscription is a topic to which a device is subscribed.
+type DeviceSubscription struct {
+	Topic  protocol.Path `json:"topic"`
+	LastID uint64        `json:"last_id"`
+
+	// LastSuccess is the time of the last successful push to the device (nil if none)
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// Devices returns the devices of the subscriptions of the user, sorted by their ids.
+func (c *connector) Devices(userID string) []Device {
+	if c.config.Devices.UserParam == "" {
+		return nil
+	}
+	devicesByID := make(map[string]*Device)
+	for _, s := range c.manager.Filter(map[string]string{c.config.Devices.UserParam: userID}) {
+		id := s.Route().Get(c.config.Devices.DeviceParam)
+		device, ok := devicesByID[id]
+		if !ok {
+			device = &Device{Connector: c.config.Name, ID: id}
+			devicesByID[id] = device
+		}
+		device.Subscriptions = append(device.Subscriptions, newDeviceSubscription(s))
+	}
+
+	devices := make([]Device, 0, len(devicesByID))
+	for _, device := range devicesByID {
+		sort.Sort(subscriptionsByTopic(device.Subscriptions))
+		devices = append(devices, *device)
+	}
+	sort.Sort(DevicesByID(devices))
+	return devices
+}
+
+func newDeviceSubscription(s Subscriber) DeviceSubscription {
+	ds := DeviceSubscription{
+		Topic:  s.Route().Path,
+		LastID: s.LastID(),
+	}
+	if lastSuccess := s.LastSuccess(); !lastSuccess.IsZero() {
+		ds.LastSuccess = &lastSuccess
+	}
+	return ds
+}
+
+// DevicesByID sorts the devices by their connectors and ids.
+type DevicesByID []Device
+
+func (d DevicesByID) Len() int      { return len(d) }
+func (d DevicesByID) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d DevicesByID) Less(i, j int) bool {
+	if d[i].Connector != d[j].Connector {
+		return d[i].Connector < d[j].Connector
+	}
+	return d[i].ID < d[j].ID
+}
+
+type subscriptionsByTopic []DeviceSubscription
+
+func (s subscriptionsByTopic) Len() int           { return len(s) }
+func (s subscriptionsByTopic) Less(i, j int) bool { return s[i].Topic < s[j].Topic }
+func (s subscriptionsByTopic) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
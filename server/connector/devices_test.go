@@ -0,0 +1,60 @@
+package connector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnector_Devices(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	conn, mocks := getTestConnector(t, Config{
+		Name:       "test",
+		Schema:     "test",
+		Prefix:     "/connector/",
+		URLPattern: "/{device_token}/{user_id}/{topic:.*}",
+		Devices:    DevicesConfig{UserParam: "user_id", DeviceParam: "device_token"},
+	}, true, false)
+
+	lastSuccess := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	s1 := newTestSubscriber("/topic2", "device2", 0)
+	s2 := newTestSubscriber("/topic2", "device1", 0)
+	s2.SetLastID(42)
+	s2.SetLastSuccess(lastSuccess)
+	s3 := newTestSubscriber("/topic1", "device1", 0)
+	mocks.manager.EXPECT().Filter(map[string]string{"user_id": "user1"}).Return([]Subscriber{s1, s2, s3})
+
+	devices := conn.Devices("user1")
+	a.Equal(2, len(devices))
+
+	a.Equal("test", devices[0].Connector)
+	a.Equal("device1", devices[0].ID)
+	a.Equal(2, len(devices[0].Subscriptions))
+	a.Equal("/topic1", string(devices[0].Subscriptions[0].Topic))
+	a.Nil(devices[0].Subscriptions[0].LastSuccess)
+	a.Equal("/topic2", string(devices[0].Subscriptions[1].Topic))
+	a.Equal(uint64(42), devices[0].Subscriptions[1].LastID)
+	a.Equal(lastSuccess, *devices[0].Subscriptions[1].LastSuccess)
+
+	a.Equal("device2", devices[1].ID)
+	a.Equal(1, len(devices[1].Subscriptions))
+}
+
+func TestConnector_DevicesNotBoundToUsers(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	conn, _ := getTestConnector(t, Config{
+		Name:       "test",
+		Schema:     "test",
+		Prefix:     "/connector/",
+		URLPattern: "/{device_token}/{user_id}/{topic:.*}",
+	}, true, false)
+
+	assert.Nil(t, conn.Devices("user1"))
+}
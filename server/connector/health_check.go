@@ -0,0 +1,200 @@
+package connector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const HealthCheckPath = "/health-check"
+
+// The statuses of the device tokens, as determined by a health check.
+const (
+	TokenValid   = "valid"
+	TokenDead    = "dead"
+	TokenUnknown = "unknown"
+)
+
+// TokenChecker is implemented by the senders which can check the device token of a subscription
+// with the API of the push provider, without delivering a notification.
+type TokenChecker interface {
+	// CheckToken returns true if the device token of the subscriber is valid,
+	// or an error if the provider could not tell
+	CheckToken(Subscriber) (bool, error)
+}
+
+// HealthCheckConfig configures the health checks of the device tokens of a connector.
+type HealthCheckConfig struct {
+	// TokenParam is the route param holding the device token (the subscriptions of a token are checked once)
+	TokenParam string
+
+	// Interval is the time between the periodic health checks (0: only on request)
+	Interval time.Duration
+
+	// MaxFailures is the number of consecutive failed pushes after which a token is dead,
+	// when the sender can not check it with the provider (0: never)
+	MaxFailures int
+}
+
+// HealthReport is the summary of a health check of the device tokens.
+type HealthReport struct {
+	Connector string    `json:"connector"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+
+	Tokens  int `json:"tokens"`
+	Valid   int `json:"valid"`
+	Dead    int `json:"dead"`
+	Unknown int `json:"unknown"`
+
+	// Removed is the number of subscriptions of the dead tokens which were removed
+	Removed int `json:"removed"`
+
+	DeadTokens []string `json:"dead_tokens,omitempty"`
+}
+
+// checkHealth checks the device tokens of all the subscriptions, and removes the subscriptions of the dead tokens
+// if remove is set (otherwise they are only reported). Only one health check runs at a time.
+func (c *connector) checkHealth(remove bool) *HealthReport {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+
+	report := &HealthReport{
+		Connector: c.config.Name,
+		Started:   time.Now(),
+	}
+	subscribersByToken := c.subscribersByToken()
+	tokens := make([]string, 0, len(subscribersByToken))
+	for token := range subscribersByToken {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+
+	for _, token := range tokens {
+		if c.ctx != nil && c.ctx.Err() != nil {
+			break
+		}
+		subscribers := subscribersByToken[token]
+		report.Tokens++
+		switch c.tokenStatus(subscribers) {
+		case TokenValid:
+			report.Valid++
+		case TokenUnknown:
+			report.Unknown++
+		case TokenDead:
+			report.Dead++
+			report.DeadTokens = append(report.DeadTokens, token)
+			if remove {
+				report.Removed += c.removeAll(subscribers)
+			}
+		}
+	}
+	report.Finished = time.Now()
+
+	c.lastHealthReport = report
+	c.logger.WithFields(log.Fields{
+		"tokens":  report.Tokens,
+		"dead":    report.Dead,
+		"unknown": report.Unknown,
+		"removed": report.Removed,
+	}).Info("Health check of the device tokens done")
+	return report
+}
+
+func (c *connector) subscribersByToken() map[string][]Subscriber {
+	subscribersByToken := make(map[string][]Subscriber)
+	for _, s := range c.manager.List() {
+		token := s.Key()
+		if c.config.HealthCheck.TokenParam != "" {
+			token = s.Route().Get(c.config.HealthCheck.TokenParam)
+		}
+		subscribersByToken[token] = append(subscribersByToken[token], s)
+	}
+	return subscribersByToken
+}
+
+// tokenStatus checks the token of the subscribers with the provider if the sender is a TokenChecker,
+// and else (or if the provider could not tell) with the failures of the last pushes.
+func (c *connector) tokenStatus(subscribers []Subscriber) string {
+	checker, isChecker := c.sender.(TokenChecker)
+	if isChecker {
+		valid, err := checker.CheckToken(subscribers[0])
+		if err == nil {
+			if valid {
+				return TokenValid
+			}
+			return TokenDead
+		}
+		c.logger.WithField("error", err.Error()).Warn("Device token could not be checked with the provider")
+	}
+
+	maxFailures := c.config.HealthCheck.MaxFailures
+	for _, s := range subscribers {
+		if maxFailures > 0 && s.Failures() >= maxFailures {
+			return TokenDead
+		}
+	}
+	if isChecker {
+		return TokenUnknown
+	}
+	return TokenValid
+}
+
+func (c *connector) removeAll(subscribers []Subscriber) (removed int) {
+	for _, s := range subscribers {
+		if err := c.manager.Remove(s); err != nil {
+			c.logger.WithField("error", err.Error()).WithField("subscriber", s).Error("Error removing subscription of dead token")
+			continue
+		}
+		removed++
+	}
+	return
+}
+
+// healthCheckLoop runs the periodic health checks, until the connector is stopped.
+func (c *connector) healthCheckLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.config.HealthCheck.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.checkHealth(true)
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// GetHealthCheck returns the report of the last health check.
+func (c *connector) GetHealthCheck(w http.ResponseWriter, req *http.Request) {
+	c.healthMu.Lock()
+	report := c.lastHealthReport
+	c.healthMu.Unlock()
+
+	if report == nil {
+		http.Error(w, `{"error":"no health check done"}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// PostHealthCheck runs a health check and returns its report.
+// The subscriptions of the dead tokens are removed, unless requested with `?remove=false`.
+func (c *connector) PostHealthCheck(w http.ResponseWriter, req *http.Request) {
+	remove := true
+	if value := req.URL.Query().Get("remove"); value != "" {
+		var err error
+		if remove, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"invalid remove parameter: %s"}`, value), http.StatusBadRequest)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(c.checkHealth(remove))
+}
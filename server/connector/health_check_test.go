@@ -0,0 +1,130 @@
+package connector
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// tokenCheckerSender is a Sender checking the tokens by their validity in the map (an unknown token is an error).
+type tokenCheckerSender map[string]bool
+
+func (s tokenCheckerSender) Send(Request) (interface{}, error) {
+	return nil, nil
+}
+
+func (s tokenCheckerSender) CheckToken(subscriber Subscriber) (bool, error) {
+	valid, ok := s[subscriber.Route().Get("device_token")]
+	if !ok {
+		return false, errors.New("provider unavailable")
+	}
+	return valid, nil
+}
+
+func TestConnector_HealthCheckWithFailures(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	conn, mocks := getTestConnector(t, Config{
+		Name:        "test",
+		Schema:      "test",
+		Prefix:      "/connector/",
+		URLPattern:  "/{device_token}/{user_id}/{topic:.*}",
+		HealthCheck: HealthCheckConfig{TokenParam: "device_token", MaxFailures: 3},
+	}, true, false)
+
+	// no health check was done yet
+	recorder := serveHealthCheck(conn, http.MethodGet, "")
+	a.Equal(http.StatusNotFound, recorder.Code)
+
+	dead1 := newTestSubscriber("/topic1", "device1", 3)
+	dead2 := newTestSubscriber("/topic2", "device1", 0)
+	alive := newTestSubscriber("/topic1", "device2", 1)
+	mocks.manager.EXPECT().List().Return([]Subscriber{dead1, alive, dead2}).Times(2)
+
+	// the dead tokens are only reported, when not removing them
+	recorder = serveHealthCheck(conn, http.MethodPost, "?remove=false")
+	a.Equal(http.StatusOK, recorder.Code)
+	report := decodeHealthReport(t, recorder)
+	a.Equal(2, report.Tokens)
+	a.Equal(1, report.Valid)
+	a.Equal(1, report.Dead)
+	a.Equal(0, report.Removed)
+	a.Equal([]string{"device1"}, report.DeadTokens)
+
+	// all the subscriptions of a dead token are removed
+	mocks.manager.EXPECT().Remove(dead1).Return(nil)
+	mocks.manager.EXPECT().Remove(dead2).Return(nil)
+	recorder = serveHealthCheck(conn, http.MethodPost, "")
+	a.Equal(http.StatusOK, recorder.Code)
+	report = decodeHealthReport(t, recorder)
+	a.Equal("test", report.Connector)
+	a.Equal(1, report.Dead)
+	a.Equal(2, report.Removed)
+
+	// the last report is kept
+	recorder = serveHealthCheck(conn, http.MethodGet, "")
+	a.Equal(http.StatusOK, recorder.Code)
+	a.Equal(report, decodeHealthReport(t, recorder))
+
+	recorder = serveHealthCheck(conn, http.MethodPost, "?remove=maybe")
+	a.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func TestConnector_HealthCheckWithTokenChecker(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	conn, mocks := getTestConnector(t, Config{
+		Name:        "test",
+		Schema:      "test",
+		Prefix:      "/connector/",
+		URLPattern:  "/{device_token}/{user_id}/{topic:.*}",
+		HealthCheck: HealthCheckConfig{TokenParam: "device_token", MaxFailures: 3},
+	}, true, false)
+	conn.SetSender(tokenCheckerSender{"device1": false, "device2": true})
+
+	invalid := newTestSubscriber("/topic", "device1", 0)
+	valid := newTestSubscriber("/topic", "device2", 5)
+	unchecked := newTestSubscriber("/topic", "device3", 0)
+	uncheckedFailing := newTestSubscriber("/topic", "device4", 3)
+	mocks.manager.EXPECT().List().Return([]Subscriber{invalid, valid, unchecked, uncheckedFailing})
+	mocks.manager.EXPECT().Remove(invalid).Return(nil)
+	mocks.manager.EXPECT().Remove(uncheckedFailing).Return(nil)
+
+	report := conn.(*connector).checkHealth(true)
+	a.Equal(4, report.Tokens)
+	a.Equal(1, report.Valid)
+	a.Equal(2, report.Dead)
+	a.Equal(1, report.Unknown)
+	a.Equal(2, report.Removed)
+	a.Equal([]string{"device1", "device4"}, report.DeadTokens)
+}
+
+func newTestSubscriber(topic, deviceToken string, failures int) Subscriber {
+	s := NewSubscriber(protocol.Path(topic), router.RouteParams{"device_token": deviceToken, "user_id": "user1"}, 0)
+	s.SetFailures(failures)
+	return s
+}
+
+func serveHealthCheck(conn Connector, method, query string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, "/connector/health-check"+query, nil)
+	conn.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func decodeHealthReport(t *testing.T, recorder *httptest.ResponseRecorder) *HealthReport {
+	report := new(HealthReport)
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(report))
+	return report
+}
@@ -0,0 +1,123 @@
+package connector
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/server/kvstore"
+)
+
+// sentSchemaSuffix is appended to the schema of a connector, for the KVStore schema of its send records.
+const sentSchemaSuffix = "_sent"
+
+// ProviderMessageID returns the message ID assigned by the provider in the response to a sent request,
+// or "" if the message was not delivered.
+type ProviderMessageID func(response interface{}) string
+
+// idempotentQueue records the messages delivered to each subscriber (with their provider message ID),
+// so that they are not pushed again if they are fetched again after a restart: a worker can be stopped between
+// sending a message and storing the lastID of its subscriber.
+// A record is kept until the lastID of a later message of the subscriber is stored.
+type idempotentQueue struct {
+	Queue
+	kvstore    kvstore.KVStore
+	schema     string
+	manager    Manager
+	providerID ProviderMessageID
+}
+
+func newIdempotentQueue(q Queue, kvs kvstore.KVStore, schema string, manager Manager, providerID ProviderMessageID) *idempotentQueue {
+	return &idempotentQueue{
+		Queue:      q,
+		kvstore:    kvs,
+		schema:     schema + sentSchemaSuffix,
+		manager:    manager,
+		providerID: providerID,
+	}
+}
+
+// Push drops the requests of the messages already delivered to their subscriber, only storing their lastID.
+func (q *idempotentQueue) Push(request Request) error {
+	s := request.Subscriber()
+	id := request.Message().ID
+	value, sent, err := q.kvstore.Get(q.schema, sentKey(s.Key(), id))
+	if err != nil {
+		// better sent twice than never
+		logger.WithError(err).WithField("subscriber", s.Key()).Error("Error reading the send record of a message")
+	} else if sent {
+		logger.WithFields(log.Fields{
+			"subscriber":        s.Key(),
+			"messageID":         id,
+			"providerMessageID": string(value),
+		}).Info("Not sending a message already delivered")
+		s.SetLastID(id)
+		return q.manager.Update(s)
+	}
+	return q.Queue.Push(request)
+}
+
+func (q *idempotentQueue) SetResponseHandler(rh ResponseHandler) {
+	if rh != nil {
+		rh = &recordingHandler{rh, q}
+	}
+	q.Queue.SetResponseHandler(rh)
+}
+
+// recordingHandler records the delivered messages, before their responses are handled.
+type recordingHandler struct {
+	ResponseHandler
+	q *idempotentQueue
+}
+
+func (h *recordingHandler) HandleResponse(request Request, response interface{}, metadata *Metadata, err error) error {
+	var recorded bool
+	if err == nil {
+		recorded = h.q.record(request, response)
+	}
+	if err := h.ResponseHandler.HandleResponse(request, response, metadata, err); err != nil {
+		return err
+	}
+	if recorded {
+		h.q.removeBefore(request.Subscriber().Key(), request.Message().ID)
+	}
+	return nil
+}
+
+func (q *idempotentQueue) record(request Request, response interface{}) bool {
+	providerID := q.providerID(response)
+	if providerID == "" {
+		return false
+	}
+	key := sentKey(request.Subscriber().Key(), request.Message().ID)
+	if err := q.kvstore.Put(q.schema, key, []byte(providerID)); err != nil {
+		logger.WithError(err).WithField("key", key).Error("Error storing the send record of a message")
+		return false
+	}
+	return true
+}
+
+// removeBefore removes the send records of a subscriber older than the message, which cannot be fetched again
+// since the lastID of the subscriber is stored.
+func (q *idempotentQueue) removeBefore(subscriberKey string, messageID uint64) {
+	prefix := subscriberKey + "/"
+	var obsolete []kvstore.Operation
+	for key := range q.kvstore.IterateKeys(context.Background(), q.schema, prefix, 0) {
+		id, err := strconv.ParseUint(strings.TrimPrefix(key, prefix), 10, 64)
+		if err == nil && id < messageID {
+			obsolete = append(obsolete, kvstore.Operation{Key: key, Delete: true})
+		}
+	}
+	if len(obsolete) == 0 {
+		return
+	}
+	if err := q.kvstore.Batch(q.schema, obsolete); err != nil {
+		logger.WithError(err).WithField("subscriber", subscriberKey).Error("Error removing the send records")
+	}
+}
+
+func sentKey(subscriberKey string, messageID uint64) string {
+	return subscriberKey + "/" + strconv.FormatUint(messageID, 10)
+}
@@ -0,0 +1,67 @@
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotentQueue_DoesNotSendTwice(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	manager := NewManager("test", kvs)
+	s := NewSubscriber("/news", map[string]string{"device_token": "device1"}, 0)
+	a.NoError(manager.Add(s))
+
+	var handler ResponseHandler
+	mQueue := NewMockQueue(testutil.MockCtrl)
+	mQueue.EXPECT().SetResponseHandler(gomock.Any()).Do(func(rh ResponseHandler) {
+		handler = rh
+	})
+	var pushed []Request
+	mQueue.EXPECT().Push(gomock.Any()).Do(func(r Request) error {
+		pushed = append(pushed, r)
+		return nil
+	}).Return(nil).AnyTimes()
+
+	mHandler := NewMockResponseHandler(testutil.MockCtrl)
+	mHandler.EXPECT().HandleResponse(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	q := newIdempotentQueue(mQueue, kvs, "test", manager, func(response interface{}) string {
+		return response.(string)
+	})
+	q.SetResponseHandler(mHandler)
+
+	// the messages 1 and 2 are delivered, the message 3 is not
+	for i, providerID := range []string{"fcm-1", "fcm-2", ""} {
+		r := NewRequest(s, &protocol.Message{ID: uint64(i + 1)})
+		a.NoError(q.Push(r))
+		a.NoError(handler.HandleResponse(r, providerID, nil, nil))
+	}
+	a.Equal(3, len(pushed))
+
+	// the records older than the last delivered message are removed
+	var keys []string
+	for key := range kvs.IterateKeys(context.Background(), "test"+sentSchemaSuffix, s.Key(), 0) {
+		keys = append(keys, key)
+	}
+	a.Equal([]string{sentKey(s.Key(), 2)}, keys)
+
+	// after a restart, the delivered message is not sent again, but its lastID is stored
+	s.SetLastID(0)
+	pushed = nil
+	a.NoError(q.Push(NewRequest(s, &protocol.Message{ID: 2})))
+	a.NoError(q.Push(NewRequest(s, &protocol.Message{ID: 3})))
+	if a.Equal(1, len(pushed)) {
+		a.Equal(uint64(3), pushed[0].Message().ID)
+	}
+	a.Equal(uint64(2), s.(*subscriber).data.LastID)
+}
@@ -0,0 +1,92 @@
+package connector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/monitor"
+)
+
+// lagTracker tracks the messages waiting in the queue of a connector, by partition,
+// so that the delivery lag of a partition is the age of its oldest waiting message.
+type lagTracker struct {
+	mu sync.Mutex
+
+	// the waiting messages (pushed once for each of their subscribers), by partition
+	pending map[string]map[*protocol.Message]int
+}
+
+func newLagTracker() *lagTracker {
+	return &lagTracker{pending: make(map[string]map[*protocol.Message]int)}
+}
+
+func (t *lagTracker) add(m *protocol.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	partition := m.Path.Partition()
+	messages, ok := t.pending[partition]
+	if !ok {
+		messages = make(map[*protocol.Message]int)
+		t.pending[partition] = messages
+	}
+	messages[m]++
+}
+
+func (t *lagTracker) done(m *protocol.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	partition := m.Path.Partition()
+	messages := t.pending[partition]
+	if messages[m] > 1 {
+		messages[m]--
+		return
+	}
+	delete(messages, m)
+	if len(messages) == 0 {
+		delete(t.pending, partition)
+	}
+}
+
+// lags returns the delivery lags of the partitions with waiting messages.
+func (t *lagTracker) lags(source string, now time.Time) []monitor.Lag {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lags := make([]monitor.Lag, 0, len(t.pending))
+	for partition, messages := range t.pending {
+		var oldest time.Duration
+		for m := range messages {
+			if age := monitor.MessageAge(m.Time, now); age > oldest {
+				oldest = age
+			}
+		}
+		lags = append(lags, monitor.Lag{
+			Kind:      monitor.DeliveryLag,
+			Source:    source,
+			Partition: partition,
+			Lag:       oldest,
+		})
+	}
+	return lags
+}
+
+// oldest returns the number of the waiting requests, and the oldest waiting message (nil if none).
+func (t *lagTracker) oldest() (int, *protocol.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var depth int
+	var oldest *protocol.Message
+	for _, messages := range t.pending {
+		for m, n := range messages {
+			depth += n
+			if oldest == nil || m.Time < oldest.Time || (m.Time == oldest.Time && m.ID < oldest.ID) {
+				oldest = m
+			}
+		}
+	}
+	return depth, oldest
+}
@@ -0,0 +1,40 @@
+package connector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/monitor"
+)
+
+func TestLagTracker(t *testing.T) {
+	a := assert.New(t)
+
+	now := time.Now()
+	older := &protocol.Message{ID: 1, Path: "/news/sport", Time: now.Add(-2 * time.Minute).Unix()}
+	newer := &protocol.Message{ID: 2, Path: "/news", Time: now.Add(-time.Minute).Unix()}
+
+	tracker := newLagTracker()
+	a.Empty(tracker.lags("fcm", now))
+
+	// a message pushed for two subscribers waits until both requests are handled
+	tracker.add(older)
+	tracker.add(older)
+	tracker.add(newer)
+	a.Equal([]monitor.Lag{{
+		Kind:      monitor.DeliveryLag,
+		Source:    "fcm",
+		Partition: "news",
+		Lag:       monitor.MessageAge(older.Time, now),
+	}}, tracker.lags("fcm", now))
+
+	tracker.done(older)
+	tracker.done(older)
+	a.Equal(monitor.MessageAge(newer.Time, now), tracker.lags("fcm", now)[0].Lag)
+
+	tracker.done(newer)
+	a.Empty(tracker.lags("fcm", now))
+}
@@ -0,0 +1,101 @@
+package connector
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// dormantIndex holds the subscribers of a lazy connector which have no route in the router yet,
+// indexed by their topic, so that their routes are only created by the first message matching them.
+type dormantIndex struct {
+	mu     sync.Mutex
+	topics map[protocol.Path]map[string]Subscriber
+	count  int
+}
+
+func newDormantIndex() *dormantIndex {
+	return &dormantIndex{topics: make(map[protocol.Path]map[string]Subscriber)}
+}
+
+func (d *dormantIndex) add(s Subscriber) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	topic := s.Route().Path
+	subscribers, ok := d.topics[topic]
+	if !ok {
+		subscribers = make(map[string]Subscriber)
+		d.topics[topic] = subscribers
+	}
+	if _, exists := subscribers[s.Key()]; !exists {
+		d.count++
+	}
+	subscribers[s.Key()] = s
+}
+
+// take removes and returns the dormant subscribers matching the message: the ones of its topic or of one of
+// the parent topics, whose params match the filters of the message.
+func (d *dormantIndex) take(m *protocol.Message) []Subscriber {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.count == 0 {
+		return nil
+	}
+	var taken []Subscriber
+	for _, topic := range topicAndParents(m.Path) {
+		subscribers, ok := d.topics[topic]
+		if !ok {
+			continue
+		}
+		for key, s := range subscribers {
+			if m.Filters != nil && !s.Filter(m.Filters) {
+				continue
+			}
+			taken = append(taken, s)
+			delete(subscribers, key)
+			d.count--
+		}
+		if len(subscribers) == 0 {
+			delete(d.topics, topic)
+		}
+	}
+	return taken
+}
+
+func (d *dormantIndex) size() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.count
+}
+
+// topicAndParents returns the path and all its parent paths (e.g. /a/b/c, /a/b and /a),
+// which are the topics of the routes receiving the messages of the path.
+func topicAndParents(path protocol.Path) []protocol.Path {
+	topics := []protocol.Path{path}
+	p := string(path)
+	for i := strings.LastIndex(p, "/"); i > 0; i = strings.LastIndex(p, "/") {
+		p = p[:i]
+		topics = append(topics, protocol.Path(p))
+	}
+	return topics
+}
+
+// materialize creates the routes of the dormant subscribers matching a message, which was just stored by the router.
+// The subscribers which never received a message fetch from it, so that it is delivered to them as well.
+func (c *connector) materialize(m *protocol.Message) {
+	for _, s := range c.dormant.take(m) {
+		// the subscriber was removed or replaced while dormant
+		if c.manager.Find(s.Key()) != s {
+			continue
+		}
+		if s.Route().FetchRequest == nil {
+			s.SetLastID(m.ID)
+			s.Reset()
+		}
+		c.logger.WithField("subscriber", s.Key()).Debug("Materializing the route of a dormant subscriber")
+		go c.Run(s)
+	}
+}
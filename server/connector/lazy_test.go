@@ -0,0 +1,98 @@
+package connector
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/filestore"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicAndParents(t *testing.T) {
+	a := assert.New(t)
+	a.Equal([]protocol.Path{"/a/b/c", "/a/b", "/a"}, topicAndParents("/a/b/c"))
+	a.Equal([]protocol.Path{"/a"}, topicAndParents("/a"))
+}
+
+func TestConnector_LazyRoutesAreCreatedByTheFirstMatchingMessage(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// the materialized routes fetch the messages which created them, so the store must answer the fetch requests
+	dir, _ := ioutil.TempDir("", "guble_connector_lazy_test")
+	defer os.RemoveAll(dir)
+	messageStore := filestore.New(dir)
+	defer messageStore.Stop()
+	kvs := kvstore.NewMemoryKVStore()
+	r := router.New(auth.NewAllowAllAccessManager(true), messageStore, kvs, nil)
+	a.NoError(r.(service.Startable).Start())
+	defer r.(service.Stopable).Stop()
+
+	sentC := make(chan Request, 3)
+	mSender := NewMockSender(testutil.MockCtrl)
+	mSender.EXPECT().Send(gomock.Any()).Do(func(request Request) {
+		sentC <- request
+	}).Return(nil, nil).Times(3)
+
+	conn, err := NewConnector(r, mSender, Config{
+		Name:       "test",
+		Schema:     "test",
+		Prefix:     "/connector/",
+		URLPattern: "/{device_token}/{user_id}/{topic:.*}",
+		Lazy:       true,
+	})
+	a.NoError(err)
+	a.NoError(conn.Start())
+	defer conn.Stop()
+
+	createSubscriptions(t, conn, 2)
+	dormant := conn.(*connector).dormant
+	a.Equal(2, dormant.size())
+
+	// a message of another topic does not create routes
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/other", Body: []byte("other")}))
+
+	// a message of a subtopic, filtered for the first user, only creates the route of its subscriber
+	a.NoError(r.HandleMessage(&protocol.Message{
+		Path:    "/topic/news",
+		Body:    []byte("first"),
+		Filters: map[string]string{"user_id": "user1"},
+	}))
+	assertSent(a, sentC, "device1", "first")
+	a.Equal(1, dormant.size())
+	time.Sleep(50 * time.Millisecond)
+
+	// the next message is delivered by the route of the first subscriber, and creates the route of the second one
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/topic", Body: []byte("second")}))
+	received := map[string]string{}
+	for i := 0; i < 2; i++ {
+		select {
+		case request := <-sentC:
+			received[request.Subscriber().Route().Get("device_token")] = string(request.Message().Body)
+		case <-time.After(time.Second):
+			a.Fail("The message was not sent")
+		}
+	}
+	a.Equal(map[string]string{"device1": "second", "device2": "second"}, received)
+	a.Equal(0, dormant.size())
+}
+
+func assertSent(a *assert.Assertions, sentC chan Request, deviceToken, body string) {
+	select {
+	case request := <-sentC:
+		a.Equal(deviceToken, request.Subscriber().Route().Get("device_token"))
+		a.Equal(body, string(request.Message().Body))
+	case <-time.After(time.Second):
+		a.Fail("The message was not sent", body)
+	}
+}
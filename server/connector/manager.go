@@ -1,15 +1,30 @@
 package connector
 
 import (
+	"context"
+	"encoding/json"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
 
 	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/kvstore"
 	"github.com/smancke/guble/server/router"
 )
 
+var (
+	// loadWorkers is the number of goroutines decoding the subscribers, when they are loaded
+	loadWorkers = runtime.NumCPU()
+
+	// loadProgressInterval is the interval at which the progress of the loading is logged
+	loadProgressInterval = 10 * time.Second
+)
+
 type Manager interface {
-	Load() error
+	Load(context.Context) error
 	List() []Subscriber
 	Filter(map[string]string) []Subscriber
 	Find(string) Subscriber
@@ -17,6 +32,8 @@ type Manager interface {
 	Create(protocol.Path, router.RouteParams) (Subscriber, error)
 	Add(Subscriber) error
 	Update(Subscriber) error
+	UpdateAll([]Subscriber) error
+	Substitute(subscribers []Subscriber, param, value string) error
 	Remove(Subscriber) error
 }
 
@@ -35,19 +52,73 @@ func NewManager(schema string, kvstore kvstore.KVStore) Manager {
 	}
 }
 
-func (m *manager) Load() error {
-	// try to load s from kvstore
-	entries := m.kvstore.Iterate(m.schema, "")
-	for e := range entries {
-		subscriber, err := NewSubscriberFromJSON([]byte(e[1]))
-		if err != nil {
-			return err
-		}
-		m.subscribers[subscriber.Key()] = subscriber
+func (m *manager) Load(ctx context.Context) error {
+	// the iteration is stopped when returning early (e.g. on a decoding error)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		loaded  int64
+		errOnce sync.Once
+		loadErr error
+		wg      sync.WaitGroup
+	)
+	done := make(chan struct{})
+	defer close(done)
+	go m.logLoadProgress(&loaded, done)
+
+	// the subscribers are decoded by a pool of workers, since there can be millions of them
+	entries := m.kvstore.Iterate(ctx, m.schema, "", 0)
+	for i := 0; i < loadWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range entries {
+				subscriber, err := NewSubscriberFromJSON([]byte(e[1]))
+				if err != nil {
+					errOnce.Do(func() {
+						loadErr = err
+						cancel()
+					})
+					continue
+				}
+				m.putSubscriber(subscriber)
+				atomic.AddInt64(&loaded, 1)
+			}
+		}()
 	}
+	wg.Wait()
+
+	if loadErr != nil {
+		return loadErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	logger.WithFields(log.Fields{
+		"schema": m.schema,
+		"count":  atomic.LoadInt64(&loaded),
+	}).Info("Loaded subscribers")
 	return nil
 }
 
+// logLoadProgress logs the number of loaded subscribers periodically, until done is closed.
+func (m *manager) logLoadProgress(loaded *int64, done chan struct{}) {
+	ticker := time.NewTicker(loadProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			logger.WithFields(log.Fields{
+				"schema": m.schema,
+				"count":  atomic.LoadInt64(loaded),
+			}).Info("Loading subscribers")
+		case <-done:
+			return
+		}
+	}
+}
+
 func (m *manager) Find(key string) Subscriber {
 	m.RLock()
 	defer m.RUnlock()
@@ -109,11 +180,14 @@ func (m *manager) Add(s Subscriber) error {
 		return ErrSubscriberExists
 	}
 
-	if err := m.updateStore(s); err != nil {
+	// the subscriber is known before it is stored, so that the change is not applied again
+	// when it is notified by the KVStore
+	m.putSubscriber(s)
+	if err := m.storeBatch([]Subscriber{s}, Subscriber.Encode); err != nil {
+		m.deleteSubscriber(s)
 		return err
 	}
 
-	m.putSubscriber(s)
 	logger.WithField("subscriber", s).Info("Add subscriber finished")
 	return nil
 }
@@ -134,7 +208,93 @@ func (m *manager) Update(s Subscriber) error {
 	return nil
 }
 
+// UpdateAll stores all the subscribers in a single batch, so that either all of them are updated, or none.
+func (m *manager) UpdateAll(subscribers []Subscriber) error {
+	if len(subscribers) == 0 {
+		return nil
+	}
+	if err := m.existAll(subscribers); err != nil {
+		return err
+	}
+	if err := m.storeBatch(subscribers, Subscriber.Encode); err != nil {
+		return err
+	}
+
+	for _, s := range subscribers {
+		m.putSubscriber(s)
+	}
+	logger.WithField("count", len(subscribers)).Info("Update of all subscribers finished")
+	return nil
+}
+
+// Substitute stores the subscribers with the new value of a route param in a single batch,
+// and changes their routes only after the batch succeeded, so that either all of them are substituted, or none.
+func (m *manager) Substitute(subscribers []Subscriber, param, value string) error {
+	if len(subscribers) == 0 {
+		return nil
+	}
+	if err := m.existAll(subscribers); err != nil {
+		return err
+	}
+	err := m.storeBatch(subscribers, func(s Subscriber) ([]byte, error) {
+		return substitutedData(s, param, value)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, s := range subscribers {
+		s.Route().Set(param, value)
+	}
+	logger.WithField("count", len(subscribers)).WithField("param", param).Info("Substitution of subscribers finished")
+	return nil
+}
+
+// substitutedData returns the encoded data of the subscriber with the new value of the route param,
+// without changing the subscriber.
+func substitutedData(s Subscriber, param, value string) ([]byte, error) {
+	encoded, err := s.Encode()
+	if err != nil {
+		return nil, err
+	}
+	var sd SubscriberData
+	if err := json.Unmarshal(encoded, &sd); err != nil {
+		return nil, err
+	}
+	if sd.Params == nil {
+		sd.Params = make(router.RouteParams)
+	}
+	sd.Params[param] = value
+	return json.Marshal(sd)
+}
+
+func (m *manager) existAll(subscribers []Subscriber) error {
+	for _, s := range subscribers {
+		if !m.Exists(s.Key()) {
+			return ErrSubscriberDoesNotExist
+		}
+	}
+	return nil
+}
+
+// storeBatch stores the subscribers, encoded by the given func, in a single batch of the KVStore.
+func (m *manager) storeBatch(subscribers []Subscriber, encode func(Subscriber) ([]byte, error)) error {
+	ops := make([]kvstore.Operation, 0, len(subscribers))
+	for _, s := range subscribers {
+		data, err := encode(s)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, kvstore.Operation{Key: s.Key(), Value: data})
+	}
+	return m.kvstore.Batch(m.schema, ops)
+}
+
 func (m *manager) putSubscriber(s Subscriber) {
+	// the response of a replayed request updates the subscriber itself
+	if rs, ok := s.(*replayedSubscriber); ok {
+		s = rs.Subscriber
+	}
 	m.Lock()
 	defer m.Unlock()
 	m.subscribers[s.Key()] = s
@@ -172,6 +332,40 @@ func (m *manager) Remove(s Subscriber) error {
 	return nil
 }
 
+// apply updates the subscribers with a change of the KVStore, done by another guble node.
+// It returns the subscriber which was added, if any.
+func (m *manager) apply(change kvstore.Change) (Subscriber, error) {
+	if change.Deleted {
+		if s := m.Find(change.Key); s != nil {
+			logger.WithField("subscriber", s).Info("Removing subscriber deleted by another node")
+			m.cancelSubscriber(s)
+			m.deleteSubscriber(s)
+		}
+		return nil, nil
+	}
+
+	if m.Exists(change.Key) {
+		return nil, nil
+	}
+	data, exist, err := m.kvstore.Get(m.schema, change.Key)
+	if err != nil || !exist {
+		return nil, err
+	}
+	s, err := NewSubscriberFromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if _, found := m.subscribers[s.Key()]; found {
+		return nil, nil
+	}
+	m.subscribers[s.Key()] = s
+	logger.WithField("subscriber", s).Info("Added subscriber created by another node")
+	return s, nil
+}
+
 func (m *manager) cancelSubscriber(s Subscriber) {
 	m.Lock()
 	defer m.Unlock()
@@ -1 +1,140 @@
 package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestManager_ApplyChanges(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	m1 := NewManager("test", kvs)
+	m2 := NewManager("test", kvs).(*manager)
+
+	// a subscriber is created by another node
+	s, err := m1.Create(protocol.Path("/topic"), router.RouteParams{"device_token": "abc"})
+	a.NoError(err)
+
+	added, err := m2.apply(kvstore.Change{Schema: "test", Key: s.Key()})
+	a.NoError(err)
+	a.NotNil(added)
+	a.Equal(s.Key(), added.Key())
+	a.True(m2.Exists(s.Key()))
+
+	// the change of a known subscriber is ignored
+	added, err = m2.apply(kvstore.Change{Schema: "test", Key: s.Key()})
+	a.NoError(err)
+	a.Nil(added)
+
+	// the subscriber is removed by the other node
+	a.NoError(m1.Remove(s))
+	added, err = m2.apply(kvstore.Change{Schema: "test", Key: s.Key(), Deleted: true})
+	a.NoError(err)
+	a.Nil(added)
+	a.False(m2.Exists(s.Key()))
+}
+
+func TestManager_ApplyChangeOfMissingEntry(t *testing.T) {
+	a := assert.New(t)
+
+	m := NewManager("test", kvstore.NewMemoryKVStore()).(*manager)
+	added, err := m.apply(kvstore.Change{Schema: "test", Key: "missing"})
+	a.NoError(err)
+	a.Nil(added)
+	a.False(m.Exists("missing"))
+}
+
+func TestManager_UpdateAll(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	m := NewManager("test", kvs)
+
+	s1, err := m.Create(protocol.Path("/topic1"), router.RouteParams{"device_token": "abc"})
+	a.NoError(err)
+	s2, err := m.Create(protocol.Path("/topic2"), router.RouteParams{"device_token": "abc"})
+	a.NoError(err)
+
+	s1.SetLastID(10)
+	s2.SetLastID(20)
+	a.NoError(m.UpdateAll([]Subscriber{s1, s2}))
+
+	for _, s := range []Subscriber{s1, s2} {
+		data, exist, err := kvs.Get("test", s.Key())
+		a.NoError(err)
+		a.True(exist)
+		expected, _ := s.Encode()
+		a.Equal(expected, data)
+	}
+
+	// unknown subscribers are not stored
+	s3 := NewSubscriber(protocol.Path("/topic3"), router.RouteParams{"device_token": "abc"}, 0)
+	a.Equal(ErrSubscriberDoesNotExist, m.UpdateAll([]Subscriber{s1, s3}))
+}
+
+func TestManager_Substitute(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	mKVS := NewMockKVStore(testutil.MockCtrl)
+	m := NewManager("test", mKVS)
+
+	mKVS.EXPECT().Batch("test", gomock.Any()).Times(2)
+	s1, err := m.Create(protocol.Path("/topic1"), router.RouteParams{"device_token": "abc"})
+	a.NoError(err)
+	s2, err := m.Create(protocol.Path("/topic2"), router.RouteParams{"device_token": "abc"})
+	a.NoError(err)
+
+	// the routes are not changed, if the batch fails
+	mKVS.EXPECT().Batch("test", gomock.Any()).Return(errors.New("unavailable"))
+	a.Error(m.Substitute([]Subscriber{s1, s2}, "device_token", "def"))
+	a.Equal("abc", s1.Route().Get("device_token"))
+	a.Equal("abc", s2.Route().Get("device_token"))
+
+	mKVS.EXPECT().Batch("test", gomock.Any()).Do(func(schema string, ops []kvstore.Operation) {
+		a.Equal(2, len(ops))
+		for _, op := range ops {
+			s, err := NewSubscriberFromJSON(op.Value)
+			a.NoError(err)
+			a.Equal("def", s.Route().Get("device_token"))
+		}
+	})
+	a.NoError(m.Substitute([]Subscriber{s1, s2}, "device_token", "def"))
+	a.Equal("def", s1.Route().Get("device_token"))
+	a.Equal("def", s2.Route().Get("device_token"))
+}
+
+func TestManager_Load(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	m1 := NewManager("test", kvs)
+	for i := 0; i < 100; i++ {
+		_, err := m1.Create(protocol.Path(fmt.Sprintf("/topic%d", i)), router.RouteParams{"device_token": "abc"})
+		a.NoError(err)
+	}
+
+	// the subscribers are loaded by several workers
+	m2 := NewManager("test", kvs)
+	a.NoError(m2.Load(context.Background()))
+	a.Equal(100, len(m2.List()))
+	for _, s := range m1.List() {
+		a.True(m2.Exists(s.Key()))
+	}
+
+	// a subscriber which cannot be decoded fails the loading
+	a.NoError(kvs.Put("test", "invalid", []byte("{")))
+	a.Error(NewManager("test", kvs).Load(context.Background()))
+}
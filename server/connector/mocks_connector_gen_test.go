@@ -8,8 +8,10 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/smancke/guble/protocol"
 
+	"github.com/smancke/guble/server/monitor"
 	"github.com/smancke/guble/server/router"
 	"net/http"
+	"time"
 )
 
 // Mock of Connector interface
@@ -33,6 +35,16 @@ func (_m *MockConnector) EXPECT() *_MockConnectorRecorder {
 	return _m.recorder
 }
 
+func (_m *MockConnector) Check() error {
+	ret := _m.ctrl.Call(_m, "Check")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConnectorRecorder) Check() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Check")
+}
+
 func (_m *MockConnector) Context() context.Context {
 	ret := _m.ctrl.Call(_m, "Context")
 	ret0, _ := ret[0].(context.Context)
@@ -43,6 +55,16 @@ func (_mr *_MockConnectorRecorder) Context() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Context")
 }
 
+func (_m *MockConnector) Devices(_param0 string) []Device {
+	ret := _m.ctrl.Call(_m, "Devices", _param0)
+	ret0, _ := ret[0].([]Device)
+	return ret0
+}
+
+func (_mr *_MockConnectorRecorder) Devices(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Devices", arg0)
+}
+
 func (_m *MockConnector) GetPrefix() string {
 	ret := _m.ctrl.Call(_m, "GetPrefix")
 	ret0, _ := ret[0].(string)
@@ -53,6 +75,16 @@ func (_mr *_MockConnectorRecorder) GetPrefix() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetPrefix")
 }
 
+func (_m *MockConnector) Lags() []monitor.Lag {
+	ret := _m.ctrl.Call(_m, "Lags")
+	ret0, _ := ret[0].([]monitor.Lag)
+	return ret0
+}
+
+func (_mr *_MockConnectorRecorder) Lags() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Lags")
+}
+
 func (_m *MockConnector) Manager() Manager {
 	ret := _m.ctrl.Call(_m, "Manager")
 	ret0, _ := ret[0].(Manager)
@@ -280,14 +312,14 @@ func (_mr *_MockManagerRecorder) List() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "List")
 }
 
-func (_m *MockManager) Load() error {
-	ret := _m.ctrl.Call(_m, "Load")
+func (_m *MockManager) Load(_param0 context.Context) error {
+	ret := _m.ctrl.Call(_m, "Load", _param0)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockManagerRecorder) Load() *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Load")
+func (_mr *_MockManagerRecorder) Load(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Load", arg0)
 }
 
 func (_m *MockManager) Remove(_param0 Subscriber) error {
@@ -310,6 +342,26 @@ func (_mr *_MockManagerRecorder) Update(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Update", arg0)
 }
 
+func (_m *MockManager) Substitute(_param0 []Subscriber, _param1 string, _param2 string) error {
+	ret := _m.ctrl.Call(_m, "Substitute", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockManagerRecorder) Substitute(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Substitute", arg0, arg1, arg2)
+}
+
+func (_m *MockManager) UpdateAll(_param0 []Subscriber) error {
+	ret := _m.ctrl.Call(_m, "UpdateAll", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockManagerRecorder) UpdateAll(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdateAll", arg0)
+}
+
 // Mock of Queue interface
 type MockQueue struct {
 	ctrl     *gomock.Controller
@@ -478,6 +530,16 @@ func (_mr *_MockSubscriberRecorder) Encode() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Encode")
 }
 
+func (_m *MockSubscriber) Failures() int {
+	ret := _m.ctrl.Call(_m, "Failures")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+func (_mr *_MockSubscriberRecorder) Failures() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Failures")
+}
+
 func (_m *MockSubscriber) Filter(_param0 map[string]string) bool {
 	ret := _m.ctrl.Call(_m, "Filter", _param0)
 	ret0, _ := ret[0].(bool)
@@ -498,6 +560,26 @@ func (_mr *_MockSubscriberRecorder) Key() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Key")
 }
 
+func (_m *MockSubscriber) LastID() uint64 {
+	ret := _m.ctrl.Call(_m, "LastID")
+	ret0, _ := ret[0].(uint64)
+	return ret0
+}
+
+func (_mr *_MockSubscriberRecorder) LastID() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "LastID")
+}
+
+func (_m *MockSubscriber) LastSuccess() time.Time {
+	ret := _m.ctrl.Call(_m, "LastSuccess")
+	ret0, _ := ret[0].(time.Time)
+	return ret0
+}
+
+func (_mr *_MockSubscriberRecorder) LastSuccess() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "LastSuccess")
+}
+
 func (_m *MockSubscriber) Loop(_param0 context.Context, _param1 Queue) error {
 	ret := _m.ctrl.Call(_m, "Loop", _param0, _param1)
 	ret0, _ := ret[0].(error)
@@ -508,6 +590,16 @@ func (_mr *_MockSubscriberRecorder) Loop(arg0, arg1 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Loop", arg0, arg1)
 }
 
+func (_m *MockSubscriber) QuietHours() *QuietHours {
+	ret := _m.ctrl.Call(_m, "QuietHours")
+	ret0, _ := ret[0].(*QuietHours)
+	return ret0
+}
+
+func (_mr *_MockSubscriberRecorder) QuietHours() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "QuietHours")
+}
+
 func (_m *MockSubscriber) Reset() error {
 	ret := _m.ctrl.Call(_m, "Reset")
 	ret0, _ := ret[0].(error)
@@ -528,6 +620,22 @@ func (_mr *_MockSubscriberRecorder) Route() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Route")
 }
 
+func (_m *MockSubscriber) SetFailures(_param0 int) {
+	_m.ctrl.Call(_m, "SetFailures", _param0)
+}
+
+func (_mr *_MockSubscriberRecorder) SetFailures(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetFailures", arg0)
+}
+
+func (_m *MockSubscriber) SetLastSuccess(_param0 time.Time) {
+	_m.ctrl.Call(_m, "SetLastSuccess", _param0)
+}
+
+func (_mr *_MockSubscriberRecorder) SetLastSuccess(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetLastSuccess", arg0)
+}
+
 func (_m *MockSubscriber) SetLastID(_param0 uint64) {
 	_m.ctrl.Call(_m, "SetLastID", _param0)
 }
@@ -535,3 +643,11 @@ func (_m *MockSubscriber) SetLastID(_param0 uint64) {
 func (_mr *_MockSubscriberRecorder) SetLastID(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetLastID", arg0)
 }
+
+func (_m *MockSubscriber) SetQuietHours(_param0 *QuietHours) {
+	_m.ctrl.Call(_m, "SetQuietHours", _param0)
+}
+
+func (_mr *_MockSubscriberRecorder) SetQuietHours(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetQuietHours", arg0)
+}
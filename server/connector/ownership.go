@@ -0,0 +1,51 @@
+package connector
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/smancke/guble/server/auth"
+)
+
+// UserIDParam is the route param (of the URL pattern, or the filters of the list) holding the user of a subscription.
+const UserIDParam = "user_id"
+
+// owner returns a handler requiring, if the connector has an Authenticator, a token of the user of the subscription,
+// or of an admin (`auth.AdminScope`).
+func (c *connector) owner(h http.HandlerFunc) http.HandlerFunc {
+	return c.authorized(h, func(req *http.Request) string {
+		if userID := mux.Vars(req)[UserIDParam]; userID != "" {
+			return userID
+		}
+		return req.URL.Query().Get(UserIDParam)
+	})
+}
+
+// admin returns a handler requiring, if the connector has an Authenticator, a token of an admin.
+func (c *connector) admin(h http.HandlerFunc) http.HandlerFunc {
+	return c.authorized(h, func(*http.Request) string {
+		return ""
+	})
+}
+
+func (c *connector) authorized(h http.HandlerFunc, userID func(*http.Request) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if c.config.Authenticator == nil {
+			h(w, req)
+			return
+		}
+		identity, err := c.config.Authenticator.Authenticate(auth.RequestToken(req))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, `{"error":"a valid token is required"}`, http.StatusUnauthorized)
+			return
+		}
+		if !identity.CanActAs(userID(req)) {
+			c.logger.WithField("user", identity.UserID).WithField("path", req.URL.Path).Warn("Forbidden request")
+			http.Error(w, `{"error":"forbidden for the user of the token"}`, http.StatusForbidden)
+			return
+		}
+		h(w, req)
+	}
+}
@@ -0,0 +1,103 @@
+package connector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/throttle"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// authenticatorFunc is an auth.Authenticator authenticating with a function.
+type authenticatorFunc func(token string) (*auth.Identity, error)
+
+func (f authenticatorFunc) Authenticate(token string) (*auth.Identity, error) {
+	return f(token)
+}
+
+// testAuthenticator authenticates the tokens `<userID>` and the token `admin` (with the admin scope).
+var testAuthenticator = authenticatorFunc(func(token string) (*auth.Identity, error) {
+	switch token {
+	case "":
+		return nil, auth.ErrInvalidToken
+	case "admin":
+		return &auth.Identity{UserID: "ops", Scopes: []string{auth.AdminScope}}, nil
+	}
+	return &auth.Identity{UserID: token}, nil
+})
+
+func TestConnector_Ownership(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	conn, mocks := getTestConnector(t, Config{
+		Name:          "name",
+		Schema:        "schema",
+		Prefix:        "/connector/",
+		URLPattern:    "/{device_token}/{user_id}/{topic:.*}",
+		Authenticator: testAuthenticator,
+	}, true, false)
+
+	serve := func(method, url, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(""))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		conn.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// the subscriptions can only be removed by their user, or an admin
+	a.Equal(http.StatusUnauthorized, serve(http.MethodDelete, "/connector/device1/user1/topic1", "").Code)
+	recorder := serve(http.MethodDelete, "/connector/device1/user1/topic1", "user2")
+	a.Equal(http.StatusForbidden, recorder.Code)
+	a.Equal(`{"error":"forbidden for the user of the token"}`+"\n", recorder.Body.String())
+	a.Equal(http.StatusForbidden, serve(http.MethodPut, "/connector/quiet-hours/device1/user1/topic1", "user2").Code)
+
+	subscriber := NewMockSubscriber(testutil.MockCtrl)
+	mocks.manager.EXPECT().Find(gomock.Any()).Return(subscriber).Times(2)
+	mocks.manager.EXPECT().Remove(subscriber).Return(nil).Times(2)
+	a.Equal(`{"unsubscribed":"/topic1"}`, serve(http.MethodDelete, "/connector/device1/user1/topic1", "user1").Body.String())
+	a.Equal(`{"unsubscribed":"/topic1"}`, serve(http.MethodDelete, "/connector/device1/user1/topic1", "admin").Body.String())
+
+	// the lists are restricted to the subscriptions of the user, unless requested by an admin
+	mocks.manager.EXPECT().Filter(map[string]string{"user_id": "user1"}).Return(nil)
+	a.Equal(http.StatusOK, serve(http.MethodGet, "/connector/?user_id=user1", "user1").Code)
+	a.Equal(http.StatusForbidden, serve(http.MethodGet, "/connector/?device_token=device1", "user1").Code)
+	mocks.manager.EXPECT().Filter(map[string]string{"device_token": "device1"}).Return(nil)
+	a.Equal(http.StatusOK, serve(http.MethodGet, "/connector/?device_token=device1", "admin").Code)
+
+	// the substitutions and the health checks require an admin
+	a.Equal(http.StatusForbidden, serve(http.MethodPost, "/connector/substitute/", "user1").Code)
+	a.Equal(http.StatusForbidden, serve(http.MethodGet, "/connector"+HealthCheckPath, "user1").Code)
+}
+
+func TestConnector_ThrottledSubscription(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	conn, _ := getTestConnector(t, Config{
+		Name:          "name",
+		Schema:        "schema",
+		Prefix:        "/connector/",
+		URLPattern:    "/{device_token}/{user_id}/{topic:.*}",
+		Authenticator: testAuthenticator,
+		Limiter:       throttle.New(throttle.Config{Rate: 1, Burst: 1}, "/admin/throttled/"),
+	}, true, false)
+
+	// the subscribing requests are limited before their authentication
+	recorder := httptest.NewRecorder()
+	conn.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/connector/device1/user1/topic1", nil))
+	a.Equal(http.StatusUnauthorized, recorder.Code)
+	recorder = httptest.NewRecorder()
+	conn.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/connector/device1/user1/topic1", nil))
+	a.Equal(http.StatusTooManyRequests, recorder.Code)
+}
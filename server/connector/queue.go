@@ -1,11 +1,15 @@
 package connector
 
 import (
+	"context"
 	"sync"
 
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/server/queue"
+	"github.com/smancke/guble/server/router"
 )
 
 // Queue is an interface modeling a task-queue (it is started and more Requests can be pushed to it, and finally it is stopped after all requests are handled).
@@ -18,66 +22,114 @@ type Queue interface {
 	Stop() error
 }
 
-type queue struct {
+type requestQueue struct {
+	name            string
 	sender          Sender
 	responseHandler ResponseHandler
-	requestsC       chan Request
+	requests        *queue.Queue
 	nWorkers        int
 	metrics         bool
 	wg              sync.WaitGroup
+
+	// breaker pauses the workers during the outages of the provider (optional)
+	breaker *Breaker
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	// lags tracks the requests waiting to be sent
+	lags *lagTracker
+
+	// controlMu guards the pausing and purging of the queue by the operators
+	controlMu sync.Mutex
+	// resumed is closed when a paused queue is resumed (nil while not paused)
+	resumed chan struct{}
+	// purgedAt drops the requests pushed until then, instead of sending them
+	purgedAt time.Time
 }
 
 // NewQueue returns a new Queue (not started).
-func NewQueue(sender Sender, nWorkers int) Queue {
-	q := &queue{
+// The name is used for the metrics of the underlying instrumented queue.
+func NewQueue(name string, sender Sender, nWorkers int) Queue {
+	return newQueue(name, sender, nWorkers, nil)
+}
+
+func newQueue(name string, sender Sender, nWorkers int, breaker *Breaker) *requestQueue {
+	return &requestQueue{
+		name:     name,
 		sender:   sender,
 		nWorkers: nWorkers,
 		metrics:  true,
+		breaker:  breaker,
+		lags:     newLagTracker(),
 	}
-	return q
 }
 
-func (q *queue) SetResponseHandler(rh ResponseHandler) {
+func (q *requestQueue) SetResponseHandler(rh ResponseHandler) {
 	q.responseHandler = rh
 }
 
-func (q *queue) ResponseHandler() ResponseHandler {
+func (q *requestQueue) ResponseHandler() ResponseHandler {
 	return q.responseHandler
 }
 
-func (q *queue) Sender() Sender {
+func (q *requestQueue) Sender() Sender {
 	return q.sender
 }
 
-func (q *queue) SetSender(s Sender) {
+func (q *requestQueue) SetSender(s Sender) {
 	q.sender = s
 }
 
 // Start a fixed number of goroutines to handle requests and responses w.r.t. external push-notification services.
-func (q *queue) Start() error {
-	q.requestsC = make(chan Request)
+func (q *requestQueue) Start() error {
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	q.requests = queue.MustNew(queue.Config{
+		Name: "connector_" + q.name,
+	})
 	for i := 1; i <= q.nWorkers; i++ {
 		go q.worker(i)
 	}
 	return nil
 }
 
-func (q *queue) worker(i int) {
+func (q *requestQueue) worker(i int) {
 	logger.WithField("worker", i).Info("starting queue worker")
-	for request := range q.requestsC {
-		q.handle(request)
+	for e := range q.requests.C() {
+		q.handle(q.requests.Take(e).(Request), e.PushedAt)
 	}
 }
 
-func (q *queue) handle(request Request) {
+func (q *requestQueue) handle(request Request, pushedAt time.Time) {
 	q.wg.Add(1)
 	defer q.wg.Done()
+	defer q.lags.done(request.Message())
+
+	// a request waiting for a resume or for the breaker when the queue is stopped is not sent:
+	// its message is fetched again from the store by the next start
+	if !q.waitResumed() {
+		return
+	}
+	if q.purged(pushedAt) {
+		mPurgedRequests.Add(q.name, 1)
+		logger.WithFields(log.Fields{
+			"subscriber": request.Subscriber(),
+			"message":    request.Message().ID,
+		}).Info("dropping purged request")
+		return
+	}
+	if !q.breaker.Allow(q.ctx) {
+		return
+	}
 
 	var beforeSend time.Time
 	if q.metrics {
 		beforeSend = time.Now()
 	}
 	response, err := q.sender.Send(request)
+	q.breaker.Report(err)
+	if err == nil {
+		router.RecordDelivery(q.name, request.Message())
+	}
 	if q.responseHandler != nil {
 		var metadata *Metadata
 		if q.metrics {
@@ -98,25 +150,77 @@ func (q *queue) handle(request Request) {
 	}
 }
 
-func (q *queue) Push(request Request) error {
-	// recover if the channel been closed
-	defer func() {
-		if r := recover(); r != nil {
-			switch x := r.(type) {
-			case error:
-				logger.WithError(x).Error("recovered from error")
-			default:
-				panic(r)
-			}
-		}
-	}()
-
-	q.requestsC <- request
-	return nil
+func (q *requestQueue) Push(request Request) error {
+	q.lags.add(request.Message())
+	err := q.requests.Push(request)
+	if err != nil {
+		q.lags.done(request.Message())
+		logger.WithError(err).Error("could not push request to queue")
+	}
+	return err
 }
 
-func (q *queue) Stop() error {
-	close(q.requestsC)
+func (q *requestQueue) Stop() error {
+	q.requests.Close()
+	q.cancel()
 	q.wg.Wait()
 	return nil
 }
+
+// waitResumed blocks while the queue is paused, and returns false if it is stopped first.
+func (q *requestQueue) waitResumed() bool {
+	q.controlMu.Lock()
+	resumed := q.resumed
+	q.controlMu.Unlock()
+	if resumed == nil {
+		return q.ctx.Err() == nil
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-q.ctx.Done():
+		return false
+	}
+}
+
+// pause stops the workers from sending the requests, until the queue is resumed.
+// The requests being sent are completed.
+func (q *requestQueue) pause() {
+	q.controlMu.Lock()
+	defer q.controlMu.Unlock()
+	if q.resumed == nil {
+		q.resumed = make(chan struct{})
+	}
+}
+
+// resume restarts the sending of a paused queue.
+func (q *requestQueue) resume() {
+	q.controlMu.Lock()
+	defer q.controlMu.Unlock()
+	if q.resumed != nil {
+		close(q.resumed)
+		q.resumed = nil
+	}
+}
+
+func (q *requestQueue) paused() bool {
+	q.controlMu.Lock()
+	defer q.controlMu.Unlock()
+	return q.resumed != nil
+}
+
+// purge drops the requests waiting in the queue, and returns their number.
+// The requests being sent are completed.
+func (q *requestQueue) purge() int {
+	q.controlMu.Lock()
+	q.purgedAt = time.Now()
+	q.controlMu.Unlock()
+	depth, _ := q.lags.oldest()
+	return depth
+}
+
+func (q *requestQueue) purged(pushedAt time.Time) bool {
+	q.controlMu.Lock()
+	defer q.controlMu.Unlock()
+	return !pushedAt.After(q.purgedAt)
+}
@@ -0,0 +1,78 @@
+package connector
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/smancke/guble/server/monitor"
+)
+
+const QueuePath = "/queue"
+
+// QueueStatus is the state of the queue of a connector, on this node.
+type QueueStatus struct {
+	Connector string `json:"connector"`
+
+	// Depth is the number of requests waiting to be sent (a message is queued once for each of its subscribers)
+	Depth  int  `json:"depth"`
+	Paused bool `json:"paused"`
+
+	// Oldest is the oldest message waiting to be sent (nil if the queue is empty)
+	Oldest *QueuedMessage `json:"oldest,omitempty"`
+}
+
+// QueuedMessage describes a message waiting in the queue of a connector.
+type QueuedMessage struct {
+	ID         uint64 `json:"id"`
+	Topic      string `json:"topic"`
+	AgeSeconds int64  `json:"age_seconds"`
+}
+
+func (c *connector) queueStatus() *QueueStatus {
+	depth, oldest := c.requests.lags.oldest()
+	status := &QueueStatus{
+		Connector: c.config.Name,
+		Depth:     depth,
+		Paused:    c.requests.paused(),
+	}
+	if oldest != nil {
+		status.Oldest = &QueuedMessage{
+			ID:         oldest.ID,
+			Topic:      string(oldest.Path),
+			AgeSeconds: int64(monitor.MessageAge(oldest.Time, time.Now()) / time.Second),
+		}
+	}
+	return status
+}
+
+// GetQueue returns the status of the queue.
+func (c *connector) GetQueue(w http.ResponseWriter, req *http.Request) {
+	json.NewEncoder(w).Encode(c.queueStatus())
+}
+
+// DeleteQueue purges the queue: the requests waiting in it are dropped instead of being sent
+// (e.g. to get rid of a message poisoning the provider), and their number is returned.
+func (c *connector) DeleteQueue(w http.ResponseWriter, req *http.Request) {
+	purged := c.requests.purge()
+	c.logger.WithField("purged", purged).Warn("Purged the queue")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"connector": c.config.Name,
+		"purged":    purged,
+	})
+}
+
+// PauseQueue pauses the sending of the requests, which wait in the queue (and the messages in the store)
+// until the queue is resumed, and returns the status of the queue.
+func (c *connector) PauseQueue(w http.ResponseWriter, req *http.Request) {
+	c.requests.pause()
+	c.logger.Warn("Paused the queue")
+	json.NewEncoder(w).Encode(c.queueStatus())
+}
+
+// ResumeQueue resumes the sending of the requests, and returns the status of the queue.
+func (c *connector) ResumeQueue(w http.ResponseWriter, req *http.Request) {
+	c.requests.resume()
+	c.logger.Info("Resumed the queue")
+	json.NewEncoder(w).Encode(c.queueStatus())
+}
@@ -0,0 +1,116 @@
+package connector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestRequestQueue_PauseAndPurge(t *testing.T) {
+	a := assert.New(t)
+
+	var mu sync.Mutex
+	var sent []uint64
+	sender := senderFunc(func(r Request) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, r.Message().ID)
+		return "ok", nil
+	})
+
+	// a worker per request, so that all of them wait in the workers (the queue has no capacity)
+	q := newQueue("test", sender, 3, nil)
+	a.NoError(q.Start())
+	q.pause()
+	a.True(q.paused())
+
+	// the requests wait while the queue is paused
+	s := NewSubscriber("/news", map[string]string{"device_token": "device1"}, 0)
+	for id := uint64(1); id <= 3; id++ {
+		a.NoError(q.Push(NewRequest(s, &protocol.Message{ID: id, Path: "/news"})))
+	}
+	waitForDepth(t, q, 3)
+	_, oldest := q.lags.oldest()
+	a.Equal(uint64(1), oldest.ID)
+
+	// the purged requests are dropped, including the ones waiting in the workers
+	a.Equal(3, q.purge())
+	q.resume()
+	a.False(q.paused())
+	waitForDepth(t, q, 0)
+	_, oldest = q.lags.oldest()
+	a.Nil(oldest)
+
+	// the requests pushed after the purge are sent
+	a.NoError(q.Push(NewRequest(s, &protocol.Message{ID: 4, Path: "/news"})))
+	waitForDepth(t, q, 0)
+	mu.Lock()
+	a.Equal([]uint64{4}, sent)
+	mu.Unlock()
+	a.NoError(q.Stop())
+}
+
+func TestConnector_QueueEndpoints(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	conn, _ := getTestConnector(t, Config{
+		Name:       "test",
+		Schema:     "test",
+		Prefix:     "/connector/",
+		URLPattern: "/{device_token}/{user_id}/{topic:.*}",
+	}, false, false)
+
+	status := decodeQueueStatus(t, serveQueue(conn, http.MethodGet, ""))
+	a.Equal(&QueueStatus{Connector: "test"}, status)
+
+	m := &protocol.Message{ID: 42, Path: "/news", Time: time.Now().Add(-time.Minute).Unix()}
+	conn.(*connector).lags.add(m)
+	conn.(*connector).lags.add(m)
+	status = decodeQueueStatus(t, serveQueue(conn, http.MethodPost, "/pause"))
+	a.Equal(2, status.Depth)
+	a.True(status.Paused)
+	a.Equal(uint64(42), status.Oldest.ID)
+	a.Equal("/news", status.Oldest.Topic)
+	a.InDelta(60, status.Oldest.AgeSeconds, 2)
+
+	status = decodeQueueStatus(t, serveQueue(conn, http.MethodPost, "/resume"))
+	a.False(status.Paused)
+
+	recorder := serveQueue(conn, http.MethodDelete, "")
+	a.Equal(http.StatusOK, recorder.Code)
+	a.JSONEq(`{"connector": "test", "purged": 2}`, recorder.Body.String())
+}
+
+// waitForDepth waits until the queue has the number of requests waiting to be sent, or fails after a second.
+func waitForDepth(t *testing.T, q *requestQueue, depth int) {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(5 * time.Millisecond) {
+		if d, _ := q.lags.oldest(); d == depth {
+			return
+		}
+	}
+	assert.FailNow(t, "the queue has not the expected depth", "%d", depth)
+}
+
+func serveQueue(conn Connector, method, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, "/connector/queue"+path, nil)
+	conn.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func decodeQueueStatus(t *testing.T, recorder *httptest.ResponseRecorder) *QueueStatus {
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	status := new(QueueStatus)
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(status))
+	return status
+}
@@ -0,0 +1,139 @@
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+)
+
+const (
+	// QuietHoursHold holds the messages received during the quiet hours, and delivers them after the window
+	QuietHoursHold = "hold"
+	// QuietHoursDrop drops the messages received during the quiet hours
+	QuietHoursDrop = "drop"
+
+	quietHoursLayout = "15:04"
+)
+
+var ErrInvalidQuietHours = errors.New("Invalid quiet hours: start and end are required as HH:MM, the timezone has to be known and the policy has to be hold or drop.")
+
+// QuietHours is a daily window in which the messages are not pushed to the device of a subscription.
+// Start and End are given as `HH:MM` in the Timezone (an IANA name, UTC if empty);
+// a window with the end before the start spans midnight.
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone,omitempty"`
+	Policy   string `json:"policy,omitempty"`
+}
+
+// Summarizer is implemented by the senders which can build the message delivered after the quiet hours,
+// in place of the `count` messages held or dropped during the window (the last of which is given).
+type Summarizer interface {
+	Summary(last *protocol.Message, count int) *protocol.Message
+}
+
+func (qh *QuietHours) validate() error {
+	start, errStart := time.Parse(quietHoursLayout, qh.Start)
+	end, errEnd := time.Parse(quietHoursLayout, qh.End)
+	if errStart != nil || errEnd != nil || start.Equal(end) {
+		return ErrInvalidQuietHours
+	}
+	if _, err := time.LoadLocation(qh.Timezone); err != nil {
+		return ErrInvalidQuietHours
+	}
+	if qh.Policy == "" {
+		qh.Policy = QuietHoursHold
+	}
+	if qh.Policy != QuietHoursHold && qh.Policy != QuietHoursDrop {
+		return ErrInvalidQuietHours
+	}
+	return nil
+}
+
+// window returns true if t is in the quiet hours, and the end of the current window.
+func (qh *QuietHours) window(t time.Time) (bool, time.Time) {
+	loc, err := time.LoadLocation(qh.Timezone)
+	if err != nil {
+		return false, time.Time{}
+	}
+	start, errStart := time.Parse(quietHoursLayout, qh.Start)
+	end, errEnd := time.Parse(quietHoursLayout, qh.End)
+	if errStart != nil || errEnd != nil {
+		return false, time.Time{}
+	}
+
+	t = t.In(loc)
+	at := func(clock time.Time, days int) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day()+days, clock.Hour(), clock.Minute(), 0, 0, loc)
+	}
+	startAt, endAt := at(start, 0), at(end, 0)
+
+	if start.Before(end) {
+		return !t.Before(startAt) && t.Before(endAt), endAt
+	}
+	// the window spans midnight
+	if !t.Before(startAt) {
+		return true, at(end, 1)
+	}
+	return t.Before(endAt), endAt
+}
+
+// muted counts the messages received by a subscriber during its quiet hours, until the end of the window.
+type muted struct {
+	policy string
+	count  int
+	last   *protocol.Message
+	timer  *time.Timer
+}
+
+// mute returns the muted state of the subscriber if t is in its quiet hours, or else nil.
+func mute(qh *QuietHours, t time.Time) *muted {
+	if qh == nil {
+		return nil
+	}
+	active, end := qh.window(t)
+	if !active {
+		return nil
+	}
+	return &muted{
+		policy: qh.Policy,
+		timer:  time.NewTimer(end.Sub(t)),
+	}
+}
+
+func (m *muted) add(message *protocol.Message) {
+	m.count++
+	m.last = message
+}
+
+// done returns the channel notified at the end of the window (nil if not muted).
+func (m *muted) done() <-chan time.Time {
+	if m == nil {
+		return nil
+	}
+	return m.timer.C
+}
+
+// summary returns the message to deliver after the window, or nil if no message was received.
+// A single held message is delivered as it is; otherwise the messages are summarized by the sender,
+// keeping the ID of the last one so that the subscriber continues after it.
+func (m *muted) summary(sender Sender) *protocol.Message {
+	if m.count == 0 {
+		return nil
+	}
+	if m.policy == QuietHoursHold && m.count == 1 {
+		return m.last
+	}
+	if s, ok := sender.(Summarizer); ok {
+		return s.Summary(m.last, m.count)
+	}
+	return &protocol.Message{
+		ID:   m.last.ID,
+		Path: m.last.Path,
+		Time: m.last.Time,
+		Body: []byte(fmt.Sprintf(`{"quiet_hours_summary":{"count":%d}}`, m.count)),
+	}
+}
@@ -0,0 +1,164 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuietHours_window(t *testing.T) {
+	a := assert.New(t)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	a.NoError(err)
+
+	night := &QuietHours{Start: "22:00", End: "07:00", Timezone: "Europe/Berlin"}
+	day := &QuietHours{Start: "12:00", End: "14:30"}
+
+	cases := []struct {
+		qh     *QuietHours
+		t      time.Time
+		active bool
+		end    time.Time
+	}{
+		{night, time.Date(2017, 3, 1, 23, 0, 0, 0, berlin), true, time.Date(2017, 3, 2, 7, 0, 0, 0, berlin)},
+		{night, time.Date(2017, 3, 2, 6, 59, 0, 0, berlin), true, time.Date(2017, 3, 2, 7, 0, 0, 0, berlin)},
+		// 21:30 UTC is 22:30 in Berlin
+		{night, time.Date(2017, 3, 1, 21, 30, 0, 0, time.UTC), true, time.Date(2017, 3, 2, 7, 0, 0, 0, berlin)},
+		{night, time.Date(2017, 3, 2, 7, 0, 0, 0, berlin), false, time.Time{}},
+		{night, time.Date(2017, 3, 2, 12, 0, 0, 0, berlin), false, time.Time{}},
+		{day, time.Date(2017, 3, 2, 12, 0, 0, 0, time.UTC), true, time.Date(2017, 3, 2, 14, 30, 0, 0, time.UTC)},
+		{day, time.Date(2017, 3, 2, 11, 59, 0, 0, time.UTC), false, time.Time{}},
+		{day, time.Date(2017, 3, 2, 14, 30, 0, 0, time.UTC), false, time.Time{}},
+	}
+	for i, c := range cases {
+		active, end := c.qh.window(c.t)
+		a.Equal(c.active, active, "case %d", i)
+		if c.active {
+			a.True(c.end.Equal(end), "case %d: %v", i, end)
+		}
+	}
+}
+
+func TestQuietHours_validate(t *testing.T) {
+	a := assert.New(t)
+
+	qh := &QuietHours{Start: "22:00", End: "07:00", Timezone: "America/New_York"}
+	a.NoError(qh.validate())
+	a.Equal(QuietHoursHold, qh.Policy)
+
+	invalid := []*QuietHours{
+		{End: "07:00"},
+		{Start: "22:00", End: "7h"},
+		{Start: "22:00", End: "22:00"},
+		{Start: "22:00", End: "07:00", Timezone: "Mars/Olympus"},
+		{Start: "22:00", End: "07:00", Policy: "postpone"},
+	}
+	for _, qh := range invalid {
+		a.Equal(ErrInvalidQuietHours, qh.validate())
+	}
+}
+
+func Test_mutedSummary(t *testing.T) {
+	a := assert.New(t)
+	first := &protocol.Message{ID: 1, Path: "/topic", Body: []byte("first")}
+	last := &protocol.Message{ID: 2, Path: "/topic", Body: []byte("last")}
+
+	m := &muted{policy: QuietHoursHold}
+	a.Nil(m.summary(nil))
+
+	// a single held message is delivered as it is
+	m.add(first)
+	a.Equal(first, m.summary(nil))
+
+	m.add(last)
+	summary := m.summary(nil)
+	a.Equal(uint64(2), summary.ID)
+	a.Equal(`{"quiet_hours_summary":{"count":2}}`, string(summary.Body))
+
+	m = &muted{policy: QuietHoursDrop}
+	m.add(first)
+	a.Equal(`{"quiet_hours_summary":{"count":1}}`, string(m.summary(nil).Body))
+}
+
+func TestSubscriber_LoopHoldsMessagesInQuietHours(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	now := time.Now().UTC()
+	s := NewSubscriber("/topic", nil, 0)
+	s.SetQuietHours(&QuietHours{
+		Start:  now.Add(-time.Hour).Format(quietHoursLayout),
+		End:    now.Add(time.Hour).Format(quietHoursLayout),
+		Policy: QuietHoursHold,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := NewMockQueue(testutil.MockCtrl)
+	done := make(chan error)
+	go func() {
+		done <- s.Loop(ctx, queue)
+	}()
+
+	// no message is pushed to the queue during the quiet hours
+	a.NoError(s.Route().Deliver(&protocol.Message{ID: 1, Path: "/topic"}, true))
+	a.NoError(s.Route().Deliver(&protocol.Message{ID: 2, Path: "/topic"}, true))
+	time.Sleep(50 * time.Millisecond)
+
+	s.Cancel()
+	a.Equal(context.Canceled, <-done)
+
+	data, err := s.Encode()
+	a.NoError(err)
+	a.Contains(string(data), `"QuietHours":{"start"`)
+}
+
+func TestConnector_PutAndDeleteQuietHours(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	conn, mocks := getTestConnector(t, Config{
+		Name:       "name",
+		Schema:     "schema",
+		Prefix:     "/connector/",
+		URLPattern: "/{device_token}/{user_id}/{topic:.*}",
+	}, true, false)
+
+	subscriber := NewSubscriber("/topic1", map[string]string{
+		"device_token": "device1",
+		"user_id":      "user1",
+		"connector":    "name",
+	}, 0)
+	mocks.manager.EXPECT().Find(gomock.Eq(subscriber.Key())).Return(subscriber).Times(2)
+	mocks.manager.EXPECT().Update(subscriber).Return(nil).Times(2)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/connector/quiet-hours/device1/user1/topic1",
+		strings.NewReader(`{"start": "22:00", "end": "07:00", "timezone": "Europe/Berlin", "policy": "drop"}`))
+	conn.ServeHTTP(recorder, req)
+	a.Equal(http.StatusOK, recorder.Code)
+	a.Equal(&QuietHours{Start: "22:00", End: "07:00", Timezone: "Europe/Berlin", Policy: QuietHoursDrop}, subscriber.QuietHours())
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "/connector/quiet-hours/device1/user1/topic1", nil)
+	conn.ServeHTTP(recorder, req)
+	a.Equal(http.StatusOK, recorder.Code)
+	a.Nil(subscriber.QuietHours())
+
+	// invalid quiet hours are rejected
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPut, "/connector/quiet-hours/device1/user1/topic1",
+		strings.NewReader(`{"start": "22:00"}`))
+	conn.ServeHTTP(recorder, req)
+	a.Equal(http.StatusBadRequest, recorder.Code)
+}
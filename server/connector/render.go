@@ -0,0 +1,39 @@
+package connector
+
+import (
+	"github.com/smancke/guble/protocol"
+)
+
+// LocaleParam is the route param holding the locale of a device (e.g. `de-AT`),
+// set at subscription time with the query parameter `?locale=<locale>`.
+const LocaleParam = "locale"
+
+// Renderer renders the messages in the locale of a subscriber, before they are sent.
+type Renderer interface {
+	// Render returns the message rendered in the locale (the same message, if it is not rendered).
+	Render(m *protocol.Message, locale string) (*protocol.Message, error)
+}
+
+// renderingQueue pushes the requests with their messages rendered in the locale of the subscriber.
+// The messages which can not be rendered are dropped: they are not sent as they are.
+type renderingQueue struct {
+	Queue
+	renderer Renderer
+}
+
+func newRenderingQueue(q Queue, renderer Renderer) *renderingQueue {
+	return &renderingQueue{Queue: q, renderer: renderer}
+}
+
+func (q *renderingQueue) Push(request Request) error {
+	s := request.Subscriber()
+	m, err := q.renderer.Render(request.Message(), s.Route().Get(LocaleParam))
+	if err != nil {
+		logger.WithField("error", err.Error()).WithField("subscriber", s.Key()).Error("Error rendering message")
+		return err
+	}
+	if m != request.Message() {
+		request = NewRequest(s, m)
+	}
+	return q.Queue.Push(request)
+}
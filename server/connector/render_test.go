@@ -0,0 +1,54 @@
+package connector
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// rendererFunc is a Renderer rendering with a function.
+type rendererFunc func(m *protocol.Message, locale string) (*protocol.Message, error)
+
+func (f rendererFunc) Render(m *protocol.Message, locale string) (*protocol.Message, error) {
+	return f(m, locale)
+}
+
+func TestRenderingQueue_PushesRenderedMessages(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	renderer := rendererFunc(func(m *protocol.Message, locale string) (*protocol.Message, error) {
+		switch m.ID {
+		case 1:
+			return &protocol.Message{ID: m.ID, Body: []byte(locale)}, nil
+		case 2:
+			return m, nil
+		}
+		return nil, errors.New("Unknown template.")
+	})
+
+	var pushed []Request
+	mQueue := NewMockQueue(testutil.MockCtrl)
+	mQueue.EXPECT().Push(gomock.Any()).Do(func(r Request) error {
+		pushed = append(pushed, r)
+		return nil
+	}).Return(nil).Times(2)
+
+	q := newRenderingQueue(mQueue, renderer)
+	s := NewSubscriber("/news", map[string]string{"device_token": "device1", LocaleParam: "de-AT"}, 0)
+	notRendered := NewRequest(s, &protocol.Message{ID: 2, Body: []byte("as it is")})
+
+	a.NoError(q.Push(NewRequest(s, &protocol.Message{ID: 1})))
+	a.NoError(q.Push(notRendered))
+	a.Error(q.Push(NewRequest(s, &protocol.Message{ID: 3})))
+
+	a.Equal(2, len(pushed))
+	a.Equal("de-AT", string(pushed[0].Message().Body))
+	a.Equal(s, pushed[0].Subscriber())
+	a.True(pushed[1] == notRendered)
+}
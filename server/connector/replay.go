@@ -0,0 +1,73 @@
+package connector
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+)
+
+const ReplayPath = "/replay"
+
+// replayedSubscriber is the subscriber of a replayed request: the lastID of the subscription
+// is not moved back to the replayed message by the response handlers.
+type replayedSubscriber struct {
+	Subscriber
+}
+
+func (s *replayedSubscriber) SetLastID(ID uint64) {}
+
+// Replay pushes the stored messages with the IDs `?from=<id>&to=<id>` of the topic of a subscription
+// to this subscription again (e.g. for a user who missed them), and returns their number.
+func (c *connector) Replay(w http.ResponseWriter, req *http.Request) {
+	params := mux.Vars(req)
+	topic := params[TopicParam]
+	c.setParams(params, req)
+	s := c.manager.Find(GenerateKey("/"+topic, params))
+	if s == nil {
+		http.Error(w, `{"error":"subscription not found"}`, http.StatusNotFound)
+		return
+	}
+	query := req.URL.Query()
+	from, errFrom := strconv.ParseUint(query.Get("from"), 10, 64)
+	to, errTo := strconv.ParseUint(query.Get("to"), 10, 64)
+	if errFrom != nil || errTo != nil {
+		http.Error(w, `{"error":"the range of IDs (from, to) is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	messages, err := router.FetchReplay(c.router, protocol.Path("/"+topic), from, to)
+	if err == router.ErrInvalidReplayRange {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"unknown error: %s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	replayed := make([]*protocol.Message, 0, len(messages))
+	for _, m := range messages {
+		if m.Filters == nil || s.Route().Filter(m.Filters) {
+			replayed = append(replayed, m)
+		}
+	}
+
+	// the requests are pushed in the background, as they wait for the workers
+	rs := &replayedSubscriber{s}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for _, m := range replayed {
+			if err := c.queue.Push(NewRequest(rs, m)); err != nil {
+				c.logger.WithError(err).WithField("subscriber", s.Key()).Error("Error replaying a message")
+				return
+			}
+		}
+	}()
+	c.logger.WithField("subscriber", s.Key()).WithField("replayed", len(replayed)).Info("Replaying messages")
+	fmt.Fprintf(w, `{"replayed":%d}`, len(replayed))
+}
@@ -0,0 +1,76 @@
+package connector
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store/filestore"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestConnector_Replay(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_connector_replay_test")
+	defer os.RemoveAll(dir)
+	messageStore := filestore.New(dir)
+	defer messageStore.Stop()
+	for _, m := range []*protocol.Message{
+		{ID: 1, Path: "/news"},
+		{ID: 2, Path: "/news", Filters: map[string]string{"user_id": "user1"}},
+		{ID: 3, Path: "/news", Filters: map[string]string{"user_id": "user2"}},
+		{ID: 4, Path: "/news"},
+	} {
+		a.NoError(messageStore.Store("news", m.ID, m.Bytes()))
+	}
+
+	conn, mocks := getTestConnector(t, Config{
+		Name:       "test",
+		Schema:     "test",
+		Prefix:     "/connector/",
+		URLPattern: "/{device_token}/{user_id}/{topic:.*}",
+	}, true, true)
+	mocks.router.EXPECT().MessageStore().Return(messageStore, nil).AnyTimes()
+
+	s := NewSubscriber("/news", router.RouteParams{"device_token": "device1", "user_id": "user1", ConnectorParam: "test"}, 10)
+	mocks.manager.EXPECT().Find(s.Key()).Return(s).AnyTimes()
+
+	pushed := make(chan Request, 10)
+	mocks.queue.EXPECT().Push(gomock.Any()).Do(func(r Request) { pushed <- r }).Return(nil).Times(2)
+
+	// the messages filtered for other users are not replayed
+	recorder := serveReplay(conn, "/device1/user1/news?from=2&to=4")
+	a.Equal(http.StatusOK, recorder.Code)
+	a.JSONEq(`{"replayed": 2}`, recorder.Body.String())
+	for _, id := range []uint64{2, 4} {
+		r := <-pushed
+		a.Equal(id, r.Message().ID)
+
+		// the lastID of the subscription is not moved back by the responses
+		r.Subscriber().SetLastID(id)
+		a.Equal(uint64(10), s.(*subscriber).data.LastID)
+	}
+
+	a.Equal(http.StatusBadRequest, serveReplay(conn, "/device1/user1/news?from=4&to=2").Code)
+	a.Equal(http.StatusBadRequest, serveReplay(conn, "/device1/user1/news").Code)
+
+	mocks.manager.EXPECT().Find(gomock.Any()).Return(nil)
+	a.Equal(http.StatusNotFound, serveReplay(conn, "/device2/user1/news?from=1&to=4").Code)
+}
+
+func serveReplay(conn Connector, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/connector/replay"+path, nil)
+	conn.ServeHTTP(recorder, req)
+	return recorder
+}
@@ -0,0 +1,205 @@
+package connector
+
+import (
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+)
+
+// sharedRouteChannelSize is the channel size of the shared routes, which are read by a single goroutine
+// passing their messages to the routes of the subscribers.
+const sharedRouteChannelSize = 1000
+
+// routeGroup shares a router route among the subscribers of a topic: its messages are passed to the routes of
+// the subscribers (which apply their filters), which are not subscribed to the router themselves.
+type routeGroup struct {
+	topic protocol.Path
+	route *router.Route
+	doneC chan struct{}
+
+	mu      sync.RWMutex
+	members map[string]groupMember
+}
+
+type groupMember struct {
+	subscriber Subscriber
+	route      *router.Route
+}
+
+// join adds a subscriber with its route to the group of its topic, creating the group (and its shared route) if needed.
+func (c *connector) join(s Subscriber, route *router.Route) error {
+	c.groupsMu.Lock()
+	defer c.groupsMu.Unlock()
+
+	g, ok := c.groups[route.Path]
+	if !ok {
+		g = &routeGroup{
+			topic: route.Path,
+			route: router.NewRoute(router.RouteConfig{
+				Path:        route.Path,
+				RouteParams: router.RouteParams{ConnectorParam: c.config.Name},
+				ChannelSize: sharedRouteChannelSize,
+				Kind:        router.ConnectorRoute,
+				Shared:      true,
+			}),
+			doneC:   make(chan struct{}),
+			members: make(map[string]groupMember),
+		}
+		if _, err := c.router.Subscribe(g.route); err != nil {
+			return err
+		}
+		c.groups[route.Path] = g
+		c.wg.Add(1)
+		go c.fanOut(g)
+		c.logger.WithField("topic", route.Path).Info("Created shared route")
+	}
+
+	g.mu.Lock()
+	g.members[s.Key()] = groupMember{subscriber: s, route: route}
+	g.mu.Unlock()
+	return nil
+}
+
+// leave removes a subscriber with the given route from its group, removing the group (and its shared route)
+// if it has no subscribers left. The subscriber is not removed if it joined again meanwhile, with another route.
+func (c *connector) leave(s Subscriber, route *router.Route) {
+	c.groupsMu.Lock()
+	defer c.groupsMu.Unlock()
+
+	g, ok := c.groups[route.Path]
+	if !ok {
+		return
+	}
+
+	g.mu.Lock()
+	if member, ok := g.members[s.Key()]; ok && member.route == route {
+		delete(g.members, s.Key())
+	}
+	empty := len(g.members) == 0
+	g.mu.Unlock()
+
+	if empty {
+		c.removeGroup(g)
+		c.router.Unsubscribe(g.route)
+	}
+}
+
+// removeGroup removes a group and stops its goroutine. It is called with the groupsMu lock.
+func (c *connector) removeGroup(g *routeGroup) {
+	if c.groups[g.topic] == g {
+		delete(c.groups, g.topic)
+		close(g.doneC)
+	}
+}
+
+// fanOut passes the messages of a shared route to the routes of the subscribers, until the group is removed.
+func (c *connector) fanOut(g *routeGroup) {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case m, ok := <-g.route.MessagesChannel():
+			if !ok {
+				c.regroup(g)
+				return
+			}
+			if userID, ok := m.Path.TargetUser(); ok {
+				c.deliverToUser(m, userID)
+				continue
+			}
+			g.deliver(m)
+		case <-g.doneC:
+			return
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver passes a message to the routes of the subscribers.
+// A subscriber whose route failed leaves the group: it is restarted (and joins again) when its route channel is closed.
+func (g *routeGroup) deliver(m *protocol.Message) {
+	g.mu.RLock()
+	var failed map[string]*router.Route
+	for key, member := range g.members {
+		if err := member.route.Deliver(m, false); err != nil {
+			if failed == nil {
+				failed = make(map[string]*router.Route)
+			}
+			failed[key] = member.route
+		}
+	}
+	g.mu.RUnlock()
+
+	if len(failed) == 0 {
+		return
+	}
+	g.mu.Lock()
+	for key, route := range failed {
+		// unless it joined again meanwhile
+		if g.members[key].route == route {
+			delete(g.members, key)
+		}
+	}
+	g.mu.Unlock()
+}
+
+// deliverToUser passes a message published to a user to the routes of the subscriptions of the user, in all the groups,
+// once for the routes having the same params (i.e. once for each device).
+func (c *connector) deliverToUser(m *protocol.Message, userID string) {
+	c.groupsMu.Lock()
+	groups := make([]*routeGroup, 0, len(c.groups))
+	for _, g := range c.groups {
+		groups = append(groups, g)
+	}
+	c.groupsMu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, g := range groups {
+		g.mu.RLock()
+		var routes []*router.Route
+		for _, member := range g.members {
+			key := member.route.RouteParams.Key()
+			if member.route.Get(UserIDParam) != userID || seen[key] {
+				continue
+			}
+			seen[key] = true
+			routes = append(routes, member.route)
+		}
+		g.mu.RUnlock()
+
+		for _, route := range routes {
+			route.Deliver(m, false)
+		}
+	}
+}
+
+// regroup restarts the subscribers of a group whose shared route was closed by the router (e.g. when it was too slow),
+// so that they fetch the messages they missed and join a new group.
+func (c *connector) regroup(g *routeGroup) {
+	c.groupsMu.Lock()
+	c.removeGroup(g)
+	c.groupsMu.Unlock()
+
+	if c.ctx.Err() != nil {
+		return
+	}
+
+	g.mu.RLock()
+	members := make([]Subscriber, 0, len(g.members))
+	for _, member := range g.members {
+		members = append(members, member.subscriber)
+	}
+	g.mu.RUnlock()
+
+	c.logger.WithFields(log.Fields{
+		"topic":       g.topic,
+		"subscribers": len(members),
+	}).Warn("Shared route closed, restarting its subscribers")
+	for _, s := range members {
+		c.restart(s)
+	}
+}
@@ -0,0 +1,131 @@
+package connector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/dummystore"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnector_SharedRoutes(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	r := router.New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil)
+	a.NoError(r.(service.Startable).Start())
+	defer r.(service.Stopable).Stop()
+
+	sentC := make(chan Request, 4)
+	mSender := NewMockSender(testutil.MockCtrl)
+	mSender.EXPECT().Send(gomock.Any()).Do(func(request Request) {
+		sentC <- request
+	}).Return(nil, nil).Times(4)
+
+	conn, err := NewConnector(r, mSender, Config{
+		Name:         "test",
+		Schema:       "test",
+		Prefix:       "/connector/",
+		URLPattern:   "/{device_token}/{user_id}/{topic:.*}",
+		SharedRoutes: true,
+	})
+	a.NoError(err)
+	a.NoError(conn.Start())
+	defer conn.Stop()
+
+	createSubscriptions(t, conn, 3)
+	time.Sleep(50 * time.Millisecond)
+
+	// the subscribers of the topic share a single router route
+	subscribers, err := r.GetSubscribers("/topic")
+	a.NoError(err)
+	var params []router.RouteParams
+	a.NoError(json.Unmarshal(subscribers, &params))
+	a.Equal([]router.RouteParams{{"connector": "test"}}, params)
+
+	group := conn.(*connector).groups["/topic"]
+	if a.NotNil(group) {
+		group.mu.RLock()
+		a.Len(group.members, 3)
+		group.mu.RUnlock()
+	}
+
+	// a filtered message only reaches its subscriber
+	a.NoError(r.HandleMessage(&protocol.Message{
+		Path:    "/topic",
+		Body:    []byte("first"),
+		Filters: map[string]string{"user_id": "user2"},
+	}))
+	assertSent(a, sentC, "device2", "first")
+
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/topic", Body: []byte("second")}))
+	received := map[string]string{}
+	for i := 0; i < 3; i++ {
+		select {
+		case request := <-sentC:
+			received[request.Subscriber().Route().Get("device_token")] = string(request.Message().Body)
+		case <-time.After(time.Second):
+			a.Fail("The message was not sent")
+		}
+	}
+	a.Equal(map[string]string{"device1": "second", "device2": "second", "device3": "second"}, received)
+}
+
+func TestConnector_SharedRoutesDeliverUserMessages(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	r := router.New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil)
+	a.NoError(r.(service.Startable).Start())
+	defer r.(service.Stopable).Stop()
+
+	sentC := make(chan Request, 2)
+	mSender := NewMockSender(testutil.MockCtrl)
+	mSender.EXPECT().Send(gomock.Any()).Do(func(request Request) {
+		sentC <- request
+	}).Return(nil, nil).Times(1)
+
+	conn, err := NewConnector(r, mSender, Config{
+		Name:         "test",
+		Schema:       "test",
+		Prefix:       "/connector/",
+		URLPattern:   "/{device_token}/{user_id}/{topic:.*}",
+		SharedRoutes: true,
+	})
+	a.NoError(err)
+	a.NoError(conn.Start())
+	defer conn.Stop()
+
+	// the device of user1 is subscribed to two topics
+	createSubscriptions(t, conn, 2)
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/connector/device1/user1/other", strings.NewReader(""))
+	a.NoError(err)
+	conn.ServeHTTP(recorder, req)
+	a.Equal(http.StatusOK, recorder.Code)
+	time.Sleep(50 * time.Millisecond)
+
+	// a message published to the user is sent once to its device
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "user:user1", Body: []byte("everywhere")}))
+	assertSent(a, sentC, "device1", "everywhere")
+	select {
+	case request := <-sentC:
+		a.Fail("The message was sent twice", request.Subscriber().Route().Get("device_token"))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
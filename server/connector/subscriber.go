@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/router"
@@ -30,15 +32,31 @@ type Subscriber interface {
 	Route() *router.Route
 	Filter(map[string]string) bool
 	Loop(context.Context, Queue) error
+	LastID() uint64
 	SetLastID(ID uint64)
 	Cancel()
 	Encode() ([]byte, error)
+	QuietHours() *QuietHours
+	SetQuietHours(*QuietHours)
+	Failures() int
+	SetFailures(int)
+	LastSuccess() time.Time
+	SetLastSuccess(time.Time)
 }
 
 type SubscriberData struct {
 	Topic  protocol.Path
 	Params router.RouteParams
 	LastID uint64
+
+	// QuietHours is the window in which the messages are not pushed (nil if not set)
+	QuietHours *QuietHours `json:",omitempty"`
+
+	// Failures is the number of consecutive pushes rejected by the provider, used by the health checks
+	Failures int `json:",omitempty"`
+
+	// LastSuccess is the time of the last push accepted by the provider (nil if none)
+	LastSuccess *time.Time `json:",omitempty"`
 }
 
 func (sd *SubscriberData) newRoute() *router.Route {
@@ -50,6 +68,7 @@ func (sd *SubscriberData) newRoute() *router.Route {
 		Path:         sd.Topic,
 		RouteParams:  sd.Params,
 		FetchRequest: fr,
+		Kind:         router.ConnectorRoute,
 	})
 }
 
@@ -59,6 +78,9 @@ type subscriber struct {
 	key    string
 	route  *router.Route
 	cancel context.CancelFunc
+
+	// mu guards the quiet hours and the failures, which can be changed while the subscriber is looping
+	mu sync.RWMutex
 }
 
 func NewSubscriber(topic protocol.Path, params router.RouteParams, lastID uint64) Subscriber {
@@ -116,6 +138,14 @@ func (s *subscriber) Loop(ctx context.Context, q Queue) error {
 	s.cancel = cancel
 	defer func() { s.cancel = nil }()
 
+	// quiet is set while the subscriber is in its quiet hours
+	var quiet *muted
+	defer func() {
+		if quiet != nil {
+			quiet.timer.Stop()
+		}
+	}()
+
 	opened := true
 	for opened {
 		select {
@@ -124,7 +154,19 @@ func (s *subscriber) Loop(ctx context.Context, q Queue) error {
 				break
 			}
 
+			if quiet == nil {
+				quiet = mute(s.QuietHours(), time.Now())
+			}
+			if quiet != nil {
+				quiet.add(m)
+				continue
+			}
 			q.Push(NewRequest(s, m))
+		case <-quiet.done():
+			if summary := quiet.summary(q.Sender()); summary != nil {
+				q.Push(NewRequest(s, summary))
+			}
+			quiet = nil
 		case <-sCtx.Done():
 			// If the parent context is still running then only this subscriber context
 			// has been cancelled
@@ -139,6 +181,10 @@ func (s *subscriber) Loop(ctx context.Context, q Queue) error {
 	return ErrRouteChannelClosed
 }
 
+func (s *subscriber) LastID() uint64 {
+	return s.data.LastID
+}
+
 func (s *subscriber) SetLastID(ID uint64) {
 	s.data.LastID = ID
 }
@@ -150,9 +196,53 @@ func (s *subscriber) Cancel() {
 }
 
 func (s *subscriber) Encode() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return json.Marshal(s.data)
 }
 
+func (s *subscriber) QuietHours() *QuietHours {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.QuietHours
+}
+
+// SetQuietHours sets the quiet hours (or removes them, if nil), applied to the next messages.
+func (s *subscriber) SetQuietHours(qh *QuietHours) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.QuietHours = qh
+}
+
+func (s *subscriber) Failures() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.Failures
+}
+
+// SetFailures sets the number of consecutive failed pushes (reset to 0 by a successful push).
+func (s *subscriber) SetFailures(failures int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Failures = failures
+}
+
+// LastSuccess returns the time of the last successful push (the zero time if none).
+func (s *subscriber) LastSuccess() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.data.LastSuccess == nil {
+		return time.Time{}
+	}
+	return *s.data.LastSuccess
+}
+
+func (s *subscriber) SetLastSuccess(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.LastSuccess = &t
+}
+
 func GenerateKey(topic string, params map[string]string) string {
 	// compute the key from params
 	h := sha1.New()
@@ -0,0 +1,82 @@
+// Package daemon connects the guble server to the supervisor running it, if any: systemd services with Type=notify
+// are notified of the readiness (and of the liveness, if the watchdog is enabled), and Windows services are handled
+// as such by the service control manager.
+package daemon
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	mu        sync.Mutex
+	watchdogC chan struct{}
+)
+
+// Start connects the server to the Windows service control manager, if it is run as a Windows service.
+// It has to be called early, before the server is started.
+func Start() {
+	startService()
+}
+
+// Ready notifies the supervisor that the server is started, and starts pinging the systemd watchdog, if it is enabled.
+func Ready() {
+	if err := notify("READY=1\nSTATUS=Running"); err != nil {
+		logger.WithError(err).Error("Error notifying the readiness to systemd")
+	}
+	serviceRunning()
+
+	interval := watchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if watchdogC == nil {
+		watchdogC = make(chan struct{})
+		// the watchdog is pinged twice in its interval, so that a single late ping does not kill the server
+		go watchdog(interval/2, watchdogC)
+	}
+}
+
+// Stopping notifies the supervisor that the server is stopping (e.g. draining), and stops pinging the watchdog.
+func Stopping(status string) {
+	mu.Lock()
+	if watchdogC != nil {
+		close(watchdogC)
+		watchdogC = nil
+	}
+	mu.Unlock()
+
+	if err := notify("STOPPING=1\nSTATUS=" + status); err != nil {
+		logger.WithError(err).Error("Error notifying the stopping to systemd")
+	}
+	serviceStopping()
+}
+
+// Stopped notifies the supervisor of the exit code of the server, before it exits.
+func Stopped(code int) {
+	serviceStopped(code)
+}
+
+// StopC returns a channel closed when the supervisor requests the server to stop, in addition to the signals
+// (i.e. by a Windows service control), or nil.
+func StopC() <-chan struct{} {
+	return serviceStopC()
+}
+
+func watchdog(interval time.Duration, stopC chan struct{}) {
+	logger.WithField("interval", interval).Info("Pinging the systemd watchdog")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := notify("WATCHDOG=1"); err != nil {
+				logger.WithError(err).Error("Error pinging the systemd watchdog")
+			}
+		case <-stopC:
+			return
+		}
+	}
+}
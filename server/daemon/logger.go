@@ -0,0 +1,7 @@
+package daemon
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "daemon")
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+func startService() {}
+
+func serviceRunning() {}
+
+func serviceStopping() {}
+
+func serviceStopped(code int) {}
+
+func serviceStopC() <-chan struct{} {
+	return nil
+}
@@ -0,0 +1,117 @@
+//go:build windows
+// +build windows
+
+package daemon
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// serviceName is the name of the Windows service (ignored by the service control manager for a service
+// running in its own process).
+const serviceName = "guble"
+
+// serviceStopTimeout is the maximum duration waited for the service control manager to take the exit code.
+const serviceStopTimeout = 5 * time.Second
+
+// service is the Windows service, if the server is run as such.
+var service *windowsService
+
+// windowsService is the svc.Handler reporting the state of the server to the service control manager,
+// and passing its stop requests to the server.
+type windowsService struct {
+	runningC  chan struct{}
+	stoppingC chan struct{}
+	stopC     chan struct{}
+	exitC     chan uint32
+	doneC     chan struct{}
+
+	runningOnce  sync.Once
+	stoppingOnce sync.Once
+	stopOnce     sync.Once
+}
+
+// startService runs the handler of the Windows service, if the server is run as such.
+func startService() {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		logger.WithError(err).Error("Error detecting the Windows service")
+		return
+	}
+	if !isService {
+		return
+	}
+	service = &windowsService{
+		runningC:  make(chan struct{}),
+		stoppingC: make(chan struct{}),
+		stopC:     make(chan struct{}),
+		exitC:     make(chan uint32, 1),
+		doneC:     make(chan struct{}),
+	}
+	go func() {
+		defer close(service.doneC)
+		if err := svc.Run(serviceName, service); err != nil {
+			logger.WithError(err).Error("Error running the Windows service")
+		}
+	}()
+	logger.Info("Running as Windows service")
+}
+
+// Execute reports the state changes of the server, until it exits.
+// It is a part of the svc.Handler implementation.
+func (ws *windowsService) Execute(args []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.StartPending}
+	runningC, stoppingC := ws.runningC, ws.stoppingC
+	for {
+		select {
+		case <-runningC:
+			runningC = nil
+			s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+		case <-stoppingC:
+			runningC, stoppingC = nil, nil
+			s <- svc.Status{State: svc.StopPending}
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				s <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				ws.stopOnce.Do(func() { close(ws.stopC) })
+			}
+		case code := <-ws.exitC:
+			return false, code
+		}
+	}
+}
+
+func serviceRunning() {
+	if service != nil {
+		service.runningOnce.Do(func() { close(service.runningC) })
+	}
+}
+
+func serviceStopping() {
+	if service != nil {
+		service.stoppingOnce.Do(func() { close(service.stoppingC) })
+	}
+}
+
+func serviceStopped(code int) {
+	if service == nil {
+		return
+	}
+	service.exitC <- uint32(code)
+	select {
+	case <-service.doneC:
+	case <-time.After(serviceStopTimeout):
+	}
+}
+
+func serviceStopC() <-chan struct{} {
+	if service == nil {
+		return nil
+	}
+	return service.stopC
+}
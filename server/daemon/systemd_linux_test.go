@@ -0,0 +1,66 @@
+//go:build linux
+// +build linux
+
+package daemon
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotify(t *testing.T) {
+	a := assert.New(t)
+
+	// without systemd, nothing is notified
+	os.Unsetenv("NOTIFY_SOCKET")
+	a.NoError(notify("READY=1"))
+
+	dir, err := ioutil.TempDir("", "guble_daemon_test")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	a.NoError(err)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", name)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	Ready()
+	Stopping("Draining")
+
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	a.NoError(err)
+	a.Equal("READY=1\nSTATUS=Running", string(buf[:n]))
+	n, err = conn.Read(buf)
+	a.NoError(err)
+	a.Equal("STOPPING=1\nSTATUS=Draining", string(buf[:n]))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	a := assert.New(t)
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Unsetenv("WATCHDOG_USEC")
+	a.Equal(time.Duration(0), watchdogInterval())
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	a.Equal(30*time.Second, watchdogInterval())
+
+	// the watchdog of another process
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	a.Equal(time.Duration(0), watchdogInterval())
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	a.Equal(30*time.Second, watchdogInterval())
+}
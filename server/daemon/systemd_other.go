@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package daemon
+
+import "time"
+
+// notify does nothing, systemd being only supported on Linux.
+func notify(state string) error {
+	return nil
+}
+
+// watchdogInterval returns 0, systemd being only supported on Linux.
+func watchdogInterval() time.Duration {
+	return 0
+}
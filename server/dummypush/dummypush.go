@@ -0,0 +1,77 @@
+// Package dummypush is a push connector behaving like the FCM and APNS connectors (subscription endpoint, queue,
+// tracking of the last delivered message), which records its notifications instead of pushing them to a provider,
+// so that the push flows can be tested end-to-end in the environments without real API keys or certificates.
+package dummypush
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/router"
+)
+
+const (
+	// schema is the default database schema for the dummy push subscriptions
+	schema = "dummypush_registration"
+
+	deviceTokenKey = "device_token"
+	userIDKey      = "user_id"
+)
+
+var errNotRecorded = errors.New("The response is not a recorded notification.")
+
+// Config is used for configuring the dummy push module.
+type Config struct {
+	Enabled  *bool
+	Prefix   *string
+	Workers  *int
+	Capacity *int
+}
+
+// dummyPush is the connector recording the notifications.
+type dummyPush struct {
+	connector.Connector
+}
+
+// New creates a new connector.ResponsiveConnector (without starting it) sending its notifications to the recorder.
+func New(router router.Router, recorder *Recorder, config Config) (connector.ResponsiveConnector, error) {
+	baseConn, err := connector.NewConnector(
+		router,
+		recorder,
+		connector.Config{
+			Name:       "dummypush",
+			Schema:     schema,
+			Prefix:     *config.Prefix,
+			URLPattern: fmt.Sprintf("/{%s}/{%s}/{%s:.*}", deviceTokenKey, userIDKey, connector.TopicParam),
+			Workers:    *config.Workers,
+		},
+	)
+	if err != nil {
+		logger.WithError(err).Error("Base connector error")
+		return nil, err
+	}
+	d := &dummyPush{Connector: baseConn}
+	d.SetResponseHandler(d)
+	return d, nil
+}
+
+// HandleResponse stores the last delivered message of the subscriber, so that it is not delivered again after a restart.
+// It is a part of the connector.ResponseHandler implementation.
+func (d *dummyPush) HandleResponse(request connector.Request, response interface{}, metadata *connector.Metadata, errSend error) error {
+	if errSend != nil {
+		return errSend
+	}
+	n, ok := response.(*Notification)
+	if !ok {
+		return errNotRecorded
+	}
+	subscriber := request.Subscriber()
+	subscriber.SetLastID(n.MessageID)
+	if err := d.Manager().Update(subscriber); err != nil {
+		logger.WithError(err).Error("Manager could not update subscription")
+		return err
+	}
+	logger.WithField("device_token", n.DeviceToken).WithField("message_id", n.MessageID).Debug("Recorded notification")
+	return nil
+}
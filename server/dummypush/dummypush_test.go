@@ -0,0 +1,124 @@
+package dummypush
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/dummystore"
+)
+
+func TestDummyPush_RecordsTheNotifications(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	r := router.New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil)
+	a.NoError(r.(service.Startable).Start())
+	defer r.(service.Stopable).Stop()
+
+	subscribed := &subscribedRouter{Router: r, subscribedC: make(chan *router.Route, 1)}
+	recorder := NewRecorder("/admin/dummypush/", 10)
+	prefix, workers, capacity := "/dummypush/", 1, 10
+	conn, err := New(subscribed, recorder, Config{Prefix: &prefix, Workers: &workers, Capacity: &capacity})
+	a.NoError(err)
+	a.NoError(conn.Start())
+	defer conn.Stop()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/dummypush/device1/user1/topic", strings.NewReader(""))
+	conn.ServeHTTP(w, req)
+	a.Equal(http.StatusOK, w.Code)
+
+	// the route of the subscription is registered asynchronously
+	select {
+	case route := <-subscribed.subscribedC:
+		a.Equal(protocol.Path("/topic"), route.Path)
+	case <-time.After(time.Second):
+		a.FailNow("The route was not subscribed")
+	}
+
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/topic", Body: []byte(`{"alert":"hello"}`)}))
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/topic", Body: []byte("plain")}))
+
+	var notifications []*Notification
+	for i := 0; i < 100 && len(notifications) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		notifications = recorder.Notifications(nil)
+	}
+	if a.Len(notifications, 2) {
+		a.Equal("device1", notifications[0].DeviceToken)
+		a.Equal("user1", notifications[0].UserID)
+		a.Equal(protocol.Path("/topic"), notifications[0].Topic)
+		a.JSONEq(`{"alert":"hello"}`, string(notifications[0].Body))
+		a.Equal("plain", notifications[1].Text)
+	}
+
+	// the last delivered message is stored with the subscription
+	time.Sleep(20 * time.Millisecond)
+	subscribers := conn.Manager().List()
+	if a.Len(subscribers, 1) && len(notifications) == 2 {
+		encoded, err := subscribers[0].Encode()
+		a.NoError(err)
+		var data connector.SubscriberData
+		a.NoError(json.Unmarshal(encoded, &data))
+		a.Equal(notifications[1].MessageID, data.LastID)
+	}
+}
+
+// subscribedRouter notifies the routes subscribed to the router.
+type subscribedRouter struct {
+	router.Router
+	subscribedC chan *router.Route
+}
+
+func (r *subscribedRouter) Subscribe(route *router.Route) (*router.Route, error) {
+	route, err := r.Router.Subscribe(route)
+	r.subscribedC <- route
+	return route, err
+}
+
+func TestRecorder_ServeHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	recorder := NewRecorder("/admin/dummypush/", 2)
+	for _, token := range []string{"device1", "device2", "device3"} {
+		s := connector.NewSubscriber("/topic", router.RouteParams{deviceTokenKey: token, userIDKey: "user"}, 0)
+		_, err := recorder.Send(connector.NewRequest(s, &protocol.Message{Path: "/topic", Body: []byte(token)}))
+		a.NoError(err)
+	}
+
+	// only the last notifications are kept
+	a.Len(recorder.Notifications(nil), 2)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/admin/dummypush/?device_token=device3", nil)
+	recorder.ServeHTTP(w, req)
+	a.Equal(http.StatusOK, w.Code)
+	var notifications []*Notification
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &notifications))
+	if a.Len(notifications, 1) {
+		a.Equal("device3", notifications[0].Text)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodDelete, "/admin/dummypush/", nil)
+	recorder.ServeHTTP(w, req)
+	a.Equal(http.StatusOK, w.Code)
+	a.Empty(recorder.Notifications(nil))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/admin/dummypush/", nil)
+	recorder.ServeHTTP(w, req)
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+}
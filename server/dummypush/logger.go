@@ -0,0 +1,7 @@
+package dummypush
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "dummypush")
@@ -0,0 +1,123 @@
+package dummypush
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/connector"
+)
+
+// Notification is a notification recorded instead of being pushed to a device.
+type Notification struct {
+	MessageID   uint64          `json:"message_id"`
+	Topic       protocol.Path   `json:"topic"`
+	DeviceToken string          `json:"device_token"`
+	UserID      string          `json:"user_id"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	Text        string          `json:"text,omitempty"`
+	Time        time.Time       `json:"time"`
+}
+
+// Recorder is the connector.Sender of the dummy push connector, recording the last notifications instead of pushing them.
+// It is an admin endpoint: `GET <prefix>` returns the recorded notifications (filtered with `?device_token=`,
+// `?user_id=` or `?topic=`), oldest first, and `DELETE <prefix>` forgets them.
+type Recorder struct {
+	prefix   string
+	capacity int
+
+	mu            sync.RWMutex
+	notifications []*Notification
+}
+
+// NewRecorder returns a new Recorder keeping the last capacity notifications.
+func NewRecorder(prefix string, capacity int) *Recorder {
+	return &Recorder{
+		prefix:   prefix,
+		capacity: capacity,
+	}
+}
+
+// Send records the notification of a request.
+// It is a part of the connector.Sender implementation.
+func (r *Recorder) Send(request connector.Request) (interface{}, error) {
+	m := request.Message()
+	route := request.Subscriber().Route()
+	n := &Notification{
+		MessageID:   m.ID,
+		Topic:       m.Path,
+		DeviceToken: route.Get(deviceTokenKey),
+		UserID:      route.Get(userIDKey),
+		Time:        time.Now(),
+	}
+	if json.Valid(m.Body) {
+		n.Body = json.RawMessage(m.Body)
+	} else {
+		n.Text = string(m.Body)
+	}
+
+	r.mu.Lock()
+	r.notifications = append(r.notifications, n)
+	if len(r.notifications) > r.capacity {
+		r.notifications = r.notifications[len(r.notifications)-r.capacity:]
+	}
+	r.mu.Unlock()
+	return n, nil
+}
+
+// Notifications returns the recorded notifications matching the filter, oldest first.
+func (r *Recorder) Notifications(filter func(*Notification) bool) []*Notification {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	notifications := make([]*Notification, 0, len(r.notifications))
+	for _, n := range r.notifications {
+		if filter == nil || filter(n) {
+			notifications = append(notifications, n)
+		}
+	}
+	return notifications
+}
+
+// Clear forgets the recorded notifications.
+func (r *Recorder) Clear() {
+	r.mu.Lock()
+	r.notifications = nil
+	r.mu.Unlock()
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (r *Recorder) GetPrefix() string {
+	return r.prefix
+}
+
+// ServeHTTP is an http.Handler.
+// It is a part of the service.endpoint implementation.
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch req.Method {
+	case http.MethodGet:
+		q := req.URL.Query()
+		notifications := r.Notifications(func(n *Notification) bool {
+			return matches(q.Get(deviceTokenKey), n.DeviceToken) &&
+				matches(q.Get(userIDKey), n.UserID) &&
+				(q.Get("topic") == "" || n.Topic == protocol.Path(q.Get("topic")))
+		})
+		if err := json.NewEncoder(w).Encode(notifications); err != nil {
+			logger.WithError(err).Error("Error encoding the notifications")
+		}
+	case http.MethodDelete:
+		r.Clear()
+		w.Write([]byte(`{"cleared": true}`))
+	default:
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET and DELETE are accepted."}`, http.StatusMethodNotAllowed)
+	}
+}
+
+func matches(filter, value string) bool {
+	return filter == "" || filter == value
+}
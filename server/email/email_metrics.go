@@ -0,0 +1,11 @@
+package email
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns             = metrics.NS("email")
+	mTotalReceived = ns.NewInt("total_received")
+	mTotalRejected = ns.NewInt("total_rejected")
+)
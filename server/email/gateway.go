@@ -0,0 +1,187 @@
+// Package email publishes the inbound emails to topics, so that the emails can trigger notification flows
+// (e.g. a reply to a support ticket notifying the agent). The emails are posted by the inbound routes of Mailgun
+// (or by any service posting the same form fields), and published to a topic derived from their recipient.
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+)
+
+const (
+	// maxEmailSize is the maximum size of a posted email, including its attachments (which are not published)
+	maxEmailSize = 10 * 1024 * 1024
+
+	// maxSignatureAge is the maximum age of the timestamp of a signed email, so that it can not be replayed later
+	maxSignatureAge = 5 * time.Minute
+)
+
+var (
+	validLevel = regexp.MustCompile(`^[a-z0-9._-]+$`)
+
+	errInvalidRecipient = errors.New("Invalid recipient.")
+	errInvalidSignature = errors.New("Invalid signature.")
+)
+
+// Config is used for configuring the inbound email gateway.
+type Config struct {
+	Enabled    *bool
+	Prefix     *string
+	Topic      *string
+	SigningKey *string
+}
+
+// Email is the body of the message published for an inbound email.
+type Email struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Subject   string `json:"subject"`
+	Text      string `json:"text"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// Gateway is the endpoint receiving the inbound emails.
+type Gateway struct {
+	router     router.Router
+	prefix     string
+	topic      protocol.Path
+	signingKey []byte
+}
+
+// New returns a new Gateway, publishing the emails to the subtopics of the configured topic.
+func New(router router.Router, config Config) *Gateway {
+	return &Gateway{
+		router:     router,
+		prefix:     *config.Prefix,
+		topic:      protocol.Path(strings.TrimSuffix(*config.Topic, "/")),
+		signingKey: []byte(*config.SigningKey),
+	}
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (g *Gateway) GetPrefix() string {
+	return g.prefix
+}
+
+// ServeHTTP publishes a posted email to the topic of its recipient.
+// An email which can not be published to a topic is answered with `406 Not Acceptable`, so that it is not posted again.
+// It is a part of the service.endpoint implementation.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed. Only HTTP POST is accepted."}`, http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxEmailSize)
+	if err := r.ParseMultipartForm(maxEmailSize); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, `{"error": "Invalid form."}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := g.verify(r); err != nil {
+		mTotalRejected.Add(1)
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusUnauthorized)
+		return
+	}
+
+	email := Email{
+		From:      firstOf(r, "from", "sender"),
+		To:        r.FormValue("recipient"),
+		Subject:   r.FormValue("subject"),
+		Text:      firstOf(r, "stripped-text", "body-plain"),
+		MessageID: r.FormValue("Message-Id"),
+	}
+	topic, err := g.Topic(email.To)
+	if err != nil {
+		mTotalRejected.Add(1)
+		logger.WithField("recipient", email.To).Warn("Rejected an email to an invalid recipient")
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusNotAcceptable)
+		return
+	}
+
+	body, err := json.Marshal(&email)
+	if err != nil {
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	if err := g.router.HandleMessage(&protocol.Message{
+		Path:        topic,
+		ContentType: "application/json",
+		Body:        body,
+	}); err != nil {
+		logger.WithError(err).WithField("topic", topic).Error("Error publishing an email")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	mTotalReceived.Add(1)
+	fmt.Fprintf(w, `{"topic": %q}`, topic)
+}
+
+// Topic returns the topic of the emails to a recipient: the subtopic of the local part of its address,
+// a subaddress (after a `+`) giving a further level, e.g. `/email/support/1234` for `support+1234@in.example.com`.
+func (g *Gateway) Topic(recipient string) (protocol.Path, error) {
+	address, err := mail.ParseAddress(recipient)
+	if err != nil {
+		return "", errInvalidRecipient
+	}
+	at := strings.LastIndex(address.Address, "@")
+	if at <= 0 {
+		return "", errInvalidRecipient
+	}
+	levels := strings.Split(strings.ToLower(address.Address[:at]), "+")
+	for _, level := range levels {
+		if !validLevel.MatchString(level) || strings.Trim(level, ".") == "" {
+			return "", errInvalidRecipient
+		}
+	}
+	return protocol.Path(string(g.topic) + "/" + strings.Join(levels, "/")), nil
+}
+
+// verify checks the signature of a posted email, if a signing key is configured: the hex-encoded HMAC-SHA256
+// with the key of the concatenated `timestamp` and `token` fields, as signed by Mailgun.
+func (g *Gateway) verify(r *http.Request) error {
+	if len(g.signingKey) == 0 {
+		return nil
+	}
+	timestamp := r.FormValue("timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(seconds, 0)) > maxSignatureAge {
+		return errInvalidSignature
+	}
+	signature, err := hex.DecodeString(r.FormValue("signature"))
+	if err != nil || !hmac.Equal(signature, Signature(g.signingKey, timestamp, r.FormValue("token"))) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+// Signature returns the signature of a posted email with the timestamp and the token.
+func Signature(key []byte, timestamp, token string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp + token))
+	return mac.Sum(nil)
+}
+
+// firstOf returns the first non-empty field of the form.
+func firstOf(r *http.Request, fields ...string) string {
+	for _, field := range fields {
+		if value := r.FormValue(field); value != "" {
+			return value
+		}
+	}
+	return ""
+}
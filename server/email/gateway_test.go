@@ -0,0 +1,131 @@
+package email
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/dummystore"
+)
+
+func newGateway(r router.Router, signingKey string) *Gateway {
+	prefix, topic := "/email/", "/email"
+	return New(r, Config{Prefix: &prefix, Topic: &topic, SigningKey: &signingKey})
+}
+
+func post(g *Gateway, form url.Values) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/email/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	g.ServeHTTP(w, req)
+	return w
+}
+
+func TestGateway_PublishesTheEmails(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	r := router.New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil)
+	a.NoError(r.(service.Startable).Start())
+	defer r.(service.Stopable).Stop()
+
+	route, err := r.Subscribe(router.NewRoute(router.RouteConfig{Path: "/email/support", ChannelSize: 10}))
+	a.NoError(err)
+
+	g := newGateway(r, "")
+	w := post(g, url.Values{
+		"recipient":     {"Support <Support+1234@in.example.com>"},
+		"from":          {"Alice <alice@example.com>"},
+		"subject":       {"Re: my order"},
+		"body-plain":    {"Thanks!\n\n> quoted"},
+		"stripped-text": {"Thanks!"},
+		"Message-Id":    {"<abc@example.com>"},
+	})
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"topic": "/email/support/1234"}`, w.Body.String())
+
+	select {
+	case m := <-route.MessagesChannel():
+		a.Equal(protocol.Path("/email/support/1234"), m.Path)
+		var email Email
+		a.NoError(json.Unmarshal(m.Body, &email))
+		a.Equal(Email{
+			From:      "Alice <alice@example.com>",
+			To:        "Support <Support+1234@in.example.com>",
+			Subject:   "Re: my order",
+			Text:      "Thanks!",
+			MessageID: "<abc@example.com>",
+		}, email)
+	case <-time.After(time.Second):
+		a.Fail("The email was not published")
+	}
+}
+
+func TestGateway_RejectsInvalidEmails(t *testing.T) {
+	a := assert.New(t)
+
+	g := newGateway(nil, "key")
+
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/email/", nil))
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	// without a valid signature
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	a.Equal(http.StatusUnauthorized, post(g, url.Values{"recipient": {"support@example.com"}}).Code)
+	a.Equal(http.StatusUnauthorized, post(g, url.Values{
+		"recipient": {"support@example.com"},
+		"timestamp": {timestamp},
+		"token":     {"token"},
+		"signature": {hex.EncodeToString(Signature([]byte("other"), timestamp, "token"))},
+	}).Code)
+
+	// signed too long ago
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	a.Equal(http.StatusUnauthorized, post(g, url.Values{
+		"recipient": {"support@example.com"},
+		"timestamp": {old},
+		"token":     {"token"},
+		"signature": {hex.EncodeToString(Signature([]byte("key"), old, "token"))},
+	}).Code)
+
+	// signed, to an invalid recipient
+	a.Equal(http.StatusNotAcceptable, post(g, url.Values{
+		"recipient": {"sup/port@example.com"},
+		"timestamp": {timestamp},
+		"token":     {"token"},
+		"signature": {hex.EncodeToString(Signature([]byte("key"), timestamp, "token"))},
+	}).Code)
+}
+
+func TestGateway_Topic(t *testing.T) {
+	a := assert.New(t)
+
+	g := newGateway(nil, "")
+	for recipient, expected := range map[string]protocol.Path{
+		"orders@in.example.com":              "/email/orders",
+		"Orders <orders+shop.1@example.com>": "/email/orders/shop.1",
+		"a_b-c+x+y@example.com":              "/email/a_b-c/x/y",
+	} {
+		topic, err := g.Topic(recipient)
+		a.NoError(err, recipient)
+		a.Equal(expected, topic)
+	}
+	for _, recipient := range []string{"", "example.com", "a+@example.com", "..@example.com", "a%b@example.com"} {
+		_, err := g.Topic(recipient)
+		a.Error(err, recipient)
+	}
+}
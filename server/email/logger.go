@@ -0,0 +1,7 @@
+package email
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "email")
@@ -0,0 +1,111 @@
+// Package external is a connector delivering its messages through an external process (a sidecar), so that
+// third-party connectors can be added without forking guble. The process receives the messages on its standard input
+// and reports the results of their delivery on its standard output, as JSON lines.
+package external
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/router"
+)
+
+const (
+	deviceIDKey = "device_id"
+	userIDKey   = "user_id"
+)
+
+var (
+	validName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+	errNotDelivered = errors.New("The response is not a delivery.")
+)
+
+// Config is used for configuring an external connector.
+type Config struct {
+	// Name is the name of the connector, giving its prefix (/<name>/) and the schema of its subscriptions
+	Name string
+	// Command and Args start the process delivering the messages
+	Command string
+	Args    []string
+	Workers int
+}
+
+// ParseConfig parses the specification of an external connector: `<name>=<command> [<arguments>]`.
+func ParseConfig(spec string) (Config, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || !validName.MatchString(parts[0]) {
+		return Config{}, fmt.Errorf("Invalid external connector %q: expected <name>=<command>, with a name of [a-z0-9_-]", spec)
+	}
+	fields := strings.Fields(parts[1])
+	if len(fields) == 0 {
+		return Config{}, fmt.Errorf("Invalid external connector %q: missing command", spec)
+	}
+	return Config{Name: parts[0], Command: fields[0], Args: fields[1:]}, nil
+}
+
+// external is a connector delivering its messages through its process.
+type external struct {
+	connector.Connector
+	process *process
+}
+
+// New creates a new connector.ResponsiveConnector (without starting it or its process).
+func New(router router.Router, config Config) (connector.ResponsiveConnector, error) {
+	p := newProcess(config.Name, config.Command, config.Args)
+	baseConn, err := connector.NewConnector(
+		router,
+		p,
+		connector.Config{
+			Name:       config.Name,
+			Schema:     "external_" + config.Name,
+			Prefix:     "/" + config.Name + "/",
+			URLPattern: fmt.Sprintf("/{%s}/{%s}/{%s:.*}", deviceIDKey, userIDKey, connector.TopicParam),
+			Workers:    config.Workers,
+		},
+	)
+	if err != nil {
+		logger.WithError(err).Error("Base connector error")
+		return nil, err
+	}
+	e := &external{Connector: baseConn, process: p}
+	e.SetResponseHandler(e)
+	return e, nil
+}
+
+// Start starts the process, then the connector.
+func (e *external) Start() error {
+	if err := e.process.start(); err != nil {
+		return err
+	}
+	return e.Connector.Start()
+}
+
+// Stop stops the connector, then the process.
+func (e *external) Stop() error {
+	err := e.Connector.Stop()
+	e.process.stop()
+	return err
+}
+
+// HandleResponse stores the last delivered message of the subscriber, so that it is not delivered again after a restart.
+// It is a part of the connector.ResponseHandler implementation.
+func (e *external) HandleResponse(request connector.Request, response interface{}, metadata *connector.Metadata, errSend error) error {
+	if errSend != nil {
+		return errSend
+	}
+	d, ok := response.(*Delivery)
+	if !ok {
+		return errNotDelivered
+	}
+	subscriber := request.Subscriber()
+	subscriber.SetLastID(d.MessageID)
+	if err := e.Manager().Update(subscriber); err != nil {
+		logger.WithError(err).Error("Manager could not update subscription")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+package external
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "external")
@@ -0,0 +1,246 @@
+package external
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/server/connector"
+)
+
+const maxLineSize = 1024 * 1024
+
+var (
+	// sendTimeout is the time given to the process for reporting the delivery of a message
+	sendTimeout = 30 * time.Second
+
+	// stopTimeout is the time given to the process for exiting after its standard input is closed
+	stopTimeout = 5 * time.Second
+
+	errProcessExited = errors.New("The external connector process exited.")
+	errStopped       = errors.New("The external connector is stopped.")
+	errSendTimeout   = errors.New("The external connector process did not report the delivery in time.")
+)
+
+// Request is a message to deliver, written as a JSON line to the standard input of the process.
+type Request struct {
+	// ID identifies the request in the response of the process
+	ID         uint64            `json:"id"`
+	Subscriber map[string]string `json:"subscriber"`
+	Message    Message           `json:"message"`
+}
+
+// Message is the message of a Request.
+type Message struct {
+	ID     uint64          `json:"id"`
+	Topic  string          `json:"topic"`
+	UserID string          `json:"user_id,omitempty"`
+	Time   int64           `json:"time"`
+	Header json.RawMessage `json:"header,omitempty"`
+	Body   string          `json:"body"`
+}
+
+// Response is the result of the delivery of a Request, written as a JSON line to the standard output of the process.
+type Response struct {
+	ID    uint64 `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// Delivery is the response of a message delivered by the process.
+type Delivery struct {
+	MessageID uint64
+}
+
+// process runs the command of an external connector, and sends it the requests of the connector.
+// The requests are sent concurrently, the process responding in any order. If it exits, its pending requests fail,
+// and it is started again by the next request.
+type process struct {
+	name    string
+	command string
+	args    []string
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	exitedC chan struct{}
+	pending map[uint64]chan Response
+	seq     uint64
+	stopped bool
+}
+
+func newProcess(name, command string, args []string) *process {
+	return &process{
+		name:    name,
+		command: command,
+		args:    args,
+		pending: make(map[uint64]chan Response),
+	}
+}
+
+func (p *process) start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = false
+	return p.startLocked()
+}
+
+func (p *process) startLocked() error {
+	cmd := exec.Command(p.command, p.args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	p.cmd = cmd
+	p.stdin = stdin
+	p.exitedC = make(chan struct{})
+	logger.WithField("name", p.name).WithField("pid", cmd.Process.Pid).Info("Started the external connector process")
+
+	go p.logErrors(stderr)
+	go p.readResponses(cmd, stdout, p.exitedC)
+	return nil
+}
+
+// Send writes a request to the process, and waits for its response.
+// It is a part of the connector.Sender implementation.
+func (p *process) Send(request connector.Request) (interface{}, error) {
+	m := request.Message()
+	r := Request{
+		Subscriber: map[string]string(request.Subscriber().Route().RouteParams),
+		Message: Message{
+			ID:     m.ID,
+			Topic:  string(m.Path),
+			UserID: m.UserID,
+			Time:   m.Time,
+			Body:   string(m.Body),
+		},
+	}
+	if m.HeaderJSON != "" && json.Valid([]byte(m.HeaderJSON)) {
+		r.Message.Header = json.RawMessage(m.HeaderJSON)
+	}
+
+	responseC := make(chan Response, 1)
+	if err := p.write(&r, responseC); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(sendTimeout)
+	defer timer.Stop()
+	select {
+	case response := <-responseC:
+		if response.Error != "" {
+			return nil, fmt.Errorf("%s: %s", p.name, response.Error)
+		}
+		return &Delivery{MessageID: m.ID}, nil
+	case <-timer.C:
+		p.mu.Lock()
+		delete(p.pending, r.ID)
+		p.mu.Unlock()
+		return nil, errSendTimeout
+	}
+}
+
+// write gives an ID to a request and writes it to the process, starting it again if it exited.
+func (p *process) write(r *Request, responseC chan Response) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return errStopped
+	}
+	if p.cmd == nil {
+		if err := p.startLocked(); err != nil {
+			return err
+		}
+	}
+
+	p.seq++
+	r.ID = p.seq
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	p.pending[r.ID] = responseC
+	if _, err := p.stdin.Write(append(line, '\n')); err != nil {
+		delete(p.pending, r.ID)
+		return err
+	}
+	return nil
+}
+
+// readResponses passes the responses of a process to their pending requests, until it exits.
+func (p *process) readResponses(cmd *exec.Cmd, stdout io.Reader, exitedC chan struct{}) {
+	defer close(exitedC)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 4096), maxLineSize)
+	for scanner.Scan() {
+		var response Response
+		if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+			logger.WithField("name", p.name).WithField("line", scanner.Text()).Error("Invalid response of the external connector process")
+			continue
+		}
+		p.mu.Lock()
+		responseC, ok := p.pending[response.ID]
+		delete(p.pending, response.ID)
+		p.mu.Unlock()
+		if ok {
+			responseC <- response
+		}
+	}
+
+	err := cmd.Wait()
+	logger.WithField("name", p.name).WithField("error", err).Warn("The external connector process exited")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == cmd {
+		p.cmd = nil
+		p.stdin = nil
+	}
+	for id, responseC := range p.pending {
+		responseC <- Response{ID: id, Error: errProcessExited.Error()}
+		delete(p.pending, id)
+	}
+}
+
+// logErrors logs the lines written by a process to its standard error.
+func (p *process) logErrors(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		logger.WithField("name", p.name).Warn(scanner.Text())
+	}
+}
+
+// stop closes the standard input of the process, which should exit, and kills it if it did not exit in time.
+func (p *process) stop() {
+	p.mu.Lock()
+	p.stopped = true
+	cmd, stdin, exitedC := p.cmd, p.stdin, p.exitedC
+	p.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+
+	stdin.Close()
+	select {
+	case <-exitedC:
+	case <-time.After(stopTimeout):
+		cmd.Process.Kill()
+		<-exitedC
+	}
+}
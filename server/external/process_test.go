@@ -0,0 +1,112 @@
+package external
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/router"
+)
+
+// TestHelperProcess is not a real test: it is the external connector process started by the tests.
+// It fails the messages of the topic /fail, and exits on a message of the topic /exit.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GUBLE_EXTERNAL_HELPER") != "1" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var r Request
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		response := Response{ID: r.ID}
+		switch r.Message.Topic {
+		case "/exit":
+			os.Exit(1)
+		case "/fail":
+			response.Error = "failed for " + r.Subscriber["device_id"]
+		}
+		line, _ := json.Marshal(response)
+		fmt.Println(string(line))
+	}
+	os.Exit(0)
+}
+
+func helperProcess() *process {
+	os.Setenv("GUBLE_EXTERNAL_HELPER", "1")
+	return newProcess("test", os.Args[0], []string{"-test.run=TestHelperProcess"})
+}
+
+func testRequest(topic string) connector.Request {
+	route := router.NewRoute(router.RouteConfig{Path: protocol.Path(topic), RouteParams: router.RouteParams{"device_id": "device1"}})
+	subscriber := &testSubscriber{route: route}
+	return connector.NewRequest(subscriber, &protocol.Message{ID: 7, Path: protocol.Path(topic), Body: []byte("hello")})
+}
+
+type testSubscriber struct {
+	connector.Subscriber
+	route *router.Route
+}
+
+func (s *testSubscriber) Route() *router.Route {
+	return s.route
+}
+
+func TestProcess_Send(t *testing.T) {
+	a := assert.New(t)
+
+	p := helperProcess()
+	a.NoError(p.start())
+	defer p.stop()
+
+	response, err := p.Send(testRequest("/topic"))
+	a.NoError(err)
+	a.Equal(&Delivery{MessageID: 7}, response)
+
+	_, err = p.Send(testRequest("/fail"))
+	a.EqualError(err, "test: failed for device1")
+}
+
+func TestProcess_RestartsAfterExiting(t *testing.T) {
+	a := assert.New(t)
+
+	p := helperProcess()
+	a.NoError(p.start())
+	defer p.stop()
+
+	// the pending request fails when the process exits
+	_, err := p.Send(testRequest("/exit"))
+	a.EqualError(err, "test: "+errProcessExited.Error())
+
+	// and the process is started again by the next request
+	_, err = p.Send(testRequest("/topic"))
+	a.NoError(err)
+
+	// no process is started once stopped
+	p.stop()
+	_, err = p.Send(testRequest("/topic"))
+	a.Equal(errStopped, err)
+}
+
+func TestParseConfig(t *testing.T) {
+	a := assert.New(t)
+
+	config, err := ParseConfig("webhook=/usr/bin/guble-webhook --url http://example.com")
+	a.NoError(err)
+	a.Equal("webhook", config.Name)
+	a.Equal("/usr/bin/guble-webhook", config.Command)
+	a.Equal([]string{"--url", "http://example.com"}, config.Args)
+
+	for _, spec := range []string{"webhook", "Web Hook=cmd", "webhook=", "=cmd"} {
+		_, err := ParseConfig(spec)
+		a.Error(err, spec)
+	}
+}
@@ -5,9 +5,11 @@ import (
 
 	"github.com/Bogh/gcm"
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/connector"
 	"github.com/smancke/guble/server/metrics"
 	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/throttle"
 	"time"
 )
 
@@ -27,6 +29,17 @@ type Config struct {
 	Endpoint             *string
 	Prefix               *string
 	IntervalMetrics      *bool
+	Collapse             *connector.CollapseWindows
+	HealthCheck          *time.Duration
+	MaxFailures          *int
+	Templates            connector.Renderer
+	BreakerFailures      *int
+	BreakerProbe         *time.Duration
+	Proxy                *string
+	Authenticator        auth.Authenticator
+	Limiter              *throttle.Limiter
+	Lazy                 *bool
+	SharedRoutes         *bool
 	AfterMessageDelivery protocol.MessageDeliveryCallback
 }
 
@@ -34,23 +47,73 @@ type Config struct {
 type fcm struct {
 	Config
 	connector.Connector
+	router router.Router
 }
 
 // New creates a new *fcm and returns it as an connector.ResponsiveConnector
 func New(router router.Router, sender connector.Sender, config Config) (connector.ResponsiveConnector, error) {
+	var collapse connector.CollapseWindows
+	if config.Collapse != nil {
+		collapse = *config.Collapse
+	}
+	healthCheck := connector.HealthCheckConfig{TokenParam: deviceTokenKey}
+	if config.HealthCheck != nil {
+		healthCheck.Interval = *config.HealthCheck
+	}
+	if config.MaxFailures != nil {
+		healthCheck.MaxFailures = *config.MaxFailures
+	}
+	var lazy bool
+	if config.Lazy != nil {
+		lazy = *config.Lazy
+	}
+	var sharedRoutes bool
+	if config.SharedRoutes != nil {
+		sharedRoutes = *config.SharedRoutes
+	}
+	var breaker connector.BreakerConfig
+	if config.BreakerFailures != nil {
+		breaker.Failures = *config.BreakerFailures
+	}
+	if config.BreakerProbe != nil {
+		breaker.Probe = *config.BreakerProbe
+	}
 	baseConn, err := connector.NewConnector(router, sender, connector.Config{
-		Name:       "fcm",
-		Schema:     schema,
-		Prefix:     *config.Prefix,
-		URLPattern: fmt.Sprintf("/{%s}/{%s}/{%s:.*}", deviceTokenKey, userIDKEy, connector.TopicParam),
-		Workers:    *config.Workers,
+		Name:        "fcm",
+		Schema:      schema,
+		Prefix:      *config.Prefix,
+		URLPattern:  fmt.Sprintf("/{%s}/{%s}/{%s:.*}", deviceTokenKey, userIDKEy, connector.TopicParam),
+		Workers:     *config.Workers,
+		Collapse:    collapse,
+		HealthCheck: healthCheck,
+		Devices:     connector.DevicesConfig{UserParam: userIDKEy, DeviceParam: deviceTokenKey},
+
+		// the devices are subscribed with their locale (e.g. ?locale=de-AT), in which the templated messages are rendered
+		QueryParams: []string{connector.LocaleParam},
+		Renderer:    config.Templates,
+
+		// the sending is paused while FCM is failing (the invalid tokens are not failures of FCM)
+		Breaker: breaker,
+
+		// the subscriptions can only be changed by their users (or admins), if the requests are authenticated
+		Authenticator: config.Authenticator,
+		Limiter:       config.Limiter,
+
+		// the delivered messages are not pushed again after a restart
+		ProviderMessageID: providerMessageID,
+
+		// the routes of the idle devices are only created by their first message
+		Lazy: lazy,
+
+		// the devices subscribed to a topic can share a single router route
+		SharedRoutes: sharedRoutes,
 	})
 	if err != nil {
 		logger.WithError(err).Error("Base connector error")
 		return nil, err
 	}
 
-	f := &fcm{config, baseConn}
+	f := &fcm{config, baseConn, router}
 	f.SetResponseHandler(f)
 	return f, nil
 }
@@ -71,6 +134,8 @@ func (f *fcm) startMetrics() {
 	mTotalResponseNotRegisteredErrors.Set(0)
 	mTotalReplacedCanonicalErrors.Set(0)
 	mTotalResponseOtherErrors.Set(0)
+	mTotalDryRunReceipts.Set(0)
+	mErrors.Reset()
 
 	if *f.IntervalMetrics {
 		f.startIntervalMetric(mMinute, time.Minute)
@@ -84,9 +149,13 @@ func (f *fcm) startIntervalMetric(m metrics.Map, td time.Duration) {
 }
 
 func (f *fcm) HandleResponse(request connector.Request, responseIface interface{}, metadata *connector.Metadata, err error) error {
+	if isDryRun(request.Message()) {
+		f.publishReceipt(request, responseIface, err)
+	}
 	if err != nil && !isValidResponseError(err) {
 		logger.WithField("error", err.Error()).Error("Error sending message to FCM")
 		mTotalSendErrors.Add(1)
+		mErrors.Add(metrics.SendError)
 		if *f.IntervalMetrics && metadata != nil {
 			addToLatenciesAndCountsMaps(currentTotalErrorsLatenciesKey, currentTotalErrorsKey, metadata.Latency)
 		}
@@ -103,6 +172,12 @@ func (f *fcm) HandleResponse(request connector.Request, responseIface interface{
 
 	logger.WithField("messageID", message.ID).Debug("Delivered message to FCM")
 	subscriber.SetLastID(message.ID)
+	if response.Ok() {
+		subscriber.SetFailures(0)
+		subscriber.SetLastSuccess(time.Now())
+	} else {
+		subscriber.SetFailures(subscriber.Failures() + 1)
+	}
 	if err := f.Manager().Update(request.Subscriber()); err != nil {
 		logger.WithField("error", err.Error()).Error("Manager could not update subscription")
 		mTotalResponseInternalErrors.Add(1)
@@ -118,7 +193,9 @@ func (f *fcm) HandleResponse(request connector.Request, responseIface interface{
 
 	logger.WithField("success", response.Success).Debug("Handling FCM Error")
 
-	switch errText := response.Error.Error(); errText {
+	errText := response.Error.Error()
+	mErrors.Add(errText)
+	switch errText {
 	case "NotRegistered":
 		logger.Debug("Removing not registered FCM subscription")
 		f.Manager().Remove(subscriber)
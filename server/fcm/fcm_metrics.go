@@ -14,6 +14,8 @@ var (
 	mTotalResponseNotRegisteredErrors = ns.NewInt("total_response_not_registered_errors")
 	mTotalReplacedCanonicalErrors     = ns.NewInt("total_replaced_canonical_errors")
 	mTotalResponseOtherErrors         = ns.NewInt("total_response_other_errors")
+	mTotalDryRunReceipts              = ns.NewInt("total_dry_run_receipts")
+	mErrors                           = ns.NewErrors("errors")
 	mMinute                           = ns.NewMap("minute")
 	mHour                             = ns.NewMap("hour")
 	mDay                              = ns.NewMap("day")
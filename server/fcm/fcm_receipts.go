@@ -0,0 +1,125 @@
+package fcm
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/Bogh/gcm"
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/connector"
+)
+
+const (
+	// dryRunHeader is the message header requesting FCM to validate the message without delivering it,
+	// e.g. `X-Guble-Dry-Run: true` on the REST API
+	dryRunHeader = "Dry-Run"
+
+	// ReceiptsPrefix is the prefix of the topics receiving the results of the dry-run messages,
+	// followed by the user id of the message publisher.
+	ReceiptsPrefix = "/receipts/fcm/"
+)
+
+// The statuses of the dry-run receipts.
+const (
+	StatusValid               = "valid"
+	StatusNotRegistered       = "not_registered"
+	StatusInvalidRegistration = "invalid_registration"
+	StatusError               = "error"
+)
+
+// Receipt is the result of a dry-run message for the device token of one subscription.
+type Receipt struct {
+	MessageID   uint64 `json:"message_id"`
+	UserID      string `json:"user_id"`
+	DeviceToken string `json:"device_token"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+
+	// CanonicalID is the token replacing the device token, as reported by FCM
+	CanonicalID string `json:"canonical_id,omitempty"`
+}
+
+// isDryRun returns true if the header of the message requests a dry-run.
+func isDryRun(message *protocol.Message) bool {
+	if message.HeaderJSON == "" {
+		return false
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal([]byte(message.HeaderJSON), &header); err != nil {
+		return false
+	}
+	for key, value := range header {
+		if !strings.EqualFold(key, dryRunHeader) {
+			continue
+		}
+		switch v := value.(type) {
+		case bool:
+			return v
+		case string:
+			dryRun, _ := strconv.ParseBool(v)
+			return dryRun
+		}
+	}
+	return false
+}
+
+// newReceipt returns the receipt of a dry-run message, from the response or the error of FCM.
+func newReceipt(request connector.Request, response *gcm.Response, err error) *Receipt {
+	route := request.Subscriber().Route()
+	receipt := &Receipt{
+		MessageID:   request.Message().ID,
+		UserID:      route.Get(userIDKEy),
+		DeviceToken: route.Get(deviceTokenKey),
+		Status:      StatusValid,
+	}
+	if err == nil && response != nil {
+		err = response.Error
+		if response.CanonicalIDs != 0 && len(response.Results) > 0 {
+			receipt.CanonicalID = response.Results[0].RegistrationID
+		}
+	}
+	if err != nil {
+		receipt.Error = err.Error()
+		switch receipt.Error {
+		case "NotRegistered":
+			receipt.Status = StatusNotRegistered
+		case "InvalidRegistration":
+			receipt.Status = StatusInvalidRegistration
+		default:
+			receipt.Status = StatusError
+		}
+	}
+	return receipt
+}
+
+// publishReceipt publishes the result of a dry-run message on the receipts topic of its publisher.
+func (f *fcm) publishReceipt(request connector.Request, responseIface interface{}, err error) {
+	message := request.Message()
+	if message.UserID == "" {
+		logger.WithField("messageID", message.ID).Warn("Dry-run message without publisher, the receipt is not published")
+		return
+	}
+
+	response, _ := responseIface.(*gcm.Response)
+	receipt := newReceipt(request, response, err)
+	body, err := json.Marshal(receipt)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Error encoding dry-run receipt")
+		return
+	}
+
+	path := protocol.Path(ReceiptsPrefix + message.UserID)
+	if err := f.router.HandleMessage(&protocol.Message{Path: path, Body: body}); err != nil {
+		logger.WithField("error", err.Error()).WithField("path", path).Error("Error publishing dry-run receipt")
+		return
+	}
+
+	mTotalDryRunReceipts.Add(1)
+	logger.WithFields(log.Fields{
+		"messageID": receipt.MessageID,
+		"status":    receipt.Status,
+	}).Debug("Published dry-run receipt")
+}
@@ -0,0 +1,121 @@
+package fcm
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Bogh/gcm"
+	"github.com/golang/mock/gomock"
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnector_DryRunPublishesReceipt(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	var subRoute *router.Route
+
+	fcm, mocks := testFCM(t, false)
+	fcm.Start()
+	defer fcm.Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	mocks.router.EXPECT().Subscribe(gomock.Any()).Do(func(route *router.Route) (*router.Route, error) {
+		subRoute = route
+		return route, nil
+	})
+
+	postSubscription(t, fcm, "user01", "device01", "topic")
+	time.Sleep(100 * time.Millisecond)
+	if !a.NotNil(subRoute) {
+		return
+	}
+
+	response := new(gcm.Response)
+	a.NoError(json.Unmarshal([]byte(SuccessFCMResponse), response))
+	mocks.gcmSender.EXPECT().Send(gomock.Any()).Do(func(m *gcm.Message) (*gcm.Response, error) {
+		a.True(m.DryRun)
+		a.Equal("device01", m.To)
+		return nil, nil
+	}).Return(response, nil)
+
+	doneC := make(chan bool)
+	mocks.router.EXPECT().HandleMessage(gomock.Any()).Do(func(m *protocol.Message) error {
+		a.Equal(protocol.Path("/receipts/fcm/admin"), m.Path)
+
+		receipt := new(Receipt)
+		a.NoError(json.Unmarshal(m.Body, receipt))
+		a.Equal(&Receipt{
+			MessageID:   7,
+			UserID:      "user01",
+			DeviceToken: "device01",
+			Status:      StatusValid,
+		}, receipt)
+
+		doneC <- true
+		return nil
+	}).Return(nil)
+
+	subRoute.Deliver(&protocol.Message{
+		Path:       "/topic",
+		ID:         7,
+		UserID:     "admin",
+		HeaderJSON: `{"Dry-Run":"true"}`,
+		Body:       []byte(fullFCMMessage),
+	}, true)
+
+	select {
+	case <-doneC:
+	case <-time.After(200 * time.Millisecond):
+		a.Fail("Receipt not published")
+	}
+}
+
+func Test_newReceipt(t *testing.T) {
+	a := assert.New(t)
+
+	request := connector.NewRequest(
+		connector.NewSubscriber("/topic", router.RouteParams{deviceTokenKey: "device01", userIDKEy: "user01"}, 0),
+		&protocol.Message{ID: 3, Path: "/topic"})
+
+	canonical := new(gcm.Response)
+	a.NoError(json.Unmarshal([]byte(ErrorFCMResponse), canonical))
+
+	testCases := []struct {
+		response          *gcm.Response
+		err               error
+		expectedStatus    string
+		expectedCanonical string
+	}{
+		{&gcm.Response{Success: 1}, nil, StatusValid, ""},
+		{nil, errors.New("NotRegistered"), StatusNotRegistered, ""},
+		{canonical, nil, StatusInvalidRegistration, "fcmCanonicalID"},
+		{nil, errors.New("timeout"), StatusError, ""},
+	}
+	for _, c := range testCases {
+		receipt := newReceipt(request, c.response, c.err)
+		a.Equal(uint64(3), receipt.MessageID)
+		a.Equal("device01", receipt.DeviceToken)
+		a.Equal(c.expectedStatus, receipt.Status)
+		a.Equal(c.expectedCanonical, receipt.CanonicalID)
+	}
+}
+
+func Test_isDryRun(t *testing.T) {
+	a := assert.New(t)
+
+	a.False(isDryRun(&protocol.Message{}))
+	a.False(isDryRun(&protocol.Message{HeaderJSON: `{"Dry-Run":"false"}`}))
+	a.False(isDryRun(&protocol.Message{HeaderJSON: `not json`}))
+	a.True(isDryRun(&protocol.Message{HeaderJSON: `{"Dry-Run":"true"}`}))
+	a.True(isDryRun(&protocol.Message{HeaderJSON: `{"dry-run":"1"}`}))
+	a.True(isDryRun(&protocol.Message{HeaderJSON: `{"dry-run":true}`}))
+}
@@ -2,6 +2,8 @@ package fcm
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -33,6 +35,7 @@ func (s *sender) Send(request connector.Request) (interface{}, error) {
 	deviceToken := request.Subscriber().Route().Get(deviceTokenKey)
 	fcmMessage := fcmMessage(request.Message())
 	fcmMessage.To = deviceToken
+	fcmMessage.DryRun = isDryRun(request.Message())
 	logger.WithFields(log.Fields{"deviceToken": fcmMessage.To}).Debug("sending message")
 	return s.gcmSender.Send(fcmMessage)
 }
@@ -40,11 +43,24 @@ func (s *sender) Send(request connector.Request) (interface{}, error) {
 func fcmMessage(message *protocol.Message) *gcm.Message {
 	m := &gcm.Message{}
 
+	if !message.IsJSON() {
+		// the bodies of the other content types are passed in the data, without decoding them
+		var body interface{} = message.Body
+		if strings.HasPrefix(message.MediaType(), "text/") {
+			body = string(message.Body)
+		}
+		m.Data = map[string]interface{}{
+			"message":      body,
+			"content_type": message.ContentType,
+		}
+		return m
+	}
+
 	err := json.Unmarshal(message.Body, m)
 	if err != nil {
 		logger.WithFields(log.Fields{
 			"error":     err.Error(),
-			"body":      string(message.Body),
+			"body":      message.RedactedBody(),
 			"messageID": message.ID,
 		}).Debug("Could not decode gcm.Message from guble message body")
 	} else if m.Notification != nil && m.Data != nil {
@@ -61,8 +77,57 @@ func fcmMessage(message *protocol.Message) *gcm.Message {
 	return m
 }
 
+// Summary returns the notification delivered after the quiet hours of a subscription,
+// in place of the messages received during the window.
+// It is a part of the connector.Summarizer implementation.
+func (s *sender) Summary(last *protocol.Message, count int) *protocol.Message {
+	body, _ := json.Marshal(&gcm.Message{
+		Notification: &gcm.Notification{
+			Body: fmt.Sprintf("%d new notifications", count),
+		},
+		Data: map[string]interface{}{
+			"quiet_hours_summary": count,
+		},
+	})
+	return &protocol.Message{
+		ID:   last.ID,
+		Path: last.Path,
+		Time: last.Time,
+		Body: body,
+	}
+}
+
+// CheckToken validates the device token of a subscriber with a dry-run message, which is not delivered.
+// It is a part of the connector.TokenChecker implementation.
+func (s *sender) CheckToken(subscriber connector.Subscriber) (bool, error) {
+	response, err := s.gcmSender.Send(&gcm.Message{
+		To:     subscriber.Route().Get(deviceTokenKey),
+		DryRun: true,
+		Data:   map[string]interface{}{"health_check": true},
+	})
+	if err == nil && response != nil {
+		err = response.Error
+	}
+	if err == nil {
+		return true, nil
+	}
+	if isValidResponseError(err) {
+		return false, nil
+	}
+	return false, err
+}
+
 // isValidResponseError returns True if the error is accepted as a valid response
 // cases are InvalidRegistration and NotRegistered
 func isValidResponseError(err error) bool {
 	return err.Error() == "InvalidRegistration" || err.Error() == "NotRegistered"
 }
+
+// providerMessageID returns the FCM message ID of a delivered message, or "" if it was not delivered.
+func providerMessageID(response interface{}) string {
+	r, ok := response.(*gcm.Response)
+	if !ok || !r.Ok() || len(r.Results) == 0 {
+		return ""
+	}
+	return r.Results[0].MessageID
+}
@@ -2,6 +2,7 @@ package fcm
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -178,6 +179,52 @@ func TestFCMFormatMessage(t *testing.T) {
 	}
 }
 
+func Test_fcmMessageWithContentType(t *testing.T) {
+	a := assert.New(t)
+
+	// a JSON looking body is not decoded, when its content type is not JSON
+	m := fcmMessage(&protocol.Message{ContentType: "text/plain; charset=utf-8", Body: []byte(`{"field1": "value1"}`)})
+	a.Nil(m.Notification)
+	a.Equal(map[string]interface{}{
+		"message":      `{"field1": "value1"}`,
+		"content_type": "text/plain; charset=utf-8",
+	}, m.Data)
+
+	m = fcmMessage(&protocol.Message{ContentType: protocol.ContentTypeProtobuf, Body: []byte{8, 42}})
+	a.Equal([]byte{8, 42}, m.Data["message"])
+
+	m = fcmMessage(&protocol.Message{ContentType: protocol.ContentTypeJSON, Body: []byte(`{"field1": "value1"}`)})
+	a.Equal(map[string]interface{}{"field1": "value1"}, m.Data)
+}
+
+func TestSender_CheckToken(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	gcmSender := NewMockSender(testutil.MockCtrl)
+	s := NewSenderWithMock(gcmSender)
+	subscriber := connector.NewSubscriber("/topic", router.RouteParams{deviceTokenKey: "device01", userIDKEy: "user01"}, 0)
+
+	gcmSender.EXPECT().Send(gomock.Any()).Do(func(m *gcm.Message) (*gcm.Response, error) {
+		a.True(m.DryRun)
+		a.Equal("device01", m.To)
+		return nil, nil
+	}).Return(&gcm.Response{Success: 1}, nil)
+	valid, err := s.CheckToken(subscriber)
+	a.NoError(err)
+	a.True(valid)
+
+	gcmSender.EXPECT().Send(gomock.Any()).Return(&gcm.Response{Failure: 1, Error: errors.New("NotRegistered")}, nil)
+	valid, err = s.CheckToken(subscriber)
+	a.NoError(err)
+	a.False(valid)
+
+	gcmSender.EXPECT().Send(gomock.Any()).Return(nil, errors.New("timeout"))
+	_, err = s.CheckToken(subscriber)
+	a.Error(err)
+}
+
 func testFCM(t *testing.T, mockStore bool) (connector.ResponsiveConnector, *mocks) {
 	mcks := new(mocks)
 
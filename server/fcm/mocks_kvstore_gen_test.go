@@ -4,7 +4,10 @@
 package fcm
 
 import (
+	context "context"
+
 	gomock "github.com/golang/mock/gomock"
+	kvstore "github.com/smancke/guble/server/kvstore"
 )
 
 // Mock of KVStore interface
@@ -28,6 +31,16 @@ func (_m *MockKVStore) EXPECT() *_MockKVStoreRecorder {
 	return _m.recorder
 }
 
+func (_m *MockKVStore) Batch(_param0 string, _param1 []kvstore.Operation) error {
+	ret := _m.ctrl.Call(_m, "Batch", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKVStoreRecorder) Batch(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Batch", arg0, arg1)
+}
+
 func (_m *MockKVStore) Delete(_param0 string, _param1 string) error {
 	ret := _m.ctrl.Call(_m, "Delete", _param0, _param1)
 	ret0, _ := ret[0].(error)
@@ -50,24 +63,24 @@ func (_mr *_MockKVStoreRecorder) Get(arg0, arg1 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Get", arg0, arg1)
 }
 
-func (_m *MockKVStore) Iterate(_param0 string, _param1 string) chan [2]string {
-	ret := _m.ctrl.Call(_m, "Iterate", _param0, _param1)
+func (_m *MockKVStore) Iterate(_param0 context.Context, _param1 string, _param2 string, _param3 int) chan [2]string {
+	ret := _m.ctrl.Call(_m, "Iterate", _param0, _param1, _param2, _param3)
 	ret0, _ := ret[0].(chan [2]string)
 	return ret0
 }
 
-func (_mr *_MockKVStoreRecorder) Iterate(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Iterate", arg0, arg1)
+func (_mr *_MockKVStoreRecorder) Iterate(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Iterate", arg0, arg1, arg2, arg3)
 }
 
-func (_m *MockKVStore) IterateKeys(_param0 string, _param1 string) chan string {
-	ret := _m.ctrl.Call(_m, "IterateKeys", _param0, _param1)
+func (_m *MockKVStore) IterateKeys(_param0 context.Context, _param1 string, _param2 string, _param3 int) chan string {
+	ret := _m.ctrl.Call(_m, "IterateKeys", _param0, _param1, _param2, _param3)
 	ret0, _ := ret[0].(chan string)
 	return ret0
 }
 
-func (_mr *_MockKVStoreRecorder) IterateKeys(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "IterateKeys", arg0, arg1)
+func (_mr *_MockKVStoreRecorder) IterateKeys(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "IterateKeys", arg0, arg1, arg2, arg3)
 }
 
 func (_m *MockKVStore) Put(_param0 string, _param1 string, _param2 []byte) error {
@@ -79,3 +92,15 @@ func (_m *MockKVStore) Put(_param0 string, _param1 string, _param2 []byte) error
 func (_mr *_MockKVStoreRecorder) Put(arg0, arg1, arg2 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Put", arg0, arg1, arg2)
 }
+
+func (_m *MockKVStore) Take(_param0 string, _param1 string) ([]byte, bool, error) {
+	ret := _m.ctrl.Call(_m, "Take", _param0, _param1)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockKVStoreRecorder) Take(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Take", arg0, arg1)
+}
@@ -0,0 +1,356 @@
+// Package forward forwards the messages of a topic to a fixed HTTP endpoint, with the URL and the body of the requests
+// built by templates from the fields of the messages: a lighter alternative to the subscriptions of a connector,
+// for the fixed integrations (e.g. posting the orders to a fulfillment service).
+package forward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/jpillora/backoff"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/cloudevents"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store"
+)
+
+const (
+	routeChannelSize = 1000
+
+	// CloudEventsBinary and CloudEventsStructured are the modes of the messages forwarded as CloudEvents
+	CloudEventsBinary     = "binary"
+	CloudEventsStructured = "structured"
+)
+
+var (
+	// retryBackoffMin and retryBackoffMax bound the delays between the attempts of a request
+	retryBackoffMin = 100 * time.Millisecond
+	retryBackoffMax = 10 * time.Second
+
+	errStopped = errors.New("The forwarder is stopped.")
+)
+
+// Config is used for configuring the forwarder.
+type Config struct {
+	Topic       *string
+	URL         *string
+	Method      *string
+	Body        *string
+	ContentType *string
+	Retries     *int
+	Timeout     *time.Duration
+	Connections *int
+	// CloudEvents forwards the messages as CloudEvents, in the binary or structured mode (optional)
+	CloudEvents *string
+
+	// Render renders the bodies of the requests instead of the Body template (optional)
+	Render func(Fields) ([]byte, error)
+}
+
+// Forwarder sends each message of its topic (including its subtopics) published on this node to the HTTP endpoint,
+// in order.
+// A request failing with a network error, a 5xx status or 429 Too Many Requests is retried with an exponential
+// backoff; a message whose requests all failed, or which is rejected with another status, is dropped.
+type Forwarder struct {
+	router      router.Router
+	topic       protocol.Path
+	url         *template.Template
+	body        func(Fields) ([]byte, error)
+	method      string
+	contentType string
+	retries     int
+	cloudEvents string
+	client      *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a new Forwarder (without starting it), after parsing its templates.
+func New(router router.Router, config Config) (*Forwarder, error) {
+	topic := strings.TrimSuffix(*config.Topic, "/")
+	if !strings.HasPrefix(topic, "/") || len(topic) < 2 {
+		return nil, fmt.Errorf("Invalid forward topic %q", *config.Topic)
+	}
+	urlTemplate, err := ParseTemplate("forward URL", *config.URL)
+	if err != nil {
+		return nil, err
+	}
+	body := config.Render
+	if body == nil {
+		bodyTemplate, err := ParseTemplate("forward body", *config.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = func(fields Fields) ([]byte, error) {
+			var buff bytes.Buffer
+			err := bodyTemplate.Execute(&buff, fields)
+			return buff.Bytes(), err
+		}
+	}
+	var cloudEvents string
+	if config.CloudEvents != nil {
+		cloudEvents = *config.CloudEvents
+	}
+	if cloudEvents != "" && cloudEvents != CloudEventsBinary && cloudEvents != CloudEventsStructured {
+		return nil, fmt.Errorf("Invalid CloudEvents mode %q", cloudEvents)
+	}
+	return &Forwarder{
+		router:      router,
+		topic:       protocol.Path(topic),
+		url:         urlTemplate,
+		body:        body,
+		method:      strings.ToUpper(*config.Method),
+		contentType: *config.ContentType,
+		retries:     *config.Retries,
+		cloudEvents: cloudEvents,
+		client: &http.Client{
+			Timeout: *config.Timeout,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost: *config.Connections,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}, nil
+}
+
+// Start begins forwarding the messages of the topic.
+func (f *Forwarder) Start() error {
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	f.wg.Add(1)
+	go f.run()
+	logger.WithField("topic", f.topic).Info("Started forwarder")
+	return nil
+}
+
+// Stop stops forwarding the messages, after the pending request.
+func (f *Forwarder) Stop() error {
+	f.cancel()
+	f.wg.Wait()
+	logger.Info("Stopped forwarder")
+	return nil
+}
+
+// run forwards the messages of the route until the forwarder is stopped. If the router closes the route
+// (e.g. when the endpoint is too slow), the messages stored meanwhile are fetched by the next route.
+func (f *Forwarder) run() {
+	defer f.wg.Done()
+
+	var lastID uint64
+	for f.ctx.Err() == nil {
+		var fr *store.FetchRequest
+		if lastID > 0 {
+			fr = store.NewFetchRequest(f.topic.Partition(), lastID+1, 0, store.DirectionForward, -1)
+		}
+		route := router.NewRoute(router.RouteConfig{
+			Path:         f.topic,
+			ChannelSize:  routeChannelSize,
+			Kind:         router.ConnectorRoute,
+			FetchRequest: fr,
+		})
+		go func() {
+			if err := route.Provide(f.router, true); err != nil {
+				logger.WithError(err).WithField("topic", f.topic).Error("Error subscribing the forwarder")
+				route.Close()
+			}
+		}()
+
+		if !f.forwardRouted(route, &lastID) {
+			f.router.Unsubscribe(route)
+			return
+		}
+		logger.WithField("topic", f.topic).Warn("Forward route closed, subscribing again")
+		select {
+		case <-time.After(time.Second):
+		case <-f.ctx.Done():
+		}
+	}
+}
+
+// forwardRouted forwards the messages of the route until it is closed,
+// and returns false if the forwarder was stopped meanwhile.
+func (f *Forwarder) forwardRouted(route *router.Route, lastID *uint64) bool {
+	for {
+		select {
+		case m, ok := <-route.MessagesChannel():
+			if !ok {
+				return f.ctx.Err() == nil
+			}
+			if m.ID <= *lastID {
+				continue
+			}
+			if !f.local(m) {
+				continue
+			}
+			if err := f.forward(m); err != nil {
+				logger.WithError(err).WithField("id", m.ID).WithField("path", m.Path).Error("Message not forwarded")
+				mTotalFailed.Add(1)
+			}
+			*lastID = m.ID
+		case <-f.ctx.Done():
+			return false
+		}
+	}
+}
+
+// local returns true if the message was published on this node: in a cluster, each message is forwarded
+// by the node which received it.
+func (f *Forwarder) local(m *protocol.Message) bool {
+	cl := f.router.Cluster()
+	return cl == nil || m.NodeID == 0 || m.NodeID == cl.Config.ID
+}
+
+// forward sends a message to the endpoint, retrying the failed requests which can succeed later.
+func (f *Forwarder) forward(m *protocol.Message) error {
+	target, body, err := f.render(m)
+	if err != nil {
+		mTotalRenderFails.Add(1)
+		return err
+	}
+
+	// the rendered body is the data of the event
+	contentType, header := f.contentType, http.Header{}
+	if f.cloudEvents != "" {
+		event := cloudevents.FromMessage(m)
+		event.Data, event.DataContentType = body, f.contentType
+		if f.cloudEvents == CloudEventsStructured {
+			if body, err = event.MarshalStructured(); err != nil {
+				mTotalRenderFails.Add(1)
+				return err
+			}
+			contentType = cloudevents.ContentType
+		} else {
+			event.SetBinaryHeader(header)
+		}
+	}
+
+	b := &backoff.Backoff{Min: retryBackoffMin, Max: retryBackoffMax, Factor: 2, Jitter: true}
+	for attempt := 0; ; attempt++ {
+		retry, err := f.send(target, contentType, header, body)
+		if err == nil {
+			mTotalForwarded.Add(1)
+			return nil
+		}
+		if !retry || attempt >= f.retries {
+			return err
+		}
+		logger.WithError(err).WithField("id", m.ID).WithField("attempt", attempt+1).Warn("Forwarding failed, retrying")
+		mTotalRetries.Add(1)
+		select {
+		case <-time.After(b.Duration()):
+		case <-f.ctx.Done():
+			return errStopped
+		}
+	}
+}
+
+// send does a request, and returns its error and whether it can be retried.
+func (f *Forwarder) send(target, contentType string, header http.Header, body []byte) (bool, error) {
+	req, err := http.NewRequest(f.method, target, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.New("Invalid forward URL")
+	}
+	req = req.WithContext(f.ctx)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		// the URL is not in the logged error, as it can hold a secret (e.g. of a chat webhook)
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return true, err
+	}
+	// the body is read, so that the connection is reused
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("The endpoint responded %s", resp.Status)
+	default:
+		return false, fmt.Errorf("The endpoint rejected the message: %s", resp.Status)
+	}
+}
+
+// Fields are the fields of a message available to the templates, e.g. `{{.Topic}}` or `{{.Header.orderId}}`.
+type Fields struct {
+	ID            uint64
+	Topic         string
+	UserID        string
+	ApplicationID string
+	Time          int64
+	Header        map[string]interface{}
+	Body          string
+}
+
+// NewFields returns the fields of a message, with its decoded header.
+func NewFields(m *protocol.Message) (Fields, error) {
+	fields := Fields{
+		ID:            m.ID,
+		Topic:         string(m.Path),
+		UserID:        m.UserID,
+		ApplicationID: m.ApplicationID,
+		Time:          m.Time,
+		Header:        make(map[string]interface{}),
+		Body:          string(m.Body),
+	}
+	if m.HeaderJSON != "" {
+		if err := json.Unmarshal([]byte(m.HeaderJSON), &fields.Header); err != nil {
+			return fields, err
+		}
+	}
+	return fields, nil
+}
+
+// render returns the URL and the body of the request of a message.
+func (f *Forwarder) render(m *protocol.Message) (string, []byte, error) {
+	fields, err := NewFields(m)
+	if err != nil {
+		return "", nil, err
+	}
+	var target bytes.Buffer
+	if err := f.url.Execute(&target, fields); err != nil {
+		return "", nil, err
+	}
+	body, err := f.body(fields)
+	if err != nil {
+		return "", nil, err
+	}
+	return target.String(), body, nil
+}
+
+// ParseTemplate parses a template of the requests, with the function `json` encoding a value as JSON
+// (e.g. `{"text": {{json .Body}}}`).
+func ParseTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s template: %s", name, err.Error())
+	}
+	return t, nil
+}
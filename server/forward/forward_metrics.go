@@ -0,0 +1,13 @@
+package forward
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns                = metrics.NS("forward")
+	mTotalForwarded   = ns.NewInt("total_forwarded_messages")
+	mTotalRetries     = ns.NewInt("total_retries")
+	mTotalFailed      = ns.NewInt("total_failed_messages")
+	mTotalRenderFails = ns.NewInt("total_render_errors")
+)
@@ -0,0 +1,204 @@
+package forward
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/dummystore"
+)
+
+type forwarded struct {
+	method      string
+	path        string
+	contentType string
+	ceType      string
+	body        string
+}
+
+// endpoint records the requests, responding with the given statuses (then 200 OK).
+type endpoint struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []forwarded
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requests = append(e.requests, forwarded{req.Method, req.URL.Path, req.Header.Get("Content-Type"), req.Header.Get("Ce-Type"), string(body)})
+	if len(e.statuses) > 0 {
+		w.WriteHeader(e.statuses[0])
+		e.statuses = e.statuses[1:]
+	}
+}
+
+func (e *endpoint) waitRequests(n int) []forwarded {
+	for i := 0; i < 100; i++ {
+		e.mu.Lock()
+		if len(e.requests) >= n {
+			requests := e.requests
+			e.mu.Unlock()
+			return requests
+		}
+		e.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.requests
+}
+
+func testConfig(url string) Config {
+	topic, method, body, contentType := "/orders", "post", `{"id": {{.ID}}, "text": {{json .Body}}}`, "application/json"
+	urlTemplate := url + "/hooks{{.Topic}}/{{.Header.shop}}"
+	retries, timeout, connections := 2, time.Second, 2
+	return Config{
+		Topic:       &topic,
+		URL:         &urlTemplate,
+		Method:      &method,
+		Body:        &body,
+		ContentType: &contentType,
+		Retries:     &retries,
+		Timeout:     &timeout,
+		Connections: &connections,
+	}
+}
+
+func startRouter(a *assert.Assertions) router.Router {
+	kvs := kvstore.NewMemoryKVStore()
+	r := router.New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil)
+	a.NoError(r.(service.Startable).Start())
+	return r
+}
+
+func TestForwarder_ForwardsTheMessagesOfTheTopic(t *testing.T) {
+	a := assert.New(t)
+
+	r := startRouter(a)
+	defer r.(service.Stopable).Stop()
+
+	e := &endpoint{}
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	f, err := New(r, testConfig(server.URL))
+	a.NoError(err)
+	a.NoError(f.Start())
+	defer f.Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/orders/new", HeaderJSON: `{"shop": "berlin"}`, Body: []byte(`say "hi"`)}))
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/other", Body: []byte("ignored")}))
+
+	requests := e.waitRequests(1)
+	time.Sleep(20 * time.Millisecond)
+	if a.Len(requests, 1) {
+		a.Equal("POST", requests[0].method)
+		a.Equal("/hooks/orders/new/berlin", requests[0].path)
+		a.Equal("application/json", requests[0].contentType)
+		a.JSONEq(`{"id": 1, "text": "say \"hi\""}`, requests[0].body)
+	}
+}
+
+func TestForwarder_RetriesTheFailedRequests(t *testing.T) {
+	a := assert.New(t)
+
+	min := retryBackoffMin
+	retryBackoffMin = time.Millisecond
+	defer func() { retryBackoffMin = min }()
+
+	r := startRouter(a)
+	defer r.(service.Stopable).Stop()
+
+	// the first message is forwarded at the third attempt, and the second one is rejected
+	e := &endpoint{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK, http.StatusBadRequest}}
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	f, err := New(r, testConfig(server.URL))
+	a.NoError(err)
+	a.NoError(f.Start())
+	defer f.Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/orders", HeaderJSON: `{"shop": "berlin"}`, Body: []byte("first")}))
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/orders", HeaderJSON: `{"shop": "berlin"}`, Body: []byte("second")}))
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/orders", HeaderJSON: `{"shop": "paris"}`, Body: []byte("third")}))
+
+	requests := e.waitRequests(5)
+	time.Sleep(20 * time.Millisecond)
+	if a.Len(requests, 5) {
+		for i, body := range []string{"first", "first", "first", "second", "third"} {
+			a.Contains(requests[i].body, body)
+		}
+	}
+}
+
+func TestForwarder_ForwardsCloudEvents(t *testing.T) {
+	a := assert.New(t)
+
+	for _, mode := range []string{CloudEventsBinary, CloudEventsStructured} {
+		r := startRouter(a)
+		e := &endpoint{}
+		server := httptest.NewServer(e)
+
+		config := testConfig(server.URL)
+		config.CloudEvents = &mode
+		f, err := New(r, config)
+		a.NoError(err)
+		a.NoError(f.Start())
+		time.Sleep(50 * time.Millisecond)
+
+		a.NoError(r.HandleMessage(&protocol.Message{Path: "/orders", HeaderJSON: `{"shop": "berlin", "ce_type": "order.created"}`, Body: []byte("hi")}))
+
+		requests := e.waitRequests(1)
+		if a.Len(requests, 1, mode) {
+			if mode == CloudEventsBinary {
+				a.Equal("application/json", requests[0].contentType)
+				a.Equal("order.created", requests[0].ceType)
+				a.JSONEq(`{"id": 1, "text": "hi"}`, requests[0].body)
+			} else {
+				a.Equal("application/cloudevents+json", requests[0].contentType)
+				a.Contains(requests[0].body, `"type":"order.created"`)
+				a.Contains(requests[0].body, `"data":{"id":1,"text":"hi"}`)
+			}
+		}
+		f.Stop()
+		server.Close()
+		r.(service.Stopable).Stop()
+	}
+}
+
+func TestNew_RejectsInvalidConfigs(t *testing.T) {
+	a := assert.New(t)
+
+	config := testConfig("http://localhost")
+	invalid := "/"
+	config.Topic = &invalid
+	_, err := New(nil, config)
+	a.Error(err)
+
+	config = testConfig("http://localhost")
+	invalid = "{{.Body"
+	config.Body = &invalid
+	_, err = New(nil, config)
+	a.Error(err)
+
+	config = testConfig("http://localhost")
+	invalid = "batch"
+	config.CloudEvents = &invalid
+	_, err = New(nil, config)
+	a.Error(err)
+}
@@ -0,0 +1,7 @@
+package forward
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "forward")
@@ -5,30 +5,53 @@ import (
 
 	"github.com/smancke/guble/logformatter"
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/alias"
 	"github.com/smancke/guble/server/apns"
 	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/campaign"
+	"github.com/smancke/guble/server/chaos"
+	"github.com/smancke/guble/server/chat"
 	"github.com/smancke/guble/server/cluster"
+	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/daemon"
+	"github.com/smancke/guble/server/dummypush"
+	"github.com/smancke/guble/server/email"
+	"github.com/smancke/guble/server/external"
 	"github.com/smancke/guble/server/fcm"
+	"github.com/smancke/guble/server/forward"
 	"github.com/smancke/guble/server/kvstore"
 	"github.com/smancke/guble/server/metrics"
+	"github.com/smancke/guble/server/monitor"
+	"github.com/smancke/guble/server/notify"
+	"github.com/smancke/guble/server/proxy"
 	"github.com/smancke/guble/server/rest"
 	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/schema"
+	"github.com/smancke/guble/server/search"
 	"github.com/smancke/guble/server/service"
 	"github.com/smancke/guble/server/sms"
 	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/store/archive"
 	"github.com/smancke/guble/server/store/dummystore"
 	"github.com/smancke/guble/server/store/filestore"
+	"github.com/smancke/guble/server/templates"
+	"github.com/smancke/guble/server/throttle"
+	"github.com/smancke/guble/server/topics"
 	"github.com/smancke/guble/server/webserver"
 	"github.com/smancke/guble/server/websocket"
 
+	"context"
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"os/signal"
 	"path"
 	"runtime"
 	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/Bogh/gcm"
 	"github.com/pkg/profile"
@@ -37,6 +60,28 @@ import (
 
 const (
 	fileOption = "file"
+
+	// mysqlConnMaxLifetime should be lower than the wait_timeout of the MySQL server,
+	// so that connections are not closed by the server while idle in the pool.
+	mysqlConnMaxLifetime = 5 * time.Minute
+
+	// partitionEvictionTopic is the admin topic notified about the partitions evicted by the file message store
+	partitionEvictionTopic = protocol.Path("/admin/evicted-partitions")
+)
+
+// The exit codes of the guble server, distinguishing a clean shutdown from the failures.
+const (
+	// exitClean is the exit code of a clean shutdown
+	exitClean = 0
+
+	// exitFatal is the exit code of the fatal errors, e.g. an invalid configuration (as of logger.Fatal)
+	exitFatal = 1
+
+	// exitStartFailed is the exit code of a server whose modules could not be started
+	exitStartFailed = 2
+
+	// exitStopFailed is the exit code of a shutdown in which some modules could not be stopped
+	exitStopFailed = 3
 )
 
 var AfterMessageDelivery = func(m *protocol.Message) {
@@ -45,12 +90,12 @@ var AfterMessageDelivery = func(m *protocol.Message) {
 
 // ValidateStoragePath validates the guble configuration with regard to the storagePath
 // (which can be used by MessageStore and/or KVStore implementations).
-var ValidateStoragePath = func() error {
-	if *Config.KVS == fileOption || *Config.MS == fileOption {
-		testfile := path.Join(*Config.StoragePath, "write-test-file")
+var ValidateStoragePath = func(config *GubleConfig) error {
+	if *config.KVS == fileOption || *config.MS == fileOption {
+		testfile := path.Join(*config.StoragePath, "write-test-file")
 		f, err := os.Create(testfile)
 		if err != nil {
-			logger.WithError(err).WithField("storagePath", *Config.StoragePath).Error("Storage path not present/writeable.")
+			logger.WithError(err).WithField("storagePath", *config.StoragePath).Error("Storage path not present/writeable.")
 			return err
 		}
 		f.Close()
@@ -67,12 +112,12 @@ var CreateAccessManager = func() auth.AccessManager {
 
 // CreateKVStore is a func which returns a kvstore.KVStore implementation
 // (currently, based on guble configuration).
-var CreateKVStore = func() kvstore.KVStore {
-	switch *Config.KVS {
+var CreateKVStore = func(config *GubleConfig) kvstore.KVStore {
+	switch *config.KVS {
 	case "memory":
 		return kvstore.NewMemoryKVStore()
 	case "file":
-		db := kvstore.NewSqliteKVStore(path.Join(*Config.StoragePath, "kv-store.db"), true)
+		db := kvstore.NewSqliteKVStore(path.Join(*config.StoragePath, "kv-store.db"), true)
 		if err := db.Open(); err != nil {
 			logger.WithError(err).Panic("Could not open sqlite database connection")
 		}
@@ -80,11 +125,11 @@ var CreateKVStore = func() kvstore.KVStore {
 	case "postgres":
 		db := kvstore.NewPostgresKVStore(kvstore.PostgresConfig{
 			ConnParams: map[string]string{
-				"host":     *Config.Postgres.Host,
-				"port":     strconv.Itoa(*Config.Postgres.Port),
-				"user":     *Config.Postgres.User,
-				"password": *Config.Postgres.Password,
-				"dbname":   *Config.Postgres.DbName,
+				"host":     *config.Postgres.Host,
+				"port":     strconv.Itoa(*config.Postgres.Port),
+				"user":     *config.Postgres.User,
+				"password": *config.Postgres.Password,
+				"dbname":   *config.Postgres.DbName,
 				"sslmode":  "disable",
 			},
 			MaxIdleConns: 1,
@@ -94,111 +139,484 @@ var CreateKVStore = func() kvstore.KVStore {
 			logger.WithError(err).Panic("Could not open postgres database connection")
 		}
 		return db
+	case "mysql":
+		db := kvstore.NewMySQLKVStore(kvstore.MySQLConfig{
+			Host:            *config.MySQL.Host,
+			Port:            *config.MySQL.Port,
+			User:            *config.MySQL.User,
+			Password:        *config.MySQL.Password,
+			DbName:          *config.MySQL.DbName,
+			MaxIdleConns:    1,
+			MaxOpenConns:    runtime.GOMAXPROCS(0),
+			ConnMaxLifetime: mysqlConnMaxLifetime,
+		})
+		if err := db.Open(); err != nil {
+			logger.WithError(err).Panic("Could not open mysql database connection")
+		}
+		return db
+	case "redis":
+		db := kvstore.NewRedisKVStore(kvstore.RedisConfig{
+			Addr:          *config.Redis.Addr,
+			Password:      *config.Redis.Password,
+			DB:            *config.Redis.DB,
+			Notifications: *config.Redis.Notifications,
+			MaxIdleConns:  1,
+			MaxOpenConns:  runtime.GOMAXPROCS(0),
+		})
+		if err := db.Open(); err != nil {
+			logger.WithError(err).Panic("Could not open redis connection")
+		}
+		return db
 	default:
-		panic(fmt.Errorf("Unknown key-value backend: %q", *Config.KVS))
+		panic(fmt.Errorf("Unknown key-value backend: %q", *config.KVS))
 	}
 }
 
 // CreateMessageStore is a func which returns a store.MessageStore implementation
 // (currently, based on guble configuration).
-var CreateMessageStore = func() store.MessageStore {
-	switch *Config.MS {
+var CreateMessageStore = func(config *GubleConfig) store.MessageStore {
+	switch *config.MS {
 	case "none", "memory", "":
 		return dummystore.New(kvstore.NewMemoryKVStore())
 	case "file":
-		logger.WithField("storagePath", *Config.StoragePath).Info("Using FileMessageStore in directory")
-		return filestore.New(*Config.StoragePath)
+		logger.WithFields(log.Fields{
+			"storagePath": *config.StoragePath,
+			"sync":        *config.MSSync,
+		}).Info("Using FileMessageStore in directory")
+		return filestore.NewWithConfig(*config.StoragePath, filestore.Config{
+			SyncPolicy:        filestore.SyncPolicy(*config.MSSync),
+			SyncInterval:      *config.MSSyncInterval,
+			CacheMessages:     *config.MSCacheMessages,
+			CacheSize:         *config.MSCacheSizeMB * 1024 * 1024,
+			MaxPartitions:     *config.MSMaxPartitions,
+			MaxSize:           *config.MSMaxSizeMB * 1024 * 1024,
+			EvictionIdleTime:  *config.MSEvictionIdle,
+			KeepPartitions:    []string{partitionEvictionTopic.Partition()},
+			ScanOnStart:       *config.MSScan,
+			Archive:           createArchive(config),
+			ArchiveAge:        *config.Archive.Age,
+			ArchiveCacheFiles: *config.Archive.CacheFiles,
+		})
 	default:
-		panic(fmt.Errorf("Unknown message-store backend: %q", *Config.MS))
+		panic(fmt.Errorf("Unknown message-store backend: %q", *config.MS))
 	}
 }
 
+// createArchive returns the archive of the old message files, or nil if the archival is disabled.
+func createArchive(config *GubleConfig) archive.Archive {
+	if config.Archive.URL == nil || *config.Archive.URL == "" {
+		return nil
+	}
+	a, err := archive.New(*config.Archive.URL, archive.S3Config{
+		Endpoint:  *config.Archive.Endpoint,
+		Region:    *config.Archive.Region,
+		AccessKey: *config.Archive.AccessKey,
+		SecretKey: *config.Archive.SecretKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+	logger.WithFields(log.Fields{
+		"archive": *config.Archive.URL,
+		"age":     *config.Archive.Age,
+	}).Info("Archiving the old message files")
+	return a
+}
+
 // CreateModules is a func which returns a slice of modules which should be used by the service
 // (currently, based on guble configuration);
 // see package `service` for terminological details.
-var CreateModules = func(router router.Router) []interface{} {
+var CreateModules = func(router router.Router, config *GubleConfig) []interface{} {
 	var modules []interface{}
 
-	if wsHandler, err := websocket.NewWSHandler(router, "/stream/"); err != nil {
+	configureProxies(config)
+
+	// the users are authenticated by the provider, unless the websockets or the connectors have their own URL
+	authenticator := createAuthenticator(config.Auth)
+
+	// the one-time tickets required for connecting the websockets, issued to the backends of the applications
+	// (the user of a websocket is derived from its ticket, so that they are only issued with the tokens of the provider)
+	if *config.WS.Tickets && authenticator == nil {
+		logger.Error("The websocket tickets require an authentication provider (--auth-provider): they are disabled")
+	} else if *config.WS.Tickets {
+		if tickets, err := websocket.NewTickets(router, *config.WS.TicketTTL); err != nil {
+			logger.WithError(err).Error("Error creating websocket tickets")
+		} else {
+			modules = append(modules, tickets, rest.NewTicketsAPI(tickets, "/api/tickets/", authenticator))
+			config.WS.Redeemer = tickets
+		}
+	}
+
+	if *config.WS.AuthURL != "" {
+		config.WS.Authenticator = auth.NewRestAuthenticator(*config.WS.AuthURL)
+	} else if authenticator != nil {
+		config.WS.Authenticator = authenticator
+	}
+
+	var usersAPI *rest.UsersAPI
+	if wsHandler, err := websocket.NewWSHandler(router, "/stream/", config.WS); err != nil {
 		logger.WithError(err).Error("Error loading WSHandler module")
 	} else {
 		modules = append(modules, wsHandler)
+
+		// the sessions of a user are closed (and its unused tickets revoked) when its access is revoked,
+		// or the messages it missed are replayed to them (only by the admins of the authentication provider)
+		var revoker rest.TicketRevoker
+		if tickets, ok := config.WS.Redeemer.(*websocket.Tickets); ok {
+			revoker = tickets
+		}
+		usersAPI = rest.NewUsersAPI(router, wsHandler, revoker, "/admin/user/", authenticator)
+		modules = append(modules, usersAPI)
+	}
+
+	// the rate limiting of the publishing and subscribing requests, by address and credential
+	var limiter *throttle.Limiter
+	if *config.Throttle.Rate > 0 {
+		limiter = throttle.New(throttle.Config{
+			Rate:           *config.Throttle.Rate,
+			Burst:          *config.Throttle.Burst,
+			BanAfter:       *config.Throttle.BanAfter,
+			Ban:            *config.Throttle.Ban,
+			TrustForwarded: *config.Throttle.TrustForwarded,
+		}, "/admin/throttled/")
+		modules = append(modules, limiter)
+		config.FCM.Limiter = limiter
+		config.APNS.Limiter = limiter
 	}
 
-	modules = append(modules, rest.NewRestMessageAPI(router, "/api/"))
+	messageAPI := rest.NewRestMessageAPI(router, "/api/")
+	messageAPI.SetLimiter(limiter)
+	messageAPI.SetAuthenticator(authenticator)
+	modules = append(modules, messageAPI)
+	topicsAPI := rest.NewTopicsAPI(router, "/admin/topics/")
+	modules = append(modules, topicsAPI)
+	modules = append(modules, rest.NewFsckAPI(router, "/admin/fsck/"))
+	modules = append(modules, rest.NewDumpAPI(router, "/admin/dump/", authenticator))
+
+	// the profiling of a production server, only by the admins of the authentication provider
+	if *config.DebugEndpoint != "" {
+		if authenticator == nil {
+			logger.Error("The debug endpoint requires an authentication provider (--auth-provider): it is not mounted")
+		} else {
+			modules = append(modules, rest.NewDebugAPI(*config.DebugEndpoint, authenticator))
+		}
+	}
 
-	if *Config.FCM.Enabled {
+	var schemas topics.SchemaValidator
+	if registry, err := schema.NewRegistry(router, "/admin/schemas/"); err != nil {
+		logger.WithError(err).Error("Error creating schema registry")
+	} else {
+		modules = append(modules, registry)
+		schemas = registry
+	}
+
+	if registry, err := topics.NewRegistry(router, schemas); err != nil {
+		logger.WithError(err).Error("Error creating topic configuration registry")
+	} else {
+		modules = append(modules, registry)
+		topicsAPI.SetConfigHandler(registry)
+	}
+
+	if registry, err := alias.NewRegistry(router, "/admin/aliases/"); err != nil {
+		logger.WithError(err).Error("Error creating alias registry")
+	} else {
+		modules = append(modules, registry)
+	}
+
+	// the full-text search of the stored messages, for the support staff
+	if len(*config.SearchTopics) > 0 {
+		searchTopics := make([]protocol.Path, 0, len(*config.SearchTopics))
+		for _, topic := range *config.SearchTopics {
+			searchTopics = append(searchTopics, protocol.Path(topic))
+		}
+		modules = append(modules, search.NewIndexer(router, search.NewMemoryIndex(), searchTopics, "/admin/search"))
+	}
+
+	// the messages of a topic forwarded to a fixed HTTP endpoint
+	if *config.Forward.Topic != "" {
+		forwarder, err := forward.New(router, config.Forward)
+		if err != nil {
+			logger.WithError(err).Fatal("Invalid forwarder")
+		}
+		modules = append(modules, forwarder)
+	}
+
+	// the messages of some topics posted to chat channels
+	if len(*config.Chat.Routes) > 0 {
+		forwarders, err := chat.New(router, config.Chat)
+		if err != nil {
+			logger.WithError(err).Fatal("Invalid chat routes")
+		}
+		for _, forwarder := range forwarders {
+			modules = append(modules, forwarder)
+		}
+	}
+
+	// a replica only serves fetches and subscriptions: the connectors of the other nodes already deliver the messages
+	if *config.Cluster.Replica {
+		logger.Info("Read-only replica: connectors and notification router disabled")
+		return modules
+	}
+
+	// the push templates, rendered by the FCM and APNS connectors in the locales of the devices
+	if registry, err := templates.NewRegistry(router, "/admin/templates/"); err != nil {
+		logger.WithError(err).Error("Error creating template registry")
+	} else {
+		modules = append(modules, registry)
+		config.FCM.Templates = registry
+		config.APNS.Templates = registry
+	}
+
+	if *config.ConnectorAuth != "" {
+		authenticator = auth.NewRestAuthenticator(*config.ConnectorAuth)
+	}
+	if authenticator != nil {
+		config.FCM.Authenticator = authenticator
+		config.APNS.Authenticator = authenticator
+	}
+
+	// the push connectors delivering the channels of the notification router
+	subscriptions := make(map[string]notify.Subscriptions)
+
+	// the connectors in which the users register their devices, listed by the devices API
+	var deviceListers []connector.DeviceLister
+
+	if *config.FCM.Enabled {
 		logger.Info("Firebase Cloud Messaging: enabled")
-		if *Config.FCM.APIKey == "" {
+		if *config.FCM.APIKey == "" {
 			logger.Panic("The API Key has to be provided when Firebase Cloud Messaging is enabled")
 		}
-		Config.FCM.AfterMessageDelivery = AfterMessageDelivery
-		*Config.FCM.IntervalMetrics = true
-		if Config.FCM.Endpoint != nil {
-			gcm.GcmSendEndpoint = *Config.FCM.Endpoint
+		config.FCM.AfterMessageDelivery = AfterMessageDelivery
+		*config.FCM.IntervalMetrics = true
+		if config.FCM.Endpoint != nil {
+			gcm.GcmSendEndpoint = *config.FCM.Endpoint
 		}
-		sender := fcm.NewSender(*Config.FCM.APIKey)
-		if fcmConn, err := fcm.New(router, sender, Config.FCM); err != nil {
+		sender := fcm.NewSender(*config.FCM.APIKey)
+		if fcmConn, err := fcm.New(router, sender, config.FCM); err != nil {
 			logger.WithError(err).Error("Error creating FCM connector")
 		} else {
 			modules = append(modules, fcmConn)
+			subscriptions[notify.FCMChannel] = fcmConn
+			deviceListers = append(deviceListers, fcmConn)
 		}
 	} else {
 		logger.Info("Firebase Cloud Messaging: disabled")
 	}
 
-	if *Config.APNS.Enabled {
-		if *Config.APNS.Production {
-			logger.Info("APNS: enabled in production mode")
+	if *config.APNS.Enabled {
+		if *config.APNS.Production {
+			logger.Info("APNS: enabled with the production environment by default")
 		} else {
-			logger.Info("APNS: enabled in development mode")
+			logger.Info("APNS: enabled with the sandbox environment by default")
 		}
 		logger.Info("APNS: enabled")
-		if *Config.APNS.CertificateFileName == "" && Config.APNS.CertificateBytes == nil {
+		if *config.APNS.CertificateFileName == "" && config.APNS.CertificateBytes == nil {
 			logger.Panic("The certificate (as filename or bytes) has to be provided when APNS is enabled")
 		}
-		if *Config.APNS.CertificatePassword == "" {
+		if *config.APNS.CertificatePassword == "" {
 			logger.Panic("A non-empty password has to be provided when APNS is enabled")
 		}
-		if *Config.APNS.AppTopic == "" {
+		if *config.APNS.AppTopic == "" {
 			logger.Panic("The Mobile App Topic (usually the bundle-id) has to be provided when APNS is enabled")
 		}
-		apnsSender, err := apns.NewSender(Config.APNS)
+		apnsSender, err := apns.NewSender(config.APNS)
 		if err != nil {
 			logger.Panic("APNS Sender could not be created")
 		}
-		*Config.APNS.IntervalMetrics = true
-		if apnsConn, err := apns.New(router, apnsSender, Config.APNS); err != nil {
+		if config.Faults != nil {
+			apnsSender = chaos.NewAPNSSender(apnsSender, config.Faults)
+		}
+		*config.APNS.IntervalMetrics = true
+		if apnsConn, err := apns.New(router, apnsSender, config.APNS); err != nil {
 			logger.WithError(err).Error("Error creating APNS connector")
 		} else {
 			modules = append(modules, apnsConn)
+			subscriptions[notify.APNSChannel] = apnsConn
+			deviceListers = append(deviceListers, apnsConn)
 		}
 	} else {
 		logger.Info("APNS: disabled")
 	}
 
-	if *Config.SMS.Enabled {
-		logger.Info("Nexmo SMS: enabled")
-		if *Config.SMS.APIKey == "" || *Config.SMS.APISecret == "" {
-			logger.Panic("The API Key has to be provided when NEXMO SMS connector is enabled")
+	if *config.DummyPush.Enabled {
+		logger.Warn("Dummy push: enabled, the notifications are only recorded (listed by /admin/dummypush/)")
+		recorder := dummypush.NewRecorder("/admin/dummypush/", *config.DummyPush.Capacity)
+		if dummyConn, err := dummypush.New(router, recorder, config.DummyPush); err != nil {
+			logger.WithError(err).Error("Error creating dummy push connector")
+		} else {
+			modules = append(modules, dummyConn, recorder)
+		}
+	} else {
+		logger.Info("Dummy push: disabled")
+	}
+
+	if *config.Email.Enabled {
+		logger.WithField("prefix", *config.Email.Prefix).Info("Inbound email: enabled")
+		if *config.Email.SigningKey == "" {
+			logger.Warn("Inbound email: the posted emails are not verified without --email-signing-key")
+		}
+		modules = append(modules, email.New(router, config.Email))
+	}
+
+	for _, spec := range *config.External {
+		externalConfig, err := external.ParseConfig(spec)
+		if err != nil {
+			logger.WithError(err).Fatal("Invalid external connector")
 		}
-		nexmoSender, err := sms.NewNexmoSender(*Config.SMS.APIKey, *Config.SMS.APISecret)
+		logger.WithField("name", externalConfig.Name).WithField("command", externalConfig.Command).Info("External connector: enabled")
+		if externalConn, err := external.New(router, externalConfig); err != nil {
+			logger.WithError(err).Error("Error creating external connector")
+		} else {
+			modules = append(modules, externalConn)
+		}
+	}
+
+	if *config.SMS.Enabled {
+		logger.WithField("provider", *config.SMS.Provider).Info("SMS: enabled")
+		providers, err := sms.NewProviders(*config.SMS.Provider, createSMSProviders(config)...)
 		if err != nil {
-			logger.WithError(err).Error("Error creating Nexmo Sender")
+			logger.Panic("The credentials of the selected provider have to be provided when the SMS connector is enabled")
 		}
-		smsConn, err := sms.New(router, nexmoSender, Config.SMS)
+		smsConn, err := sms.New(router, providers, config.SMS)
 		if err != nil {
-			logger.WithError(err).Error("Error creating Nexmo Sender")
+			logger.WithError(err).Error("Error creating SMS connector")
 		} else {
 			modules = append(modules, smsConn)
+			config.Notify.SMSTopic = *config.SMS.SMSTopic
 		}
 	} else {
 		logger.Info("SMS: disabled")
 	}
 
+	if *config.Notify.Enabled {
+		logger.Info("Notification router: enabled")
+		if notifier, err := notify.New(router, subscriptions, config.Notify); err != nil {
+			logger.WithError(err).Error("Error creating notification router")
+		} else {
+			modules = append(modules, notifier)
+			deviceListers = append(deviceListers, notifier)
+		}
+	} else {
+		logger.Info("Notification router: disabled")
+	}
+
+	if *config.Campaign.Enabled {
+		logger.Info("Campaigns: enabled")
+		if campaigner, err := campaign.New(router, config.Campaign); err != nil {
+			logger.WithError(err).Error("Error creating campaigner")
+		} else {
+			modules = append(modules, campaigner)
+		}
+	} else {
+		logger.Info("Campaigns: disabled")
+	}
+
+	// the devices share the prefix of the users API, which serves them if it is mounted
+	devicesAPI := rest.NewDevicesAPI("/admin/user/", authenticator, deviceListers...)
+	if usersAPI != nil {
+		usersAPI.SetDevices(devicesAPI)
+	} else {
+		modules = append(modules, devicesAPI)
+	}
+
 	return modules
 }
 
+// configureProxies routes the outbound traffic through the configured proxies:
+// the default HTTP transport (used by the FCM client and the hooks) through the FCM or the global proxy,
+// and the APNS and SMS connectors through their own proxy, or else through the global one.
+func configureProxies(config *GubleConfig) {
+	global := parseProxy(config.Proxy)
+	hosts := make(map[string]*url.URL)
+	if fcmProxy := parseProxy(config.FCM.Proxy); fcmProxy != nil {
+		endpoint := gcm.GcmSendEndpoint
+		if config.FCM.Endpoint != nil {
+			endpoint = *config.FCM.Endpoint
+		}
+		if u, err := url.Parse(endpoint); err == nil {
+			hosts[u.Host] = fcmProxy
+		}
+	}
+	if global != nil || len(hosts) > 0 {
+		proxy.SetDefault(global, hosts)
+	}
+
+	if config.APNS.Proxy == nil || *config.APNS.Proxy == "" {
+		config.APNS.Proxy = config.Proxy
+	}
+	if config.SMS.Proxy == nil || *config.SMS.Proxy == "" {
+		config.SMS.Proxy = config.Proxy
+	}
+}
+
+// createAuthenticator returns the configured authentication provider, or nil if none is configured.
+func createAuthenticator(config AuthConfig) auth.Authenticator {
+	if *config.Provider == authProviderNone {
+		return nil
+	}
+	if *config.URL == "" {
+		logger.Panic("The URL of the authentication provider has to be provided")
+	}
+	logger.WithField("provider", *config.Provider).Info("Authentication of the users: enabled")
+	switch *config.Provider {
+	case authProviderOAuth2:
+		return auth.NewIntrospectionAuthenticator(*config.URL, *config.ClientID, *config.ClientSecret)
+	case authProviderLDAP:
+		authenticator, err := auth.NewLDAPAuthenticator(*config.URL, *config.LDAPUserDN)
+		if err != nil {
+			panic(err)
+		}
+		return authenticator
+	}
+	return auth.NewRestAuthenticator(*config.URL)
+}
+
+// parseProxy returns the URL of the configured proxy, or nil if none is configured.
+func parseProxy(value *string) *url.URL {
+	if value == nil {
+		return nil
+	}
+	proxyURL, err := proxy.Parse(*value)
+	if err != nil {
+		panic(err)
+	}
+	if proxyURL != nil {
+		logger.WithField("proxy", proxy.Redacted(proxyURL)).Info("Using outbound proxy")
+	}
+	return proxyURL
+}
+
+// createSMSProviders returns the SMS providers, whose credentials are configured.
+func createSMSProviders(config *GubleConfig) []sms.Provider {
+	var providers []sms.Provider
+	proxyURL := parseProxy(config.SMS.Proxy)
+	if *config.SMS.APIKey != "" && *config.SMS.APISecret != "" {
+		nexmoSender, err := sms.NewNexmoSender(*config.SMS.APIKey, *config.SMS.APISecret)
+		if err != nil {
+			logger.WithError(err).Error("Error creating Nexmo Sender")
+		} else {
+			if *config.SMS.CallbackURL != "" {
+				nexmoSender.Callback = sms.ReportURL(*config.SMS.CallbackURL, *config.SMS.Prefix, sms.NexmoProvider)
+			}
+			nexmoSender.SetProxy(proxyURL)
+			providers = append(providers, nexmoSender)
+		}
+	}
+	if *config.SMS.TwilioAccountSID != "" && *config.SMS.TwilioAuthToken != "" {
+		twilioSender, err := sms.NewTwilioSender(*config.SMS.TwilioAccountSID, *config.SMS.TwilioAuthToken)
+		if err != nil {
+			logger.WithError(err).Error("Error creating Twilio Sender")
+		} else {
+			if *config.SMS.CallbackURL != "" {
+				twilioSender.StatusCallback = sms.ReportURL(*config.SMS.CallbackURL, *config.SMS.Prefix, sms.TwilioProvider)
+			}
+			twilioSender.SetProxy(proxyURL)
+			providers = append(providers, twilioSender)
+		}
+	}
+	return providers
+}
+
 // Main is the entry-point of the guble server.
 func Main() {
 	defer func() {
@@ -208,18 +626,26 @@ func Main() {
 	}()
 
 	parseConfig()
+	config := Config
+
+	// a Windows service has to be connected to the service control manager early
+	daemon.Start()
 
 	if !terminal.IsTerminal(int(os.Stdout.Fd())) {
-		log.SetFormatter(&logformatter.LogstashFormatter{Env: *Config.EnvName})
+		log.SetFormatter(&logformatter.LogstashFormatter{Env: *config.EnvName})
 	}
 
-	level, err := log.ParseLevel(*Config.Log)
+	level, err := log.ParseLevel(*config.Log)
 	if err != nil {
 		logger.WithError(err).Fatal("Invalid log level")
 	}
 	log.SetLevel(level)
 
-	switch *Config.Profile {
+	if err := protocol.SetRedactedTopics(*config.RedactTopics); err != nil {
+		logger.WithError(err).Fatal("Invalid redaction rules")
+	}
+
+	switch *config.Profile {
 	case cpuProfile:
 		logger.Info("starting to profile cpu")
 		defer profile.Start(profile.CPUProfile).Stop()
@@ -233,58 +659,181 @@ func Main() {
 		logger.Debug("no profiling was started")
 	}
 
-	if err := ValidateStoragePath(); err != nil {
+	if err := ValidateStoragePath(config); err != nil {
 		logger.Fatal("Fatal error in gubled in validation of storage path")
 	}
 
-	srv := StartService()
+	srv := StartServiceWithConfig(config)
 	if srv == nil {
-		logger.Fatal("exiting because of unrecoverable error(s) when starting the service")
+		logger.Error("exiting because of unrecoverable error(s) when starting the service")
+		daemon.Stopped(exitStartFailed)
+		os.Exit(exitStartFailed)
 	}
-
-	waitForTermination(func() {
-		err := srv.Stop()
-		if err != nil {
+	daemon.Ready()
+
+	waitForTermination(func(sig os.Signal) int {
+		daemon.Stopping("Stopping")
+
+		// on SIGTERM (e.g. sent by Kubernetes after the preStop hook), the websockets are closed gracefully first
+		if sig == syscall.SIGTERM {
+			ctx, cancel := context.WithTimeout(context.Background(), *config.DrainTimeout)
+			if err := srv.Drain(ctx); err != nil {
+				logger.WithError(err).Warn("The service was not drained before stopping")
+			}
+			cancel()
+		}
+		if err := srv.Stop(); err != nil {
 			logger.WithField("error", err.Error()).Error("errors occurred while stopping service")
+			return exitStopFailed
 		}
+		return exitClean
 	})
 }
 
-// StartService starts a server.Service after first creating the router (and its dependencies), the webserver.
+// notifyPartitionEviction returns a handler publishing the info of the evicted partitions on the eviction topic.
+func notifyPartitionEviction(r router.Router) func(*store.PartitionInfo) {
+	return func(info *store.PartitionInfo) {
+		body, err := json.Marshal(info)
+		if err != nil {
+			logger.WithError(err).Error("Error encoding the info of an evicted partition")
+			return
+		}
+		if err := r.HandleMessage(&protocol.Message{Path: partitionEvictionTopic, Body: body}); err != nil {
+			logger.WithError(err).WithField("partition", info.Partition).Error("Error notifying the eviction of a partition")
+		}
+	}
+}
+
+// StartService starts a server.Service with the configuration parsed from the command line (see StartServiceWithConfig).
 func StartService() *service.Service {
+	return StartServiceWithConfig(Config)
+}
+
+// StartServiceWithConfig starts a server.Service after first creating the router (and its dependencies), the webserver,
+// using the given configuration, so that differently configured services can run in the same process.
+func StartServiceWithConfig(config *GubleConfig) *service.Service {
 	//TODO StartService could return an error in case it fails to start
 
 	accessManager := CreateAccessManager()
-	messageStore := CreateMessageStore()
-	kvStore := CreateKVStore()
+	messageStore := CreateMessageStore(config)
+	kvStore := CreateKVStore(config)
+
+	// the faults injected for testing, changed by an admin endpoint
+	var faults *chaos.Injector
+	routerStore := messageStore
+	if *config.Chaos {
+		logger.Warn("Starting in chaos-mode: faults can be injected with /admin/chaos/")
+		faults = chaos.New("/admin/chaos/")
+		config.Faults = faults
+		routerStore = chaos.NewMessageStore(messageStore, faults)
+	}
 
 	var cl *cluster.Cluster
 	var err error
 
-	if *Config.Cluster.NodeID > 0 {
-		exitIfInvalidClusterParams(*Config.Cluster.NodeID, *Config.Cluster.NodePort, *Config.Cluster.Remotes)
-		logger.Info("Starting in cluster-mode")
-		cl, err = cluster.New(&cluster.Config{
-			ID:      *Config.Cluster.NodeID,
-			Port:    *Config.Cluster.NodePort,
-			Remotes: *Config.Cluster.Remotes,
-		})
+	if *config.Cluster.NodeID > 0 {
+		exitIfInvalidClusterParams(*config.Cluster.NodeID, *config.Cluster.NodePort, *config.Cluster.Remotes)
+		if *config.Cluster.Replica {
+			logger.Info("Starting in cluster-mode, as read-only replica")
+		} else {
+			logger.Info("Starting in cluster-mode")
+		}
+		clusterConfig := newClusterConfig(config, faults)
+		cl, err = cluster.New(&clusterConfig)
 		if err != nil {
 			logger.WithField("err", err).Fatal("Module could not be started (cluster)")
 		}
+	} else if *config.Cluster.Replica {
+		logger.Fatal("Could not start as replica: a replica requires the cluster-mode")
 	} else {
 		logger.Info("Starting in standalone-mode")
 	}
 
-	r := router.New(accessManager, messageStore, kvStore, cl)
-	websrv := webserver.New(*Config.HttpListen)
+	r := router.New(accessManager, routerStore, kvStore, cl)
+	if dc, ok := r.(router.DeliveryConfigurable); ok {
+		dc.SetDeliveryWorkers(router.WebsocketRoute, *config.Delivery.WebsocketWorkers)
+		dc.SetDeliveryWorkers(router.ConnectorRoute, *config.Delivery.ConnectorWorkers)
+	}
+	if ec, ok := r.(router.EphemeralConfigurable); ok {
+		if err := ec.SetEphemeralTopics(*config.EphemeralTopics); err != nil {
+			logger.WithError(err).Fatal("Invalid ephemeral topics")
+		}
+	}
+	if bc, ok := r.(router.BroadcastConfigurable); ok {
+		if err := bc.SetBroadcast(*config.Broadcast.Topic, *config.Broadcast.Spread); err != nil {
+			logger.WithError(err).Fatal("Invalid broadcast configuration")
+		}
+	}
+	if cc, ok := r.(router.CompressionConfigurable); ok {
+		if err := cc.SetCompression(*config.Compression.Encoding, *config.Compression.Threshold); err != nil {
+			logger.WithError(err).Fatal("Invalid compression configuration")
+		}
+	}
+	if interceptable, ok := r.(router.Interceptable); ok {
+		interceptable.AddInterceptor("header-limits", router.HeaderLimits{
+			MaxSize: *config.HeaderMaxSize,
+			MaxKeys: *config.HeaderMaxKeys,
+		})
+		interceptable.AddInterceptor("references", router.CheckReferences)
+		for _, url := range *config.Hooks {
+			interceptable.AddInterceptor(url, router.NewHTTPInterceptor(url, *config.HookTimeout))
+		}
+	}
+	// a replica cannot publish the evictions
+	if fms, ok := messageStore.(*filestore.FileMessageStore); ok && !*config.Cluster.Replica {
+		fms.SetEvictionHandler(notifyPartitionEviction(r))
+	}
+	websrv := webserver.New(*config.HttpListen)
 
 	srv := service.New(r, websrv).
-		HealthEndpoint(*Config.HealthEndpoint).
-		MetricsEndpoint(*Config.MetricsEndpoint)
+		HealthEndpoint(*config.HealthEndpoint).
+		MetricsEndpoint(*config.MetricsEndpoint).
+		DrainEndpoint(*config.DrainEndpoint, *config.DrainTimeout)
 
 	srv.RegisterModules(0, 6, kvStore, messageStore)
-	srv.RegisterModules(4, 3, CreateModules(r)...)
+	if cl != nil && *config.Cluster.DataURL != "" {
+		srv.RegisterModules(4, 3, cl.ReplicationEndpoint("/cluster/replication"))
+	}
+	// a standalone node can be migrated to the cluster-mode at runtime, with the node configuration
+	if ca, ok := r.(router.ClusterAttachable); ok && cl == nil {
+		srv.RegisterModules(1, 5, cluster.NewMigrator(newClusterConfig(config, faults), r, ca.AttachCluster))
+	}
+	if *config.MetricsSnapshot > 0 {
+		// the counters are restored before the router starts, and persisted a last time after the connectors stopped
+		node := "node" + strconv.Itoa(int(*config.Cluster.NodeID))
+		srv.RegisterModules(1, 5, metrics.NewSnapshots(kvStore, node, *config.MetricsSnapshot))
+	}
+	if *config.StatsD.Address != "" {
+		srv.RegisterModules(4, 5, metrics.NewStatsD(
+			*config.StatsD.Address, *config.StatsD.Prefix, *config.StatsD.Tags, *config.StatsD.Interval))
+	}
+	modules := CreateModules(r, config)
+	srv.RegisterModules(4, 3, modules...)
+	if faults != nil {
+		srv.RegisterModules(4, 3, faults)
+	}
+
+	if *config.Lag.URL != "" || *config.Lag.Topic != "" {
+		var reporters []monitor.LagReporter
+		for _, m := range modules {
+			if reporter, ok := m.(monitor.LagReporter); ok {
+				reporters = append(reporters, reporter)
+			}
+		}
+		if cl != nil {
+			reporters = append(reporters, cl)
+		}
+		// the monitor stops before the connectors, so that their stopping raises no alerts
+		srv.RegisterModules(5, 1, monitor.New(monitor.Config{
+			NodeID:               *config.Cluster.NodeID,
+			Interval:             *config.Lag.Interval,
+			DeliveryThreshold:    *config.Lag.DeliveryThreshold,
+			ReplicationThreshold: *config.Lag.ReplicationThreshold,
+			URL:                  *config.Lag.URL,
+			Topic:                protocol.Path(*config.Lag.Topic),
+			Timeout:              *config.HookTimeout,
+		}, r, reporters...))
+	}
 
 	if err = srv.Start(); err != nil {
 		logger.WithField("error", err.Error()).Error("errors occurred while starting service")
@@ -297,6 +846,30 @@ func StartService() *service.Service {
 	return srv
 }
 
+// newClusterConfig returns the configuration of the cluster node.
+func newClusterConfig(config *GubleConfig, faults *chaos.Injector) cluster.Config {
+	return cluster.Config{
+		ID:              *config.Cluster.NodeID,
+		Port:            *config.Cluster.NodePort,
+		AdvertiseHost:   *config.Cluster.NodeAdvertiseHost,
+		AdvertisePort:   *config.Cluster.NodeAdvertisePort,
+		Remotes:         *config.Cluster.Remotes,
+		Replica:         *config.Cluster.Replica,
+		FetchTimeout:    *config.Cluster.FetchTimeout,
+		JoinTimeout:     *config.Cluster.JoinTimeout,
+		DataPort:        *config.Cluster.DataPort,
+		DataURL:         *config.Cluster.DataURL,
+		DataSecret:      *config.Cluster.DataSecret,
+		ControlRate:     *config.Cluster.ControlRate,
+		DataRate:        *config.Cluster.DataRate,
+		Codec:           *config.Cluster.Codec,
+		Zone:            *config.Cluster.Zone,
+		Weight:          *config.Cluster.Weight,
+		CrossZoneFanout: *config.Cluster.CrossZoneFanout,
+		Faults:          faults,
+	}
+}
+
 func exitIfInvalidClusterParams(nodeID uint8, nodePort int, remotes []*net.TCPAddr) {
 	if (nodeID <= 0 && len(remotes) > 0) || (nodePort <= 0) {
 		errorMessage := "Could not start in cluster-mode: invalid/incomplete parameters"
@@ -308,13 +881,21 @@ func exitIfInvalidClusterParams(nodeID uint8, nodePort int, remotes []*net.TCPAd
 	}
 }
 
-func waitForTermination(callback func()) {
-	signalC := make(chan os.Signal)
+// waitForTermination waits for SIGINT or SIGTERM (or for the stop of the Windows service, handled as SIGTERM),
+// and exits with the exit code returned by the callback.
+func waitForTermination(callback func(os.Signal) int) {
+	signalC := make(chan os.Signal, 1)
 	signal.Notify(signalC, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-signalC
+	var sig os.Signal
+	select {
+	case sig = <-signalC:
+	case <-daemon.StopC():
+		sig = syscall.SIGTERM
+	}
 	logger.Infof("Got signal '%v' .. exiting gracefully now", sig)
-	callback()
+	code := callback(sig)
 	metrics.LogOnDebugLevel()
-	logger.Info("Exit gracefully now")
-	os.Exit(0)
+	logger.WithField("code", code).Info("Exit now")
+	daemon.Stopped(code)
+	os.Exit(code)
 }
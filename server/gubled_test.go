@@ -1,6 +1,7 @@
 package server
 
 import (
+	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/kvstore"
 
 	"github.com/smancke/guble/testutil"
@@ -23,19 +24,19 @@ func TestValidateStoragePath(t *testing.T) {
 	*Config.MS = "file"
 
 	*Config.StoragePath = valid
-	a.NoError(ValidateStoragePath())
+	a.NoError(ValidateStoragePath(Config))
 	*Config.StoragePath = invalid
 
-	a.Error(ValidateStoragePath())
+	a.Error(ValidateStoragePath(Config))
 
 	*Config.KVS = "file"
-	a.Error(ValidateStoragePath())
+	a.Error(ValidateStoragePath(Config))
 }
 
 func TestCreateKVStoreBackend(t *testing.T) {
 	a := assert.New(t)
 	*Config.KVS = "memory"
-	memory := CreateKVStore()
+	memory := CreateKVStore(Config)
 	a.Equal("*kvstore.MemoryKVStore", reflect.TypeOf(memory).String())
 
 	dir, _ := ioutil.TempDir("", "guble_test")
@@ -43,7 +44,7 @@ func TestCreateKVStoreBackend(t *testing.T) {
 
 	*Config.KVS = "file"
 	*Config.StoragePath = dir
-	sqlite := CreateKVStore()
+	sqlite := CreateKVStore(Config)
 	a.Equal("*kvstore.SqliteKVStore", reflect.TypeOf(sqlite).String())
 }
 
@@ -54,15 +55,34 @@ func TestFCMOnlyStartedIfEnabled(t *testing.T) {
 	a := assert.New(t)
 
 	routerMock := initRouterMock()
-	routerMock.EXPECT().KVStore().Return(kvstore.NewMemoryKVStore(), nil)
 
 	*Config.FCM.Enabled = true
 	*Config.FCM.APIKey = "xyz"
 	*Config.APNS.Enabled = false
-	a.True(containsFCMModule(CreateModules(routerMock)))
+	*Config.Cluster.Replica = false
+	a.True(containsFCMModule(CreateModules(routerMock, Config)))
 
 	*Config.FCM.Enabled = false
-	a.False(containsFCMModule(CreateModules(routerMock)))
+	a.False(containsFCMModule(CreateModules(routerMock, Config)))
+}
+
+func TestConnectorsNotStartedOnReplica(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	routerMock := initRouterMock()
+
+	*Config.FCM.Enabled = true
+	*Config.FCM.APIKey = "xyz"
+	*Config.APNS.Enabled = false
+	*Config.Cluster.Replica = true
+	defer func() { *Config.Cluster.Replica = false }()
+
+	modules := CreateModules(routerMock, Config)
+	a.False(containsFCMModule(modules))
+	a.True(len(modules) > 0, "the websocket and REST APIs should be served by a replica")
 }
 
 func containsFCMModule(modules []interface{}) bool {
@@ -88,7 +108,31 @@ func TestPanicOnMissingFCMApiKey(t *testing.T) {
 	routerMock := initRouterMock()
 	*Config.FCM.APIKey = ""
 	*Config.FCM.Enabled = true
-	CreateModules(routerMock)
+	*Config.Cluster.Replica = false
+	CreateModules(routerMock, Config)
+}
+
+func TestCreateAuthenticator(t *testing.T) {
+	a := assert.New(t)
+	config, err := NewConfig([]string{"--storage-path", os.TempDir()})
+	a.NoError(err)
+	a.Nil(createAuthenticator(config.Auth))
+
+	config, err = NewConfig([]string{"--storage-path", os.TempDir(),
+		"--auth-provider", "oauth2", "--auth-url", "https://sso.example.com/introspect", "--auth-client-id", "guble"})
+	a.NoError(err)
+	a.Equal(auth.NewIntrospectionAuthenticator("https://sso.example.com/introspect", "guble", ""), createAuthenticator(config.Auth))
+
+	config, err = NewConfig([]string{"--storage-path", os.TempDir(),
+		"--auth-provider", "ldap", "--auth-url", "ldaps://ldap.example.com", "--auth-ldap-user-dn", "uid=%s,dc=example,dc=com"})
+	a.NoError(err)
+	a.IsType(&auth.LDAPAuthenticator{}, createAuthenticator(config.Auth))
+
+	// an LDAP provider without the DN of the users, and a provider without URL
+	*config.Auth.LDAPUserDN = ""
+	a.Panics(func() { createAuthenticator(config.Auth) })
+	*config.Auth.URL = ""
+	a.Panics(func() { createAuthenticator(config.Auth) })
 }
 
 func TestCreateStoreBackendPanicInvalidBackend(t *testing.T) {
@@ -99,7 +143,7 @@ func TestCreateStoreBackendPanicInvalidBackend(t *testing.T) {
 		}()
 
 		*Config.KVS = "foo bar"
-		CreateKVStore()
+		CreateKVStore(Config)
 	}()
 	assert.NotNil(t, p)
 }
@@ -114,6 +158,7 @@ func TestStartServiceModules(t *testing.T) {
 	*Config.MS = "file"
 	*Config.FCM.Enabled = false
 	*Config.APNS.Enabled = false
+	*Config.Cluster.Replica = false
 
 	// using an available port for http
 	testHttpPort++
@@ -123,13 +168,13 @@ func TestStartServiceModules(t *testing.T) {
 	s := StartService()
 
 	// then the number and ordering of modules should be correct
-	a.Equal(6, len(s.ModulesSortedByStartOrder()))
+	a.Equal(12, len(s.ModulesSortedByStartOrder()))
 	var moduleNames []string
 	for _, iface := range s.ModulesSortedByStartOrder() {
 		name := reflect.TypeOf(iface).String()
 		moduleNames = append(moduleNames, name)
 	}
-	a.Equal("*kvstore.MemoryKVStore *filestore.FileMessageStore *router.router *webserver.WebServer *websocket.WSHandler *rest.RestMessageAPI",
+	a.Equal("*kvstore.MemoryKVStore *filestore.FileMessageStore *router.router *webserver.WebServer *websocket.WSHandler *rest.RestMessageAPI *rest.TopicsAPI *rest.FsckAPI *rest.DumpAPI *schema.Registry *topics.Registry *alias.Registry",
 		strings.Join(moduleNames, " "))
 }
 
@@ -141,6 +186,7 @@ func initRouterMock() *MockRouter {
 
 	routerMock.EXPECT().AccessManager().Return(amMock, nil).AnyTimes()
 	routerMock.EXPECT().MessageStore().Return(msMock, nil).AnyTimes()
+	routerMock.EXPECT().KVStore().Return(kvstore.NewMemoryKVStore(), nil).AnyTimes()
 
 	return routerMock
 }
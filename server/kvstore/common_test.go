@@ -3,6 +3,7 @@ package kvstore
 import (
 	"github.com/stretchr/testify/assert"
 
+	"context"
 	"crypto/rand"
 	"io/ioutil"
 	"os"
@@ -39,6 +40,48 @@ func CommonTestPutGetDelete(t *testing.T, kvs1 KVStore, kvs2 KVStore) {
 	assertGetNoExist(a, kvs1, "s2", "a")
 }
 
+func CommonTestBatch(t *testing.T, kvs1 KVStore, kvs2 KVStore) {
+	a := assert.New(t)
+
+	a.NoError(kvs1.Put("s1", "c", test3))
+	a.NoError(kvs1.Batch("s1", []Operation{
+		{Key: "a", Value: test1},
+		{Key: "b", Value: test2},
+		{Key: "c", Delete: true},
+	}))
+
+	assertGet(a, kvs2, "s1", "a", test1)
+	assertGet(a, kvs2, "s1", "b", test2)
+	assertGetNoExist(a, kvs2, "s1", "c")
+
+	a.NoError(kvs1.Batch("s1", []Operation{
+		{Key: "a", Value: test3},
+		{Key: "b", Delete: true},
+	}))
+
+	assertGet(a, kvs2, "s1", "a", test3)
+	assertGetNoExist(a, kvs2, "s1", "b")
+}
+
+func CommonTestTake(t *testing.T, kvs1 KVStore, kvs2 KVStore) {
+	a := assert.New(t)
+
+	a.NoError(kvs1.Put("s1", "a", test1))
+	a.NoError(kvs1.Put("s1", "b", test2))
+
+	value, exist, err := kvs2.Take("s1", "a")
+	a.NoError(err)
+	a.True(exist)
+	a.Equal(test1, value)
+	assertGetNoExist(a, kvs1, "s1", "a")
+	assertGet(a, kvs1, "s1", "b", test2)
+
+	// an entry can only be taken once
+	_, exist, err = kvs2.Take("s1", "a")
+	a.NoError(err)
+	a.False(exist)
+}
+
 func CommonTestIterate(t *testing.T, kvs1 KVStore, kvs2 KVStore) {
 	a := assert.New(t)
 
@@ -47,24 +90,61 @@ func CommonTestIterate(t *testing.T, kvs1 KVStore, kvs2 KVStore) {
 	a.NoError(kvs1.Put("s1", "buu", test3))
 	a.NoError(kvs1.Put("s2", "bli", test2))
 
-	assertChannelContainsEntries(a, kvs2.Iterate("s1", "bl"),
+	assertChannelContainsEntries(a, kvs2.Iterate(context.Background(), "s1", "bl", 0),
 		[2]string{"bli", string(test1)},
 		[2]string{"bla", string(test2)})
 
-	assertChannelContainsEntries(a, kvs2.Iterate("s1", ""),
+	assertChannelContainsEntries(a, kvs2.Iterate(context.Background(), "s1", "", 0),
 		[2]string{"bli", string(test1)},
 		[2]string{"bla", string(test2)},
 		[2]string{"buu", string(test3)})
 
-	assertChannelContainsEntries(a, kvs2.Iterate("s1", "bla"),
+	assertChannelContainsEntries(a, kvs2.Iterate(context.Background(), "s1", "bla", 0),
 		[2]string{"bla", string(test2)})
 
-	assertChannelContainsEntries(a, kvs2.Iterate("s1", "nothing"))
+	assertChannelContainsEntries(a, kvs2.Iterate(context.Background(), "s1", "nothing", 0))
 
-	assertChannelContainsEntries(a, kvs2.Iterate("s2", ""),
+	assertChannelContainsEntries(a, kvs2.Iterate(context.Background(), "s2", "", 0),
 		[2]string{"bli", string(test2)})
 }
 
+func CommonTestIterateWithLimitAndCancel(t *testing.T, kvs1 KVStore, kvs2 KVStore) {
+	a := assert.New(t)
+
+	a.NoError(kvs1.Put("s1", "bli", test1))
+	a.NoError(kvs1.Put("s1", "bla", test2))
+	a.NoError(kvs1.Put("s1", "buu", test3))
+
+	count := 0
+	for range kvs2.Iterate(context.Background(), "s1", "", 2) {
+		count++
+	}
+	a.Equal(2, count)
+
+	count = 0
+	for range kvs2.IterateKeys(context.Background(), "s1", "b", 1) {
+		count++
+	}
+	a.Equal(1, count)
+
+	// the channel is closed, even if the entries are not consumed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	entriesC := kvs2.Iterate(ctx, "s1", "", 0)
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-entriesC:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			a.Fail("timeout")
+			return
+		}
+	}
+}
+
 func assertChannelContainsEntries(a *assert.Assertions, entryC chan [2]string, expectedEntries ...[2]string) {
 	var allEntries [][2]string
 
@@ -95,18 +175,18 @@ func CommonTestIterateKeys(t *testing.T, kvs1 KVStore, kvs2 KVStore) {
 	a.NoError(kvs1.Put("s1", "buu", test3))
 	a.NoError(kvs1.Put("s2", "bli", test2))
 
-	assertChannelContains(a, kvs2.IterateKeys("s1", "bl"),
+	assertChannelContains(a, kvs2.IterateKeys(context.Background(), "s1", "bl", 0),
 		"bli", "bla")
 
-	assertChannelContains(a, kvs2.IterateKeys("s1", ""),
+	assertChannelContains(a, kvs2.IterateKeys(context.Background(), "s1", "", 0),
 		"bli", "bla", "buu")
 
-	assertChannelContains(a, kvs2.IterateKeys("s1", "bla"),
+	assertChannelContains(a, kvs2.IterateKeys(context.Background(), "s1", "bla", 0),
 		"bla")
 
-	assertChannelContains(a, kvs2.IterateKeys("s1", "nothing"))
+	assertChannelContains(a, kvs2.IterateKeys(context.Background(), "s1", "nothing", 0))
 
-	assertChannelContains(a, kvs2.IterateKeys("s2", ""),
+	assertChannelContains(a, kvs2.IterateKeys(context.Background(), "s2", "", 0),
 		"bli")
 }
 
@@ -4,12 +4,21 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/jinzhu/gorm"
 
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 )
 
 const (
 	responseChannelSize = 100
+
+	// iteratePageSize is the maximum number of rows fetched by a single query when iterating
+	iteratePageSize = 1000
+
+	kvEntryTable = "kv_entry"
 )
 
 type kvEntry struct {
@@ -22,9 +31,13 @@ type kvEntry struct {
 type kvStore struct {
 	db     *gorm.DB
 	logger *log.Entry
+
+	stmts      map[string]*sql.Stmt
+	stmtsMutex sync.Mutex
 }
 
 func (store *kvStore) Stop() error {
+	store.closeStatements()
 	if store.db != nil {
 		err := store.db.Close()
 		store.db = nil
@@ -47,65 +60,226 @@ func (store *kvStore) Check() error {
 }
 
 func (store *kvStore) Put(schema, key string, value []byte) error {
-	if err := store.Delete(schema, key); err != nil {
-		return err
+	return store.Batch(schema, []Operation{{Key: key, Value: value}})
+}
+
+// Batch applies the operations in a single transaction.
+// A Put is implemented as a delete followed by an insert, which also requires the transaction.
+func (store *kvStore) Batch(schema string, ops []Operation) error {
+	tx := store.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	for _, op := range ops {
+		if err := tx.Delete(&kvEntry{Schema: schema, Key: op.Key}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if op.Delete {
+			continue
+		}
+		entry := &kvEntry{Schema: schema, Key: op.Key, Value: op.Value, UpdatedAt: time.Now()}
+		if err := tx.Create(entry).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
-	entry := &kvEntry{Schema: schema, Key: key, Value: value, UpdatedAt: time.Now()}
-	return store.db.Create(entry).Error
+	return tx.Commit().Error
 }
 
 func (store *kvStore) Get(schema, key string) ([]byte, bool, error) {
-	entry := &kvEntry{}
-	if err := store.db.First(&entry, "schema = ? and key = ?", schema, key).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
+	stmt, err := store.prepare(store.getQuery())
+	if err != nil {
+		return nil, false, err
+	}
+
+	var value []byte
+	if err := stmt.QueryRow(schema, key).Scan(&value); err != nil {
+		if err == sql.ErrNoRows {
 			return nil, false, nil
 		}
 		return nil, false, err
 	}
-
-	return entry.Value, true, nil
+	return value, true, nil
 }
 
-func (store *kvStore) Iterate(schema string, keyPrefix string) chan [2]string {
+func (store *kvStore) Iterate(ctx context.Context, schema string, keyPrefix string, limit int) chan [2]string {
 	responseC := make(chan [2]string, responseChannelSize)
 	go func() {
-		rows, err := store.db.Raw("select key, value from kv_entry where schema = ? and key LIKE ?", schema, keyPrefix+"%").
-			Rows()
-		if err != nil {
-			store.logger.WithField("error", err.Error()).Error("Error fetching keys from database")
-		} else {
-			defer rows.Close()
-			for rows.Next() {
-				var key, value string
-				rows.Scan(&key, &value)
-				responseC <- [2]string{key, value}
+		store.iterate(ctx, schema, keyPrefix, limit, true, func(key, value string) bool {
+			select {
+			case responseC <- [2]string{key, value}:
+				return true
+			case <-ctx.Done():
+				return false
 			}
-		}
+		})
 		close(responseC)
 	}()
 	return responseC
 }
 
-func (store *kvStore) IterateKeys(schema string, keyPrefix string) chan string {
+func (store *kvStore) IterateKeys(ctx context.Context, schema string, keyPrefix string, limit int) chan string {
 	responseC := make(chan string, responseChannelSize)
 	go func() {
-		rows, err := store.db.Raw("select key from kv_entry where schema = ? and key LIKE ?", schema, keyPrefix+"%").
-			Rows()
-		if err != nil {
-			store.logger.WithField("error", err.Error()).Error("Error fetching keys from database")
-		} else {
-			defer rows.Close()
-			for rows.Next() {
-				var value string
-				rows.Scan(&value)
-				responseC <- value
+		store.iterate(ctx, schema, keyPrefix, limit, false, func(key, value string) bool {
+			select {
+			case responseC <- key:
+				return true
+			case <-ctx.Done():
+				return false
 			}
-		}
+		})
 		close(responseC)
 	}()
 	return responseC
 }
 
+// iterate fetches the entries matching the schema and key-prefix, ordered by key, one page at a time.
+// Each page starts after the last key of the previous page (keyset pagination),
+// so that large schemas are not held in a single result set.
+// The iteration stops after limit entries (if limit > 0), when the context is done, or when handle returns false.
+func (store *kvStore) iterate(ctx context.Context, schema, keyPrefix string, limit int, withValues bool,
+	handle func(key, value string) bool) {
+
+	stmt, err := store.prepare(store.pageQuery(withValues))
+	if err != nil {
+		return
+	}
+
+	lastKey := ""
+	remaining := limit
+	for {
+		pageSize := iteratePageSize
+		if limit > 0 && remaining < pageSize {
+			pageSize = remaining
+		}
+
+		rows, err := stmt.QueryContext(ctx, schema, keyPrefix+"%", lastKey, pageSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				store.logger.WithField("error", err.Error()).Error("Error fetching keys from database")
+			}
+			return
+		}
+
+		count := 0
+		for rows.Next() {
+			var key, value string
+			if withValues {
+				err = rows.Scan(&key, &value)
+			} else {
+				err = rows.Scan(&key)
+			}
+			if err != nil {
+				store.logger.WithField("error", err.Error()).Error("Error scanning row from database")
+				rows.Close()
+				return
+			}
+			if !handle(key, value) {
+				rows.Close()
+				return
+			}
+			lastKey = key
+			count++
+		}
+		if err := rows.Err(); err != nil && ctx.Err() == nil {
+			store.logger.WithField("error", err.Error()).Error("Error fetching keys from database")
+		}
+		rows.Close()
+
+		remaining -= count
+		if count < pageSize || (limit > 0 && remaining <= 0) {
+			return
+		}
+	}
+}
+
 func (store *kvStore) Delete(schema, key string) error {
 	return store.db.Delete(&kvEntry{Schema: schema, Key: key}).Error
 }
+
+// Take reads the value, and deletes the entry: only the Take whose delete affected the row has taken it.
+func (store *kvStore) Take(schema, key string) ([]byte, bool, error) {
+	value, exist, err := store.Get(schema, key)
+	if err != nil || !exist {
+		return nil, false, err
+	}
+	result := store.db.Delete(&kvEntry{Schema: schema, Key: key})
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, false, nil
+	}
+	return value, true, nil
+}
+
+// prepare returns a prepared statement for the given query, which is cached for the lifetime of the store.
+func (store *kvStore) prepare(query string) (*sql.Stmt, error) {
+	store.stmtsMutex.Lock()
+	defer store.stmtsMutex.Unlock()
+
+	if stmt, ok := store.stmts[query]; ok {
+		return stmt, nil
+	}
+	if store.db == nil {
+		return nil, errors.New("Database is not initialized.")
+	}
+	stmt, err := store.db.DB().Prepare(query)
+	if err != nil {
+		store.logger.WithField("error", err.Error()).WithField("query", query).Error("Error preparing statement")
+		return nil, err
+	}
+	if store.stmts == nil {
+		store.stmts = make(map[string]*sql.Stmt)
+	}
+	store.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (store *kvStore) closeStatements() {
+	store.stmtsMutex.Lock()
+	defer store.stmtsMutex.Unlock()
+
+	for query, stmt := range store.stmts {
+		if err := stmt.Close(); err != nil {
+			store.logger.WithField("error", err.Error()).Error("Error closing prepared statement")
+		}
+		delete(store.stmts, query)
+	}
+}
+
+func (store *kvStore) getQuery() string {
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s AND %s = %s",
+		store.quote("value"), store.quote(kvEntryTable),
+		store.quote("schema"), store.bindVar(1),
+		store.quote("key"), store.bindVar(2))
+}
+
+func (store *kvStore) pageQuery(withValues bool) string {
+	columns := store.quote("key")
+	if withValues {
+		columns += ", " + store.quote("value")
+	}
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s AND %s LIKE %s AND %s > %s ORDER BY %s LIMIT %s",
+		columns, store.quote(kvEntryTable),
+		store.quote("schema"), store.bindVar(1),
+		store.quote("key"), store.bindVar(2),
+		store.quote("key"), store.bindVar(3),
+		store.quote("key"), store.bindVar(4))
+}
+
+// quote returns the identifier quoted according to the SQL dialect of the database
+// (e.g. `key` and `schema` are reserved words in MySQL).
+func (store *kvStore) quote(identifier string) string {
+	return store.db.Dialect().Quote(identifier)
+}
+
+// bindVar returns the placeholder for the i-th (1-based) parameter of a statement.
+func (store *kvStore) bindVar(i int) string {
+	if store.db.Dialect().GetName() == "postgres" {
+		return fmt.Sprintf("$%d", i)
+	}
+	return "?"
+}
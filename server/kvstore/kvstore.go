@@ -1,5 +1,7 @@
 package kvstore
 
+import "context"
+
 // KVStore is an interface for a persistence backend, storing key-value pairs.
 type KVStore interface {
 
@@ -12,13 +14,46 @@ type KVStore interface {
 	// Delete an entry
 	Delete(schema, key string) error
 
-	// Iterate iterates over all entries in the key value store.
-	// The result will be sent to the channel, which is closed after the last entry.
+	// Take deletes an entry and returns the value it had, atomically:
+	// of concurrent Takes of the same entry, only one finds it (exist == true).
+	Take(schema, key string) (value []byte, exist bool, err error)
+
+	// Batch applies the operations on the entries of a schema atomically:
+	// either all of them are stored, or none of them.
+	Batch(schema string, ops []Operation) error
+
+	// Iterate iterates over the entries in the key value store, with keys starting with keyPrefix.
+	// At most limit entries are returned (no limit, if limit <= 0).
+	// The result will be sent to the channel, which is closed after the last entry,
+	// or when the context is done (so that the iteration can be stopped early).
 	// For simplicity, the return type is an string array with key, value.
 	// If you have binary values, you can safely cast back to []byte.
-	Iterate(schema, keyPrefix string) (entries chan [2]string)
+	Iterate(ctx context.Context, schema, keyPrefix string, limit int) (entries chan [2]string)
+
+	// IterateKeys iterates over the keys in the key value store, starting with keyPrefix.
+	// The limit and the context are handled the same as by Iterate.
+	IterateKeys(ctx context.Context, schema, keyPrefix string, limit int) (keys chan string)
+}
+
+// Operation is a change of an entry, applied as part of a Batch: a Put of the Value, or a Delete.
+type Operation struct {
+	Key    string
+	Value  []byte
+	Delete bool
+}
+
+// Change describes a modification of an entry in a KVStore.
+type Change struct {
+	Schema  string
+	Key     string
+	Deleted bool
+}
+
+// Watcher is implemented by the KVStores which can notify about the changes of a schema,
+// including the changes done by other clients of the same backend (e.g. other guble nodes).
+type Watcher interface {
 
-	// IterateKeys iterates over all keys in the key value store.
-	// The keys will be sent to the channel, which is closed after the last entry.
-	IterateKeys(schema, keyPrefix string) (keys chan string)
+	// Watch returns a channel receiving the changes of the given schema.
+	// The channel is closed after the context is done.
+	Watch(ctx context.Context, schema string) (<-chan Change, error)
 }
@@ -1,6 +1,7 @@
 package kvstore
 
 import (
+	"context"
 	"strings"
 	"sync"
 )
@@ -47,18 +48,54 @@ func (kvStore *MemoryKVStore) Delete(schema, key string) error {
 	return nil
 }
 
+// Take implements the `kvstore` Take func.
+func (kvStore *MemoryKVStore) Take(schema, key string) ([]byte, bool, error) {
+	kvStore.mutex.Lock()
+	defer kvStore.mutex.Unlock()
+	s := kvStore.getSchema(schema)
+	v, ok := s[key]
+	delete(s, key)
+	return v, ok, nil
+}
+
+// Batch implements the `kvstore` Batch func.
+func (kvStore *MemoryKVStore) Batch(schema string, ops []Operation) error {
+	kvStore.mutex.Lock()
+	defer kvStore.mutex.Unlock()
+	s := kvStore.getSchema(schema)
+	for _, op := range ops {
+		if op.Delete {
+			delete(s, op.Key)
+		} else {
+			s[op.Key] = op.Value
+		}
+	}
+	return nil
+}
+
 // Iterate iterates over the key-value pairs in the schema, with keys matching the keyPrefix.
 // TODO: this can lead to a deadlock, if the consumer modifies the store while receiving and the channel blocks
-func (kvStore *MemoryKVStore) Iterate(schema string, keyPrefix string) chan [2]string {
+func (kvStore *MemoryKVStore) Iterate(ctx context.Context, schema string, keyPrefix string, limit int) chan [2]string {
 	responseChan := make(chan [2]string, 100)
 	kvStore.mutex.Lock()
 	s := kvStore.getSchema(schema)
 	kvStore.mutex.Unlock()
 	go func() {
 		kvStore.mutex.Lock()
+		count := 0
 		for key, value := range s {
+			if limit > 0 && count >= limit {
+				break
+			}
 			if strings.HasPrefix(key, keyPrefix) {
-				responseChan <- [2]string{key, string(value)}
+				select {
+				case responseChan <- [2]string{key, string(value)}:
+					count++
+				case <-ctx.Done():
+				}
+			}
+			if ctx.Err() != nil {
+				break
 			}
 		}
 		kvStore.mutex.Unlock()
@@ -69,16 +106,27 @@ func (kvStore *MemoryKVStore) Iterate(schema string, keyPrefix string) chan [2]s
 
 // IterateKeys iterates over the keys in the schema, matching the keyPrefix.
 // TODO: this can lead to a deadlock, if the consumer modifies the store while receiving and the channel blocks
-func (kvStore *MemoryKVStore) IterateKeys(schema string, keyPrefix string) chan string {
+func (kvStore *MemoryKVStore) IterateKeys(ctx context.Context, schema string, keyPrefix string, limit int) chan string {
 	responseChan := make(chan string, 100)
 	kvStore.mutex.Lock()
 	s := kvStore.getSchema(schema)
 	kvStore.mutex.Unlock()
 	go func() {
 		kvStore.mutex.Lock()
+		count := 0
 		for key := range s {
+			if limit > 0 && count >= limit {
+				break
+			}
 			if strings.HasPrefix(key, keyPrefix) {
-				responseChan <- key
+				select {
+				case responseChan <- key:
+					count++
+				case <-ctx.Done():
+				}
+			}
+			if ctx.Err() != nil {
+				break
 			}
 		}
 		kvStore.mutex.Unlock()
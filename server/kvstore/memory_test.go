@@ -9,11 +9,26 @@ func TestMemoryPutGetDelete(t *testing.T) {
 	CommonTestPutGetDelete(t, mkvs, mkvs)
 }
 
+func TestMemoryBatch(t *testing.T) {
+	mkvs := NewMemoryKVStore()
+	CommonTestBatch(t, mkvs, mkvs)
+}
+
+func TestMemoryTake(t *testing.T) {
+	mkvs := NewMemoryKVStore()
+	CommonTestTake(t, mkvs, mkvs)
+}
+
 func TestMemoryIterateKeys(t *testing.T) {
 	mkvs := NewMemoryKVStore()
 	CommonTestIterateKeys(t, mkvs, mkvs)
 }
 
+func TestMemoryIterateWithLimitAndCancel(t *testing.T) {
+	mkvs := NewMemoryKVStore()
+	CommonTestIterateWithLimitAndCancel(t, mkvs, mkvs)
+}
+
 func TestMemoryIterate(t *testing.T) {
 	mkvs := NewMemoryKVStore()
 	CommonTestIterate(t, mkvs, mkvs)
@@ -0,0 +1,78 @@
+package kvstore
+
+import (
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/jinzhu/gorm"
+
+	// use gorm's mysql dialect
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+)
+
+const mysqlGormLogMode = false
+
+// mysqlMigrations are the statements creating the database schema, applied in order when opening the store.
+// The kv_entry table is created explicitly (instead of using gorm's AutoMigrate), because MySQL needs
+// a binary collation for the keys (case-sensitive, byte-wise ordering) and a BLOB type for the values.
+var mysqlMigrations = []string{
+	"CREATE TABLE IF NOT EXISTS `kv_entry` (" +
+		"`schema` VARCHAR(200) NOT NULL, " +
+		"`key` VARCHAR(200) NOT NULL, " +
+		"`value` LONGBLOB, " +
+		"`updated_at` DATETIME NULL, " +
+		"PRIMARY KEY (`schema`, `key`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin",
+}
+
+// MySQLKVStore extends a gorm-based kvStore with a MySQL-specific configuration.
+type MySQLKVStore struct {
+	*kvStore
+	config MySQLConfig
+}
+
+// NewMySQLKVStore returns a new configured MySQLKVStore (not opened yet).
+func NewMySQLKVStore(mysqlConfig MySQLConfig) *MySQLKVStore {
+	return &MySQLKVStore{
+		kvStore: &kvStore{logger: log.WithFields(log.Fields{"module": "kv-mysql"})},
+		config:  mysqlConfig,
+	}
+}
+
+// Open a connection to MySQL database, or return an error.
+func (kvStore *MySQLKVStore) Open() error {
+	logger := kvStore.logger.WithFields(log.Fields{
+		"host":   kvStore.config.Host,
+		"port":   kvStore.config.Port,
+		"dbname": kvStore.config.DbName,
+	})
+	logger.Info("Opening database")
+
+	gormdb, err := gorm.Open("mysql", kvStore.config.connectionString())
+	if err != nil {
+		logger.WithError(err).Error("Error opening database")
+		return err
+	}
+
+	if err := gormdb.DB().Ping(); err != nil {
+		logger.WithError(err).Error("Error pinging database")
+		return err
+	}
+	logger.Info("Ping reply from database")
+
+	gormdb.LogMode(mysqlGormLogMode)
+	gormdb.SingularTable(true)
+	gormdb.DB().SetMaxIdleConns(kvStore.config.MaxIdleConns)
+	gormdb.DB().SetMaxOpenConns(kvStore.config.MaxOpenConns)
+	gormdb.DB().SetConnMaxLifetime(kvStore.config.ConnMaxLifetime)
+
+	for _, migration := range mysqlMigrations {
+		if err := gormdb.Exec(migration).Error; err != nil {
+			logger.WithError(err).Error("Error in schema migration")
+			return err
+		}
+	}
+
+	logger.Info("Ensured database schema")
+	kvStore.db = gormdb
+	return nil
+}
@@ -0,0 +1,34 @@
+package kvstore
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// MySQLConfig is the configuration of a MySQL connection (host, dbname etc.),
+// extended with connection-pool parameters (e.g. number of open / idle connections).
+type MySQLConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	DbName   string
+
+	// Params are additional DSN parameters of the go-sql-driver (e.g. "timeout", "tls")
+	Params map[string]string
+
+	MaxIdleConns    int
+	MaxOpenConns    int
+	ConnMaxLifetime time.Duration
+}
+
+func (mc MySQLConfig) connectionString() string {
+	values := url.Values{}
+	for key, value := range mc.Params {
+		values.Set(key, value)
+	}
+	// required for scanning the DATETIME columns into time.Time
+	values.Set("parseTime", "true")
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?%s", mc.User, mc.Password, mc.Host, mc.Port, mc.DbName, values.Encode())
+}
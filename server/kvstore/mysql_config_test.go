@@ -0,0 +1,21 @@
+package kvstore
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMySQLConfig_String(t *testing.T) {
+	a := assert.New(t)
+	mc0 := MySQLConfig{Host: "localhost", Port: 3306, User: "guble", Password: "secret", DbName: "guble"}
+	a.Equal("guble:secret@tcp(localhost:3306)/guble?parseTime=true", mc0.connectionString())
+
+	mc1 := MySQLConfig{
+		Host:   "db",
+		Port:   3307,
+		User:   "user",
+		DbName: "kv",
+		Params: map[string]string{"timeout": "5s", "parseTime": "false"},
+	}
+	a.Equal("user:@tcp(db:3307)/kv?parseTime=true&timeout=5s", mc1.connectionString())
+}
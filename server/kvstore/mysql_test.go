@@ -0,0 +1,94 @@
+package kvstore
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func BenchmarkMySQLKVStore_PutGet(b *testing.B) {
+	kvs := NewMySQLKVStore(aMySQLConfig())
+	kvs.Open()
+	CommonBenchmarkPutGet(b, kvs)
+}
+
+func TestMySQLKVStore_PutGetDelete(t *testing.T) {
+	kvs := NewMySQLKVStore(aMySQLConfig())
+	kvs.Open()
+	CommonTestPutGetDelete(t, kvs, kvs)
+}
+
+func TestMySQLKVStore_Batch(t *testing.T) {
+	kvs := NewMySQLKVStore(aMySQLConfig())
+	kvs.Open()
+	CommonTestBatch(t, kvs, kvs)
+}
+
+func TestMySQLKVStore_Take(t *testing.T) {
+	kvs := NewMySQLKVStore(aMySQLConfig())
+	kvs.Open()
+	CommonTestTake(t, kvs, kvs)
+}
+
+func TestMySQLKVStore_Iterate(t *testing.T) {
+	kvs := NewMySQLKVStore(aMySQLConfig())
+	kvs.Open()
+	CommonTestIterate(t, kvs, kvs)
+}
+
+func TestMySQLKVStore_IterateKeys(t *testing.T) {
+	kvs := NewMySQLKVStore(aMySQLConfig())
+	kvs.Open()
+	CommonTestIterateKeys(t, kvs, kvs)
+}
+
+func TestMySQLKVStore_IterateWithLimitAndCancel(t *testing.T) {
+	kvs := NewMySQLKVStore(aMySQLConfig())
+	kvs.Open()
+	CommonTestIterateWithLimitAndCancel(t, kvs, kvs)
+}
+
+func TestMySQLKVStore_Check(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := NewMySQLKVStore(aMySQLConfig())
+	kvs.Open()
+
+	err := kvs.Check()
+	a.NoError(err, "Db ping should work")
+
+	kvs.Stop()
+
+	err = kvs.Check()
+	a.NotNil(err, "Check should fail because db was already closed")
+}
+
+func TestMySQLKVStore_Open(t *testing.T) {
+	kvs := NewMySQLKVStore(invalidMySQLConfig())
+	err := kvs.Open()
+	assert.NotNil(t, err)
+}
+
+// This config assumes a MySQL running locally
+func aMySQLConfig() MySQLConfig {
+	return MySQLConfig{
+		Host:         "localhost",
+		Port:         3306,
+		User:         "root",
+		Password:     "",
+		DbName:       "guble",
+		MaxIdleConns: 1,
+		MaxOpenConns: 1,
+	}
+}
+
+func invalidMySQLConfig() MySQLConfig {
+	return MySQLConfig{
+		Host:         "localhost",
+		Port:         3306,
+		User:         "",
+		Password:     "",
+		DbName:       "",
+		MaxIdleConns: 1,
+		MaxOpenConns: 1,
+	}
+}
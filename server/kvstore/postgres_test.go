@@ -17,6 +17,18 @@ func TestPostgresKVStore_PutGetDelete(t *testing.T) {
 	CommonTestPutGetDelete(t, kvs, kvs)
 }
 
+func TestPostgresKVStore_Batch(t *testing.T) {
+	kvs := NewPostgresKVStore(aPostgresConfig())
+	kvs.Open()
+	CommonTestBatch(t, kvs, kvs)
+}
+
+func TestPostgresKVStore_Take(t *testing.T) {
+	kvs := NewPostgresKVStore(aPostgresConfig())
+	kvs.Open()
+	CommonTestTake(t, kvs, kvs)
+}
+
 func TestPostgresKVStore_Iterate(t *testing.T) {
 	kvs := NewPostgresKVStore(aPostgresConfig())
 	kvs.Open()
@@ -29,6 +41,12 @@ func TestPostgresKVStore_IterateKeys(t *testing.T) {
 	CommonTestIterateKeys(t, kvs, kvs)
 }
 
+func TestPostgresKVStore_IterateWithLimitAndCancel(t *testing.T) {
+	kvs := NewPostgresKVStore(aPostgresConfig())
+	kvs.Open()
+	CommonTestIterateWithLimitAndCancel(t, kvs, kvs)
+}
+
 func TestPostgresKVStore_Check(t *testing.T) {
 	a := assert.New(t)
 
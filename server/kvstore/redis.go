@@ -0,0 +1,378 @@
+package kvstore
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/garyburd/redigo/redis"
+
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	redisKeyPrefix     = "guble"
+	redisScanCount     = 1000
+	redisIdleTimeout   = 4 * time.Minute
+	redisRetryInterval = time.Second
+
+	// redisKeyspaceEvents enables the keyspace notifications for generic (DEL) and string (SET) commands
+	redisKeyspaceEvents = "Kg$"
+)
+
+// ErrNotificationsDisabled is returned by RedisKVStore.Watch when the keyspace notifications are not enabled.
+var ErrNotificationsDisabled = errors.New("Redis keyspace notifications are disabled.")
+
+// RedisConfig is used for configuring the Redis connection.
+type RedisConfig struct {
+	Addr     string
+	Password string
+	DB       int
+
+	// Notifications enables the keyspace notifications (required by Watch)
+	Notifications bool
+
+	MaxIdleConns int
+	MaxOpenConns int
+}
+
+// RedisKVStore is a KVStore backed by Redis. Every entry is stored as a string value under the key
+// "guble:<schema>:<key>", so that the keyspace notifications can be used for watching the changes.
+type RedisKVStore struct {
+	config RedisConfig
+	pool   *redis.Pool
+	logger *log.Entry
+}
+
+// NewRedisKVStore returns a new configured RedisKVStore (not opened yet).
+func NewRedisKVStore(config RedisConfig) *RedisKVStore {
+	return &RedisKVStore{
+		config: config,
+		logger: log.WithFields(log.Fields{
+			"module": "kv-redis",
+			"addr":   config.Addr,
+			"db":     config.DB,
+		}),
+	}
+}
+
+// Open creates the connection pool, pings the server and enables the keyspace notifications if configured.
+func (kvStore *RedisKVStore) Open() error {
+	kvStore.logger.Info("Opening redis connection")
+
+	kvStore.pool = &redis.Pool{
+		MaxIdle:     kvStore.config.MaxIdleConns,
+		MaxActive:   kvStore.config.MaxOpenConns,
+		IdleTimeout: redisIdleTimeout,
+		Wait:        true,
+		Dial:        kvStore.dial,
+		TestOnBorrow: func(conn redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+	}
+
+	if err := kvStore.Check(); err != nil {
+		return err
+	}
+	kvStore.logger.Info("Ping reply from redis")
+
+	if kvStore.config.Notifications {
+		conn := kvStore.pool.Get()
+		defer conn.Close()
+		if _, err := conn.Do("CONFIG", "SET", "notify-keyspace-events", redisKeyspaceEvents); err != nil {
+			// e.g. hosted Redis services disallow CONFIG; the notifications have to be enabled by the provider
+			kvStore.logger.WithError(err).Warn("Could not enable keyspace notifications")
+		}
+	}
+	return nil
+}
+
+func (kvStore *RedisKVStore) dial() (redis.Conn, error) {
+	return redis.Dial("tcp", kvStore.config.Addr,
+		redis.DialPassword(kvStore.config.Password),
+		redis.DialDatabase(kvStore.config.DB))
+}
+
+// Stop closes the connection pool.
+func (kvStore *RedisKVStore) Stop() error {
+	if kvStore.pool != nil {
+		err := kvStore.pool.Close()
+		kvStore.pool = nil
+		return err
+	}
+	return nil
+}
+
+// Check implements the health-check, by pinging the Redis server.
+func (kvStore *RedisKVStore) Check() error {
+	if kvStore.pool == nil {
+		errorMessage := "Error: Redis connection pool is not initialized (nil)"
+		kvStore.logger.Error(errorMessage)
+		return errors.New(errorMessage)
+	}
+	conn := kvStore.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		kvStore.logger.WithError(err).Error("Error pinging redis")
+		return err
+	}
+	return nil
+}
+
+// Put implements the `kvstore` Put func.
+func (kvStore *RedisKVStore) Put(schema, key string, value []byte) error {
+	conn := kvStore.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", redisKey(schema, key), value)
+	return err
+}
+
+// Get implements the `kvstore` Get func.
+func (kvStore *RedisKVStore) Get(schema, key string) ([]byte, bool, error) {
+	conn := kvStore.pool.Get()
+	defer conn.Close()
+	value, err := redis.Bytes(conn.Do("GET", redisKey(schema, key)))
+	if err == redis.ErrNil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Delete implements the `kvstore` Delete func.
+func (kvStore *RedisKVStore) Delete(schema, key string) error {
+	conn := kvStore.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", redisKey(schema, key))
+	return err
+}
+
+// Take gets and deletes the key in a MULTI / EXEC transaction.
+func (kvStore *RedisKVStore) Take(schema, key string) ([]byte, bool, error) {
+	conn := kvStore.pool.Get()
+	defer conn.Close()
+
+	k := redisKey(schema, key)
+	conn.Send("MULTI")
+	conn.Send("GET", k)
+	conn.Send("DEL", k)
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(replies) != 2 || replies[0] == nil {
+		return nil, false, nil
+	}
+	value, err := redis.Bytes(replies[0], nil)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Batch applies the operations in a MULTI / EXEC transaction.
+func (kvStore *RedisKVStore) Batch(schema string, ops []Operation) error {
+	conn := kvStore.pool.Get()
+	defer conn.Close()
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		var err error
+		if op.Delete {
+			err = conn.Send("DEL", redisKey(schema, op.Key))
+		} else {
+			err = conn.Send("SET", redisKey(schema, op.Key), op.Value)
+		}
+		if err != nil {
+			conn.Do("DISCARD")
+			return err
+		}
+	}
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// Iterate iterates over the key-value pairs in the schema, with keys matching the keyPrefix.
+func (kvStore *RedisKVStore) Iterate(ctx context.Context, schema, keyPrefix string, limit int) chan [2]string {
+	responseC := make(chan [2]string, responseChannelSize)
+	go func() {
+		kvStore.scan(ctx, schema, keyPrefix, limit, func(conn redis.Conn, keys []string) (int, error) {
+			values, err := redis.Values(conn.Do("MGET", redis.Args{}.AddFlat(keys)...))
+			if err != nil {
+				return 0, err
+			}
+			sent := 0
+			for i, value := range values {
+				// nil is returned for the keys deleted in the meantime (unlike the empty values, which are iterated)
+				if value == nil {
+					continue
+				}
+				data, err := redis.String(value, nil)
+				if err != nil {
+					return sent, err
+				}
+				select {
+				case responseC <- [2]string{keys[i][len(redisKey(schema, "")):], data}:
+					sent++
+				case <-ctx.Done():
+					return sent, ctx.Err()
+				}
+			}
+			return sent, nil
+		})
+		close(responseC)
+	}()
+	return responseC
+}
+
+// IterateKeys iterates over the keys in the schema, matching the keyPrefix.
+func (kvStore *RedisKVStore) IterateKeys(ctx context.Context, schema, keyPrefix string, limit int) chan string {
+	responseC := make(chan string, responseChannelSize)
+	go func() {
+		kvStore.scan(ctx, schema, keyPrefix, limit, func(conn redis.Conn, keys []string) (int, error) {
+			for i, key := range keys {
+				select {
+				case responseC <- key[len(redisKey(schema, "")):]:
+				case <-ctx.Done():
+					return i, ctx.Err()
+				}
+			}
+			return len(keys), nil
+		})
+		close(responseC)
+	}()
+	return responseC
+}
+
+// scan uses the SCAN cursor for fetching the keys in batches, so that the server is not blocked
+// for large schemas (as opposed to KEYS).
+// The batches passed to handle are truncated, so that at most limit entries are handled (if limit > 0).
+func (kvStore *RedisKVStore) scan(ctx context.Context, schema, keyPrefix string, limit int,
+	handle func(redis.Conn, []string) (int, error)) {
+
+	conn := kvStore.pool.Get()
+	defer conn.Close()
+
+	pattern := redisKey(schema, escapeGlob(keyPrefix)) + "*"
+	cursor := 0
+	handled := 0
+	for ctx.Err() == nil {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", redisScanCount))
+		if err != nil {
+			kvStore.logger.WithError(err).Error("Error scanning keys from redis")
+			return
+		}
+		var keys []string
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			kvStore.logger.WithError(err).Error("Error scanning keys from redis")
+			return
+		}
+		if limit > 0 && len(keys) > limit-handled {
+			keys = keys[:limit-handled]
+		}
+		if len(keys) > 0 {
+			n, err := handle(conn, keys)
+			if err != nil {
+				if ctx.Err() == nil {
+					kvStore.logger.WithError(err).Error("Error fetching values from redis")
+				}
+				return
+			}
+			handled += n
+		}
+		if cursor == 0 || (limit > 0 && handled >= limit) {
+			return
+		}
+	}
+}
+
+// Watch implements the Watcher interface, using the Redis keyspace notifications.
+// Changes done while the subscription is being reestablished (e.g. after a connection loss) are not received.
+func (kvStore *RedisKVStore) Watch(ctx context.Context, schema string) (<-chan Change, error) {
+	if !kvStore.config.Notifications {
+		return nil, ErrNotificationsDisabled
+	}
+
+	channelPrefix := fmt.Sprintf("__keyspace@%d__:%s", kvStore.config.DB, redisKey(schema, ""))
+	changesC := make(chan Change, responseChannelSize)
+	go func() {
+		defer close(changesC)
+		for {
+			err := kvStore.receiveChanges(ctx, channelPrefix, func(key, event string) {
+				change := Change{Schema: schema, Key: key}
+				switch event {
+				case "set":
+				case "del", "expired":
+					change.Deleted = true
+				default:
+					return
+				}
+				select {
+				case changesC <- change:
+				case <-ctx.Done():
+				}
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			kvStore.logger.WithError(err).WithField("schema", schema).Error("Error receiving keyspace notifications")
+			select {
+			case <-time.After(redisRetryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changesC, nil
+}
+
+func (kvStore *RedisKVStore) receiveChanges(ctx context.Context, channelPrefix string, handle func(key, event string)) error {
+	conn, err := kvStore.dial()
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+
+	if err := psc.PSubscribe(escapeGlob(channelPrefix) + "*"); err != nil {
+		return err
+	}
+
+	// closing the connection makes Receive return, when the context is done
+	stopC := make(chan struct{})
+	defer close(stopC)
+	go func() {
+		select {
+		case <-ctx.Done():
+			psc.Close()
+		case <-stopC:
+		}
+	}()
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.PMessage:
+			handle(strings.TrimPrefix(v.Channel, channelPrefix), string(v.Data))
+		case error:
+			return v
+		}
+	}
+}
+
+func redisKey(schema, key string) string {
+	return redisKeyPrefix + ":" + schema + ":" + key
+}
+
+// escapeGlob escapes the special characters of the glob-style patterns used by SCAN and PSUBSCRIBE.
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
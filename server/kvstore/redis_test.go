@@ -0,0 +1,153 @@
+package kvstore
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"context"
+	"testing"
+	"time"
+)
+
+func BenchmarkRedisKVStore_PutGet(b *testing.B) {
+	kvs := NewRedisKVStore(aRedisConfig())
+	kvs.Open()
+	CommonBenchmarkPutGet(b, kvs)
+}
+
+func TestRedisKVStore_PutGetDelete(t *testing.T) {
+	kvs := NewRedisKVStore(aRedisConfig())
+	kvs.Open()
+	CommonTestPutGetDelete(t, kvs, kvs)
+}
+
+func TestRedisKVStore_Batch(t *testing.T) {
+	kvs := NewRedisKVStore(aRedisConfig())
+	kvs.Open()
+	CommonTestBatch(t, kvs, kvs)
+}
+
+func TestRedisKVStore_Take(t *testing.T) {
+	kvs := NewRedisKVStore(aRedisConfig())
+	kvs.Open()
+	CommonTestTake(t, kvs, kvs)
+}
+
+func TestRedisKVStore_Iterate(t *testing.T) {
+	kvs := NewRedisKVStore(aRedisConfig())
+	kvs.Open()
+	CommonTestIterate(t, kvs, kvs)
+}
+
+func TestRedisKVStore_IterateEmptyValues(t *testing.T) {
+	a := assert.New(t)
+	kvs := NewRedisKVStore(aRedisConfig())
+	kvs.Open()
+
+	a.NoError(kvs.Put("empty", "a", []byte{}))
+	a.NoError(kvs.Put("empty", "b", test1))
+	defer kvs.Delete("empty", "a")
+	defer kvs.Delete("empty", "b")
+
+	assertChannelContainsEntries(a, kvs.Iterate(context.Background(), "empty", "", 0),
+		[2]string{"a", ""},
+		[2]string{"b", string(test1)})
+}
+
+func TestRedisKVStore_IterateKeys(t *testing.T) {
+	kvs := NewRedisKVStore(aRedisConfig())
+	kvs.Open()
+	CommonTestIterateKeys(t, kvs, kvs)
+}
+
+func TestRedisKVStore_IterateWithLimitAndCancel(t *testing.T) {
+	kvs := NewRedisKVStore(aRedisConfig())
+	kvs.Open()
+	CommonTestIterateWithLimitAndCancel(t, kvs, kvs)
+}
+
+func TestRedisKVStore_Check(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := NewRedisKVStore(aRedisConfig())
+	kvs.Open()
+
+	err := kvs.Check()
+	a.NoError(err, "Redis ping should work")
+
+	kvs.Stop()
+
+	err = kvs.Check()
+	a.NotNil(err, "Check should fail because the pool was already closed")
+}
+
+func TestRedisKVStore_Open(t *testing.T) {
+	config := aRedisConfig()
+	config.Addr = "localhost:1"
+	kvs := NewRedisKVStore(config)
+	err := kvs.Open()
+	assert.NotNil(t, err)
+}
+
+func TestRedisKVStore_WatchDisabled(t *testing.T) {
+	config := aRedisConfig()
+	config.Notifications = false
+	kvs := NewRedisKVStore(config)
+
+	_, err := kvs.Watch(context.Background(), "s1")
+	assert.Equal(t, ErrNotificationsDisabled, err)
+}
+
+func TestRedisKVStore_Watch(t *testing.T) {
+	a := assert.New(t)
+
+	// two stores simulate two guble nodes
+	kvs1 := NewRedisKVStore(aRedisConfig())
+	a.NoError(kvs1.Open())
+	kvs2 := NewRedisKVStore(aRedisConfig())
+	a.NoError(kvs2.Open())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changesC, err := kvs2.Watch(ctx, "watch")
+	a.NoError(err)
+	// wait for the subscription to be established
+	time.Sleep(100 * time.Millisecond)
+
+	a.NoError(kvs1.Put("watch", "a", test1))
+	a.NoError(kvs1.Delete("watch", "a"))
+
+	a.Equal(Change{Schema: "watch", Key: "a"}, receiveChange(a, changesC))
+	a.Equal(Change{Schema: "watch", Key: "a", Deleted: true}, receiveChange(a, changesC))
+
+	cancel()
+	select {
+	case _, ok := <-changesC:
+		a.False(ok, "channel should be closed after the context is done")
+	case <-time.After(time.Second):
+		a.Fail("timeout")
+	}
+}
+
+func TestEscapeGlob(t *testing.T) {
+	assert.Equal(t, `a\*b\?c\[d\]e\\f`, escapeGlob(`a*b?c[d]e\f`))
+}
+
+func receiveChange(a *assert.Assertions, changesC <-chan Change) Change {
+	select {
+	case change := <-changesC:
+		return change
+	case <-time.After(time.Second):
+		a.Fail("timeout")
+	}
+	return Change{}
+}
+
+// This config assumes a redis running locally
+func aRedisConfig() RedisConfig {
+	return RedisConfig{
+		Addr:          "localhost:6379",
+		DB:            0,
+		Notifications: true,
+		MaxIdleConns:  1,
+		MaxOpenConns:  2,
+	}
+}
@@ -1,6 +1,8 @@
 package kvstore
 
 import (
+	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
@@ -24,6 +26,24 @@ func TestSqlitePutGetDelete(t *testing.T) {
 	CommonTestPutGetDelete(t, db, db)
 }
 
+func TestSqliteBatch(t *testing.T) {
+	f := tempFilename()
+	defer os.Remove(f)
+
+	db := NewSqliteKVStore(f, false)
+	db.Open()
+	CommonTestBatch(t, db, db)
+}
+
+func TestSqliteTake(t *testing.T) {
+	f := tempFilename()
+	defer os.Remove(f)
+
+	db := NewSqliteKVStore(f, false)
+	db.Open()
+	CommonTestTake(t, db, db)
+}
+
 func TestSqliteIterate(t *testing.T) {
 	f := tempFilename()
 	defer os.Remove(f)
@@ -44,6 +64,43 @@ func TestSqliteIterateKeys(t *testing.T) {
 	CommonTestIterateKeys(t, db, db)
 }
 
+func TestSqliteIterateWithLimitAndCancel(t *testing.T) {
+	f := tempFilename()
+	defer os.Remove(f)
+
+	db := NewSqliteKVStore(f, false)
+	db.Open()
+	CommonTestIterateWithLimitAndCancel(t, db, db)
+}
+
+func TestSqliteIterateMultiplePages(t *testing.T) {
+	a := assert.New(t)
+	f := tempFilename()
+	defer os.Remove(f)
+
+	db := NewSqliteKVStore(f, false)
+	db.Open()
+
+	n := iteratePageSize + 10
+	for i := 0; i < n; i++ {
+		a.NoError(db.Put("s1", fmt.Sprintf("key%05d", i), test1))
+	}
+
+	count := 0
+	for key := range db.IterateKeys(context.Background(), "s1", "key", 0) {
+		a.Equal(fmt.Sprintf("key%05d", count), key)
+		count++
+	}
+	a.Equal(n, count)
+
+	// the limit spans multiple pages
+	count = 0
+	for range db.IterateKeys(context.Background(), "s1", "key", iteratePageSize+5) {
+		count++
+	}
+	a.Equal(iteratePageSize+5, count)
+}
+
 func TestCheck_SqlKVStore(t *testing.T) {
 	a := assert.New(t)
 	f := tempFilename()
@@ -31,5 +31,26 @@ func NewMap(name string) Map {
 	return &dummyMap{}
 }
 
+type dummyErrors struct{}
+
+// Dummy functions on dummyErrors
+func (v *dummyErrors) Add(category string) {}
+func (v *dummyErrors) Reset()              {}
+
+// NewErrors returns a dummyErrors, depending on the build tag declared at the beginning of this file.
+func NewErrors(name string) Errors {
+	return &dummyErrors{}
+}
+
+type dummyHistograms struct{}
+
+// Dummy functions on dummyHistograms
+func (v *dummyHistograms) Observe(group, key string, d time.Duration) {}
+
+// NewHistograms returns a dummyHistograms, depending on the build tag declared at the beginning of this file.
+func NewHistograms(name string) Histograms {
+	return &dummyHistograms{}
+}
+
 func RegisterInterval(m Map, td time.Duration, reset func(Map, time.Time), processAndReset func(Map, time.Duration, time.Time)) {
 }
@@ -17,6 +17,22 @@ func NewMap(name string) Map {
 	return expvar.NewMap(name)
 }
 
+// NewErrors returns an Errors metric published as an expvar, depending on the absence of build tag
+// declared at the beginning of this file
+func NewErrors(name string) Errors {
+	e := newErrors()
+	expvar.Publish(name, e)
+	return e
+}
+
+// NewHistograms returns a Histograms metric published as an expvar, depending on the absence of build tag
+// declared at the beginning of this file
+func NewHistograms(name string) Histograms {
+	hs := newHistograms()
+	expvar.Publish(name, hs)
+	return hs
+}
+
 func RegisterInterval(ctx context.Context, m Map, td time.Duration, reset func(Map, time.Time), processAndReset func(Map, time.Duration, time.Time)) {
 	reset(m, time.Now())
 	go func(m Map, td time.Duration, processAndReset func(Map, time.Duration, time.Time)) {
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SendError is the category of the errors sending a request to a provider (e.g. network errors and timeouts),
+// as opposed to the errors responded by the provider.
+const SendError = "SendError"
+
+// maxErrorCategories bounds the number of categories of an Errors metric,
+// the errors of further categories being counted in the category "Other".
+const maxErrorCategories = 100
+
+// Errors is an interface for counting the errors by category (e.g. the reasons of the provider of a connector).
+type Errors interface {
+	Add(category string)
+	Reset()
+}
+
+type errorCount struct {
+	total int64
+	last  time.Time
+}
+
+// categoryErrors is an Errors metric, whose JSON value holds for each category the number of its errors
+// and the time of the last one, e.g. `{"BadDeviceToken": {"total": 12, "last": "2017-03-01T12:00:00Z"}}`.
+type categoryErrors struct {
+	mu     sync.Mutex
+	counts map[string]*errorCount
+}
+
+func newErrors() *categoryErrors {
+	return &categoryErrors{counts: make(map[string]*errorCount)}
+}
+
+// Add counts an error of the category.
+func (e *categoryErrors) Add(category string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	count, ok := e.counts[category]
+	if !ok {
+		if len(e.counts) >= maxErrorCategories {
+			category = "Other"
+			count = e.counts[category]
+		}
+		if count == nil {
+			count = &errorCount{}
+			e.counts[category] = count
+		}
+	}
+	count.total++
+	count.last = time.Now()
+}
+
+// Reset removes all the counted errors.
+func (e *categoryErrors) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.counts = make(map[string]*errorCount)
+}
+
+// String returns the JSON value of the metric, with the categories sorted.
+// It is a part of the expvar.Var implementation.
+func (e *categoryErrors) String() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	categories := make([]string, 0, len(e.counts))
+	for category := range e.counts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	var buff bytes.Buffer
+	buff.WriteString("{")
+	for i, category := range categories {
+		if i > 0 {
+			buff.WriteString(", ")
+		}
+		count := e.counts[category]
+		fmt.Fprintf(&buff, `%q: {"total": %d, "last": %q}`, category, count.total, count.last.UTC().Format(time.RFC3339))
+	}
+	buff.WriteString("}")
+	return buff.String()
+}
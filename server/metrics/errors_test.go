@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrors(t *testing.T) {
+	a := assert.New(t)
+
+	e := NewErrors("test_errors")
+	a.Equal("{}", e.(expvar.Var).String())
+
+	e.Add("Unregistered")
+	e.Add("BadDeviceToken")
+	e.Add("Unregistered")
+
+	var value map[string]struct {
+		Total int64
+		Last  string
+	}
+	a.NoError(json.Unmarshal([]byte(e.(expvar.Var).String()), &value))
+	a.Len(value, 2)
+	a.Equal(int64(2), value["Unregistered"].Total)
+	a.Equal(int64(1), value["BadDeviceToken"].Total)
+	last, err := time.Parse(time.RFC3339, value["Unregistered"].Last)
+	a.NoError(err)
+	a.WithinDuration(time.Now(), last, 2*time.Second)
+
+	e.Reset()
+	a.Equal("{}", e.(expvar.Var).String())
+}
+
+func TestErrors_BoundsTheCategories(t *testing.T) {
+	a := assert.New(t)
+
+	e := newErrors()
+	for i := 0; i < maxErrorCategories+10; i++ {
+		e.Add(fmt.Sprintf("reason%d", i))
+	}
+	a.Len(e.counts, maxErrorCategories+1)
+	a.Equal(int64(10), e.counts["Other"].total)
+}
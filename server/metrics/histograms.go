@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxHistogramKeys bounds the number of keys of a group of Histograms,
+// the durations of further keys being recorded with the key "other".
+const maxHistogramKeys = 100
+
+// histogramBounds are the upper bounds of the buckets of a histogram, in milliseconds.
+var histogramBounds = []int64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+// Histograms is an interface for recording the distributions of durations by group and key
+// (e.g. the delivery latencies by connector and topic).
+type Histograms interface {
+	Observe(group, key string, d time.Duration)
+}
+
+type histogram struct {
+	// counts holds the number of durations of each bucket, the last one counting the durations above all the bounds
+	counts []int64
+	total  int64
+	max    time.Duration
+}
+
+func (h *histogram) observe(d time.Duration) {
+	ms := int64(d / time.Millisecond)
+	i := sort.Search(len(histogramBounds), func(i int) bool { return histogramBounds[i] >= ms })
+	h.counts[i]++
+	h.total++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// percentile returns the upper bound of the bucket of the percentile p (e.g. 0.99), in milliseconds,
+// or the maximum if the percentile is above all the bounds.
+func (h *histogram) percentile(p float64) int64 {
+	rank := int64(float64(h.total)*p + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var cumulated int64
+	for i, bound := range histogramBounds {
+		cumulated += h.counts[i]
+		if cumulated >= rank {
+			return bound
+		}
+	}
+	return int64(h.max / time.Millisecond)
+}
+
+func (h *histogram) writeJSON(buff *bytes.Buffer) {
+	fmt.Fprintf(buff, `{"total": %d, "p50_msec": %d, "p90_msec": %d, "p99_msec": %d, "max_msec": %d, "buckets": {`,
+		h.total, h.percentile(0.5), h.percentile(0.9), h.percentile(0.99), int64(h.max/time.Millisecond))
+	var cumulated int64
+	for i, bound := range histogramBounds {
+		cumulated += h.counts[i]
+		fmt.Fprintf(buff, `"le_%d": %d, `, bound, cumulated)
+	}
+	fmt.Fprintf(buff, `"le_inf": %d}}`, h.total)
+}
+
+// histograms is a Histograms metric, whose JSON value holds for each group and key the number of durations,
+// their percentiles and maximum in milliseconds, and the cumulative counts of the buckets, e.g.
+// `{"apns": {"/orders": {"total": 12, "p50_msec": 50, "p90_msec": 100, "p99_msec": 250, "max_msec": 180, "buckets": {...}}}}`.
+// The percentiles are the upper bounds of their buckets.
+type histograms struct {
+	mu     sync.Mutex
+	groups map[string]map[string]*histogram
+}
+
+func newHistograms() *histograms {
+	return &histograms{groups: make(map[string]map[string]*histogram)}
+}
+
+// Observe records a duration of the key in the group.
+func (hs *histograms) Observe(group, key string, d time.Duration) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	keys, ok := hs.groups[group]
+	if !ok {
+		keys = make(map[string]*histogram)
+		hs.groups[group] = keys
+	}
+	h, ok := keys[key]
+	if !ok {
+		if len(keys) >= maxHistogramKeys {
+			key = "other"
+			h = keys[key]
+		}
+		if h == nil {
+			h = &histogram{counts: make([]int64, len(histogramBounds)+1)}
+			keys[key] = h
+		}
+	}
+	h.observe(d)
+}
+
+// String returns the JSON value of the metric, with the groups and keys sorted.
+// It is a part of the expvar.Var implementation.
+func (hs *histograms) String() string {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	groups := make([]string, 0, len(hs.groups))
+	for group := range hs.groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	var buff bytes.Buffer
+	buff.WriteString("{")
+	for i, group := range groups {
+		if i > 0 {
+			buff.WriteString(", ")
+		}
+		fmt.Fprintf(&buff, "%q: {", group)
+		keys := hs.groups[group]
+		names := make([]string, 0, len(keys))
+		for key := range keys {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		for j, key := range names {
+			if j > 0 {
+				buff.WriteString(", ")
+			}
+			fmt.Fprintf(&buff, "%q: ", key)
+			keys[key].writeJSON(&buff)
+		}
+		buff.WriteString("}")
+	}
+	buff.WriteString("}")
+	return buff.String()
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistograms(t *testing.T) {
+	a := assert.New(t)
+
+	hs := NewHistograms("test_histograms")
+	a.Equal("{}", hs.(expvar.Var).String())
+
+	for i := 1; i <= 100; i++ {
+		hs.Observe("apns", "/orders", time.Duration(i)*time.Millisecond)
+	}
+	hs.Observe("websocket", "/chat", 90*time.Second)
+
+	var value map[string]map[string]struct {
+		Total   int64
+		P50     int64 `json:"p50_msec"`
+		P90     int64 `json:"p90_msec"`
+		P99     int64 `json:"p99_msec"`
+		Max     int64 `json:"max_msec"`
+		Buckets map[string]int64
+	}
+	a.NoError(json.Unmarshal([]byte(hs.(expvar.Var).String()), &value))
+
+	orders := value["apns"]["/orders"]
+	a.Equal(int64(100), orders.Total)
+	a.Equal(int64(50), orders.P50)
+	a.Equal(int64(100), orders.P90)
+	a.Equal(int64(100), orders.P99)
+	a.Equal(int64(100), orders.Max)
+	a.Equal(int64(1), orders.Buckets["le_1"])
+	a.Equal(int64(10), orders.Buckets["le_10"])
+	a.Equal(int64(100), orders.Buckets["le_100"])
+	a.Equal(int64(100), orders.Buckets["le_inf"])
+
+	// a duration above all the bounds
+	chat := value["websocket"]["/chat"]
+	a.Equal(int64(90000), chat.P99)
+	a.Equal(int64(0), chat.Buckets["le_60000"])
+	a.Equal(int64(1), chat.Buckets["le_inf"])
+}
+
+func TestHistograms_BoundsTheKeys(t *testing.T) {
+	a := assert.New(t)
+
+	hs := newHistograms()
+	for i := 0; i < maxHistogramKeys+10; i++ {
+		hs.Observe("fcm", fmt.Sprintf("/topic%d", i), time.Millisecond)
+	}
+	a.Len(hs.groups["fcm"], maxHistogramKeys+1)
+	a.Equal(int64(10), hs.groups["fcm"]["other"].total)
+}
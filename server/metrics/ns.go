@@ -13,6 +13,14 @@ func (ns NS) NewMap(key string) Map {
 	return NewMap(string(ns) + sep + key)
 }
 
+func (ns NS) NewErrors(key string) Errors {
+	return NewErrors(string(ns) + sep + key)
+}
+
+func (ns NS) NewHistograms(key string) Histograms {
+	return NewHistograms(string(ns) + sep + key)
+}
+
 func (ns NS) NewNS(childKey string) NS {
 	return NS(string(ns) + sep + childKey)
 }
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"expvar"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/server/kvstore"
+)
+
+// SnapshotsSchema is the schema of the key-value store in which the counters are persisted.
+const SnapshotsSchema = "metrics_snapshots"
+
+// Snapshots persists the counters (the Int metrics with "total" in their name) periodically in a key-value store,
+// and restores them at its start, so that they keep counting across restarts.
+// The gauges (e.g. the current subscriptions) and the interval metrics are not persisted.
+type Snapshots struct {
+	kvStore  kvstore.KVStore
+	prefix   string
+	interval time.Duration
+
+	stopC chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewSnapshots returns the Snapshots of the counters, stored with the keys starting with prefix
+// (e.g. the ID of the node, in a key-value store shared by the cluster) every interval.
+func NewSnapshots(kvStore kvstore.KVStore, prefix string, interval time.Duration) *Snapshots {
+	return &Snapshots{
+		kvStore:  kvStore,
+		prefix:   prefix,
+		interval: interval,
+		stopC:    make(chan struct{}),
+	}
+}
+
+// Start restores the persisted counters, adding them to the current values, and starts their periodic persistence.
+func (s *Snapshots) Start() error {
+	if err := s.restore(); err != nil {
+		return err
+	}
+	s.wg.Add(1)
+	go s.loop()
+	return nil
+}
+
+// Stop persists the counters a last time.
+func (s *Snapshots) Stop() error {
+	close(s.stopC)
+	s.wg.Wait()
+	return s.save()
+}
+
+func (s *Snapshots) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.save(); err != nil {
+				logger.WithError(err).Error("Could not persist the metrics")
+			}
+		case <-s.stopC:
+			return
+		}
+	}
+}
+
+func (s *Snapshots) restore() error {
+	var err error
+	restored := 0
+	eachCounter(func(name string, counter *expvar.Int) {
+		if err != nil {
+			return
+		}
+		var value []byte
+		var exist bool
+		value, exist, err = s.kvStore.Get(SnapshotsSchema, s.key(name))
+		if err != nil || !exist {
+			return
+		}
+		v, parseErr := strconv.ParseInt(string(value), 10, 64)
+		if parseErr != nil {
+			logger.WithField("metric", name).WithError(parseErr).Warn("Ignoring an invalid persisted metric")
+			return
+		}
+		counter.Add(v)
+		restored++
+	})
+	if err == nil {
+		logger.WithFields(log.Fields{
+			"prefix":   s.prefix,
+			"restored": restored,
+		}).Info("Restored the metrics")
+	}
+	return err
+}
+
+func (s *Snapshots) save() error {
+	var ops []kvstore.Operation
+	eachCounter(func(name string, counter *expvar.Int) {
+		ops = append(ops, kvstore.Operation{
+			Key:   s.key(name),
+			Value: []byte(strconv.FormatInt(counter.Value(), 10)),
+		})
+	})
+	if len(ops) == 0 {
+		return nil
+	}
+	return s.kvStore.Batch(SnapshotsSchema, ops)
+}
+
+func (s *Snapshots) key(name string) string {
+	return s.prefix + sep + name
+}
+
+// eachCounter calls f for each counter metric.
+func eachCounter(f func(name string, counter *expvar.Int)) {
+	expvar.Do(func(kv expvar.KeyValue) {
+		if counter, ok := kv.Value.(*expvar.Int); ok && isCounter(kv.Key) {
+			f(kv.Key, counter)
+		}
+	})
+}
+
+// isCounter returns true if the metric of the given name only grows (e.g. "router.total_messages_routed").
+func isCounter(name string) bool {
+	return strings.Contains(name[strings.LastIndex(name, sep)+1:], "total")
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/server/kvstore"
+)
+
+func TestSnapshots_RestoreTheCountersAfterARestart(t *testing.T) {
+	a := assert.New(t)
+
+	counter := expvar.NewInt("snapshots_test.total_messages")
+	gauge := expvar.NewInt("snapshots_test.current_messages")
+	kvs := kvstore.NewMemoryKVStore()
+
+	counter.Set(5)
+	gauge.Set(3)
+	s := NewSnapshots(kvs, "node1", time.Hour)
+	a.NoError(s.Start())
+	a.NoError(s.Stop())
+
+	value, exist, err := kvs.Get(SnapshotsSchema, "node1.snapshots_test.total_messages")
+	a.NoError(err)
+	a.True(exist)
+	a.Equal("5", string(value))
+	_, exist, err = kvs.Get(SnapshotsSchema, "node1.snapshots_test.current_messages")
+	a.NoError(err)
+	a.False(exist)
+
+	// after a restart, the counter keeps the values counted before and since the start
+	counter.Set(2)
+	gauge.Set(0)
+	s = NewSnapshots(kvs, "node1", time.Hour)
+	a.NoError(s.Start())
+	a.Equal(int64(7), counter.Value())
+	a.Equal(int64(0), gauge.Value())
+	a.NoError(s.Stop())
+
+	// the counters of another node are separate
+	counter.Set(0)
+	s = NewSnapshots(kvs, "node2", time.Hour)
+	a.NoError(s.Start())
+	a.Equal(int64(0), counter.Value())
+	a.NoError(s.Stop())
+}
+
+func TestIsCounter(t *testing.T) {
+	a := assert.New(t)
+	a.True(isCounter("router.total_messages_routed"))
+	a.True(isCounter("fcm.total_sent_messages"))
+	a.False(isCounter("router.current_routes"))
+	a.False(isCounter("num_goroutines"))
+}
@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStatsDPacketSize is the maximum size of a UDP packet sent to StatsD, fitting in the usual network MTU.
+const maxStatsDPacketSize = 1432
+
+var statsDNameReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", " ", "_")
+
+// StatsD exports the metrics of the JSON endpoint to a StatsD (or DogStatsD) server over UDP, at each interval.
+// The counters are sent as StatsD counters, with their increments since the previous export,
+// and the other numeric metrics as gauges. The metrics of the maps (e.g. "fcm.minute") are flattened
+// with their keys (e.g. "fcm.minute.last_messages_rate_sec"), and the non-numeric values are skipped.
+// The tags (e.g. "env:prod") are appended to each metric in the DogStatsD format.
+type StatsD struct {
+	address  string
+	prefix   string
+	tags     string
+	interval time.Duration
+
+	conn  net.Conn
+	last  map[string]float64
+	stopC chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewStatsD returns a StatsD exporter sending to the address (host:port), the names of the metrics prefixed by prefix.
+func NewStatsD(address, prefix string, tags []string, interval time.Duration) *StatsD {
+	s := &StatsD{
+		address:  address,
+		prefix:   prefix,
+		interval: interval,
+		last:     make(map[string]float64),
+		stopC:    make(chan struct{}),
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, sep) {
+		s.prefix = prefix + sep
+	}
+	if len(tags) > 0 {
+		s.tags = "|#" + strings.Join(tags, ",")
+	}
+	return s
+}
+
+// Start opens the UDP socket and starts the periodic export.
+func (s *StatsD) Start() error {
+	conn, err := net.Dial("udp", s.address)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	// the counters are exported with their increments since the start (e.g. not with their restored snapshots)
+	s.lines()
+	s.wg.Add(1)
+	go s.loop()
+	return nil
+}
+
+// Stop exports the metrics a last time, and closes the socket.
+func (s *StatsD) Stop() error {
+	close(s.stopC)
+	s.wg.Wait()
+	err := s.export()
+	if closeErr := s.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *StatsD) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.export(); err != nil {
+				logger.WithError(err).WithField("address", s.address).Warn("Could not export the metrics to StatsD")
+			}
+		case <-s.stopC:
+			return
+		}
+	}
+}
+
+// export sends the current metrics, in as few packets as possible.
+func (s *StatsD) export() error {
+	var packet bytes.Buffer
+	for _, line := range s.lines() {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsDPacketSize {
+			if _, err := s.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(packet.Bytes())
+	return err
+}
+
+// lines returns the StatsD lines of the current metrics, sorted by name.
+func (s *StatsD) lines() []string {
+	numGoroutines.Set(int64(runtime.NumGoroutine()))
+	values := make(map[string]float64)
+	expvar.Do(func(kv expvar.KeyValue) {
+		var value interface{}
+		if err := json.Unmarshal([]byte(kv.Value.String()), &value); err == nil {
+			flatten(values, kv.Key, value)
+		}
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		value := values[name]
+		metricType := "g"
+		if isCounter(name) {
+			value, s.last[name] = value-s.last[name], value
+			metricType = "c"
+		}
+		lines = append(lines, s.prefix+statsDNameReplacer.Replace(name)+":"+
+			strconv.FormatFloat(value, 'f', -1, 64)+"|"+metricType+s.tags)
+	}
+	return lines
+}
+
+// flatten adds the numeric values of a metric to values, with the keys of its (nested) maps appended to its name.
+func flatten(values map[string]float64, name string, value interface{}) {
+	switch v := value.(type) {
+	case float64:
+		values[name] = v
+	case map[string]interface{}:
+		for key, child := range v {
+			flatten(values, name+sep+key, child)
+		}
+	}
+}
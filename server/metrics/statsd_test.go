@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"expvar"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsD_Export(t *testing.T) {
+	a := assert.New(t)
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	a.NoError(err)
+	defer server.Close()
+
+	counter := expvar.NewInt("statsd_test.total_messages")
+	m := expvar.NewMap("statsd_test.minute")
+	m.Add("current_messages_count", 3)
+	m.Set("current_interval_start", NewTime(time.Now()))
+
+	counter.Set(10)
+	s := NewStatsD(server.LocalAddr().String(), "guble", []string{"env:test", "node:1"}, time.Hour)
+	a.NoError(s.Start())
+
+	// the counters are sent with their increments since the start
+	counter.Add(5)
+	a.NoError(s.Stop())
+
+	lines := readStatsDLines(a, server)
+	a.Contains(lines, "guble.statsd_test.total_messages:5|c|#env:test,node:1")
+	a.Contains(lines, "guble.statsd_test.minute.current_messages_count:3|g|#env:test,node:1")
+	for _, line := range lines {
+		a.False(strings.Contains(line, "current_interval_start"), line)
+	}
+}
+
+func readStatsDLines(a *assert.Assertions, server net.PacketConn) []string {
+	var lines []string
+	buffer := make([]byte, maxStatsDPacketSize)
+	for {
+		server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := server.ReadFrom(buffer)
+		if err != nil {
+			return lines
+		}
+		a.True(n <= maxStatsDPacketSize)
+		lines = append(lines, strings.Split(string(buffer[:n]), "\n")...)
+	}
+}
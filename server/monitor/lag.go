@@ -0,0 +1,37 @@
+package monitor
+
+import (
+	"time"
+)
+
+const (
+	// DeliveryLag is the kind of the lags of the messages waiting to be sent by a connector.
+	DeliveryLag = "delivery"
+
+	// ReplicationLag is the kind of the lags of the messages replicated from a cluster peer.
+	ReplicationLag = "replication"
+)
+
+// Lag is the delay of the messages of a partition, in their delivery by a connector
+// or in their replication from a cluster peer.
+type Lag struct {
+	Kind      string
+	Source    string
+	Partition string
+	Lag       time.Duration
+}
+
+// LagReporter is implemented by the components whose lags are monitored.
+type LagReporter interface {
+	// Lags returns the current lags of the partitions with pending or replicated messages.
+	Lags() []Lag
+}
+
+// MessageAge returns the age of a message, given its time in seconds.
+func MessageAge(t int64, now time.Time) time.Duration {
+	age := now.Sub(time.Unix(t, 0))
+	if age < 0 {
+		return 0
+	}
+	return age
+}
@@ -0,0 +1,7 @@
+package monitor
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "monitor")
@@ -0,0 +1,209 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// Config is the configuration of a Monitor.
+type Config struct {
+	// NodeID is the ID of the node in the cluster (0 in the standalone-mode), sent with the alerts.
+	NodeID uint8
+
+	Interval time.Duration
+
+	// the lags above which an alert is raised, by kind (no alerts for a kind without threshold)
+	DeliveryThreshold    time.Duration
+	ReplicationThreshold time.Duration
+
+	// the alerts are posted to the URL (if not empty), and published to the topic (if not empty)
+	URL     string
+	Topic   protocol.Path
+	Timeout time.Duration
+}
+
+// handler is the part of the router.Router publishing the alerts.
+type handler interface {
+	HandleMessage(message *protocol.Message) error
+}
+
+// Alert is raised when a lag exceeds its threshold, and resolved when it is back below it.
+type Alert struct {
+	Kind      string  `json:"kind"`
+	Source    string  `json:"source"`
+	Partition string  `json:"partition"`
+	Node      uint8   `json:"node,omitempty"`
+	Lag       float64 `json:"lag_seconds"`
+	Threshold float64 `json:"threshold_seconds"`
+	Resolved  bool    `json:"resolved"`
+	Time      string  `json:"time"`
+}
+
+// Monitor checks the lags of its reporters periodically, raising an alert (and later its resolution)
+// when a lag exceeds its threshold, so that the operators hear about stuck connectors and peers.
+type Monitor struct {
+	config    Config
+	handler   handler
+	reporters []LagReporter
+	client    *http.Client
+
+	// the raised alerts which are not resolved yet
+	raised map[string]*Alert
+
+	stopC chan struct{}
+	wg    sync.WaitGroup
+}
+
+// New returns a Monitor of the lags of the reporters, publishing its alerts with the handler (e.g. the router).
+func New(config Config, handler handler, reporters ...LagReporter) *Monitor {
+	return &Monitor{
+		config:    config,
+		handler:   handler,
+		reporters: reporters,
+		client:    &http.Client{Timeout: config.Timeout},
+		raised:    make(map[string]*Alert),
+		stopC:     make(chan struct{}),
+	}
+}
+
+// Start starts the periodic checks.
+func (m *Monitor) Start() error {
+	logger.WithFields(log.Fields{
+		"reporters": len(m.reporters),
+		"interval":  m.config.Interval,
+	}).Info("Starting the lag monitor")
+
+	m.wg.Add(1)
+	go m.loop()
+	return nil
+}
+
+// Stop stops the periodic checks.
+func (m *Monitor) Stop() error {
+	close(m.stopC)
+	m.wg.Wait()
+	return nil
+}
+
+func (m *Monitor) loop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.check(time.Now())
+		case <-m.stopC:
+			return
+		}
+	}
+}
+
+// check raises the alerts of the lags exceeding their thresholds, and resolves the ones whose lag is back below it.
+func (m *Monitor) check(now time.Time) {
+	exceeding := make(map[string]Lag)
+	for _, reporter := range m.reporters {
+		for _, lag := range reporter.Lags() {
+			threshold := m.threshold(lag.Kind)
+			if threshold > 0 && lag.Lag > threshold {
+				exceeding[alertKey(lag)] = lag
+			}
+		}
+	}
+
+	for key, lag := range exceeding {
+		if _, ok := m.raised[key]; ok {
+			continue
+		}
+		alert := m.newAlert(lag, now)
+		m.raised[key] = alert
+		m.send(alert)
+	}
+	for key, alert := range m.raised {
+		if _, ok := exceeding[key]; ok {
+			continue
+		}
+		delete(m.raised, key)
+		alert.Resolved = true
+		alert.Time = now.UTC().Format(time.RFC3339)
+		m.send(alert)
+	}
+}
+
+func (m *Monitor) threshold(kind string) time.Duration {
+	switch kind {
+	case DeliveryLag:
+		return m.config.DeliveryThreshold
+	case ReplicationLag:
+		return m.config.ReplicationThreshold
+	}
+	return 0
+}
+
+func (m *Monitor) newAlert(lag Lag, now time.Time) *Alert {
+	return &Alert{
+		Kind:      lag.Kind,
+		Source:    lag.Source,
+		Partition: lag.Partition,
+		Node:      m.config.NodeID,
+		Lag:       lag.Lag.Seconds(),
+		Threshold: m.threshold(lag.Kind).Seconds(),
+		Time:      now.UTC().Format(time.RFC3339),
+	}
+}
+
+// send posts the alert to the URL and publishes it to the topic, logging the failures.
+func (m *Monitor) send(alert *Alert) {
+	fields := log.Fields{
+		"kind":      alert.Kind,
+		"source":    alert.Source,
+		"partition": alert.Partition,
+		"lag":       alert.Lag,
+	}
+	if alert.Resolved {
+		logger.WithFields(fields).Info("Lag alert resolved")
+	} else {
+		logger.WithFields(fields).Warn("Lag alert raised")
+	}
+
+	body, err := json.Marshal(alert)
+	if err != nil {
+		logger.WithError(err).Error("Error encoding a lag alert")
+		return
+	}
+	if m.config.URL != "" {
+		if err := m.post(body); err != nil {
+			logger.WithError(err).WithField("url", m.config.URL).Error("Error posting a lag alert")
+		}
+	}
+	if m.config.Topic != "" && m.handler != nil {
+		if err := m.handler.HandleMessage(&protocol.Message{Path: m.config.Topic, Body: body}); err != nil {
+			logger.WithError(err).WithField("topic", m.config.Topic).Error("Error publishing a lag alert")
+		}
+	}
+}
+
+func (m *Monitor) post(body []byte) error {
+	resp, err := m.client.Post(m.config.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("the alert URL answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func alertKey(lag Lag) string {
+	return lag.Kind + "/" + lag.Source + "/" + lag.Partition
+}
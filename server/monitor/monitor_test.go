@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+)
+
+type fakeReporter []Lag
+
+func (r *fakeReporter) Lags() []Lag {
+	return *r
+}
+
+type fakeHandler []*protocol.Message
+
+func (h *fakeHandler) HandleMessage(m *protocol.Message) error {
+	*h = append(*h, m)
+	return nil
+}
+
+func TestMonitor_RaisesAndResolvesAlerts(t *testing.T) {
+	a := assert.New(t)
+
+	posted := make(chan Alert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var alert Alert
+		a.NoError(json.Unmarshal(body, &alert))
+		posted <- alert
+	}))
+	defer server.Close()
+
+	reporter := &fakeReporter{
+		{Kind: DeliveryLag, Source: "fcm", Partition: "news", Lag: 10 * time.Minute},
+		{Kind: DeliveryLag, Source: "apns", Partition: "news", Lag: time.Second},
+		{Kind: ReplicationLag, Source: "node2", Partition: "news", Lag: time.Hour},
+	}
+	handler := &fakeHandler{}
+	m := New(Config{
+		NodeID:            1,
+		DeliveryThreshold: time.Minute,
+		URL:               server.URL,
+		Topic:             "/alerts",
+		Timeout:           time.Second,
+	}, handler, reporter)
+
+	// only the delivery lag above the threshold raises an alert (the replication lags have no threshold), once
+	now := time.Now()
+	m.check(now)
+	m.check(now)
+	alert := <-posted
+	a.Equal("fcm", alert.Source)
+	a.Equal(float64(600), alert.Lag)
+	a.Equal(float64(60), alert.Threshold)
+	a.Equal(uint8(1), alert.Node)
+	a.False(alert.Resolved)
+	a.Len(*handler, 1)
+	a.Equal(protocol.Path("/alerts"), (*handler)[0].Path)
+
+	// the alert is resolved when the lag is back below the threshold
+	(*reporter)[0].Lag = time.Second
+	m.check(now)
+	alert = <-posted
+	a.Equal("fcm", alert.Source)
+	a.True(alert.Resolved)
+	a.Len(*handler, 2)
+	a.Len(posted, 0)
+}
+
+func TestMessageAge(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now()
+	a.Equal(time.Minute, MessageAge(now.Add(-time.Minute).Unix(), time.Unix(now.Unix(), 0)))
+	a.Equal(time.Duration(0), MessageAge(now.Add(time.Minute).Unix(), now))
+}
@@ -0,0 +1,7 @@
+package notify
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "notify")
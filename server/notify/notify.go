@@ -0,0 +1,319 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/sms"
+)
+
+const (
+	DefaultPrefix  = "/notify/"
+	DefaultTopic   = "/notifications"
+	DefaultSMSFrom = "guble"
+
+	// confirmPath follows the user id in the topic of the confirmations
+	confirmPath = "confirm"
+)
+
+// Config is used for configuring the notification router.
+type Config struct {
+	Enabled *bool
+	Prefix  *string
+	Topic   *string
+	SMSFrom *string
+
+	// SMSTopic is the topic of the SMS gateway ("" when the SMS channel is not available)
+	SMSTopic string
+}
+
+// Subscriptions is implemented by the push connectors (FCM, APNS),
+// in which the notifier subscribes the devices of the users.
+type Subscriptions interface {
+	Manager() connector.Manager
+	Run(connector.Subscriber)
+}
+
+// notifier routes the notifications of the users over their channels, following the policy of each user:
+// the notifications published on `<topic>/<userID>` are delivered over the channel of the first step,
+// and escalated to the next steps until they are confirmed by a message on `<topic>/<userID>/confirm`.
+type notifier struct {
+	config        Config
+	router        router.Router
+	kvstore       kvstore.KVStore
+	subscriptions map[string]Subscriptions
+
+	route *router.Route
+
+	// pending are the escalations waiting for a confirmation, by user id and notification id
+	pending map[string]map[uint64]*escalation
+	mu      sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	logger *log.Entry
+}
+
+// New returns the notification router, delivering the push channels with the given connectors (by channel name).
+func New(router router.Router, subscriptions map[string]Subscriptions, config Config) (*notifier, error) {
+	kvs, err := router.KVStore()
+	if err != nil {
+		return nil, err
+	}
+	if config.Prefix == nil {
+		prefix := DefaultPrefix
+		config.Prefix = &prefix
+	}
+	if config.Topic == nil {
+		topic := DefaultTopic
+		config.Topic = &topic
+	}
+	if config.SMSFrom == nil {
+		from := DefaultSMSFrom
+		config.SMSFrom = &from
+	}
+	return &notifier{
+		config:        config,
+		router:        router,
+		kvstore:       kvs,
+		subscriptions: subscriptions,
+		pending:       make(map[string]map[uint64]*escalation),
+		logger:        logger.WithField("name", "notifier"),
+	}, nil
+}
+
+// Start subscribes the notifier to the notifications and confirmations.
+func (n *notifier) Start() error {
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	if err := n.subscribe(); err != nil {
+		return err
+	}
+	n.wg.Add(1)
+	go n.loop()
+	n.logger.WithField("topic", *n.config.Topic).Info("Started notifier")
+	return nil
+}
+
+// Stop unsubscribes the notifier, and drops the pending escalations.
+func (n *notifier) Stop() error {
+	n.cancel()
+	n.router.Unsubscribe(n.route)
+	n.wg.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for userID, escalations := range n.pending {
+		for _, e := range escalations {
+			e.timer.Stop()
+		}
+		delete(n.pending, userID)
+	}
+	n.logger.Info("Stopped notifier")
+	return nil
+}
+
+func (n *notifier) subscribe() error {
+	n.route = router.NewRoute(router.RouteConfig{
+		Path:        protocol.Path(*n.config.Topic),
+		ChannelSize: 1000,
+		Kind:        router.ConnectorRoute,
+	})
+	return n.route.Provide(n.router, true)
+}
+
+func (n *notifier) loop() {
+	defer n.wg.Done()
+	for {
+		select {
+		case m, opened := <-n.route.MessagesChannel():
+			if !opened {
+				if n.ctx.Err() != nil {
+					return
+				}
+				n.logger.Error("Notifications route was closed, subscribing again")
+				if err := n.subscribe(); err != nil {
+					n.logger.WithField("error", err.Error()).Error("Error subscribing to the notifications")
+					return
+				}
+				continue
+			}
+			n.handle(m)
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
+
+// handle dispatches a message received on the notifications topic.
+func (n *notifier) handle(m *protocol.Message) {
+	parts := strings.Split(strings.TrimPrefix(string(m.Path), strings.TrimSuffix(*n.config.Topic, "/")+"/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		// in a cluster, the escalation is tracked only by the node which received the notification
+		if c := n.router.Cluster(); c != nil && m.NodeID != c.Config.ID {
+			return
+		}
+		n.notify(parts[0], m)
+	case len(parts) == 2 && parts[0] != "" && parts[1] == confirmPath:
+		n.confirm(parts[0], m)
+	default:
+		n.logger.WithField("path", m.Path).Debug("Ignoring message which is no notification")
+	}
+}
+
+// notify delivers a notification over the first step of the user's policy,
+// and tracks its escalation if the policy has more steps.
+func (n *notifier) notify(userID string, m *protocol.Message) {
+	policy, err := loadPolicy(n.kvstore, userID)
+	if err != nil {
+		n.logger.WithField("error", err.Error()).WithField("userID", userID).Error("Error loading the notification policy")
+		return
+	}
+	if policy == nil {
+		n.logger.WithField("userID", userID).Warn("Dropping notification of a user without notification policy")
+		return
+	}
+	mTotalNotifications.Add(1)
+
+	e := &escalation{userID: userID, message: m, policy: policy}
+	n.mu.Lock()
+	n.track(e)
+	n.mu.Unlock()
+	n.deliver(e, 0)
+}
+
+// track schedules the escalation to the next step, if the current step is not the last one.
+// It is called with the lock held.
+func (n *notifier) track(e *escalation) {
+	if e.step == len(e.policy.Steps)-1 {
+		delete(n.pending[e.userID], e.message.ID)
+		if len(n.pending[e.userID]) == 0 {
+			delete(n.pending, e.userID)
+		}
+		return
+	}
+	if n.pending[e.userID] == nil {
+		n.pending[e.userID] = make(map[uint64]*escalation)
+	}
+	n.pending[e.userID][e.message.ID] = e
+	e.timer = time.AfterFunc(e.policy.Steps[e.step].escalateAfter, func() { n.escalate(e) })
+}
+
+// escalate delivers an unconfirmed notification over the next step.
+func (n *notifier) escalate(e *escalation) {
+	n.mu.Lock()
+	if n.pending[e.userID][e.message.ID] != e {
+		// confirmed in the meantime
+		n.mu.Unlock()
+		return
+	}
+	e.step++
+	step := e.step
+	n.track(e)
+	n.mu.Unlock()
+
+	mTotalEscalations.Add(1)
+	n.logger.WithFields(log.Fields{
+		"userID": e.userID,
+		"id":     e.message.ID,
+		"step":   step + 1,
+	}).Info("Escalating unconfirmed notification")
+	n.deliver(e, step)
+}
+
+// deliver publishes the notification on the channel of a step.
+func (n *notifier) deliver(e *escalation, index int) {
+	step := e.policy.Steps[index]
+	m := &protocol.Message{
+		UserID:        e.message.UserID,
+		ApplicationID: e.message.ApplicationID,
+		HeaderJSON:    e.message.HeaderJSON,
+		ContentType:   e.message.ContentType,
+		Body:          e.message.Body,
+	}
+	if step.Channel == SMSChannel {
+		body, err := json.Marshal(&sms.SMS{
+			To:   step.Target,
+			From: *n.config.SMSFrom,
+			Text: smsText(e.message),
+		})
+		if err != nil {
+			n.logger.WithField("error", err.Error()).Error("Error encoding the notification as SMS")
+			mTotalDeliveryErrors.Add(1)
+			return
+		}
+		m.Path = protocol.Path(n.config.SMSTopic)
+		m.HeaderJSON = ""
+		m.ContentType = protocol.ContentTypeJSON
+		m.Body = body
+	} else {
+		m.Path = stepTopic(e.userID, index)
+	}
+
+	if err := n.router.HandleMessage(m); err != nil {
+		n.logger.WithField("error", err.Error()).WithField("path", m.Path).Error("Error delivering the notification")
+		mTotalDeliveryErrors.Add(1)
+		return
+	}
+	mTotalDeliveries.Add(1)
+}
+
+// smsText returns the text of a notification: the `text` field of a JSON body, or else the whole body.
+func smsText(m *protocol.Message) string {
+	var notification struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Body, &notification); err == nil && notification.Text != "" {
+		return notification.Text
+	}
+	return string(m.Body)
+}
+
+// confirm stops the escalation of the notification given in the body of a confirmation (`{"id": <id>}`),
+// or of all the pending notifications of the user if the body contains no id.
+func (n *notifier) confirm(userID string, m *protocol.Message) {
+	var confirmation struct {
+		ID uint64 `json:"id"`
+	}
+	if len(m.Body) > 0 {
+		if err := json.Unmarshal(m.Body, &confirmation); err != nil {
+			n.logger.WithField("error", err.Error()).WithField("userID", userID).Error("Invalid notification confirmation")
+			return
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for id, e := range n.pending[userID] {
+		if confirmation.ID != 0 && id != confirmation.ID {
+			continue
+		}
+		e.timer.Stop()
+		delete(n.pending[userID], id)
+		mTotalConfirmations.Add(1)
+		n.logger.WithField("userID", userID).WithField("id", id).Info("Confirmed notification")
+	}
+	if len(n.pending[userID]) == 0 {
+		delete(n.pending, userID)
+	}
+}
+
+// escalation is a notification waiting for a confirmation.
+type escalation struct {
+	userID  string
+	message *protocol.Message
+	policy  *Policy
+	step    int
+	timer   *time.Timer
+}
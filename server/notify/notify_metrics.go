@@ -0,0 +1,14 @@
+package notify
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns                   = metrics.NS("notify")
+	mTotalNotifications  = ns.NewInt("total_notifications")
+	mTotalDeliveries     = ns.NewInt("total_deliveries")
+	mTotalEscalations    = ns.NewInt("total_escalations")
+	mTotalConfirmations  = ns.NewInt("total_confirmations")
+	mTotalDeliveryErrors = ns.NewInt("total_delivery_errors")
+)
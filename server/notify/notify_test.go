@@ -0,0 +1,168 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/sms"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSubscriptions struct {
+	manager connector.Manager
+	runC    chan connector.Subscriber
+}
+
+func newFakeSubscriptions(kvs kvstore.KVStore, schema string) *fakeSubscriptions {
+	return &fakeSubscriptions{
+		manager: connector.NewManager(schema, kvs),
+		runC:    make(chan connector.Subscriber, 10),
+	}
+}
+
+func (f *fakeSubscriptions) Manager() connector.Manager {
+	return f.manager
+}
+
+func (f *fakeSubscriptions) Run(s connector.Subscriber) {
+	f.runC <- s
+}
+
+func newTestNotifier(t *testing.T, routerMock *MockRouter) (*notifier, *fakeSubscriptions) {
+	kvs := kvstore.NewMemoryKVStore()
+	routerMock.EXPECT().KVStore().Return(kvs, nil)
+	fcmSubscriptions := newFakeSubscriptions(kvs, "fcm_registration")
+
+	n, err := New(routerMock, map[string]Subscriptions{FCMChannel: fcmSubscriptions}, Config{SMSTopic: sms.SMSDefaultTopic})
+	assert.NoError(t, err)
+	return n, fcmSubscriptions
+}
+
+func putPolicy(t *testing.T, n *notifier, userID, policy string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	n.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/notify/"+userID, strings.NewReader(policy)))
+	return w
+}
+
+func Test_PolicyAPI(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	n, fcmSubscriptions := newTestNotifier(t, NewMockRouter(ctrl))
+
+	w := putPolicy(t, n, "marvin", `{"steps": [
+		{"channel": "fcm", "target": "token1", "escalate_after": "30s"},
+		{"channel": "sms", "target": "+4917012345"}
+	]}`)
+	a.Equal(http.StatusOK, w.Code)
+
+	// the device of the fcm step is subscribed on the topic of the step
+	s := <-fcmSubscriptions.runC
+	a.Equal(protocol.Path("/notification-channels/marvin/0"), s.Route().Path)
+	a.Equal("token1", s.Route().Get(deviceTokenKey))
+	a.Equal("marvin", s.Route().Get(userIDKey))
+
+	w = httptest.NewRecorder()
+	n.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/notify/marvin", nil))
+	a.Equal(http.StatusOK, w.Code)
+	var policy Policy
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &policy))
+	a.Equal(2, len(policy.Steps))
+	a.Equal("+4917012345", policy.Steps[1].Target)
+
+	// the phone number of the sms step is listed as a device of the user
+	devices := n.Devices("marvin")
+	a.Equal(1, len(devices))
+	a.Equal(SMSChannel, devices[0].Connector)
+	a.Equal("+4917012345", devices[0].ID)
+	a.Equal(protocol.Path("/notification-channels/marvin/1"), devices[0].Subscriptions[0].Topic)
+
+	// the channels have to be enabled
+	w = putPolicy(t, n, "marvin", `{"steps": [{"channel": "apns", "target": "device1"}]}`)
+	a.Equal(http.StatusBadRequest, w.Code)
+	w = putPolicy(t, n, "marvin", `{"steps": [{"channel": "fcm", "target": "token1"}, {"channel": "sms", "target": "+4917012345"}]}`)
+	a.Equal(http.StatusBadRequest, w.Code)
+
+	// replacing the policy moves the subscription
+	w = putPolicy(t, n, "marvin", `{"steps": [{"channel": "sms", "target": "+4917012345", "escalate_after": "1m"}, {"channel": "fcm", "target": "token2"}]}`)
+	a.Equal(http.StatusOK, w.Code)
+	s = <-fcmSubscriptions.runC
+	a.Equal(protocol.Path("/notification-channels/marvin/1"), s.Route().Path)
+	a.Equal(1, len(fcmSubscriptions.manager.List()))
+
+	w = httptest.NewRecorder()
+	n.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/notify/marvin", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal(0, len(fcmSubscriptions.manager.List()))
+
+	w = httptest.NewRecorder()
+	n.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/notify/marvin", nil))
+	a.Equal(http.StatusNotFound, w.Code)
+}
+
+func Test_EscalatesUnconfirmedNotifications(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	n, fcmSubscriptions := newTestNotifier(t, routerMock)
+	routerMock.EXPECT().Cluster().Return(nil).AnyTimes()
+
+	a.Equal(http.StatusOK, putPolicy(t, n, "marvin", `{"steps": [
+		{"channel": "fcm", "target": "token1", "escalate_after": "20ms"},
+		{"channel": "sms", "target": "+4917012345"}
+	]}`).Code)
+	<-fcmSubscriptions.runC
+
+	deliveredC := make(chan *protocol.Message, 10)
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(m *protocol.Message) error {
+		deliveredC <- m
+		return nil
+	}).Return(nil).AnyTimes()
+
+	// an unconfirmed notification is delivered over fcm, then over sms
+	n.handle(&protocol.Message{ID: 1, Path: "/notifications/marvin", Body: []byte(`{"text": "Hello"}`)})
+	m := <-deliveredC
+	a.Equal(protocol.Path("/notification-channels/marvin/0"), m.Path)
+	a.Equal(`{"text": "Hello"}`, string(m.Body))
+
+	select {
+	case m = <-deliveredC:
+		a.Equal(protocol.Path(sms.SMSDefaultTopic), m.Path)
+		var text sms.SMS
+		a.NoError(json.Unmarshal(m.Body, &text))
+		a.Equal("+4917012345", text.To)
+		a.Equal("Hello", text.Text)
+	case <-time.After(time.Second):
+		a.Fail("The notification was not escalated")
+	}
+	a.Equal(0, len(n.pending))
+
+	// a confirmed notification is not escalated
+	n.handle(&protocol.Message{ID: 2, Path: "/notifications/marvin", Body: []byte("Hello")})
+	m = <-deliveredC
+	a.Equal(protocol.Path("/notification-channels/marvin/0"), m.Path)
+	n.handle(&protocol.Message{ID: 3, Path: "/notifications/marvin/confirm", Body: []byte(`{"id": 2}`)})
+	a.Equal(0, len(n.pending))
+
+	select {
+	case m = <-deliveredC:
+		a.Fail("The confirmed notification was escalated", m.Path)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the notifications of users without policy are dropped
+	n.handle(&protocol.Message{ID: 4, Path: "/notifications/arthur", Body: []byte("Hello")})
+	a.Equal(0, len(deliveredC))
+}
@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+)
+
+// The channels over which the notifications can be delivered.
+const (
+	FCMChannel  = "fcm"
+	APNSChannel = "apns"
+	SMSChannel  = "sms"
+)
+
+const (
+	// schema is the database schema of the notification policies, stored by user id
+	schema = "notify_policy"
+
+	// ChannelsTopic is the prefix of the topics on which the push channels of the users are subscribed,
+	// followed by the user id and the index of the step.
+	ChannelsTopic = "/notification-channels"
+
+	// deviceTokenKey and userIDKey are the route params of the FCM and APNS subscriptions
+	deviceTokenKey = "device_token"
+	userIDKey      = "user_id"
+)
+
+var ErrInvalidPolicy = errors.New("Invalid notification policy.")
+
+// Policy is the fallback chain of a user: the notifications are delivered over the channel of the first step,
+// and escalated to the next step if they are not confirmed before the step expires.
+type Policy struct {
+	Steps []*Step `json:"steps"`
+}
+
+// Step delivers the notifications over a channel, to a target of the user.
+type Step struct {
+	// Channel is the name of the channel: fcm | apns | sms
+	Channel string `json:"channel"`
+
+	// Target is the FCM device token, the APNS device token, or the phone number of the user
+	Target string `json:"target"`
+
+	// EscalateAfter is the duration to wait for a confirmation, before escalating to the next step (e.g. "60s")
+	EscalateAfter string `json:"escalate_after,omitempty"`
+
+	escalateAfter time.Duration
+}
+
+// validate checks the steps of the policy, and parses their durations.
+// Each step, except the last one, has to escalate after a positive duration.
+func (p *Policy) validate() error {
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("%v The policy has no steps", ErrInvalidPolicy)
+	}
+	for i, step := range p.Steps {
+		switch step.Channel {
+		case FCMChannel, APNSChannel, SMSChannel:
+		default:
+			return fmt.Errorf("%v Unknown channel %q in step %d", ErrInvalidPolicy, step.Channel, i+1)
+		}
+		if step.Target == "" {
+			return fmt.Errorf("%v Missing target in step %d", ErrInvalidPolicy, i+1)
+		}
+		step.escalateAfter = 0
+		if step.EscalateAfter != "" {
+			d, err := time.ParseDuration(step.EscalateAfter)
+			if err != nil || d < 0 {
+				return fmt.Errorf("%v Invalid escalation duration in step %d", ErrInvalidPolicy, i+1)
+			}
+			step.escalateAfter = d
+		}
+		if step.escalateAfter == 0 && i < len(p.Steps)-1 {
+			return fmt.Errorf("%v Missing escalation duration in step %d", ErrInvalidPolicy, i+1)
+		}
+	}
+	return nil
+}
+
+// stepTopic returns the topic on which the push channel of a step is subscribed.
+func stepTopic(userID string, index int) protocol.Path {
+	return protocol.Path(fmt.Sprintf("%s/%s/%d", ChannelsTopic, userID, index))
+}
+
+// loadPolicy returns the policy of a user, or nil if the user has none.
+func loadPolicy(kvs kvstore.KVStore, userID string) (*Policy, error) {
+	data, exists, err := kvs.Get(schema, userID)
+	if err != nil || !exists {
+		return nil, err
+	}
+	p := new(Policy)
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func storePolicy(kvs kvstore.KVStore, userID string, p *Policy) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return kvs.Put(schema, userID, data)
+}
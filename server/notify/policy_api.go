@@ -0,0 +1,189 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/router"
+)
+
+// GetPrefix returns the prefix of the notification policies API.
+// It is a part of the service.endpoint implementation.
+func (n *notifier) GetPrefix() string {
+	return *n.config.Prefix
+}
+
+// ServeHTTP manages the notification policies on `<prefix><userID>`:
+// GET returns the policy of the user, PUT (or POST) replaces it, DELETE removes it.
+// It is a part of the service.endpoint implementation.
+func (n *notifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := strings.Trim(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(n.GetPrefix(), "/")), "/")
+	if userID == "" || strings.Contains(userID, "/") {
+		http.Error(w, `{"error": "Missing user id."}`, http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		n.getPolicy(w, userID)
+	case http.MethodPut, http.MethodPost:
+		n.putPolicy(w, r, userID)
+	case http.MethodDelete:
+		n.deletePolicy(w, userID)
+	default:
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET, PUT, POST and DELETE are accepted."}`, http.StatusMethodNotAllowed)
+	}
+}
+
+func (n *notifier) getPolicy(w http.ResponseWriter, userID string) {
+	policy, err := loadPolicy(n.kvstore, userID)
+	if err != nil {
+		n.logger.WithField("error", err.Error()).WithField("userID", userID).Error("Error loading the notification policy")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	if policy == nil {
+		http.Error(w, `{"error": "Policy not found."}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(policy)
+}
+
+// Devices returns the phone numbers of the SMS steps in the policy of the user.
+// The devices of the push steps are subscribed in their connectors, which list them.
+// It is a part of the connector.DeviceLister implementation.
+func (n *notifier) Devices(userID string) []connector.Device {
+	policy, err := loadPolicy(n.kvstore, userID)
+	if err != nil {
+		n.logger.WithField("error", err.Error()).WithField("userID", userID).Error("Error loading the notification policy")
+		return nil
+	}
+	if policy == nil {
+		return nil
+	}
+	var devices []connector.Device
+	for i, step := range policy.Steps {
+		if step.Channel != SMSChannel {
+			continue
+		}
+		devices = append(devices, connector.Device{
+			Connector:     SMSChannel,
+			ID:            step.Target,
+			Subscriptions: []connector.DeviceSubscription{{Topic: stepTopic(userID, i)}},
+		})
+	}
+	return devices
+}
+
+func (n *notifier) putPolicy(w http.ResponseWriter, r *http.Request, userID string) {
+	policy := new(Policy)
+	if err := json.NewDecoder(r.Body).Decode(policy); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "json body could not be decoded: %s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := policy.validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	for i, step := range policy.Steps {
+		if !n.channelAvailable(step.Channel) {
+			http.Error(w, fmt.Sprintf(`{"error": "Channel %s of step %d is not enabled."}`, step.Channel, i+1), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := n.replacePolicy(userID, policy); err != nil {
+		n.logger.WithField("error", err.Error()).WithField("userID", userID).Error("Error storing the notification policy")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(policy)
+}
+
+func (n *notifier) deletePolicy(w http.ResponseWriter, userID string) {
+	if err := n.replacePolicy(userID, nil); err != nil {
+		n.logger.WithField("error", err.Error()).WithField("userID", userID).Error("Error deleting the notification policy")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, `{"deleted": %q}`, userID)
+}
+
+// channelAvailable returns true if the notifier can deliver over the channel.
+func (n *notifier) channelAvailable(channel string) bool {
+	if channel == SMSChannel {
+		return n.config.SMSTopic != ""
+	}
+	_, ok := n.subscriptions[channel]
+	return ok
+}
+
+// replacePolicy stores the new policy of a user (or deletes it, if nil),
+// and moves the subscriptions of the push channels from the old policy to the new one.
+func (n *notifier) replacePolicy(userID string, policy *Policy) error {
+	old, err := loadPolicy(n.kvstore, userID)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		n.unsubscribeSteps(userID, old)
+	}
+
+	if policy == nil {
+		return n.kvstore.Delete(schema, userID)
+	}
+	if err := storePolicy(n.kvstore, userID, policy); err != nil {
+		return err
+	}
+	return n.subscribeSteps(userID, policy)
+}
+
+// subscribeSteps subscribes the devices of the push channels in their connectors, each on the topic of its step.
+func (n *notifier) subscribeSteps(userID string, policy *Policy) error {
+	for i, step := range policy.Steps {
+		subscriptions, ok := n.subscriptions[step.Channel]
+		if !ok {
+			continue
+		}
+		s, err := subscriptions.Manager().Create(stepTopic(userID, i), stepParams(userID, step))
+		if err == connector.ErrSubscriberExists {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		go subscriptions.Run(s)
+	}
+	return nil
+}
+
+func (n *notifier) unsubscribeSteps(userID string, policy *Policy) {
+	for i, step := range policy.Steps {
+		subscriptions, ok := n.subscriptions[step.Channel]
+		if !ok {
+			continue
+		}
+		manager := subscriptions.Manager()
+		s := manager.Find(connector.GenerateKey(string(stepTopic(userID, i)), stepParams(userID, step)))
+		if s == nil {
+			continue
+		}
+		if err := manager.Remove(s); err != nil {
+			n.logger.WithField("error", err.Error()).WithField("userID", userID).Error("Error removing the subscription of a step")
+		}
+	}
+}
+
+// stepParams returns the route params of the connector subscription of a push channel.
+func stepParams(userID string, step *Step) router.RouteParams {
+	return router.RouteParams{
+		deviceTokenKey:           step.Target,
+		userIDKey:                userID,
+		connector.ConnectorParam: step.Channel,
+	}
+}
@@ -0,0 +1,34 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_validate(t *testing.T) {
+	a := assert.New(t)
+
+	p := &Policy{Steps: []*Step{
+		{Channel: FCMChannel, Target: "token", EscalateAfter: "30s"},
+		{Channel: APNSChannel, Target: "device", EscalateAfter: "1m"},
+		{Channel: SMSChannel, Target: "+4917012345"},
+	}}
+	a.NoError(p.validate())
+	a.Equal(30*time.Second, p.Steps[0].escalateAfter)
+	a.Equal(time.Minute, p.Steps[1].escalateAfter)
+	a.Equal(time.Duration(0), p.Steps[2].escalateAfter)
+
+	invalid := []*Policy{
+		{},
+		{Steps: []*Step{{Channel: "pigeon", Target: "x"}}},
+		{Steps: []*Step{{Channel: SMSChannel}}},
+		{Steps: []*Step{{Channel: SMSChannel, Target: "+4917012345", EscalateAfter: "soon"}}},
+		// only the last step may not escalate
+		{Steps: []*Step{{Channel: FCMChannel, Target: "token"}, {Channel: SMSChannel, Target: "+4917012345"}}},
+	}
+	for _, p := range invalid {
+		a.Error(p.validate())
+	}
+}
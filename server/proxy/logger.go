@@ -0,0 +1,7 @@
+package proxy
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "proxy")
@@ -0,0 +1,7 @@
+package queue
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "queue")
@@ -0,0 +1,241 @@
+// Package queue implements a bounded, instrumented FIFO queue which is shared by the guble modules
+// (router, connectors, cluster), giving them uniform observability and backpressure semantics.
+package queue
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// ErrQueueFull is returned by Push when the queue is full and its policy is DropPolicy.
+	ErrQueueFull = errors.New("Queue is full. Element was dropped.")
+
+	// ErrQueueClosed is returned by Push when the queue was already closed.
+	ErrQueueClosed = errors.New("Queue is closed.")
+
+	// ErrMissingCodec is returned by New when SpillPolicy is used without Encode / Decode functions.
+	ErrMissingCodec = errors.New("Spilling queue requires Encode and Decode functions.")
+)
+
+// Policy defines the behaviour of Push when the queue reached its capacity.
+type Policy int
+
+const (
+	// BlockPolicy makes Push wait until there is room in the queue (backpressure).
+	BlockPolicy Policy = iota
+
+	// DropPolicy makes Push return ErrQueueFull without enqueueing the element.
+	DropPolicy
+
+	// SpillPolicy makes Push append the element to a file on disk,
+	// from where it is moved back into the queue when room becomes available.
+	SpillPolicy
+)
+
+// Config is used for configuring a Queue.
+type Config struct {
+	// Name is used for logging and as the metrics namespace of the queue
+	Name string
+
+	// Capacity is the number of elements which can be held in memory
+	Capacity int
+
+	// Policy is applied when the in-memory capacity is reached
+	Policy Policy
+
+	// SpillDir is the directory where the spillover file is created (only used by SpillPolicy)
+	SpillDir string
+
+	// Encode and Decode are used for writing and reading the elements in the spillover file
+	Encode func(interface{}) ([]byte, error)
+	Decode func([]byte) (interface{}, error)
+}
+
+// Element is a value held in the Queue, together with the time when it was pushed.
+type Element struct {
+	Value    interface{}
+	PushedAt time.Time
+}
+
+// Queue is a bounded FIFO queue exposing its depth, the time spent waiting by its elements
+// and the number of dropped elements as metrics.
+type Queue struct {
+	config  Config
+	c       chan *Element
+	spill   *spillover
+	metrics *queueMetrics
+
+	closed bool
+	mu     sync.RWMutex
+
+	// done is closed by Close, releasing the pushes blocked on a full queue
+	done      chan struct{}
+	closeOnce sync.Once
+
+	logger *log.Entry
+}
+
+// New returns a new Queue created using the given Config.
+func New(config Config) (*Queue, error) {
+	if config.Capacity < 0 {
+		config.Capacity = 0
+	}
+	q := &Queue{
+		config:  config,
+		c:       make(chan *Element, config.Capacity),
+		done:    make(chan struct{}),
+		metrics: metricsFor(config.Name),
+		logger:  logger.WithField("name", config.Name),
+	}
+	if config.Policy == SpillPolicy {
+		if config.Encode == nil || config.Decode == nil {
+			return nil, ErrMissingCodec
+		}
+		s, err := newSpillover(config.SpillDir, config.Name, config.Decode, q.c)
+		if err != nil {
+			q.logger.WithError(err).Error("Could not create spillover file")
+			return nil, err
+		}
+		q.spill = s
+	}
+	return q, nil
+}
+
+// MustNew returns a new Queue, and panics if the Config is invalid.
+func MustNew(config Config) *Queue {
+	q, err := New(config)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// Name returns the name of the queue.
+func (q *Queue) Name() string {
+	return q.config.Name
+}
+
+// Push adds a value at the end of the queue, applying the configured Policy if the queue is full.
+func (q *Queue) Push(value interface{}) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	e := &Element{Value: value, PushedAt: time.Now()}
+	q.metrics.pushed.Add(1)
+
+	switch q.config.Policy {
+	case DropPolicy:
+		select {
+		case q.c <- e:
+		default:
+			q.metrics.dropped.Add(1)
+			q.logger.Debug("Dropping element because queue is full")
+			return ErrQueueFull
+		}
+	case SpillPolicy:
+		// once the queue started spilling, all elements go to disk to preserve the order
+		if q.spill.pending() > 0 {
+			return q.spillElement(e)
+		}
+		select {
+		case q.c <- e:
+		default:
+			return q.spillElement(e)
+		}
+	default:
+		if len(q.c) == cap(q.c) {
+			q.metrics.blocked.Add(1)
+		}
+		select {
+		case q.c <- e:
+		case <-q.done:
+			return ErrQueueClosed
+		}
+	}
+	q.metrics.depth.Set(int64(q.Len()))
+	return nil
+}
+
+func (q *Queue) spillElement(e *Element) error {
+	data, err := q.config.Encode(e.Value)
+	if err != nil {
+		q.logger.WithError(err).Error("Could not encode element for spillover")
+		q.metrics.dropped.Add(1)
+		return err
+	}
+	if err := q.spill.write(data); err != nil {
+		q.logger.WithError(err).Error("Could not write element to spillover file")
+		q.metrics.dropped.Add(1)
+		return err
+	}
+	q.metrics.spilled.Add(1)
+	return nil
+}
+
+// C returns the channel from which the elements can be received.
+// Every received Element should be passed to Take, so that the metrics are kept up-to-date.
+func (q *Queue) C() <-chan *Element {
+	return q.c
+}
+
+// Take records the metrics for an Element received from C, and returns its value.
+func (q *Queue) Take(e *Element) interface{} {
+	q.metrics.taken.Add(1)
+	q.metrics.waitNanos.Add(int64(time.Since(e.PushedAt)))
+	q.metrics.depth.Set(int64(q.Len()))
+	return e.Value
+}
+
+// Pop blocks until an element is available and returns its value.
+// The second returned value is false if the queue was closed and there are no more elements.
+func (q *Queue) Pop() (interface{}, bool) {
+	e, ok := <-q.c
+	if !ok {
+		return nil, false
+	}
+	return q.Take(e), true
+}
+
+// Len returns the number of elements in the queue (including the ones spilled to disk).
+func (q *Queue) Len() int {
+	if q.spill != nil {
+		return len(q.c) + q.spill.pending()
+	}
+	return len(q.c)
+}
+
+// Cap returns the in-memory capacity of the queue.
+func (q *Queue) Cap() int {
+	return cap(q.c)
+}
+
+// Close closes the queue; elements already in the queue can still be received.
+// The pushes blocked on the full queue return ErrQueueClosed.
+func (q *Queue) Close() error {
+	// the blocked pushes hold the read lock, so they are released before taking the write lock
+	q.closeOnce.Do(func() { close(q.done) })
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	q.closed = true
+
+	if q.spill != nil {
+		if err := q.spill.close(); err != nil {
+			q.logger.WithError(err).Error("Error closing spillover file")
+		}
+	}
+	close(q.c)
+	return nil
+}
@@ -0,0 +1,46 @@
+package queue
+
+import (
+	"sync"
+
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns = metrics.NS("queue")
+
+	// registry keeps the metrics of each queue name, since expvar allows registering a name only once
+	registry   = make(map[string]*queueMetrics)
+	registryMu sync.Mutex
+)
+
+type queueMetrics struct {
+	depth     metrics.Int
+	pushed    metrics.Int
+	taken     metrics.Int
+	dropped   metrics.Int
+	blocked   metrics.Int
+	spilled   metrics.Int
+	waitNanos metrics.Int
+}
+
+func metricsFor(name string) *queueMetrics {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if m, ok := registry[name]; ok {
+		return m
+	}
+	qns := ns.NewNS(name)
+	m := &queueMetrics{
+		depth:     qns.NewInt("current_depth"),
+		pushed:    qns.NewInt("total_pushed"),
+		taken:     qns.NewInt("total_taken"),
+		dropped:   qns.NewInt("total_dropped"),
+		blocked:   qns.NewInt("total_blocked_pushes"),
+		spilled:   qns.NewInt("total_spilled"),
+		waitNanos: qns.NewInt("total_wait_nanos"),
+	}
+	registry[name] = m
+	return m
+}
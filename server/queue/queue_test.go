@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue_PushAndPop(t *testing.T) {
+	a := assert.New(t)
+
+	q := MustNew(Config{Name: "test_push_pop", Capacity: 3})
+	a.NoError(q.Push(1))
+	a.NoError(q.Push(2))
+	a.Equal(2, q.Len())
+	a.Equal(3, q.Cap())
+
+	v, ok := q.Pop()
+	a.True(ok)
+	a.Equal(1, v)
+
+	e := <-q.C()
+	a.Equal(2, q.Take(e))
+	a.Equal(0, q.Len())
+
+	a.NoError(q.Close())
+	_, ok = q.Pop()
+	a.False(ok)
+	a.Equal(ErrQueueClosed, q.Push(3))
+}
+
+func TestQueue_DropPolicy(t *testing.T) {
+	a := assert.New(t)
+
+	q := MustNew(Config{Name: "test_drop", Capacity: 1, Policy: DropPolicy})
+	a.NoError(q.Push(1))
+	a.Equal(ErrQueueFull, q.Push(2))
+	a.Equal(1, q.Len())
+}
+
+func TestQueue_BlockPolicy(t *testing.T) {
+	a := assert.New(t)
+
+	q := MustNew(Config{Name: "test_block", Capacity: 1})
+	a.NoError(q.Push(1))
+
+	doneC := make(chan bool)
+	go func() {
+		q.Push(2)
+		doneC <- true
+	}()
+
+	select {
+	case <-doneC:
+		a.Fail("push should block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	v, _ := q.Pop()
+	a.Equal(1, v)
+	select {
+	case <-doneC:
+	case <-time.After(time.Second):
+		a.Fail("push should continue after an element was taken")
+	}
+}
+
+func TestQueue_CloseReleasesBlockedPush(t *testing.T) {
+	a := assert.New(t)
+
+	q := MustNew(Config{Name: "test_block_close", Capacity: 1})
+	a.NoError(q.Push(1))
+
+	errC := make(chan error)
+	go func() {
+		errC <- q.Push(2)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	closedC := make(chan error)
+	go func() {
+		closedC <- q.Close()
+	}()
+	select {
+	case err := <-errC:
+		a.Equal(ErrQueueClosed, err)
+	case <-time.After(time.Second):
+		a.Fail("the blocked push should return when the queue is closed")
+	}
+	select {
+	case err := <-closedC:
+		a.NoError(err)
+	case <-time.After(time.Second):
+		a.Fail("close should not wait for the blocked push")
+	}
+}
+
+func TestQueue_SpillPolicyRequiresCodec(t *testing.T) {
+	_, err := New(Config{Name: "test_spill_codec", Capacity: 1, Policy: SpillPolicy})
+	assert.Equal(t, ErrMissingCodec, err)
+}
+
+func TestQueue_SpillPolicyKeepsOrder(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "guble_queue_test")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	q, err := New(Config{
+		Name:     "test_spill",
+		Capacity: 2,
+		Policy:   SpillPolicy,
+		SpillDir: dir,
+		Encode:   func(v interface{}) ([]byte, error) { return []byte(v.(string)), nil },
+		Decode:   func(data []byte) (interface{}, error) { return string(data), nil },
+	})
+	a.NoError(err)
+
+	values := []string{"a", "b", "c", "d", "e"}
+	for _, v := range values {
+		a.NoError(q.Push(v))
+	}
+	a.Equal(len(values), q.Len())
+
+	for _, expected := range values {
+		v, ok := q.Pop()
+		a.True(ok)
+		a.Equal(expected, v)
+	}
+	a.NoError(q.Close())
+}
@@ -0,0 +1,161 @@
+package queue
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// recordHeaderSize is the size of a spillover record header: the push time as 64 bit and the data size as 32 bit
+const recordHeaderSize = 12
+
+// spillover is an append-only file holding the elements which did not fit in the in-memory queue.
+// A goroutine moves the elements back into the queue channel, in the same order in which they were written.
+type spillover struct {
+	file        *os.File
+	readOffset  int64
+	writeOffset int64
+	count       int
+	mu          sync.Mutex
+
+	decode func([]byte) (interface{}, error)
+	c      chan<- *Element
+
+	notifyC chan struct{}
+	stopC   chan struct{}
+	doneC   chan struct{}
+}
+
+func newSpillover(dir, name string, decode func([]byte) (interface{}, error), c chan<- *Element) (*spillover, error) {
+	file, err := ioutil.TempFile(dir, name+"-spill-")
+	if err != nil {
+		return nil, err
+	}
+	s := &spillover{
+		file:    file,
+		decode:  decode,
+		c:       c,
+		notifyC: make(chan struct{}, 1),
+		stopC:   make(chan struct{}),
+		doneC:   make(chan struct{}),
+	}
+	go s.drain()
+	return s, nil
+}
+
+func (s *spillover) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+func (s *spillover) write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.LittleEndian.PutUint64(record, uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(data)))
+	copy(record[recordHeaderSize:], data)
+
+	if _, err := s.file.WriteAt(record, s.writeOffset); err != nil {
+		return err
+	}
+	s.writeOffset += int64(len(record))
+	s.count++
+
+	select {
+	case s.notifyC <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// read returns the next element from the file, without advancing the read offset.
+func (s *spillover) read() (*Element, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	header := make([]byte, recordHeaderSize)
+	if _, err := s.file.ReadAt(header, s.readOffset); err != nil {
+		return nil, 0, err
+	}
+	pushedAt := time.Unix(0, int64(binary.LittleEndian.Uint64(header)))
+	size := binary.LittleEndian.Uint32(header[8:])
+
+	data := make([]byte, size)
+	if _, err := s.file.ReadAt(data, s.readOffset+recordHeaderSize); err != nil {
+		return nil, 0, err
+	}
+	value, err := s.decode(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &Element{Value: value, PushedAt: pushedAt}, int64(recordHeaderSize + len(data)), nil
+}
+
+// advance moves the read offset past the last read element, and truncates the file once it is fully drained.
+func (s *spillover) advance(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readOffset += n
+	s.count--
+	if s.count == 0 {
+		s.truncate()
+	}
+}
+
+func (s *spillover) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.count = 0
+	s.truncate()
+}
+
+func (s *spillover) truncate() {
+	s.readOffset, s.writeOffset = 0, 0
+	if err := s.file.Truncate(0); err != nil {
+		logger.WithError(err).Error("Error truncating spillover file")
+	}
+}
+
+func (s *spillover) drain() {
+	defer close(s.doneC)
+	for {
+		if s.pending() == 0 {
+			select {
+			case <-s.notifyC:
+				continue
+			case <-s.stopC:
+				return
+			}
+		}
+
+		e, n, err := s.read()
+		if err != nil {
+			logger.WithError(err).Error("Error reading from spillover file; discarding the spilled elements")
+			s.reset()
+			continue
+		}
+		select {
+		case s.c <- e:
+			s.advance(n)
+		case <-s.stopC:
+			return
+		}
+	}
+}
+
+func (s *spillover) close() error {
+	close(s.stopC)
+	<-s.doneC
+	name := s.file.Name()
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,42 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/server/auth"
+)
+
+// authorized returns true if the request has a token with one of the scopes (the admin scope, if none is given),
+// and otherwise refuses it. All the requests are refused without an Authenticator.
+func authorized(w http.ResponseWriter, r *http.Request, authenticator auth.Authenticator, scopes ...string) bool {
+	if authenticator == nil {
+		refuse(w, http.StatusForbidden, "The endpoint requires an authentication provider.")
+		return false
+	}
+	identity, err := authenticator.Authenticate(auth.RequestToken(r))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		refuse(w, http.StatusUnauthorized, "Unauthorized: a valid token is required.")
+		return false
+	}
+	if len(scopes) == 0 {
+		scopes = []string{auth.AdminScope}
+	}
+	for _, scope := range scopes {
+		if identity.HasScope(scope) {
+			return true
+		}
+	}
+	log.WithField("user", identity.UserID).WithField("path", r.URL.Path).Warn("Forbidden request")
+	refuse(w, http.StatusForbidden, fmt.Sprintf("Forbidden: the %s scope is required.", strings.Join(scopes, " or ")))
+	return false
+}
+
+func refuse(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	http.Error(w, fmt.Sprintf(`{"error": %q}`, message), status)
+}
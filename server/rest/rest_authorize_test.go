@@ -0,0 +1,66 @@
+package rest
+
+import (
+	"github.com/smancke/guble/server/auth"
+
+	"github.com/stretchr/testify/assert"
+
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testAuthenticator authenticates the tokens "marvin" (a user), "backend" (with the backend scope)
+// and "admin" (with the admin scope).
+var testAuthenticator = authenticatorFunc(func(token string) (*auth.Identity, error) {
+	switch token {
+	case "marvin":
+		return &auth.Identity{UserID: "marvin"}, nil
+	case "backend":
+		return &auth.Identity{UserID: "app", Scopes: []string{auth.BackendScope}}, nil
+	case "admin":
+		return &auth.Identity{UserID: "ops", Scopes: []string{auth.AdminScope}}, nil
+	}
+	return nil, auth.ErrInvalidToken
+})
+
+// tokenRequest returns a request with the token, if not empty.
+func tokenRequest(method, url string, body io.Reader, token string) *http.Request {
+	req := httptest.NewRequest(method, url, body)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+// adminRequest returns a request with a token of the admin scope.
+func adminRequest(method, url string, body io.Reader) *http.Request {
+	return tokenRequest(method, url, body, "admin")
+}
+
+func TestAuthorized(t *testing.T) {
+	a := assert.New(t)
+
+	serve := func(authenticator auth.Authenticator, token string, scopes ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		if authorized(w, tokenRequest(http.MethodGet, "/admin/", nil, token), authenticator, scopes...) {
+			w.WriteHeader(http.StatusOK)
+		}
+		return w
+	}
+
+	a.Equal(http.StatusForbidden, serve(nil, "admin").Code)
+	a.Equal(http.StatusUnauthorized, serve(testAuthenticator, "").Code)
+	a.Equal(http.StatusUnauthorized, serve(testAuthenticator, "unknown").Code)
+	a.Equal(http.StatusForbidden, serve(testAuthenticator, "marvin").Code)
+	a.Equal(http.StatusOK, serve(testAuthenticator, "admin").Code)
+	a.Equal(http.StatusForbidden, serve(testAuthenticator, "backend").Code)
+
+	// one of the scopes is required
+	a.Equal(http.StatusOK, serve(testAuthenticator, "backend", auth.BackendScope, auth.AdminScope).Code)
+	a.Equal(http.StatusOK, serve(testAuthenticator, "admin", auth.BackendScope, auth.AdminScope).Code)
+	w := serve(testAuthenticator, "marvin", auth.BackendScope, auth.AdminScope)
+	a.Equal(http.StatusForbidden, w.Code)
+	a.Contains(w.Body.String(), "the backend or admin scope is required")
+}
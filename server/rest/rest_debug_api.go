@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+
+	"github.com/smancke/guble/server/auth"
+)
+
+// DebugAPI is an admin endpoint for profiling a running server:
+// `<prefix>pprof/` serves the profiles of net/http/pprof (e.g. `<prefix>pprof/heap`, `<prefix>pprof/profile?seconds=30`),
+// `<prefix>vars` the expvar variables and `<prefix>goroutines` the stack traces of all the goroutines.
+// All the requests require a token with the admin scope, so the endpoint is refused without an Authenticator.
+type DebugAPI struct {
+	prefix        string
+	authenticator auth.Authenticator
+}
+
+// NewDebugAPI returns a new DebugAPI.
+func NewDebugAPI(prefix string, authenticator auth.Authenticator) *DebugAPI {
+	return &DebugAPI{
+		prefix:        prefix,
+		authenticator: authenticator,
+	}
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (api *DebugAPI) GetPrefix() string {
+	return api.prefix
+}
+
+// ServeHTTP is an http.Handler.
+// It is a part of the service.endpoint implementation.
+func (api *DebugAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r, api.authenticator) {
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, api.prefix)
+	switch {
+	case path == "vars":
+		expvar.Handler().ServeHTTP(w, r)
+	case path == "goroutines":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(stacks())
+	case path == "pprof/" || path == "pprof":
+		pprof.Index(w, r)
+	case path == "pprof/cmdline":
+		pprof.Cmdline(w, r)
+	case path == "pprof/profile":
+		pprof.Profile(w, r)
+	case path == "pprof/symbol":
+		pprof.Symbol(w, r)
+	case path == "pprof/trace":
+		pprof.Trace(w, r)
+	case strings.HasPrefix(path, "pprof/"):
+		// the named profiles, e.g. heap or goroutine
+		pprof.Handler(strings.TrimPrefix(path, "pprof/")).ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// stacks returns the stack traces of all the goroutines.
+func stacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,44 @@
+package rest
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugAPI_ServeHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	api := NewDebugAPI("/admin/debug/", testAuthenticator)
+	get := func(url, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, tokenRequest(http.MethodGet, url, nil, token))
+		return w
+	}
+
+	a.Equal(http.StatusUnauthorized, get("/admin/debug/goroutines", "").Code)
+	a.Equal(http.StatusForbidden, get("/admin/debug/goroutines", "marvin").Code)
+
+	w := get("/admin/debug/goroutines", "admin")
+	a.Equal(http.StatusOK, w.Code)
+	a.True(strings.Contains(w.Body.String(), "TestDebugAPI_ServeHTTP"))
+
+	w = get("/admin/debug/vars", "admin")
+	a.Equal(http.StatusOK, w.Code)
+	a.True(strings.Contains(w.Body.String(), `"memstats"`))
+
+	w = get("/admin/debug/pprof/heap?debug=1", "admin")
+	a.Equal(http.StatusOK, w.Code)
+	a.True(strings.Contains(w.Body.String(), "heap profile"))
+
+	a.Equal(http.StatusNotFound, get("/admin/debug/other", "admin").Code)
+}
+
+func TestDebugAPI_RefusedWithoutAuthenticator(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewDebugAPI("/admin/debug/", nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/debug/vars", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
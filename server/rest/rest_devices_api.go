@@ -0,0 +1,81 @@
+package rest
+
+import (
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/connector"
+
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const devicesPath = "/devices"
+
+// DevicesAPI is an admin endpoint listing the devices and channels registered by a user in all the connectors:
+// `GET <prefix><userID>/devices` returns the devices (APNS device ids, FCM tokens, phone numbers)
+// with the topics they are subscribed to. All the requests require a token with the admin scope.
+// It shares the prefix of the UsersAPI, which serves it when both are used (see UsersAPI.SetDevices).
+type DevicesAPI struct {
+	listers       []connector.DeviceLister
+	prefix        string
+	authenticator auth.Authenticator
+}
+
+// NewDevicesAPI returns a new DevicesAPI, listing the devices of the given connectors.
+func NewDevicesAPI(prefix string, authenticator auth.Authenticator, listers ...connector.DeviceLister) *DevicesAPI {
+	return &DevicesAPI{listers, prefix, authenticator}
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (api *DevicesAPI) GetPrefix() string {
+	return api.prefix
+}
+
+// ServeHTTP is an http.Handler.
+// It is a part of the service.endpoint implementation.
+func (api *DevicesAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !authorized(w, r, api.authenticator) {
+		return
+	}
+	api.serve(w, r)
+}
+
+// serve lists the devices of an authorized request.
+func (api *DevicesAPI) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET is accepted."}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, removeTrailingSlash(api.prefix))
+	if !strings.HasSuffix(path, devicesPath) {
+		http.NotFound(w, r)
+		return
+	}
+	userID := strings.Trim(strings.TrimSuffix(path, devicesPath), "/")
+	if userID == "" || strings.Contains(userID, "/") {
+		http.Error(w, `{"error": "Missing user id."}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(api.Devices(userID)); err != nil {
+		log.WithError(err).Error("Encoding the devices failed")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+	}
+}
+
+// Devices returns the devices of the user in all the connectors, sorted by their connectors and ids.
+func (api *DevicesAPI) Devices(userID string) []connector.Device {
+	devices := make([]connector.Device, 0)
+	for _, lister := range api.listers {
+		devices = append(devices, lister.Devices(userID)...)
+	}
+	sort.Sort(connector.DevicesByID(devices))
+	return devices
+}
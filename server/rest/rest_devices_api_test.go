@@ -0,0 +1,85 @@
+package rest
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/connector"
+
+	"github.com/stretchr/testify/assert"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type deviceListerFunc func(userID string) []connector.Device
+
+func (f deviceListerFunc) Devices(userID string) []connector.Device {
+	return f(userID)
+}
+
+func TestDevicesAPI_ServeHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	lastSuccess := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	fcmLister := deviceListerFunc(func(userID string) []connector.Device {
+		if userID != "marvin" {
+			return nil
+		}
+		return []connector.Device{{
+			Connector: "fcm",
+			ID:        "token1",
+			Subscriptions: []connector.DeviceSubscription{
+				{Topic: "/news", LastID: 42, LastSuccess: &lastSuccess},
+			},
+		}}
+	})
+	smsLister := deviceListerFunc(func(userID string) []connector.Device {
+		return []connector.Device{{
+			Connector:     "sms",
+			ID:            "+4917012345",
+			Subscriptions: []connector.DeviceSubscription{{Topic: protocol.Path("/notification-channels/" + userID + "/1")}},
+		}}
+	})
+	api := NewDevicesAPI("/admin/user/", testAuthenticator, smsLister, fcmLister)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/user/marvin/devices", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("application/json", w.Header().Get("Content-Type"))
+
+	var devices []connector.Device
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &devices))
+	a.Equal(2, len(devices))
+	a.Equal("fcm", devices[0].Connector)
+	a.Equal("token1", devices[0].ID)
+	a.Equal(uint64(42), devices[0].Subscriptions[0].LastID)
+	a.True(lastSuccess.Equal(*devices[0].Subscriptions[0].LastSuccess))
+	a.Equal("sms", devices[1].Connector)
+	a.Nil(devices[1].Subscriptions[0].LastSuccess)
+
+	// a user without devices
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/user/trillian/devices", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &devices))
+	a.Equal(1, len(devices))
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/user//devices", nil))
+	a.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/user/marvin", nil))
+	a.Equal(http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodDelete, "/admin/user/marvin/devices", nil))
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	// the devices are only listed to the admins
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, tokenRequest(http.MethodGet, "/admin/user/marvin/devices", nil, "marvin"))
+	a.Equal(http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,346 @@
+package rest
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store"
+
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// maxDumpLineSize is the maximum size of a line of an imported dump.
+const maxDumpLineSize = 16 * 1024 * 1024
+
+// DumpAPI is an admin endpoint exporting and importing the stored messages as newline-delimited JSON:
+// `GET <prefix><path>` exports the messages of the given topic path (and of its subtopics) in the order of their ids,
+// `GET <prefix>?user_id=<userID>` exports the messages published by or addressed to the user, across all the topics,
+// `POST <prefix>` imports such a dump into the message store, keeping the ids of the messages.
+// The imported messages are only stored, they are not delivered to the subscribers.
+// An export with `redact=true` masks the redacted bodies (see protocol.Message.Redacted), and cannot be imported.
+// All the requests require a token with the admin scope, so the endpoint is refused without an Authenticator.
+type DumpAPI struct {
+	router        router.Router
+	prefix        string
+	authenticator auth.Authenticator
+}
+
+// dumpedMessage is a line of a dump.
+type dumpedMessage struct {
+	ID            uint64            `json:"id"`
+	Path          protocol.Path     `json:"path"`
+	UserID        string            `json:"user_id,omitempty"`
+	ApplicationID string            `json:"application_id,omitempty"`
+	Filters       map[string]string `json:"filters,omitempty"`
+	Time          int64             `json:"time"`
+	NodeID        uint8             `json:"node_id,omitempty"`
+	Headers       string            `json:"headers,omitempty"`
+	ContentType   string            `json:"content_type,omitempty"`
+	Body          []byte            `json:"body"`
+	Redacted      bool              `json:"redacted,omitempty"`
+}
+
+func newDumpedMessage(m *protocol.Message, redact bool) *dumpedMessage {
+	d := &dumpedMessage{
+		ID:            m.ID,
+		Path:          m.Path,
+		UserID:        m.UserID,
+		ApplicationID: m.ApplicationID,
+		Filters:       m.Filters,
+		Time:          m.Time,
+		NodeID:        m.NodeID,
+		Headers:       m.HeaderJSON,
+		ContentType:   m.ContentType,
+		Body:          m.Body,
+	}
+	if redact && m.Redacted() {
+		d.Body = []byte(protocol.RedactedBody)
+		d.Redacted = true
+	}
+	return d
+}
+
+func (d *dumpedMessage) message() *protocol.Message {
+	return &protocol.Message{
+		ID:            d.ID,
+		Path:          d.Path,
+		UserID:        d.UserID,
+		ApplicationID: d.ApplicationID,
+		Filters:       d.Filters,
+		Time:          d.Time,
+		NodeID:        d.NodeID,
+		HeaderJSON:    d.Headers,
+		ContentType:   d.ContentType,
+		Body:          d.Body,
+	}
+}
+
+// NewDumpAPI returns a new DumpAPI.
+func NewDumpAPI(router router.Router, prefix string, authenticator auth.Authenticator) *DumpAPI {
+	return &DumpAPI{router, prefix, authenticator}
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (api *DumpAPI) GetPrefix() string {
+	return api.prefix
+}
+
+// ServeHTTP is an http.Handler.
+// It is a part of the service.endpoint implementation.
+func (api *DumpAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r, api.authenticator) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		api.export(w, r)
+	case http.MethodPost:
+		api.importDump(w, r)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET and POST are accepted."}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// export writes the messages of a topic, or the messages of a user across all the topics, one JSON object per line.
+func (api *DumpAPI) export(w http.ResponseWriter, r *http.Request) {
+	topic := api.extractTopic(r.URL.Path)
+	userID := q(r, "user_id")
+	redact := q(r, "redact") == "true"
+	if topic == "" && userID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Missing topic or user_id."}`, http.StatusBadRequest)
+		return
+	}
+
+	partitions, err := api.exportedPartitions(topic)
+	if err != nil {
+		log.WithError(err).Error("Reading the topic partitions failed")
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	if topic != "" && len(partitions) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Topic not found."}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if userID != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "user-"+userID+".ndjson"))
+	}
+	encoder := json.NewEncoder(w)
+	exported := 0
+	for _, partition := range partitions {
+		n, err := exportPartition(encoder, partition, redact, func(m *protocol.Message) bool {
+			return (topic == "" || m.Path.InTopic(topic)) && (userID == "" || isOfUser(m, userID))
+		})
+		exported += n
+		if err != nil {
+			// the response is already started, so the export is only cut short
+			log.WithError(err).WithField("partition", partition.Name()).Error("Exporting the partition failed")
+			return
+		}
+	}
+	log.WithFields(log.Fields{"topic": topic, "userID": userID, "exported": exported}).Info("Exported messages")
+}
+
+// exportedPartitions returns the partition of the topic, or all the partitions sorted by name if no topic is given.
+func (api *DumpAPI) exportedPartitions(topic protocol.Path) ([]store.MessagePartition, error) {
+	if topic != "" {
+		partition, err := api.partition(topic.Partition())
+		if err != nil || partition == nil {
+			return nil, err
+		}
+		return []store.MessagePartition{partition}, nil
+	}
+
+	messageStore, err := api.router.MessageStore()
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := messageStore.Partitions()
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(partitionsByName(partitions))
+	return partitions, nil
+}
+
+// exportPartition writes the accepted messages of a partition in the order of their ids, returning their number.
+func exportPartition(encoder *json.Encoder, partition store.MessagePartition, redact bool, accept func(*protocol.Message) bool) (int, error) {
+	req := store.NewFetchRequest(partition.Name(), 0, 0, store.DirectionForward, math.MaxInt32)
+	req.Init()
+	partition.Fetch(req)
+
+	exported := 0
+	for {
+		select {
+		case <-req.StartC:
+		case fetched, open := <-req.MessageC:
+			if !open {
+				return exported, nil
+			}
+			m, err := protocol.ParseMessage(fetched.Message)
+			if err != nil {
+				log.WithError(err).WithField("id", fetched.ID).Error("Skipping a stored message which cannot be parsed")
+				continue
+			}
+			if !accept(m) {
+				continue
+			}
+			if err := encoder.Encode(newDumpedMessage(m, redact)); err != nil {
+				go drain(req)
+				return exported, err
+			}
+			exported++
+		case err := <-req.ErrorC:
+			return exported, err
+		}
+	}
+}
+
+// isOfUser returns true if the message was published by the user, or addressed to the user by the user_id filter.
+func isOfUser(m *protocol.Message, userID string) bool {
+	return m.UserID == userID || m.Filters["user_id"] == userID
+}
+
+type partitionsByName []store.MessagePartition
+
+func (p partitionsByName) Len() int           { return len(p) }
+func (p partitionsByName) Less(i, j int) bool { return p[i].Name() < p[j].Name() }
+func (p partitionsByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// importDump stores the messages of a dump into the partitions of their paths.
+// If the URL contains a topic, all the messages have to belong to it.
+func (api *DumpAPI) importDump(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	messageStore, err := api.router.MessageStore()
+	if err != nil {
+		log.WithError(err).Error("Getting the message store failed")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+
+	topic := api.extractTopic(r.URL.Path)
+	imported, err := importMessages(messageStore, r, topic)
+	if err != nil {
+		log.WithError(err).WithField("imported", imported).Error("Importing the dump failed")
+		status := http.StatusBadRequest
+		if _, ok := err.(*dumpLineError); !ok {
+			status = http.StatusInternalServerError
+		}
+		body, _ := json.Marshal(map[string]interface{}{"error": err.Error(), "imported": imported})
+		http.Error(w, string(body), status)
+		return
+	}
+
+	log.WithFields(log.Fields{"topic": topic, "imported": imported}).Info("Imported dump")
+	fmt.Fprintf(w, `{"imported": %d}`, imported)
+}
+
+// dumpLineError is an invalid line of an imported dump.
+type dumpLineError struct {
+	line int
+	err  error
+}
+
+func (e *dumpLineError) Error() string {
+	return fmt.Sprintf("Invalid message on line %d: %v", e.line, e.err)
+}
+
+var (
+	errMissingID      = errors.New("missing id")
+	errOutsideOfTopic = errors.New("path outside of the topic")
+	errRedacted       = errors.New("redacted body")
+)
+
+func importMessages(messageStore store.MessageStore, r *http.Request, topic protocol.Path) (int, error) {
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxDumpLineSize)
+
+	imported := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var d dumpedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			return imported, &dumpLineError{line, err}
+		}
+		if d.ID == 0 {
+			return imported, &dumpLineError{line, errMissingID}
+		}
+		if d.Path == "" || (topic != "" && !d.Path.InTopic(topic)) {
+			return imported, &dumpLineError{line, errOutsideOfTopic}
+		}
+		if d.Redacted {
+			return imported, &dumpLineError{line, errRedacted}
+		}
+
+		m := d.message()
+		if err := messageStore.Store(m.Path.Partition(), m.ID, m.Bytes()); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	if err := scanner.Err(); err != nil {
+		return imported, err
+	}
+	return imported, nil
+}
+
+// extractTopic returns the topic path following the prefix, or "" if there is none.
+func (api *DumpAPI) extractTopic(path string) protocol.Path {
+	topic := strings.Trim(strings.TrimPrefix(path, removeTrailingSlash(api.prefix)), "/")
+	if topic == "" {
+		return ""
+	}
+	return protocol.Path("/" + topic)
+}
+
+// partition returns the partition of the message store with the given name, or nil if it does not exist.
+func (api *DumpAPI) partition(name string) (store.MessagePartition, error) {
+	messageStore, err := api.router.MessageStore()
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := messageStore.Partitions()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range partitions {
+		if p.Name() == name {
+			return p, nil
+		}
+	}
+	return nil, nil
+}
+
+// drain consumes the rest of a fetch request which is not read anymore, so that the fetching goroutine can finish.
+func drain(req *store.FetchRequest) {
+	for {
+		select {
+		case _, open := <-req.MessageC:
+			if !open {
+				return
+			}
+		case <-req.ErrorC:
+			return
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package rest
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store/filestore"
+	"github.com/smancke/guble/testutil"
+
+	"github.com/stretchr/testify/assert"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDumpAPI_ExportAndImport(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_rest_dump_test")
+	defer os.RemoveAll(dir)
+
+	sourceStore := filestore.New(dir + "/source")
+	defer sourceStore.Stop()
+	messages := []*protocol.Message{
+		{ID: 1, Path: "/foo/bar", UserID: "user01", Time: 1420110001, HeaderJSON: `{"Content-Type": "text/plain"}`, Body: []byte("Hello")},
+		{ID: 2, Path: "/foo/baz", ApplicationID: "phone1", Time: 1420110002, Body: []byte{0, 1, 2}},
+		{ID: 3, Path: "/foo/bar/sub", Filters: map[string]string{"user": "user01"}, Time: 1420110003, Body: []byte("World")},
+	}
+	for _, m := range messages {
+		a.NoError(sourceStore.Store("foo", m.ID, m.Bytes()))
+	}
+
+	sourceRouter := NewMockRouter(ctrl)
+	sourceRouter.EXPECT().MessageStore().Return(sourceStore, nil).AnyTimes()
+	sourceAPI := NewDumpAPI(sourceRouter, "/admin/dump/", testAuthenticator)
+
+	// the export of a topic contains its subtopics
+	w := httptest.NewRecorder()
+	sourceAPI.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/dump/foo/bar", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("application/x-ndjson", w.Header().Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	a.Equal(2, len(lines))
+	var first dumpedMessage
+	a.NoError(json.Unmarshal([]byte(lines[0]), &first))
+	a.Equal(uint64(1), first.ID)
+	a.Equal(protocol.Path("/foo/bar"), first.Path)
+	a.Equal(int64(1420110001), first.Time)
+	a.Equal(`{"Content-Type": "text/plain"}`, first.Headers)
+
+	// the whole partition is exported, and imported into another message store
+	w = httptest.NewRecorder()
+	sourceAPI.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/dump/foo", nil))
+	a.Equal(http.StatusOK, w.Code)
+	dump := w.Body.Bytes()
+
+	targetStore := filestore.New(dir + "/target")
+	defer targetStore.Stop()
+	targetRouter := NewMockRouter(ctrl)
+	targetRouter.EXPECT().MessageStore().Return(targetStore, nil).AnyTimes()
+	targetAPI := NewDumpAPI(targetRouter, "/admin/dump/", testAuthenticator)
+
+	w = httptest.NewRecorder()
+	targetAPI.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/dump/", bytes.NewReader(dump)))
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"imported": 3}`, w.Body.String())
+
+	maxID, err := targetStore.MaxMessageID("foo")
+	a.NoError(err)
+	a.Equal(uint64(3), maxID)
+
+	w = httptest.NewRecorder()
+	targetAPI.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/dump/foo", nil))
+	a.Equal(string(dump), w.Body.String())
+}
+
+func TestDumpAPI_ExportOfUser(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_rest_dump_test")
+	defer os.RemoveAll(dir)
+
+	messageStore := filestore.New(dir)
+	defer messageStore.Stop()
+	messages := []*protocol.Message{
+		{ID: 1, Path: "/foo/bar", UserID: "user01", Time: 1420110001, Body: []byte("Hello")},
+		{ID: 2, Path: "/foo/bar", UserID: "user02", Time: 1420110002, Body: []byte("Hello")},
+		{ID: 3, Path: "/foo/baz", UserID: "user02", Filters: map[string]string{"user_id": "user01"}, Time: 1420110003, Body: []byte("Hi")},
+		{ID: 1, Path: "/bar", UserID: "user01", Time: 1420110004, Body: []byte("World")},
+	}
+	for _, m := range messages {
+		a.NoError(messageStore.Store(m.Path.Partition(), m.ID, m.Bytes()))
+	}
+
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().MessageStore().Return(messageStore, nil).AnyTimes()
+	api := NewDumpAPI(routerMock, "/admin/dump/", testAuthenticator)
+
+	exportedIDs := func(url string) []string {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, adminRequest(http.MethodGet, url, nil))
+		a.Equal(http.StatusOK, w.Code)
+		a.Equal("application/x-ndjson", w.Header().Get("Content-Type"))
+		a.Equal(`attachment; filename="user-user01.ndjson"`, w.Header().Get("Content-Disposition"))
+
+		var ids []string
+		for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+			var d dumpedMessage
+			a.NoError(json.Unmarshal([]byte(line), &d))
+			ids = append(ids, fmt.Sprintf("%s:%d", d.Path, d.ID))
+		}
+		return ids
+	}
+
+	// the messages published by the user and addressed to the user, across all the topics
+	a.Equal([]string{"/bar:1", "/foo/bar:1", "/foo/baz:3"}, exportedIDs("/admin/dump/?user_id=user01"))
+
+	// the export of a user can be restricted to a topic
+	a.Equal([]string{"/foo/baz:3"}, exportedIDs("/admin/dump/foo/baz?user_id=user01"))
+}
+
+func TestDumpAPI_ExportRedacted(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+	a.NoError(protocol.SetRedactedTopics([]string{"/foo/secret"}))
+	defer protocol.SetRedactedTopics(nil)
+
+	dir, _ := ioutil.TempDir("", "guble_rest_dump_test")
+	defer os.RemoveAll(dir)
+
+	messageStore := filestore.New(dir)
+	defer messageStore.Stop()
+	messages := []*protocol.Message{
+		{ID: 1, Path: "/foo/bar", Time: 1420110001, Body: []byte("Hello")},
+		{ID: 2, Path: "/foo/bar", Time: 1420110002, HeaderJSON: `{"Redact": true}`, Body: []byte("Hello")},
+		{ID: 3, Path: "/foo/secret", Time: 1420110003, Body: []byte("Hello")},
+	}
+	for _, m := range messages {
+		a.NoError(messageStore.Store(m.Path.Partition(), m.ID, m.Bytes()))
+	}
+
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().MessageStore().Return(messageStore, nil).AnyTimes()
+	api := NewDumpAPI(routerMock, "/admin/dump/", testAuthenticator)
+
+	exported := func(url string) (bodies []string) {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, adminRequest(http.MethodGet, url, nil))
+		a.Equal(http.StatusOK, w.Code)
+		for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+			var d dumpedMessage
+			a.NoError(json.Unmarshal([]byte(line), &d))
+			a.Equal(string(d.Body) == protocol.RedactedBody, d.Redacted)
+			bodies = append(bodies, string(d.Body))
+		}
+		return
+	}
+
+	a.Equal([]string{"Hello", "Hello", "Hello"}, exported("/admin/dump/foo"))
+	a.Equal([]string{"Hello", protocol.RedactedBody, protocol.RedactedBody}, exported("/admin/dump/foo?redact=true"))
+}
+
+func TestDumpAPI_Errors(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_rest_dump_test")
+	defer os.RemoveAll(dir)
+
+	messageStore := filestore.New(dir)
+	defer messageStore.Stop()
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().MessageStore().Return(messageStore, nil).AnyTimes()
+	api := NewDumpAPI(routerMock, "/admin/dump/", testAuthenticator)
+
+	// the exports and imports are only allowed to the admins
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/dump/?user_id=marvin", nil))
+	a.Equal(http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, tokenRequest(http.MethodGet, "/admin/dump/?user_id=marvin", nil, "marvin"))
+	a.Equal(http.StatusForbidden, w.Code)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, tokenRequest(http.MethodPost, "/admin/dump/", strings.NewReader(""), "marvin"))
+	a.Equal(http.StatusForbidden, w.Code)
+
+	// an unknown topic
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/dump/unknown", nil))
+	a.Equal(http.StatusNotFound, w.Code)
+
+	// neither a topic nor a user
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/dump/", nil))
+	a.Equal(http.StatusBadRequest, w.Code)
+
+	// the import stops on the first invalid line
+	dump := `{"id":1,"path":"/foo","time":1420110001,"body":"SGVsbG8="}
+{"id":2,"path":"/bar","time":1420110002,"body":"SGVsbG8="}
+{"path":"/foo","time":1420110003}
+`
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/dump/foo", strings.NewReader(dump)))
+	a.Equal(http.StatusBadRequest, w.Code)
+	a.JSONEq(`{"error": "Invalid message on line 2: path outside of the topic", "imported": 1}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/dump/", strings.NewReader(dump)))
+	a.Equal(http.StatusBadRequest, w.Code)
+	a.JSONEq(`{"error": "Invalid message on line 3: missing id", "imported": 2}`, w.Body.String())
+
+	// a redacted dump cannot be imported
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/dump/",
+		strings.NewReader(`{"id":1,"path":"/foo","time":1420110001,"body":"W1JFREFDVEVEXQ==","redacted":true}`)))
+	a.Equal(http.StatusBadRequest, w.Code)
+	a.JSONEq(`{"error": "Invalid message on line 1: redacted body", "imported": 0}`, w.Body.String())
+
+	// only GET and POST are allowed
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodDelete, "/admin/dump/foo", nil))
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,98 @@
+package rest
+
+import (
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store"
+
+	"encoding/json"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// FsckAPI is an admin endpoint verifying the stored messages:
+// `GET <prefix>` checks all the partitions, `GET <prefix><path>` only the partition of the given topic path.
+// The partitions of message stores which cannot verify their messages are skipped.
+type FsckAPI struct {
+	router router.Router
+	prefix string
+}
+
+// fsckReport is the response of the FsckAPI.
+type fsckReport struct {
+	Checked []string              `json:"checked"`
+	Corrupt []*store.CorruptRange `json:"corrupt"`
+}
+
+// NewFsckAPI returns a new FsckAPI.
+func NewFsckAPI(router router.Router, prefix string) *FsckAPI {
+	return &FsckAPI{router, prefix}
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (api *FsckAPI) GetPrefix() string {
+	return api.prefix
+}
+
+// ServeHTTP is an http.Handler.
+// It is a part of the service.endpoint implementation.
+func (api *FsckAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET is accepted."}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	partition := extractPartition(api.prefix, r.URL.Path)
+	report, found, err := api.check(partition)
+	if err != nil {
+		log.WithError(err).Error("Checking the topic partitions failed")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	if partition != "" && !found {
+		http.Error(w, `{"error": "Topic not found."}`, http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.WithError(err).Error("Encoding the check report failed")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+	}
+}
+
+// check verifies all the partitions of the message store, or only the given partition if not empty,
+// and returns whether any partition was found.
+func (api *FsckAPI) check(partition string) (*fsckReport, bool, error) {
+	messageStore, err := api.router.MessageStore()
+	if err != nil {
+		return nil, false, err
+	}
+	partitions, err := messageStore.Partitions()
+	if err != nil {
+		return nil, false, err
+	}
+
+	report := &fsckReport{Checked: []string{}, Corrupt: []*store.CorruptRange{}}
+	found := false
+	for _, p := range partitions {
+		if partition != "" && p.Name() != partition {
+			continue
+		}
+		found = true
+
+		checker, ok := p.(store.PartitionChecker)
+		if !ok {
+			continue
+		}
+		ranges, err := checker.Check()
+		if err != nil {
+			return nil, false, err
+		}
+		report.Checked = append(report.Checked, p.Name())
+		report.Corrupt = append(report.Corrupt, ranges...)
+	}
+	return report, found, nil
+}
@@ -0,0 +1,76 @@
+package rest
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/store/filestore"
+	"github.com/smancke/guble/testutil"
+
+	"github.com/stretchr/testify/assert"
+
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFsckAPI_ServeHTTP(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_rest_fsck_test")
+	defer os.RemoveAll(dir)
+
+	messageStore := filestore.New(dir)
+	defer messageStore.Stop()
+	for id := 1; id <= 3; id++ {
+		m := &protocol.Message{ID: uint64(id), Path: "/foo/bar", Time: int64(1420110000 + id), Body: []byte("body")}
+		a.NoError(messageStore.Store("foo", m.ID, m.Bytes()))
+	}
+	m := &protocol.Message{ID: 1, Path: "/baz", Time: 1420110042, Body: []byte("body")}
+	a.NoError(messageStore.Store("baz", m.ID, m.Bytes()))
+
+	// the last byte of the last message of the partition foo is corrupted
+	msgFile, err := os.OpenFile(dir+"/foo/foo-00000000000000000000.msg", os.O_RDWR, 0666)
+	a.NoError(err)
+	stat, err := msgFile.Stat()
+	a.NoError(err)
+	_, err = msgFile.WriteAt([]byte("x"), stat.Size()-1)
+	a.NoError(err)
+	a.NoError(msgFile.Close())
+
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().MessageStore().Return(messageStore, nil).AnyTimes()
+	api := NewFsckAPI(routerMock, "/admin/fsck/")
+
+	// all the partitions
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/fsck/", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("application/json", w.Header().Get("Content-Type"))
+
+	var report fsckReport
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &report))
+	a.Equal([]string{"baz", "foo"}, report.Checked)
+	a.Equal([]*store.CorruptRange{{Partition: "foo", FromID: 3, ToID: 3, Count: 1}}, report.Corrupt)
+
+	// a single partition, by the path of a topic
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/fsck/baz", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"checked":["baz"],"corrupt":[]}`, w.Body.String())
+
+	// an unknown partition
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/fsck/unknown", nil))
+	a.Equal(http.StatusNotFound, w.Code)
+
+	// only GET is allowed
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/fsck/", nil))
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+}
@@ -7,12 +7,16 @@ import (
 	"github.com/azer/snakecase"
 
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/cloudevents"
 	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/throttle"
 
 	"github.com/rs/xid"
 
 	"bytes"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"strings"
 
@@ -29,13 +33,26 @@ var errNotFound = errors.New("Not Found.")
 
 // RestMessageAPI is a struct representing a router's connector for a REST API.
 type RestMessageAPI struct {
-	router router.Router
-	prefix string
+	router        router.Router
+	prefix        string
+	limiter       *throttle.Limiter
+	authenticator auth.Authenticator
 }
 
 // NewRestMessageAPI returns a new RestMessageAPI.
 func NewRestMessageAPI(router router.Router, prefix string) *RestMessageAPI {
-	return &RestMessageAPI{router, prefix}
+	return &RestMessageAPI{router: router, prefix: prefix}
+}
+
+// SetAuthenticator requires the publishers to authenticate (no authentication, if nil):
+// the messages are then published as the user of the token, which can only be chosen with `?userId=` by an admin.
+func (api *RestMessageAPI) SetAuthenticator(authenticator auth.Authenticator) {
+	api.authenticator = authenticator
+}
+
+// SetLimiter limits the rate of the publishing requests (no limit, if nil).
+func (api *RestMessageAPI) SetLimiter(limiter *throttle.Limiter) {
+	api.limiter = limiter
 }
 
 // GetPrefix returns the prefix.
@@ -82,6 +99,16 @@ func (api *RestMessageAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !api.limiter.Allow(w, r) {
+		return
+	}
+
+	userID, status, err := api.publisher(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Can not read body", http.StatusBadRequest)
@@ -98,21 +125,62 @@ func (api *RestMessageAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	contentType, err := contentType(r.Header)
+	if err != nil {
+		http.Error(w, "Invalid Content-Type", http.StatusBadRequest)
+		return
+	}
+
 	msg := &protocol.Message{
 		Path:          protocol.Path(topic),
 		Body:          body,
-		UserID:        q(r, "userId"),
+		UserID:        userID,
 		ApplicationID: xid.New().String(),
 		HeaderJSON:    headersToJSON(r.Header),
+		ContentType:   contentType,
+	}
+
+	// a message published as a CloudEvent keeps the attributes of the event in its header
+	if err := applyCloudEvent(r.Header, msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// add filters
 	api.setFilters(r, msg)
 
-	api.router.HandleMessage(msg)
+	if err := api.router.HandleMessage(msg); err != nil {
+		if rejected, ok := err.(*router.MessageRejectedError); ok {
+			http.Error(w, rejected.Error(), http.StatusBadRequest)
+			return
+		}
+		if err == router.ErrReadOnlyReplica {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
 	fmt.Fprintf(w, "OK")
 }
 
+// publisher returns the user publishing the request, or the http status and the error refusing it.
+func (api *RestMessageAPI) publisher(r *http.Request) (string, int, error) {
+	userID := q(r, "userId")
+	if api.authenticator == nil {
+		return userID, 0, nil
+	}
+	identity, err := api.authenticator.Authenticate(auth.RequestToken(r))
+	if err != nil {
+		return "", http.StatusUnauthorized, errors.New("Unauthorized: a valid token is required.")
+	}
+	if userID == "" {
+		return identity.UserID, 0, nil
+	}
+	if !identity.CanActAs(userID) {
+		return "", http.StatusForbidden, errors.New("Forbidden: the userId is not the user of the token.")
+	}
+	return userID, 0, nil
+}
+
 func (api *RestMessageAPI) extractTopic(path string, requestTypeTopicPrefix string) (string, error) {
 	p := removeTrailingSlash(api.prefix) + requestTypeTopicPrefix
 	if !strings.HasPrefix(path, p) {
@@ -126,6 +194,31 @@ func (api *RestMessageAPI) extractTopic(path string, requestTypeTopicPrefix stri
 	return topic, nil
 }
 
+// applyCloudEvent replaces the body, the content type and the header of a message published as a CloudEvent,
+// in the structured mode (with the content type `application/cloudevents+json`) or in the binary mode (with `ce-` headers).
+func applyCloudEvent(header http.Header, msg *protocol.Message) error {
+	var (
+		event *cloudevents.Event
+		err   error
+	)
+	switch {
+	case cloudevents.IsStructured(msg.ContentType):
+		event, err = cloudevents.ParseStructured(msg.Body)
+	case cloudevents.IsBinary(header):
+		event, err = cloudevents.ParseBinary(header, msg.Body)
+		if err == nil {
+			// the content type of the data was already validated
+			event.DataContentType = msg.ContentType
+		}
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return event.Apply(msg)
+}
+
 // setFilters sets a field found in the format `filterCamelCaseField` in the
 // query of the request to underscore format on the message filters
 func (api *RestMessageAPI) setFilters(r *http.Request, msg *protocol.Message) {
@@ -150,6 +243,19 @@ func filterName(name string) string {
 	return snakecase.SnakeCase(strings.TrimPrefix(name, filterPrefix))
 }
 
+// contentType returns the normalized Content-Type of the request, or "" if not given.
+func contentType(header http.Header) (string, error) {
+	value := header.Get("Content-Type")
+	if value == "" {
+		return "", nil
+	}
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return "", err
+	}
+	return mime.FormatMediaType(mediaType, params), nil
+}
+
 func headersToJSON(header http.Header) string {
 	buff := &bytes.Buffer{}
 	buff.WriteString("{")
@@ -2,6 +2,9 @@ package rest
 
 import (
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/throttle"
 	"github.com/smancke/guble/testutil"
 
 	"github.com/golang/mock/gomock"
@@ -54,6 +57,173 @@ func TestServerHTTP(t *testing.T) {
 	api.ServeHTTP(w, req)
 }
 
+// Server should return a 400 Bad Request in case the message is rejected by an interceptor
+func TestServerHTTP_RejectedMessage(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	routerMock.EXPECT().HandleMessage(gomock.Any()).
+		Return(&router.MessageRejectedError{Interceptor: "schema", Reason: "The body is no JSON."})
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/message/my/topic", bytes.NewReader(testBytes)))
+	a.Equal(http.StatusBadRequest, w.Code)
+	a.Contains(w.Body.String(), "The body is no JSON.")
+}
+
+// Server should return a 429 Too Many Requests in case the publisher exceeds its rate
+func TestServerHTTP_Throttled(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	api.SetLimiter(throttle.New(throttle.Config{Rate: 1}, "/admin/throttled/"))
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Return(nil)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/message/my/topic", bytes.NewReader(testBytes)))
+	a.Equal(http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/message/my/topic", bytes.NewReader(testBytes)))
+	a.Equal(http.StatusTooManyRequests, w.Code)
+	a.Equal("1", w.Header().Get("Retry-After"))
+}
+
+// authenticatorFunc is an auth.Authenticator authenticating with a function.
+type authenticatorFunc func(token string) (*auth.Identity, error)
+
+func (f authenticatorFunc) Authenticate(token string) (*auth.Identity, error) {
+	return f(token)
+}
+
+// Server should publish as the authenticated user, and refuse the other users (except for an admin)
+func TestServerHTTP_Authenticated(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	api.SetAuthenticator(authenticatorFunc(func(token string) (*auth.Identity, error) {
+		switch token {
+		case "marvin":
+			return &auth.Identity{UserID: "marvin"}, nil
+		case "admin":
+			return &auth.Identity{UserID: "ops", Scopes: []string{auth.AdminScope}}, nil
+		}
+		return nil, auth.ErrInvalidToken
+	}))
+	publish := func(url, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(testBytes))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	a.Equal(http.StatusUnauthorized, publish("/api/message/my/topic?userId=marvin", "").Code)
+	a.Equal(http.StatusForbidden, publish("/api/message/my/topic?userId=arthur", "marvin").Code)
+
+	var userIDs []string
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) {
+		userIDs = append(userIDs, msg.UserID)
+	}).Return(nil).Times(3)
+	a.Equal(http.StatusOK, publish("/api/message/my/topic", "marvin").Code)
+	a.Equal(http.StatusOK, publish("/api/message/my/topic?userId=marvin", "marvin").Code)
+	a.Equal(http.StatusOK, publish("/api/message/my/topic?userId=arthur", "admin").Code)
+	a.Equal([]string{"marvin", "marvin", "arthur"}, userIDs)
+}
+
+// Server should return a 403 Forbidden in case the message is published on a replica
+func TestServerHTTP_ReadOnlyReplica(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Return(router.ErrReadOnlyReplica)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/message/my/topic", bytes.NewReader(testBytes)))
+	a.Equal(http.StatusForbidden, w.Code)
+	a.Contains(w.Body.String(), "read-only replica")
+}
+
+// Server should pass the Content-Type of the request in the message
+func TestServerHTTP_ContentType(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) {
+		a.Equal("text/plain; charset=utf-8", msg.ContentType)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/message/my/topic", bytes.NewReader(testBytes))
+	req.Header.Set("Content-Type", "Text/Plain; Charset=utf-8")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	a.Equal(http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/message/my/topic", bytes.NewReader(testBytes))
+	req.Header.Set("Content-Type", "text/")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	a.Equal(http.StatusBadRequest, w.Code)
+}
+
+// Server should publish the data of a CloudEvent, with its attributes in the header of the message
+func TestServerHTTP_CloudEvent(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Times(2).Do(func(msg *protocol.Message) {
+		a.Equal("application/json", msg.ContentType)
+		a.JSONEq(`{"order": 42}`, string(msg.Body))
+		a.JSONEq(`{"ce_id": "42", "ce_source": "/shop", "ce_type": "order.created"}`, msg.HeaderJSON)
+	})
+
+	// in the binary mode
+	req := httptest.NewRequest(http.MethodPost, "/api/message/orders", bytes.NewBufferString(`{"order": 42}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ce-Specversion", "1.0")
+	req.Header.Set("Ce-Id", "42")
+	req.Header.Set("Ce-Source", "/shop")
+	req.Header.Set("Ce-Type", "order.created")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	a.Equal(http.StatusOK, w.Code)
+
+	// in the structured mode
+	req = httptest.NewRequest(http.MethodPost, "/api/message/orders", bytes.NewBufferString(`{"specversion": "1.0",
+		"id": "42", "source": "/shop", "type": "order.created", "datacontenttype": "application/json", "data": {"order": 42}}`))
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	a.Equal(http.StatusOK, w.Code)
+
+	// without the required attributes
+	req = httptest.NewRequest(http.MethodPost, "/api/message/orders", bytes.NewBufferString(`{"specversion": "1.0"}`))
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	a.Equal(http.StatusBadRequest, w.Code)
+}
+
 // Server should return an 405 Method Not Allowed in case method request is not POST
 func TestServeHTTP_GetError(t *testing.T) {
 	a := assert.New(t)
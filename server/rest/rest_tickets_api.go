@@ -0,0 +1,69 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/server/auth"
+)
+
+// TicketIssuer issues the one-time tickets of the websocket connections.
+type TicketIssuer interface {
+	Issue(userID string) (string, time.Time, error)
+}
+
+// TicketsAPI is an endpoint issuing the tickets required for connecting a websocket, to the backends of the applications:
+// `POST <prefix><userID>` returns a ticket for the user, and `POST <prefix>` a ticket for an anonymous websocket,
+// as `{"ticket": "...", "expires": "2017-03-01T10:00:30Z"}`.
+// All the requests require a token with the backend or the admin scope, so the endpoint is refused without an Authenticator.
+type TicketsAPI struct {
+	issuer        TicketIssuer
+	prefix        string
+	authenticator auth.Authenticator
+}
+
+// NewTicketsAPI returns a new TicketsAPI.
+func NewTicketsAPI(issuer TicketIssuer, prefix string, authenticator auth.Authenticator) *TicketsAPI {
+	return &TicketsAPI{issuer, prefix, authenticator}
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (api *TicketsAPI) GetPrefix() string {
+	return api.prefix
+}
+
+// ServeHTTP is an http.Handler.
+// It is a part of the service.endpoint implementation.
+func (api *TicketsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !authorized(w, r, api.authenticator, auth.BackendScope, auth.AdminScope) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed. Only HTTP POST is accepted."}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := strings.Trim(strings.TrimPrefix(r.URL.Path, removeTrailingSlash(api.prefix)), "/")
+	if strings.Contains(userID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	ticket, expires, err := api.issuer.Issue(userID)
+	if err != nil {
+		log.WithError(err).Error("Issuing a websocket ticket failed")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(struct {
+		Ticket  string    `json:"ticket"`
+		Expires time.Time `json:"expires"`
+	}{ticket, expires})
+}
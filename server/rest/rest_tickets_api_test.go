@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// issuerFunc is a TicketIssuer issuing with a function.
+type issuerFunc func(userID string) (string, time.Time, error)
+
+func (f issuerFunc) Issue(userID string) (string, time.Time, error) {
+	return f(userID)
+}
+
+func TestTicketsAPI_ServeHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	expires := time.Date(2017, 3, 1, 10, 0, 30, 0, time.UTC)
+	var issuedFor []string
+	api := NewTicketsAPI(issuerFunc(func(userID string) (string, time.Time, error) {
+		if userID == "broken" {
+			return "", time.Time{}, errors.New("kvstore down")
+		}
+		issuedFor = append(issuedFor, userID)
+		return "ticket-" + userID, expires, nil
+	}), "/api/tickets/", testAuthenticator)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, tokenRequest(http.MethodPost, "/api/tickets/user01", nil, "backend"))
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("application/json", w.Header().Get("Content-Type"))
+	var response map[string]string
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	a.Equal(map[string]string{"ticket": "ticket-user01", "expires": "2017-03-01T10:00:30Z"}, response)
+
+	// an anonymous websocket
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, tokenRequest(http.MethodPost, "/api/tickets/", nil, "backend"))
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal([]string{"user01", ""}, issuedFor)
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, tokenRequest(http.MethodGet, "/api/tickets/user01", nil, "backend"))
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, tokenRequest(http.MethodPost, "/api/tickets/user01/foo", nil, "backend"))
+	a.Equal(http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, tokenRequest(http.MethodPost, "/api/tickets/broken", nil, "backend"))
+	a.Equal(http.StatusInternalServerError, w.Code)
+	a.Equal([]string{"user01", ""}, issuedFor)
+
+	// only the backends and the admins are issued tickets
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, tokenRequest(http.MethodPost, "/api/tickets/user01", nil, ""))
+	a.Equal(http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, tokenRequest(http.MethodPost, "/api/tickets/user01", nil, "marvin"))
+	a.Equal(http.StatusForbidden, w.Code)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, tokenRequest(http.MethodPost, "/api/tickets/user01", nil, "admin"))
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal([]string{"user01", "", "user01"}, issuedFor)
+}
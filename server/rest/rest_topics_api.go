@@ -0,0 +1,139 @@
+package rest
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store"
+
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// TopicsAPI is an admin endpoint describing the topic partitions of the message store:
+// `GET <prefix>` returns all the partitions, `GET <prefix><path>` only the partition of the given topic path.
+// The configurations of the topics are served under `<prefix><path>/config`, if a ConfigHandler is set.
+type TopicsAPI struct {
+	router  router.Router
+	prefix  string
+	configs ConfigHandler
+}
+
+// ConfigHandler manages the configurations of the topics (of all the topics, if the topic is empty).
+type ConfigHandler interface {
+	ServeConfig(w http.ResponseWriter, r *http.Request, topic protocol.Path)
+}
+
+// NewTopicsAPI returns a new TopicsAPI.
+func NewTopicsAPI(router router.Router, prefix string) *TopicsAPI {
+	return &TopicsAPI{router: router, prefix: prefix}
+}
+
+// SetConfigHandler sets the handler of the topic configurations.
+func (api *TopicsAPI) SetConfigHandler(h ConfigHandler) {
+	api.configs = h
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (api *TopicsAPI) GetPrefix() string {
+	return api.prefix
+}
+
+// ServeHTTP is an http.Handler.
+// It is a part of the service.endpoint implementation.
+func (api *TopicsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if topic, ok := extractConfigTopic(api.prefix, r.URL.Path); ok && api.configs != nil {
+		api.configs.ServeConfig(w, r, topic)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET is accepted."}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	partition := extractPartition(api.prefix, r.URL.Path)
+	infos, err := api.partitionInfos(partition)
+	if err != nil {
+		log.WithError(err).Error("Reading the topic partitions failed")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+
+	var response interface{} = infos
+	if partition != "" {
+		if len(infos) == 0 {
+			http.Error(w, `{"error": "Topic not found."}`, http.StatusNotFound)
+			return
+		}
+		response = infos[0]
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.WithError(err).Error("Encoding the topic partitions failed")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+	}
+}
+
+// extractPartition returns the partition of the topic path following the prefix, or "" for all the partitions.
+func extractPartition(prefix string, path string) string {
+	topic := strings.TrimPrefix(path, removeTrailingSlash(prefix))
+	if topic == "" || topic == "/" {
+		return ""
+	}
+	return protocol.Path("/" + strings.TrimPrefix(topic, "/")).Partition()
+}
+
+// extractConfigTopic returns the topic path of a configuration path (`<prefix><path>/config`),
+// which is empty for the configurations of all the topics (`<prefix>config`).
+func extractConfigTopic(prefix string, path string) (protocol.Path, bool) {
+	topic := "/" + strings.Trim(strings.TrimPrefix(path, removeTrailingSlash(prefix)), "/")
+	if topic != "/config" && !strings.HasSuffix(topic, "/config") {
+		return "", false
+	}
+	return protocol.Path(strings.TrimSuffix(topic, "/config")), true
+}
+
+// partitionInfos returns the infos of all the partitions of the message store,
+// or only of the given partition, if not empty.
+func (api *TopicsAPI) partitionInfos(partition string) ([]*store.PartitionInfo, error) {
+	messageStore, err := api.router.MessageStore()
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := messageStore.Partitions()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*store.PartitionInfo, 0, len(partitions))
+	for _, p := range partitions {
+		if partition != "" && p.Name() != partition {
+			continue
+		}
+		info, err := partitionInfo(p)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// partitionInfo returns the info of a partition,
+// restricted to its name, count and last id if the partition is not able to describe its messages.
+func partitionInfo(p store.MessagePartition) (*store.PartitionInfo, error) {
+	if provider, ok := p.(store.PartitionInfoProvider); ok {
+		return provider.Info()
+	}
+	return &store.PartitionInfo{
+		Partition: p.Name(),
+		Count:     p.Count(),
+		LastID:    p.MaxMessageID(),
+	}, nil
+}
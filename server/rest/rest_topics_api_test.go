@@ -0,0 +1,103 @@
+package rest
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/store/filestore"
+	"github.com/smancke/guble/testutil"
+
+	"github.com/stretchr/testify/assert"
+
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestTopicsAPI_ServeHTTP(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_rest_topics_test")
+	defer os.RemoveAll(dir)
+
+	messageStore := filestore.New(dir)
+	defer messageStore.Stop()
+	for id := 1; id <= 3; id++ {
+		m := &protocol.Message{ID: uint64(id), Path: "/foo/bar", Time: int64(1420110000 + id), Body: []byte("body")}
+		a.NoError(messageStore.Store("foo", m.ID, m.Bytes()))
+	}
+	m := &protocol.Message{ID: 1, Path: "/baz", Time: 1420110042, Body: []byte("body")}
+	a.NoError(messageStore.Store("baz", m.ID, m.Bytes()))
+
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().MessageStore().Return(messageStore, nil).AnyTimes()
+	api := NewTopicsAPI(routerMock, "/admin/topics/")
+
+	// all the partitions
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/topics/", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("application/json", w.Header().Get("Content-Type"))
+
+	var infos []*store.PartitionInfo
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &infos))
+	a.Equal(2, len(infos))
+	a.Equal("baz", infos[0].Partition)
+	a.Equal("foo", infos[1].Partition)
+
+	// a single partition, by the path of a topic
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/topics/foo/bar", nil))
+	a.Equal(http.StatusOK, w.Code)
+
+	var info store.PartitionInfo
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &info))
+	a.Equal("foo", info.Partition)
+	a.Equal(uint64(3), info.Count)
+	a.True(info.Size > 0)
+	a.Equal(uint64(1), info.FirstID)
+	a.Equal(int64(1420110001), info.FirstTime)
+	a.Equal(uint64(3), info.LastID)
+	a.Equal(int64(1420110003), info.LastTime)
+
+	// an unknown partition
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/topics/unknown", nil))
+	a.Equal(http.StatusNotFound, w.Code)
+	_, err := os.Stat(dir + "/unknown")
+	a.True(os.IsNotExist(err))
+
+	// only GET is allowed
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/topics/", nil))
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+}
+
+type recordingConfigHandler struct {
+	topics []protocol.Path
+}
+
+func (h *recordingConfigHandler) ServeConfig(w http.ResponseWriter, r *http.Request, topic protocol.Path) {
+	h.topics = append(h.topics, topic)
+}
+
+func TestTopicsAPI_ServesTheConfigurations(t *testing.T) {
+	a := assert.New(t)
+
+	handler := &recordingConfigHandler{}
+	api := NewTopicsAPI(nil, "/admin/topics/")
+	api.SetConfigHandler(handler)
+
+	api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/admin/topics/foo/bar/config", nil))
+	api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/topics/config", nil))
+	a.Equal([]protocol.Path{"/foo/bar", ""}, handler.topics)
+
+	topic, ok := extractConfigTopic("/admin/topics/", "/admin/topics/foo/configs")
+	a.False(ok)
+	a.Equal(protocol.Path(""), topic)
+}
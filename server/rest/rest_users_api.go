@@ -0,0 +1,183 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/router"
+)
+
+// maxDisconnectReasonLength is the maximum length of the reason sent to the disconnected websockets.
+const maxDisconnectReasonLength = 1024
+
+// UserSessions closes the connected websockets of the users, replays messages to them, or cancels their subscriptions.
+type UserSessions interface {
+	DisconnectUser(userID, reason string) int
+	ReplayUser(userID string, messages []*protocol.Message) int
+	UnsubscribeUser(userID string, topic protocol.Path, reason string) int
+}
+
+// TicketRevoker revokes the unused websocket tickets of the users.
+type TicketRevoker interface {
+	RevokeUser(userID string) (int, error)
+}
+
+// UsersAPI is an admin endpoint acting on the sessions of a user, e.g. when its access is revoked
+// or its token is compromised: `POST <prefix><userID>/disconnect?reason=<reason>` closes all the websockets
+// of the user connected to this node (notifying them with the optional reason), and revokes the unused tickets
+// of the user, answering `{"disconnected": 2, "revoked_tickets": 1}`.
+// `POST <prefix><userID>/replay?topic=<topic>&from=<id>&to=<id>` sends the stored messages of the topic
+// with the IDs from..to again to the websockets of the user connected to this node (e.g. for a user who missed them),
+// answering `{"replayed": 3, "websockets": 1}`.
+// `POST <prefix><userID>/unsubscribe?topic=<topic>` cancels the subscriptions of the websockets of the user connected
+// to this node to the topic and its subtopics (all of them, without topic), notifying the clients,
+// answering `{"unsubscribed": 2}`.
+// All the requests require a token with the admin scope, so the endpoint is refused without an Authenticator.
+type UsersAPI struct {
+	router        router.Router
+	sessions      UserSessions
+	revoker       TicketRevoker
+	prefix        string
+	authenticator auth.Authenticator
+	devices       *DevicesAPI
+}
+
+// NewUsersAPI returns a new UsersAPI. The revoker is optional (nil, if the tickets are disabled).
+func NewUsersAPI(router router.Router, sessions UserSessions, revoker TicketRevoker, prefix string, authenticator auth.Authenticator) *UsersAPI {
+	return &UsersAPI{router: router, sessions: sessions, revoker: revoker, prefix: prefix, authenticator: authenticator}
+}
+
+// SetDevices serves `GET <prefix><userID>/devices` with the DevicesAPI, which can not be mounted on the same prefix.
+func (api *UsersAPI) SetDevices(devices *DevicesAPI) {
+	api.devices = devices
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (api *UsersAPI) GetPrefix() string {
+	return api.prefix
+}
+
+// ServeHTTP is an http.Handler.
+// It is a part of the service.endpoint implementation.
+func (api *UsersAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !authorized(w, r, api.authenticator) {
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, removeTrailingSlash(api.prefix)), "/")
+	i := strings.LastIndex(path, "/")
+	if i <= 0 || strings.Contains(path[:i], "/") {
+		http.NotFound(w, r)
+		return
+	}
+	userID, action := path[:i], path[i+1:]
+	if action == "devices" && api.devices != nil {
+		api.devices.serve(w, r)
+		return
+	}
+	if action != "disconnect" && action != "replay" && action != "unsubscribe" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed. Only HTTP POST is accepted."}`, http.StatusMethodNotAllowed)
+		return
+	}
+	switch action {
+	case "replay":
+		api.replay(w, r, userID)
+	case "unsubscribe":
+		api.unsubscribe(w, r, userID)
+	default:
+		api.disconnect(w, r, userID)
+	}
+}
+
+func (api *UsersAPI) disconnect(w http.ResponseWriter, r *http.Request, userID string) {
+	reason := r.URL.Query().Get("reason")
+	if len(reason) > maxDisconnectReasonLength {
+		http.Error(w, `{"error": "The reason is too long."}`, http.StatusBadRequest)
+		return
+	}
+
+	// the tickets are revoked first, so that a disconnected client can not connect again with an unused ticket
+	revoked := 0
+	if api.revoker != nil {
+		var err error
+		if revoked, err = api.revoker.RevokeUser(userID); err != nil {
+			log.WithError(err).WithField("userID", userID).Error("Revoking the websocket tickets of a user failed")
+			http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+			return
+		}
+	}
+	disconnected := api.sessions.DisconnectUser(userID, reason)
+	log.WithFields(log.Fields{
+		"userID":          userID,
+		"disconnected":    disconnected,
+		"revoked_tickets": revoked,
+	}).Info("Closed the sessions of a user")
+
+	json.NewEncoder(w).Encode(struct {
+		Disconnected   int `json:"disconnected"`
+		RevokedTickets int `json:"revoked_tickets"`
+	}{disconnected, revoked})
+}
+
+func (api *UsersAPI) replay(w http.ResponseWriter, r *http.Request, userID string) {
+	query := r.URL.Query()
+	topic := protocol.Path("/" + strings.Trim(query.Get("topic"), "/"))
+	from, errFrom := strconv.ParseUint(query.Get("from"), 10, 64)
+	to, errTo := strconv.ParseUint(query.Get("to"), 10, 64)
+	if topic == "/" || errFrom != nil || errTo != nil {
+		http.Error(w, `{"error": "The topic and the range of IDs (from, to) are required."}`, http.StatusBadRequest)
+		return
+	}
+
+	messages, err := router.FetchReplay(api.router, topic, from, to)
+	if err == router.ErrInvalidReplayRange {
+		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.WithError(err).WithField("topic", topic).Error("Fetching the replayed messages failed")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	websockets := api.sessions.ReplayUser(userID, messages)
+	log.WithFields(log.Fields{
+		"userID":     userID,
+		"topic":      topic,
+		"replayed":   len(messages),
+		"websockets": websockets,
+	}).Info("Replayed messages to a user")
+
+	json.NewEncoder(w).Encode(struct {
+		Replayed   int `json:"replayed"`
+		Websockets int `json:"websockets"`
+	}{len(messages), websockets})
+}
+
+func (api *UsersAPI) unsubscribe(w http.ResponseWriter, r *http.Request, userID string) {
+	var topic protocol.Path
+	if t := strings.Trim(r.URL.Query().Get("topic"), "/"); t != "" {
+		topic = protocol.Path("/" + t)
+	}
+	unsubscribed := api.sessions.UnsubscribeUser(userID, topic, protocol.UnsubscribedAdmin)
+	log.WithFields(log.Fields{
+		"userID":       userID,
+		"topic":        topic,
+		"unsubscribed": unsubscribed,
+	}).Info("Canceled the subscriptions of a user")
+
+	json.NewEncoder(w).Encode(struct {
+		Unsubscribed int `json:"unsubscribed"`
+	}{unsubscribed})
+}
@@ -0,0 +1,218 @@
+package rest
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/connector"
+	"github.com/smancke/guble/server/store/filestore"
+	"github.com/smancke/guble/testutil"
+)
+
+// closerFunc is a UserSessions closing with a function, and replaying to a single websocket.
+type closerFunc func(userID, reason string) int
+
+func (f closerFunc) DisconnectUser(userID, reason string) int {
+	return f(userID, reason)
+}
+
+func (f closerFunc) ReplayUser(userID string, messages []*protocol.Message) int {
+	return 1
+}
+
+func (f closerFunc) UnsubscribeUser(userID string, topic protocol.Path, reason string) int {
+	return 0
+}
+
+// replayerFunc is a UserSessions replaying with a function.
+type replayerFunc func(userID string, messages []*protocol.Message) int
+
+func (f replayerFunc) DisconnectUser(userID, reason string) int {
+	return 0
+}
+
+func (f replayerFunc) ReplayUser(userID string, messages []*protocol.Message) int {
+	return f(userID, messages)
+}
+
+func (f replayerFunc) UnsubscribeUser(userID string, topic protocol.Path, reason string) int {
+	return 0
+}
+
+// unsubscriberFunc is a UserSessions canceling the subscriptions with a function.
+type unsubscriberFunc func(userID string, topic protocol.Path, reason string) int
+
+func (f unsubscriberFunc) DisconnectUser(userID, reason string) int {
+	return 0
+}
+
+func (f unsubscriberFunc) ReplayUser(userID string, messages []*protocol.Message) int {
+	return 0
+}
+
+func (f unsubscriberFunc) UnsubscribeUser(userID string, topic protocol.Path, reason string) int {
+	return f(userID, topic, reason)
+}
+
+// revokerFunc is a TicketRevoker revoking with a function.
+type revokerFunc func(userID string) (int, error)
+
+func (f revokerFunc) RevokeUser(userID string) (int, error) {
+	return f(userID)
+}
+
+func TestUsersAPI_ServeHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	var disconnected, revoked []string
+	closer := closerFunc(func(userID, reason string) int {
+		disconnected = append(disconnected, userID+": "+reason)
+		return 2
+	})
+	revoker := revokerFunc(func(userID string) (int, error) {
+		if userID == "broken" {
+			return 0, errors.New("kvstore down")
+		}
+		revoked = append(revoked, userID)
+		return 1, nil
+	})
+	api := NewUsersAPI(nil, closer, revoker, "/admin/user/", testAuthenticator)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/user/user01/disconnect?reason=revoked", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"disconnected": 2, "revoked_tickets": 1}`, w.Body.String())
+	a.Equal([]string{"user01: revoked"}, disconnected)
+	a.Equal([]string{"user01"}, revoked)
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/user/user01/disconnect", nil))
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	for _, path := range []string{"/admin/user/user01", "/admin/user/disconnect", "/admin/user/a/b/disconnect"} {
+		w = httptest.NewRecorder()
+		api.ServeHTTP(w, adminRequest(http.MethodPost, path, nil))
+		a.Equal(http.StatusNotFound, w.Code, path)
+	}
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/user/user01/disconnect?reason="+strings.Repeat("x", 2000), nil))
+	a.Equal(http.StatusBadRequest, w.Code)
+
+	// the websockets are not closed if the tickets could not be revoked
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/user/broken/disconnect", nil))
+	a.Equal(http.StatusInternalServerError, w.Code)
+	a.Len(disconnected, 1)
+
+	// only the admins can close the sessions
+	for _, token := range []string{"", "marvin"} {
+		w = httptest.NewRecorder()
+		api.ServeHTTP(w, tokenRequest(http.MethodPost, "/admin/user/user01/disconnect", nil, token))
+		a.NotEqual(http.StatusOK, w.Code, token)
+	}
+	a.Len(disconnected, 1)
+	a.Equal([]string{"user01"}, revoked)
+
+	// without tickets
+	api = NewUsersAPI(nil, closer, nil, "/admin/user/", testAuthenticator)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/user/user02/disconnect", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"disconnected": 2, "revoked_tickets": 0}`, w.Body.String())
+}
+
+func TestUsersAPI_Devices(t *testing.T) {
+	a := assert.New(t)
+
+	api := NewUsersAPI(nil, closerFunc(nil), nil, "/admin/user/", testAuthenticator)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/user/marvin/devices", nil))
+	a.Equal(http.StatusNotFound, w.Code)
+
+	// the devices API is served on the same prefix
+	api.SetDevices(NewDevicesAPI("/admin/user/", testAuthenticator, deviceListerFunc(func(userID string) []connector.Device {
+		return []connector.Device{{Connector: "fcm", ID: "token-" + userID}}
+	})))
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/user/marvin/devices", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`[{"connector": "fcm", "id": "token-marvin", "subscriptions": null}]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, tokenRequest(http.MethodGet, "/admin/user/marvin/devices", nil, "marvin"))
+	a.Equal(http.StatusForbidden, w.Code)
+}
+
+func TestUsersAPI_Replay(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_rest_users_test")
+	defer os.RemoveAll(dir)
+	messageStore := filestore.New(dir)
+	defer messageStore.Stop()
+	for id := uint64(1); id <= 5; id++ {
+		m := &protocol.Message{ID: id, Path: "/news", Body: []byte("Hello")}
+		a.NoError(messageStore.Store("news", id, m.Bytes()))
+	}
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().MessageStore().Return(messageStore, nil).AnyTimes()
+
+	var replayed []uint64
+	sessions := replayerFunc(func(userID string, messages []*protocol.Message) int {
+		a.Equal("user01", userID)
+		for _, m := range messages {
+			replayed = append(replayed, m.ID)
+		}
+		return 2
+	})
+	api := NewUsersAPI(routerMock, sessions, nil, "/admin/user/", testAuthenticator)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/user/user01/replay?topic=news&from=2&to=4", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"replayed": 3, "websockets": 2}`, w.Body.String())
+	a.Equal([]uint64{2, 3, 4}, replayed)
+
+	for _, query := range []string{"?from=2&to=4", "?topic=news&to=4", "?topic=news&from=4&to=2"} {
+		w = httptest.NewRecorder()
+		api.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/user/user01/replay"+query, nil))
+		a.Equal(http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestUsersAPI_Unsubscribe(t *testing.T) {
+	a := assert.New(t)
+
+	var unsubscribed []string
+	sessions := unsubscriberFunc(func(userID string, topic protocol.Path, reason string) int {
+		unsubscribed = append(unsubscribed, userID+" "+string(topic)+" "+reason)
+		return 2
+	})
+	api := NewUsersAPI(nil, sessions, nil, "/admin/user/", testAuthenticator)
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/user/user01/unsubscribe?topic=news/", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"unsubscribed": 2}`, w.Body.String())
+
+	// all the subscriptions, without topic
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/user/user01/unsubscribe", nil))
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal([]string{"user01 /news admin", "user01  admin"}, unsubscribed)
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/user/user01/unsubscribe", nil))
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,222 @@
+package router
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// broadcastSlots is the number of batches in which the delivery of a broadcast message is spread.
+var broadcastSlots = 100
+
+// BroadcastConfigurable is implemented by the routers which can spread the delivery of the broadcast messages.
+type BroadcastConfigurable interface {
+	// SetBroadcast sets the topic (matching its subtopics as well) whose messages are delivered to the local
+	// subscribers over the given duration (0: at once), so that millions of clients do not reconnect or publish
+	// at the same time in reaction to a broadcast message. It is applied when the router is started.
+	SetBroadcast(topic string, spread time.Duration) error
+}
+
+// SetBroadcast is a part of the BroadcastConfigurable implementation.
+func (router *router) SetBroadcast(topic string, spread time.Duration) error {
+	if !strings.HasPrefix(topic, "/") || len(topic) < 2 {
+		return fmt.Errorf("Invalid broadcast topic %q", topic)
+	}
+	if spread < 0 {
+		return fmt.Errorf("Invalid broadcast spread %v", spread)
+	}
+
+	router.Lock()
+	defer router.Unlock()
+	router.broadcastTopic = protocol.Path(strings.TrimSuffix(topic, "/"))
+	router.broadcastSpread = spread
+	return nil
+}
+
+// startSpreader starts spreading the delivery of the broadcast messages, if configured.
+func (router *router) startSpreader() {
+	router.Lock()
+	defer router.Unlock()
+
+	router.spreader = nil
+	if router.broadcastSpread > 0 {
+		router.spreader = newSpreader(router.broadcastSpread)
+		logger.WithField("topic", router.broadcastTopic).WithField("spread", router.broadcastSpread).Info("Spreading the delivery of the broadcast messages")
+	}
+}
+
+// isSpread returns true if the delivery of a message is spread over time, because of its topic.
+func (router *router) isSpread(message *protocol.Message) bool {
+	return router.spreader != nil && matchesTopic(message.Path, router.broadcastTopic)
+}
+
+// dispatchSpread delivers a batch of a broadcast message to its routes which were not removed meanwhile.
+// It is called by the routing goroutine, as the other messages.
+func (router *router) dispatchSpread(task deliveryTask) {
+	routes := make([]*Route, 0, len(task.routes))
+	for _, route := range task.routes {
+		if !route.isInvalid() {
+			routes = append(routes, route)
+		}
+	}
+	router.dispatch(task.message, routes)
+}
+
+// spreader delays the delivery of the broadcast messages: the routes are split in slots, a route always being
+// in the same slot, and the routes of the n-th slot receive the messages after n/broadcastSlots of the spread.
+// As the delay of a route is the same for all the messages, a route receives the messages in order.
+type spreader struct {
+	spread time.Duration
+
+	mu      sync.Mutex
+	pending spreadQueue
+	seq     uint64
+
+	wakeC  chan struct{}
+	tasksC chan deliveryTask
+	stopC  chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newSpreader(spread time.Duration) *spreader {
+	s := &spreader{
+		spread: spread,
+		wakeC:  make(chan struct{}, 1),
+		tasksC: make(chan deliveryTask),
+		stopC:  make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// schedule splits the routes of a message in the slots of the spread.
+func (s *spreader) schedule(message *protocol.Message, routes []*Route) {
+	slots := make(map[int][]*Route)
+	for _, route := range routes {
+		slot := routeSlot(route)
+		slots[slot] = append(slots[slot], route)
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	for slot, slotRoutes := range slots {
+		s.seq++
+		heap.Push(&s.pending, spreadTask{
+			at:   now.Add(s.spread * time.Duration(slot) / time.Duration(broadcastSlots)),
+			seq:  s.seq,
+			task: deliveryTask{message: message, routes: slotRoutes},
+		})
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wakeC <- struct{}{}:
+	default:
+	}
+}
+
+// tasks returns the channel of the batches due for delivery (nil for a nil spreader).
+func (s *spreader) tasks() <-chan deliveryTask {
+	if s == nil {
+		return nil
+	}
+	return s.tasksC
+}
+
+func (s *spreader) run() {
+	defer s.wg.Done()
+	for {
+		var due []deliveryTask
+		wait := time.Duration(-1)
+
+		s.mu.Lock()
+		now := time.Now()
+		for len(s.pending) > 0 && !s.pending[0].at.After(now) {
+			due = append(due, heap.Pop(&s.pending).(spreadTask).task)
+		}
+		if len(s.pending) > 0 {
+			wait = s.pending[0].at.Sub(now)
+		}
+		s.mu.Unlock()
+
+		for _, task := range due {
+			select {
+			case s.tasksC <- task:
+			case <-s.stopC:
+				return
+			}
+		}
+
+		var timer *time.Timer
+		var timerC <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			timerC = timer.C
+		}
+		select {
+		case <-s.wakeC:
+		case <-timerC:
+		case <-s.stopC:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-s.stopC:
+			return
+		default:
+		}
+	}
+}
+
+// stop stops the spreader, the pending deliveries being dropped with the routes.
+func (s *spreader) stop() {
+	if s == nil {
+		return
+	}
+	close(s.stopC)
+	s.wg.Wait()
+}
+
+// routeSlot returns the slot of a route, given by the hash of its key.
+func routeSlot(route *Route) int {
+	h := fnv.New32a()
+	h.Write([]byte(route.Key()))
+	return int(h.Sum32() % uint32(broadcastSlots))
+}
+
+type spreadTask struct {
+	at   time.Time
+	seq  uint64
+	task deliveryTask
+}
+
+// spreadQueue is a heap of the pending batches, ordered by their time and by the order of their scheduling.
+type spreadQueue []spreadTask
+
+func (q spreadQueue) Len() int { return len(q) }
+
+func (q spreadQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+
+func (q spreadQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *spreadQueue) Push(x interface{}) { *q = append(*q, x.(spreadTask)) }
+
+func (q *spreadQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	t := old[n-1]
+	*q = old[:n-1]
+	return t
+}
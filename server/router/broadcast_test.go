@@ -0,0 +1,103 @@
+package router
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/store/dummystore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_SetBroadcast(t *testing.T) {
+	a := assert.New(t)
+
+	router := New(auth.NewAllowAllAccessManager(true), nil, nil, nil).(*router)
+	a.Error(router.SetBroadcast("broadcast", time.Second))
+	a.Error(router.SetBroadcast("/", time.Second))
+	a.Error(router.SetBroadcast("/broadcast", -time.Second))
+	a.NoError(router.SetBroadcast("/broadcast/", time.Second))
+	a.Equal(protocol.Path("/broadcast"), router.broadcastTopic)
+}
+
+func TestSpreader_DelaysTheSlots(t *testing.T) {
+	a := assert.New(t)
+
+	slots := broadcastSlots
+	broadcastSlots = 2
+	defer func() { broadcastSlots = slots }()
+
+	// Given a route in each slot
+	var first, second *Route
+	for i := 0; first == nil || second == nil; i++ {
+		r := NewRoute(RouteConfig{Path: "/broadcast", RouteParams: RouteParams{"device": fmt.Sprintf("device%d", i)}})
+		if routeSlot(r) == 0 {
+			first = r
+		} else {
+			second = r
+		}
+	}
+
+	s := newSpreader(200 * time.Millisecond)
+	defer s.stop()
+
+	// when a message is scheduled, the routes of the first slot receive it at once
+	start := time.Now()
+	s.schedule(&protocol.Message{ID: 1, Path: "/broadcast"}, []*Route{second, first})
+	select {
+	case task := <-s.tasks():
+		a.Equal([]*Route{first}, task.routes)
+		a.True(time.Since(start) < 50*time.Millisecond)
+	case <-time.After(time.Second):
+		a.Fail("The first slot was not delivered")
+	}
+
+	// and the routes of the second slot after half of the spread
+	select {
+	case task := <-s.tasks():
+		a.Equal([]*Route{second}, task.routes)
+		a.True(time.Since(start) >= 100*time.Millisecond)
+	case <-time.After(time.Second):
+		a.Fail("The second slot was not delivered")
+	}
+}
+
+func TestRouter_SpreadsTheBroadcastMessages(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	router := New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil).(*router)
+	a.NoError(router.SetBroadcast("/broadcast", 100*time.Millisecond))
+	a.NoError(router.Start())
+	defer router.Stop()
+
+	var routes []*Route
+	for i := 0; i < 10; i++ {
+		r, err := router.Subscribe(NewRoute(RouteConfig{
+			Path:        "/broadcast",
+			RouteParams: RouteParams{"device": fmt.Sprintf("device%d", i)},
+			ChannelSize: 10,
+		}))
+		a.NoError(err)
+		routes = append(routes, r)
+	}
+
+	for i := 0; i < 3; i++ {
+		a.NoError(router.HandleMessage(&protocol.Message{Path: "/broadcast/news", Body: []byte(fmt.Sprintf("%d", i))}))
+	}
+
+	// all the routes receive the messages in order, within the spread
+	for _, r := range routes {
+		for i := 0; i < 3; i++ {
+			select {
+			case m := <-r.MessagesChannel():
+				a.Equal(fmt.Sprintf("%d", i), string(m.Body))
+			case <-time.After(time.Second):
+				a.Fail("The message was not delivered", r.String())
+			}
+		}
+	}
+}
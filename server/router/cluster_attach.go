@@ -0,0 +1,30 @@
+package router
+
+import (
+	"errors"
+
+	"github.com/smancke/guble/server/cluster"
+)
+
+// ErrClusterAttached is returned when attaching a cluster to a router which already has one.
+var ErrClusterAttached = errors.New("The router already has a cluster")
+
+// ClusterAttachable is implemented by the routers to which a cluster can be attached at runtime,
+// for migrating a standalone node to the cluster-mode without restarting it.
+type ClusterAttachable interface {
+	// AttachCluster sets the cluster of a standalone router: the messages published afterwards are given
+	// cluster-aware IDs and broadcast to the other nodes. A nil cluster detaches the attached one.
+	AttachCluster(c *cluster.Cluster) error
+}
+
+// AttachCluster is a part of the ClusterAttachable implementation.
+func (router *router) AttachCluster(c *cluster.Cluster) error {
+	router.clusterMu.Lock()
+	defer router.clusterMu.Unlock()
+
+	if c != nil && router.cluster != nil {
+		return ErrClusterAttached
+	}
+	router.cluster = c
+	return nil
+}
@@ -0,0 +1,73 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// CompressionConfigurable is implemented by the routers which can compress the large message bodies.
+type CompressionConfigurable interface {
+	// SetCompression sets the content encoding (`gzip` or `zstd`, none if empty) with which the bodies
+	// of at least threshold bytes are compressed, when stored and when sent to the websockets accepting the encoding.
+	// The messages are delivered to the routes with their uncompressed bodies.
+	SetCompression(encoding string, threshold int) error
+}
+
+// SetCompression is a part of the CompressionConfigurable implementation.
+func (router *router) SetCompression(encoding string, threshold int) error {
+	if encoding != "" && !protocol.IsEncoding(encoding) {
+		return fmt.Errorf("Invalid compression %q", encoding)
+	}
+	if threshold < 0 {
+		return fmt.Errorf("Invalid compression threshold %d", threshold)
+	}
+
+	router.Lock()
+	defer router.Unlock()
+	router.compression = encoding
+	router.compressionThreshold = threshold
+	return nil
+}
+
+// compressible returns the content encoding with which the body of the message is compressed, if it is.
+func (router *router) compressible(message *protocol.Message) (string, bool) {
+	router.RLock()
+	defer router.RUnlock()
+	return router.compression, router.compression != "" && len(message.Body) >= router.compressionThreshold
+}
+
+// store stores the message, with its body compressed if compressible, and returns the stored size.
+// A compressed message is identified as by the message store, before its serialization is compressed.
+func (router *router) store(message *protocol.Message, nodeID uint8) (int, error) {
+	encoding, ok := router.compressible(message)
+	if !ok {
+		return router.messageStore.StoreMessage(message, nodeID)
+	}
+	if err := router.identify(message, nodeID); err != nil {
+		return 0, err
+	}
+	data, err := message.Compress(encoding)
+	if err != nil {
+		return 0, err
+	}
+	if err := router.messageStore.Store(message.Path.Partition(), message.ID, data); err != nil {
+		return 0, err
+	}
+	mTotalMessagesCompressed.Add(1)
+	return len(data), nil
+}
+
+// compress compresses the body of an identified message which is not stored, for sending it to the websockets,
+// if compressible.
+func (router *router) compress(message *protocol.Message) error {
+	encoding, ok := router.compressible(message)
+	if !ok {
+		return nil
+	}
+	if _, err := message.Compress(encoding); err != nil {
+		return err
+	}
+	mTotalMessagesCompressed.Add(1)
+	return nil
+}
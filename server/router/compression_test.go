@@ -0,0 +1,63 @@
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestRouter_CompressesLargeBodies(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	router, r := aRouterRoute(chanSize)
+	msMock := NewMockMessageStore(ctrl)
+	router.messageStore = msMock
+
+	a.Error(router.SetCompression("br", 0))
+	a.Error(router.SetCompression(protocol.EncodingGzip, -1))
+	a.NoError(router.SetCompression(protocol.EncodingGzip, 100))
+
+	// the large bodies are stored compressed, but delivered uncompressed to the routes
+	body := []byte(strings.Repeat("Hello World ", 20))
+	ts := time.Now().Unix()
+	msMock.EXPECT().GenerateNextMsgID("blah", uint8(0)).Return(uint64(2), ts, nil)
+	msMock.EXPECT().Store("blah", uint64(2), gomock.Any()).Do(func(partition string, id uint64, data []byte) error {
+		a.Equal(protocol.EncodingGzip, protocol.RawEncoding(data))
+		a.True(len(data) < len(body))
+		stored, err := protocol.ParseMessage(data)
+		a.NoError(err)
+		a.Equal(body, stored.Body)
+		return nil
+	})
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: body}))
+
+	select {
+	case m := <-r.MessagesChannel():
+		a.Equal(uint64(2), m.ID)
+		a.Equal(body, m.Body)
+		_, encoding := m.CompressedBytes()
+		a.Equal(protocol.EncodingGzip, encoding)
+	case <-time.After(100 * time.Millisecond):
+		a.Fail("No message received")
+	}
+
+	// the small bodies are stored as they are
+	msMock.EXPECT().StoreMessage(gomock.Any(), uint8(0)).Return(10, nil)
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: aTestByteMessage}))
+
+	select {
+	case m := <-r.MessagesChannel():
+		_, encoding := m.CompressedBytes()
+		a.Equal("", encoding)
+	case <-time.After(100 * time.Millisecond):
+		a.Fail("No message received")
+	}
+}
@@ -0,0 +1,203 @@
+package router
+
+import (
+	"sync"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// RouteKind selects the goroutines delivering the messages to a route.
+type RouteKind int
+
+const (
+	// WebsocketRoute is the kind of the routes of the websocket clients, and of the routes without kind.
+	WebsocketRoute RouteKind = iota
+
+	// ConnectorRoute is the kind of the routes of the connectors, which pass the messages to their queues.
+	ConnectorRoute
+)
+
+func (k RouteKind) String() string {
+	switch k {
+	case ConnectorRoute:
+		return "connector"
+	default:
+		return "websocket"
+	}
+}
+
+// deliveryBatchSize is the maximum number of routes to which a worker delivers a message before serving
+// the other partitions: the delivery of a message to more routes is split in several tasks.
+var deliveryBatchSize = 1000
+
+// DeliveryConfigurable is implemented by the routers whose delivery concurrency can be configured.
+type DeliveryConfigurable interface {
+	// SetDeliveryWorkers sets the number of goroutines delivering the messages to the routes of a kind
+	// (0: the messages are delivered by the routing goroutine). It is applied when the router is started.
+	SetDeliveryWorkers(kind RouteKind, workers int)
+}
+
+// SetDeliveryWorkers is a part of the DeliveryConfigurable implementation.
+func (router *router) SetDeliveryWorkers(kind RouteKind, workers int) {
+	router.Lock()
+	defer router.Unlock()
+	router.deliveryWorkers[kind] = workers
+}
+
+// startDeliveries starts the delivery pools of the route kinds with workers.
+func (router *router) startDeliveries() {
+	router.Lock()
+	defer router.Unlock()
+
+	router.deliveries = make(map[RouteKind]*deliveryPool)
+	for kind, workers := range router.deliveryWorkers {
+		if workers > 0 {
+			router.deliveries[kind] = newDeliveryPool(workers, router.deliver)
+			logger.WithField("kind", kind).WithField("workers", workers).Info("Started delivery workers")
+		}
+	}
+}
+
+// stopDeliveries waits for the pending deliveries, and stops the delivery pools.
+func (router *router) stopDeliveries() {
+	for _, pool := range router.deliveries {
+		pool.stop()
+	}
+}
+
+// dispatch delivers a message to its matching routes: directly, or by the delivery pool of their kind.
+func (router *router) dispatch(message *protocol.Message, routes []*Route) {
+	var pooled map[RouteKind][]*Route
+	for _, route := range routes {
+		if _, ok := router.deliveries[route.Kind]; !ok {
+			router.deliver(message, route)
+			continue
+		}
+		if pooled == nil {
+			pooled = make(map[RouteKind][]*Route)
+		}
+		pooled[route.Kind] = append(pooled[route.Kind], route)
+	}
+
+	partition := message.Path.Partition()
+	for kind, kindRoutes := range pooled {
+		pool := router.deliveries[kind]
+		for len(kindRoutes) > 0 {
+			n := len(kindRoutes)
+			if n > deliveryBatchSize {
+				n = deliveryBatchSize
+			}
+			pool.push(partition, deliveryTask{message: message, routes: kindRoutes[:n]})
+			kindRoutes = kindRoutes[n:]
+		}
+	}
+}
+
+// deliver delivers a message to a route, removing the route if it is invalid.
+// It is called by the routing goroutine, or by a delivery worker.
+func (router *router) deliver(message *protocol.Message, route *Route) {
+	if err := route.Deliver(message, false); err != ErrInvalidRoute {
+		return
+	}
+	if router.deliveries[route.Kind] == nil {
+		router.unsubscribe(route)
+		return
+	}
+	// the routes are only changed by the routing goroutine, which is not waited for
+	select {
+	case router.unsubscribeC <- subRequest{route: route, doneC: make(chan bool, 1)}:
+	default:
+		logger.WithField("route", route.String()).Warn("Invalid route not unsubscribed, retried with its next message")
+	}
+}
+
+type deliveryTask struct {
+	message *protocol.Message
+	routes  []*Route
+}
+
+// deliveryPool delivers the messages to the routes of a kind with several goroutines.
+// The tasks of a partition are done in order, by a single worker at a time, so that the messages are delivered
+// in the order of their IDs. An idle worker takes the next partition with pending tasks, and a worker puts its
+// partition back at the end of the line after each task, so that the messages of a partition with many routes
+// do not hold back the ones of the other partitions.
+type deliveryPool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending map[string][]deliveryTask
+	busy    map[string]bool
+	ready   []string
+	stopped bool
+
+	deliver func(*protocol.Message, *Route)
+	wg      sync.WaitGroup
+}
+
+func newDeliveryPool(workers int, deliver func(*protocol.Message, *Route)) *deliveryPool {
+	p := &deliveryPool{
+		pending: make(map[string][]deliveryTask),
+		busy:    make(map[string]bool),
+		deliver: deliver,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *deliveryPool) push(partition string, task deliveryTask) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending[partition] = append(p.pending[partition], task)
+	if len(p.pending[partition]) == 1 && !p.busy[partition] {
+		p.ready = append(p.ready, partition)
+		p.cond.Signal()
+	}
+}
+
+func (p *deliveryPool) work() {
+	defer p.wg.Done()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		for len(p.ready) == 0 && !p.stopped {
+			p.cond.Wait()
+		}
+		if len(p.ready) == 0 {
+			return
+		}
+
+		partition := p.ready[0]
+		p.ready = p.ready[1:]
+		task := p.pending[partition][0]
+		p.pending[partition] = p.pending[partition][1:]
+		p.busy[partition] = true
+
+		p.mu.Unlock()
+		for _, route := range task.routes {
+			p.deliver(task.message, route)
+		}
+		p.mu.Lock()
+
+		delete(p.busy, partition)
+		if len(p.pending[partition]) > 0 {
+			p.ready = append(p.ready, partition)
+			p.cond.Signal()
+		} else {
+			delete(p.pending, partition)
+		}
+	}
+}
+
+// stop waits for the pending tasks to be done, and stops the workers.
+func (p *deliveryPool) stop() {
+	p.mu.Lock()
+	p.stopped = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,92 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/store/dummystore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliveryPool_PartitionsTakeTurns(t *testing.T) {
+	a := assert.New(t)
+
+	var (
+		mu        sync.Mutex
+		delivered []string
+	)
+	startedC := make(chan struct{})
+	releaseC := make(chan struct{})
+	pool := newDeliveryPool(1, func(m *protocol.Message, r *Route) {
+		if m.Path == "/hot" && m.ID == 1 {
+			close(startedC)
+			<-releaseC
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, fmt.Sprintf("%s%d", m.Path.Partition(), m.ID))
+	})
+
+	route := NewRoute(RouteConfig{Path: "/hot"})
+	pool.push("hot", deliveryTask{message: &protocol.Message{ID: 1, Path: "/hot"}, routes: []*Route{route}})
+	<-startedC
+
+	// while the worker delivers the first message of the hot partition, the messages of both partitions are queued
+	pool.push("hot", deliveryTask{message: &protocol.Message{ID: 2, Path: "/hot"}, routes: []*Route{route}})
+	pool.push("hot", deliveryTask{message: &protocol.Message{ID: 3, Path: "/hot"}, routes: []*Route{route}})
+	pool.push("cold", deliveryTask{message: &protocol.Message{ID: 1, Path: "/cold"}, routes: []*Route{route}})
+	close(releaseC)
+	pool.stop()
+
+	a.Equal([]string{"hot1", "cold1", "hot2", "hot3"}, delivered)
+}
+
+func TestRouter_DeliveryWorkers(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	router := New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil).(*router)
+	router.SetDeliveryWorkers(ConnectorRoute, 4)
+	a.NoError(router.Start())
+	defer router.Stop()
+
+	batchSize := deliveryBatchSize
+	deliveryBatchSize = 2
+	defer func() { deliveryBatchSize = batchSize }()
+
+	var routes []*Route
+	for i := 0; i < 5; i++ {
+		r, err := router.Subscribe(NewRoute(RouteConfig{
+			Path:        "/topic",
+			RouteParams: RouteParams{"device": fmt.Sprintf("device%d", i)},
+			ChannelSize: 10,
+			Kind:        ConnectorRoute,
+		}))
+		a.NoError(err)
+		routes = append(routes, r)
+	}
+	websocket, err := router.Subscribe(NewRoute(RouteConfig{Path: "/topic", ChannelSize: 10}))
+	a.NoError(err)
+	routes = append(routes, websocket)
+
+	for i := 0; i < 5; i++ {
+		a.NoError(router.HandleMessage(&protocol.Message{Path: "/topic", Body: []byte(fmt.Sprintf("%d", i))}))
+	}
+
+	// all the routes receive the messages in order, delivered by the workers or by the routing goroutine
+	for _, r := range routes {
+		for i := 0; i < 5; i++ {
+			select {
+			case m := <-r.MessagesChannel():
+				a.Equal(fmt.Sprintf("%d", i), string(m.Body))
+			case <-time.After(time.Second):
+				a.Fail("The message was not delivered", r.String())
+			}
+		}
+	}
+}
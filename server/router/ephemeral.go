@@ -0,0 +1,69 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// EphemeralHeader is the header flag of the messages which are delivered to the subscribers, but not stored,
+// whatever their topic: `{"Ephemeral": true}`, i.e. `X-Guble-Ephemeral: true` when publishing through the REST API.
+const EphemeralHeader = "Ephemeral"
+
+// EphemeralConfigurable is implemented by the routers which can deliver messages without storing them.
+type EphemeralConfigurable interface {
+	// SetEphemeralTopics sets the topics (matching their subtopics as well, where a level can be a wildcard)
+	// whose messages are delivered to the current subscribers, but not stored.
+	SetEphemeralTopics(topics []string) error
+}
+
+// SetEphemeralTopics is a part of the EphemeralConfigurable implementation.
+func (router *router) SetEphemeralTopics(topics []string) error {
+	paths := make([]protocol.Path, 0, len(topics))
+	for _, topic := range topics {
+		if !strings.HasPrefix(topic, "/") || len(topic) < 2 {
+			return fmt.Errorf("Invalid ephemeral topic %q", topic)
+		}
+		paths = append(paths, protocol.Path(strings.TrimSuffix(topic, "/")))
+	}
+
+	router.Lock()
+	defer router.Unlock()
+	router.ephemeralTopics = paths
+	return nil
+}
+
+// isEphemeral returns true if the message is not stored, because of its topic (or its settings) or its header flag.
+func (router *router) isEphemeral(message *protocol.Message) bool {
+	router.RLock()
+	topics := router.ephemeralTopics
+	router.RUnlock()
+
+	for _, topic := range topics {
+		if matchesTopic(message.Path, topic) {
+			return true
+		}
+	}
+	if s := router.topicSettings(); s != nil && s.Ephemeral(message.Path) {
+		return true
+	}
+	return message.HeaderFlag(EphemeralHeader)
+}
+
+// identify gives an ephemeral message its ID and time, as the message store does when storing a message,
+// so that it is delivered in order with the stored messages of its partition.
+// The messages received from other guble nodes were already identified by their node.
+func (router *router) identify(message *protocol.Message, nodeID uint8) error {
+	if nodeID != 0 && message.NodeID != 0 {
+		return nil
+	}
+	id, ts, err := router.messageStore.GenerateNextMsgID(message.Path.Partition(), nodeID)
+	if err != nil {
+		return err
+	}
+	message.ID = id
+	message.Time = ts
+	message.NodeID = nodeID
+	return nil
+}
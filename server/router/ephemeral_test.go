@@ -0,0 +1,57 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestRouter_EphemeralMessagesAreNotStored(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	router, r := aRouterRoute(chanSize)
+	msMock := NewMockMessageStore(ctrl)
+	router.messageStore = msMock
+
+	a.Error(router.SetEphemeralTopics([]string{"typing"}))
+	a.NoError(router.SetEphemeralTopics([]string{"/blah/"}))
+
+	// the messages of the ephemeral topics are given an ID, but not stored
+	ts := time.Now().Unix()
+	msMock.EXPECT().GenerateNextMsgID("blah", uint8(0)).Return(uint64(2), ts, nil)
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: aTestByteMessage}))
+
+	select {
+	case m := <-r.MessagesChannel():
+		a.Equal(uint64(2), m.ID)
+		a.Equal(ts, m.Time)
+	case <-time.After(100 * time.Millisecond):
+		a.Fail("No message received")
+	}
+
+	// and neither the messages with the header flag
+	a.NoError(router.SetEphemeralTopics(nil))
+	msMock.EXPECT().GenerateNextMsgID("blah", uint8(0)).Return(uint64(3), ts, nil)
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, HeaderJSON: `{"Ephemeral": true}`, Body: aTestByteMessage}))
+	assertChannelContainsMessage(a, r.MessagesChannel(), aTestByteMessage)
+}
+
+func TestRouter_isEphemeral(t *testing.T) {
+	a := assert.New(t)
+
+	router := New(nil, nil, nil, nil).(*router)
+	a.NoError(router.SetEphemeralTopics([]string{"/typing", "/users/*/presence"}))
+
+	a.True(router.isEphemeral(&protocol.Message{Path: "/typing"}))
+	a.True(router.isEphemeral(&protocol.Message{Path: "/typing/room1"}))
+	a.True(router.isEphemeral(&protocol.Message{Path: "/users/bob/presence"}))
+	a.False(router.isEphemeral(&protocol.Message{Path: "/typingx"}))
+	a.False(router.isEphemeral(&protocol.Message{Path: "/users/bob/orders"}))
+	a.True(router.isEphemeral(&protocol.Message{Path: "/users/bob/orders", HeaderJSON: `{"ephemeral": "true"}`}))
+}
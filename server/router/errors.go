@@ -22,6 +22,9 @@ var (
 
 	// ErrQueueFull is returned when trying to `Deliver` a message in a full queued route
 	ErrQueueFull = errors.New("Route queue is full. Route is closed.")
+
+	// ErrReadOnlyReplica is returned by `HandleMessage` when a message is published on a replica node
+	ErrReadOnlyReplica = errors.New("This guble node is a read-only replica. Messages have to be published on another node.")
 )
 
 // PermissionDeniedError is returned when AccessManager denies a user request for a topic
@@ -49,3 +52,16 @@ type ModuleStoppingError struct {
 func (m *ModuleStoppingError) Error() string {
 	return fmt.Sprintf("Service %s is stopping", m.Name)
 }
+
+// MessageRejectedError is returned by `HandleMessage` when an interceptor rejects the message
+type MessageRejectedError struct {
+	// name of the rejecting interceptor
+	Interceptor string
+
+	// the reason given by the interceptor
+	Reason string
+}
+
+func (e *MessageRejectedError) Error() string {
+	return fmt.Sprintf("Message rejected by %s: %s", e.Interceptor, e.Reason)
+}
@@ -0,0 +1,57 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"unicode/utf8"
+
+	"github.com/smancke/guble/protocol"
+)
+
+var (
+	// ErrHeaderTooLarge is returned when the header of a message exceeds the maximum size.
+	ErrHeaderTooLarge = errors.New("The header exceeds the maximum size.")
+
+	// ErrHeaderTooManyKeys is returned when the header of a message has more keys than allowed.
+	ErrHeaderTooManyKeys = errors.New("The header exceeds the maximum number of keys.")
+
+	// ErrHeaderNotUTF8 is returned when the header of a message is not valid UTF-8.
+	ErrHeaderNotUTF8 = errors.New("The header is not valid UTF-8.")
+
+	// ErrHeaderNotJSONObject is returned when the keys of a header are limited, but the header is no JSON object.
+	ErrHeaderNotJSONObject = errors.New("The header is no JSON object.")
+)
+
+// HeaderLimits is an Interceptor rejecting the messages with oversized headers,
+// so that a single publisher can not blow up the stored messages and the websocket frames.
+type HeaderLimits struct {
+	// MaxSize is the maximum size of the header JSON in bytes (no limit, if <= 0)
+	MaxSize int
+
+	// MaxKeys is the maximum number of keys of the header JSON (no limit, if <= 0)
+	MaxKeys int
+}
+
+// Intercept checks the header of the message against the limits.
+// It is a part of the Interceptor implementation.
+func (l HeaderLimits) Intercept(m *protocol.Message) error {
+	if m.HeaderJSON == "" {
+		return nil
+	}
+	if l.MaxSize > 0 && len(m.HeaderJSON) > l.MaxSize {
+		return ErrHeaderTooLarge
+	}
+	if !utf8.ValidString(m.HeaderJSON) {
+		return ErrHeaderNotUTF8
+	}
+	if l.MaxKeys > 0 {
+		var header map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(m.HeaderJSON), &header); err != nil {
+			return ErrHeaderNotJSONObject
+		}
+		if len(header) > l.MaxKeys {
+			return ErrHeaderTooManyKeys
+		}
+	}
+	return nil
+}
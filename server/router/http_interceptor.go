@@ -0,0 +1,94 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+)
+
+var errHookUnavailable = errors.New("Hook is not available.")
+
+// hookMessage is the JSON representation of a message exchanged with an external hook.
+type hookMessage struct {
+	Path          protocol.Path     `json:"path"`
+	UserID        string            `json:"user_id,omitempty"`
+	ApplicationID string            `json:"application_id,omitempty"`
+	Filters       map[string]string `json:"filters,omitempty"`
+	Headers       string            `json:"headers,omitempty"`
+	ContentType   string            `json:"content_type,omitempty"`
+	Body          []byte            `json:"body"`
+}
+
+type httpInterceptor struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPInterceptor returns an Interceptor posting each message as JSON to an external hook.
+// The hook accepts the message as it is with `204 No Content`, or returns it modified with `200 OK`
+// (its path can not be changed), or rejects it with a 4xx status and the reason as response body.
+// The message is rejected too, when the hook can not be reached or fails.
+func NewHTTPInterceptor(url string, timeout time.Duration) Interceptor {
+	return &httpInterceptor{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (h *httpInterceptor) Intercept(m *protocol.Message) error {
+	data, err := json.Marshal(&hookMessage{
+		Path:          m.Path,
+		UserID:        m.UserID,
+		ApplicationID: m.ApplicationID,
+		Filters:       m.Filters,
+		Headers:       m.HeaderJSON,
+		ContentType:   m.ContentType,
+		Body:          m.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		logger.WithField("url", h.url).WithField("error", err.Error()).Error("Error calling hook")
+		return errHookUnavailable
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return nil
+	case resp.StatusCode == http.StatusOK:
+		modified := new(hookMessage)
+		if err := json.NewDecoder(resp.Body).Decode(modified); err != nil {
+			return fmt.Errorf("Invalid message returned by hook: %s", err.Error())
+		}
+		if modified.Path != "" && modified.Path != m.Path {
+			return errors.New("The hook can not change the path of the message.")
+		}
+		m.UserID = modified.UserID
+		m.ApplicationID = modified.ApplicationID
+		m.Filters = modified.Filters
+		m.HeaderJSON = modified.Headers
+		m.ContentType = modified.ContentType
+		m.Body = modified.Body
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		reason, _ := ioutil.ReadAll(resp.Body)
+		if len(reason) == 0 {
+			return errors.New(http.StatusText(resp.StatusCode))
+		}
+		return errors.New(strings.TrimSpace(string(reason)))
+	default:
+		logger.WithField("url", h.url).WithField("status", resp.StatusCode).Error("Unexpected response of hook")
+		return errHookUnavailable
+	}
+}
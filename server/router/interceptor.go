@@ -0,0 +1,61 @@
+package router
+
+import (
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// Interceptor inspects each message published on this guble node, before it is stored and delivered.
+// It can mutate or enrich the message (e.g. strip personal data, add localization),
+// or reject it by returning an error (e.g. when the body does not match the schema of the topic).
+type Interceptor interface {
+	Intercept(*protocol.Message) error
+}
+
+// InterceptorFunc is a function used as Interceptor.
+type InterceptorFunc func(*protocol.Message) error
+
+// Intercept calls the function.
+func (f InterceptorFunc) Intercept(m *protocol.Message) error {
+	return f(m)
+}
+
+// Interceptable is implemented by the routers which accept message interceptors.
+type Interceptable interface {
+	// AddInterceptor appends an interceptor, called after the ones added before.
+	AddInterceptor(name string, i Interceptor)
+}
+
+type namedInterceptor struct {
+	name string
+	Interceptor
+}
+
+// AddInterceptor is a part of the Interceptable implementation.
+func (router *router) AddInterceptor(name string, i Interceptor) {
+	router.Lock()
+	defer router.Unlock()
+	router.interceptors = append(router.interceptors, namedInterceptor{name, i})
+	logger.WithField("interceptor", name).Info("Added message interceptor")
+}
+
+// intercept passes the message through all the interceptors, stopping at the first one rejecting it.
+func (router *router) intercept(message *protocol.Message) error {
+	router.RLock()
+	interceptors := router.interceptors
+	router.RUnlock()
+
+	for _, i := range interceptors {
+		if err := i.Intercept(message); err != nil {
+			logger.WithFields(log.Fields{
+				"interceptor": i.name,
+				"path":        message.Path,
+				"error":       err.Error(),
+			}).Info("Message rejected by interceptor")
+			mTotalMessagesRejected.Add(1)
+			return &MessageRejectedError{Interceptor: i.name, Reason: err.Error()}
+		}
+	}
+	return nil
+}
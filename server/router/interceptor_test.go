@@ -0,0 +1,112 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_InterceptorsMutateAndRejectMessages(t *testing.T) {
+	a := assert.New(t)
+
+	router, r := aRouterRoute(chanSize)
+	router.AddInterceptor("strip", InterceptorFunc(func(m *protocol.Message) error {
+		m.Body = bytes.Replace(m.Body, []byte("secret"), []byte("******"), -1)
+		return nil
+	}))
+	router.AddInterceptor("schema", InterceptorFunc(func(m *protocol.Message) error {
+		var body interface{}
+		if err := json.Unmarshal(m.Body, &body); err != nil {
+			return errors.New("The body is no JSON.")
+		}
+		return nil
+	}))
+
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: []byte(`{"pin": "secret"}`)}))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte(`{"pin": "******"}`))
+
+	err := router.HandleMessage(&protocol.Message{Path: r.Path, Body: aTestByteMessage})
+	a.Equal(&MessageRejectedError{Interceptor: "schema", Reason: "The body is no JSON."}, err)
+	select {
+	case m := <-r.MessagesChannel():
+		a.Fail("The rejected message was delivered", string(m.Body))
+	case <-time.After(5 * time.Millisecond):
+	}
+}
+
+func TestHTTPInterceptor(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m hookMessage
+		a.NoError(json.NewDecoder(r.Body).Decode(&m))
+		switch string(m.Body) {
+		case "accept":
+			w.WriteHeader(http.StatusNoContent)
+		case "enrich":
+			m.Headers = `{"Lang": "de"}`
+			m.Body = []byte("Hallo")
+			json.NewEncoder(w).Encode(&m)
+		case "move":
+			m.Path = "/elsewhere"
+			json.NewEncoder(w).Encode(&m)
+		case "fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.Error(w, "Unknown body.", http.StatusUnprocessableEntity)
+		}
+	}))
+	defer server.Close()
+
+	i := NewHTTPInterceptor(server.URL, time.Second)
+
+	m := &protocol.Message{Path: "/foo", UserID: "marvin", Body: []byte("accept")}
+	a.NoError(i.Intercept(m))
+	a.Equal("accept", string(m.Body))
+
+	m = &protocol.Message{Path: "/foo", UserID: "marvin", Body: []byte("enrich")}
+	a.NoError(i.Intercept(m))
+	a.Equal("Hallo", string(m.Body))
+	a.Equal(`{"Lang": "de"}`, m.HeaderJSON)
+	a.Equal("marvin", m.UserID)
+
+	a.Error(i.Intercept(&protocol.Message{Path: "/foo", Body: []byte("move")}))
+	a.Equal(errHookUnavailable, i.Intercept(&protocol.Message{Path: "/foo", Body: []byte("fail")}))
+	a.EqualError(i.Intercept(&protocol.Message{Path: "/foo", Body: []byte("other")}), "Unknown body.")
+
+	server.Close()
+	a.Equal(errHookUnavailable, i.Intercept(&protocol.Message{Path: "/foo", Body: []byte("accept")}))
+}
+
+func TestHeaderLimits(t *testing.T) {
+	a := assert.New(t)
+
+	limits := HeaderLimits{MaxSize: 32, MaxKeys: 2}
+	a.NoError(limits.Intercept(&protocol.Message{}))
+	a.NoError(limits.Intercept(&protocol.Message{HeaderJSON: `{"a": "1", "b": "2"}`}))
+	a.Equal(ErrHeaderTooLarge, limits.Intercept(&protocol.Message{HeaderJSON: `{"a": "` + strings.Repeat("x", 32) + `"}`}))
+	a.Equal(ErrHeaderTooManyKeys, limits.Intercept(&protocol.Message{HeaderJSON: `{"a": "1", "b": "2", "c": "3"}`}))
+	a.Equal(ErrHeaderNotUTF8, limits.Intercept(&protocol.Message{HeaderJSON: "{\"a\": \"\xff\"}"}))
+	a.Equal(ErrHeaderNotJSONObject, limits.Intercept(&protocol.Message{HeaderJSON: `["a", "b"]`}))
+
+	// without limits, only the encoding is checked
+	a.NoError(HeaderLimits{}.Intercept(&protocol.Message{HeaderJSON: `no json`}))
+	a.Equal(ErrHeaderNotUTF8, HeaderLimits{}.Intercept(&protocol.Message{HeaderJSON: "\xff"}))
+}
+
+func TestCheckReferences(t *testing.T) {
+	a := assert.New(t)
+
+	a.NoError(CheckReferences.Intercept(&protocol.Message{}))
+	a.NoError(CheckReferences.Intercept(&protocol.Message{HeaderJSON: `{"Supersedes": "42"}`}))
+	a.NoError(CheckReferences.Intercept(&protocol.Message{HeaderJSON: `{"Deletes": 42}`}))
+	a.Error(CheckReferences.Intercept(&protocol.Message{HeaderJSON: `{"Deletes": "latest"}`}))
+}
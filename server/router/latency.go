@@ -0,0 +1,25 @@
+package router
+
+import (
+	"time"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// RecordDelivery records the latency of a delivered message, from its reception by the router of this node,
+// by kind of subscriber (e.g. `websocket`, or the name of a connector) and first level of its topic.
+// The messages fetched from the store (which were not received meanwhile) are not recorded.
+func RecordDelivery(kind string, m *protocol.Message) {
+	if m.ReceivedAt.IsZero() {
+		return
+	}
+	mDeliveryLatencies.Observe(kind, latencyTopic(m.Path), time.Since(m.ReceivedAt))
+}
+
+// latencyTopic returns the first level of a topic, all the topics of the users being grouped in `/user:`.
+func latencyTopic(path protocol.Path) string {
+	if _, ok := path.TargetUser(); ok {
+		return "/" + protocol.UserTargetPrefix
+	}
+	return "/" + path.Partition()
+}
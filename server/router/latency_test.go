@@ -0,0 +1,17 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+)
+
+func TestLatencyTopic(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("/orders", latencyTopic("/orders/new/42"))
+	a.Equal("/orders", latencyTopic("/orders"))
+	a.Equal("/user:", latencyTopic(protocol.UserPath("alice")))
+}
@@ -10,23 +10,23 @@ const (
 	defaultQueueCap = 50
 )
 
-type queue struct {
+type messageQueue struct {
 	mu    sync.Mutex
 	queue []*protocol.Message
 }
 
-// newQueue creates a *queue that will have the capacity specified by size.
+// newQueue creates a *messageQueue that will have the capacity specified by size.
 // If `size` is negative use the defaultQueueCap.
-func newQueue(size int) *queue {
+func newQueue(size int) *messageQueue {
 	if size < 0 {
 		size = defaultQueueCap
 	}
-	return &queue{
+	return &messageQueue{
 		queue: make([]*protocol.Message, 0, size),
 	}
 }
 
-func (q *queue) push(m *protocol.Message) {
+func (q *messageQueue) push(m *protocol.Message) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
@@ -34,7 +34,7 @@ func (q *queue) push(m *protocol.Message) {
 }
 
 // remove the first item from the queue if exists
-func (q *queue) remove() {
+func (q *messageQueue) remove() {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
@@ -45,7 +45,7 @@ func (q *queue) remove() {
 }
 
 // poll returns the first item from the queue without removing it
-func (q *queue) poll() (*protocol.Message, error) {
+func (q *messageQueue) poll() (*protocol.Message, error) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
@@ -56,7 +56,7 @@ func (q *queue) poll() (*protocol.Message, error) {
 	return q.queue[0], nil
 }
 
-func (q *queue) size() int {
+func (q *messageQueue) size() int {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	return len(q.queue)
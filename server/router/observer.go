@@ -0,0 +1,50 @@
+package router
+
+import (
+	"github.com/smancke/guble/protocol"
+)
+
+// Observer is notified of each message handled by the router, after it is stored and before it is delivered
+// to the matching routes (including the messages received from the other guble nodes).
+// It is called by the routing goroutine, so it must not block.
+type Observer interface {
+	Observe(*protocol.Message)
+}
+
+// ObserverFunc is a function used as Observer.
+type ObserverFunc func(*protocol.Message)
+
+// Observe calls the function.
+func (f ObserverFunc) Observe(m *protocol.Message) {
+	f(m)
+}
+
+// Observable is implemented by the routers which accept message observers.
+type Observable interface {
+	// AddObserver adds an observer, notified after the ones added before.
+	AddObserver(name string, o Observer)
+}
+
+type namedObserver struct {
+	name string
+	Observer
+}
+
+// AddObserver is a part of the Observable implementation.
+func (router *router) AddObserver(name string, o Observer) {
+	router.Lock()
+	defer router.Unlock()
+	router.observers = append(router.observers, namedObserver{name, o})
+	logger.WithField("observer", name).Info("Added message observer")
+}
+
+// observe notifies all the observers of the message.
+func (router *router) observe(message *protocol.Message) {
+	router.RLock()
+	observers := router.observers
+	router.RUnlock()
+
+	for _, o := range observers {
+		o.Observe(message)
+	}
+}
@@ -0,0 +1,36 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_ObserversAreNotifiedBeforeDelivery(t *testing.T) {
+	a := assert.New(t)
+
+	router, r := aRouterRoute(chanSize)
+	observedC := make(chan *protocol.Message, 2)
+	router.AddObserver("test", ObserverFunc(func(m *protocol.Message) {
+		// the message is stored, but not delivered yet
+		a.NotZero(m.ID)
+		a.Equal(0, len(r.MessagesChannel()))
+		observedC <- m
+	}))
+
+	// the messages not matching any route are observed as well
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/nobody", Body: aTestByteMessage}))
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: aTestByteMessage}))
+
+	for _, path := range []protocol.Path{"/nobody", r.Path} {
+		select {
+		case m := <-observedC:
+			a.Equal(path, m.Path)
+		case <-time.After(time.Second):
+			a.Fail("The message was not observed", string(path))
+		}
+	}
+	assertChannelContainsMessage(a, r.MessagesChannel(), aTestByteMessage)
+}
@@ -0,0 +1,12 @@
+package router
+
+import (
+	"github.com/smancke/guble/protocol"
+)
+
+// CheckReferences is an Interceptor rejecting the messages with an invalid reference to an earlier message,
+// given by the protocol.SupersedesHeader or protocol.DeletesHeader of their header.
+var CheckReferences = InterceptorFunc(func(m *protocol.Message) error {
+	_, _, err := m.Reference()
+	return err
+})
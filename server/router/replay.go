@@ -0,0 +1,57 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+)
+
+// MaxReplayMessages bounds the range of the IDs of a replay.
+const MaxReplayMessages = 1000
+
+// ErrInvalidReplayRange is returned by FetchReplay for a range of IDs which is empty, or larger than MaxReplayMessages.
+var ErrInvalidReplayRange = fmt.Errorf("The replayed IDs have to be a range of 1 to %d IDs, from 1.", MaxReplayMessages)
+
+// FetchReplay returns the stored messages of the topic (including its subtopics) with the IDs from..to,
+// in order, so that they can be replayed to a single subscriber (e.g. for a user who missed them).
+func FetchReplay(r Router, topic protocol.Path, from, to uint64) ([]*protocol.Message, error) {
+	if from == 0 || to < from || to-from >= MaxReplayMessages {
+		return nil, ErrInvalidReplayRange
+	}
+	messageStore, err := r.MessageStore()
+	if err != nil {
+		return nil, err
+	}
+	req := store.NewFetchRequest(topic.Partition(), from, to, store.DirectionForward, int(to-from+1))
+	req.Init()
+	go messageStore.Fetch(req)
+
+	var messages []*protocol.Message
+	for {
+		select {
+		case <-req.StartC:
+		case fetched, open := <-req.MessageC:
+			if !open {
+				return messages, nil
+			}
+			if fetched.ID < from || fetched.ID > to {
+				continue
+			}
+			m, err := protocol.ParseMessage(fetched.Message)
+			if err != nil {
+				logger.WithError(err).WithField("id", fetched.ID).Error("Skipping a stored message which cannot be parsed")
+				continue
+			}
+			if matchesTopic(m.Path, topic) {
+				messages = append(messages, m)
+			}
+		case err := <-req.ErrorC:
+			if err == nil {
+				err = errors.New("The message store failed to fetch the messages.")
+			}
+			return nil, err
+		}
+	}
+}
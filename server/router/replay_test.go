@@ -0,0 +1,49 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store/filestore"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestFetchReplay(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_router_replay_test")
+	defer os.RemoveAll(dir)
+	messageStore := filestore.New(dir)
+	defer messageStore.Stop()
+	for _, m := range []*protocol.Message{
+		{ID: 1, Path: "/news/sport", Body: []byte("1")},
+		{ID: 2, Path: "/news/sport/football", Body: []byte("2")},
+		{ID: 3, Path: "/news/politics", Body: []byte("3")},
+		{ID: 4, Path: "/news/sport", Body: []byte("4")},
+		{ID: 5, Path: "/news/sport", Body: []byte("5")},
+	} {
+		a.NoError(messageStore.Store(m.Path.Partition(), m.ID, m.Bytes()))
+	}
+
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().MessageStore().Return(messageStore, nil).AnyTimes()
+
+	// the messages of the range in the topic (including its subtopics) are returned in order
+	messages, err := FetchReplay(routerMock, "/news/sport", 2, 4)
+	a.NoError(err)
+	if a.Len(messages, 2) {
+		a.Equal(uint64(2), messages[0].ID)
+		a.Equal(uint64(4), messages[1].ID)
+	}
+
+	for _, invalid := range [][2]uint64{{0, 3}, {4, 3}, {1, MaxReplayMessages + 1}} {
+		_, err := FetchReplay(routerMock, "/news/sport", invalid[0], invalid[1])
+		a.Equal(ErrInvalidReplayRange, err)
+	}
+}
@@ -0,0 +1,37 @@
+package router
+
+import (
+	"github.com/smancke/guble/protocol"
+)
+
+// Resolver maps the topic of a published message or of a subscription to the topic actually used,
+// e.g. when the topic is an alias of another one.
+type Resolver interface {
+	// Resolve returns the topic actually used for a path (the path itself, if it is not mapped).
+	Resolve(path protocol.Path) protocol.Path
+}
+
+// Resolvable is implemented by the routers which accept a topic resolver.
+type Resolvable interface {
+	// SetResolver sets the resolver of the topics, replacing the previous one.
+	SetResolver(r Resolver)
+}
+
+// SetResolver is a part of the Resolvable implementation.
+func (router *router) SetResolver(r Resolver) {
+	router.Lock()
+	defer router.Unlock()
+	router.resolver = r
+}
+
+// resolve returns the topic actually used for a path.
+func (router *router) resolve(path protocol.Path) protocol.Path {
+	router.RLock()
+	resolver := router.resolver
+	router.RUnlock()
+
+	if resolver == nil {
+		return path
+	}
+	return resolver.Resolve(path)
+}
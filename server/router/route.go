@@ -29,7 +29,7 @@ type Route struct {
 	// queue that will store the messages in correct order.
 	// The queue can have a settable size;
 	// if it reaches the capacity the route is closed.
-	queue *queue
+	queue *messageQueue
 
 	closeC chan struct{}
 
@@ -183,6 +183,12 @@ REFETCH:
 			if err != nil {
 				return err
 			}
+			if IsExpired(router, message) {
+				// skipped, as if it was delivered
+				mTotalMessagesExpired.Add(1)
+				lastID = message.ID
+				continue
+			}
 
 			r.logger.WithField("messageID", message.ID).Debug("Sending fetched message in channel")
 			if err := r.Deliver(message, true); err != nil {
@@ -18,6 +18,17 @@ type RouteConfig struct {
 
 	ChannelSize int
 
+	// Kind selects the goroutines delivering the messages to the route
+	Kind RouteKind `json:"-"`
+
+	// Shared is set for a route receiving the messages of several subscribers, which apply the message filters
+	// themselves: the filters are not applied to the route
+	Shared bool `json:"-"`
+
+	// Exact is set for a route receiving only the messages of its path (where a level can still be a wildcard),
+	// and not the ones of its subtopics
+	Exact bool `json:"-"`
+
 	// queueSize specifies the size of the internal queue slice
 	// (how many items to hold before the channel is closed).
 	// If set to `0` then the queue will have no capacity and the messages
@@ -45,7 +56,10 @@ func (rc *RouteConfig) Equal(other RouteConfig, keys ...string) bool {
 
 // messageFilter returns true if the route matches message filters
 func (rc *RouteConfig) messageFilter(m *protocol.Message) bool {
-	if m.Filters == nil {
+	if _, user := m.Path.TargetUser(); !user && rc.Exact && !MatchesExactly(m.Path, rc.Path) {
+		return false
+	}
+	if m.Filters == nil || rc.Shared {
 		return true
 	}
 
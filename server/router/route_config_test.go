@@ -205,4 +205,11 @@ func TestRouteConfig_messageFilter(t *testing.T) {
 		m := &protocol.Message{Filters: c.filters}
 		a.Equal(c.result, routeConfig.messageFilter(m), "Failed filter: "+name)
 	}
+
+	// the filters are applied by the subscribers of a shared route
+	routeConfig.Shared = true
+	for name, c := range testcases {
+		m := &protocol.Message{Filters: c.filters}
+		a.True(routeConfig.messageFilter(m), "Failed shared filter: "+name)
+	}
 }
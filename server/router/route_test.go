@@ -230,6 +230,24 @@ func TestRoute_messageFilter(t *testing.T) {
 	a.False(isMessageReceived(route, msg))
 }
 
+func TestRoute_ExactReceivesNoSubtopics(t *testing.T) {
+	a := assert.New(t)
+
+	route := NewRoute(RouteConfig{
+		Path:        "/topic/*",
+		ChannelSize: 1,
+		Exact:       true,
+	})
+
+	msg := &protocol.Message{ID: 1, Path: "/topic/sub"}
+	route.Deliver(msg, false)
+	a.True(isMessageReceived(route, msg))
+
+	msg = &protocol.Message{ID: 2, Path: "/topic/sub/subsub"}
+	route.Deliver(msg, true)
+	a.False(isMessageReceived(route, msg))
+}
+
 func isMessageReceived(route *Route, msg *protocol.Message) bool {
 	select {
 	case m, opened := <-route.MessagesChannel():
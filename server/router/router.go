@@ -3,8 +3,8 @@ package router
 import (
 	"fmt"
 	"runtime"
-	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/health"
@@ -17,6 +17,7 @@ import (
 	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/cluster"
 	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/queue"
 	"github.com/smancke/guble/server/store"
 )
 
@@ -52,7 +53,8 @@ type subRequest struct {
 
 type router struct {
 	routes       map[protocol.Path][]*Route // mapping the path to the route slice
-	handleC      chan *protocol.Message
+	index        *topicIndex                // the paths of the routes, for matching the messages
+	handleQ      *queue.Queue
 	subscribeC   chan subRequest
 	unsubscribeC chan subRequest
 	stopC        chan bool      // Channel that signals stop of the router
@@ -63,6 +65,28 @@ type router struct {
 	messageStore  store.MessageStore
 	kvStore       kvstore.KVStore
 	cluster       *cluster.Cluster
+	clusterMu     sync.RWMutex
+
+	interceptors []namedInterceptor
+	observers    []namedObserver
+	sequencer    sequencer
+	resolver     Resolver
+
+	// ephemeralTopics are the topics whose messages are not stored
+	ephemeralTopics []protocol.Path
+	settings        TopicSettings
+
+	deliveryWorkers map[RouteKind]int
+	deliveries      map[RouteKind]*deliveryPool
+
+	// broadcastTopic is the topic whose messages are delivered over broadcastSpread
+	broadcastTopic  protocol.Path
+	broadcastSpread time.Duration
+	spreader        *spreader
+
+	// compression is the content encoding of the stored bodies of at least compressionThreshold bytes
+	compression          string
+	compressionThreshold int
 
 	sync.RWMutex
 }
@@ -71,12 +95,18 @@ type router struct {
 func New(accessManager auth.AccessManager, messageStore store.MessageStore, kvStore kvstore.KVStore, cluster *cluster.Cluster) Router {
 	return &router{
 		routes: make(map[protocol.Path][]*Route),
+		index:  newTopicIndex(),
 
-		handleC:      make(chan *protocol.Message, handleChannelCapacity),
+		handleQ: queue.MustNew(queue.Config{
+			Name:     "router_handle",
+			Capacity: handleChannelCapacity,
+		}),
 		subscribeC:   make(chan subRequest, subscribeChannelCapacity),
 		unsubscribeC: make(chan subRequest, unsubscribeChannelCapacity),
 		stopC:        make(chan bool, 1),
 
+		deliveryWorkers: make(map[RouteKind]int),
+
 		accessManager: accessManager,
 		messageStore:  messageStore,
 		kvStore:       kvStore,
@@ -91,10 +121,14 @@ func (router *router) Start() error {
 
 	router.wg.Add(1)
 	router.setStopping(false)
+	router.startDeliveries()
+	router.startSpreader()
 
 	go func() {
 		for {
 			if router.stopping && router.channelsAreEmpty() {
+				router.spreader.stop()
+				router.stopDeliveries()
 				router.closeRoutes()
 				router.wg.Done()
 				return
@@ -104,9 +138,11 @@ func (router *router) Start() error {
 				defer protocol.PanicLogger()
 
 				select {
-				case message := <-router.handleC:
-					router.handleMessage(message)
+				case e := <-router.handleQ.C():
+					router.handleMessage(router.handleQ.Take(e).(*protocol.Message))
 					runtime.Gosched()
+				case task := <-router.spreader.tasks():
+					router.dispatchSpread(task)
 				case subscriber := <-router.subscribeC:
 					router.subscribe(subscriber.route)
 					subscriber.doneC <- true
@@ -162,35 +198,97 @@ func (router *router) HandleMessage(message *protocol.Message) error {
 		"path":   message.Path}).Debug("HandleMessage")
 
 	mTotalMessagesIncoming.Add(1)
+	message.ReceivedAt = time.Now()
 	if err := router.isStopping(); err != nil {
 		logger.WithField("error", err.Error()).Error("Router is stopping")
 		return err
 	}
 
-	if !router.accessManager.IsAllowed(auth.WRITE, message.UserID, message.Path) {
+	// the cluster is read once, as it may be attached meanwhile
+	cl := router.Cluster()
+
+	// a replica only handles the messages received from the other guble nodes
+	if cl != nil && cl.Config.Replica && message.NodeID == 0 {
+		return ErrReadOnlyReplica
+	}
+
+	// a message published to `user:<userID>` is stored in the topic of the user
+	if userID, ok := message.Path.TargetUser(); ok {
+		message.Path = protocol.UserPath(userID)
+	}
+
+	if !router.isAllowed(auth.WRITE, message.UserID, message.Path) {
 		return &PermissionDeniedError{UserID: message.UserID, AccessType: auth.WRITE, Path: message.Path}
 	}
 
+	// a message published to an alias is published to its target, if allowed as well
+	if path := router.resolve(message.Path); path != message.Path {
+		if !router.isAllowed(auth.WRITE, message.UserID, path) {
+			return &PermissionDeniedError{UserID: message.UserID, AccessType: auth.WRITE, Path: path}
+		}
+		message.Path = path
+	}
+
+	// the messages received from other guble nodes were already intercepted by the node which received them
+	if cl == nil || message.NodeID == 0 {
+		path := message.Path
+		if err := router.intercept(message); err != nil {
+			return err
+		}
+		if message.Path != path && !router.isAllowed(auth.WRITE, message.UserID, message.Path) {
+			return &PermissionDeniedError{UserID: message.UserID, AccessType: auth.WRITE, Path: message.Path}
+		}
+	}
+
 	var nodeID uint8
-	if router.cluster != nil {
-		nodeID = router.cluster.Config.ID
+	if cl != nil {
+		nodeID = cl.Config.ID
 	}
 
 	mTotalMessagesIncomingBytes.Add(int64(len(message.Bytes())))
-	size, err := router.messageStore.StoreMessage(message, nodeID)
+	ephemeral := router.isEphemeral(message)
+
+	// the ID is given, the message is stored and queued for delivery while no other message of the partition is handled,
+	// so that the messages are delivered in the order of their IDs
+	err := router.sequencer.sequence(message.Path.Partition(), func(lastID uint64) (uint64, error) {
+		if ephemeral {
+			if err := router.identify(message, nodeID); err != nil {
+				logger.WithField("error", err.Error()).Error("Error giving an ID to an ephemeral message")
+				mTotalMessageStoreErrors.Add(1)
+				return 0, err
+			}
+			if err := router.compress(message); err != nil {
+				logger.WithField("error", err.Error()).Error("Error compressing an ephemeral message")
+				return 0, err
+			}
+			mTotalMessagesEphemeral.Add(1)
+		} else {
+			size, err := router.store(message, nodeID)
+			if err != nil {
+				logger.WithField("error", err.Error()).Error("Error storing message")
+				mTotalMessageStoreErrors.Add(1)
+				return 0, err
+			}
+			mTotalMessagesStoredBytes.Add(int64(size))
+		}
+
+		// the message is complete after storing it (e.g. with its ID), so it is serialized only once for all the routes
+		message.Freeze()
+
+		router.handleOverloadedChannel()
+
+		if err := router.handleQ.Push(message); err != nil {
+			logger.WithError(err).WithField("id", message.ID).Error("Stored message could not be routed")
+			return message.ID, err
+		}
+		return message.ID, nil
+	})
 	if err != nil {
-		logger.WithField("error", err.Error()).Error("Error storing message")
-		mTotalMessageStoreErrors.Add(1)
 		return err
 	}
-	mTotalMessagesStoredBytes.Add(int64(size))
-
-	router.handleOverloadedChannel()
 
-	router.handleC <- message
-
-	if router.cluster != nil && message.NodeID == router.cluster.Config.ID {
-		go router.cluster.BroadcastMessage(message)
+	if cl != nil && message.NodeID == cl.Config.ID {
+		go cl.BroadcastMessage(message)
 	}
 
 	return nil
@@ -209,10 +307,18 @@ func (router *router) Subscribe(r *Route) (*Route, error) {
 	userID := r.Get("user_id")
 	routePath := r.Path
 
-	accessAllowed := router.accessManager.IsAllowed(auth.READ, userID, routePath)
+	accessAllowed := router.isAllowed(auth.READ, userID, routePath)
 	if !accessAllowed {
 		return r, &PermissionDeniedError{UserID: userID, AccessType: auth.READ, Path: routePath}
 	}
+
+	// a subscription to an alias is a subscription to its target, if allowed as well
+	if path := router.resolve(routePath); path != routePath {
+		if !router.isAllowed(auth.READ, userID, path) {
+			return r, &PermissionDeniedError{UserID: userID, AccessType: auth.READ, Path: path}
+		}
+		r.Path = path
+	}
 	req := subRequest{
 		route: r,
 		doneC: make(chan bool),
@@ -267,6 +373,7 @@ func (router *router) subscribe(r *Route) {
 		// Path not present yet. Initialize the slice
 		slice = make([]*Route, 0, 1)
 		router.routes[routePath] = slice
+		router.index.add(routePath)
 		mCurrentRoutes.Add(1)
 	}
 	router.routes[routePath] = append(slice, r)
@@ -298,6 +405,7 @@ func (router *router) unsubscribe(r *Route) {
 	}
 	if len(router.routes[routePath]) == 0 {
 		delete(router.routes, routePath)
+		router.index.remove(routePath)
 		mCurrentRoutes.Add(-1)
 	}
 }
@@ -310,7 +418,7 @@ func (router *router) panicIfInternalDependenciesAreNil() {
 }
 
 func (router *router) channelsAreEmpty() bool {
-	return len(router.handleC) == 0 && len(router.subscribeC) == 0 && len(router.unsubscribeC) == 0
+	return router.handleQ.Len() == 0 && len(router.subscribeC) == 0 && len(router.unsubscribeC) == 0
 }
 
 func (router *router) setStopping(v bool) {
@@ -344,20 +452,24 @@ func (router *router) handleMessage(message *protocol.Message) {
 	flog.Debug("Called routeMessage for data")
 	mTotalMessagesRouted.Add(1)
 
-	matched := false
-	for path, pathRoutes := range router.routes {
-		if matchesTopic(message.Path, path) {
-			matched = true
-			for _, route := range pathRoutes {
-				if err := route.Deliver(message, false); err == ErrInvalidRoute {
-					// Unsubscribe invalid routes
-					router.unsubscribe(route)
-				}
-			}
+	router.observe(message)
+
+	if userID, ok := message.Path.TargetUser(); ok {
+		router.dispatch(message, router.userRoutes(userID))
+		return
+	}
+
+	spread := router.isSpread(message)
+	paths := router.index.match(message.Path)
+	for _, path := range paths {
+		if spread {
+			router.spreader.schedule(message, router.routes[path])
+			continue
 		}
+		router.dispatch(message, router.routes[path])
 	}
 
-	if !matched {
+	if len(paths) == 0 {
 		flog.Debug("No route matched.")
 		mTotalMessagesNotMatchingTopic.Add(1)
 	}
@@ -376,22 +488,35 @@ func (router *router) closeRoutes() {
 }
 
 func (router *router) handleOverloadedChannel() {
-	if float32(len(router.handleC))/float32(cap(router.handleC)) > overloadedHandleChannelRatio {
+	if float32(router.handleQ.Len())/float32(router.handleQ.Cap()) > overloadedHandleChannelRatio {
 		logger.WithFields(log.Fields{
-			"currentLength": len(router.handleC),
-			"maxCapacity":   cap(router.handleC),
-		}).Warn("handleC channel is almost full")
+			"currentLength": router.handleQ.Len(),
+			"maxCapacity":   router.handleQ.Cap(),
+		}).Warn("handle queue is almost full")
 		mTotalOverloadedHandleChannel.Add(1)
 	}
 }
 
-// matchesTopic checks whether the supplied routePath matches the message topic
+// matchesTopic checks whether the supplied routePath matches the message topic,
+// as the topic index does when routing the messages
 func matchesTopic(messagePath, routePath protocol.Path) bool {
-	messagePathLen := len(string(messagePath))
-	routePathLen := len(string(routePath))
-	return strings.HasPrefix(string(messagePath), string(routePath)) &&
-		(messagePathLen == routePathLen ||
-			(messagePathLen > routePathLen && string(messagePath)[routePathLen] == '/'))
+	messageLevels := levels(messagePath)
+	routeLevels := levels(routePath)
+	if len(routeLevels) > len(messageLevels) {
+		return false
+	}
+	for i, level := range routeLevels {
+		if level != messageLevels[i] && level != wildcardLevel {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesExactly checks whether the supplied routePath matches the message topic, without its subtopics
+// (a level of the routePath can still be a wildcard)
+func MatchesExactly(messagePath, routePath protocol.Path) bool {
+	return len(levels(messagePath)) == len(levels(routePath)) && matchesTopic(messagePath, routePath)
 }
 
 // removeIfMatching removes a route from the supplied list, based on same ApplicationID id and same path (if existing)
@@ -415,6 +540,12 @@ func (router *router) Fetch(req *store.FetchRequest) error {
 	if err := router.isStopping(); err != nil {
 		return err
 	}
+	if cl := router.Cluster(); cl != nil {
+		// the messages stored by the other nodes only (e.g. before this node joined) are fetched first
+		if _, err := cl.FetchMissing(req); err != nil {
+			logger.WithError(err).WithField("partition", req.Partition).Error("Error fetching the missing messages from the cluster")
+		}
+	}
 	router.messageStore.Fetch(req)
 	return nil
 }
@@ -424,7 +555,7 @@ func (router *router) AccessManager() (auth.AccessManager, error) {
 	if router.accessManager == nil {
 		return nil, ErrServiceNotProvided
 	}
-	return router.accessManager, nil
+	return topicAccessManager{router}, nil
 }
 
 // MessageStore returns the `messageStore` provided for the router
@@ -445,6 +576,8 @@ func (router *router) KVStore() (kvstore.KVStore, error) {
 
 // Cluster returns the `cluster` provided for the router, or nil if no cluster was set-up
 func (router *router) Cluster() *cluster.Cluster {
+	router.clusterMu.RLock()
+	defer router.clusterMu.RUnlock()
 	return router.cluster
 }
 
@@ -17,12 +17,18 @@ var (
 	mTotalMessagesIncoming                     = metrics.NewInt("router.total_messages_incoming")
 	mTotalMessagesIncomingBytes                = metrics.NewInt("router.total_messages_bytes_incoming")
 	mTotalMessagesStoredBytes                  = metrics.NewInt("router.total_messages_bytes_stored")
+	mTotalMessagesEphemeral                    = metrics.NewInt("router.total_messages_ephemeral")
+	mTotalMessagesCompressed                   = metrics.NewInt("router.total_messages_compressed")
+	mTotalMessagesExpired                      = metrics.NewInt("router.total_messages_expired")
 	mTotalMessagesRouted                       = metrics.NewInt("router.total_messages_routed")
 	mTotalOverloadedHandleChannel              = metrics.NewInt("router.total_overloaded_handle_channel")
 	mTotalMessagesNotMatchingTopic             = metrics.NewInt("router.total_messages_not_matching_topic")
 	mTotalMessageStoreErrors                   = metrics.NewInt("router.total_errors_message_store")
 	mTotalDeliverMessageErrors                 = metrics.NewInt("router.total_errors_deliver_message")
 	mTotalNotMatchedByFilters                  = metrics.NewInt("router.total_not_matched_by_filters")
+	mTotalMessagesRejected                     = metrics.NewInt("router.total_messages_rejected")
+	mTotalMessagesOutOfSequence                = metrics.NewInt("router.total_messages_out_of_sequence")
+	mDeliveryLatencies                         = metrics.NewHistograms("router.delivery_latencies")
 )
 
 func resetRouterMetrics() {
@@ -43,5 +49,10 @@ func resetRouterMetrics() {
 	mTotalMessageStoreErrors.Set(0)
 	mTotalMessagesIncomingBytes.Set(0)
 	mTotalMessagesStoredBytes.Set(0)
+	mTotalMessagesEphemeral.Set(0)
+	mTotalMessagesCompressed.Set(0)
+	mTotalMessagesExpired.Set(0)
 	mTotalNotMatchedByFilters.Set(0)
+	mTotalMessagesRejected.Set(0)
+	mTotalMessagesOutOfSequence.Set(0)
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/cluster"
 	"github.com/smancke/guble/server/kvstore"
 	"github.com/smancke/guble/server/store"
 	"github.com/smancke/guble/server/store/dummystore"
@@ -244,6 +245,36 @@ func TestRouter_SimpleMessageSending(t *testing.T) {
 	assertChannelContainsMessage(a, r.MessagesChannel(), aTestByteMessage)
 }
 
+func TestRouter_MessageBytesSharedByRoutes(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with two routes for the same topic
+	router, r1 := aRouterRoute(chanSize)
+	r2, _ := router.Subscribe(NewRoute(
+		RouteConfig{
+			RouteParams: RouteParams{"application_id": "appid02", "user_id": "user02"},
+			Path:        protocol.Path("/blah"),
+			ChannelSize: chanSize,
+		},
+	))
+
+	// when i send a message
+	router.HandleMessage(&protocol.Message{Path: r1.Path, Body: aTestByteMessage})
+
+	// then both routes receive the message serialized only once
+	var serialized [][]byte
+	for _, r := range []*Route{r1, r2} {
+		select {
+		case m := <-r.MessagesChannel():
+			serialized = append(serialized, m.Bytes())
+		case <-time.After(time.Millisecond * 50):
+			a.FailNow("No message received")
+		}
+	}
+	a.Equal(string(serialized[0]), string(serialized[1]))
+	a.True(&serialized[0][0] == &serialized[1][0])
+}
+
 func TestRouter_RoutingWithSubTopics(t *testing.T) {
 	ctrl, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -309,6 +340,16 @@ func TestMatchesTopic(t *testing.T) {
 	}
 }
 
+func TestMatchesExactly(t *testing.T) {
+	a := assert.New(t)
+
+	a.True(MatchesExactly("/foo", "/foo"))
+	a.True(MatchesExactly("/foo/xyz", "/foo/*"))
+	a.False(MatchesExactly("/foo/xyz", "/foo"))
+	a.False(MatchesExactly("/foo/xyz/abc", "/foo/*"))
+	a.False(MatchesExactly("/bar", "/foo"))
+}
+
 func TestRoute_IsRemovedIfChannelIsFull(t *testing.T) {
 	ctrl, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -449,6 +490,45 @@ func TestRouter_CleanShutdown(t *testing.T) {
 	<-time.After(100 * time.Millisecond)
 }
 
+func TestRouter_ReplicaRejectsPublishing(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router of a replica node with a route
+	router, r := aRouterRoute(chanSize)
+	router.cluster = &cluster.Cluster{Config: &cluster.Config{ID: 2, Replica: true}}
+
+	// when a message is published on the replica, it is rejected
+	err := router.HandleMessage(&protocol.Message{Path: r.Path, Body: aTestByteMessage})
+	a.Equal(ErrReadOnlyReplica, err)
+	a.Equal(0, len(r.MessagesChannel()))
+
+	// but the messages of the other nodes are delivered
+	err = router.HandleMessage(&protocol.Message{ID: 42, NodeID: 1, Path: r.Path, Body: aTestByteMessage})
+	a.NoError(err)
+	assertChannelContainsMessage(a, r.MessagesChannel(), aTestByteMessage)
+}
+
+func TestRouter_AttachCluster(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a standalone Router
+	router, _, _, _ := aStartedRouter()
+	a.Nil(router.Cluster())
+
+	// when a cluster is attached, it is used by the router
+	cl := &cluster.Cluster{Config: &cluster.Config{ID: 2}}
+	a.NoError(router.AttachCluster(cl))
+	a.Equal(cl, router.Cluster())
+
+	// but it is not replaced by another one
+	a.Equal(ErrClusterAttached, router.AttachCluster(&cluster.Cluster{Config: &cluster.Config{ID: 3}}))
+	a.Equal(cl, router.Cluster())
+
+	// unless detached
+	a.NoError(router.AttachCluster(nil))
+	a.Nil(router.Cluster())
+}
+
 func TestRouter_Check(t *testing.T) {
 	ctrl, finish := testutil.NewMockCtrl(t)
 	defer finish()
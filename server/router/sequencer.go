@@ -0,0 +1,82 @@
+package router
+
+import (
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// sequencer serializes the handling of the messages of each partition, so that the messages of
+// concurrent publishers (REST, websocket and other cluster nodes) are given their IDs, stored
+// and delivered in the same order. The partitions are handled independently from each other.
+// The sequence of a partition is removed when it is drained (no message of the partition is handled or waiting),
+// so that the last ID is only compared within a burst of messages of the partition.
+// The zero value is ready to use.
+type sequencer struct {
+	mutex      sync.Mutex
+	partitions map[string]*sequence
+}
+
+// sequence is the state of the messages of a single partition.
+type sequence struct {
+	sync.Mutex
+	lastID uint64
+
+	// handlers is the number of handlings of the partition running or waiting, guarded by the mutex of the sequencer
+	handlers int
+}
+
+// sequence runs fn exclusively for the partition.
+// fn is given the ID of the last message of the partition, and returns the ID of the handled message.
+// The IDs which are not strictly increasing are counted and logged, but not rejected:
+// they can be given to messages received from other cluster nodes, e.g. with a skewed clock.
+func (s *sequencer) sequence(partition string, fn func(lastID uint64) (uint64, error)) error {
+	seq := s.acquire(partition)
+	defer s.release(partition, seq)
+	seq.Lock()
+	defer seq.Unlock()
+
+	id, err := fn(seq.lastID)
+	if err != nil {
+		return err
+	}
+	if id <= seq.lastID {
+		mTotalMessagesOutOfSequence.Add(1)
+		logger.WithFields(log.Fields{
+			"partition": partition,
+			"id":        id,
+			"lastID":    seq.lastID,
+		}).Warn("Message ID is not increasing")
+		return nil
+	}
+	seq.lastID = id
+	return nil
+}
+
+// acquire returns the sequence of the partition, creating it if needed, and counts the handling using it.
+func (s *sequencer) acquire(partition string) *sequence {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.partitions == nil {
+		s.partitions = make(map[string]*sequence)
+	}
+	seq, ok := s.partitions[partition]
+	if !ok {
+		seq = &sequence{}
+		s.partitions[partition] = seq
+	}
+	seq.handlers++
+	return seq
+}
+
+// release ends a handling using the sequence, and removes the sequence when it is drained.
+func (s *sequencer) release(partition string, seq *sequence) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	seq.handlers--
+	if seq.handlers == 0 {
+		delete(s.partitions, partition)
+	}
+}
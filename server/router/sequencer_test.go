@@ -0,0 +1,113 @@
+package router
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+)
+
+func TestSequencer_KeepsLastIDPerPartition(t *testing.T) {
+	a := assert.New(t)
+
+	var s sequencer
+	handle := func(id uint64) func(uint64) (uint64, error) {
+		return func(uint64) (uint64, error) {
+			return id, nil
+		}
+	}
+
+	// the sequences are kept while handlings of their partitions are waiting
+	foo := s.acquire("foo")
+	bar := s.acquire("bar")
+
+	a.NoError(s.sequence("foo", handle(2)))
+	a.NoError(s.sequence("foo", handle(3)))
+	a.NoError(s.sequence("bar", handle(1)))
+
+	// a lower ID is handled, but does not become the last one
+	a.NoError(s.sequence("foo", handle(1)))
+	a.NoError(s.sequence("foo", func(lastID uint64) (uint64, error) {
+		a.Equal(uint64(3), lastID)
+		return 4, nil
+	}))
+
+	// a failed handling does not change the last ID
+	a.Error(s.sequence("foo", func(uint64) (uint64, error) {
+		return 0, errors.New("store failed")
+	}))
+	a.NoError(s.sequence("foo", func(lastID uint64) (uint64, error) {
+		a.Equal(uint64(4), lastID)
+		return 5, nil
+	}))
+
+	// the drained sequences are removed
+	s.release("foo", foo)
+	s.release("bar", bar)
+	a.Equal(0, len(s.partitions))
+	a.NoError(s.sequence("foo", func(lastID uint64) (uint64, error) {
+		a.Equal(uint64(0), lastID)
+		return 6, nil
+	}))
+	a.Equal(0, len(s.partitions))
+}
+
+func TestRouter_ConcurrentPublishersAreSequenced(t *testing.T) {
+	a := assert.New(t)
+
+	publishers, messagesPerPublisher := 8, 50
+	total := publishers * messagesPerPublisher
+
+	router, _, ms, _ := aStartedRouter()
+	defer router.Stop()
+	router.messageStore = &slowMessageStore{ms}
+	r, _ := router.Subscribe(NewRoute(
+		RouteConfig{
+			RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+			Path:        protocol.Path("/blah"),
+			ChannelSize: total,
+		},
+	))
+
+	var wg sync.WaitGroup
+	wg.Add(publishers)
+	for i := 0; i < publishers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < messagesPerPublisher; j++ {
+				a.NoError(router.HandleMessage(&protocol.Message{Path: "/blah/sub", Body: aTestByteMessage}))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the messages are delivered in the order of their IDs, without gaps
+	lastID := uint64(0)
+	for i := 0; i < total; i++ {
+		select {
+		case m := <-r.MessagesChannel():
+			if !a.Equal(lastID+1, m.ID) {
+				return
+			}
+			lastID = m.ID
+		case <-time.After(time.Second):
+			a.FailNow("Not all messages received", "received %d of %d", i, total)
+		}
+	}
+}
+
+// slowMessageStore takes some time after giving the ID to a message, as a store writing to disk does.
+type slowMessageStore struct {
+	store.MessageStore
+}
+
+func (s *slowMessageStore) StoreMessage(m *protocol.Message, nodeID uint8) (int, error) {
+	size, err := s.MessageStore.StoreMessage(m, nodeID)
+	time.Sleep(time.Duration(m.ID%3) * 100 * time.Microsecond)
+	return size, err
+}
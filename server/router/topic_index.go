@@ -0,0 +1,105 @@
+package router
+
+import (
+	"strings"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// wildcardLevel is the level of a route path matching any level of the message topics (e.g. /users/*/orders).
+const wildcardLevel = "*"
+
+// topicIndex is a trie of the route paths, by their levels, so that the paths of the routes matching a message
+// are found in a time depending on the depth of its topic, instead of on the number of subscribed paths.
+// It is only used by the routing goroutine, so it is not synchronized.
+type topicIndex struct {
+	root *topicNode
+}
+
+type topicNode struct {
+	children map[string]*topicNode
+
+	// path is the route path ending at this node, if routed
+	path   protocol.Path
+	routed bool
+}
+
+func newTopicIndex() *topicIndex {
+	return &topicIndex{root: newTopicNode()}
+}
+
+func newTopicNode() *topicNode {
+	return &topicNode{children: make(map[string]*topicNode)}
+}
+
+// add indexes a route path.
+func (idx *topicIndex) add(path protocol.Path) {
+	node := idx.root
+	for _, level := range levels(path) {
+		child, ok := node.children[level]
+		if !ok {
+			child = newTopicNode()
+			node.children[level] = child
+		}
+		node = child
+	}
+	node.path = path
+	node.routed = true
+}
+
+// remove removes a route path from the index, with its nodes which do not lead to other paths anymore.
+func (idx *topicIndex) remove(path protocol.Path) {
+	pathLevels := levels(path)
+	nodes := make([]*topicNode, 0, len(pathLevels)+1)
+	node := idx.root
+	nodes = append(nodes, node)
+	for _, level := range pathLevels {
+		child, ok := node.children[level]
+		if !ok {
+			return
+		}
+		node = child
+		nodes = append(nodes, node)
+	}
+	node.routed = false
+	node.path = ""
+
+	for i := len(pathLevels); i > 0; i-- {
+		if nodes[i].routed || len(nodes[i].children) > 0 {
+			return
+		}
+		delete(nodes[i-1].children, pathLevels[i-1])
+	}
+}
+
+// match returns the route paths matching a message topic: the paths of the topic and of its parent topics,
+// where any level can be a wildcard.
+func (idx *topicIndex) match(topic protocol.Path) []protocol.Path {
+	var paths []protocol.Path
+	idx.root.match(levels(topic), &paths)
+	return paths
+}
+
+func (node *topicNode) match(topicLevels []string, paths *[]protocol.Path) {
+	if node.routed {
+		*paths = append(*paths, node.path)
+	}
+	if len(topicLevels) == 0 {
+		return
+	}
+	if child, ok := node.children[topicLevels[0]]; ok {
+		child.match(topicLevels[1:], paths)
+	}
+	if topicLevels[0] == wildcardLevel {
+		return
+	}
+	if child, ok := node.children[wildcardLevel]; ok {
+		child.match(topicLevels[1:], paths)
+	}
+}
+
+// levels splits a path into its levels, keeping the empty ones, so that a path matches the paths it starts with
+// only at a level boundary (e.g. /foo matches /foo/bar, but not /foobar).
+func levels(path protocol.Path) []string {
+	return strings.Split(string(path), "/")
+}
@@ -0,0 +1,89 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicIndex_Match(t *testing.T) {
+	a := assert.New(t)
+
+	paths := []protocol.Path{"/foo", "/foo/bar", "/foo/bar/baz", "/users/*/orders", "/users/*", "/*/news", "/other"}
+	idx := newTopicIndex()
+	for _, path := range paths {
+		idx.add(path)
+	}
+
+	for _, topic := range []protocol.Path{
+		"/foo", "/foo/bar", "/foo/bar/baz/qux", "/foobar", "/users", "/users/bob", "/users/bob/orders/1",
+		"/sports/news", "/other/news", "/", "/*", "/users/*/orders",
+	} {
+		// the index matches the same paths as the full scan
+		var expected []string
+		for _, path := range paths {
+			if matchesTopic(topic, path) {
+				expected = append(expected, string(path))
+			}
+		}
+		var matched []string
+		for _, path := range idx.match(topic) {
+			matched = append(matched, string(path))
+		}
+		sort.Strings(expected)
+		sort.Strings(matched)
+		a.Equal(expected, matched, "topic %s", topic)
+	}
+
+	a.Len(idx.match("/users/bob/orders"), 2)
+	a.Len(idx.match("/sports/news"), 1)
+}
+
+func TestTopicIndex_Remove(t *testing.T) {
+	a := assert.New(t)
+
+	idx := newTopicIndex()
+	idx.add("/foo")
+	idx.add("/foo/bar/baz")
+
+	idx.remove("/foo/bar")
+	a.Equal([]protocol.Path{"/foo", "/foo/bar/baz"}, idx.match("/foo/bar/baz"))
+
+	idx.remove("/foo/bar/baz")
+	a.Equal([]protocol.Path{"/foo"}, idx.match("/foo/bar/baz"))
+	a.Len(idx.root.children[""].children["foo"].children, 0)
+
+	idx.remove("/foo")
+	a.Empty(idx.match("/foo"))
+	a.Len(idx.root.children, 0)
+}
+
+func TestRouter_WildcardRoutes(t *testing.T) {
+	a := assert.New(t)
+
+	router, _, _, _ := aStartedRouter()
+	r, err := router.Subscribe(NewRoute(RouteConfig{Path: "/users/*/orders", ChannelSize: chanSize}))
+	a.NoError(err)
+
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/users/bob/orders/42", Body: aTestByteMessage}))
+	assertChannelContainsMessage(a, r.MessagesChannel(), aTestByteMessage)
+
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/users/bob/profile", Body: aTestByteMessage}))
+	time.Sleep(10 * time.Millisecond)
+	a.Equal(0, len(r.MessagesChannel()))
+}
+
+func BenchmarkTopicIndex_Match(b *testing.B) {
+	idx := newTopicIndex()
+	for i := 0; i < 100000; i++ {
+		idx.add(protocol.Path(fmt.Sprintf("/users/%d/devices/%d", i%1000, i)))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.match(protocol.Path(fmt.Sprintf("/users/%d/devices/%d", i%1000, i%100000)))
+	}
+}
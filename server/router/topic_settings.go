@@ -0,0 +1,73 @@
+package router
+
+import (
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+)
+
+// TopicSettings gives the settings of the topics which are applied by the router,
+// e.g. from a configuration of each topic.
+type TopicSettings interface {
+	// Ephemeral returns true if the messages of the topic are not stored.
+	Ephemeral(topic protocol.Path) bool
+
+	// AccessPath returns the topic whose permissions apply to the topic (the topic itself, by default).
+	AccessPath(topic protocol.Path) protocol.Path
+
+	// TTL returns the age from which the stored messages of the topic are not delivered anymore (0: no limit).
+	TTL(topic protocol.Path) time.Duration
+}
+
+// TopicConfigurable is implemented by the routers which apply the settings of the topics.
+type TopicConfigurable interface {
+	// SetTopicSettings sets the settings of the topics, replacing the previous ones.
+	SetTopicSettings(s TopicSettings)
+}
+
+// SetTopicSettings is a part of the TopicConfigurable implementation.
+func (router *router) SetTopicSettings(s TopicSettings) {
+	router.Lock()
+	defer router.Unlock()
+	router.settings = s
+}
+
+func (router *router) topicSettings() TopicSettings {
+	router.RLock()
+	defer router.RUnlock()
+	return router.settings
+}
+
+// isAllowed checks the permission of a user for a topic, or for the topic whose permissions apply to it.
+func (router *router) isAllowed(accessType auth.AccessType, userID string, path protocol.Path) bool {
+	if s := router.topicSettings(); s != nil {
+		path = s.AccessPath(path)
+	}
+	return router.accessManager.IsAllowed(accessType, userID, path)
+}
+
+// topicAccessManager checks the permissions as the router does, so that the other modules apply the
+// permissions of the topics in the same way.
+type topicAccessManager struct {
+	router *router
+}
+
+// IsAllowed is a part of the auth.AccessManager implementation.
+func (am topicAccessManager) IsAllowed(accessType auth.AccessType, userID string, path protocol.Path) bool {
+	return am.router.isAllowed(accessType, userID, path)
+}
+
+// IsExpired returns true if a stored message is older than the TTL of its topic, given by the settings of the router.
+func IsExpired(r Router, m *protocol.Message) bool {
+	rt, ok := r.(*router)
+	if !ok {
+		return false
+	}
+	s := rt.topicSettings()
+	if s == nil {
+		return false
+	}
+	ttl := s.TTL(m.Path)
+	return ttl > 0 && time.Since(time.Unix(m.Time, 0)) > ttl
+}
@@ -0,0 +1,27 @@
+package router
+
+// userIDParam is the route param of the user owning a route.
+const userIDParam = "user_id"
+
+// userRoutes returns the routes receiving the messages published to a user: the routes owned by the user,
+// once for the routes having the same params (e.g. the routes of a websocket, or of a device, for several topics),
+// and one shared route of each connector, which delivers the message to the subscriptions of the user.
+// It is only called by the routing goroutine.
+func (router *router) userRoutes(userID string) []*Route {
+	var routes []*Route
+	seen := make(map[string]bool)
+	for _, pathRoutes := range router.routes {
+		for _, route := range pathRoutes {
+			if !route.Shared && route.Get(userIDParam) != userID {
+				continue
+			}
+			key := route.RouteParams.Key()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
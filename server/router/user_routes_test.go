@@ -0,0 +1,53 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+)
+
+func TestRouter_PublishToUser(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with the routes of a websocket of user01 on two topics, and a route of another user
+	router, r := aRouterRoute(chanSize)
+	other, err := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+		Path:        "/other",
+		ChannelSize: chanSize,
+		Exact:       true,
+	}))
+	a.NoError(err)
+	notOwned, err := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: RouteParams{"application_id": "appid02", "user_id": "user02"},
+		Path:        r.Path,
+		ChannelSize: chanSize,
+	}))
+	a.NoError(err)
+
+	// when a message is published to user01
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "user:user01", Body: aTestByteMessage}))
+
+	// then it is delivered once to the websocket, in the topic of the user
+	var received []*protocol.Message
+	timeout := time.After(50 * time.Millisecond)
+COLLECT:
+	for {
+		select {
+		case m := <-r.MessagesChannel():
+			received = append(received, m)
+		case m := <-other.MessagesChannel():
+			received = append(received, m)
+		case <-timeout:
+			break COLLECT
+		}
+	}
+	if a.Len(received, 1) {
+		a.Equal(protocol.UserPath("user01"), received[0].Path)
+		a.Equal(string(aTestByteMessage), string(received[0].Body))
+	}
+	a.Equal(0, len(notOwned.MessagesChannel()))
+}
@@ -0,0 +1,7 @@
+package schema
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "schema")
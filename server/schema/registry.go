@@ -0,0 +1,267 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+)
+
+// kvSchema is the KVStore schema holding the JSON Schemas, keyed by topic path.
+const kvSchema = "topic_schemas"
+
+// Registry validates the bodies of the published messages against the JSON Schemas registered for their topics.
+// A schema registered for a topic applies to its subtopics too, unless they have a schema of their own.
+// The schemas are managed by an admin endpoint:
+// `GET <prefix>` lists the topics having a schema,
+// `GET <prefix><topic>` returns the schema of the topic, `PUT` (or `POST`) registers it, and `DELETE` removes it.
+type Registry struct {
+	router  router.Router
+	kvstore kvstore.KVStore
+	prefix  string
+
+	mu      sync.RWMutex
+	schemas map[protocol.Path]*Schema
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRegistry returns a new Registry, validating the messages handled by the router.
+func NewRegistry(router router.Router, prefix string) (*Registry, error) {
+	kvs, err := router.KVStore()
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{
+		router:  router,
+		kvstore: kvs,
+		prefix:  prefix,
+		schemas: make(map[protocol.Path]*Schema),
+	}, nil
+}
+
+// Start loads the registered schemas, and begins validating the messages.
+func (r *Registry) Start() error {
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	for entry := range r.kvstore.Iterate(r.ctx, kvSchema, "", 0) {
+		s, err := Parse([]byte(entry[1]))
+		if err != nil {
+			logger.WithField("topic", entry[0]).WithField("error", err.Error()).Error("Ignoring invalid stored schema")
+			continue
+		}
+		r.set(protocol.Path(entry[0]), s)
+	}
+
+	if w, ok := r.kvstore.(kvstore.Watcher); ok {
+		r.wg.Add(1)
+		go r.watch(w)
+	}
+
+	if i, ok := r.router.(router.Interceptable); ok {
+		i.AddInterceptor("schema", r)
+	} else {
+		logger.Error("The router does not accept interceptors, the messages will not be validated")
+	}
+	logger.WithField("schemas", len(r.schemas)).Info("Started schema registry")
+	return nil
+}
+
+// Stop stops watching the schema changes.
+func (r *Registry) Stop() error {
+	r.cancel()
+	r.wg.Wait()
+	logger.Info("Stopped schema registry")
+	return nil
+}
+
+// Intercept rejects the messages whose body does not match the schema of their topic.
+// It is a part of the router.Interceptor implementation.
+func (r *Registry) Intercept(m *protocol.Message) error {
+	topic, s := r.lookup(m.Path)
+	if s == nil {
+		return nil
+	}
+	mTotalValidated.Add(1)
+	if err := s.Validate(m.Body); err != nil {
+		mTotalRejected.Add(1)
+		return fmt.Errorf("The body does not match the schema of %s: %s", topic, err.Error())
+	}
+	return nil
+}
+
+// ValidateWith validates a body against the schema registered for the given topic (or for its nearest parent
+// having one), e.g. the schema referenced by the configuration of another topic.
+func (r *Registry) ValidateWith(topic protocol.Path, body []byte) error {
+	schemaTopic, s := r.lookup(topic)
+	if s == nil {
+		return fmt.Errorf("No schema is registered for %s", topic)
+	}
+	mTotalValidated.Add(1)
+	if err := s.Validate(body); err != nil {
+		mTotalRejected.Add(1)
+		return fmt.Errorf("The body does not match the schema of %s: %s", schemaTopic, err.Error())
+	}
+	return nil
+}
+
+// watch applies the schema changes done by other guble nodes, until the registry is stopped.
+func (r *Registry) watch(w kvstore.Watcher) {
+	defer r.wg.Done()
+
+	changesC, err := w.Watch(r.ctx, kvSchema)
+	if err != nil {
+		logger.WithField("error", err.Error()).Info("Not watching the schema changes")
+		return
+	}
+	for change := range changesC {
+		topic := protocol.Path(change.Key)
+		if change.Deleted {
+			r.set(topic, nil)
+			continue
+		}
+		data, exist, err := r.kvstore.Get(kvSchema, change.Key)
+		if err != nil || !exist {
+			continue
+		}
+		s, err := Parse(data)
+		if err != nil {
+			logger.WithField("topic", topic).WithField("error", err.Error()).Error("Ignoring invalid schema change")
+			continue
+		}
+		r.set(topic, s)
+	}
+}
+
+// lookup returns the schema of the topic, or of its nearest parent having one.
+func (r *Registry) lookup(path protocol.Path) (protocol.Path, *Schema) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.schemas) == 0 {
+		return "", nil
+	}
+	topic := strings.TrimSuffix(string(path), "/")
+	for topic != "" {
+		if s, ok := r.schemas[protocol.Path(topic)]; ok {
+			return protocol.Path(topic), s
+		}
+		topic = topic[:strings.LastIndex(topic, "/")]
+	}
+	return "", nil
+}
+
+// set caches the schema of a topic, or removes it if nil.
+func (r *Registry) set(topic protocol.Path, s *Schema) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s == nil {
+		delete(r.schemas, topic)
+	} else {
+		r.schemas[topic] = s
+	}
+	mTotalSchemas.Set(int64(len(r.schemas)))
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (r *Registry) GetPrefix() string {
+	return r.prefix
+}
+
+// ServeHTTP manages the schemas of the topics.
+// It is a part of the service.endpoint implementation.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	topic := strings.Trim(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(r.prefix, "/")), "/")
+	if topic == "" {
+		if req.Method != http.MethodGet {
+			http.Error(w, `{"error": "Missing topic."}`, http.StatusBadRequest)
+			return
+		}
+		r.listTopics(w)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		r.getSchema(w, "/"+topic)
+	case http.MethodPut, http.MethodPost:
+		r.putSchema(w, req, "/"+topic)
+	case http.MethodDelete:
+		r.deleteSchema(w, "/"+topic)
+	default:
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET, PUT, POST and DELETE are accepted."}`, http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) listTopics(w http.ResponseWriter) {
+	r.mu.RLock()
+	topics := make([]string, 0, len(r.schemas))
+	for topic := range r.schemas {
+		topics = append(topics, string(topic))
+	}
+	r.mu.RUnlock()
+
+	sort.Strings(topics)
+	json.NewEncoder(w).Encode(topics)
+}
+
+func (r *Registry) getSchema(w http.ResponseWriter, topic string) {
+	data, exist, err := r.kvstore.Get(kvSchema, topic)
+	if err != nil {
+		logger.WithField("error", err.Error()).WithField("topic", topic).Error("Error loading the schema")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	if !exist {
+		http.Error(w, `{"error": "Schema not found."}`, http.StatusNotFound)
+		return
+	}
+	w.Write(data)
+}
+
+func (r *Registry) putSchema(w http.ResponseWriter, req *http.Request, topic string) {
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	s, err := Parse(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.kvstore.Put(kvSchema, topic, data); err != nil {
+		logger.WithField("error", err.Error()).WithField("topic", topic).Error("Error storing the schema")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	r.set(protocol.Path(topic), s)
+	logger.WithField("topic", topic).Info("Registered schema")
+	w.Write(data)
+}
+
+func (r *Registry) deleteSchema(w http.ResponseWriter, topic string) {
+	if err := r.kvstore.Delete(kvSchema, topic); err != nil {
+		logger.WithField("error", err.Error()).WithField("topic", topic).Error("Error deleting the schema")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	r.set(protocol.Path(topic), nil)
+	logger.WithField("topic", topic).Info("Removed schema")
+	fmt.Fprintf(w, `{"deleted": %q}`, topic)
+}
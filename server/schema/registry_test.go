@@ -0,0 +1,69 @@
+package schema
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/dummystore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_ManagesSchemasAndRejectsInvalidMessages(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	a.NoError(kvs.Put(kvSchema, "/stored", []byte(`{"type": "string"}`)))
+	r := router.New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil)
+	a.NoError(r.(service.Startable).Start())
+	defer r.(service.Stopable).Stop()
+
+	registry, err := NewRegistry(r, "/admin/schemas/")
+	a.NoError(err)
+	a.NoError(registry.Start())
+	defer registry.Stop()
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		registry.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodPut, "/admin/schemas/users", `{"type": "object", "required": ["name"]}`)
+	a.Equal(http.StatusOK, w.Code)
+
+	w = serve(http.MethodPut, "/admin/schemas/users", `{"type": "object", "pattern": "("}`)
+	a.Equal(http.StatusBadRequest, w.Code)
+
+	w = serve(http.MethodGet, "/admin/schemas/", "")
+	a.JSONEq(`["/stored", "/users"]`, w.Body.String())
+
+	w = serve(http.MethodGet, "/admin/schemas/users", "")
+	a.JSONEq(`{"type": "object", "required": ["name"]}`, w.Body.String())
+
+	// the schema of a topic applies to its subtopics
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/users/marvin", Body: []byte(`{"name": "Marvin"}`)}))
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/others", Body: []byte(`no json`)}))
+	err = r.HandleMessage(&protocol.Message{Path: "/users/marvin", Body: []byte(`{"planet": "Earth"}`)})
+	a.Equal(&router.MessageRejectedError{
+		Interceptor: "schema",
+		Reason:      `The body does not match the schema of /users: $: misses the required property "name"`,
+	}, err)
+	a.Error(r.HandleMessage(&protocol.Message{Path: "/stored", Body: []byte(`42`)}))
+
+	w = serve(http.MethodDelete, "/admin/schemas/users", "")
+	a.JSONEq(`{"deleted": "/users"}`, w.Body.String())
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/users/marvin", Body: []byte(`{"planet": "Earth"}`)}))
+
+	w = serve(http.MethodGet, "/admin/schemas/users", "")
+	a.Equal(http.StatusNotFound, w.Code)
+	w = serve(http.MethodDelete, "/admin/schemas/", "")
+	a.Equal(http.StatusBadRequest, w.Code)
+}
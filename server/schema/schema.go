@@ -0,0 +1,365 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var errRefNotSupported = errors.New("The keyword $ref is not supported.")
+
+// Schema is a compiled JSON Schema, supporting the validation keywords:
+// type, enum, const, properties, required, additionalProperties, items, minItems, maxItems,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf, minLength, maxLength, pattern,
+// allOf, anyOf, oneOf and not. The other keywords (e.g. title, description, format) are ignored.
+type Schema struct {
+	types                []string
+	enum                 []interface{}
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	noAdditional         bool
+	items                *Schema
+	minItems, maxItems   *int
+	minimum, maximum     *float64
+	exclusiveMin         *float64
+	exclusiveMax         *float64
+	multipleOf           *float64
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	allOf, anyOf, oneOf  []*Schema
+	not                  *Schema
+}
+
+// rawSchema is the JSON representation of a Schema.
+type rawSchema struct {
+	Ref                  *string                    `json:"$ref"`
+	Type                 json.RawMessage            `json:"type"`
+	Enum                 []interface{}              `json:"enum"`
+	Const                *json.RawMessage           `json:"const"`
+	Properties           map[string]json.RawMessage `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	Items                json.RawMessage            `json:"items"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+	Minimum              *float64                   `json:"minimum"`
+	Maximum              *float64                   `json:"maximum"`
+	ExclusiveMinimum     *float64                   `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64                   `json:"exclusiveMaximum"`
+	MultipleOf           *float64                   `json:"multipleOf"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	Pattern              *string                    `json:"pattern"`
+	AllOf                []json.RawMessage          `json:"allOf"`
+	AnyOf                []json.RawMessage          `json:"anyOf"`
+	OneOf                []json.RawMessage          `json:"oneOf"`
+	Not                  json.RawMessage            `json:"not"`
+}
+
+// ValidationError describes the first violation of a schema by a JSON document.
+type ValidationError struct {
+	// Path locates the invalid value in the document, e.g. `$.items[2].name`
+	Path string
+
+	// Message describes the violation
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Parse compiles a JSON Schema.
+func Parse(data []byte) (*Schema, error) {
+	var raw rawSchema
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("Invalid JSON Schema: %s", err.Error())
+	}
+	if raw.Ref != nil {
+		return nil, errRefNotSupported
+	}
+
+	s := &Schema{
+		enum:         raw.Enum,
+		required:     raw.Required,
+		minItems:     raw.MinItems,
+		maxItems:     raw.MaxItems,
+		minimum:      raw.Minimum,
+		maximum:      raw.Maximum,
+		exclusiveMin: raw.ExclusiveMinimum,
+		exclusiveMax: raw.ExclusiveMaximum,
+		multipleOf:   raw.MultipleOf,
+		minLength:    raw.MinLength,
+		maxLength:    raw.MaxLength,
+	}
+
+	if len(raw.Type) > 0 {
+		if err := json.Unmarshal(raw.Type, &s.types); err != nil {
+			var single string
+			if err := json.Unmarshal(raw.Type, &single); err != nil {
+				return nil, errors.New("The type has to be a string or an array of strings.")
+			}
+			s.types = []string{single}
+		}
+	}
+	if raw.Const != nil {
+		var value interface{}
+		if err := json.Unmarshal(*raw.Const, &value); err != nil {
+			return nil, err
+		}
+		s.enum = []interface{}{value}
+	}
+	if raw.Pattern != nil {
+		pattern, err := regexp.Compile(*raw.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q: %s", *raw.Pattern, err.Error())
+		}
+		s.pattern = pattern
+	}
+	if raw.MultipleOf != nil && *raw.MultipleOf <= 0 {
+		return nil, errors.New("The multipleOf has to be greater than 0.")
+	}
+
+	if len(raw.Properties) > 0 {
+		s.properties = make(map[string]*Schema, len(raw.Properties))
+		for name, data := range raw.Properties {
+			property, err := Parse(data)
+			if err != nil {
+				return nil, err
+			}
+			s.properties[name] = property
+		}
+	}
+	switch string(bytes.TrimSpace(raw.AdditionalProperties)) {
+	case "", "true":
+	case "false":
+		s.noAdditional = true
+	default:
+		additional, err := Parse(raw.AdditionalProperties)
+		if err != nil {
+			return nil, err
+		}
+		s.additionalProperties = additional
+	}
+
+	var err error
+	if s.items, err = parseOptional(raw.Items); err != nil {
+		return nil, err
+	}
+	if s.not, err = parseOptional(raw.Not); err != nil {
+		return nil, err
+	}
+	if s.allOf, err = parseAll(raw.AllOf); err != nil {
+		return nil, err
+	}
+	if s.anyOf, err = parseAll(raw.AnyOf); err != nil {
+		return nil, err
+	}
+	if s.oneOf, err = parseAll(raw.OneOf); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseOptional(data json.RawMessage) (*Schema, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return Parse(data)
+}
+
+func parseAll(data []json.RawMessage) ([]*Schema, error) {
+	schemas := make([]*Schema, 0, len(data))
+	for _, d := range data {
+		s, err := Parse(d)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, nil
+}
+
+// Validate checks a JSON document against the schema.
+// It returns a *ValidationError for the first violation, or an error if the document is no valid JSON.
+func (s *Schema) Validate(document []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(document, &value); err != nil {
+		return fmt.Errorf("The body is no valid JSON: %s", err.Error())
+	}
+	if err := s.validate("$", value); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Schema) validate(path string, value interface{}) *ValidationError {
+	invalid := func(format string, args ...interface{}) *ValidationError {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if len(s.types) > 0 && !s.matchesType(value) {
+		return invalid("has to be of type %s, but is %s", strings.Join(s.types, " or "), typeOf(value))
+	}
+	if len(s.enum) > 0 && !contains(s.enum, value) {
+		return invalid("has to be one of the allowed values")
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if err := s.validateObject(path, v); err != nil {
+			return err
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			return invalid("has to contain at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			return invalid("has to contain at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				if err := s.items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			return invalid("has to be >= %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			return invalid("has to be <= %v", *s.maximum)
+		}
+		if s.exclusiveMin != nil && v <= *s.exclusiveMin {
+			return invalid("has to be > %v", *s.exclusiveMin)
+		}
+		if s.exclusiveMax != nil && v >= *s.exclusiveMax {
+			return invalid("has to be < %v", *s.exclusiveMax)
+		}
+		if s.multipleOf != nil {
+			if q := v / *s.multipleOf; q != math.Trunc(q) {
+				return invalid("has to be a multiple of %v", *s.multipleOf)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength != nil && length < *s.minLength {
+			return invalid("has to be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			return invalid("has to be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return invalid("has to match the pattern %q", s.pattern.String())
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(path, value); err != nil {
+			return err
+		}
+	}
+	if len(s.anyOf) > 0 && s.countValid(s.anyOf, path, value) == 0 {
+		return invalid("has to match at least one of the anyOf schemas")
+	}
+	if len(s.oneOf) > 0 && s.countValid(s.oneOf, path, value) != 1 {
+		return invalid("has to match exactly one of the oneOf schemas")
+	}
+	if s.not != nil && s.not.validate(path, value) == nil {
+		return invalid("must not match the schema of not")
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(path string, object map[string]interface{}) *ValidationError {
+	for _, name := range s.required {
+		if _, ok := object[name]; !ok {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("misses the required property %q", name)}
+		}
+	}
+
+	// the properties are validated in a stable order, so that the same error is reported for the same document
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		propertyPath := path + "." + name
+		if property, ok := s.properties[name]; ok {
+			if err := property.validate(propertyPath, object[name]); err != nil {
+				return err
+			}
+			continue
+		}
+		if s.noAdditional {
+			return &ValidationError{Path: propertyPath, Message: "is not an allowed property"}
+		}
+		if s.additionalProperties != nil {
+			if err := s.additionalProperties.validate(propertyPath, object[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) countValid(schemas []*Schema, path string, value interface{}) int {
+	valid := 0
+	for _, sub := range schemas {
+		if sub.validate(path, value) == nil {
+			valid++
+		}
+	}
+	return valid
+}
+
+func (s *Schema) matchesType(value interface{}) bool {
+	actual := typeOf(value)
+	for _, t := range s.types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type of a decoded JSON value.
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func contains(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,12 @@
+package schema
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns              = metrics.NS("schema")
+	mTotalValidated = ns.NewInt("total_validated_messages")
+	mTotalRejected  = ns.NewInt("total_rejected_messages")
+	mTotalSchemas   = ns.NewInt("current_schemas")
+)
@@ -0,0 +1,86 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const userSchema = `{
+	"type": "object",
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "maxLength": 5},
+		"age": {"type": "integer", "minimum": 0},
+		"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}},
+		"score": {"type": ["number", "null"], "exclusiveMaximum": 10, "multipleOf": 0.5},
+		"contact": {"anyOf": [{"required": ["phone"]}, {"required": ["mail"]}]},
+		"id": {"oneOf": [{"type": "integer"}, {"type": "string"}], "not": {"const": "root"}}
+	}
+}`
+
+func TestSchema_Validate(t *testing.T) {
+	a := assert.New(t)
+
+	s, err := Parse([]byte(userSchema))
+	a.NoError(err)
+
+	for _, valid := range []string{
+		`{"name": "Zaph", "age": 42}`,
+		`{"name": "Zaph", "age": 0, "email": "zaph@hog", "role": "admin", "tags": ["a", "b"]}`,
+		`{"name": "Zaph", "age": 1, "score": 9.5, "contact": {"mail": "zaph@hog"}, "id": "z1"}`,
+		`{"name": "Zaph", "age": 1, "score": null, "id": 7}`,
+	} {
+		a.NoError(s.Validate([]byte(valid)), valid)
+	}
+
+	for document, expected := range map[string]string{
+		`{"name": "Zaph"}`:                                    `$: misses the required property "age"`,
+		`{"name": "Zaph", "age": -1}`:                         `$.age: has to be >= 0`,
+		`{"name": "Zaph", "age": 1.5}`:                        `$.age: has to be of type integer, but is number`,
+		`{"name": "", "age": 1}`:                              `$.name: has to be at least 1 characters long`,
+		`{"name": "Zaphod", "age": 1}`:                        `$.name: has to be at most 5 characters long`,
+		`{"name": "Zaph", "age": 1, "email": "zaph"}`:         `$.email: has to match the pattern "^[^@]+@[^@]+$"`,
+		`{"name": "Zaph", "age": 1, "role": "guest"}`:         `$.role: has to be one of the allowed values`,
+		`{"name": "Zaph", "age": 1, "tags": ["a", 2]}`:        `$.tags[1]: has to be of type string, but is integer`,
+		`{"name": "Zaph", "age": 1, "tags": ["a", "b", "c"]}`: `$.tags: has to contain at most 2 items`,
+		`{"name": "Zaph", "age": 1, "score": 10}`:             `$.score: has to be < 10`,
+		`{"name": "Zaph", "age": 1, "score": 0.3}`:            `$.score: has to be a multiple of 0.5`,
+		`{"name": "Zaph", "age": 1, "contact": {}}`:           `$.contact: has to match at least one of the anyOf schemas`,
+		`{"name": "Zaph", "age": 1, "id": true}`:              `$.id: has to match exactly one of the oneOf schemas`,
+		`{"name": "Zaph", "age": 1, "id": "root"}`:            `$.id: must not match the schema of not`,
+		`{"name": "Zaph", "age": 1, "planet": "Betelgeuse"}`:  `$.planet: is not an allowed property`,
+		`["Zaph"]`: `$: has to be of type object, but is array`,
+	} {
+		err := s.Validate([]byte(document))
+		if a.Error(err, document) {
+			a.IsType(&ValidationError{}, err)
+			a.Equal(expected, err.Error(), document)
+		}
+	}
+
+	a.Error(s.Validate([]byte("no json")))
+}
+
+func TestParse_InvalidSchemas(t *testing.T) {
+	a := assert.New(t)
+
+	for _, invalid := range []string{
+		`no json`,
+		`{"type": 42}`,
+		`{"pattern": "("}`,
+		`{"multipleOf": 0}`,
+		`{"properties": {"a": {"$ref": "#/definitions/a"}}}`,
+		`{"items": {"minLength": "one"}}`,
+	} {
+		_, err := Parse([]byte(invalid))
+		a.Error(err, invalid)
+	}
+
+	s, err := Parse([]byte(`{"title": "anything", "format": "date-time"}`))
+	a.NoError(err)
+	a.NoError(s.Validate([]byte(`"any value"`)))
+}
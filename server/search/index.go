@@ -0,0 +1,133 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// Hit is a message matching a search.
+type Hit struct {
+	Partition string
+	ID        uint64
+}
+
+// Index is a full-text index of the stored messages.
+// The in-memory index is the default; an index backed by a search engine (e.g. bleve) or by the full-text search
+// of a database can be used instead, by implementing this interface.
+type Index interface {
+	// Add indexes the text of a message; adding a message again has no effect.
+	Add(m *protocol.Message) error
+
+	// Search returns the messages of the topic (or of its subtopics) containing all the terms,
+	// newest first, at most limit of them.
+	Search(topic protocol.Path, terms []string, limit int) ([]Hit, error)
+}
+
+// Terms returns the lower-cased words of a text, without duplicates.
+func Terms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	seen := make(map[string]bool, len(words))
+	terms := words[:0]
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// indexable returns true if the body of the message is text which can be indexed:
+// the binary and the redacted bodies are not.
+func indexable(m *protocol.Message) bool {
+	return len(m.Body) > 0 && utf8.Valid(m.Body) && !m.Redacted()
+}
+
+// memoryIndex is an inverted index of the terms of the messages, held in memory.
+type memoryIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[Hit]bool
+	paths    map[Hit]protocol.Path
+}
+
+// NewMemoryIndex returns a new Index held in memory, which is rebuilt from the message store at each start.
+func NewMemoryIndex() Index {
+	return &memoryIndex{
+		postings: make(map[string]map[Hit]bool),
+		paths:    make(map[Hit]protocol.Path),
+	}
+}
+
+// Add is a part of the Index implementation.
+func (idx *memoryIndex) Add(m *protocol.Message) error {
+	hit := Hit{Partition: m.Path.Partition(), ID: m.ID}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, exist := idx.paths[hit]; exist {
+		return nil
+	}
+	idx.paths[hit] = m.Path
+	for _, term := range Terms(string(m.Body)) {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[Hit]bool)
+		}
+		idx.postings[term][hit] = true
+	}
+	mCurrentIndexed.Set(int64(len(idx.paths)))
+	return nil
+}
+
+// Search is a part of the Index implementation.
+func (idx *memoryIndex) Search(topic protocol.Path, terms []string, limit int) ([]Hit, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	// the rarest term gives the candidates
+	rarest := terms[0]
+	for _, term := range terms[1:] {
+		if len(idx.postings[term]) < len(idx.postings[rarest]) {
+			rarest = term
+		}
+	}
+
+	var hits []Hit
+	for hit := range idx.postings[rarest] {
+		if !idx.paths[hit].InTopic(topic) {
+			continue
+		}
+		matches := true
+		for _, term := range terms {
+			if !idx.postings[term][hit] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			hits = append(hits, hit)
+		}
+	}
+
+	sort.Sort(newestFirst(hits))
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+type newestFirst []Hit
+
+func (h newestFirst) Len() int           { return len(h) }
+func (h newestFirst) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h newestFirst) Less(i, j int) bool { return h[i].ID > h[j].ID }
@@ -0,0 +1,44 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+)
+
+func TestTerms(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal([]string{"your", "order", "42", "shipped", "grüße"}, Terms("Your order #42 shipped! Order: grüße"))
+	a.Empty(Terms(" ,.! "))
+}
+
+func TestMemoryIndex(t *testing.T) {
+	a := assert.New(t)
+
+	idx := NewMemoryIndex()
+	a.NoError(idx.Add(&protocol.Message{ID: 1, Path: "/orders/user01", Body: []byte("Your order 42 shipped")}))
+	a.NoError(idx.Add(&protocol.Message{ID: 2, Path: "/orders/user02", Body: []byte("Your order 43 shipped")}))
+	a.NoError(idx.Add(&protocol.Message{ID: 3, Path: "/orders/user01", Body: []byte("Your order 44 was cancelled")}))
+	a.NoError(idx.Add(&protocol.Message{ID: 4, Path: "/ordersx", Body: []byte("Your order shipped")}))
+	// added again, e.g. fetched and routed
+	a.NoError(idx.Add(&protocol.Message{ID: 1, Path: "/orders/user01", Body: []byte("Your order 42 shipped")}))
+
+	hits, err := idx.Search("/orders", []string{"order", "shipped"}, 0)
+	a.NoError(err)
+	a.Equal([]Hit{{"orders", 2}, {"orders", 1}}, hits)
+
+	hits, err = idx.Search("/orders/user01", []string{"your"}, 0)
+	a.NoError(err)
+	a.Equal([]Hit{{"orders", 3}, {"orders", 1}}, hits)
+
+	hits, err = idx.Search("/orders", []string{"your"}, 1)
+	a.NoError(err)
+	a.Equal([]Hit{{"orders", 3}}, hits)
+
+	hits, err = idx.Search("/orders", []string{"shipped", "unknown"}, 0)
+	a.NoError(err)
+	a.Empty(hits)
+}
@@ -0,0 +1,297 @@
+// Package search indexes the text of the messages stored in some topics, so that they can be searched
+// (e.g. by the support staff looking for a notification) without exporting the whole topics.
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store"
+)
+
+const (
+	// defaultLimit is the number of messages returned by a search without limit, and maxLimit the maximum
+	defaultLimit = 100
+	maxLimit     = 1000
+
+	routeChannelSize = 1000
+)
+
+// Indexer builds the index of the configured topics asynchronously: the messages already stored are read from the
+// message store in the background, and the new messages are indexed as they are routed.
+// The index is searched by an admin endpoint:
+// `GET <prefix>?topic=<topic>&q=<words>[&limit=<n>]` returns the messages of the topic (or of its subtopics)
+// containing all the words, newest first.
+type Indexer struct {
+	router router.Router
+	index  Index
+	topics []protocol.Path
+	prefix string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewIndexer returns a new Indexer of the given topics (including their subtopics).
+func NewIndexer(router router.Router, index Index, topics []protocol.Path, prefix string) *Indexer {
+	return &Indexer{
+		router: router,
+		index:  index,
+		topics: topics,
+		prefix: prefix,
+	}
+}
+
+// Start begins indexing the topics.
+func (ix *Indexer) Start() error {
+	ix.ctx, ix.cancel = context.WithCancel(context.Background())
+	for _, topic := range ix.topics {
+		ix.wg.Add(1)
+		go ix.indexTopic(topic)
+	}
+	logger.WithField("topics", ix.topics).Info("Started search indexer")
+	return nil
+}
+
+// Stop stops indexing the topics.
+func (ix *Indexer) Stop() error {
+	ix.cancel()
+	ix.wg.Wait()
+	logger.Info("Stopped search indexer")
+	return nil
+}
+
+// indexTopic indexes the stored messages of a topic and, after subscribing, its new messages.
+// If the router closes the route (e.g. when the indexing is too slow), the messages stored meanwhile are read again.
+func (ix *Indexer) indexTopic(topic protocol.Path) {
+	defer ix.wg.Done()
+
+	var lastID uint64
+	for ix.ctx.Err() == nil {
+		route := router.NewRoute(router.RouteConfig{
+			Path:        topic,
+			ChannelSize: routeChannelSize,
+			Kind:        router.ConnectorRoute,
+		})
+		if _, err := ix.router.Subscribe(route); err != nil {
+			logger.WithError(err).WithField("topic", topic).Error("Error subscribing, the topic is not indexed")
+			return
+		}
+
+		// subscribed first, so that no message is missed: the messages received twice are indexed once
+		id, err := ix.indexStored(topic, lastID+1)
+		if err != nil {
+			logger.WithError(err).WithField("topic", topic).Error("Error indexing the stored messages")
+		}
+		if id > lastID {
+			lastID = id
+		}
+
+		if !ix.indexRouted(route, &lastID) {
+			ix.router.Unsubscribe(route)
+			return
+		}
+		logger.WithField("topic", topic).Warn("Search route closed, indexing the stored messages again")
+	}
+}
+
+// indexRouted indexes the messages of the route until it is closed,
+// and returns false if the indexer was stopped meanwhile.
+func (ix *Indexer) indexRouted(route *router.Route, lastID *uint64) bool {
+	for {
+		select {
+		case m, ok := <-route.MessagesChannel():
+			if !ok {
+				return ix.ctx.Err() == nil
+			}
+			ix.add(m)
+			if m.ID > *lastID {
+				*lastID = m.ID
+			}
+		case <-ix.ctx.Done():
+			return false
+		}
+	}
+}
+
+// indexStored indexes the stored messages of the topic from the given id, and returns the id of the last one.
+func (ix *Indexer) indexStored(topic protocol.Path, startID uint64) (uint64, error) {
+	messageStore, err := ix.router.MessageStore()
+	if err != nil {
+		return 0, err
+	}
+	req := store.NewFetchRequest(topic.Partition(), startID, 0, store.DirectionForward, -1)
+	req.Init()
+	messageStore.Fetch(req)
+
+	var lastID uint64
+	for {
+		select {
+		case <-req.StartC:
+		case fetched, open := <-req.MessageC:
+			if !open {
+				return lastID, nil
+			}
+			lastID = fetched.ID
+			m, err := protocol.ParseMessage(fetched.Message)
+			if err != nil {
+				logger.WithError(err).WithField("id", fetched.ID).Error("Skipping a stored message which cannot be parsed")
+				continue
+			}
+			if m.Path.InTopic(topic) {
+				ix.add(m)
+			}
+		case err := <-req.ErrorC:
+			return lastID, err
+		case <-ix.ctx.Done():
+			return lastID, ix.ctx.Err()
+		}
+	}
+}
+
+// add indexes a message, if its body is text.
+func (ix *Indexer) add(m *protocol.Message) {
+	if !indexable(m) {
+		return
+	}
+	if err := ix.index.Add(m); err != nil {
+		logger.WithError(err).WithField("id", m.ID).WithField("path", m.Path).Error("Error indexing a message")
+		return
+	}
+	mTotalIndexed.Add(1)
+}
+
+// result is a message found by a search.
+type result struct {
+	ID          uint64        `json:"id"`
+	Path        protocol.Path `json:"path"`
+	UserID      string        `json:"user_id,omitempty"`
+	Time        int64         `json:"time"`
+	Headers     string        `json:"headers,omitempty"`
+	ContentType string        `json:"content_type,omitempty"`
+	Body        string        `json:"body"`
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (ix *Indexer) GetPrefix() string {
+	return ix.prefix
+}
+
+// ServeHTTP searches the indexed messages.
+// It is a part of the service.endpoint implementation.
+func (ix *Indexer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET is accepted."}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	topic := protocol.Path(query.Get("topic"))
+	if !ix.indexed(topic) {
+		http.Error(w, `{"error": "The topic is not indexed."}`, http.StatusBadRequest)
+		return
+	}
+	terms := Terms(query.Get("q"))
+	if len(terms) == 0 {
+		http.Error(w, `{"error": "Missing search words."}`, http.StatusBadRequest)
+		return
+	}
+	limit := defaultLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxLimit {
+			http.Error(w, fmt.Sprintf(`{"error": "The limit has to be between 1 and %d."}`, maxLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	mTotalSearches.Add(1)
+	hits, err := ix.index.Search(topic, terms, limit)
+	if err != nil {
+		logger.WithError(err).WithField("topic", topic).Error("Error searching")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	results, err := ix.load(hits)
+	if err != nil {
+		logger.WithError(err).WithField("topic", topic).Error("Error loading the found messages")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(results)
+}
+
+// indexed returns true if the topic is indexed, as one of the topics or as a subtopic of one of them.
+func (ix *Indexer) indexed(topic protocol.Path) bool {
+	if topic == "" {
+		return false
+	}
+	for _, indexed := range ix.topics {
+		if topic.InTopic(indexed) {
+			return true
+		}
+	}
+	return false
+}
+
+// load returns the found messages from the message store, skipping the ones removed meanwhile.
+func (ix *Indexer) load(hits []Hit) ([]result, error) {
+	messageStore, err := ix.router.MessageStore()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]result, 0, len(hits))
+	for _, hit := range hits {
+		req := store.NewFetchRequest(hit.Partition, hit.ID, 0, store.DirectionOneMessage, 1)
+		req.Init()
+		messageStore.Fetch(req)
+		fetched, err := fetchOne(req)
+		if err != nil {
+			return nil, err
+		}
+		if fetched == nil || fetched.ID != hit.ID {
+			continue
+		}
+		m, err := protocol.ParseMessage(fetched.Message)
+		if err != nil {
+			continue
+		}
+		results = append(results, result{
+			ID:          m.ID,
+			Path:        m.Path,
+			UserID:      m.UserID,
+			Time:        m.Time,
+			Headers:     m.HeaderJSON,
+			ContentType: m.ContentType,
+			Body:        string(m.Body),
+		})
+	}
+	return results, nil
+}
+
+// fetchOne returns the message of a fetch request for a single message (nil, if it does not exist).
+func fetchOne(req *store.FetchRequest) (*store.FetchedMessage, error) {
+	var found *store.FetchedMessage
+	for {
+		select {
+		case <-req.StartC:
+		case fetched, open := <-req.MessageC:
+			if !open {
+				return found, nil
+			}
+			found = fetched
+		case err := <-req.ErrorC:
+			return nil, err
+		}
+	}
+}
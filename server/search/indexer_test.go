@@ -0,0 +1,76 @@
+package search
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/filestore"
+)
+
+func TestIndexer_IndexesTheStoredAndTheRoutedMessages(t *testing.T) {
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_search_test")
+	defer os.RemoveAll(dir)
+
+	kvs := kvstore.NewMemoryKVStore()
+	messageStore := filestore.New(dir)
+	defer messageStore.Stop()
+	r := router.New(auth.NewAllowAllAccessManager(true), messageStore, kvs, nil)
+	a.NoError(r.(service.Startable).Start())
+	defer r.(service.Stopable).Stop()
+
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/orders/user01", Body: []byte("Order 42 shipped")}))
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/orders/user02", HeaderJSON: `{"Redact": true}`, Body: []byte("Order 43 shipped")}))
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/other", Body: []byte("Order 44 shipped")}))
+
+	indexer := NewIndexer(r, NewMemoryIndex(), []protocol.Path{"/orders"}, "/admin/search")
+	a.NoError(indexer.Start())
+	defer indexer.Stop()
+
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/orders/user01", Body: []byte("Order 45 shipped")}))
+
+	search := func(query string) (int, []result) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/admin/search?"+query, nil)
+		indexer.ServeHTTP(w, req)
+		var results []result
+		json.Unmarshal(w.Body.Bytes(), &results)
+		return w.Code, results
+	}
+
+	// the stored messages are indexed in the background
+	var results []result
+	for i := 0; i < 100 && len(results) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		_, results = search("topic=/orders&q=shipped")
+	}
+	if a.Len(results, 2) {
+		a.Equal("Order 45 shipped", results[0].Body)
+		a.Equal("Order 42 shipped", results[1].Body)
+		a.Equal(protocol.Path("/orders/user01"), results[1].Path)
+	}
+
+	code, results := search("topic=/orders/user01&q=order+42")
+	a.Equal(http.StatusOK, code)
+	a.Len(results, 1)
+
+	code, _ = search("topic=/other&q=shipped")
+	a.Equal(http.StatusBadRequest, code)
+	code, _ = search("topic=/orders&q=+!")
+	a.Equal(http.StatusBadRequest, code)
+	code, _ = search("topic=/orders&q=shipped&limit=0")
+	a.Equal(http.StatusBadRequest, code)
+}
@@ -0,0 +1,7 @@
+package search
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "search")
@@ -0,0 +1,12 @@
+package search
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns              = metrics.NS("search")
+	mTotalIndexed   = ns.NewInt("total_indexed_messages")
+	mTotalSearches  = ns.NewInt("total_searches")
+	mCurrentIndexed = ns.NewInt("current_indexed_messages")
+)
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"net/http"
 	"sort"
 )
@@ -15,6 +16,12 @@ type Stopable interface {
 	Stop() error
 }
 
+// Drainable interface for modules which stop accepting new work and close their clients gracefully before stopping.
+// Drain blocks until the module is drained, or until the context is done.
+type Drainable interface {
+	Drain(ctx context.Context) error
+}
+
 // Endpoint adds a HTTP handler for the `GetPrefix()` to the webserver
 type Endpoint interface {
 	http.Handler
@@ -8,9 +8,13 @@ import (
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/server/webserver"
 
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/hashicorp/go-multierror"
 	"net/http"
 	"reflect"
+	"sync"
 	"time"
 )
 
@@ -19,6 +23,22 @@ const (
 	defaultHealthThreshold = 1
 )
 
+var (
+	errStarting = errors.New("The service is starting: its modules are not started yet")
+	errDraining = errors.New("The service is draining: it does not accept new connections")
+
+	// startup is the health check failing until all the modules are started, so that a node is not reported as ready
+	// before e.g. the connectors have restored the routes of their subscriptions
+	startup = health.NewStatusUpdater()
+
+	// drain is the health check failing once the service drains, so that a node does not receive new traffic
+	drain = health.NewStatusUpdater()
+
+	// startupRegistry is the health registry in which the startup and drain checks are registered
+	startupRegistry   *health.Registry
+	startupRegistryMu sync.Mutex
+)
+
 // Service is the main struct for controlling a guble server
 type Service struct {
 	webserver       *webserver.WebServer
@@ -28,11 +48,14 @@ type Service struct {
 	healthFrequency time.Duration
 	healthThreshold int
 	metricsEndpoint string
+	drainEndpoint   string
+	drainTimeout    time.Duration
 }
 
 // New creates a new Service, using the given Router and WebServer.
 // If the router has already a configured Cluster, it is registered as a service module.
 // The Router and Webserver are then registered as modules.
+// The WebServer can be nil (e.g. when guble is embedded), then the endpoints of the modules are not served.
 func New(router router.Router, webserver *webserver.WebServer) *Service {
 	s := &Service{
 		webserver:       webserver,
@@ -46,7 +69,9 @@ func New(router router.Router, webserver *webserver.WebServer) *Service {
 		router.Cluster().Router = router
 	}
 	s.RegisterModules(2, 2, s.router)
-	s.RegisterModules(3, 4, s.webserver)
+	if webserver != nil {
+		s.RegisterModules(3, 4, s.webserver)
+	}
 	return s
 }
 
@@ -80,14 +105,29 @@ func (s *Service) MetricsEndpoint(endpointPrefix string) *Service {
 	return s
 }
 
+// DrainEndpoint sets the endpoint draining the service (e.g. called by a preStop hook), and the maximum duration
+// of the draining. Parameter for disabling the endpoint is: "". Returns the updated service.
+func (s *Service) DrainEndpoint(endpointPrefix string, timeout time.Duration) *Service {
+	s.drainEndpoint = endpointPrefix
+	s.drainTimeout = timeout
+	return s
+}
+
 // Start checks the modules for the following interfaces and registers and/or starts:
 //   Startable:
 //   health.Checker:
 //   Endpoint: Register the handler function of the Endpoint in the http service at prefix
 func (s *Service) Start() error {
 	var multierr *multierror.Error
+	if s.webserver == nil {
+		s.healthEndpoint = ""
+		s.metricsEndpoint = ""
+		s.drainEndpoint = ""
+	}
 	if s.healthEndpoint != "" {
 		logger.WithField("healthEndpoint", s.healthEndpoint).Info("Health endpoint")
+		registerStartup()
+		defer startup.Update(nil)
 		s.webserver.Handle(s.healthEndpoint, http.HandlerFunc(health.StatusHandler))
 	} else {
 		logger.Info("Health endpoint disabled")
@@ -98,6 +138,10 @@ func (s *Service) Start() error {
 	} else {
 		logger.Info("Metrics endpoint disabled")
 	}
+	if s.drainEndpoint != "" {
+		logger.WithField("drainEndpoint", s.drainEndpoint).Info("Drain endpoint")
+		s.webserver.Handle(s.drainEndpoint, http.HandlerFunc(s.handleDrain))
+	}
 	for order, iface := range s.ModulesSortedByStartOrder() {
 		name := reflect.TypeOf(iface).String()
 		if s, ok := iface.(Startable); ok {
@@ -113,7 +157,7 @@ func (s *Service) Start() error {
 			logger.WithField("name", name).Info("Registering module as Health-Checker")
 			health.RegisterPeriodicThresholdFunc(name, s.healthFrequency, s.healthThreshold, health.CheckFunc(c.Check))
 		}
-		if e, ok := iface.(Endpoint); ok {
+		if e, ok := iface.(Endpoint); ok && s.webserver != nil {
 			prefix := e.GetPrefix()
 			logger.WithFields(log.Fields{"name": name, "prefix": prefix}).Info("Registering module as Endpoint")
 			s.webserver.Handle(prefix, e)
@@ -122,6 +166,61 @@ func (s *Service) Start() error {
 	return multierr.ErrorOrNil()
 }
 
+// registerStartup registers the startup health check (once in a registry), failing until the service is started,
+// and the drain health check, failing once the service drains.
+func registerStartup() {
+	startupRegistryMu.Lock()
+	defer startupRegistryMu.Unlock()
+
+	startup.Update(errStarting)
+	drain.Update(nil)
+	if startupRegistry != health.DefaultRegistry {
+		health.Register("startup", startup)
+		health.Register("drain", drain)
+		startupRegistry = health.DefaultRegistry
+	}
+}
+
+// Drain fails the health check (so that the node does not receive new traffic), and drains the Drainable modules
+// (e.g. closing the websockets gracefully), blocking until they are drained or until the context is done.
+// The modules are not stopped.
+func (s *Service) Drain(ctx context.Context) error {
+	if s.healthEndpoint != "" {
+		drain.Update(errDraining)
+	}
+	var multierr *multierror.Error
+	for _, iface := range s.ModulesSortedByStartOrder() {
+		if d, ok := iface.(Drainable); ok {
+			name := reflect.TypeOf(iface).String()
+			logger.WithField("name", name).Info("Draining module")
+			if err := d.Drain(ctx); err != nil {
+				logger.WithError(err).WithField("name", name).Warn("Module not drained")
+				multierr = multierror.Append(multierr, err)
+			}
+		}
+	}
+	return multierr.ErrorOrNil()
+}
+
+// handleDrain drains the service, answering when it is drained, or with 503 after the drain timeout.
+// It answers to GET as well (e.g. for the httpGet preStop hooks of Kubernetes).
+func (s *Service) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET and POST are accepted."}`, http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.drainTimeout)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/json")
+	result := map[string]interface{}{"drained": true}
+	if err := s.Drain(ctx); err != nil {
+		result = map[string]interface{}{"drained": false, "error": err.Error()}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
 // Stop stops the registered modules in their given order
 func (s *Service) Stop() error {
 	var multierr *multierror.Error
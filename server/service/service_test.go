@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+
 	"github.com/smancke/guble/server/kvstore"
 	"github.com/smancke/guble/server/store"
 	"github.com/smancke/guble/server/store/dummystore"
@@ -76,6 +78,23 @@ func TestEndpointRegisterAndServing(t *testing.T) {
 	a.Equal("bar", string(body))
 }
 
+func TestServiceWithoutWebServer(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	defer testutil.ResetDefaultRegistryHealthCheck()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	routerMock.EXPECT().Cluster().Return(nil)
+	service := New(routerMock, nil).HealthEndpoint("/health_url")
+
+	// the endpoints are not served, but the modules are still started and stopped
+	service.RegisterModules(0, 0, &testEndpoint{})
+	a.Equal(2, len(service.ModulesSortedByStartOrder()))
+	a.NoError(service.Start())
+	a.NoError(service.Stop())
+}
+
 func TestHealthUp(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -135,6 +154,40 @@ func TestHealthDown(t *testing.T) {
 	a.Equal("{\"*service.MockChecker\":\"sick\"}", string(body))
 }
 
+func TestHealthDownWhileStarting(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	defer testutil.ResetDefaultRegistryHealthCheck()
+	a := assert.New(t)
+
+	// given: a module started after the webserver, which is slow to start
+	service, _, _, _ := aMockedServiceWithMockedRouterStandalone()
+	service = service.HealthEndpoint("/health_url")
+	slow := &testSlowStartable{startedC: make(chan struct{}), releaseC: make(chan struct{})}
+	service.RegisterModules(5, 0, slow)
+
+	startedC := make(chan error)
+	go func() { startedC <- service.Start() }()
+	defer service.Stop()
+	<-slow.startedC
+
+	// then the node is not ready while the module is starting
+	url := fmt.Sprintf("http://%s/health_url", service.WebServer().GetAddr())
+	result, err := http.Get(url)
+	a.NoError(err)
+	a.Equal(503, result.StatusCode)
+	body, err := ioutil.ReadAll(result.Body)
+	a.NoError(err)
+	a.Contains(string(body), "startup")
+
+	// and it is ready when all the modules are started
+	close(slow.releaseC)
+	a.NoError(<-startedC)
+	result, err = http.Get(url)
+	a.NoError(err)
+	a.Equal(200, result.StatusCode)
+}
+
 func TestMetricsEnabled(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -162,6 +215,46 @@ func TestMetricsEnabled(t *testing.T) {
 	a.True(len(body) > 0)
 }
 
+func TestDrainEndpoint(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	defer testutil.ResetDefaultRegistryHealthCheck()
+	a := assert.New(t)
+
+	// given: a module which is drained slowly
+	service, _, _, _ := aMockedServiceWithMockedRouterStandalone()
+	service = service.HealthEndpoint("/health_url").DrainEndpoint("/drain_url", time.Second)
+	drainable := &testDrainable{delay: 20 * time.Millisecond}
+	service.RegisterModules(5, 0, drainable)
+
+	defer service.Stop()
+	a.NoError(service.Start())
+	addr := service.WebServer().GetAddr()
+
+	// when the drain URL is called, it answers when the module is drained
+	result, err := http.Get(fmt.Sprintf("http://%s/drain_url", addr))
+	a.NoError(err)
+	a.Equal(200, result.StatusCode)
+	body, err := ioutil.ReadAll(result.Body)
+	a.NoError(err)
+	a.JSONEq(`{"drained": true}`, string(body))
+	a.True(drainable.drained)
+
+	// and the node is not ready anymore
+	result, err = http.Get(fmt.Sprintf("http://%s/health_url", addr))
+	a.NoError(err)
+	a.Equal(503, result.StatusCode)
+	body, err = ioutil.ReadAll(result.Body)
+	a.NoError(err)
+	a.Contains(string(body), "drain")
+
+	// a module which is not drained in time fails the draining
+	service.drainTimeout = time.Millisecond
+	result, err = http.Post(fmt.Sprintf("http://%s/drain_url", addr), "application/json", nil)
+	a.NoError(err)
+	a.Equal(503, result.StatusCode)
+}
+
 func aMockedServiceWithMockedRouterStandalone() (*Service, kvstore.KVStore, store.MessageStore, *MockRouter) {
 	kvStore := kvstore.NewMemoryKVStore()
 	messageStore := dummystore.New(kvStore)
@@ -190,6 +283,32 @@ func (*testStartable) Start() error {
 	panic(fmt.Errorf("In a panic when I should start"))
 }
 
+type testSlowStartable struct {
+	startedC chan struct{}
+	releaseC chan struct{}
+}
+
+func (s *testSlowStartable) Start() error {
+	close(s.startedC)
+	<-s.releaseC
+	return nil
+}
+
+type testDrainable struct {
+	delay   time.Duration
+	drained bool
+}
+
+func (d *testDrainable) Drain(ctx context.Context) error {
+	select {
+	case <-time.After(d.delay):
+		d.drained = true
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 type testStopable struct {
 }
 
@@ -8,6 +8,8 @@ type NexmoSms struct {
 	To        string `json:"to"`
 	From      string `json:"from"`
 	Text      string `json:"text"`
+	ClientRef string `json:"client-ref,omitempty"`
+	Callback  string `json:"callback,omitempty"`
 }
 
 func (sms *NexmoSms) EncodeNexmoSms(apiKey, apiSecret string) ([]byte, error) {
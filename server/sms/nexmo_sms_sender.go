@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/proxy"
 )
 
 var (
@@ -44,9 +48,9 @@ const (
 )
 
 var (
-	ErrNoSMSSent                 = errors.New("No sms was sent to Nexmo")
-	ErrIncompleteSMSSent         = errors.New("Nexmo sms was only partial delivered.One or more part returned an error")
-	ErrSMSResponseDecodingFailed = errors.New("Nexmo response decoding failed.")
+	ErrNoSMSSent                 = errors.New("No sms was sent to the provider")
+	ErrIncompleteSMSSent         = errors.New("The sms was only partial delivered.One or more part returned an error")
+	ErrSMSResponseDecodingFailed = errors.New("The provider response decoding failed.")
 	ErrNoRetry                   = errors.New("SMS failed. No retrying.")
 )
 
@@ -112,12 +116,26 @@ func (nm NexmoMessageResponse) Check() error {
 	return nil
 }
 
+// nexmoReportStatuses maps the statuses of the Nexmo delivery receipts to the common statuses.
+var nexmoReportStatuses = map[string]string{
+	"accepted":  StatusPending,
+	"buffered":  StatusPending,
+	"delivered": StatusDelivered,
+	"expired":   StatusExpired,
+	"failed":    StatusFailed,
+	"rejected":  StatusFailed,
+}
+
 type NexmoSender struct {
 	logger    *log.Entry
 	ApiKey    string
 	ApiSecret string
 
+	// Callback is the URL receiving the delivery receipts (default: the URL configured in the Nexmo account)
+	Callback string
+
 	httpClient *http.Client
+	proxy      *url.URL
 }
 
 func NewNexmoSender(apiKey, apiSecret string) (*NexmoSender, error) {
@@ -130,6 +148,12 @@ func NewNexmoSender(apiKey, apiSecret string) (*NexmoSender, error) {
 	return ns, nil
 }
 
+// Name returns the name of the Nexmo provider.
+// It is a part of the Provider implementation.
+func (ns *NexmoSender) Name() string {
+	return NexmoProvider
+}
+
 func (ns *NexmoSender) Send(msg *protocol.Message) error {
 	nexmoSMS := new(NexmoSms)
 	err := json.Unmarshal(msg.Body, nexmoSMS)
@@ -137,6 +161,8 @@ func (ns *NexmoSender) Send(msg *protocol.Message) error {
 		logger.WithField("error", err.Error()).Error("Could not decode message body to send to nexmo")
 		return err
 	}
+	nexmoSMS.ClientRef = clientReference(msg)
+	nexmoSMS.Callback = ns.Callback
 	nexmoSMSResponse, err := ns.sendSms(nexmoSMS)
 	if err != nil {
 		logger.WithField("error", err.Error()).Error("Could not decode nexmo response message body")
@@ -161,7 +187,7 @@ func (ns *NexmoSender) sendSms(sms *NexmoSms) (*NexmoMessageResponse, error) {
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Content-Length", strconv.Itoa(len(smsEncoded)))
 
-	resp, err := (&http.Client{}).Do(req)
+	resp, err := ns.httpClient.Do(req)
 	if err != nil {
 		logger.WithField("error", err.Error()).Error("Error doing the request to nexmo endpoint")
 		ns.createHttpClient()
@@ -189,12 +215,64 @@ func (ns *NexmoSender) sendSms(sms *NexmoSms) (*NexmoMessageResponse, error) {
 	return messageResponse, nil
 }
 
+// ParseReport decodes a Nexmo delivery receipt, sent either as query / form parameters or as JSON.
+// It is a part of the Provider implementation.
+func (ns *NexmoSender) ParseReport(r *http.Request) (*DeliveryReport, error) {
+	params := make(map[string]string)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		values := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+			return nil, ErrInvalidReport
+		}
+		for key, value := range values {
+			if value != nil {
+				params[key] = fmt.Sprint(value)
+			}
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, ErrInvalidReport
+		}
+		for key := range r.Form {
+			params[key] = r.Form.Get(key)
+		}
+	}
+
+	if params["messageId"] == "" || params["status"] == "" {
+		return nil, ErrInvalidReport
+	}
+	report := &DeliveryReport{
+		Provider:          NexmoProvider,
+		ProviderMessageID: params["messageId"],
+		To:                params["msisdn"],
+		Status:            StatusUnknown,
+	}
+	if status, ok := nexmoReportStatuses[params["status"]]; ok {
+		report.Status = status
+	}
+	if errorCode := params["err-code"]; errorCode != "" && errorCode != "0" {
+		report.ErrorCode = errorCode
+	}
+	if err := report.setClientReference(params["client-ref"]); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// SetProxy routes the requests to Nexmo through the proxy (directly, if nil).
+func (ns *NexmoSender) SetProxy(proxyURL *url.URL) {
+	ns.proxy = proxyURL
+	ns.createHttpClient()
+}
+
 func (ns *NexmoSender) createHttpClient() {
 	logger.Info("Recreating HTTP client for nexmo sender")
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: MaxIdleConnections,
+	}
+	proxy.Configure(transport, ns.proxy)
 	ns.httpClient = &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost: MaxIdleConnections,
-		},
-		Timeout: RequestTimeout,
+		Transport: transport,
+		Timeout:   RequestTimeout,
 	}
 }
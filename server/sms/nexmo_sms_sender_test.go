@@ -2,6 +2,9 @@ package sms
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -58,3 +61,38 @@ func TestNexmoSender_SendWithError(t *testing.T) {
 	a.Error(err)
 	a.Equal(ErrIncompleteSMSSent, err)
 }
+
+func TestNexmoSender_ParseReport(t *testing.T) {
+	a := assert.New(t)
+	sender, err := NewNexmoSender(KEY, SECRET)
+	a.NoError(err)
+
+	// a delivery receipt in query parameters
+	req := httptest.NewRequest(http.MethodGet,
+		"/sms/receipts/nexmo?msisdn=4917012345678&to=guble&messageId=0A00000012345678&status=expired&err-code=5&client-ref=4%3Asamsa", nil)
+	report, err := sender.ParseReport(req)
+	a.NoError(err)
+	a.Equal(&DeliveryReport{
+		Provider:          NexmoProvider,
+		ProviderMessageID: "0A00000012345678",
+		To:                "4917012345678",
+		Status:            StatusExpired,
+		ErrorCode:         "5",
+		MessageID:         4,
+		UserID:            "samsa",
+	}, report)
+
+	// a delivery receipt in JSON
+	req = httptest.NewRequest(http.MethodPost, "/sms/receipts/nexmo",
+		strings.NewReader(`{"msisdn": "4917012345678", "messageId": "0A00000012345678", "status": "delivered", "err-code": "0", "client-ref": "4:samsa"}`))
+	req.Header.Set("Content-Type", "application/json")
+	report, err = sender.ParseReport(req)
+	a.NoError(err)
+	a.Equal(StatusDelivered, report.Status)
+	a.Equal("", report.ErrorCode)
+
+	// a delivery receipt without status
+	req = httptest.NewRequest(http.MethodGet, "/sms/receipts/nexmo?messageId=0A00000012345678&client-ref=4%3Asamsa", nil)
+	_, err = sender.ParseReport(req)
+	a.Equal(ErrInvalidReport, err)
+}
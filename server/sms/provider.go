@@ -0,0 +1,142 @@
+package sms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/smancke/guble/protocol"
+)
+
+const (
+	NexmoProvider  = "nexmo"
+	TwilioProvider = "twilio"
+
+	// ReceiptsPrefix is the prefix of the topics receiving the delivery reports of the SMS,
+	// followed by the user id of the SMS publisher.
+	ReceiptsPrefix = "/receipts/sms/"
+)
+
+// The statuses of the delivery reports, common to all the providers.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+	StatusExpired   = "expired"
+	StatusUnknown   = "unknown"
+)
+
+var (
+	ErrUnknownProvider = errors.New("Unknown SMS provider.")
+	ErrInvalidReport   = errors.New("Invalid SMS delivery report.")
+)
+
+// Provider is a Sender using the API of an SMS provider,
+// which is also able to decode the delivery reports the provider sends back.
+type Provider interface {
+	Sender
+
+	// Name returns the name by which the provider is selected
+	Name() string
+
+	// ParseReport decodes a delivery report request received from the provider
+	ParseReport(*http.Request) (*DeliveryReport, error)
+}
+
+// SMS is the body of the messages published on the SMS topic.
+type SMS struct {
+	To   string `json:"to"`
+	From string `json:"from"`
+	Text string `json:"text"`
+
+	// Provider is the name of the provider sending the SMS (default: the provider selected in the config)
+	Provider string `json:"provider,omitempty"`
+}
+
+// DeliveryReport is the delivery status of an SMS, reported by its provider.
+type DeliveryReport struct {
+	Provider          string `json:"provider"`
+	ProviderMessageID string `json:"provider_message_id"`
+	To                string `json:"to"`
+	Status            string `json:"status"`
+	ErrorCode         string `json:"error_code,omitempty"`
+
+	// MessageID and UserID are the id and the publisher of the guble message of the SMS
+	MessageID uint64 `json:"message_id"`
+	UserID    string `json:"user_id"`
+}
+
+// Path returns the receipts topic of the user who published the SMS.
+func (r *DeliveryReport) Path() protocol.Path {
+	return protocol.Path(ReceiptsPrefix + r.UserID)
+}
+
+// clientReference returns the reference of a message, which the providers attach to the delivery reports of its SMS.
+func clientReference(msg *protocol.Message) string {
+	return fmt.Sprintf("%d:%s", msg.ID, msg.UserID)
+}
+
+// setClientReference fills the guble message id and user id of the report from a client reference.
+func (r *DeliveryReport) setClientReference(ref string) error {
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) != 2 {
+		return ErrInvalidReport
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return ErrInvalidReport
+	}
+	r.MessageID = id
+	r.UserID = parts[1]
+	return nil
+}
+
+// Providers is a Sender dispatching each SMS to the provider named in the message,
+// or to the default provider if the message does not name one.
+type Providers struct {
+	defaultProvider Provider
+	providers       map[string]Provider
+}
+
+// NewProviders returns the Providers, failing if the default provider is not one of them.
+func NewProviders(defaultName string, providers ...Provider) (*Providers, error) {
+	p := &Providers{providers: make(map[string]Provider, len(providers))}
+	for _, provider := range providers {
+		p.providers[provider.Name()] = provider
+	}
+	defaultProvider, ok := p.providers[defaultName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	p.defaultProvider = defaultProvider
+	return p, nil
+}
+
+// Get returns the provider with the given name.
+func (p *Providers) Get(name string) (Provider, bool) {
+	provider, ok := p.providers[name]
+	return provider, ok
+}
+
+// Send sends the SMS of the message with its provider.
+// The messages naming an unknown provider are skipped, because retrying them is useless.
+func (p *Providers) Send(msg *protocol.Message) error {
+	sms := new(SMS)
+	if err := json.Unmarshal(msg.Body, sms); err != nil {
+		logger.WithField("error", err.Error()).Error("Could not decode message body to send as sms")
+		return err
+	}
+
+	provider := p.defaultProvider
+	if sms.Provider != "" {
+		var ok bool
+		if provider, ok = p.providers[sms.Provider]; !ok {
+			logger.WithField("provider", sms.Provider).WithField("id", msg.ID).Error("Skipping sms for an unknown provider")
+			return nil
+		}
+	}
+	return provider.Send(msg)
+}
@@ -0,0 +1,62 @@
+package sms
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeProvider struct {
+	name string
+	sent []*protocol.Message
+}
+
+func (p *fakeProvider) Name() string {
+	return p.name
+}
+
+func (p *fakeProvider) Send(msg *protocol.Message) error {
+	p.sent = append(p.sent, msg)
+	return nil
+}
+
+func (p *fakeProvider) ParseReport(*http.Request) (*DeliveryReport, error) {
+	return nil, ErrInvalidReport
+}
+
+func TestProviders_Send(t *testing.T) {
+	a := assert.New(t)
+
+	nexmo := &fakeProvider{name: NexmoProvider}
+	twilio := &fakeProvider{name: TwilioProvider}
+	providers, err := NewProviders(NexmoProvider, nexmo, twilio)
+	a.NoError(err)
+
+	a.NoError(providers.Send(smsMessage(a, SMS{To: "+4917012345678", Text: "default"})))
+	a.NoError(providers.Send(smsMessage(a, SMS{To: "+4917012345678", Text: "selected", Provider: TwilioProvider})))
+	a.NoError(providers.Send(smsMessage(a, SMS{To: "+4917012345678", Text: "skipped", Provider: "unknown"})))
+	a.Equal(1, len(nexmo.sent))
+	a.Equal(1, len(twilio.sent))
+
+	provider, ok := providers.Get(TwilioProvider)
+	a.True(ok)
+	a.Equal(twilio, provider)
+
+	// the default provider has to be configured
+	_, err = NewProviders(TwilioProvider, nexmo)
+	a.Equal(ErrUnknownProvider, err)
+}
+
+func TestDeliveryReport_setClientReference(t *testing.T) {
+	a := assert.New(t)
+
+	report := &DeliveryReport{}
+	a.NoError(report.setClientReference(clientReference(&protocol.Message{ID: 42, UserID: "user:01"})))
+	a.Equal(uint64(42), report.MessageID)
+	a.Equal("user:01", report.UserID)
+
+	a.Equal(ErrInvalidReport, report.setClientReference(""))
+	a.Equal(ErrInvalidReport, report.setClientReference("x:user01"))
+}
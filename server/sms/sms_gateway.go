@@ -30,12 +30,20 @@ type Sender interface {
 }
 
 type Config struct {
-	Enabled         *bool
-	APIKey          *string
-	APISecret       *string
-	Workers         *int
-	SMSTopic        *string
-	IntervalMetrics *bool
+	Enabled          *bool
+	Provider         *string
+	APIKey           *string
+	APISecret        *string
+	TwilioAccountSID *string
+	TwilioAuthToken  *string
+	Workers          *int
+	SMSTopic         *string
+	Prefix           *string
+	CallbackURL      *string
+	IntervalMetrics  *bool
+	BreakerFailures  *int
+	BreakerProbe     *time.Duration
+	Proxy            *string
 
 	Name   string
 	Schema string
@@ -44,9 +52,10 @@ type Config struct {
 type gateway struct {
 	config *Config
 
-	sender Sender
-	router router.Router
-	route  *router.Route
+	sender  Sender
+	router  router.Router
+	route   *router.Route
+	breaker *connector.Breaker
 
 	LastIDSent uint64
 
@@ -62,11 +71,23 @@ func New(router router.Router, sender Sender, config Config) (*gateway, error) {
 	}
 	config.Schema = SMSSchema
 	config.Name = SMSDefaultTopic
+	if config.Prefix == nil {
+		prefix := SMSDefaultPrefix
+		config.Prefix = &prefix
+	}
+	var breaker connector.BreakerConfig
+	if config.BreakerFailures != nil {
+		breaker.Failures = *config.BreakerFailures
+	}
+	if config.BreakerProbe != nil {
+		breaker.Probe = *config.BreakerProbe
+	}
 	return &gateway{
-		config: &config,
-		router: router,
-		sender: sender,
-		logger: logger.WithField("name", config.Name),
+		config:  &config,
+		router:  router,
+		sender:  sender,
+		breaker: connector.NewBreaker("sms", breaker),
+		logger:  logger.WithField("name", config.Name),
 	}, nil
 }
 
@@ -94,6 +115,7 @@ func (g *gateway) initRoute() {
 		Path:         protocol.Path(*g.config.SMSTopic),
 		ChannelSize:  5000,
 		FetchRequest: g.fetchRequest(),
+		Kind:         router.ConnectorRoute,
 	})
 }
 
@@ -185,6 +207,10 @@ func (g *gateway) proxyLoop() (*protocol.Message, error) {
 				break
 			}
 
+			if !g.breaker.Allow(g.ctx) {
+				// stopped while the provider is failing: the message is fetched again after the last sent id
+				return nil, nil
+			}
 			err := g.send(receivedMsg)
 			if err != nil {
 				return receivedMsg, err
@@ -225,6 +251,7 @@ func (g *gateway) retry(msg *protocol.Message) error {
 
 func (g *gateway) send(receivedMsg *protocol.Message) error {
 	err := g.sender.Send(receivedMsg)
+	g.breaker.Report(err)
 	if err != nil {
 		log.WithField("error", err.Error()).Error("Sending of message failed")
 		mTotalResponseErrors.Add(1)
@@ -235,6 +262,12 @@ func (g *gateway) send(receivedMsg *protocol.Message) error {
 	return nil
 }
 
+// Check returns an error while the circuit breaker of the gateway is open.
+// It is a part of the health.Checker implementation.
+func (g *gateway) Check() error {
+	return g.breaker.Check()
+}
+
 func (g *gateway) Restart() error {
 	g.logger.WithField("LastIDSent", g.LastIDSent).Debug("Restart in progress")
 
@@ -131,6 +131,24 @@ func Test_SendOneSms(t *testing.T) {
 	a.Equal(totalSentCount, mTotalSentMessages)
 }
 
+func Test_SendFailuresOpenBreaker(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	mockSmsSender := NewMockSender(ctrl)
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	worker, topic, failures := 1, "/sms", 2
+	gw, err := New(routerMock, mockSmsSender, Config{Workers: &worker, SMSTopic: &topic, BreakerFailures: &failures})
+	a.NoError(err)
+
+	mockSmsSender.EXPECT().Send(gomock.Any()).Return(ErrNoSMSSent).Times(2)
+	a.Error(gw.send(&protocol.Message{ID: 1}))
+	a.NoError(gw.Check())
+	a.Error(gw.send(&protocol.Message{ID: 1}))
+	a.Error(gw.Check())
+}
+
 func Test_Restart(t *testing.T) {
 	ctrl, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -11,6 +11,7 @@ var (
 	mTotalSendErrors             = ns.NewInt("total_sent_message_errors")
 	mTotalResponseErrors         = ns.NewInt("total_response_errors")
 	mTotalResponseInternalErrors = ns.NewInt("total_response_internal_errors")
+	mTotalDeliveryReports        = ns.NewInt("total_delivery_reports")
 	mMinute                      = ns.NewMap("minute")
 	mHour                        = ns.NewMap("hour")
 	mDay                         = ns.NewMap("day")
@@ -0,0 +1,94 @@
+package sms
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/smancke/guble/protocol"
+)
+
+const (
+	SMSDefaultPrefix = "/sms/"
+
+	// receiptsPath is the path of the delivery report callbacks following the prefix,
+	// followed by the name of the provider
+	receiptsPath = "receipts/"
+)
+
+// ReportURL returns the URL receiving the delivery reports of a provider,
+// on a guble server reachable at the given base URL.
+func ReportURL(baseURL, prefix, provider string) string {
+	return strings.TrimSuffix(baseURL, "/") + receiptsPrefix(prefix) + provider
+}
+
+func receiptsPrefix(prefix string) string {
+	return strings.TrimSuffix(prefix, "/") + "/" + receiptsPath
+}
+
+// GetPrefix returns the prefix of the delivery report callbacks.
+// It is a part of the service.endpoint implementation.
+func (g *gateway) GetPrefix() string {
+	return *g.config.Prefix
+}
+
+// ServeHTTP receives the delivery reports of the providers on `<prefix>receipts/<provider>`,
+// and republishes them on the receipts topic of the SMS publisher.
+// It is a part of the service.endpoint implementation.
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	callbacksPrefix := receiptsPrefix(g.GetPrefix())
+	if !strings.HasPrefix(r.URL.Path, callbacksPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+
+	providers, ok := g.sender.(*Providers)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	provider, ok := providers.Get(strings.TrimPrefix(r.URL.Path, callbacksPrefix))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	report, err := provider.ParseReport(r)
+	if err != nil || report.UserID == "" {
+		g.logger.WithField("provider", provider.Name()).WithField("url", r.URL.RequestURI()).Error("Invalid delivery report")
+		http.Error(w, ErrInvalidReport.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := g.publishReport(report); err != nil {
+		http.Error(w, "Server error.", http.StatusInternalServerError)
+		return
+	}
+}
+
+// publishReport publishes a delivery report on the receipts topic of the SMS publisher.
+func (g *gateway) publishReport(report *DeliveryReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		g.logger.WithField("error", err.Error()).Error("Error encoding delivery report")
+		return err
+	}
+
+	err = g.router.HandleMessage(&protocol.Message{
+		Path: report.Path(),
+		Body: body,
+	})
+	if err != nil {
+		g.logger.WithField("error", err.Error()).WithField("path", report.Path()).Error("Error publishing delivery report")
+		return err
+	}
+
+	mTotalDeliveryReports.Add(1)
+	g.logger.WithFields(log.Fields{
+		"provider":  report.Provider,
+		"messageID": report.MessageID,
+		"status":    report.Status,
+	}).Info("Published delivery report")
+	return nil
+}
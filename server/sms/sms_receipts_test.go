@@ -0,0 +1,76 @@
+package sms
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ReceiveDeliveryReport(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	twilioSender, err := NewTwilioSender("sid", "token")
+	a.NoError(err)
+	providers, err := NewProviders(TwilioProvider, twilioSender)
+	a.NoError(err)
+
+	routerMock := NewMockRouter(ctrl)
+	topic := "/sms"
+	worker := 1
+	config := Config{
+		Workers:  &worker,
+		SMSTopic: &topic,
+	}
+	gw, err := New(routerMock, providers, config)
+	a.NoError(err)
+	a.Equal("/sms/", gw.GetPrefix())
+
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(m *protocol.Message) error {
+		a.Equal(protocol.Path("/receipts/sms/samsa"), m.Path)
+
+		var report DeliveryReport
+		a.NoError(json.Unmarshal(m.Body, &report))
+		a.Equal(TwilioProvider, report.Provider)
+		a.Equal("SM123", report.ProviderMessageID)
+		a.Equal(StatusDelivered, report.Status)
+		a.Equal(uint64(4), report.MessageID)
+		return nil
+	}).Return(nil)
+
+	form := url.Values{}
+	form.Set("MessageSid", "SM123")
+	form.Set("MessageStatus", "delivered")
+	req := httptest.NewRequest(http.MethodPost, "/sms/receipts/twilio?ref=4%3Asamsa", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, req)
+	a.Equal(http.StatusOK, w.Code)
+
+	// a report which cannot be mapped to a message
+	req = httptest.NewRequest(http.MethodPost, "/sms/receipts/twilio", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	gw.ServeHTTP(w, req)
+	a.Equal(http.StatusBadRequest, w.Code)
+
+	// a provider which is not configured
+	w = httptest.NewRecorder()
+	gw.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sms/receipts/nexmo", nil))
+	a.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestReportURL(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("https://guble.example.com/sms/receipts/nexmo", ReportURL("https://guble.example.com/", "/sms/", NexmoProvider))
+	a.Equal("https://guble.example.com/text/receipts/twilio", ReportURL("https://guble.example.com", "/text", TwilioProvider))
+}
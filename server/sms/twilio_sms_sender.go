@@ -0,0 +1,193 @@
+package sms
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/proxy"
+)
+
+// TwilioURL is the URL of the Twilio messages resource, formatted with the account SID.
+var TwilioURL = "https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json"
+
+// twilioReportStatuses maps the statuses of the Twilio status callbacks to the common statuses.
+var twilioReportStatuses = map[string]string{
+	"accepted":    StatusPending,
+	"queued":      StatusPending,
+	"sending":     StatusPending,
+	"sent":        StatusPending,
+	"delivered":   StatusDelivered,
+	"undelivered": StatusFailed,
+	"failed":      StatusFailed,
+}
+
+// TwilioMessageResponse is the response of the Twilio API to an accepted message.
+type TwilioMessageResponse struct {
+	SID    string `json:"sid"`
+	Status string `json:"status"`
+}
+
+// TwilioErrorResponse is the response of the Twilio API to a rejected message.
+type TwilioErrorResponse struct {
+	Status  int    `json:"status"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type TwilioSender struct {
+	logger     *log.Entry
+	AccountSID string
+	AuthToken  string
+
+	// StatusCallback is the URL receiving the status callbacks of the messages (none by default)
+	StatusCallback string
+
+	httpClient *http.Client
+	proxy      *url.URL
+}
+
+func NewTwilioSender(accountSID, authToken string) (*TwilioSender, error) {
+	ts := &TwilioSender{
+		logger:     logger.WithField("name", "twilioSender"),
+		AccountSID: accountSID,
+		AuthToken:  authToken,
+	}
+	ts.createHttpClient()
+	return ts, nil
+}
+
+// Name returns the name of the Twilio provider.
+// It is a part of the Provider implementation.
+func (ts *TwilioSender) Name() string {
+	return TwilioProvider
+}
+
+func (ts *TwilioSender) Send(msg *protocol.Message) error {
+	sms := new(SMS)
+	if err := json.Unmarshal(msg.Body, sms); err != nil {
+		logger.WithField("error", err.Error()).Error("Could not decode message body to send to twilio")
+		return err
+	}
+
+	params := url.Values{}
+	params.Set("To", sms.To)
+	params.Set("From", sms.From)
+	params.Set("Body", sms.Text)
+	if ts.StatusCallback != "" {
+		params.Set("StatusCallback", ts.statusCallback(msg))
+	}
+	logger.WithField("sms_details", sms).Info("sendSms")
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(TwilioURL, ts.AccountSID), strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(ts.AccountSID, ts.AuthToken)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := ts.httpClient.Do(req)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Error doing the request to twilio endpoint")
+		ts.createHttpClient()
+		mTotalSendErrors.Add(1)
+		return ErrNoSMSSent
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Error reading the twilio body response")
+		mTotalResponseInternalErrors.Add(1)
+		return ErrSMSResponseDecodingFailed
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return checkTwilioError(resp.StatusCode, respBody)
+	}
+
+	var response TwilioMessageResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		logger.WithField("error", err.Error()).Error("Error decoding the response from twilio endpoint")
+		mTotalResponseInternalErrors.Add(1)
+		return ErrSMSResponseDecodingFailed
+	}
+	logger.WithField("messageResponse", response).Info("Actual twilio response")
+	return nil
+}
+
+// checkTwilioError returns an error for the rejected requests worth retrying: the throttled and the failed ones.
+// The other rejected requests are only logged.
+func checkTwilioError(statusCode int, body []byte) error {
+	var response TwilioErrorResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		logger.WithField("error", err.Error()).Error("Error decoding the error response from twilio endpoint")
+	}
+	logger.WithFields(log.Fields{
+		"httpStatus": statusCode,
+		"code":       response.Code,
+		"error":      response.Message,
+	}).Error("Error received from Twilio")
+
+	if statusCode == http.StatusTooManyRequests || statusCode >= 500 {
+		return ErrIncompleteSMSSent
+	}
+	return nil
+}
+
+// statusCallback returns the status callback URL of a message, referring the message.
+func (ts *TwilioSender) statusCallback(msg *protocol.Message) string {
+	separator := "?"
+	if strings.Contains(ts.StatusCallback, "?") {
+		separator = "&"
+	}
+	return ts.StatusCallback + separator + "ref=" + url.QueryEscape(clientReference(msg))
+}
+
+// ParseReport decodes a Twilio status callback.
+// It is a part of the Provider implementation.
+func (ts *TwilioSender) ParseReport(r *http.Request) (*DeliveryReport, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, ErrInvalidReport
+	}
+	if r.Form.Get("MessageSid") == "" || r.Form.Get("MessageStatus") == "" {
+		return nil, ErrInvalidReport
+	}
+
+	report := &DeliveryReport{
+		Provider:          TwilioProvider,
+		ProviderMessageID: r.Form.Get("MessageSid"),
+		To:                r.Form.Get("To"),
+		Status:            StatusUnknown,
+		ErrorCode:         r.Form.Get("ErrorCode"),
+	}
+	if status, ok := twilioReportStatuses[r.Form.Get("MessageStatus")]; ok {
+		report.Status = status
+	}
+	if err := report.setClientReference(r.Form.Get("ref")); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// SetProxy routes the requests to Twilio through the proxy (directly, if nil).
+func (ts *TwilioSender) SetProxy(proxyURL *url.URL) {
+	ts.proxy = proxyURL
+	ts.createHttpClient()
+}
+
+func (ts *TwilioSender) createHttpClient() {
+	logger.Info("Recreating HTTP client for twilio sender")
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: MaxIdleConnections,
+	}
+	proxy.Configure(transport, ts.proxy)
+	ts.httpClient = &http.Client{
+		Transport: transport,
+		Timeout:   RequestTimeout,
+	}
+}
@@ -0,0 +1,102 @@
+package sms
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTwilioSender_Send(t *testing.T) {
+	a := assert.New(t)
+
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("/Accounts/sid/Messages.json", r.URL.Path)
+		user, password, ok := r.BasicAuth()
+		a.True(ok)
+		a.Equal("sid", user)
+		a.Equal("token", password)
+
+		a.NoError(r.ParseForm())
+		form = r.PostForm
+		switch form.Get("To") {
+		case "+4917012345678":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"sid": "SM123", "status": "queued"}`))
+		case "invalid":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code": 21211, "message": "Invalid 'To' Phone Number", "status": 400}`))
+		default:
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"code": 20429, "message": "Too Many Requests", "status": 429}`))
+		}
+	}))
+	defer server.Close()
+	defer func(url string) { TwilioURL = url }(TwilioURL)
+	TwilioURL = server.URL + "/Accounts/%s/Messages.json"
+
+	sender, err := NewTwilioSender("sid", "token")
+	a.NoError(err)
+	sender.StatusCallback = "http://guble.example.com/sms/receipts/twilio"
+
+	a.NoError(sender.Send(smsMessage(a, SMS{To: "+4917012345678", From: "guble", Text: "body"})))
+	a.Equal("+4917012345678", form.Get("To"))
+	a.Equal("guble", form.Get("From"))
+	a.Equal("body", form.Get("Body"))
+	a.Equal("http://guble.example.com/sms/receipts/twilio?ref=4%3Asamsa", form.Get("StatusCallback"))
+
+	// the rejected messages are not retried, unlike the throttled ones
+	a.NoError(sender.Send(smsMessage(a, SMS{To: "invalid", From: "guble", Text: "body"})))
+	a.Equal(ErrIncompleteSMSSent, sender.Send(smsMessage(a, SMS{To: "throttled", From: "guble", Text: "body"})))
+}
+
+func TestTwilioSender_ParseReport(t *testing.T) {
+	a := assert.New(t)
+	sender, err := NewTwilioSender("sid", "token")
+	a.NoError(err)
+
+	form := url.Values{}
+	form.Set("MessageSid", "SM123")
+	form.Set("MessageStatus", "undelivered")
+	form.Set("To", "+4917012345678")
+	form.Set("ErrorCode", "30003")
+	req := httptest.NewRequest(http.MethodPost, "/sms/receipts/twilio?ref=4%3Asamsa", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	report, err := sender.ParseReport(req)
+	a.NoError(err)
+	a.Equal(&DeliveryReport{
+		Provider:          TwilioProvider,
+		ProviderMessageID: "SM123",
+		To:                "+4917012345678",
+		Status:            StatusFailed,
+		ErrorCode:         "30003",
+		MessageID:         4,
+		UserID:            "samsa",
+	}, report)
+	a.Equal(protocol.Path("/receipts/sms/samsa"), report.Path())
+
+	// a status callback without the reference of the message
+	req = httptest.NewRequest(http.MethodPost, "/sms/receipts/twilio", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err = sender.ParseReport(req)
+	a.Equal(ErrInvalidReport, err)
+}
+
+func smsMessage(a *assert.Assertions, sms SMS) *protocol.Message {
+	d, err := json.Marshal(&sms)
+	a.NoError(err)
+	return &protocol.Message{
+		Path:          protocol.Path(SMSDefaultTopic),
+		UserID:        "samsa",
+		ApplicationID: "sms",
+		ID:            uint64(4),
+		Body:          d,
+	}
+}
@@ -0,0 +1,60 @@
+// Package archive provides the cold storages, into which the file message store moves its old message files.
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "archive")
+
+// ErrNotFound is returned when an object does not exist in the archive.
+var ErrNotFound = errors.New("Object not found in the archive.")
+
+// Archive is a cold storage of named objects.
+// The names are relative paths, separated by slashes.
+type Archive interface {
+
+	// Put stores the content under the name, replacing an existing object
+	Put(name string, content io.ReadSeeker) error
+
+	// Get returns the content of the object, or ErrNotFound
+	Get(name string) (io.ReadCloser, error)
+
+	// Delete removes the object; deleting a missing object is not an error
+	Delete(name string) error
+}
+
+// New returns the archive located by the URL:
+// file:///path for a directory, s3://bucket/prefix for Amazon S3
+// and gs://bucket/prefix for Google Cloud Storage (using its S3-compatible API with HMAC keys).
+// The endpoint and the region of the config are defaulted according to the scheme.
+func New(rawurl string, config S3Config) (Archive, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		return NewDirectory(u.Path), nil
+	case "s3", "gs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("archive: missing bucket in %q", rawurl)
+		}
+		config.Bucket = u.Host
+		config.Prefix = strings.Trim(u.Path, "/")
+		if config.Region == "" {
+			config.Region = defaultRegions[u.Scheme]
+		}
+		if config.Endpoint == "" {
+			config.Endpoint = defaultEndpoint(u.Scheme, config.Region)
+		}
+		return NewS3(config), nil
+	}
+	return nil, fmt.Errorf("archive: unsupported scheme in %q", rawurl)
+}
@@ -0,0 +1,65 @@
+package archive
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// directory is an Archive storing the objects as files below a directory,
+// e.g. on a network file system or a mounted bucket.
+type directory struct {
+	dir string
+}
+
+// NewDirectory returns an Archive storing the objects as files below the directory.
+func NewDirectory(dir string) Archive {
+	return &directory{dir: dir}
+}
+
+func (d *directory) Put(name string, content io.ReadSeeker) error {
+	filename := d.filename(name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+
+	// the content is written into a temporary file, which replaces the object only when complete
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+func (d *directory) Get(name string) (io.ReadCloser, error) {
+	file, err := os.Open(d.filename(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (d *directory) Delete(name string) error {
+	err := os.Remove(d.filename(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (d *directory) filename(name string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(name))
+}
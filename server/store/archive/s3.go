@@ -0,0 +1,252 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	amzDateFormat   = "20060102T150405Z"
+	emptyBodySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+var defaultRegions = map[string]string{
+	"s3": "us-east-1",
+	"gs": "auto",
+}
+
+func defaultEndpoint(scheme, region string) string {
+	if scheme == "gs" {
+		return "https://storage.googleapis.com"
+	}
+	if region == "us-east-1" {
+		return "https://s3.amazonaws.com"
+	}
+	return "https://s3." + region + ".amazonaws.com"
+}
+
+// S3Config is used for configuring an archive in a bucket of Amazon S3, or of another S3-compatible storage.
+type S3Config struct {
+	// Endpoint is the base URL of the storage, e.g. https://s3.eu-central-1.amazonaws.com
+	Endpoint string
+
+	// Region is the region of the bucket, used for signing the requests
+	Region string
+
+	Bucket string
+
+	// Prefix is prepended to the names of the objects
+	Prefix string
+
+	AccessKey string
+	SecretKey string
+
+	// Client is used for the requests (default: http.DefaultClient)
+	Client *http.Client
+}
+
+// s3 is an Archive in a bucket of an S3-compatible storage, accessed by path-style URLs
+// and authenticated by AWS signature version 4.
+type s3 struct {
+	config S3Config
+}
+
+// NewS3 returns an Archive in a bucket of an S3-compatible storage.
+func NewS3(config S3Config) Archive {
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &s3{config: config}
+}
+
+func (s *s3) Put(name string, content io.ReadSeeker) error {
+	hash := sha256.New()
+	size, err := io.Copy(hash, content)
+	if err != nil {
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, s.url(name), ioutil.NopCloser(content))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := s.do(req, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3) Get(name string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, emptyBodySHA256)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3) Delete(name string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.url(name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyBodySHA256)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3) url(name string) string {
+	key := name
+	if s.config.Prefix != "" {
+		key = s.config.Prefix + "/" + name
+	}
+	return s.config.Endpoint + "/" + uriEncode(s.config.Bucket, false) + "/" + uriEncode(key, false)
+}
+
+// do signs and sends the request, returning the response if it is successful.
+func (s *s3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	sign(req, s.config, time.Now())
+
+	resp, err := s.config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	logger.WithFields(log.Fields{
+		"method": req.Method,
+		"url":    req.URL.String(),
+		"status": resp.StatusCode,
+		"body":   string(body),
+	}).Error("Error response of the archive")
+	return nil, fmt.Errorf("archive: %s %s: %s", req.Method, req.URL.Path, resp.Status)
+}
+
+// sign adds the authorization header of the AWS signature version 4 to the request,
+// signing the host and all the headers already set. The x-amz-content-sha256 header has to be set.
+func sign(req *http.Request, config S3Config, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	req.Header.Set("x-amz-date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := &bytes.Buffer{}
+	for _, name := range names {
+		fmt.Fprintf(canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+
+	scope := strings.Join([]string{amzDate[:8], config.Region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+config.SecretKey), amzDate[:8])
+	for _, part := range []string{config.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		config.AccessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode escapes all characters except the unreserved ones, and the slashes unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	encoded := &bytes.Buffer{}
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,228 @@
+package filestore
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	defaultArchiveAge        = 7 * 24 * time.Hour
+	defaultArchiveCacheFiles = 4
+
+	// archiveCacheDir is the directory of a partition, holding the archived message files fetched back
+	archiveCacheDir = "archived"
+)
+
+// archiveInterval is the interval of checking the loaded partitions for message files to archive
+var archiveInterval = time.Minute
+
+func (c Config) archiveAge() time.Duration {
+	if c.ArchiveAge <= 0 {
+		return defaultArchiveAge
+	}
+	return c.ArchiveAge
+}
+
+func (c Config) archiveCacheFiles() int {
+	if c.ArchiveCacheFiles <= 0 {
+		return defaultArchiveCacheFiles
+	}
+	return c.ArchiveCacheFiles
+}
+
+// archiveLoop periodically archives the old message files of the loaded partitions, until the store is stopped.
+func (fms *FileMessageStore) archiveLoop() {
+	defer fms.archiveWG.Done()
+
+	ticker := time.NewTicker(archiveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fms.archive()
+		case <-fms.stopC:
+			return
+		}
+	}
+}
+
+// archive moves the complete message files, which were last written before the archive age, into the archive.
+func (fms *FileMessageStore) archive() {
+	fms.mutex.RLock()
+	var partitions []*messagePartition
+	for _, entry := range fms.partitions {
+		if entry.ready() && entry.err == nil {
+			partitions = append(partitions, entry.partition)
+		}
+	}
+	fms.mutex.RUnlock()
+
+	before := time.Now().Add(-fms.config.archiveAge())
+	for _, p := range partitions {
+		if _, err := p.archiveFiles(before); err != nil {
+			logger.WithError(err).WithField("partition", p.name).Error("Error archiving message files")
+		}
+	}
+}
+
+// archiveFiles uploads the complete message files last written before the given time, and removes them from the disk.
+// The index files are kept, so that the archived messages are still found; the message files are fetched back on reading.
+// It returns the number of archived files.
+func (p *messagePartition) archiveFiles(before time.Time) (int, error) {
+	archived := 0
+	for fileID := range p.fileCache.snapshot() {
+		filename := p.composeMsgFilenameForPosition(uint64(fileID))
+		stat, err := os.Stat(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return archived, err
+		}
+		if !stat.ModTime().Before(before) {
+			continue
+		}
+
+		if err := p.archiveFile(fileID, filename); err != nil {
+			return archived, err
+		}
+		archived++
+		mTotalArchivedFiles.Add(1)
+		logger.WithFields(log.Fields{
+			"partition": p.name,
+			"filename":  filename,
+			"size":      stat.Size(),
+		}).Info("Archived message file")
+	}
+	return archived, nil
+}
+
+func (p *messagePartition) archiveFile(fileID int, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := p.config.Archive.Put(p.archiveName(fileID), file); err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+// openMsgFile opens a message file of the partition.
+// An archived message file is fetched from the archive into the local cache of the partition,
+// from which the least recently used files are removed.
+func (p *messagePartition) openMsgFile(fileID int) (*os.File, error) {
+	file, err := os.Open(p.composeMsgFilenameForPosition(uint64(fileID)))
+	if err == nil || !os.IsNotExist(err) || p.config.Archive == nil {
+		return file, err
+	}
+
+	p.archiveMutex.Lock()
+	defer p.archiveMutex.Unlock()
+
+	cached := filepath.Join(p.basedir, archiveCacheDir, filepath.Base(p.composeMsgFilenameForPosition(uint64(fileID))))
+	if file, err := os.Open(cached); err == nil {
+		now := time.Now()
+		os.Chtimes(cached, now, now)
+		mTotalArchiveCacheHits.Add(1)
+		return file, nil
+	}
+
+	if err := p.fetchArchived(fileID, cached); err != nil {
+		return nil, err
+	}
+	mTotalArchiveFetches.Add(1)
+	if err := p.trimArchiveCache(); err != nil {
+		logger.WithError(err).WithField("partition", p.name).Error("Error trimming the cache of archived files")
+	}
+	return os.Open(cached)
+}
+
+// fetchArchived downloads an archived message file into the given file.
+func (p *messagePartition) fetchArchived(fileID int, filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	r, err := p.config.Archive.Get(p.archiveName(fileID))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	logger.WithFields(log.Fields{
+		"partition": p.name,
+		"filename":  filename,
+		"size":      size,
+	}).Info("Fetched archived message file")
+	return os.Rename(tmp.Name(), filename)
+}
+
+// trimArchiveCache removes the least recently used files beyond the limit from the cache of archived files.
+// The files which are still open are readable until they are closed.
+func (p *messagePartition) trimArchiveCache() error {
+	files, err := ioutil.ReadDir(filepath.Join(p.basedir, archiveCacheDir))
+	if err != nil {
+		return err
+	}
+	if len(files) <= p.config.archiveCacheFiles() {
+		return nil
+	}
+	sort.Sort(filesByLastUsed(files))
+	for _, file := range files[p.config.archiveCacheFiles():] {
+		if err := os.Remove(filepath.Join(p.basedir, archiveCacheDir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeArchived deletes the archived message files of the partition from the archive.
+func (p *messagePartition) removeArchived() error {
+	if p.config.Archive == nil {
+		return nil
+	}
+	for fileID := range p.fileCache.snapshot() {
+		if _, err := os.Stat(p.composeMsgFilenameForPosition(uint64(fileID))); !os.IsNotExist(err) {
+			continue
+		}
+		if err := p.config.Archive.Delete(p.archiveName(fileID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filesByLastUsed sorts the files of the cache by their modification time, the most recently used first.
+type filesByLastUsed []os.FileInfo
+
+func (f filesByLastUsed) Len() int           { return len(f) }
+func (f filesByLastUsed) Less(i, j int) bool { return f[i].ModTime().After(f[j].ModTime()) }
+func (f filesByLastUsed) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// archiveName returns the name of an archived message file: the partition directory and the filename.
+func (p *messagePartition) archiveName(fileID int) string {
+	return p.name + "/" + filepath.Base(p.composeMsgFilenameForPosition(uint64(fileID)))
+}
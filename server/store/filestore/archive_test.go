@@ -0,0 +1,120 @@
+package filestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/store/archive"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Archive_filesAreFetchedBack(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(3)
+
+	dir, _ := ioutil.TempDir("", "guble_archive_test")
+	defer os.RemoveAll(dir)
+	archiveDir, _ := ioutil.TempDir("", "guble_archive_test")
+	defer os.RemoveAll(archiveDir)
+
+	p, err := newMessagePartition(dir, "p1", Config{Archive: archive.NewDirectory(archiveDir), ArchiveCacheFiles: 1})
+	a.NoError(err)
+	defer p.Close()
+
+	for id := 1; id <= 10; id++ {
+		a.NoError(p.Store(uint64(id), []byte(fmt.Sprintf("%d", id))))
+	}
+
+	// the files written after the given time are not archived
+	archived, err := p.archiveFiles(time.Now().Add(-time.Hour))
+	a.NoError(err)
+	a.Equal(0, archived)
+
+	// only the complete files are archived, and the index files are kept
+	archived, err = p.archiveFiles(time.Now().Add(time.Hour))
+	a.NoError(err)
+	a.Equal(3, archived)
+	for fileID := uint64(0); fileID < 3; fileID++ {
+		_, err := os.Stat(p.composeMsgFilenameForPosition(fileID))
+		a.True(os.IsNotExist(err))
+		_, err = os.Stat(p.composeIdxFilenameForPosition(fileID))
+		a.NoError(err)
+		_, err = os.Stat(path.Join(archiveDir, "p1", fmt.Sprintf("p1-%020d.msg", fileID)))
+		a.NoError(err)
+	}
+	_, err = os.Stat(p.composeMsgFilenameForPosition(3))
+	a.NoError(err)
+
+	// the archived messages are fetched transparently, keeping the configured number of files in the cache
+	a.Equal([]string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}, fetchAll(a, p))
+	files, err := ioutil.ReadDir(path.Join(dir, archiveCacheDir))
+	a.NoError(err)
+	a.Equal(1, len(files))
+	a.Equal(uint64(10), p.Count())
+
+	// the archived files are removed from the archive, together with the partition
+	a.NoError(p.removeArchived())
+	files, err = ioutil.ReadDir(path.Join(archiveDir, "p1"))
+	a.NoError(err)
+	a.Equal(0, len(files))
+}
+
+func Test_Archive_loopArchivesLoadedPartitions(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(3)
+	defer func(interval time.Duration) { archiveInterval = interval }(archiveInterval)
+	archiveInterval = 10 * time.Millisecond
+
+	dir, _ := ioutil.TempDir("", "guble_archive_test")
+	defer os.RemoveAll(dir)
+	archiveDir, _ := ioutil.TempDir("", "guble_archive_test")
+	defer os.RemoveAll(archiveDir)
+
+	mStore := NewWithConfig(dir, Config{Archive: archive.NewDirectory(archiveDir), ArchiveAge: time.Millisecond})
+	for id := 1; id <= 4; id++ {
+		a.NoError(mStore.Store("p1", uint64(id), []byte("aaaaaaaaaa")))
+	}
+	time.Sleep(100 * time.Millisecond)
+	a.NoError(mStore.Stop())
+
+	_, err := os.Stat(path.Join(archiveDir, "p1", fmt.Sprintf("p1-%020d.msg", 0)))
+	a.NoError(err)
+	_, err = os.Stat(path.Join(dir, "p1", fmt.Sprintf("p1-%020d.msg", 0)))
+	a.True(os.IsNotExist(err))
+}
+
+func fetchAll(a *assert.Assertions, p *messagePartition) []string {
+	req := &store.FetchRequest{
+		Partition: p.name,
+		StartID:   0,
+		Direction: 1,
+		Count:     math.MaxInt32,
+		MessageC:  make(chan *store.FetchedMessage, store.FetchBufferSize),
+		ErrorC:    make(chan error, 1),
+		StartC:    make(chan int, 1),
+	}
+	p.Fetch(req)
+
+	var messages []string
+	for {
+		select {
+		case fetched, open := <-req.MessageC:
+			if !open {
+				return messages
+			}
+			messages = append(messages, string(fetched.Message))
+		case err := <-req.ErrorC:
+			a.NoError(err)
+			return messages
+		case <-time.After(time.Second):
+			a.FailNow("timeout")
+		}
+	}
+}
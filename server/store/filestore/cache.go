@@ -2,8 +2,6 @@ package filestore
 
 import (
 	"sync"
-
-	"github.com/smancke/guble/server/store"
 )
 
 type cache struct {
@@ -32,19 +30,16 @@ func (c *cache) add(entry *cacheEntry) {
 	c.entries = append(c.entries, entry)
 }
 
-type cacheEntry struct {
-	min, max uint64
+// snapshot returns a copy of the current entries
+func (c *cache) snapshot() []*cacheEntry {
+	c.RLock()
+	defer c.RUnlock()
+
+	entries := make([]*cacheEntry, len(c.entries))
+	copy(entries, c.entries)
+	return entries
 }
 
-// Contains returns true if the req.StartID is between the min and max
-// There is a chance the request messages to be found in this range
-func (entry *cacheEntry) Contains(req *store.FetchRequest) bool {
-	if req.StartID == 0 {
-		req.Direction = 1
-		return true
-	}
-	if req.Direction >= 0 {
-		return req.StartID >= entry.min && req.StartID <= entry.max
-	}
-	return req.StartID >= entry.min
+type cacheEntry struct {
+	min, max uint64
 }
@@ -0,0 +1,289 @@
+package filestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/smancke/guble/server/store"
+)
+
+const (
+	// fileHeaderSize is the size of the header of a .msg file: the magic number and the file format version
+	fileHeaderSize = 9
+
+	// messageHeaderSizeV1 is the size of the header of each message in the version 1 files: the size and the id
+	messageHeaderSizeV1 = 12
+
+	// messageHeaderSize is the size of the header of each message: the size, the id and the CRC-32 of the message
+	messageHeaderSize = 16
+)
+
+// ErrCorruptMessage is returned when a stored message cannot be read back intact.
+var ErrCorruptMessage = errors.New("The stored message is corrupt.")
+
+// messageHeader returns the header written before a message in a .msg file of the given format version.
+func messageHeader(version byte, id uint64, data []byte) []byte {
+	if version < 2 {
+		header := make([]byte, messageHeaderSizeV1)
+		binary.LittleEndian.PutUint32(header, uint32(len(data)))
+		binary.LittleEndian.PutUint64(header[4:], id)
+		return header
+	}
+	header := make([]byte, messageHeaderSize)
+	binary.LittleEndian.PutUint32(header, uint32(len(data)))
+	binary.LittleEndian.PutUint64(header[4:], id)
+	binary.LittleEndian.PutUint32(header[12:], crc32.ChecksumIEEE(data))
+	return header
+}
+
+func messageHeaderSizeFor(version byte) int {
+	if version < 2 {
+		return messageHeaderSizeV1
+	}
+	return messageHeaderSize
+}
+
+// readFileVersion returns the format version of a .msg file, after checking its magic number.
+func readFileVersion(file *os.File) (byte, error) {
+	header := make([]byte, fileHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return 0, ErrCorruptMessage
+		}
+		return 0, err
+	}
+	if !bytes.Equal(header[:len(magicNumber)], magicNumber) {
+		return 0, ErrCorruptMessage
+	}
+	return header[len(magicNumber)], nil
+}
+
+// readMessage reads the message of an index entry, verifying its header and its checksum if the file has one.
+func readMessage(file *os.File, version byte, index *index) ([]byte, error) {
+	headerSize := messageHeaderSizeFor(version)
+	if index.offset < uint64(fileHeaderSize+headerSize) {
+		return nil, ErrCorruptMessage
+	}
+
+	buffer := make([]byte, headerSize+int(index.size))
+	if _, err := file.ReadAt(buffer, int64(index.offset)-int64(headerSize)); err != nil {
+		if err == io.EOF {
+			return nil, ErrCorruptMessage
+		}
+		return nil, err
+	}
+
+	msg := buffer[headerSize:]
+	if binary.LittleEndian.Uint32(buffer) != index.size ||
+		binary.LittleEndian.Uint64(buffer[4:]) != index.id ||
+		(version >= 2 && binary.LittleEndian.Uint32(buffer[12:]) != crc32.ChecksumIEEE(msg)) {
+		return nil, ErrCorruptMessage
+	}
+	return msg, nil
+}
+
+// scanMessages walks through the messages of a .msg file,
+// and returns the end of the last message which is completely written (and has a valid checksum).
+func scanMessages(file *os.File) (int64, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	version, err := readFileVersion(file)
+	if err == ErrCorruptMessage {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	headerSize := int64(messageHeaderSizeFor(version))
+	position := int64(fileHeaderSize)
+	header := make([]byte, headerSize)
+	for position+headerSize <= stat.Size() {
+		if _, err := file.ReadAt(header, position); err != nil {
+			return 0, err
+		}
+		size := int64(binary.LittleEndian.Uint32(header))
+		if position+headerSize+size > stat.Size() {
+			break
+		}
+		if version >= 2 {
+			msg := make([]byte, size)
+			if _, err := file.ReadAt(msg, position+headerSize); err != nil {
+				return 0, err
+			}
+			if binary.LittleEndian.Uint32(header[12:]) != crc32.ChecksumIEEE(msg) {
+				break
+			}
+		}
+		position += headerSize + size
+	}
+	return position, nil
+}
+
+// truncateTornTail scans the last .msg file of the partition, and truncates the torn tail left by a crash:
+// the incompletely written messages are removed from the .msg file, and their entries from the .idx file.
+func (p *messagePartition) truncateTornTail() error {
+	fileID, ok, err := p.lastFileID()
+	if err != nil || !ok {
+		return err
+	}
+
+	msgFile, err := os.OpenFile(p.composeMsgFilenameForPosition(fileID), os.O_RDWR, 0666)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer msgFile.Close()
+
+	end, err := scanMessages(msgFile)
+	if err != nil {
+		return err
+	}
+	stat, err := msgFile.Stat()
+	if err != nil {
+		return err
+	}
+	if end < stat.Size() {
+		if err := msgFile.Truncate(end); err != nil {
+			return err
+		}
+		if err := msgFile.Sync(); err != nil {
+			return err
+		}
+	}
+
+	removed, err := p.truncateIndex(fileID, end)
+	if err != nil {
+		return err
+	}
+
+	if end < stat.Size() || removed > 0 {
+		mTotalTruncatedBytes.Add(stat.Size() - end)
+		logger.WithFields(log.Fields{
+			"filename":       msgFile.Name(),
+			"truncatedBytes": stat.Size() - end,
+			"removedEntries": removed,
+		}).Warn("Truncated the torn tail of a message file")
+	}
+	return nil
+}
+
+// truncateIndex removes the entries of an .idx file referring messages beyond the given end of the .msg file,
+// and the incompletely written entry at the end of the file. It returns the number of the removed entries.
+func (p *messagePartition) truncateIndex(fileID uint64, end int64) (int, error) {
+	idxFile, err := os.OpenFile(p.composeIdxFilenameForPosition(fileID), os.O_RDWR, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer idxFile.Close()
+
+	stat, err := idxFile.Stat()
+	if err != nil {
+		return 0, err
+	}
+	entries := uint64(stat.Size() / int64(indexEntrySize))
+
+	kept := uint64(0)
+	for i := uint64(0); i < entries; i++ {
+		id, offset, size, err := readIndexEntry(idxFile, int64(i*uint64(indexEntrySize)))
+		if err != nil {
+			return 0, err
+		}
+		if int64(offset)+int64(size) > end {
+			continue
+		}
+		if kept != i {
+			if err := writeIndexEntry(idxFile, id, offset, size, kept); err != nil {
+				return 0, err
+			}
+		}
+		kept++
+	}
+
+	if keptSize := int64(kept) * int64(indexEntrySize); keptSize < stat.Size() {
+		if err := idxFile.Truncate(keptSize); err != nil {
+			return 0, err
+		}
+		if err := idxFile.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	return int(entries - kept), nil
+}
+
+// lastFileID returns the ID of the .idx file with the biggest name, if the partition has any.
+func (p *messagePartition) lastFileID() (uint64, bool, error) {
+	files, err := ioutil.ReadDir(p.basedir)
+	if err != nil {
+		return 0, false, err
+	}
+
+	lastName := ""
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), p.name+"-") && strings.HasSuffix(file.Name(), ".idx") {
+			lastName = file.Name()
+		}
+	}
+	if lastName == "" {
+		return 0, false, nil
+	}
+
+	fileID, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(lastName, p.name+"-"), filepath.Ext(lastName)), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return fileID, true, nil
+}
+
+// Check verifies all the stored messages of the partition, and returns the ranges of consecutive corrupt messages.
+// It is a part of the `store.PartitionChecker` implementation.
+func (p *messagePartition) Check() ([]*store.CorruptRange, error) {
+	reader := &messageReader{p: p}
+	defer reader.close()
+
+	var ranges []*store.CorruptRange
+	var current *store.CorruptRange
+	err := p.fetchEntries(&store.FetchRequest{StartID: 0, Direction: 1, Count: math.MaxInt32}, func(int) {}, func(index *index) error {
+		_, err := reader.read(index)
+		if err == nil {
+			current = nil
+			return nil
+		}
+		if err != ErrCorruptMessage {
+			return err
+		}
+
+		if current == nil {
+			current = &store.CorruptRange{Partition: p.name, FromID: index.id}
+			ranges = append(ranges, current)
+		}
+		current.ToID = index.id
+		current.Count++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ranges) > 0 {
+		logger.WithFields(log.Fields{
+			"partition": p.name,
+			"ranges":    len(ranges),
+		}).Error("Found corrupt messages")
+	}
+	return ranges, nil
+}
@@ -0,0 +1,121 @@
+package filestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/smancke/guble/server/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Checksum_verifiedOnRead(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(10)
+
+	dir, _ := ioutil.TempDir("", "guble_checksum_test")
+	defer os.RemoveAll(dir)
+
+	mStore, err := newMessagePartition(dir, "myMessages", Config{})
+	a.NoError(err)
+	defer mStore.Close()
+	for id := uint64(1); id <= 5; id++ {
+		a.NoError(mStore.Store(id, []byte("aaaaaaaaaa")))
+	}
+
+	// the messages 2 and 3 are corrupted on the disk: header 9 bytes, then 16 bytes + 10 bytes per message
+	msgFile, err := os.OpenFile(path.Join(dir, "myMessages-00000000000000000000.msg"), os.O_RDWR, 0666)
+	a.NoError(err)
+	_, err = msgFile.WriteAt([]byte("b"), 9+26+16+3)
+	a.NoError(err)
+	_, err = msgFile.WriteAt([]byte("c"), 9+2*26+16)
+	a.NoError(err)
+	a.NoError(msgFile.Close())
+
+	reader := &messageReader{p: mStore}
+	defer reader.close()
+	msg, err := reader.read(mStore.list.get(0))
+	a.NoError(err)
+	a.Equal([]byte("aaaaaaaaaa"), msg)
+	_, err = reader.read(mStore.list.get(1))
+	a.Equal(ErrCorruptMessage, err)
+
+	ranges, err := mStore.Check()
+	a.NoError(err)
+	a.Equal([]*store.CorruptRange{{Partition: "myMessages", FromID: 2, ToID: 3, Count: 2}}, ranges)
+}
+
+func Test_Checksum_truncatesTornTailOnStart(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(10)
+
+	dir, _ := ioutil.TempDir("", "guble_checksum_test")
+	defer os.RemoveAll(dir)
+
+	mStore, err := newMessagePartition(dir, "myMessages", Config{})
+	a.NoError(err)
+	for id := uint64(1); id <= 3; id++ {
+		a.NoError(mStore.Store(id, []byte("aaaaaaaaaa")))
+	}
+	a.NoError(mStore.Close())
+
+	// the last message and its index entry were only partially written
+	msgFilename := path.Join(dir, "myMessages-00000000000000000000.msg")
+	idxFilename := path.Join(dir, "myMessages-00000000000000000000.idx")
+	a.NoError(os.Truncate(msgFilename, 9+3*26-4))
+	a.NoError(os.Truncate(idxFilename, int64(3*indexEntrySize-7)))
+
+	mStore, err = newMessagePartition(dir, "myMessages", Config{ScanOnStart: true})
+	a.NoError(err)
+	defer mStore.Close()
+
+	a.Equal(uint64(2), mStore.Count())
+	a.Equal(uint64(2), mStore.MaxMessageID())
+
+	stat, err := os.Stat(msgFilename)
+	a.NoError(err)
+	a.Equal(int64(9+2*26), stat.Size())
+	entries, err := calculateNoEntries(idxFilename)
+	a.NoError(err)
+	a.Equal(uint64(2), entries)
+
+	// the partition is usable after the truncation
+	a.NoError(mStore.Store(uint64(3), []byte("bbbbbbbbbb")))
+	ranges, err := mStore.Check()
+	a.NoError(err)
+	a.Equal(0, len(ranges))
+}
+
+func Test_Checksum_readsVersion1Files(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(10)
+
+	dir, _ := ioutil.TempDir("", "guble_checksum_test")
+	defer os.RemoveAll(dir)
+
+	fileFormatVersion = []byte{1}
+	mStore, err := newMessagePartition(dir, "myMessages", Config{})
+	a.NoError(err)
+	a.NoError(mStore.Store(uint64(1), []byte("aaaaaaaaaa")))
+	a.NoError(mStore.Close())
+	fileFormatVersion = []byte{2}
+
+	// the messages are appended without checksums to the existing file
+	mStore, err = newMessagePartition(dir, "myMessages", Config{ScanOnStart: true})
+	a.NoError(err)
+	defer mStore.Close()
+	a.NoError(mStore.Store(uint64(2), []byte("bbbbbbbbbb")))
+	a.Equal(uint64(9+12+10+12), mStore.list.get(1).offset)
+
+	reader := &messageReader{p: mStore}
+	defer reader.close()
+	msg, err := reader.read(mStore.list.get(1))
+	a.NoError(err)
+	a.Equal([]byte("bbbbbbbbbb"), msg)
+
+	ranges, err := mStore.Check()
+	a.NoError(err)
+	a.Equal(0, len(ranges))
+}
@@ -0,0 +1,216 @@
+package filestore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/smancke/guble/server/store"
+)
+
+const defaultEvictionIdleTime = 10 * time.Minute
+
+// ErrLimitReached is returned when a new partition exceeds the limits of the store,
+// and no idle partition could be evicted for making room for it.
+var ErrLimitReached = errors.New("The limits of the message store are reached: no idle partition to evict.")
+
+// partitionUsage is the disk usage of a partition, used for choosing the partitions to evict.
+type partitionUsage struct {
+	name     string
+	size     int64
+	lastUsed time.Time
+}
+
+type usagesByLastUsed []*partitionUsage
+
+func (u usagesByLastUsed) Len() int           { return len(u) }
+func (u usagesByLastUsed) Less(i, j int) bool { return u[i].lastUsed.Before(u[j].lastUsed) }
+func (u usagesByLastUsed) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
+func (c Config) limited() bool {
+	return c.MaxPartitions > 0 || c.MaxSize > 0
+}
+
+func (c Config) evictionIdleTime() time.Duration {
+	if c.EvictionIdleTime <= 0 {
+		return defaultEvictionIdleTime
+	}
+	return c.EvictionIdleTime
+}
+
+func (c Config) keeps(partition string) bool {
+	for _, p := range c.KeepPartitions {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
+
+// SetEvictionHandler sets a function, which is called with the info of every evicted partition before removing it.
+// The handler may store messages only into the partitions which are kept (see Config.KeepPartitions).
+func (fms *FileMessageStore) SetEvictionHandler(handler func(*store.PartitionInfo)) {
+	fms.evictionHandler = handler
+}
+
+func (entry *partitionEntry) ready() bool {
+	select {
+	case <-entry.readyC:
+		return true
+	default:
+		return false
+	}
+}
+
+// touch records the use of a partition entry, for evicting the least recently used partitions first.
+func (entry *partitionEntry) touch() {
+	atomic.StoreInt64(&entry.lastUsed, time.Now().UnixNano())
+}
+
+// makeRoom evicts the least recently used idle partitions, until a new partition fits within the limits of the store.
+// It is called with the eviction mutex locked.
+func (fms *FileMessageStore) makeRoom(newPartition string) error {
+	usages, err := fms.usages(newPartition)
+	if err != nil {
+		return err
+	}
+	sort.Sort(usagesByLastUsed(usages))
+
+	count := len(usages) + 1
+	var size int64
+	for _, u := range usages {
+		size += u.size
+	}
+
+	idleSince := time.Now().Add(-fms.config.evictionIdleTime())
+	for _, u := range usages {
+		if fms.withinLimits(count, size) || u.lastUsed.After(idleSince) {
+			break
+		}
+		evicted, err := fms.evict(u.name)
+		if err != nil {
+			return err
+		}
+		if !evicted {
+			continue
+		}
+		count--
+		size -= u.size
+	}
+
+	if !fms.withinLimits(count, size) {
+		logger.WithFields(log.Fields{
+			"partition":  newPartition,
+			"partitions": count - 1,
+			"size":       size,
+		}).Error("Rejecting a new partition, because the limits of the message store are reached")
+		return ErrLimitReached
+	}
+	return nil
+}
+
+func (fms *FileMessageStore) withinLimits(count int, size int64) bool {
+	return (fms.config.MaxPartitions <= 0 || count <= fms.config.MaxPartitions) &&
+		(fms.config.MaxSize <= 0 || size < fms.config.MaxSize)
+}
+
+// usages returns the usages of the partitions on the disk, except the kept ones and the given one.
+// The partitions which are still loading are skipped, so that they are not evicted.
+func (fms *FileMessageStore) usages(except string) ([]*partitionUsage, error) {
+	dirs, err := ioutil.ReadDir(fms.basedir)
+	if err != nil {
+		return nil, err
+	}
+
+	var usages []*partitionUsage
+	for _, dir := range dirs {
+		if !dir.IsDir() || dir.Name() == except || fms.config.keeps(dir.Name()) {
+			continue
+		}
+		u := &partitionUsage{name: dir.Name(), lastUsed: dir.ModTime()}
+
+		files, err := ioutil.ReadDir(path.Join(fms.basedir, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			u.size += file.Size()
+			if file.ModTime().After(u.lastUsed) {
+				u.lastUsed = file.ModTime()
+			}
+		}
+
+		fms.mutex.RLock()
+		entry, exist := fms.partitions[dir.Name()]
+		fms.mutex.RUnlock()
+		if exist {
+			if !entry.ready() {
+				continue
+			}
+			lastUsed := time.Unix(0, atomic.LoadInt64(&entry.lastUsed))
+			if lastUsed.After(u.lastUsed) {
+				u.lastUsed = lastUsed
+			}
+		}
+
+		usages = append(usages, u)
+	}
+	return usages, nil
+}
+
+// evict removes a partition from the store and from the disk, after calling the eviction handler.
+// It is called with the eviction mutex locked, so the partition is not loaded concurrently;
+// a partition which started loading meanwhile is not evicted.
+func (fms *FileMessageStore) evict(partition string) (bool, error) {
+	fms.mutex.Lock()
+	entry, loaded := fms.partitions[partition]
+	if loaded && !entry.ready() {
+		fms.mutex.Unlock()
+		return false, nil
+	}
+	delete(fms.partitions, partition)
+	fms.mutex.Unlock()
+
+	var p *messagePartition
+	if loaded {
+		p = entry.partition
+	} else {
+		var err error
+		if p, err = newMessagePartition(path.Join(fms.basedir, partition), partition, fms.config); err != nil {
+			return false, err
+		}
+	}
+
+	info, err := p.Info()
+	if err != nil {
+		logger.WithError(err).WithField("partition", partition).Error("Error reading the info of an evicted partition")
+		info = &store.PartitionInfo{Partition: partition}
+	}
+	if fms.evictionHandler != nil {
+		fms.evictionHandler(info)
+	}
+
+	fms.cache.remove(partition)
+	if err := p.removeArchived(); err != nil {
+		logger.WithError(err).WithField("partition", partition).Error("Error removing the archived files of an evicted partition")
+	}
+	if err := p.Close(); err != nil {
+		logger.WithError(err).WithField("partition", partition).Error("Error closing an evicted partition")
+	}
+	if err := os.RemoveAll(path.Join(fms.basedir, partition)); err != nil {
+		return false, err
+	}
+
+	mTotalEvictedPartitions.Add(1)
+	logger.WithFields(log.Fields{
+		"partition": partition,
+		"count":     info.Count,
+		"size":      info.Size,
+	}).Info("Evicted partition")
+	return true, nil
+}
@@ -0,0 +1,106 @@
+package filestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/server/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Eviction_leastRecentlyUsedPartition(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(10)
+
+	dir, _ := ioutil.TempDir("", "guble_eviction_test")
+	defer os.RemoveAll(dir)
+
+	mStore := NewWithConfig(dir, Config{MaxPartitions: 2, EvictionIdleTime: time.Millisecond})
+	defer mStore.Stop()
+
+	var evicted []*store.PartitionInfo
+	mStore.SetEvictionHandler(func(info *store.PartitionInfo) {
+		evicted = append(evicted, info)
+	})
+
+	a.NoError(mStore.Store("p1", 1, []byte("aaaaaaaaaa")))
+	a.NoError(mStore.Store("p2", 1, []byte("aaaaaaaaaa")))
+	time.Sleep(10 * time.Millisecond)
+
+	// p1 is used again, so p2 is the least recently used partition
+	a.NoError(mStore.Store("p1", 2, []byte("aaaaaaaaaa")))
+	time.Sleep(10 * time.Millisecond)
+	a.NoError(mStore.Store("p3", 1, []byte("aaaaaaaaaa")))
+
+	a.Equal(1, len(evicted))
+	a.Equal("p2", evicted[0].Partition)
+	a.Equal(uint64(1), evicted[0].Count)
+
+	_, err := os.Stat(path.Join(dir, "p2"))
+	a.True(os.IsNotExist(err))
+	a.Equal(uint64(2), mustMaxMessageID(a, mStore, "p1"))
+	a.Equal(uint64(1), mustMaxMessageID(a, mStore, "p3"))
+
+	// an evicted partition is created again, when it is used
+	time.Sleep(10 * time.Millisecond)
+	a.NoError(mStore.Store("p2", 1, []byte("aaaaaaaaaa")))
+	a.Equal(2, len(evicted))
+	a.Equal("p1", evicted[1].Partition)
+}
+
+func Test_Eviction_rejectsPartitionsWithoutIdlePartitions(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(10)
+
+	dir, _ := ioutil.TempDir("", "guble_eviction_test")
+	defer os.RemoveAll(dir)
+
+	mStore := NewWithConfig(dir, Config{MaxPartitions: 1, EvictionIdleTime: time.Hour, KeepPartitions: []string{"admin"}})
+	defer mStore.Stop()
+
+	a.NoError(mStore.Store("p1", 1, []byte("aaaaaaaaaa")))
+	a.Equal(ErrLimitReached, mStore.Store("p2", 1, []byte("aaaaaaaaaa")))
+
+	_, err := os.Stat(path.Join(dir, "p2"))
+	a.True(os.IsNotExist(err))
+
+	// the kept partitions are not limited
+	a.NoError(mStore.Store("admin", 1, []byte("aaaaaaaaaa")))
+}
+
+func Test_Eviction_maxSize(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(10)
+
+	dir, _ := ioutil.TempDir("", "guble_eviction_test")
+	defer os.RemoveAll(dir)
+
+	mStore := NewWithConfig(dir, Config{MaxSize: 1000, EvictionIdleTime: time.Millisecond})
+	defer mStore.Stop()
+
+	a.NoError(mStore.Store("p1", 1, make([]byte, 600)))
+	a.NoError(mStore.Store("p2", 1, []byte("aaaaaaaaaa")))
+	a.NoError(mStore.Store("p3", 1, make([]byte, 600)))
+	time.Sleep(10 * time.Millisecond)
+	a.NoError(mStore.Store("p3", 2, []byte("aaaaaaaaaa")))
+
+	// the size of the partitions exceeds the limit, so the least recently used ones are evicted until it fits
+	a.NoError(mStore.Store("p4", 1, []byte("aaaaaaaaaa")))
+
+	_, err := os.Stat(path.Join(dir, "p1"))
+	a.True(os.IsNotExist(err))
+	for _, p := range []string{"p2", "p3", "p4"} {
+		_, err = os.Stat(path.Join(dir, p))
+		a.NoError(err)
+	}
+}
+
+func mustMaxMessageID(a *assert.Assertions, mStore *FileMessageStore, partition string) uint64 {
+	id, err := mStore.MaxMessageID(partition)
+	a.NoError(err)
+	return id
+}
@@ -0,0 +1,301 @@
+package filestore
+
+import (
+	"math"
+	"os"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/smancke/guble/server/store"
+)
+
+// fetchSegment is a group of index files with overlapping id ranges.
+// The messages stored out of order are indexed in a file which may overlap the previous ones,
+// so the entries of a segment are loaded and sorted together. The segments of a partition are disjoint:
+// a fetch loads only one of them at a time, so that its memory does not depend on the number of fetched messages.
+type fetchSegment struct {
+	min, max uint64
+	fileIDs  []int
+
+	// current is the list of the current index file, if it belongs to the segment
+	current *indexList
+}
+
+type segmentsByMin []*fetchSegment
+
+func (s segmentsByMin) Len() int           { return len(s) }
+func (s segmentsByMin) Less(i, j int) bool { return s[i].min < s[j].min }
+func (s segmentsByMin) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// fetchRange is the range of ids fetched by a request, and the number of messages within it.
+type fetchRange struct {
+	from, to uint64
+	count    int
+}
+
+func (r fetchRange) overlaps(s *fetchSegment) bool {
+	return s.min <= r.to && s.max >= r.from
+}
+
+func (r fetchRange) includes(s *fetchSegment) bool {
+	return s.min >= r.from && s.max <= r.to
+}
+
+func (r fetchRange) contains(id uint64) bool {
+	return id >= r.from && id <= r.to
+}
+
+// fetchSegments returns the segments of the partition in ascending order.
+// The index files in the file cache are not changed anymore, so only the current list is copied
+// under the partition lock: the writes into the partition are not blocked while fetching.
+func (p *messagePartition) fetchSegments() []*fetchSegment {
+	p.RLock()
+	fileCacheEntries := p.fileCache.snapshot()
+	currentList := p.list.clone()
+	p.RUnlock()
+
+	files := make([]*fetchSegment, 0, len(fileCacheEntries)+1)
+	for i, fce := range fileCacheEntries {
+		files = append(files, &fetchSegment{min: fce.min, max: fce.max, fileIDs: []int{i}})
+	}
+	if currentList.len() > 0 {
+		files = append(files, &fetchSegment{
+			min:     currentList.front().id,
+			max:     currentList.back().id,
+			current: currentList,
+		})
+	}
+	sort.Stable(segmentsByMin(files))
+
+	segments := make([]*fetchSegment, 0, len(files))
+	for _, f := range files {
+		if n := len(segments); n > 0 && f.min <= segments[n-1].max {
+			last := segments[n-1]
+			last.fileIDs = append(last.fileIDs, f.fileIDs...)
+			if f.current != nil {
+				last.current = f.current
+			}
+			if f.max > last.max {
+				last.max = f.max
+			}
+			continue
+		}
+		segments = append(segments, f)
+	}
+	return segments
+}
+
+// loadSegment returns the sorted index entries of a segment.
+func (p *messagePartition) loadSegment(s *fetchSegment) (*indexList, error) {
+	if len(s.fileIDs) == 0 {
+		return s.current, nil
+	}
+	if len(s.fileIDs) == 1 && s.current == nil {
+		return p.loadIndexList(s.fileIDs[0])
+	}
+
+	l := newIndexList(0)
+	for _, fileID := range s.fileIDs {
+		fileList, err := p.loadIndexList(fileID)
+		if err != nil {
+			return nil, err
+		}
+		l.insertList(fileList)
+	}
+	if s.current != nil {
+		l.insertList(s.current)
+	}
+	return l, nil
+}
+
+// segmentEntries returns the number of index entries of a segment, without loading them.
+func (p *messagePartition) segmentEntries(s *fetchSegment) (int, error) {
+	n := 0
+	for _, fileID := range s.fileIDs {
+		entries, err := calculateNoEntries(p.composeIdxFilenameForPosition(uint64(fileID)))
+		if err != nil {
+			return 0, err
+		}
+		n += int(entries)
+	}
+	if s.current != nil {
+		n += s.current.len()
+	}
+	return n, nil
+}
+
+// calculateFetchRange returns the ids and the number of the messages of the fetch request.
+// Only the segments at the bounds of the range are loaded, the entries of the others are counted by the file sizes.
+func (p *messagePartition) calculateFetchRange(segments []*fetchSegment, req *store.FetchRequest) (fetchRange, error) {
+	if req.StartID == 0 || req.Direction == 0 {
+		req.Direction = 1
+	}
+	if req.Direction > 0 {
+		r := fetchRange{from: req.StartID, to: math.MaxUint64}
+		if req.EndID > 0 {
+			r.to = req.EndID
+		}
+		for _, s := range segments {
+			if r.count >= req.Count {
+				break
+			}
+			n, err := p.countSegmentEntries(s, r)
+			if err != nil {
+				return r, err
+			}
+			r.count += n
+		}
+		if r.count > req.Count {
+			r.count = req.Count
+		}
+		return r, nil
+	}
+
+	// backwards, the range is searched from its end, and the messages are fetched in ascending order
+	r := fetchRange{from: req.EndID, to: req.StartID}
+	from := r.to
+	for i := len(segments) - 1; i >= 0 && r.count < req.Count; i-- {
+		s := segments[i]
+		if !r.overlaps(s) {
+			continue
+		}
+		remaining := req.Count - r.count
+		if r.includes(s) {
+			n, err := p.segmentEntries(s)
+			if err != nil {
+				return r, err
+			}
+			if n <= remaining {
+				r.count += n
+				from = s.min
+				continue
+			}
+		}
+
+		l, err := p.loadSegment(s)
+		if err != nil {
+			return r, err
+		}
+		items := l.toSliceArray()
+		j := sort.Search(len(items), func(i int) bool { return items[i].id > r.to })
+		i := sort.Search(j, func(i int) bool { return items[i].id >= r.from })
+		if j-i > remaining {
+			i = j - remaining
+		}
+		if i < j {
+			r.count += j - i
+			from = items[i].id
+		}
+	}
+	r.from = from
+	return r, nil
+}
+
+func (p *messagePartition) countSegmentEntries(s *fetchSegment, r fetchRange) (int, error) {
+	if !r.overlaps(s) {
+		return 0, nil
+	}
+	if r.includes(s) {
+		return p.segmentEntries(s)
+	}
+
+	l, err := p.loadSegment(s)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, index := range l.toSliceArray() {
+		if r.contains(index.id) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// fetchEntries calls start with the number of the index entries of the fetch request,
+// and then fn for each of them in ascending order, loading a single segment at a time.
+func (p *messagePartition) fetchEntries(req *store.FetchRequest, start func(int), fn func(*index) error) error {
+	segments := p.fetchSegments()
+	r, err := p.calculateFetchRange(segments, req)
+	if err != nil {
+		return err
+	}
+	start(r.count)
+
+	fetched := 0
+	for _, s := range segments {
+		if fetched >= r.count {
+			break
+		}
+		if !r.overlaps(s) {
+			continue
+		}
+		l, err := p.loadSegment(s)
+		if err != nil {
+			return err
+		}
+		for _, index := range l.toSliceArray() {
+			if fetched >= r.count {
+				break
+			}
+			if !r.contains(index.id) {
+				continue
+			}
+			if err := fn(index); err != nil {
+				return err
+			}
+			fetched++
+		}
+	}
+	return nil
+}
+
+// messageReader reads messages from the message files of a partition, keeping the last used file open.
+type messageReader struct {
+	p       *messagePartition
+	fileID  int
+	file    *os.File
+	version byte
+}
+
+func (r *messageReader) read(index *index) ([]byte, error) {
+	if r.file == nil || r.fileID != index.fileID {
+		r.close()
+		file, err := r.p.openMsgFile(index.fileID)
+		if err != nil {
+			return nil, err
+		}
+		r.file = file
+		r.fileID = index.fileID
+		if r.version, err = readFileVersion(file); err != nil {
+			r.close()
+			return nil, r.failed(index, err)
+		}
+	}
+
+	msg, err := readMessage(r.file, r.version, index)
+	if err != nil {
+		return nil, r.failed(index, err)
+	}
+	return msg, nil
+}
+
+func (r *messageReader) failed(index *index, err error) error {
+	if err == ErrCorruptMessage {
+		mTotalCorruptMessages.Add(1)
+		logger.WithFields(log.Fields{
+			"partition": r.p.name,
+			"fileID":    index.fileID,
+			"id":        index.id,
+			"offset":    index.offset,
+		}).Error("Read a corrupt message")
+	}
+	return err
+}
+
+func (r *messageReader) close() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
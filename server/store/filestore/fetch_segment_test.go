@@ -0,0 +1,118 @@
+package filestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/server/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_fetchSegments_mergesOverlappingFiles(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(3)
+
+	dir, _ := ioutil.TempDir("", "guble_fetch_segment_test")
+	defer os.RemoveAll(dir)
+
+	mStore, err := newMessagePartition(dir, "myMessages", Config{})
+	a.NoError(err)
+	defer mStore.Close()
+
+	// the second file overlaps the first one, because of the message 2 stored out of order
+	for _, id := range []uint64{1, 3, 4, 5, 2, 6, 7, 8, 9, 10} {
+		a.NoError(mStore.Store(id, []byte("aaaaaaaaaa")))
+	}
+
+	segments := mStore.fetchSegments()
+	a.Equal(3, len(segments))
+
+	a.Equal(uint64(1), segments[0].min)
+	a.Equal(uint64(6), segments[0].max)
+	a.Equal([]int{0, 1}, segments[0].fileIDs)
+	a.Nil(segments[0].current)
+
+	a.Equal(uint64(7), segments[1].min)
+	a.Equal(uint64(9), segments[1].max)
+	a.Equal([]int{2}, segments[1].fileIDs)
+
+	a.Equal(uint64(10), segments[2].min)
+	a.Equal(0, len(segments[2].fileIDs))
+	a.NotNil(segments[2].current)
+
+	l, err := mStore.loadSegment(segments[0])
+	a.NoError(err)
+	ids := []uint64{}
+	for _, index := range l.toSliceArray() {
+		ids = append(ids, index.id)
+	}
+	a.Equal([]uint64{1, 2, 3, 4, 5, 6}, ids)
+}
+
+func Test_Partition_FetchManyFiles(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(10)
+
+	dir, _ := ioutil.TempDir("", "guble_fetch_segment_test")
+	defer os.RemoveAll(dir)
+
+	mStore, err := newMessagePartition(dir, "myMessages", Config{})
+	a.NoError(err)
+	defer mStore.Close()
+
+	for id := 1; id <= 1005; id++ {
+		a.NoError(mStore.Store(uint64(id), []byte(fmt.Sprintf("%d", id))))
+	}
+
+	testCases := []struct {
+		description string
+		req         *store.FetchRequest
+		first, last int
+	}{
+		{`all messages`,
+			&store.FetchRequest{StartID: 0, Direction: 1, Count: math.MaxInt32},
+			1, 1005,
+		},
+		{`forward until the end id`,
+			&store.FetchRequest{StartID: 95, EndID: 512, Direction: 1, Count: math.MaxInt32},
+			95, 512,
+		},
+		{`backward over many files`,
+			&store.FetchRequest{StartID: 1000, Direction: -1, Count: 333},
+			668, 1000,
+		},
+		{`backward including the current file`,
+			&store.FetchRequest{StartID: 1005, Direction: -1, Count: 20},
+			986, 1005,
+		},
+	}
+
+	for _, testcase := range testCases {
+		req := testcase.req
+		req.Partition = "myMessages"
+		req.MessageC = make(chan *store.FetchedMessage, store.FetchBufferSize)
+		req.ErrorC = make(chan error)
+		req.StartC = make(chan int)
+		mStore.Fetch(req)
+
+		select {
+		case n := <-req.StartC:
+			a.Equal(testcase.last-testcase.first+1, n, testcase.description)
+		case <-time.After(time.Second):
+			a.FailNow("timeout", testcase.description)
+		}
+
+		expected := testcase.first
+		for fetched := range req.MessageC {
+			a.Equal(uint64(expected), fetched.ID, testcase.description)
+			a.Equal(fmt.Sprintf("%d", expected), string(fetched.Message), testcase.description)
+			expected++
+		}
+		a.Equal(testcase.last+1, expected, testcase.description)
+	}
+}
@@ -0,0 +1,23 @@
+package filestore
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns                    = metrics.NS("filestore")
+	mTotalCacheHits       = ns.NewInt("total_cache_hits")
+	mTotalCacheMisses     = ns.NewInt("total_cache_misses")
+	mTotalCacheEvictions  = ns.NewInt("total_cache_evictions")
+	mCurrentCacheBytes    = ns.NewInt("current_cache_bytes")
+	mCurrentCacheMessages = ns.NewInt("current_cache_messages")
+
+	mTotalEvictedPartitions = ns.NewInt("total_evicted_partitions")
+
+	mTotalCorruptMessages = ns.NewInt("total_corrupt_messages")
+	mTotalTruncatedBytes  = ns.NewInt("total_truncated_bytes")
+
+	mTotalArchivedFiles    = ns.NewInt("total_archived_files")
+	mTotalArchiveFetches   = ns.NewInt("total_archive_fetches")
+	mTotalArchiveCacheHits = ns.NewInt("total_archive_cache_hits")
+)
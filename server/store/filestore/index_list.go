@@ -5,7 +5,6 @@ import (
 	"sync"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/smancke/guble/server/store"
 )
 
 // IndexList a sorted list of fetch entries
@@ -69,9 +68,22 @@ func (l *indexList) insertElem(elem *index) {
 
 // Clear empties the current list
 func (l *indexList) clear() {
+	l.Lock()
+	defer l.Unlock()
+
 	l.items = make([]*index, 0)
 }
 
+// clone returns a copy of the list, which is not affected by the later changes of this list
+func (l *indexList) clone() *indexList {
+	l.RLock()
+	defer l.RUnlock()
+
+	c := newIndexList(len(l.items))
+	c.items = append(c.items, l.items...)
+	return c
+}
+
 // GetIndexEntryFromID performs a binarySearch retrieving the
 // true, the position and list and the actual entry if found
 // false , -1 ,nil if position is not found
@@ -181,61 +193,6 @@ func (l *indexList) String() string {
 	return s
 }
 
-// Contains returns true if given ID is between first and last item in the list
-func (l *indexList) contains(id uint64) bool {
-	l.RLock()
-	defer l.RUnlock()
-
-	if len(l.items) == 0 {
-		return false
-	}
-
-	if id == 0 {
-		return true
-	}
-
-	return l.items[0].id <= id && id <= l.items[len(l.items)-1].id
-}
-
-// Extract will return a new list containing items requested by the FetchRequest from this list
-func (l *indexList) extract(req *store.FetchRequest) *indexList {
-	potentialEntries := newIndexList(0)
-	found, pos, lastPos, _ := l.search(req.StartID)
-	currentPos := lastPos
-	if found {
-		currentPos = pos
-	}
-
-	for potentialEntries.len() < req.Count && currentPos >= 0 && currentPos < l.len() {
-		elem := l.get(currentPos)
-		logger.WithFields(log.Fields{
-			"elem":       *elem,
-			"currentPos": currentPos,
-			"req":        *req,
-		}).Debug("Elem in retrieve")
-
-		if elem == nil {
-			logger.WithFields(log.Fields{
-				"pos":     currentPos,
-				"l.Len":   l.len(),
-				"len":     potentialEntries.len(),
-				"startID": req.StartID,
-				"count":   req.Count,
-			}).Error("Error in retrieving from list.Got nil entry")
-			break
-		}
-
-		potentialEntries.insert(elem)
-		currentPos += int(req.Direction)
-
-		// // if we reach req.EndID than we break
-		if req.EndID > 0 && elem.id >= req.EndID {
-			break
-		}
-	}
-	return potentialEntries
-}
-
 func abs(m1, m2 uint64) uint64 {
 	if m1 > m2 {
 		return m1 - m2
@@ -0,0 +1,198 @@
+package filestore
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+
+	"github.com/smancke/guble/server/store"
+)
+
+type cachedMessage struct {
+	id   uint64
+	data []byte
+}
+
+// partitionCache holds the most recent messages of a partition, sorted by id (and it is never empty).
+// All the messages of the partition with an id greater or equal to the first cached id are cached.
+type partitionCache struct {
+	name     string
+	messages []cachedMessage
+	size     int64
+}
+
+func (pc *partitionCache) first() uint64 {
+	return pc.messages[0].id
+}
+
+// messageCache keeps the most recent messages of the partitions in memory, so that the fetch requests
+// for them do not hit the disk. The memory budget is shared by all the partitions:
+// when it is exceeded, the messages of the least recently used partitions are evicted first.
+type messageCache struct {
+	maxMessages int
+	maxSize     int64
+
+	size       int64
+	count      int64
+	partitions map[string]*list.Element
+	lru        *list.List
+
+	sync.Mutex
+}
+
+// newMessageCache returns a cache for at most maxMessages messages per partition, using at most maxSize bytes.
+// It returns nil (no caching) if any of the limits is not positive.
+func newMessageCache(maxMessages int, maxSize int64) *messageCache {
+	if maxMessages <= 0 || maxSize <= 0 {
+		return nil
+	}
+	return &messageCache{
+		maxMessages: maxMessages,
+		maxSize:     maxSize,
+		partitions:  make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// add caches a message stored in the partition; maxMessageID is the max id of the partition before storing it.
+// A message is cached only if the cached messages remain the most recent ones of the partition.
+func (c *messageCache) add(partition string, id uint64, data []byte, maxMessageID uint64) {
+	if c == nil || int64(len(data)) > c.maxSize {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	// the caller may reuse its buffer
+	data = append([]byte(nil), data...)
+
+	var pc *partitionCache
+	if e, exist := c.partitions[partition]; exist {
+		pc = e.Value.(*partitionCache)
+		if id < pc.first() {
+			return
+		}
+		c.lru.MoveToFront(e)
+
+		i := sort.Search(len(pc.messages), func(i int) bool { return pc.messages[i].id >= id })
+		if i < len(pc.messages) && pc.messages[i].id == id {
+			return
+		}
+		pc.messages = append(pc.messages, cachedMessage{})
+		copy(pc.messages[i+1:], pc.messages[i:])
+		pc.messages[i] = cachedMessage{id, data}
+	} else {
+		if id <= maxMessageID {
+			return
+		}
+		pc = &partitionCache{name: partition, messages: []cachedMessage{{id, data}}}
+		c.partitions[partition] = c.lru.PushFront(pc)
+	}
+	c.grow(pc, 1, int64(len(data)))
+
+	if len(pc.messages) > c.maxMessages {
+		c.evict(pc, len(pc.messages)-c.maxMessages)
+	}
+	for c.size > c.maxSize {
+		oldest := c.lru.Back().Value.(*partitionCache)
+		c.evict(oldest, 1)
+		mTotalCacheEvictions.Add(1)
+	}
+}
+
+// fetch returns the messages of the request in ascending order, if all of them are cached.
+// As opposed to the index files, the start id is not required to exist:
+// the messages starting from the first id greater (or lower, in backwards direction) than it are returned.
+func (c *messageCache) fetch(partition string, req *store.FetchRequest) ([]cachedMessage, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	fetched, ok := c.lookup(partition, req)
+	if ok {
+		mTotalCacheHits.Add(1)
+		c.lru.MoveToFront(c.partitions[partition])
+	} else {
+		mTotalCacheMisses.Add(1)
+	}
+	return fetched, ok
+}
+
+func (c *messageCache) lookup(partition string, req *store.FetchRequest) ([]cachedMessage, bool) {
+	e, exist := c.partitions[partition]
+	if !exist || req.Count <= 0 {
+		return nil, false
+	}
+	messages := e.Value.(*partitionCache).messages
+	if len(messages) == 0 || req.StartID < messages[0].id {
+		return nil, false
+	}
+
+	if req.Direction >= 0 {
+		i := sort.Search(len(messages), func(i int) bool { return messages[i].id >= req.StartID })
+		j := i
+		for j < len(messages) && j-i < req.Count {
+			j++
+			if req.EndID > 0 && messages[j-1].id >= req.EndID {
+				break
+			}
+		}
+		return copyMessages(messages[i:j]), true
+	}
+
+	// the older messages may be on the disk only, so the cached messages have to be enough for the count
+	if req.EndID > 0 {
+		return nil, false
+	}
+	j := sort.Search(len(messages), func(i int) bool { return messages[i].id > req.StartID })
+	i := j - req.Count
+	if i < 0 {
+		return nil, false
+	}
+	return copyMessages(messages[i:j]), true
+}
+
+// remove drops all the cached messages of a partition.
+func (c *messageCache) remove(partition string) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	if e, exist := c.partitions[partition]; exist {
+		pc := e.Value.(*partitionCache)
+		c.grow(pc, -int64(len(pc.messages)), -pc.size)
+		c.lru.Remove(e)
+		delete(c.partitions, partition)
+	}
+}
+
+// evict drops the n oldest messages of the partition cache, and the partition cache itself when it becomes empty.
+func (c *messageCache) evict(pc *partitionCache, n int) {
+	var size int64
+	for _, m := range pc.messages[:n] {
+		size += int64(len(m.data))
+	}
+	pc.messages = append(pc.messages[:0:0], pc.messages[n:]...)
+	c.grow(pc, -int64(n), -size)
+
+	if len(pc.messages) == 0 {
+		c.lru.Remove(c.partitions[pc.name])
+		delete(c.partitions, pc.name)
+	}
+}
+
+func (c *messageCache) grow(pc *partitionCache, count int64, size int64) {
+	pc.size += size
+	c.size += size
+	c.count += count
+	mCurrentCacheBytes.Set(c.size)
+	mCurrentCacheMessages.Set(c.count)
+}
+
+func copyMessages(messages []cachedMessage) []cachedMessage {
+	return append([]cachedMessage(nil), messages...)
+}
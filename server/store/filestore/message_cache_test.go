@@ -0,0 +1,176 @@
+package filestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/server/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MessageCache_fetch(t *testing.T) {
+	a := assert.New(t)
+
+	c := newMessageCache(5, 1000)
+	for id := uint64(1); id <= 8; id++ {
+		c.add("p1", id, []byte(fmt.Sprintf("%d", id)), id-1)
+	}
+
+	testCases := []struct {
+		description string
+		req         *store.FetchRequest
+		expectedIDs []uint64
+		expectedHit bool
+	}{
+		{`last messages`,
+			&store.FetchRequest{StartID: 8, Direction: -1, Count: 3},
+			[]uint64{6, 7, 8}, true,
+		},
+		{`all cached messages`,
+			&store.FetchRequest{StartID: 8, Direction: -1, Count: 5},
+			[]uint64{4, 5, 6, 7, 8}, true,
+		},
+		{`more messages than cached`,
+			&store.FetchRequest{StartID: 8, Direction: -1, Count: 6},
+			nil, false,
+		},
+		{`forward from a cached message`,
+			&store.FetchRequest{StartID: 6, Direction: 1, Count: 10},
+			[]uint64{6, 7, 8}, true,
+		},
+		{`forward until the end id`,
+			&store.FetchRequest{StartID: 5, EndID: 6, Direction: 1, Count: 10},
+			[]uint64{5, 6}, true,
+		},
+		{`forward after the last message`,
+			&store.FetchRequest{StartID: 9, Direction: 1, Count: 10},
+			[]uint64{}, true,
+		},
+		{`forward from an evicted message`,
+			&store.FetchRequest{StartID: 3, Direction: 1, Count: 2},
+			nil, false,
+		},
+	}
+
+	for _, testcase := range testCases {
+		messages, hit := c.fetch("p1", testcase.req)
+		a.Equal(testcase.expectedHit, hit, testcase.description)
+		if hit {
+			ids := []uint64{}
+			for _, m := range messages {
+				ids = append(ids, m.id)
+				a.Equal(fmt.Sprintf("%d", m.id), string(m.data))
+			}
+			a.Equal(testcase.expectedIDs, ids, testcase.description)
+		}
+	}
+
+	_, hit := c.fetch("p2", &store.FetchRequest{StartID: 8, Direction: -1, Count: 1})
+	a.False(hit)
+}
+
+func Test_MessageCache_addKeepsTheMostRecentMessages(t *testing.T) {
+	a := assert.New(t)
+
+	c := newMessageCache(10, 1000)
+
+	// older messages may be stored on the disk
+	c.add("p1", 5, []byte("5"), 10)
+	a.Equal(0, len(c.partitions))
+
+	c.add("p1", 11, []byte("11"), 10)
+	c.add("p1", 13, []byte("13"), 11)
+	c.add("p1", 12, []byte("12"), 13)
+	c.add("p1", 9, []byte("9"), 13)
+
+	messages, hit := c.fetch("p1", &store.FetchRequest{StartID: 13, Direction: -1, Count: 3})
+	a.True(hit)
+	a.Equal([]cachedMessage{{11, []byte("11")}, {12, []byte("12")}, {13, []byte("13")}}, messages)
+	a.Equal(int64(3), c.count)
+	a.Equal(int64(6), c.size)
+}
+
+func Test_MessageCache_evictsLeastRecentlyUsedPartitions(t *testing.T) {
+	a := assert.New(t)
+
+	c := newMessageCache(10, 40)
+	for id := uint64(1); id <= 2; id++ {
+		c.add("p1", id, []byte("0123456789"), id-1)
+		c.add("p2", id, []byte("0123456789"), id-1)
+	}
+	a.Equal(int64(40), c.size)
+
+	// p1 is used, so p2 is evicted first
+	_, hit := c.fetch("p1", &store.FetchRequest{StartID: 2, Direction: -1, Count: 2})
+	a.True(hit)
+
+	c.add("p3", 1, []byte("0123456789"), 0)
+	c.add("p3", 2, []byte("0123456789"), 1)
+	a.Equal(int64(40), c.size)
+	a.Equal(int64(4), c.count)
+
+	_, hit = c.fetch("p1", &store.FetchRequest{StartID: 2, Direction: -1, Count: 2})
+	a.True(hit)
+	_, exist := c.partitions["p2"]
+	a.False(exist)
+
+	c.remove("p1")
+	a.Equal(int64(20), c.size)
+	a.Equal(int64(2), c.count)
+}
+
+func Test_MessageCache_disabled(t *testing.T) {
+	a := assert.New(t)
+
+	c := newMessageCache(0, 1000)
+	a.Nil(c)
+
+	c.add("p1", 1, []byte("1"), 0)
+	_, hit := c.fetch("p1", &store.FetchRequest{StartID: 1, Direction: 1, Count: 1})
+	a.False(hit)
+}
+
+func Test_FetchFromMessageCache(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(10000)
+
+	dir, _ := ioutil.TempDir("", "guble_message_cache_test")
+	defer os.RemoveAll(dir)
+
+	config := Config{CacheMessages: 5, CacheSize: 1000}
+	mStore := NewWithConfig(dir, config)
+	for id := 1; id <= 8; id++ {
+		a.NoError(mStore.Store("p1", uint64(id), []byte(fmt.Sprintf("message %d", id))))
+	}
+	a.NoError(mStore.Stop())
+
+	// the most recent messages are loaded into the cache, when the partition is loaded again
+	mStore = NewWithConfig(dir, config)
+	defer mStore.Stop()
+	_, err := mStore.Partition("p1")
+	a.NoError(err)
+
+	// so that they are fetched without reading the message file
+	a.NoError(os.Remove(path.Join(dir, "p1", "p1-00000000000000000000.msg")))
+
+	req := store.NewFetchRequest("p1", 8, 0, store.DirectionBackwards, 3)
+	req.Init()
+	mStore.Fetch(req)
+
+	select {
+	case n := <-req.StartC:
+		a.Equal(3, n)
+	case <-time.After(time.Second):
+		a.FailNow("timeout")
+	}
+	messages := []string{}
+	for fetched := range req.MessageC {
+		messages = append(messages, string(fetched.Message))
+	}
+	a.Equal([]string{"message 6", "message 7", "message 8"}, messages)
+}
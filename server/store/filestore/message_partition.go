@@ -3,13 +3,16 @@ package filestore
 import (
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/store"
 
 	"io"
@@ -19,7 +22,7 @@ import (
 
 var (
 	magicNumber       = []byte{42, 249, 180, 108, 82, 75, 222, 182}
-	fileFormatVersion = []byte{1}
+	fileFormatVersion = []byte{2}
 	messagesPerFile   = uint64(10000)
 	indexEntrySize    = 20
 )
@@ -27,6 +30,7 @@ var (
 const (
 	gubleNodeIdBits    = 3
 	sequenceBits       = 12
+	sequenceMask       = 1<<sequenceBits - 1
 	gubleNodeIdShift   = sequenceBits
 	timestampLeftShift = sequenceBits + gubleNodeIdBits
 	gubleEpoch         = 1467714505012
@@ -45,24 +49,45 @@ type messagePartition struct {
 	appendFile            *os.File
 	indexFile             *os.File
 	appendFilePosition    uint64
+	appendFileVersion     byte
 	maxMessageID          uint64
 	sequenceNumber        uint64
+	lastGeneratedID       uint64
 	totalNumberOfMessages uint64
 	entriesCount          uint64
 	list                  *indexList
 	fileCache             *cache
 
+	config   Config
+	cache    *messageCache
+	wal      *writeAheadLog
+	stopC    chan struct{}
+	stopOnce sync.Once
+	syncWG   sync.WaitGroup
+
+	// archiveMutex serializes the fetching of archived message files
+	archiveMutex sync.Mutex
+
 	sync.RWMutex
 }
 
-func newMessagePartition(basedir string, storeName string) (*messagePartition, error) {
+func newMessagePartition(basedir string, storeName string, config Config) (*messagePartition, error) {
 	p := &messagePartition{
 		basedir:   basedir,
 		name:      storeName,
 		list:      newIndexList(int(messagesPerFile)),
 		fileCache: newCache(),
+		config:    config,
+		stopC:     make(chan struct{}),
+	}
+	if err := p.initialize(); err != nil {
+		return p, err
 	}
-	return p, p.initialize()
+	if config.SyncPolicy == SyncInterval {
+		p.syncWG.Add(1)
+		go p.syncLoop(config.syncInterval())
+	}
+	return p, nil
 }
 
 func (p *messagePartition) Name() string {
@@ -83,21 +108,203 @@ func (p *messagePartition) Count() uint64 {
 	return p.totalNumberOfMessages
 }
 
+// Info returns the number, the size of the files and the first/last messages of the partition.
+// It is a part of the `store.PartitionInfoProvider` implementation.
+func (p *messagePartition) Info() (*store.PartitionInfo, error) {
+	info := &store.PartitionInfo{
+		Partition: p.name,
+		Count:     p.Count(),
+	}
+
+	size, err := p.filesSize()
+	if err != nil {
+		return nil, err
+	}
+	info.Size = size
+
+	if info.FirstID, info.FirstTime, err = p.readEdge(&store.FetchRequest{StartID: 0, Direction: 1, Count: 1}); err != nil {
+		return nil, err
+	}
+	if info.LastID, info.LastTime, err = p.readEdge(&store.FetchRequest{StartID: math.MaxUint64, Direction: -1, Count: 1}); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// filesSize returns the size in bytes of the message and index files of the partition.
+func (p *messagePartition) filesSize() (int64, error) {
+	entries, err := ioutil.ReadDir(p.basedir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), p.name+"-") {
+			size += entry.Size()
+		}
+	}
+	return size, nil
+}
+
+// readEdge returns the id and the publishing time of the first message of the fetch request (or zeros, if there is none).
+// The time is zero as well, if the stored data is not a guble message.
+func (p *messagePartition) readEdge(req *store.FetchRequest) (id uint64, timestamp int64, err error) {
+	reader := &messageReader{p: p}
+	defer reader.close()
+
+	err = p.fetchEntries(req, func(int) {}, func(index *index) error {
+		data, err := reader.read(index)
+		if err != nil {
+			return err
+		}
+		id = index.id
+		if message, err := protocol.ParseMessage(data); err == nil {
+			timestamp = message.Time
+		}
+		return nil
+	})
+	return
+}
+
 func (p *messagePartition) initialize() error {
 	p.Lock()
 	defer p.Unlock()
 
 	// reset the cache entries
 	p.fileCache = newCache()
+
+	if err := p.recoverFromWAL(); err != nil {
+		logger.WithError(err).Error("MessagePartition error on recovering from WAL")
+		return err
+	}
+
+	if p.config.ScanOnStart {
+		if err := p.truncateTornTail(); err != nil {
+			logger.WithError(err).Error("MessagePartition error on scanning the last message file")
+			return err
+		}
+	}
+
 	err := p.readIdxFiles()
 	if err != nil {
 		logger.WithField("err", err).Error("MessagePartition error on scanFiles")
 		return err
 	}
 
+	if p.config.durable() && p.wal == nil {
+		if p.wal, err = openWAL(p.walFilename()); err != nil {
+			logger.WithError(err).Error("MessagePartition error on opening WAL")
+			return err
+		}
+	}
 	return nil
 }
 
+// recoverFromWAL restores the consistency of the .idx file referred by the WAL checkpoint, after a crash:
+// the entries following the checkpoint are rewritten from the WAL records of the completely written messages,
+// and the remaining entries are discarded.
+func (p *messagePartition) recoverFromWAL() error {
+	fileID, entries, records, ok, err := readWAL(p.walFilename())
+	if err != nil || !ok {
+		return err
+	}
+
+	idxFile, err := os.OpenFile(p.composeIdxFilenameForPosition(fileID), os.O_RDWR, 0666)
+	if os.IsNotExist(err) {
+		return p.removeUnusedWAL()
+	}
+	if err != nil {
+		return err
+	}
+	defer idxFile.Close()
+
+	msgFile, err := os.Open(p.composeMsgFilenameForPosition(fileID))
+	if err != nil {
+		return err
+	}
+	defer msgFile.Close()
+
+	stat, err := idxFile.Stat()
+	if err != nil {
+		return err
+	}
+	if checkpointSize := int64(entries) * int64(indexEntrySize); stat.Size() > checkpointSize {
+		if err := idxFile.Truncate(checkpointSize); err != nil {
+			return err
+		}
+	}
+
+	recovered := 0
+	for _, r := range records {
+		if r.position != entries+uint64(recovered) {
+			break
+		}
+		msg := make([]byte, r.size)
+		if _, err := msgFile.ReadAt(msg, int64(r.offset)); err != nil || crc32.ChecksumIEEE(msg) != r.checksum {
+			break
+		}
+		if err := writeIndexEntry(idxFile, r.id, r.offset, r.size, r.position); err != nil {
+			return err
+		}
+		recovered++
+	}
+	if err := idxFile.Sync(); err != nil {
+		return err
+	}
+
+	logger.WithFields(log.Fields{
+		"filename":  idxFile.Name(),
+		"recovered": recovered,
+		"discarded": len(records) - recovered,
+	}).Info("Recovered index entries from WAL")
+
+	return p.removeUnusedWAL()
+}
+
+// removeUnusedWAL removes the WAL file when it is not used with the current sync policy.
+func (p *messagePartition) removeUnusedWAL() error {
+	if p.config.durable() {
+		return nil
+	}
+	return os.Remove(p.walFilename())
+}
+
+// checkpoint flushes the current .msg and .idx files to the disk, so that the WAL records are not needed anymore.
+func (p *messagePartition) checkpoint() error {
+	if p.wal == nil || p.appendFile == nil || p.indexFile == nil {
+		return nil
+	}
+	if err := p.appendFile.Sync(); err != nil {
+		return err
+	}
+	if err := p.indexFile.Sync(); err != nil {
+		return err
+	}
+	return p.wal.reset(uint64(p.fileCache.length()), p.entriesCount)
+}
+
+// syncLoop periodically checkpoints the partition, until it is closed.
+func (p *messagePartition) syncLoop(interval time.Duration) {
+	defer p.syncWG.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.Lock()
+			if p.wal != nil && p.wal.records > 0 {
+				if err := p.checkpoint(); err != nil {
+					logger.WithError(err).WithField("partition", p.name).Error("Error on checkpoint")
+				}
+			}
+			p.Unlock()
+		case <-p.stopC:
+			return
+		}
+	}
+}
+
 // Returns the start messages ids for all available message files
 // in a sorted list
 func (p *messagePartition) readIdxFiles() error {
@@ -225,7 +432,7 @@ func (p *messagePartition) createNextAppendFiles() error {
 		return err
 	}
 
-	// write file header on new files
+	// write file header on new files, and keep appending in the format of the existing ones
 	if stat, _ := appendfile.Stat(); stat.Size() == 0 {
 		p.appendFilePosition = uint64(stat.Size())
 
@@ -238,6 +445,10 @@ func (p *messagePartition) createNextAppendFiles() error {
 		if err != nil {
 			return err
 		}
+		p.appendFileVersion = fileFormatVersion[0]
+	} else if p.appendFileVersion, err = readFileVersion(appendfile); err != nil {
+		appendfile.Close()
+		return err
 	}
 
 	indexfile, errIndex := os.OpenFile(p.composeIdxFilenameForPosition(uint64(p.fileCache.length())), os.O_RDWR|os.O_CREATE, 0666)
@@ -255,7 +466,8 @@ func (p *messagePartition) createNextAppendFiles() error {
 	}
 	p.appendFilePosition = uint64(stat.Size())
 
-	return nil
+	// start logging the entries of the new files
+	return p.checkpoint()
 }
 
 func (p *messagePartition) generateNextMsgID(nodeID uint8) (uint64, int64, error) {
@@ -275,9 +487,21 @@ func (p *messagePartition) generateNextMsgID(nodeID uint8) (uint64, int64, error
 		return 0, 0, err
 	}
 
-	id := (uint64(nanoTimestamp-gubleEpoch) << timestampLeftShift) |
-		(uint64(nodeID) << gubleNodeIdShift) | p.sequenceNumber
+	node := uint64(nodeID) << gubleNodeIdShift
+	sequence := p.sequenceNumber & sequenceMask
+	id := (uint64(nanoTimestamp-gubleEpoch) << timestampLeftShift) | node | sequence
+
+	// the IDs have to be strictly increasing, even if the clock moves backwards
+	// or the messages of other nodes with a skewed clock were stored meanwhile
+	last := p.lastGeneratedID
+	if p.maxMessageID > last {
+		last = p.maxMessageID
+	}
+	if id <= last {
+		id = ((last>>timestampLeftShift)+1)<<timestampLeftShift | node | sequence
+	}
 
+	p.lastGeneratedID = id
 	p.sequenceNumber++
 
 	logger.WithFields(log.Fields{
@@ -291,9 +515,23 @@ func (p *messagePartition) generateNextMsgID(nodeID uint8) (uint64, int64, error
 }
 
 func (p *messagePartition) Close() error {
+	p.stopOnce.Do(func() {
+		close(p.stopC)
+	})
+	p.syncWG.Wait()
+
 	p.Lock()
 	defer p.Unlock()
 
+	if p.wal != nil {
+		if err := p.checkpoint(); err != nil {
+			logger.WithError(err).WithField("partition", p.name).Error("Error on checkpoint")
+		}
+		if err := p.wal.close(); err != nil {
+			logger.WithError(err).WithField("partition", p.name).Error("Error on closing WAL")
+		}
+		p.wal = nil
+	}
 	return p.closeAppendFiles()
 }
 
@@ -321,6 +559,10 @@ func (p *messagePartition) store(messageID uint64, data []byte) error {
 			"fileCache":    p.fileCache,
 		}).Debug("store")
 
+		if err := p.checkpoint(); err != nil {
+			return err
+		}
+
 		if err := p.closeAppendFiles(); err != nil {
 			return err
 		}
@@ -354,12 +596,11 @@ func (p *messagePartition) store(messageID uint64, data []byte) error {
 		}
 	}
 
-	// write the message size and the message id: 32 bit and 64 bit, so 12 bytes
-	sizeAndID := make([]byte, 12)
-	binary.LittleEndian.PutUint32(sizeAndID, uint32(len(data)))
-	binary.LittleEndian.PutUint64(sizeAndID[4:], messageID)
+	// write the message size, the message id and the message checksum: 32, 64 and 32 bit, so 16 bytes
+	// (12 bytes without the checksum, when appending to a file of the version 1)
+	header := messageHeader(p.appendFileVersion, messageID, data)
 
-	if _, err := p.appendFile.Write(sizeAndID); err != nil {
+	if _, err := p.appendFile.Write(header); err != nil {
 		return err
 	}
 
@@ -368,8 +609,31 @@ func (p *messagePartition) store(messageID uint64, data []byte) error {
 		return err
 	}
 
+	messageOffset := p.appendFilePosition + uint64(len(header))
+
+	// log the index entry before writing it, so that it can be recovered after a crash
+	if p.wal != nil {
+		err := p.wal.append(walRecord{
+			id:       messageID,
+			offset:   messageOffset,
+			size:     uint32(len(data)),
+			position: p.entriesCount,
+			checksum: crc32.ChecksumIEEE(data),
+		})
+		if err != nil {
+			return err
+		}
+		if p.config.SyncPolicy == SyncAlways {
+			if err := p.appendFile.Sync(); err != nil {
+				return err
+			}
+			if err := p.wal.sync(); err != nil {
+				return err
+			}
+		}
+	}
+
 	// write the index entry to the index file
-	messageOffset := p.appendFilePosition + uint64(len(sizeAndID))
 	err := writeIndexEntry(p.indexFile, messageID, messageOffset, uint32(len(data)), p.entriesCount)
 	if err != nil {
 		return err
@@ -394,7 +658,9 @@ func (p *messagePartition) store(messageID uint64, data []byte) error {
 	}
 	p.list.insert(e)
 
-	p.appendFilePosition += uint64(len(sizeAndID) + len(data))
+	p.appendFilePosition += uint64(len(header) + len(data))
+
+	p.cache.add(p.name, messageID, data, p.maxMessageID)
 
 	if messageID > p.maxMessageID {
 		p.maxMessageID = messageID
@@ -414,19 +680,35 @@ func (p *messagePartition) Fetch(req *store.FetchRequest) {
 	le.Debug("Fetching")
 
 	go func() {
-		fetchList, err := p.calculateFetchList(req)
-
-		if err != nil {
-			log.WithField("err", err).Error("Error calculating list")
-			req.ErrorC <- err
+		if p.fetchFromCache(req) {
 			return
 		}
-		req.StartC <- fetchList.len()
 
-		err = p.fetchByFetchlist(fetchList, req)
+		reader := &messageReader{p: p}
+		defer reader.close()
+
+		err := p.fetchEntries(req,
+			func(count int) {
+				req.StartC <- count
+			},
+			func(index *index) error {
+				if req.IsDone() {
+					return store.ErrRequestDone
+				}
+				msg, err := reader.read(index)
+				if err != nil {
+					logger.WithFields(log.Fields{
+						"err":    err,
+						"offset": index.offset,
+					}).Error("Error ReadAt")
+					return err
+				}
+				req.Push(index.id, msg)
+				return nil
+			})
 
 		if err != nil {
-			le.WithField("err", err).Error("Error calculating list")
+			le.WithField("err", err).Error("Error fetching messages")
 			req.Error(err)
 			return
 		}
@@ -434,79 +716,53 @@ func (p *messagePartition) Fetch(req *store.FetchRequest) {
 	}()
 }
 
-// fetchByFetchlist fetches the messages in the supplied fetchlist and sends them to the message-channel
-func (p *messagePartition) fetchByFetchlist(fetchList *indexList, req *store.FetchRequest) error {
-	return fetchList.mapWithPredicate(func(index *index, _ int) error {
-		if req.IsDone() {
-			return store.ErrRequestDone
-		}
-
-		filename := p.composeMsgFilenameForPosition(uint64(index.fileID))
-		file, err := os.Open(filename)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		msg := make([]byte, index.size, index.size)
-		_, err = file.ReadAt(msg, int64(index.offset))
-		if err != nil {
-			logger.WithFields(log.Fields{
-				"err":    err,
-				"offset": index.offset,
-			}).Error("Error ReadAt")
-			return err
-		}
-
-		req.Push(index.id, msg)
-		return nil
-	})
+// fetchFromCache serves the fetch request from the message cache, if all the requested messages are cached.
+func (p *messagePartition) fetchFromCache(req *store.FetchRequest) bool {
+	messages, ok := p.cache.fetch(p.name, req)
+	if !ok {
+		return false
+	}
+	req.StartC <- len(messages)
+	for _, m := range messages {
+		req.Push(m.id, m.data)
+	}
+	req.Done()
+	return true
 }
 
-// calculateFetchList returns a list of fetchEntry records for all messages in the fetch request.
-func (p *messagePartition) calculateFetchList(req *store.FetchRequest) (*indexList, error) {
-	if req.Direction == 0 {
-		req.Direction = 1
+// warmCache loads the most recent messages of the current file into the message cache,
+// if they are the most recent messages of the partition.
+func (p *messagePartition) warmCache() error {
+	if p.cache == nil {
+		return nil
 	}
+	p.RLock()
+	defer p.RUnlock()
 
-	potentialEntries := newIndexList(0)
-
-	// reading from IndexFiles
-	// TODO: fix  prev when EndID logic will be done
-	// prev specifies if we found anything in the previous list, in which case
-	// it is possible the items to continue in the next list
-	prev := false
-
-	p.fileCache.RLock()
-
-	for i, fce := range p.fileCache.entries {
-		if fce.Contains(req) || (prev && potentialEntries.len() < req.Count) {
-			prev = true
-
-			l, err := p.loadIndexList(i)
-			if err != nil {
-				logger.WithError(err).Info("Error loading idx file in memory")
-				return nil, err
-			}
-
-			potentialEntries.insert(l.extract(req).toSliceArray()...)
-		} else {
-			prev = false
-		}
+	items := p.list.toSliceArray()
+	if len(items) > p.cache.maxMessages {
+		items = items[len(items)-p.cache.maxMessages:]
 	}
-
-	// Read from current cached value (the idx file which size is smaller than MESSAGE_PER_FILE
-	if p.list.contains(req.StartID) || (prev && potentialEntries.len() < req.Count) {
-		potentialEntries.insert(p.list.extract(req).toSliceArray()...)
+	if len(items) == 0 {
+		return nil
+	}
+	for _, entry := range p.fileCache.snapshot() {
+		if entry.max >= items[0].id {
+			return nil
+		}
 	}
 
-	// Currently potentialEntries contains a potentials IDs from any files and
-	// from in memory. From this will select only Count.
-	fetchList := potentialEntries.extract(req)
-
-	p.fileCache.RUnlock()
+	reader := &messageReader{p: p}
+	defer reader.close()
 
-	return fetchList, nil
+	for _, item := range items {
+		msg, err := reader.read(item)
+		if err != nil {
+			return err
+		}
+		p.cache.add(p.name, item.id, msg, 0)
+	}
+	return nil
 }
 
 func (p *messagePartition) rewriteSortedIdxFile(filename string) error {
@@ -514,10 +770,13 @@ func (p *messagePartition) rewriteSortedIdxFile(filename string) error {
 		"filename": filename,
 	}).Info("Dumping Sorted list")
 
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	// the sorted entries are written into a temporary file, which replaces the .idx file only when complete
+	tmpFilename := filename + ".tmp"
+	file, err := os.OpenFile(tmpFilename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmpFilename)
 	defer file.Close()
 
 	lastID := uint64(0)
@@ -547,7 +806,10 @@ func (p *messagePartition) rewriteSortedIdxFile(filename string) error {
 			return err
 		}
 	}
-	return nil
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
 }
 
 // readIndexEntry reads from a .idx file from the given `position` the msgID msgOffset and msgSize
@@ -663,6 +925,10 @@ func (p *messagePartition) composeMsgFilenameForPosition(value uint64) string {
 	return filepath.Join(p.basedir, fmt.Sprintf("%s-%020d.msg", p.name, value))
 }
 
+func (p *messagePartition) walFilename() string {
+	return filepath.Join(p.basedir, p.name+".wal")
+}
+
 func (p *messagePartition) composeIdxFilenameForPosition(value uint64) string {
 	return filepath.Join(p.basedir, fmt.Sprintf("%s-%020d.idx", p.name, value))
 }
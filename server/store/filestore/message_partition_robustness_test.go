@@ -20,7 +20,7 @@ func Test_MessagePartition_forConcurrentWriteAndReads(t *testing.T) {
 	dir, _ := ioutil.TempDir("", "guble_partition_store_test")
 	defer os.RemoveAll(dir)
 
-	store, _ := newMessagePartition(dir, "myMessages")
+	store, _ := newMessagePartition(dir, "myMessages", Config{})
 
 	n := 2000 * 100
 	nReaders := 7
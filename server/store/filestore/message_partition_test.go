@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/store"
 
 	"errors"
@@ -20,7 +21,7 @@ func TestFileMessageStore_GenerateNextMsgId(t *testing.T) {
 
 	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
 	defer os.RemoveAll(dir)
-	mStore, err := newMessagePartition(dir, "node1")
+	mStore, err := newMessagePartition(dir, "node1", Config{})
 	a.Nil(err)
 
 	var generatedIDs []uint64
@@ -40,12 +41,12 @@ func TestFileMessageStore_GenerateNextMsgIdMultipleNodes(t *testing.T) {
 
 	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
 	defer os.RemoveAll(dir)
-	mStore, err := newMessagePartition(dir, "node1")
+	mStore, err := newMessagePartition(dir, "node1", Config{})
 	a.Nil(err)
 
 	dir2, _ := ioutil.TempDir("", "guble_message_partition_test2")
 	defer os.RemoveAll(dir2)
-	mStore2, err := newMessagePartition(dir2, "node1")
+	mStore2, err := newMessagePartition(dir2, "node1", Config{})
 	a.Nil(err)
 
 	var generatedIDs []uint64
@@ -78,30 +79,30 @@ func Test_MessagePartition_loadFiles(t *testing.T) {
 
 	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
 	defer os.RemoveAll(dir)
-	mStore, _ := newMessagePartition(dir, "myMessages")
+	mStore, _ := newMessagePartition(dir, "myMessages", Config{})
 
 	msgData := []byte("aaaaaaaaaa")             // 10 bytes message
-	a.NoError(mStore.Store(uint64(3), msgData)) // stored offset 21, size: 10
-	a.NoError(mStore.Store(uint64(4), msgData)) // stored offset 21+10+12=43
+	a.NoError(mStore.Store(uint64(3), msgData)) // stored offset 25, size: 10
+	a.NoError(mStore.Store(uint64(4), msgData)) // stored offset 25+10+16=51
 
-	a.NoError(mStore.Store(uint64(10), msgData)) // stored offset 43+22=65
+	a.NoError(mStore.Store(uint64(10), msgData)) // stored offset 51+26=77
 
-	a.NoError(mStore.Store(uint64(9), msgData)) // stored offset 65+22=87
-	a.NoError(mStore.Store(uint64(5), msgData)) // stored offset 87+22=109
+	a.NoError(mStore.Store(uint64(9), msgData)) // stored offset 77+26=103
+	a.NoError(mStore.Store(uint64(5), msgData)) // stored offset 103+26=129
 
 	// here second file will start
-	a.NoError(mStore.Store(uint64(8), msgData))  // stored offset 21
-	a.NoError(mStore.Store(uint64(15), msgData)) // stored offset 43
-	a.NoError(mStore.Store(uint64(13), msgData)) // stored offset 65
+	a.NoError(mStore.Store(uint64(8), msgData))  // stored offset 25
+	a.NoError(mStore.Store(uint64(15), msgData)) // stored offset 51
+	a.NoError(mStore.Store(uint64(13), msgData)) // stored offset 77
 
-	a.NoError(mStore.Store(uint64(22), msgData)) // stored offset 87
-	a.NoError(mStore.Store(uint64(23), msgData)) // stored offset 109
+	a.NoError(mStore.Store(uint64(22), msgData)) // stored offset 103
+	a.NoError(mStore.Store(uint64(23), msgData)) // stored offset 129
 
 	// third file
-	a.NoError(mStore.Store(uint64(24), msgData)) // stored offset 21
-	a.NoError(mStore.Store(uint64(26), msgData)) // stored offset 43
+	a.NoError(mStore.Store(uint64(24), msgData)) // stored offset 25
+	a.NoError(mStore.Store(uint64(26), msgData)) // stored offset 51
 
-	a.NoError(mStore.Store(uint64(30), msgData)) // stored offset 65
+	a.NoError(mStore.Store(uint64(30), msgData)) // stored offset 77
 	a.Equal(uint64(13), mStore.Count())
 
 	a.NoError(mStore.Close())
@@ -122,7 +123,7 @@ func Test_MessagePartition_correctIdAfterRestart(t *testing.T) {
 	a := assert.New(t)
 	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
 	defer os.RemoveAll(dir)
-	mStore, _ := newMessagePartition(dir, "myMessages")
+	mStore, _ := newMessagePartition(dir, "myMessages", Config{})
 
 	a.NoError(mStore.Store(uint64(1), []byte("aaaaaaaaaa")))
 	a.NoError(mStore.Store(uint64(2), []byte("aaaaaaaaaa")))
@@ -130,17 +131,53 @@ func Test_MessagePartition_correctIdAfterRestart(t *testing.T) {
 	a.NoError(mStore.Close())
 	a.Equal(uint64(2), mStore.Count())
 
-	newMStore, err := newMessagePartition(dir, "myMessages")
+	newMStore, err := newMessagePartition(dir, "myMessages", Config{})
 	a.NoError(err)
 	a.Equal(uint64(2), newMStore.MaxMessageID())
 	a.Equal(uint64(2), newMStore.Count())
 }
 
+func Test_MessagePartition_Info(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(2)
+
+	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
+	defer os.RemoveAll(dir)
+	mStore, err := newMessagePartition(dir, "myMessages", Config{})
+	a.NoError(err)
+	defer mStore.Close()
+
+	info, err := mStore.Info()
+	a.NoError(err)
+	a.Equal(&store.PartitionInfo{Partition: "myMessages"}, info)
+
+	for id := 1; id <= 5; id++ {
+		m := &protocol.Message{ID: uint64(id), Path: "/myMessages", Time: int64(1420110000 + id), Body: []byte("body")}
+		a.NoError(mStore.Store(m.ID, m.Bytes()))
+	}
+
+	info, err = mStore.Info()
+	a.NoError(err)
+	a.Equal("myMessages", info.Partition)
+	a.Equal(uint64(5), info.Count)
+	a.Equal(uint64(1), info.FirstID)
+	a.Equal(int64(1420110001), info.FirstTime)
+	a.Equal(uint64(5), info.LastID)
+	a.Equal(int64(1420110005), info.LastTime)
+
+	var size int64
+	files, _ := ioutil.ReadDir(dir)
+	for _, f := range files {
+		size += f.Size()
+	}
+	a.Equal(size, info.Size)
+}
+
 func Benchmark_Storing_HelloWorld_Messages(b *testing.B) {
 	a := assert.New(b)
 	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
 	defer os.RemoveAll(dir)
-	mStore, _ := newMessagePartition(dir, "myMessages")
+	mStore, _ := newMessagePartition(dir, "myMessages", Config{})
 
 	b.ResetTimer()
 	for i := 1; i <= b.N; i++ {
@@ -154,7 +191,7 @@ func Benchmark_Storing_1Kb_Messages(b *testing.B) {
 	a := assert.New(b)
 	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
 	defer os.RemoveAll(dir)
-	mStore, _ := newMessagePartition(dir, "myMessages")
+	mStore, _ := newMessagePartition(dir, "myMessages", Config{})
 
 	message := make([]byte, 1024)
 	for i := range message {
@@ -173,7 +210,7 @@ func Benchmark_Storing_1MB_Messages(b *testing.B) {
 	a := assert.New(b)
 	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
 	defer os.RemoveAll(dir)
-	mStore, _ := newMessagePartition(dir, "myMessages")
+	mStore, _ := newMessagePartition(dir, "myMessages", Config{})
 
 	message := make([]byte, 1024*1024)
 	for i := range message {
@@ -188,7 +225,7 @@ func Benchmark_Storing_1MB_Messages(b *testing.B) {
 	b.StopTimer()
 }
 
-func Test_calculateFetchList(t *testing.T) {
+func Test_fetchEntries(t *testing.T) {
 	// allow five messages per file
 	messagesPerFile = uint64(5)
 
@@ -198,32 +235,32 @@ func Test_calculateFetchList(t *testing.T) {
 	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
 	defer os.RemoveAll(dir)
 
-	mStore, _ := newMessagePartition(dir, "myMessages")
+	mStore, _ := newMessagePartition(dir, "myMessages", Config{})
 
 	// File header: MAGIC_NUMBER + FILE_NUMBER_VERSION = 9 bytes in the file
-	// For each stored message there is a 12 bytes write that contains the msgID and size
+	// For each stored message there is a 16 bytes write that contains the msgID, size and checksum
 
-	a.NoError(mStore.Store(uint64(3), msgData)) // stored offset 21, size: 10
-	a.NoError(mStore.Store(uint64(4), msgData)) // stored offset 21+10+12=43
+	a.NoError(mStore.Store(uint64(3), msgData)) // stored offset 25, size: 10
+	a.NoError(mStore.Store(uint64(4), msgData)) // stored offset 25+10+16=51
 
-	a.NoError(mStore.Store(uint64(10), msgData)) // stored offset 43+22=65
+	a.NoError(mStore.Store(uint64(10), msgData)) // stored offset 51+26=77
 
-	a.NoError(mStore.Store(uint64(9), msgData)) // stored offset 65+22=87
-	a.NoError(mStore.Store(uint64(5), msgData)) // stored offset 87+22=109
+	a.NoError(mStore.Store(uint64(9), msgData)) // stored offset 77+26=103
+	a.NoError(mStore.Store(uint64(5), msgData)) // stored offset 103+26=129
 
 	// here second file will start
-	a.NoError(mStore.Store(uint64(8), msgData))  // stored offset 21
-	a.NoError(mStore.Store(uint64(15), msgData)) // stored offset 43
-	a.NoError(mStore.Store(uint64(13), msgData)) // stored offset 65
+	a.NoError(mStore.Store(uint64(8), msgData))  // stored offset 25
+	a.NoError(mStore.Store(uint64(15), msgData)) // stored offset 51
+	a.NoError(mStore.Store(uint64(13), msgData)) // stored offset 77
 
-	a.NoError(mStore.Store(uint64(22), msgData)) // stored offset 87
-	a.NoError(mStore.Store(uint64(23), msgData)) // stored offset 109
+	a.NoError(mStore.Store(uint64(22), msgData)) // stored offset 103
+	a.NoError(mStore.Store(uint64(23), msgData)) // stored offset 129
 
 	// third file
-	a.NoError(mStore.Store(uint64(24), msgData)) // stored offset 21
-	a.NoError(mStore.Store(uint64(26), msgData)) // stored offset 43
+	a.NoError(mStore.Store(uint64(24), msgData)) // stored offset 25
+	a.NoError(mStore.Store(uint64(26), msgData)) // stored offset 51
 
-	a.NoError(mStore.Store(uint64(30), msgData)) // stored offset 65
+	a.NoError(mStore.Store(uint64(30), msgData)) // stored offset 77
 
 	defer a.NoError(mStore.Close())
 
@@ -235,34 +272,34 @@ func Test_calculateFetchList(t *testing.T) {
 		{`direct match`,
 			store.FetchRequest{StartID: 3, Direction: 0, Count: 1},
 			indexList{
-				items: []*index{{3, uint64(21), 10, 0}}, // messageId, offset, size, fileId
+				items: []*index{{3, uint64(25), 10, 0}}, // messageId, offset, size, fileId
 			},
 		},
 		{`direct match in second file`,
 			store.FetchRequest{StartID: 8, Direction: 0, Count: 1},
 			indexList{
-				items: []*index{{8, uint64(21), 10, 1}}, // messageId, offset, size, fileId,
+				items: []*index{{8, uint64(25), 10, 1}}, // messageId, offset, size, fileId,
 			},
 		},
 		{`direct match in second file, not first position`,
 			store.FetchRequest{StartID: 13, Direction: 0, Count: 1},
 			indexList{
-				items: []*index{{13, uint64(65), 10, 1}}, // messageId, offset, size, fileId,
+				items: []*index{{13, uint64(77), 10, 1}}, // messageId, offset, size, fileId,
 			},
 		},
 		// TODO this is caused by hasStartID() functions.This will be done when implementing the EndID logic
 		// {`next entry matches`,
 		// 	store.FetchRequest{StartID: 1, Direction: 0, Count: 1},
 		// 	SortedIndexList{
-		// 		{3, uint64(21), 10, 0}, // messageId, offset, size, fileId
+		// 		{3, uint64(25), 10, 0}, // messageId, offset, size, fileId
 		// 	},
 		// },
 		{`entry before matches`,
 			store.FetchRequest{StartID: 5, Direction: -1, Count: 2},
 			indexList{
 				items: []*index{
-					{4, uint64(43), 10, 0},  // messageId, offset, size, fileId
-					{5, uint64(109), 10, 0}, // messageId, offset, size, fileId
+					{4, uint64(51), 10, 0},  // messageId, offset, size, fileId
+					{5, uint64(129), 10, 0}, // messageId, offset, size, fileId
 				},
 			},
 		},
@@ -282,9 +319,9 @@ func Test_calculateFetchList(t *testing.T) {
 			store.FetchRequest{StartID: 9, Direction: 1, Count: 3},
 			indexList{
 				items: []*index{
-					{9, uint64(87), 10, 0},  // messageId, offset, size, fileId
-					{10, uint64(65), 10, 0}, // messageId, offset, size, fileId
-					{13, uint64(65), 10, 1}, // messageId, offset, size, fileId
+					{9, uint64(103), 10, 0}, // messageId, offset, size, fileId
+					{10, uint64(77), 10, 0}, // messageId, offset, size, fileId
+					{13, uint64(77), 10, 1}, // messageId, offset, size, fileId
 				},
 			},
 		},
@@ -292,11 +329,11 @@ func Test_calculateFetchList(t *testing.T) {
 			store.FetchRequest{StartID: 26, Direction: -1, Count: 4},
 			indexList{
 				items: []*index{
-					// {15, uint64(43), 10, 1},  // messageId, offset, size, fileId
-					{22, uint64(87), 10, 1},  // messageId, offset, size, fileId
-					{23, uint64(109), 10, 1}, // messageId, offset, size, fileId
-					{24, uint64(21), 10, 2},  // messageId, offset, size, fileId
-					{26, uint64(43), 10, 2},  // messageId, offset, size, fileId
+					// {15, uint64(51), 10, 1},  // messageId, offset, size, fileId
+					{22, uint64(103), 10, 1}, // messageId, offset, size, fileId
+					{23, uint64(129), 10, 1}, // messageId, offset, size, fileId
+					{24, uint64(25), 10, 2},  // messageId, offset, size, fileId
+					{26, uint64(51), 10, 2},  // messageId, offset, size, fileId
 				},
 			},
 		},
@@ -304,16 +341,16 @@ func Test_calculateFetchList(t *testing.T) {
 			store.FetchRequest{StartID: 5, Direction: 1, Count: 10},
 			indexList{
 				items: []*index{
-					{5, uint64(109), 10, 0},  // messageId, offset, size, fileId
-					{8, uint64(21), 10, 1},   // messageId, offset, size, fileId
-					{9, uint64(87), 10, 0},   // messageId, offset, size, fileId
-					{10, uint64(65), 10, 0},  // messageId, offset, size, fileId
-					{13, uint64(65), 10, 1},  // messageId, offset, size, fileId
-					{15, uint64(43), 10, 1},  // messageId, offset, size, fileId
-					{22, uint64(87), 10, 1},  // messageId, offset, size, fileId
-					{23, uint64(109), 10, 1}, // messageId, offset, size, fileId
-					{24, uint64(21), 10, 2},  // messageId, offset, size, fileId
-					{26, uint64(43), 10, 2},  // messageId, offset, size, fileId
+					{5, uint64(129), 10, 0},  // messageId, offset, size, fileId
+					{8, uint64(25), 10, 1},   // messageId, offset, size, fileId
+					{9, uint64(103), 10, 0},  // messageId, offset, size, fileId
+					{10, uint64(77), 10, 0},  // messageId, offset, size, fileId
+					{13, uint64(77), 10, 1},  // messageId, offset, size, fileId
+					{15, uint64(51), 10, 1},  // messageId, offset, size, fileId
+					{22, uint64(103), 10, 1}, // messageId, offset, size, fileId
+					{23, uint64(129), 10, 1}, // messageId, offset, size, fileId
+					{24, uint64(25), 10, 2},  // messageId, offset, size, fileId
+					{26, uint64(51), 10, 2},  // messageId, offset, size, fileId
 				},
 			},
 		},
@@ -321,8 +358,18 @@ func Test_calculateFetchList(t *testing.T) {
 
 	for _, testcase := range testCases {
 		testcase.req.Partition = "myMessages"
-		fetchEntries, err := mStore.calculateFetchList(&testcase.req)
+		fetchEntries := newIndexList(0)
+		count := -1
+		err := mStore.fetchEntries(&testcase.req,
+			func(n int) {
+				count = n
+			},
+			func(index *index) error {
+				fetchEntries.insert(index)
+				return nil
+			})
 		a.NoError(err, "Tescase: "+testcase.description)
+		a.Equal(testcase.expectedResults.len(), count, "Tescase: "+testcase.description)
 		a.True(matchSortedList(t, testcase.expectedResults, *fetchEntries), "Tescase: "+testcase.description)
 	}
 }
@@ -359,32 +406,32 @@ func Test_Partition_Fetch(t *testing.T) {
 	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
 	defer os.RemoveAll(dir)
 
-	mStore, _ := newMessagePartition(dir, "myMessages")
+	mStore, _ := newMessagePartition(dir, "myMessages", Config{})
 
 	// File header: MAGIC_NUMBER + FILE_NUMBER_VERSION = 9 bytes in the file
-	// For each stored message there is a 12 bytes write that contains the msgID and size
+	// For each stored message there is a 16 bytes write that contains the msgID, size and checksum
 
-	a.NoError(mStore.Store(uint64(3), msgData)) // stored offset 21, size: 10
-	a.NoError(mStore.Store(uint64(4), msgData)) // stored offset 21+10+12=43
+	a.NoError(mStore.Store(uint64(3), msgData)) // stored offset 25, size: 10
+	a.NoError(mStore.Store(uint64(4), msgData)) // stored offset 25+10+16=51
 
-	a.NoError(mStore.Store(uint64(10), msgData)) // stored offset 43+22=65
+	a.NoError(mStore.Store(uint64(10), msgData)) // stored offset 51+26=77
 
-	a.NoError(mStore.Store(uint64(9), msgData2)) // stored offset 65+22=87
-	a.NoError(mStore.Store(uint64(5), msgData3)) // stored offset 87+22=109
+	a.NoError(mStore.Store(uint64(9), msgData2)) // stored offset 77+26=103
+	a.NoError(mStore.Store(uint64(5), msgData3)) // stored offset 103+26=129
 
 	// here second file will start
-	a.NoError(mStore.Store(uint64(8), msgData2))  // stored offset 21
-	a.NoError(mStore.Store(uint64(15), msgData))  // stored offset 43
-	a.NoError(mStore.Store(uint64(13), msgData3)) // stored offset 65
+	a.NoError(mStore.Store(uint64(8), msgData2))  // stored offset 25
+	a.NoError(mStore.Store(uint64(15), msgData))  // stored offset 51
+	a.NoError(mStore.Store(uint64(13), msgData3)) // stored offset 77
 
-	a.NoError(mStore.Store(uint64(22), msgData)) // stored offset 87
-	a.NoError(mStore.Store(uint64(23), msgData)) // stored offset 109
+	a.NoError(mStore.Store(uint64(22), msgData)) // stored offset 103
+	a.NoError(mStore.Store(uint64(23), msgData)) // stored offset 129
 
 	// third file
-	a.NoError(mStore.Store(uint64(24), msgData)) // stored offset 21
-	a.NoError(mStore.Store(uint64(26), msgData)) // stored offset 43
+	a.NoError(mStore.Store(uint64(24), msgData)) // stored offset 25
+	a.NoError(mStore.Store(uint64(26), msgData)) // stored offset 51
 
-	a.NoError(mStore.Store(uint64(30), msgData)) // stored offset 65
+	a.NoError(mStore.Store(uint64(30), msgData)) // stored offset 77
 
 	defer a.NoError(mStore.Close())
 
@@ -490,3 +537,28 @@ func TestFilenameGeneration(t *testing.T) {
 	a.Equal("/foo/bar/myMessages-00000000000000000000.idx", mStore.composeIdxFilenameForPosition(0))
 	a.Equal(fmt.Sprintf("/foo/bar/myMessages-%020d.idx", messagesPerFile), mStore.composeIdxFilenameForPosition(messagesPerFile))
 }
+
+func TestFileMessageStore_GenerateNextMsgIdStrictlyIncreasing(t *testing.T) {
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
+	defer os.RemoveAll(dir)
+	mStore, err := newMessagePartition(dir, "node1", Config{})
+	a.Nil(err)
+
+	// a message of another node with a clock in the future was stored
+	future := uint64(time.Now().Add(time.Hour).UnixNano()-gubleEpoch) << timestampLeftShift
+	mStore.maxMessageID = future
+
+	// and the sequence number exceeds its bits
+	mStore.sequenceNumber = sequenceMask
+
+	lastID := future
+	for i := 0; i < 10; i++ {
+		id, _, err := mStore.generateNextMsgID(3)
+		a.Nil(err)
+		a.True(id > lastID, "Ids should be monotonic")
+		a.Equal(uint64(3), (id>>gubleNodeIdShift)&(1<<gubleNodeIdBits-1), "The node id should be kept")
+		lastID = id
+	}
+}
@@ -9,26 +9,137 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/store/archive"
 )
 
+const defaultSyncInterval = time.Second
+
+// SyncPolicy defines when the message files are flushed to the disk (fsync).
+type SyncPolicy string
+
+const (
+	// SyncNone leaves the flushing to the operating system: the lowest latency,
+	// but the messages stored shortly before a crash may be lost, and the index files may become inconsistent.
+	SyncNone SyncPolicy = "none"
+
+	// SyncAlways flushes every message before it is acknowledged: the highest durability.
+	SyncAlways SyncPolicy = "always"
+
+	// SyncInterval flushes the message files periodically:
+	// the messages stored since the last flush may be lost on a crash.
+	SyncInterval SyncPolicy = "interval"
+)
+
+// Config is used for configuring the durability and the message cache of a FileMessageStore.
+// With SyncAlways and SyncInterval, the index entries are written ahead into a log (WAL),
+// which guarantees the consistency of the index files after a crash.
+type Config struct {
+	SyncPolicy SyncPolicy
+
+	// SyncInterval is the interval for flushing the files with SyncInterval (default: 1s)
+	SyncInterval time.Duration
+
+	// CacheMessages is the number of most recent messages per partition kept in memory (0 disables the cache)
+	CacheMessages int
+
+	// CacheSize is the memory budget of the message cache in bytes, shared by all the partitions
+	CacheSize int64
+
+	// MaxPartitions is the maximum number of partitions (0: unlimited)
+	MaxPartitions int
+
+	// MaxSize is the maximum size in bytes of all the partitions on the disk (0: unlimited)
+	MaxSize int64
+
+	// EvictionIdleTime is the time after its last use, from which a partition may be evicted
+	// for making room for a new one, when a limit is reached (default: 10m)
+	EvictionIdleTime time.Duration
+
+	// KeepPartitions are never evicted, and they are not counted within the limits
+	KeepPartitions []string
+
+	// ScanOnStart enables the scan of the last message file of every partition when it is loaded,
+	// truncating the messages which were not completely written before a crash
+	ScanOnStart bool
+
+	// Archive is the cold storage, into which the complete message files are moved
+	// when they were last written before ArchiveAge (nil disables the archival)
+	Archive archive.Archive
+
+	// ArchiveAge is the age from which the message files are archived (default: 7 days)
+	ArchiveAge time.Duration
+
+	// ArchiveCacheFiles is the number of archived message files per partition,
+	// which are kept on the disk after fetching them back (default: 4)
+	ArchiveCacheFiles int
+}
+
+func (c Config) durable() bool {
+	return c.SyncPolicy == SyncAlways || c.SyncPolicy == SyncInterval
+}
+
+func (c Config) syncInterval() time.Duration {
+	if c.SyncInterval <= 0 {
+		return defaultSyncInterval
+	}
+	return c.SyncInterval
+}
+
+// partitionEntry holds a messagePartition of the FileMessageStore, which is usable after readyC is closed.
+type partitionEntry struct {
+	// lastUsed is the time of the last use in unix nanoseconds (accessed atomically)
+	lastUsed int64
+
+	readyC    chan struct{}
+	partition *messagePartition
+	err       error
+}
+
 // FileMessageStore is a struct used by the filesystem-based implementation of the MessageStore interface.
 // It holds the base directory, a map of messagePartitions etc.
+// The mutex guards only the map: each partition has its own lock,
+// so that different partitions are loaded, written and read concurrently.
+// When the store is limited, the partitions are loaded one at a time under the eviction mutex.
 type FileMessageStore struct {
-	partitions map[string]*messagePartition
+	partitions map[string]*partitionEntry
 	basedir    string
+	config     Config
+	cache      *messageCache
 	mutex      sync.RWMutex
+
+	evictionMutex   sync.Mutex
+	evictionHandler func(*store.PartitionInfo)
+
+	stopC     chan struct{}
+	stopOnce  sync.Once
+	archiveWG sync.WaitGroup
 }
 
-// New returns a new FileMessageStore.
+// New returns a new FileMessageStore, leaving the flushing of the files to the operating system (SyncNone).
 func New(basedir string) *FileMessageStore {
-	return &FileMessageStore{
-		partitions: make(map[string]*messagePartition),
+	return NewWithConfig(basedir, Config{SyncPolicy: SyncNone})
+}
+
+// NewWithConfig returns a new FileMessageStore with the given durability configuration.
+// The old message files are moved periodically into the archive, if one is configured.
+func NewWithConfig(basedir string, config Config) *FileMessageStore {
+	fms := &FileMessageStore{
+		partitions: make(map[string]*partitionEntry),
 		basedir:    basedir,
+		config:     config,
+		cache:      newMessageCache(config.CacheMessages, config.CacheSize),
+		stopC:      make(chan struct{}),
+	}
+	if config.Archive != nil {
+		fms.archiveWG.Add(1)
+		go fms.archiveLoop()
 	}
+	return fms
 }
 
 // MaxMessageID is a part of the `store.MessageStore` implementation.
@@ -43,32 +154,43 @@ func (fms *FileMessageStore) MaxMessageID(partition string) (uint64, error) {
 // Stop the FileMessageStore.
 // Implements the service.stopable interface.
 func (fms *FileMessageStore) Stop() error {
+	fms.stopOnce.Do(func() {
+		close(fms.stopC)
+	})
+	fms.archiveWG.Wait()
+
 	fms.mutex.Lock()
-	defer fms.mutex.Unlock()
+	partitions := fms.partitions
+	fms.partitions = make(map[string]*partitionEntry)
+	fms.mutex.Unlock()
 
 	logger.Info("Stopping")
 
 	var returnError error
-	for key, partition := range fms.partitions {
-		if err := partition.Close(); err != nil {
+	for key, entry := range partitions {
+		<-entry.readyC
+		if entry.err != nil {
+			continue
+		}
+		fms.cache.remove(key)
+		if err := entry.partition.Close(); err != nil {
 			returnError = err
 			logger.WithFields(log.Fields{
 				"key": key,
 				"err": err,
 			}).Error("Error on closing message store partition")
 		}
-		delete(fms.partitions, key)
 	}
 	return returnError
 }
 
 // GenerateNextMsgID is a part of the `store.MessageStore` implementation.
 func (fms *FileMessageStore) GenerateNextMsgID(partitionName string, nodeID uint8) (uint64, int64, error) {
-	p, err := fms.Partition(partitionName)
+	p, err := fms.partition(partitionName)
 	if err != nil {
 		return 0, 0, err
 	}
-	return p.(*messagePartition).generateNextMsgID(nodeID)
+	return p.generateNextMsgID(nodeID)
 }
 
 // StoreMessage is a part of the `store.MessageStore` implementation.
@@ -97,7 +219,7 @@ func (fms *FileMessageStore) StoreMessage(message *protocol.Message, nodeID uint
 
 	data := message.Bytes()
 
-	if err := fms.Store(partitionName, message.ID, message.Bytes()); err != nil {
+	if err := fms.Store(partitionName, message.ID, data); err != nil {
 		logger.
 			WithError(err).WithField("partition", partitionName).
 			Error("Error storing locally generated  messagein partition")
@@ -168,33 +290,94 @@ func (fms *FileMessageStore) Partitions() (partitions []store.MessagePartition,
 	return
 }
 
+// Partition returns the message partition with the given name, loading (or creating) it on first use.
 func (fms *FileMessageStore) Partition(partition string) (store.MessagePartition, error) {
-	fms.mutex.Lock()
-	defer fms.mutex.Unlock()
+	p, err := fms.partition(partition)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// partition returns the message partition with the given name.
+// The store mutex is only held for the lookup, so that loading a partition does not block the other partitions;
+// concurrent calls for a partition which is being loaded wait for it.
+// A partition which could not be loaded is removed, so that it is retried on the next call.
+func (fms *FileMessageStore) partition(partition string) (*messagePartition, error) {
+	fms.mutex.RLock()
+	entry, exist := fms.partitions[partition]
+	fms.mutex.RUnlock()
 
-	partitionStore, exist := fms.partitions[partition]
 	if !exist {
-		dir := path.Join(fms.basedir, partition)
-		if _, errStat := os.Stat(dir); errStat != nil {
-			if os.IsNotExist(errStat) {
-				if errMkdir := os.MkdirAll(dir, 0700); errMkdir != nil {
-					logger.WithError(errMkdir).Error("partitionStore")
-					return nil, errMkdir
+		fms.mutex.Lock()
+		entry, exist = fms.partitions[partition]
+		if !exist {
+			entry = &partitionEntry{readyC: make(chan struct{})}
+			fms.partitions[partition] = entry
+		}
+		fms.mutex.Unlock()
+
+		if !exist {
+			fms.load(partition, entry)
+		}
+	}
+
+	<-entry.readyC
+	if entry.err == nil {
+		entry.touch()
+	}
+	return entry.partition, entry.err
+}
+
+func (fms *FileMessageStore) load(partition string, entry *partitionEntry) {
+	defer close(entry.readyC)
+
+	entry.partition, entry.err = fms.newPartition(partition)
+	if entry.err != nil {
+		entry.partition = nil
+
+		fms.mutex.Lock()
+		if fms.partitions[partition] == entry {
+			delete(fms.partitions, partition)
+		}
+		fms.mutex.Unlock()
+	}
+}
+
+func (fms *FileMessageStore) newPartition(partition string) (*messagePartition, error) {
+	if fms.config.limited() && !fms.config.keeps(partition) {
+		fms.evictionMutex.Lock()
+		defer fms.evictionMutex.Unlock()
+	}
+
+	dir := path.Join(fms.basedir, partition)
+	if _, errStat := os.Stat(dir); errStat != nil {
+		if os.IsNotExist(errStat) {
+			if fms.config.limited() && !fms.config.keeps(partition) {
+				if err := fms.makeRoom(partition); err != nil {
+					return nil, err
 				}
-			} else {
-				logger.WithError(errStat).Error("partitionStore")
-				return nil, errStat
 			}
+			if errMkdir := os.MkdirAll(dir, 0700); errMkdir != nil {
+				logger.WithError(errMkdir).Error("partitionStore")
+				return nil, errMkdir
+			}
+		} else {
+			logger.WithError(errStat).Error("partitionStore")
+			return nil, errStat
 		}
-		var err error
-		partitionStore, err = newMessagePartition(dir, partition)
-		if err != nil {
-			logger.WithField("err", err).Error("partitionStore")
-			return nil, err
-		}
-		fms.partitions[partition] = partitionStore
 	}
-	return partitionStore, nil
+	p, err := newMessagePartition(dir, partition, fms.config)
+	if err != nil {
+		logger.WithField("err", err).Error("partitionStore")
+		return nil, err
+	}
+	p.cache = fms.cache
+	if err := p.warmCache(); err != nil {
+		logger.WithError(err).WithField("partition", partition).Error("Error loading the message cache")
+		p.cache.remove(partition)
+	}
+	return p, nil
 }
 
 // Check returns if available storage space is still above a certain threshold.
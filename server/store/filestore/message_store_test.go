@@ -3,6 +3,9 @@ package filestore
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path"
+	"sync"
 	"testing"
 	"time"
 
@@ -178,6 +181,78 @@ func Test_Check(t *testing.T) {
 	a.Nil(err)
 }
 
+func Test_ConcurrentWritesAndReadsInPartitions(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(50)
+
+	dir, _ := ioutil.TempDir("", "guble_message_store_test")
+	defer os.RemoveAll(dir)
+
+	mStore := New(dir)
+	defer mStore.Stop()
+
+	nPartitions := 8
+	nMessages := 200
+
+	var wg sync.WaitGroup
+	for i := 0; i < nPartitions; i++ {
+		partition := fmt.Sprintf("p%d", i)
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for id := 1; id <= nMessages; id++ {
+				a.NoError(mStore.Store(partition, uint64(id), []byte(fmt.Sprintf("%s-%d", partition, id))))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				req := store.NewFetchRequest(partition, 0, 0, store.DirectionForward, -1)
+				req.Init()
+				mStore.Fetch(req)
+
+				select {
+				case <-req.StartC:
+				case err := <-req.ErrorC:
+					a.Fail(err.Error())
+					return
+				}
+				lastID := uint64(0)
+				for fetched := range req.MessageC {
+					a.True(fetched.ID > lastID, "Fetched messages should be sorted")
+					a.Equal(fmt.Sprintf("%s-%d", partition, fetched.ID), string(fetched.Message))
+					lastID = fetched.ID
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < nPartitions; i++ {
+		maxID, err := mStore.MaxMessageID(fmt.Sprintf("p%d", i))
+		a.NoError(err)
+		a.Equal(uint64(nMessages), maxID)
+	}
+	a.Equal(nPartitions, len(mStore.partitions))
+}
+
+func Test_PartitionNotKeptOnError(t *testing.T) {
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_message_store_test")
+	defer os.RemoveAll(dir)
+
+	// the partition directory cannot be created inside a regular file
+	basedir := path.Join(dir, "file")
+	a.NoError(ioutil.WriteFile(basedir, []byte{}, 0600))
+
+	mStore := New(basedir)
+	_, err := mStore.Partition("p1")
+	a.Error(err)
+	a.Equal(0, len(mStore.partitions))
+}
+
 // func Test_Partitions(t *testing.T) {
 // 	// Store multiple partitions then recreate the store and see if they are picked up
 // 	a := assert.New(t)
@@ -0,0 +1,125 @@
+package filestore
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	// walHeaderSize is the size of the WAL header: the ID of the current file and its number of checkpointed entries
+	walHeaderSize = 16
+
+	// walRecordSize is the size of a WAL record: id, offset, size, position, message checksum and record checksum
+	walRecordSize = 36
+)
+
+// walRecord is the index entry of a message appended to the current .msg file after the last checkpoint.
+type walRecord struct {
+	id       uint64
+	offset   uint64
+	size     uint32
+	position uint64
+
+	// checksum is the CRC-32 of the message, used for detecting messages which were not completely written
+	checksum uint32
+}
+
+func (r walRecord) bytes() []byte {
+	b := make([]byte, walRecordSize)
+	binary.LittleEndian.PutUint64(b, r.id)
+	binary.LittleEndian.PutUint64(b[8:], r.offset)
+	binary.LittleEndian.PutUint32(b[16:], r.size)
+	binary.LittleEndian.PutUint64(b[20:], r.position)
+	binary.LittleEndian.PutUint32(b[28:], r.checksum)
+	binary.LittleEndian.PutUint32(b[32:], crc32.ChecksumIEEE(b[:32]))
+	return b
+}
+
+// writeAheadLog records the index entries of the messages stored since the last checkpoint,
+// i.e. since the last time the current .msg and .idx files were flushed to the disk.
+// After a crash, the entries of the .idx file following the checkpoint cannot be trusted,
+// so they are rewritten from the WAL records of the completely written messages.
+type writeAheadLog struct {
+	file    *os.File
+	size    int64
+	records int
+}
+
+func openWAL(filename string) (*writeAheadLog, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &writeAheadLog{file: file, size: stat.Size()}, nil
+}
+
+// reset discards all the records, and writes the new checkpoint into the header.
+func (w *writeAheadLog) reset(fileID uint64, entries uint64) error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	header := make([]byte, walHeaderSize)
+	binary.LittleEndian.PutUint64(header, fileID)
+	binary.LittleEndian.PutUint64(header[8:], entries)
+	if _, err := w.file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	w.size = walHeaderSize
+	w.records = 0
+	return w.file.Sync()
+}
+
+func (w *writeAheadLog) append(r walRecord) error {
+	n, err := w.file.WriteAt(r.bytes(), w.size)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	w.records++
+	return nil
+}
+
+func (w *writeAheadLog) sync() error {
+	return w.file.Sync()
+}
+
+func (w *writeAheadLog) close() error {
+	return w.file.Close()
+}
+
+// readWAL returns the checkpoint from the header and the valid records of a WAL file.
+// The records following a partially written (or corrupted) record are ignored.
+// A missing or empty WAL file (e.g. the WAL was not used before) has no checkpoint (ok is false).
+func readWAL(filename string) (fileID uint64, entries uint64, records []walRecord, ok bool, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil || len(data) < walHeaderSize {
+		return
+	}
+	ok = true
+	fileID = binary.LittleEndian.Uint64(data)
+	entries = binary.LittleEndian.Uint64(data[8:])
+
+	for b := data[walHeaderSize:]; len(b) >= walRecordSize; b = b[walRecordSize:] {
+		if crc32.ChecksumIEEE(b[:32]) != binary.LittleEndian.Uint32(b[32:]) {
+			break
+		}
+		records = append(records, walRecord{
+			id:       binary.LittleEndian.Uint64(b),
+			offset:   binary.LittleEndian.Uint64(b[8:]),
+			size:     binary.LittleEndian.Uint32(b[16:]),
+			position: binary.LittleEndian.Uint64(b[20:]),
+			checksum: binary.LittleEndian.Uint32(b[28:]),
+		})
+	}
+	return
+}
@@ -0,0 +1,166 @@
+package filestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WAL_recoversIndexAfterCrash(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(10)
+
+	dir, _ := ioutil.TempDir("", "guble_wal_test")
+	defer os.RemoveAll(dir)
+
+	config := Config{SyncPolicy: SyncAlways}
+	mStore, err := newMessagePartition(dir, "myMessages", config)
+	a.NoError(err)
+	a.NoError(mStore.Store(uint64(1), []byte("aaaaaaaaaa")))
+	a.NoError(mStore.Store(uint64(2), []byte("bbbbbbbbbb")))
+	a.NoError(mStore.Store(uint64(3), []byte("cccccccccc")))
+	crash(mStore)
+
+	// the index entries were not flushed before the crash
+	idxFilename := path.Join(dir, "myMessages-00000000000000000000.idx")
+	a.NoError(os.Truncate(idxFilename, 0))
+	a.NoError(os.Truncate(idxFilename, int64(2*indexEntrySize)))
+
+	mStore, err = newMessagePartition(dir, "myMessages", config)
+	a.NoError(err)
+	defer mStore.Close()
+
+	a.Equal(uint64(3), mStore.Count())
+	a.Equal(uint64(3), mStore.MaxMessageID())
+	a.Equal(uint64(1), mStore.list.front().id)
+	a.Equal(uint64(3), mStore.list.back().id)
+
+	entries, err := calculateNoEntries(idxFilename)
+	a.NoError(err)
+	a.Equal(uint64(3), entries)
+}
+
+func Test_WAL_discardsIncompleteMessages(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(10)
+
+	dir, _ := ioutil.TempDir("", "guble_wal_test")
+	defer os.RemoveAll(dir)
+
+	config := Config{SyncPolicy: SyncAlways}
+	mStore, err := newMessagePartition(dir, "myMessages", config)
+	a.NoError(err)
+	a.NoError(mStore.Store(uint64(1), []byte("aaaaaaaaaa")))
+	a.NoError(mStore.Store(uint64(2), []byte("bbbbbbbbbb")))
+	a.NoError(mStore.Store(uint64(3), []byte("cccccccccc")))
+	crash(mStore)
+
+	// the last message was only partially written
+	msgFilename := path.Join(dir, "myMessages-00000000000000000000.msg")
+	stat, err := os.Stat(msgFilename)
+	a.NoError(err)
+	a.NoError(os.Truncate(msgFilename, stat.Size()-5))
+
+	mStore, err = newMessagePartition(dir, "myMessages", config)
+	a.NoError(err)
+	defer mStore.Close()
+
+	a.Equal(uint64(2), mStore.Count())
+	a.Equal(uint64(2), mStore.MaxMessageID())
+}
+
+func Test_WAL_checkpointOnRotation(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(5)
+
+	dir, _ := ioutil.TempDir("", "guble_wal_test")
+	defer os.RemoveAll(dir)
+
+	mStore, err := newMessagePartition(dir, "myMessages", Config{SyncPolicy: SyncAlways})
+	a.NoError(err)
+	for i := 1; i <= 7; i++ {
+		a.NoError(mStore.Store(uint64(i), []byte("aaaaaaaaaa")))
+	}
+
+	// only the entries of the second file are logged
+	fileID, entries, records, ok, err := readWAL(mStore.walFilename())
+	a.NoError(err)
+	a.True(ok)
+	a.Equal(uint64(1), fileID)
+	a.Equal(uint64(0), entries)
+	a.Equal(2, len(records))
+	a.Equal(uint64(7), records[1].id)
+
+	_, err = os.Stat(path.Join(dir, "myMessages-00000000000000000000.idx.tmp"))
+	a.True(os.IsNotExist(err))
+
+	// closing checkpoints all the entries
+	a.NoError(mStore.Close())
+	fileID, entries, records, ok, err = readWAL(mStore.walFilename())
+	a.NoError(err)
+	a.True(ok)
+	a.Equal(uint64(1), fileID)
+	a.Equal(uint64(2), entries)
+	a.Equal(0, len(records))
+}
+
+func Test_WAL_checkpointOnInterval(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(10)
+
+	dir, _ := ioutil.TempDir("", "guble_wal_test")
+	defer os.RemoveAll(dir)
+
+	mStore, err := newMessagePartition(dir, "myMessages", Config{SyncPolicy: SyncInterval, SyncInterval: 10 * time.Millisecond})
+	a.NoError(err)
+	defer mStore.Close()
+
+	a.NoError(mStore.Store(uint64(1), []byte("aaaaaaaaaa")))
+	time.Sleep(100 * time.Millisecond)
+
+	mStore.Lock()
+	a.Equal(0, mStore.wal.records)
+	mStore.Unlock()
+
+	_, entries, _, _, err := readWAL(mStore.walFilename())
+	a.NoError(err)
+	a.Equal(uint64(1), entries)
+}
+
+func Test_WAL_removedWithSyncNone(t *testing.T) {
+	a := assert.New(t)
+	messagesPerFile = uint64(10)
+
+	dir, _ := ioutil.TempDir("", "guble_wal_test")
+	defer os.RemoveAll(dir)
+
+	mStore, err := newMessagePartition(dir, "myMessages", Config{SyncPolicy: SyncAlways})
+	a.NoError(err)
+	a.NoError(mStore.Store(uint64(1), []byte("aaaaaaaaaa")))
+	crash(mStore)
+
+	mStore, err = newMessagePartition(dir, "myMessages", Config{SyncPolicy: SyncNone})
+	a.NoError(err)
+	defer mStore.Close()
+
+	a.Equal(uint64(1), mStore.Count())
+	a.Nil(mStore.wal)
+	_, err = os.Stat(mStore.walFilename())
+	a.True(os.IsNotExist(err))
+}
+
+// crash closes the files of the partition without a checkpoint
+func crash(p *messagePartition) {
+	p.stopOnce.Do(func() {
+		close(p.stopC)
+	})
+	p.syncWG.Wait()
+
+	p.appendFile.Close()
+	p.indexFile.Close()
+	p.wal.close()
+}
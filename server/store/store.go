@@ -54,3 +54,36 @@ type MessagePartition interface {
 
 	DoInTx(func(uint64) error) error
 }
+
+// PartitionInfo describes the messages stored in a partition.
+type PartitionInfo struct {
+	Partition string `json:"partition"`
+	Count     uint64 `json:"count"`
+	Size      int64  `json:"size"`
+	FirstID   uint64 `json:"first_id"`
+	LastID    uint64 `json:"last_id"`
+	FirstTime int64  `json:"first_time"`
+	LastTime  int64  `json:"last_time"`
+}
+
+// PartitionInfoProvider is implemented by the message partitions which are able to describe their messages.
+type PartitionInfoProvider interface {
+
+	// Info returns the number, the size (in bytes) and the first/last messages of the partition
+	Info() (*PartitionInfo, error)
+}
+
+// CorruptRange is a range of consecutive messages of a partition, which cannot be read back intact.
+type CorruptRange struct {
+	Partition string `json:"partition"`
+	FromID    uint64 `json:"from_id"`
+	ToID      uint64 `json:"to_id"`
+	Count     int    `json:"count"`
+}
+
+// PartitionChecker is implemented by the message partitions which are able to verify their stored messages.
+type PartitionChecker interface {
+
+	// Check reads all the messages of the partition, and returns the ranges of the corrupt ones
+	Check() ([]*CorruptRange, error)
+}
@@ -0,0 +1,7 @@
+package templates
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "templates")
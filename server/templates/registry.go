@@ -0,0 +1,294 @@
+package templates
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+)
+
+const (
+	// kvSchema is the KVStore schema holding the templates, keyed by template key.
+	kvSchema = "push_templates"
+
+	// templateHeader is the message header referencing a template, e.g. `X-Guble-Template: order_shipped` on the REST API
+	templateHeader = "Template"
+)
+
+var ErrUnknownTemplate = errors.New("Unknown template.")
+
+// Registry renders the push payloads of the messages referencing a template, in the locale of the devices.
+// The templates are managed by an admin endpoint:
+// `GET <prefix>` lists the template keys,
+// `GET <prefix><key>` returns a template, `PUT` (or `POST`) stores it, and `DELETE` removes it.
+type Registry struct {
+	router  router.Router
+	kvstore kvstore.KVStore
+	prefix  string
+
+	mu        sync.RWMutex
+	templates map[string]*Template
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRegistry returns a new Registry, storing the templates in the KVStore of the router.
+func NewRegistry(router router.Router, prefix string) (*Registry, error) {
+	kvs, err := router.KVStore()
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{
+		router:    router,
+		kvstore:   kvs,
+		prefix:    prefix,
+		templates: make(map[string]*Template),
+	}, nil
+}
+
+// Start loads the stored templates.
+func (r *Registry) Start() error {
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	for entry := range r.kvstore.Iterate(r.ctx, kvSchema, "", 0) {
+		t, err := Parse([]byte(entry[1]))
+		if err != nil {
+			logger.WithField("key", entry[0]).WithField("error", err.Error()).Error("Ignoring invalid stored template")
+			continue
+		}
+		r.set(entry[0], t)
+	}
+
+	if w, ok := r.kvstore.(kvstore.Watcher); ok {
+		r.wg.Add(1)
+		go r.watch(w)
+	}
+	logger.WithField("templates", len(r.templates)).Info("Started template registry")
+	return nil
+}
+
+// Stop stops watching the template changes.
+func (r *Registry) Stop() error {
+	r.cancel()
+	r.wg.Wait()
+	logger.Info("Stopped template registry")
+	return nil
+}
+
+// Render returns the message rendered in the locale, if its header references a template:
+// the body of such a message holds the variables of the template as a JSON object,
+// and is replaced by the payload of the template.
+// The other messages are returned as they are.
+// It is a part of the connector.Renderer implementation.
+func (r *Registry) Render(m *protocol.Message, locale string) (*protocol.Message, error) {
+	key := templateKey(m)
+	if key == "" {
+		return m, nil
+	}
+
+	body, err := r.render(key, locale, m.Body)
+	if err != nil {
+		mTotalErrors.Add(1)
+		return nil, err
+	}
+	mTotalRendered.Add(1)
+	return &protocol.Message{
+		ID:            m.ID,
+		Path:          m.Path,
+		UserID:        m.UserID,
+		ApplicationID: m.ApplicationID,
+		Filters:       m.Filters,
+		Time:          m.Time,
+		HeaderJSON:    m.HeaderJSON,
+		ContentType:   protocol.ContentTypeJSON,
+		Body:          body,
+		NodeID:        m.NodeID,
+	}, nil
+}
+
+func (r *Registry) render(key, locale string, variablesJSON []byte) ([]byte, error) {
+	r.mu.RLock()
+	t := r.templates[key]
+	r.mu.RUnlock()
+	if t == nil {
+		return nil, fmt.Errorf("%v %q", ErrUnknownTemplate, key)
+	}
+
+	var variables map[string]interface{}
+	if len(variablesJSON) > 0 {
+		if err := json.Unmarshal(variablesJSON, &variables); err != nil {
+			return nil, fmt.Errorf("The variables of the template %q are not a JSON object: %s", key, err.Error())
+		}
+	}
+	body, err := t.Render(locale, variables)
+	if err != nil {
+		return nil, fmt.Errorf("Error rendering the template %q: %s", key, err.Error())
+	}
+	return body, nil
+}
+
+// templateKey returns the key of the template referenced by the header of the message, or an empty string.
+func templateKey(m *protocol.Message) string {
+	if m.HeaderJSON == "" {
+		return ""
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal([]byte(m.HeaderJSON), &header); err != nil {
+		return ""
+	}
+	for key, value := range header {
+		if strings.EqualFold(key, templateHeader) {
+			if s, ok := value.(string); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// watch applies the template changes done by other guble nodes, until the registry is stopped.
+func (r *Registry) watch(w kvstore.Watcher) {
+	defer r.wg.Done()
+
+	changesC, err := w.Watch(r.ctx, kvSchema)
+	if err != nil {
+		logger.WithField("error", err.Error()).Info("Not watching the template changes")
+		return
+	}
+	for change := range changesC {
+		if change.Deleted {
+			r.set(change.Key, nil)
+			continue
+		}
+		data, exist, err := r.kvstore.Get(kvSchema, change.Key)
+		if err != nil || !exist {
+			continue
+		}
+		t, err := Parse(data)
+		if err != nil {
+			logger.WithField("key", change.Key).WithField("error", err.Error()).Error("Ignoring invalid template change")
+			continue
+		}
+		r.set(change.Key, t)
+	}
+}
+
+// set caches the template, or removes it if nil.
+func (r *Registry) set(key string, t *Template) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t == nil {
+		delete(r.templates, key)
+	} else {
+		r.templates[key] = t
+	}
+	mTotalTemplates.Set(int64(len(r.templates)))
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (r *Registry) GetPrefix() string {
+	return r.prefix
+}
+
+// ServeHTTP manages the templates.
+// It is a part of the service.endpoint implementation.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	key := strings.Trim(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(r.prefix, "/")), "/")
+	if strings.Contains(key, "/") {
+		http.NotFound(w, req)
+		return
+	}
+	if key == "" {
+		if req.Method != http.MethodGet {
+			http.Error(w, `{"error": "Missing template key."}`, http.StatusBadRequest)
+			return
+		}
+		r.listKeys(w)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		r.getTemplate(w, key)
+	case http.MethodPut, http.MethodPost:
+		r.putTemplate(w, req, key)
+	case http.MethodDelete:
+		r.deleteTemplate(w, key)
+	default:
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET, PUT, POST and DELETE are accepted."}`, http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) listKeys(w http.ResponseWriter) {
+	r.mu.RLock()
+	keys := make([]string, 0, len(r.templates))
+	for key := range r.templates {
+		keys = append(keys, key)
+	}
+	r.mu.RUnlock()
+
+	sort.Strings(keys)
+	json.NewEncoder(w).Encode(keys)
+}
+
+func (r *Registry) getTemplate(w http.ResponseWriter, key string) {
+	data, exist, err := r.kvstore.Get(kvSchema, key)
+	if err != nil {
+		logger.WithField("error", err.Error()).WithField("key", key).Error("Error loading the template")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	if !exist {
+		http.Error(w, `{"error": "Template not found."}`, http.StatusNotFound)
+		return
+	}
+	w.Write(data)
+}
+
+func (r *Registry) putTemplate(w http.ResponseWriter, req *http.Request, key string) {
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	t, err := Parse(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.kvstore.Put(kvSchema, key, data); err != nil {
+		logger.WithField("error", err.Error()).WithField("key", key).Error("Error storing the template")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	r.set(key, t)
+	logger.WithField("key", key).Info("Stored template")
+	w.Write(data)
+}
+
+func (r *Registry) deleteTemplate(w http.ResponseWriter, key string) {
+	if err := r.kvstore.Delete(kvSchema, key); err != nil {
+		logger.WithField("error", err.Error()).WithField("key", key).Error("Error deleting the template")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	r.set(key, nil)
+	logger.WithField("key", key).Info("Removed template")
+	fmt.Fprintf(w, `{"deleted": %q}`, key)
+}
@@ -0,0 +1,102 @@
+package templates
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/dummystore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_ManagesTemplatesAndRendersMessages(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	a.NoError(kvs.Put(kvSchema, "stored", []byte(`{"locales": {"en": {"body": "Stored"}}}`)))
+	r := router.New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil)
+	a.NoError(r.(service.Startable).Start())
+	defer r.(service.Stopable).Stop()
+
+	registry, err := NewRegistry(r, "/admin/templates/")
+	a.NoError(err)
+	a.NoError(registry.Start())
+	defer registry.Stop()
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		registry.ServeHTTP(w, req)
+		return w
+	}
+
+	shipped := `{"default_locale": "en", "locales": {"en": {"body": "Hi {{.name}}"}, "de": {"body": "Hallo {{.name}}"}}}`
+	w := serve(http.MethodPut, "/admin/templates/shipped", shipped)
+	a.Equal(http.StatusOK, w.Code)
+
+	w = serve(http.MethodPut, "/admin/templates/shipped", `{"locales": {"en": {"body": "Hi {{.name"}}}`)
+	a.Equal(http.StatusBadRequest, w.Code)
+
+	w = serve(http.MethodGet, "/admin/templates/", "")
+	a.JSONEq(`["shipped", "stored"]`, w.Body.String())
+
+	w = serve(http.MethodGet, "/admin/templates/shipped", "")
+	a.JSONEq(shipped, w.Body.String())
+
+	m := &protocol.Message{
+		ID:          42,
+		Path:        "/orders",
+		Filters:     map[string]string{"user_id": "anna"},
+		HeaderJSON:  `{"template": "shipped"}`,
+		ContentType: protocol.ContentTypeJSON,
+		Body:        []byte(`{"name": "Anna"}`),
+	}
+	rendered, err := registry.Render(m, "de-AT")
+	a.NoError(err)
+	a.Equal(uint64(42), rendered.ID)
+	a.Equal(protocol.Path("/orders"), rendered.Path)
+	a.Equal(m.Filters, rendered.Filters)
+	a.JSONEq(`{"body": "Hallo Anna"}`, string(rendered.Body))
+	a.Equal(`{"name": "Anna"}`, string(m.Body))
+
+	// the messages without template are not rendered
+	plain := &protocol.Message{ID: 43, HeaderJSON: `{"Expires": "2017-03-01T10:00:00Z"}`, Body: []byte(`{"body": "Hi"}`)}
+	rendered, err = registry.Render(plain, "de")
+	a.NoError(err)
+	a.True(rendered == plain)
+
+	_, err = registry.Render(&protocol.Message{HeaderJSON: `{"Template": "unknown"}`}, "de")
+	a.Error(err)
+	_, err = registry.Render(&protocol.Message{HeaderJSON: `{"Template": "shipped"}`, Body: []byte(`"Anna"`)}, "de")
+	a.Error(err)
+	_, err = registry.Render(&protocol.Message{HeaderJSON: `{"Template": "shipped"}`}, "de")
+	a.Error(err)
+
+	w = serve(http.MethodDelete, "/admin/templates/shipped", "")
+	a.JSONEq(`{"deleted": "shipped"}`, w.Body.String())
+	_, err = registry.Render(m, "de")
+	a.Error(err)
+
+	w = serve(http.MethodGet, "/admin/templates/shipped", "")
+	a.Equal(http.StatusNotFound, w.Code)
+	w = serve(http.MethodDelete, "/admin/templates/", "")
+	a.Equal(http.StatusBadRequest, w.Code)
+	w = serve(http.MethodGet, "/admin/templates/shipped/more", "")
+	a.Equal(http.StatusNotFound, w.Code)
+}
+
+func Test_templateKey(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("shipped", templateKey(&protocol.Message{HeaderJSON: `{"Template": "shipped"}`}))
+	a.Equal("shipped", templateKey(&protocol.Message{HeaderJSON: `{"template": "shipped"}`}))
+	a.Equal("", templateKey(&protocol.Message{HeaderJSON: `{"Template": 1}`}))
+	a.Equal("", templateKey(&protocol.Message{HeaderJSON: `no json`}))
+	a.Equal("", templateKey(&protocol.Message{}))
+}
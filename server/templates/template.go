@@ -0,0 +1,140 @@
+// Package templates renders the push payloads of the messages referencing a template, in the locale of each device.
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+var ErrInvalidTemplate = errors.New("Invalid template.")
+
+// Template is a push payload translated in several locales.
+// The payloads are JSON values whose strings are Go text templates (e.g. `"Hello {{.name}}"`),
+// executed with the variables of the messages.
+type Template struct {
+	// DefaultLocale is the locale of the payload sent to the devices whose locale has no payload
+	DefaultLocale string                     `json:"default_locale"`
+	Locales       map[string]json.RawMessage `json:"locales"`
+
+	// payloads are the decoded payloads, by normalized locale
+	payloads map[string]interface{}
+}
+
+// Parse returns the template, after checking its payloads.
+// The default locale may be omitted when the template has a single locale.
+func Parse(data []byte) (*Template, error) {
+	t := new(Template)
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("%v %s", ErrInvalidTemplate, err.Error())
+	}
+	if len(t.Locales) == 0 {
+		return nil, fmt.Errorf("%v Missing locales", ErrInvalidTemplate)
+	}
+
+	t.payloads = make(map[string]interface{}, len(t.Locales))
+	for locale, raw := range t.Locales {
+		var payload interface{}
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return nil, fmt.Errorf("%v Invalid payload of locale %q: %s", ErrInvalidTemplate, locale, err.Error())
+		}
+		if _, err := render(payload, check); err != nil {
+			return nil, fmt.Errorf("%v Invalid payload of locale %q: %s", ErrInvalidTemplate, locale, err.Error())
+		}
+		t.payloads[normalize(locale)] = payload
+		if t.DefaultLocale == "" && len(t.Locales) == 1 {
+			t.DefaultLocale = locale
+		}
+	}
+	if _, ok := t.payloads[normalize(t.DefaultLocale)]; !ok {
+		return nil, fmt.Errorf("%v The default locale %q has no payload", ErrInvalidTemplate, t.DefaultLocale)
+	}
+	return t, nil
+}
+
+// Render returns the JSON payload of the locale, with the variables substituted.
+func (t *Template) Render(locale string, variables map[string]interface{}) ([]byte, error) {
+	rendered, err := render(t.payload(locale), func(s string) (string, error) {
+		if !strings.Contains(s, "{{") {
+			return s, nil
+		}
+		tmpl, err := parse(s)
+		if err != nil {
+			return "", err
+		}
+		var buff bytes.Buffer
+		if err := tmpl.Execute(&buff, variables); err != nil {
+			return "", err
+		}
+		return buff.String(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rendered)
+}
+
+// payload returns the payload of the exact locale (e.g. `de-AT`), or else of its language (`de`),
+// or else of the default locale.
+func (t *Template) payload(locale string) interface{} {
+	locale = normalize(locale)
+	if p, ok := t.payloads[locale]; ok {
+		return p
+	}
+	if i := strings.IndexByte(locale, '-'); i > 0 {
+		if p, ok := t.payloads[locale[:i]]; ok {
+			return p
+		}
+	}
+	return t.payloads[normalize(t.DefaultLocale)]
+}
+
+// normalize returns the locale in lower case, with `-` separating its parts (`de_AT` and `de-at` are both `de-at`).
+func normalize(locale string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+}
+
+// render returns a copy of the JSON value, whose strings are replaced by the results of f.
+func render(value interface{}, f func(string) (string, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return f(v)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			r, err := render(item, f)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := render(item, f)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	default:
+		return v, nil
+	}
+}
+
+// check returns the error of a string which is not a valid text template.
+func check(s string) (string, error) {
+	_, err := parse(s)
+	return s, err
+}
+
+// parse parses a text template, whose execution fails on the missing variables
+// (instead of sending `<no value>` to the devices).
+func parse(s string) (*template.Template, error) {
+	return template.New("").Option("missingkey=error").Parse(s)
+}
@@ -0,0 +1,55 @@
+package templates
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	a := assert.New(t)
+
+	tmpl, err := Parse([]byte(`{"locales": {"en": {"body": "Hi {{.name}}"}}}`))
+	a.NoError(err)
+	a.Equal("en", tmpl.DefaultLocale)
+
+	for _, invalid := range []string{
+		`not json`,
+		`{"default_locale": "en"}`,
+		`{"default_locale": "fr", "locales": {"en": {"body": "Hi"}}}`,
+		`{"locales": {"en": {"body": "Hi"}, "de": {"body": "Hallo"}}}`,
+		`{"locales": {"en": {"body": "Hi {{.name"}}}`,
+		`{"locales": {"en": nope}}`,
+	} {
+		_, err := Parse([]byte(invalid))
+		a.Error(err, invalid)
+	}
+}
+
+func TestTemplate_RenderSelectsLocale(t *testing.T) {
+	a := assert.New(t)
+
+	tmpl, err := Parse([]byte(`{"default_locale": "en", "locales": {
+		"en": {"notification": {"body": "Hi {{.name}}, order {{.order}} is on its way"}, "data": {"counts": ["{{.count}}", 3]}},
+		"de": {"notification": {"body": "Hallo {{.name}}"}, "priority": 10},
+		"de_CH": {"notification": {"body": "Grüezi {{.name}}"}}}}`))
+	a.NoError(err)
+	variables := map[string]interface{}{"name": "Anna", "order": "A-42", "count": 2.0}
+
+	for locale, expected := range map[string]string{
+		"en":    `{"notification": {"body": "Hi Anna, order A-42 is on its way"}, "data": {"counts": ["2", 3]}}`,
+		"de":    `{"notification": {"body": "Hallo Anna"}, "priority": 10}`,
+		"de-AT": `{"notification": {"body": "Hallo Anna"}, "priority": 10}`,
+		"de-ch": `{"notification": {"body": "Grüezi Anna"}}`,
+		"fr":    `{"notification": {"body": "Hi Anna, order A-42 is on its way"}, "data": {"counts": ["2", 3]}}`,
+		"":      `{"notification": {"body": "Hi Anna, order A-42 is on its way"}, "data": {"counts": ["2", 3]}}`,
+	} {
+		rendered, err := tmpl.Render(locale, variables)
+		a.NoError(err, locale)
+		a.JSONEq(expected, string(rendered), locale)
+	}
+
+	// the missing variables are not rendered as `<no value>`
+	_, err = tmpl.Render("de", map[string]interface{}{})
+	a.Error(err)
+}
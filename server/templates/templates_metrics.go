@@ -0,0 +1,12 @@
+package templates
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns              = metrics.NS("templates")
+	mTotalRendered  = ns.NewInt("total_rendered_messages")
+	mTotalErrors    = ns.NewInt("total_render_errors")
+	mTotalTemplates = ns.NewInt("current_templates")
+)
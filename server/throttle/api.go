@@ -0,0 +1,34 @@
+package throttle
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (l *Limiter) GetPrefix() string {
+	return l.prefix
+}
+
+// ServeHTTP lists the throttled sources (`GET <prefix>`), and lifts the ban of a source (`DELETE <prefix><source>`).
+// It is a part of the service.endpoint implementation.
+func (l *Limiter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	key := strings.Trim(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(l.prefix, "/")), "/")
+	switch {
+	case req.Method == http.MethodGet && key == "":
+		json.NewEncoder(w).Encode(l.Throttled())
+	case req.Method == http.MethodDelete && key != "":
+		if !l.Unban(key) {
+			http.Error(w, `{"error": "Unknown source."}`, http.StatusNotFound)
+			return
+		}
+		logger.WithField("source", key).Info("Ban lifted")
+		w.Write([]byte(`{"unbanned": true}`))
+	default:
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET and DELETE <source> are accepted."}`, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,259 @@
+package throttle
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBan is the default duration of the bans.
+	DefaultBan = 10 * time.Minute
+
+	// sweepInterval is the interval at which the idle sources are forgotten.
+	sweepInterval = time.Minute
+)
+
+// Config configures the rate limiting of the requests.
+type Config struct {
+	// Rate is the number of requests per second allowed for each source
+	Rate float64
+
+	// Burst is the number of requests a source can send at once (default: the rate, at least 1)
+	Burst int
+
+	// BanAfter is the number of rejected requests after which a source is banned (never, if not positive).
+	// The count restarts once the source slowed down enough for its requests to be allowed at their full burst again.
+	BanAfter int
+
+	// Ban is the duration of the bans (default: DefaultBan)
+	Ban time.Duration
+
+	// TrustForwarded takes the first address of the X-Forwarded-For header as the address of the requests
+	// (only when guble is behind a proxy setting it, otherwise the clients can choose their address)
+	TrustForwarded bool
+}
+
+// source is the token bucket of an address or credential.
+type source struct {
+	tokens      float64
+	updated     time.Time
+	rejected    int
+	bannedUntil time.Time
+}
+
+// Throttled is a source whose requests are currently rejected.
+type Throttled struct {
+	Source      string     `json:"source"`
+	Rejected    int        `json:"rejected"`
+	BannedUntil *time.Time `json:"banned_until,omitempty"`
+}
+
+type bySource []Throttled
+
+func (s bySource) Len() int           { return len(s) }
+func (s bySource) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySource) Less(i, j int) bool { return s[i].Source < s[j].Source }
+
+// Limiter limits the rate of the requests of each source: the address of the client,
+// and the credential given as Authorization header (so that a client using many addresses is limited as well).
+// The sources exceeding their rate repeatedly are banned for a while.
+// The Limiter is an endpoint listing the throttled sources (`GET <prefix>`), and lifting their bans (`DELETE <prefix><source>`).
+// A nil Limiter allows all the requests.
+type Limiter struct {
+	config  Config
+	prefix  string
+	mu      sync.Mutex
+	sources map[string]*source
+	now     func() time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a new Limiter, served with the given prefix.
+func New(config Config, prefix string) *Limiter {
+	if config.Burst <= 0 {
+		config.Burst = int(math.Max(1, math.Ceil(config.Rate)))
+	}
+	if config.Ban <= 0 {
+		config.Ban = DefaultBan
+	}
+	return &Limiter{
+		config:  config,
+		prefix:  prefix,
+		sources: make(map[string]*source),
+		now:     time.Now,
+	}
+}
+
+// Start forgets the idle sources periodically.
+func (l *Limiter) Start() error {
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	l.wg.Add(1)
+	go l.sweepLoop()
+	return nil
+}
+
+// Stop stops forgetting the idle sources.
+func (l *Limiter) Stop() error {
+	l.cancel()
+	l.wg.Wait()
+	return nil
+}
+
+// Limit returns a handler serving only the requests allowed by the Limiter.
+func (l *Limiter) Limit(h http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if l.Allow(w, req) {
+			h(w, req)
+		}
+	}
+}
+
+// Allow returns true if the request is allowed, otherwise it answers it with `429 Too Many Requests`.
+func (l *Limiter) Allow(w http.ResponseWriter, req *http.Request) bool {
+	if l == nil {
+		return true
+	}
+	for _, key := range l.sourceKeys(req) {
+		if ok, retryAfter := l.allow(key); !ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, `{"error":"Too many requests."}`, http.StatusTooManyRequests)
+			return false
+		}
+	}
+	return true
+}
+
+// sourceKeys returns the sources of the request: its address, and its credential (hashed, so that it is not listed).
+func (l *Limiter) sourceKeys(req *http.Request) []string {
+	addr, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		addr = req.RemoteAddr
+	}
+	if forwarded := req.Header.Get("X-Forwarded-For"); l.config.TrustForwarded && forwarded != "" {
+		addr = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	keys := []string{"ip:" + addr}
+	if credential := req.Header.Get("Authorization"); credential != "" {
+		hash := sha256.Sum256([]byte(credential))
+		keys = append(keys, "credential:"+hex.EncodeToString(hash[:8]))
+	}
+	return keys
+}
+
+// allow takes a token from the bucket of the source, and returns false and the time to wait if it is empty or banned.
+func (l *Limiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	s, exist := l.sources[key]
+	if !exist {
+		s = &source{tokens: float64(l.config.Burst), updated: now}
+		l.sources[key] = s
+	}
+	if now.Before(s.bannedUntil) {
+		mRejected.Add(1)
+		return false, s.bannedUntil.Sub(now)
+	}
+
+	l.refill(s, now)
+	if s.tokens >= 1 {
+		s.tokens--
+		return true, 0
+	}
+
+	mRejected.Add(1)
+	s.rejected++
+	if l.config.BanAfter > 0 && s.rejected >= l.config.BanAfter {
+		logger.WithField("source", key).WithField("rejected", s.rejected).Warn("Banning a source exceeding its rate")
+		mBans.Add(1)
+		s.bannedUntil = now.Add(l.config.Ban)
+		s.rejected = 0
+		return false, l.config.Ban
+	}
+	return false, time.Duration((1 - s.tokens) / l.config.Rate * float64(time.Second))
+}
+
+// refill adds the tokens earned since the last update, and forgets the rejections once the bucket is full.
+func (l *Limiter) refill(s *source, now time.Time) {
+	burst := float64(l.config.Burst)
+	s.tokens = math.Min(burst, s.tokens+now.Sub(s.updated).Seconds()*l.config.Rate)
+	s.updated = now
+	if s.tokens == burst {
+		s.rejected = 0
+	}
+}
+
+// Throttled returns the sources which are banned, or had requests rejected since their bucket was last full.
+func (l *Limiter) Throttled() []Throttled {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	throttled := make([]Throttled, 0)
+	for key, s := range l.sources {
+		if now.Before(s.bannedUntil) {
+			bannedUntil := s.bannedUntil
+			throttled = append(throttled, Throttled{Source: key, Rejected: s.rejected, BannedUntil: &bannedUntil})
+			continue
+		}
+		if l.refill(s, now); s.rejected > 0 {
+			throttled = append(throttled, Throttled{Source: key, Rejected: s.rejected})
+		}
+	}
+	sort.Sort(bySource(throttled))
+	return throttled
+}
+
+// Unban forgets the source, lifting its ban, and returns false if it is not known.
+func (l *Limiter) Unban(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, exist := l.sources[key]
+	delete(l.sources, key)
+	return exist
+}
+
+func (l *Limiter) sweepLoop() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.sweep()
+		case <-l.ctx.Done():
+			return
+		}
+	}
+}
+
+// sweep forgets the sources which are not banned, and whose bucket is full again.
+func (l *Limiter) sweep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key, s := range l.sources {
+		if l.refill(s, now); !now.Before(s.bannedUntil) && s.tokens == float64(l.config.Burst) {
+			delete(l.sources, key)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package throttle
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testLimiter returns a Limiter with a clock advanced by the returned function.
+func testLimiter(config Config) (*Limiter, func(time.Duration)) {
+	now := time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+	l := New(config, "/admin/throttled/")
+	l.now = func() time.Time {
+		return now
+	}
+	return l, func(d time.Duration) {
+		now = now.Add(d)
+	}
+}
+
+func request(addr, credential string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/message/topic", nil)
+	req.RemoteAddr = addr
+	if credential != "" {
+		req.Header.Set("Authorization", credential)
+	}
+	return req
+}
+
+func TestLimiter_RateAndBan(t *testing.T) {
+	a := assert.New(t)
+	l, advance := testLimiter(Config{Rate: 1, Burst: 2, BanAfter: 3, Ban: time.Minute})
+
+	served := 0
+	handler := l.Limit(func(w http.ResponseWriter, req *http.Request) {
+		served++
+	})
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		return recorder
+	}
+
+	a.Equal(http.StatusOK, serve(request("10.0.0.1:4000", "")).Code)
+	a.Equal(http.StatusOK, serve(request("10.0.0.1:4001", "")).Code)
+	recorder := serve(request("10.0.0.1:4002", ""))
+	a.Equal(http.StatusTooManyRequests, recorder.Code)
+	a.Equal("1", recorder.Header().Get("Retry-After"))
+	a.Equal(http.StatusOK, serve(request("10.0.0.2:4000", "")).Code)
+	a.Equal(3, served)
+
+	// a token is earned each second
+	advance(time.Second)
+	a.Equal(http.StatusOK, serve(request("10.0.0.1:4000", "")).Code)
+
+	// the source is banned after the third rejection since its bucket was full
+	a.Equal(http.StatusTooManyRequests, serve(request("10.0.0.1:4000", "")).Code)
+	recorder = serve(request("10.0.0.1:4000", ""))
+	a.Equal(http.StatusTooManyRequests, recorder.Code)
+	a.Equal("60", recorder.Header().Get("Retry-After"))
+	advance(10 * time.Second)
+	a.Equal(http.StatusTooManyRequests, serve(request("10.0.0.1:4000", "")).Code)
+	a.Equal(4, served)
+
+	throttled := l.Throttled()
+	a.Len(throttled, 1)
+	a.Equal("ip:10.0.0.1", throttled[0].Source)
+	a.Equal(time.Date(2017, 3, 1, 10, 0, 61, 0, time.UTC), *throttled[0].BannedUntil)
+
+	// the ban expires, and the idle sources are forgotten
+	advance(time.Minute)
+	a.Equal(http.StatusOK, serve(request("10.0.0.1:4000", "")).Code)
+	a.Empty(l.Throttled())
+	advance(time.Minute)
+	l.sweep()
+	a.Empty(l.sources)
+}
+
+func TestLimiter_Sources(t *testing.T) {
+	a := assert.New(t)
+	l, _ := testLimiter(Config{Rate: 1})
+
+	// a credential is limited across addresses
+	a.True(l.Allow(httptest.NewRecorder(), request("10.0.0.1:4000", "Bearer secret")))
+	a.False(l.Allow(httptest.NewRecorder(), request("10.0.0.2:4000", "Bearer secret")))
+	a.True(l.Allow(httptest.NewRecorder(), request("10.0.0.3:4000", "Bearer other")))
+
+	throttled := l.Throttled()
+	a.Len(throttled, 1)
+	a.Regexp("^credential:[0-9a-f]{16}$", throttled[0].Source)
+	a.Equal(1, throttled[0].Rejected)
+	a.Nil(throttled[0].BannedUntil)
+
+	// the forwarded address is only used when trusted
+	req := request("10.0.0.9:4000", "")
+	req.Header.Set("X-Forwarded-For", "192.168.1.5, 10.0.0.9")
+	a.Equal([]string{"ip:10.0.0.9"}, l.sourceKeys(req))
+	l.config.TrustForwarded = true
+	a.Equal([]string{"ip:192.168.1.5"}, l.sourceKeys(req))
+
+	// a nil Limiter allows all the requests
+	var disabled *Limiter
+	a.True(disabled.Allow(httptest.NewRecorder(), req))
+	a.NotNil(disabled.Limit(func(http.ResponseWriter, *http.Request) {}))
+}
+
+func TestLimiter_ServeHTTP(t *testing.T) {
+	a := assert.New(t)
+	l, _ := testLimiter(Config{Rate: 1, BanAfter: 1})
+	l.Allow(httptest.NewRecorder(), request("10.0.0.1:4000", ""))
+	l.Allow(httptest.NewRecorder(), request("10.0.0.1:4000", ""))
+
+	recorder := httptest.NewRecorder()
+	l.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/throttled/", nil))
+	a.Equal(http.StatusOK, recorder.Code)
+	var throttled []Throttled
+	a.NoError(json.NewDecoder(recorder.Body).Decode(&throttled))
+	a.Len(throttled, 1)
+	a.NotNil(throttled[0].BannedUntil)
+
+	recorder = httptest.NewRecorder()
+	l.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/admin/throttled/ip:10.0.0.1", nil))
+	a.Equal(`{"unbanned": true}`, recorder.Body.String())
+	a.True(l.Allow(httptest.NewRecorder(), request("10.0.0.1:4000", "")))
+
+	recorder = httptest.NewRecorder()
+	l.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/admin/throttled/ip:10.0.0.2", nil))
+	a.Equal(http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	l.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/admin/throttled/", nil))
+	a.Equal(http.StatusMethodNotAllowed, recorder.Code)
+}
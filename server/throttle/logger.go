@@ -0,0 +1,7 @@
+package throttle
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "throttle")
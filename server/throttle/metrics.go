@@ -0,0 +1,15 @@
+package throttle
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns = metrics.NS("throttle")
+
+	// mRejected is the number of requests rejected because their source exceeded its rate, or is banned
+	mRejected = ns.NewInt("rejected")
+
+	// mBans is the number of sources banned for exceeding their rate repeatedly
+	mBans = ns.NewInt("bans")
+)
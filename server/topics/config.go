@@ -0,0 +1,64 @@
+package topics
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// Config is the configuration of a topic, which applies to its subtopics too,
+// unless they have a configuration of their own.
+type Config struct {
+	// Persistent tells whether the messages of the topic are stored (default: true).
+	Persistent *bool `json:"persistent,omitempty"`
+
+	// TTL is the age from which the stored messages are not delivered anymore, e.g. `24h` (default: no limit).
+	TTL string `json:"ttl,omitempty"`
+
+	// MaxSize is the maximum size in bytes of the message bodies (default: no limit).
+	MaxSize int `json:"max_size,omitempty"`
+
+	// ACL is the topic whose permissions apply to the topic (default: the topic itself).
+	ACL protocol.Path `json:"acl,omitempty"`
+
+	// Schema is the topic whose JSON Schema the message bodies have to match,
+	// in addition to the schema registered for the topic itself.
+	Schema protocol.Path `json:"schema,omitempty"`
+
+	// Priority is the priority of the messages, for the consumers of the configuration.
+	Priority int `json:"priority,omitempty"`
+
+	ttl time.Duration
+}
+
+// Parse parses and checks the JSON of a topic configuration.
+func Parse(data []byte) (*Config, error) {
+	c := new(Config)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("Invalid topic configuration: %s", err.Error())
+	}
+	if c.TTL != "" {
+		ttl, err := time.ParseDuration(c.TTL)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("ttl has to be a positive duration, but was %q", c.TTL)
+		}
+		c.ttl = ttl
+	}
+	if c.MaxSize < 0 {
+		return nil, fmt.Errorf("max_size cannot be negative, but was %d", c.MaxSize)
+	}
+	for name, path := range map[string]protocol.Path{"acl": c.ACL, "schema": c.Schema} {
+		if path != "" && (!strings.HasPrefix(string(path), "/") || len(path) < 2) {
+			return nil, fmt.Errorf("%s has to be a topic path, but was %q", name, path)
+		}
+	}
+	return c, nil
+}
+
+// ephemeral returns true if the messages of the topic are not stored.
+func (c *Config) ephemeral() bool {
+	return c.Persistent != nil && !*c.Persistent
+}
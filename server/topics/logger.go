@@ -0,0 +1,7 @@
+package topics
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithField("module", "topics")
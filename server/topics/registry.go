@@ -0,0 +1,285 @@
+package topics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+)
+
+// kvSchema is the KVStore schema holding the configurations of the topics, keyed by topic path.
+const kvSchema = "topic_configs"
+
+// SchemaValidator validates a body against the JSON Schema registered for a topic.
+type SchemaValidator interface {
+	ValidateWith(topic protocol.Path, body []byte) error
+}
+
+// Registry holds the configurations of the topics, and applies them to the messages handled by the router:
+// the persistence, the TTL of the stored messages and the permissions are applied by the router,
+// the maximum size and the schema of the bodies are checked when publishing.
+// The configurations are managed with `ServeConfig`, served by the topics admin endpoint.
+type Registry struct {
+	router  router.Router
+	kvstore kvstore.KVStore
+	schemas SchemaValidator
+
+	mu      sync.RWMutex
+	configs map[protocol.Path]*Config
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRegistry returns a new Registry, applying the configurations to the router.
+// The referenced schemas are validated by the given validator, if not nil.
+func NewRegistry(router router.Router, schemas SchemaValidator) (*Registry, error) {
+	kvs, err := router.KVStore()
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{
+		router:  router,
+		kvstore: kvs,
+		schemas: schemas,
+		configs: make(map[protocol.Path]*Config),
+	}, nil
+}
+
+// Start loads the configurations, and begins applying them.
+func (r *Registry) Start() error {
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	for entry := range r.kvstore.Iterate(r.ctx, kvSchema, "", 0) {
+		c, err := Parse([]byte(entry[1]))
+		if err != nil {
+			logger.WithField("topic", entry[0]).WithField("error", err.Error()).Error("Ignoring invalid stored configuration")
+			continue
+		}
+		r.set(protocol.Path(entry[0]), c)
+	}
+
+	if w, ok := r.kvstore.(kvstore.Watcher); ok {
+		r.wg.Add(1)
+		go r.watch(w)
+	}
+
+	if i, ok := r.router.(router.Interceptable); ok {
+		i.AddInterceptor("topic-config", r)
+	} else {
+		logger.Error("The router does not accept interceptors, the sizes and schemas of the messages will not be checked")
+	}
+	if tc, ok := r.router.(router.TopicConfigurable); ok {
+		tc.SetTopicSettings(r)
+	} else {
+		logger.Error("The router does not apply the settings of the topics")
+	}
+	logger.WithField("configs", len(r.configs)).Info("Started topic configuration registry")
+	return nil
+}
+
+// Stop stops watching the configuration changes.
+func (r *Registry) Stop() error {
+	r.cancel()
+	r.wg.Wait()
+	logger.Info("Stopped topic configuration registry")
+	return nil
+}
+
+// Lookup returns the configuration of the topic, or of its nearest parent having one (nil if none has).
+func (r *Registry) Lookup(path protocol.Path) (protocol.Path, *Config) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.configs) == 0 {
+		return "", nil
+	}
+	topic := strings.TrimSuffix(string(path), "/")
+	for topic != "" {
+		if c, ok := r.configs[protocol.Path(topic)]; ok {
+			return protocol.Path(topic), c
+		}
+		topic = topic[:strings.LastIndex(topic, "/")]
+	}
+	return "", nil
+}
+
+// Intercept rejects the messages whose body is too large, or does not match the schema referenced by their topic.
+// It is a part of the router.Interceptor implementation.
+func (r *Registry) Intercept(m *protocol.Message) error {
+	topic, c := r.Lookup(m.Path)
+	if c == nil {
+		return nil
+	}
+	if c.MaxSize > 0 && len(m.Body) > c.MaxSize {
+		mTotalRejected.Add(1)
+		return fmt.Errorf("The body of %d bytes exceeds the maximum size of %d bytes of %s", len(m.Body), c.MaxSize, topic)
+	}
+	if c.Schema != "" && r.schemas != nil {
+		if err := r.schemas.ValidateWith(c.Schema, m.Body); err != nil {
+			mTotalRejected.Add(1)
+			return err
+		}
+	}
+	return nil
+}
+
+// Ephemeral is a part of the router.TopicSettings implementation.
+func (r *Registry) Ephemeral(topic protocol.Path) bool {
+	_, c := r.Lookup(topic)
+	return c != nil && c.ephemeral()
+}
+
+// AccessPath is a part of the router.TopicSettings implementation.
+func (r *Registry) AccessPath(topic protocol.Path) protocol.Path {
+	if _, c := r.Lookup(topic); c != nil && c.ACL != "" {
+		return c.ACL
+	}
+	return topic
+}
+
+// TTL is a part of the router.TopicSettings implementation.
+func (r *Registry) TTL(topic protocol.Path) time.Duration {
+	if _, c := r.Lookup(topic); c != nil {
+		return c.ttl
+	}
+	return 0
+}
+
+// watch applies the configuration changes done by other guble nodes, until the registry is stopped.
+func (r *Registry) watch(w kvstore.Watcher) {
+	defer r.wg.Done()
+
+	changesC, err := w.Watch(r.ctx, kvSchema)
+	if err != nil {
+		logger.WithField("error", err.Error()).Info("Not watching the configuration changes")
+		return
+	}
+	for change := range changesC {
+		topic := protocol.Path(change.Key)
+		if change.Deleted {
+			r.set(topic, nil)
+			continue
+		}
+		data, exist, err := r.kvstore.Get(kvSchema, change.Key)
+		if err != nil || !exist {
+			continue
+		}
+		c, err := Parse(data)
+		if err != nil {
+			logger.WithField("topic", topic).WithField("error", err.Error()).Error("Ignoring invalid configuration change")
+			continue
+		}
+		r.set(topic, c)
+	}
+}
+
+// set caches the configuration of a topic, or removes it if nil.
+func (r *Registry) set(topic protocol.Path, c *Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c == nil {
+		delete(r.configs, topic)
+	} else {
+		r.configs[topic] = c
+	}
+	mTotalConfigs.Set(int64(len(r.configs)))
+}
+
+// ServeConfig manages the configuration of a topic:
+// `GET` returns it, `PUT` (or `POST`) sets it, and `DELETE` removes it.
+// Without topic, `GET` returns the configurations of all the topics.
+func (r *Registry) ServeConfig(w http.ResponseWriter, req *http.Request, topic protocol.Path) {
+	w.Header().Set("Content-Type", "application/json")
+
+	topic = protocol.Path(strings.TrimSuffix(string(topic), "/"))
+	if topic == "" || topic == "/" {
+		if req.Method != http.MethodGet {
+			http.Error(w, `{"error": "Missing topic."}`, http.StatusBadRequest)
+			return
+		}
+		r.listConfigs(w)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		r.getConfig(w, topic)
+	case http.MethodPut, http.MethodPost:
+		r.putConfig(w, req, topic)
+	case http.MethodDelete:
+		r.deleteConfig(w, topic)
+	default:
+		http.Error(w, `{"error": "Method not allowed. Only HTTP GET, PUT, POST and DELETE are accepted."}`, http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) listConfigs(w http.ResponseWriter) {
+	r.mu.RLock()
+	configs := make(map[protocol.Path]*Config, len(r.configs))
+	for topic, c := range r.configs {
+		configs[topic] = c
+	}
+	r.mu.RUnlock()
+
+	json.NewEncoder(w).Encode(configs)
+}
+
+func (r *Registry) getConfig(w http.ResponseWriter, topic protocol.Path) {
+	data, exist, err := r.kvstore.Get(kvSchema, string(topic))
+	if err != nil {
+		logger.WithField("error", err.Error()).WithField("topic", topic).Error("Error loading the configuration")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	if !exist {
+		http.Error(w, `{"error": "Configuration not found."}`, http.StatusNotFound)
+		return
+	}
+	w.Write(data)
+}
+
+func (r *Registry) putConfig(w http.ResponseWriter, req *http.Request, topic protocol.Path) {
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	c, err := Parse(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	// stored as parsed, without the unknown fields
+	data, _ = json.Marshal(c)
+	if err := r.kvstore.Put(kvSchema, string(topic), data); err != nil {
+		logger.WithField("error", err.Error()).WithField("topic", topic).Error("Error storing the configuration")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	r.set(topic, c)
+	logger.WithField("topic", topic).WithField("config", string(data)).Info("Configured topic")
+	w.Write(data)
+}
+
+func (r *Registry) deleteConfig(w http.ResponseWriter, topic protocol.Path) {
+	if err := r.kvstore.Delete(kvSchema, string(topic)); err != nil {
+		logger.WithField("error", err.Error()).WithField("topic", topic).Error("Error deleting the configuration")
+		http.Error(w, `{"error": "Server error."}`, http.StatusInternalServerError)
+		return
+	}
+	r.set(topic, nil)
+	logger.WithField("topic", topic).Info("Removed topic configuration")
+	fmt.Fprintf(w, `{"deleted": %q}`, topic)
+}
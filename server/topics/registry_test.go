@@ -0,0 +1,105 @@
+package topics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/schema"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/dummystore"
+)
+
+func TestRegistry_ManagesAndAppliesTheConfigurations(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	a.NoError(kvs.Put(kvSchema, "/stored", []byte(`{"persistent": false}`)))
+	r := router.New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil)
+	a.NoError(r.(service.Startable).Start())
+	defer r.(service.Stopable).Stop()
+
+	schemas, err := schema.NewRegistry(r, "/admin/schemas/")
+	a.NoError(err)
+	a.NoError(schemas.Start())
+	defer schemas.Stop()
+
+	registry, err := NewRegistry(r, schemas)
+	a.NoError(err)
+	a.NoError(registry.Start())
+	defer registry.Stop()
+
+	serve := func(method string, topic protocol.Path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/admin/topics"+string(topic)+"/config", strings.NewReader(body))
+		registry.ServeConfig(w, req, topic)
+		return w
+	}
+
+	w := serve(http.MethodPut, "/users", `{"ttl": "24h", "max_size": 20, "acl": "/acl/users", "priority": 2, "unknown": 1}`)
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"ttl": "24h", "max_size": 20, "acl": "/acl/users", "priority": 2}`, w.Body.String())
+
+	a.Equal(http.StatusBadRequest, serve(http.MethodPut, "/other", `{"ttl": "soon"}`).Code)
+	a.Equal(http.StatusBadRequest, serve(http.MethodPut, "/other", `{"max_size": -1}`).Code)
+	a.Equal(http.StatusBadRequest, serve(http.MethodPut, "/other", `{"schema": "users"}`).Code)
+	a.Equal(http.StatusBadRequest, serve(http.MethodPut, "", `{}`).Code)
+
+	w = serve(http.MethodGet, "/users", "")
+	a.JSONEq(`{"ttl": "24h", "max_size": 20, "acl": "/acl/users", "priority": 2}`, w.Body.String())
+	w = serve(http.MethodGet, "", "")
+	a.JSONEq(`{"/stored": {"persistent": false}, "/users": {"ttl": "24h", "max_size": 20, "acl": "/acl/users", "priority": 2}}`,
+		w.Body.String())
+
+	// the settings apply to the subtopics
+	a.True(registry.Ephemeral("/stored/sub"))
+	a.False(registry.Ephemeral("/users/bob"))
+	a.Equal(protocol.Path("/acl/users"), registry.AccessPath("/users/bob"))
+	a.Equal(protocol.Path("/other"), registry.AccessPath("/other"))
+	a.Equal(24*time.Hour, registry.TTL("/users/bob"))
+	a.Equal(time.Duration(0), registry.TTL("/other"))
+
+	// the sizes are checked when publishing
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/users/bob", Body: []byte("small")}))
+	a.Error(r.HandleMessage(&protocol.Message{Path: "/users/bob", Body: []byte("a body larger than the maximum")}))
+
+	// and the referenced schemas
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/admin/schemas/names", strings.NewReader(`{"type": "string"}`))
+	schemas.ServeHTTP(w, req)
+	a.Equal(http.StatusOK, w.Code)
+	serve(http.MethodPut, "/people", `{"schema": "/names"}`)
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/people/bob", Body: []byte(`"Bob"`)}))
+	a.Error(r.HandleMessage(&protocol.Message{Path: "/people/bob", Body: []byte(`{"name": "Bob"}`)}))
+
+	w = serve(http.MethodDelete, "/users", "")
+	a.JSONEq(`{"deleted": "/users"}`, w.Body.String())
+	a.Equal(http.StatusNotFound, serve(http.MethodGet, "/users", "").Code)
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/users/bob", Body: []byte("a body larger than the maximum")}))
+}
+
+func TestRegistry_MessagesExpireAfterTheTTL(t *testing.T) {
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	a.NoError(kvs.Put(kvSchema, "/news", []byte(`{"ttl": "1h"}`)))
+	r := router.New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil)
+
+	registry, err := NewRegistry(r, nil)
+	a.NoError(err)
+	a.NoError(registry.Start())
+	defer registry.Stop()
+
+	now := time.Now()
+	a.True(router.IsExpired(r, &protocol.Message{Path: "/news/sport", Time: now.Add(-2 * time.Hour).Unix()}))
+	a.False(router.IsExpired(r, &protocol.Message{Path: "/news/sport", Time: now.Unix()}))
+	a.False(router.IsExpired(r, &protocol.Message{Path: "/weather", Time: now.Add(-2 * time.Hour).Unix()}))
+}
@@ -0,0 +1,11 @@
+package topics
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns             = metrics.NS("topics")
+	mTotalRejected = ns.NewInt("total_rejected_messages")
+	mTotalConfigs  = ns.NewInt("current_configs")
+)
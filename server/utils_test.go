@@ -20,7 +20,6 @@ import (
 	"github.com/smancke/guble/client"
 	"github.com/smancke/guble/server/service"
 	"github.com/stretchr/testify/assert"
-	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 type testClusterNodeConfig struct {
@@ -33,14 +32,16 @@ type testClusterNodeConfig struct {
 	Remotes     string
 }
 
-func (tnc *testClusterNodeConfig) parseConfig() error {
+// parseConfig returns the configuration of the node, without changing the global Config,
+// so that differently configured nodes can run in the same process.
+func (tnc *testClusterNodeConfig) parseConfig() (*GubleConfig, error) {
 	var err error
 
 	dir := tnc.StoragePath
 	if dir == "" {
 		dir, err = ioutil.TempDir("", "guble_test")
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	tnc.StoragePath = dir
@@ -66,7 +67,7 @@ func (tnc *testClusterNodeConfig) parseConfig() error {
 
 	if tnc.NodeID > 0 {
 		if tnc.Remotes == "" {
-			return fmt.Errorf("Missing Remotes value when running in cluster mode.")
+			return nil, fmt.Errorf("Missing Remotes value when running in cluster mode.")
 		}
 
 		args = append(
@@ -77,8 +78,7 @@ func (tnc *testClusterNodeConfig) parseConfig() error {
 		)
 	}
 
-	_, err = kingpin.CommandLine.Parse(args)
-	return err
+	return NewConfig(args)
 }
 
 type testClusterNode struct {
@@ -91,12 +91,12 @@ type testClusterNode struct {
 func newTestClusterNode(t *testing.T, nodeConfig testClusterNodeConfig) *testClusterNode {
 	a := assert.New(t)
 
-	err := nodeConfig.parseConfig()
+	config, err := nodeConfig.parseConfig()
 	if !a.NoError(err) {
 		return nil
 	}
 
-	s := StartService()
+	s := StartServiceWithConfig(config)
 
 	var (
 		fcmConnector connector.ResponsiveConnector
@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// DeliveryHeader is the reserved field of the message header, in which the annotations of the delivery are given
+// to the subscriptions with the `annotate=true` option. A field of the same name set by the publisher is replaced.
+const DeliveryHeader = "_delivery"
+
+// deliveryAnnotation describes the delivery of a message to a subscription.
+type deliveryAnnotation struct {
+	// Time is the time of the delivery, in milliseconds since the epoch
+	Time int64 `json:"time"`
+
+	// Subscription identifies the subscription, uniquely on the server
+	Subscription string `json:"subscription"`
+
+	// Redelivery is the number of previous subscriptions of the connection to the path which delivered the message
+	Redelivery int `json:"redelivery"`
+}
+
+// deliveryLog keeps the ranges of the message IDs delivered to the annotated subscriptions of a connection,
+// by path, so that the messages replayed again (e.g. after subscribing again from an earlier ID) are counted
+// as redeliveries.
+type deliveryLog struct {
+	mu     sync.Mutex
+	ranges map[protocol.Path][]*idRange
+	count  int
+}
+
+// idRange is the range of the message IDs delivered to a subscription, which are delivered in ascending order.
+type idRange struct {
+	first, last uint64
+}
+
+func newDeliveryLog() *deliveryLog {
+	return &deliveryLog{ranges: make(map[protocol.Path][]*idRange)}
+}
+
+// open starts the range of a new subscription to a path, and returns it with the number of the subscription.
+func (l *deliveryLog) open(path protocol.Path) (*idRange, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r := &idRange{}
+	l.ranges[path] = append(l.ranges[path], r)
+	l.count++
+	return r, l.count
+}
+
+// record adds a message ID to the range of a subscription, and returns the number of the other ranges
+// of the path which contain it.
+func (l *deliveryLog) record(path protocol.Path, r *idRange, id uint64) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	redeliveries := 0
+	for _, other := range l.ranges[path] {
+		if other != r && other.first != 0 && other.first <= id && id <= other.last {
+			redeliveries++
+		}
+	}
+	if r.first == 0 {
+		r.first = id
+	}
+	if id > r.last {
+		r.last = id
+	}
+	return redeliveries
+}
+
+// annotate returns the message with the annotation of its delivery in its header.
+// A message which cannot be annotated (e.g. with an invalid header) is returned as it is.
+func annotate(raw []byte, annotation deliveryAnnotation) []byte {
+	m, err := protocol.ParseMessage(raw)
+	if err != nil {
+		return raw
+	}
+	header := make(map[string]json.RawMessage)
+	if m.HeaderJSON != "" {
+		if err := json.Unmarshal([]byte(m.HeaderJSON), &header); err != nil {
+			return raw
+		}
+	}
+	header[DeliveryHeader], _ = json.Marshal(annotation)
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return raw
+	}
+	m.HeaderJSON = string(headerJSON)
+	return m.Bytes()
+}
+
+// annotated returns a message delivered by the receiver, annotated if the subscription annotates its messages.
+func (rec *Receiver) annotated(id uint64, raw []byte) []byte {
+	if !rec.annotate || rec.deliveries == nil {
+		return raw
+	}
+	if rec.delivered == nil {
+		var n int
+		rec.delivered, n = rec.deliveries.open(rec.path)
+		rec.subscriptionID = fmt.Sprintf("%s-%d", rec.applicationID, n)
+	}
+	return annotate(raw, deliveryAnnotation{
+		Time:         time.Now().UnixNano() / int64(time.Millisecond),
+		Subscription: rec.subscriptionID,
+		Redelivery:   rec.deliveries.record(rec.path, rec.delivered, id),
+	})
+}
@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestDeliveryLog_CountsTheRedeliveries(t *testing.T) {
+	a := assert.New(t)
+
+	l := newDeliveryLog()
+	first, n := l.open("/foo")
+	a.Equal(1, n)
+	a.Equal(0, l.record("/foo", first, 1))
+	a.Equal(0, l.record("/foo", first, 2))
+	a.Equal(0, l.record("/foo", first, 3))
+
+	// a second subscription to the path, replaying from the start
+	second, n := l.open("/foo")
+	a.Equal(2, n)
+	a.Equal(1, l.record("/foo", second, 2))
+	a.Equal(1, l.record("/foo", second, 3))
+	a.Equal(0, l.record("/foo", second, 4))
+
+	third, _ := l.open("/foo")
+	a.Equal(2, l.record("/foo", third, 3))
+	a.Equal(1, l.record("/foo", third, 4))
+
+	// the other paths are counted apart
+	other, _ := l.open("/bar")
+	a.Equal(0, l.record("/bar", other, 3))
+}
+
+func TestAnnotate_KeepsTheHeaderOfThePublisher(t *testing.T) {
+	a := assert.New(t)
+
+	raw := (&protocol.Message{ID: 42, Path: "/foo", HeaderJSON: `{"Key":"Value","_delivery":"forged"}`, Body: []byte("Hello")}).Bytes()
+	annotated := annotate(raw, deliveryAnnotation{Time: 1420110000123, Subscription: "app-1", Redelivery: 1})
+
+	m, err := protocol.ParseMessage(annotated)
+	a.NoError(err)
+	a.Equal(uint64(42), m.ID)
+	a.Equal("Hello", string(m.Body))
+	a.JSONEq(`{"Key": "Value", "_delivery": {"time": 1420110000123, "subscription": "app-1", "redelivery": 1}}`, m.HeaderJSON)
+
+	// without header
+	m, err = protocol.ParseMessage(annotate(aTestMessage.Bytes(), deliveryAnnotation{Subscription: "app-1"}))
+	a.NoError(err)
+	var header map[string]deliveryAnnotation
+	a.NoError(json.Unmarshal([]byte(m.HeaderJSON), &header))
+	a.Equal("app-1", header[DeliveryHeader].Subscription)
+
+	// an invalid header is not changed
+	raw = (&protocol.Message{ID: 42, Path: "/foo", HeaderJSON: `not json`, Body: []byte("Hello")}).Bytes()
+	a.Equal(raw, annotate(raw, deliveryAnnotation{}))
+}
+
+func TestReceiver_AnnotatesTheMessages(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	rec, _, _, _, err := aMockedReceiver("/foo annotate=true")
+	a.NoError(err)
+	a.True(rec.annotate)
+	a.Nil(rec.throttle)
+	rec.deliveries = newDeliveryLog()
+
+	m, err := protocol.ParseMessage(rec.annotated(42, aTestMessage.Bytes()))
+	a.NoError(err)
+	var header map[string]deliveryAnnotation
+	a.NoError(json.Unmarshal([]byte(m.HeaderJSON), &header))
+	a.Equal("any-appId-1", header[DeliveryHeader].Subscription)
+	a.Equal(0, header[DeliveryHeader].Redelivery)
+	a.True(header[DeliveryHeader].Time > 0)
+
+	// without the option, the messages are sent as they are
+	rec, _, _, _, err = aMockedReceiver("/foo")
+	a.NoError(err)
+	rec.deliveries = newDeliveryLog()
+	a.Equal(aTestMessage.Bytes(), rec.annotated(42, aTestMessage.Bytes()))
+}
@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/smancke/guble/protocol"
+)
+
+const (
+	// BatchSubprotocol is the websocket subprotocol of the line-based guble format, in which a frame sent to
+	// the client holds one or more messages and notifications, each prefixed by its length in bytes and a newline.
+	BatchSubprotocol = "guble-batch"
+
+	// JSONBatchSubprotocol is the JSON subprotocol, in which a frame sent to the client holds one or more
+	// messages and notifications as newline-delimited JSON objects.
+	JSONBatchSubprotocol = "guble-json-batch"
+)
+
+var (
+	// maxBatchMessages is the maximum number of messages and notifications coalesced in a frame
+	maxBatchMessages = 100
+
+	// maxBatchSize is the size in bytes of the messages above which no further message is added to a frame
+	maxBatchSize = 64 * 1024
+)
+
+// sendBatch sends a message or notification in a single frame with the ones already pending for the connection
+// (as long as the client has credits for them), without waiting for further ones.
+// It returns false if the connection failed.
+func (ws *WebSocket) sendBatch(first []byte) bool {
+	batch := [][]byte{first}
+	size := len(first)
+COLLECT:
+	for len(batch) < maxBatchMessages && size < maxBatchSize && ws.flow.available() {
+		select {
+		case raw, ok := <-ws.sendChannel:
+			if !ok {
+				break COLLECT
+			}
+			if ws.checkAccess(raw) {
+				// only the sending goroutine takes credits, so the available credit is not taken meanwhile
+				if isMessage(raw) {
+					ws.flow.tryAcquire()
+				}
+				batch = append(batch, raw)
+				size += len(raw)
+			} else {
+				batch = append(batch, ws.revokeAccess()...)
+			}
+		default:
+			break COLLECT
+		}
+	}
+
+	frame := ws.encodeBatch(batch)
+	if len(frame) == 0 {
+		return true
+	}
+	return ws.sendFrame(frame)
+}
+
+// encodeBatch encodes the messages and notifications of a frame in the format of the negotiated subprotocol.
+func (ws *WebSocket) encodeBatch(batch [][]byte) []byte {
+	var frame bytes.Buffer
+	for _, raw := range batch {
+		if ws.jsonFrames {
+			jsonFrame, err := toJSONFrame(raw)
+			if err != nil {
+				logger.WithError(err).WithField("actualContent", protocol.Redact(raw)).Error("Could not encode as JSON")
+				continue
+			}
+			frame.Write(jsonFrame)
+			frame.WriteByte('\n')
+			continue
+		}
+		frame.WriteString(strconv.Itoa(len(raw)))
+		frame.WriteByte('\n')
+		frame.Write(raw)
+	}
+	return frame.Bytes()
+}
@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestWebSocket_SendBatchCoalescesThePendingMessages(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	first := aTestMessage.Bytes()
+	second := (&protocol.Message{ID: 43, Path: "/foo", Body: []byte("Second")}).Bytes()
+	notification := (&protocol.NotificationMessage{Name: protocol.SUCCESS_SEND, Arg: "43"}).Bytes()
+
+	var frames [][]byte
+	wsconn := NewMockWSConnection(testutil.MockCtrl)
+	wsconn.EXPECT().Send(gomock.Any()).Do(func(frame []byte) error {
+		frames = append(frames, frame)
+		return nil
+	}).Return(nil).Times(2)
+
+	ws := NewWebSocket(testWSHandler(nil, auth.NewAllowAllAccessManager(true)), wsconn, "testuser")
+	ws.batchFrames = true
+
+	// the pending messages are sent in the frame of the first one
+	ws.sendChannel <- second
+	ws.sendChannel <- notification
+	a.True(ws.sendBatch(first))
+	a.Equal(0, len(ws.sendChannel))
+
+	// a message without pending ones is sent alone
+	a.True(ws.sendBatch(second))
+
+	if a.Len(frames, 2) {
+		expected := fmt.Sprintf("%d\n%s%d\n%s%d\n%s", len(first), first, len(second), second, len(notification), notification)
+		a.Equal(expected, string(frames[0]))
+		a.Equal(fmt.Sprintf("%d\n%s", len(second), second), string(frames[1]))
+	}
+}
+
+func TestWebSocket_SendBatchAsNDJSON(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	var frame []byte
+	wsconn := NewMockWSConnection(testutil.MockCtrl)
+	wsconn.EXPECT().Send(gomock.Any()).Do(func(f []byte) error {
+		frame = f
+		return nil
+	}).Return(nil)
+
+	ws := NewWebSocket(testWSHandler(nil, auth.NewAllowAllAccessManager(true)), wsconn, "testuser")
+	ws.batchFrames = true
+	ws.jsonFrames = true
+
+	batchMessages := maxBatchMessages
+	maxBatchMessages = 2
+	defer func() { maxBatchMessages = batchMessages }()
+
+	ws.sendChannel <- (&protocol.Message{ID: 43, Path: "/foo", Body: []byte("Second")}).Bytes()
+	ws.sendChannel <- (&protocol.Message{ID: 44, Path: "/foo", Body: []byte("Third")}).Bytes()
+	a.True(ws.sendBatch(aTestMessage.Bytes()))
+
+	// the batch is limited, the last message stays pending
+	a.Equal(1, len(ws.sendChannel))
+	lines := bytes.Split(bytes.TrimSuffix(frame, []byte("\n")), []byte("\n"))
+	if a.Len(lines, 2) {
+		for i, line := range lines {
+			var m jsonMessage
+			a.NoError(json.Unmarshal(line, &m))
+			a.Equal(uint64(42+i), m.ID)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/server/metrics"
+)
+
+// reconnectWindow is the delay after the disconnection of a user within which its next connection
+// is counted as a reconnection.
+var reconnectWindow = time.Minute
+
+// churnEvents are the events counted in total and by minute.
+var churnEvents = []string{"connects", "disconnects", "reconnects", "subscribes", "unsubscribes"}
+
+// churn counts the connections, disconnections, reconnections and (un)subscriptions of the websockets of an endpoint,
+// so that the reconnect storms of the clients (e.g. caused by a bug of a mobile SDK) can be diagnosed from the server.
+// Its metrics are published in `websocket.endpoints`, by prefix of the endpoint.
+type churn struct {
+	totals *expvar.Map
+	minute *expvar.Map
+
+	// lastDisconnect holds the time of the last disconnection of the users, for the reconnect window
+	mu             sync.Mutex
+	lastDisconnect map[string]time.Time
+}
+
+func newChurn(prefix string) *churn {
+	c := &churn{
+		totals:         new(expvar.Map).Init(),
+		minute:         new(expvar.Map).Init(),
+		lastDisconnect: make(map[string]time.Time),
+	}
+	c.totals.Add("current_connections", 0)
+	for _, event := range churnEvents {
+		c.totals.Add("total_"+event, 0)
+	}
+	c.totals.Set("minute", c.minute)
+	mEndpoints.Set(prefix, c.totals)
+	metrics.RegisterInterval(context.Background(), c.minute, time.Minute, resetChurnInterval, c.processAndResetInterval)
+	return c
+}
+
+// connected counts a connection of the user, and a reconnection if the user disconnected within the reconnect window.
+func (c *churn) connected(userID string) {
+	if c == nil {
+		return
+	}
+	c.add("connects")
+	c.totals.Add("current_connections", 1)
+	if userID == "" {
+		return
+	}
+	c.mu.Lock()
+	at, ok := c.lastDisconnect[userID]
+	delete(c.lastDisconnect, userID)
+	c.mu.Unlock()
+	if ok && time.Since(at) <= reconnectWindow {
+		c.add("reconnects")
+	}
+}
+
+// disconnected counts a disconnection of the user.
+func (c *churn) disconnected(userID string) {
+	if c == nil {
+		return
+	}
+	c.add("disconnects")
+	c.totals.Add("current_connections", -1)
+	if userID == "" {
+		return
+	}
+	c.mu.Lock()
+	c.lastDisconnect[userID] = time.Now()
+	c.mu.Unlock()
+}
+
+// subscribed counts a subscription of a websocket.
+func (c *churn) subscribed() {
+	if c != nil {
+		c.add("subscribes")
+	}
+}
+
+// unsubscribed counts a cancelled subscription of a websocket.
+func (c *churn) unsubscribed() {
+	if c != nil {
+		c.add("unsubscribes")
+	}
+}
+
+func (c *churn) add(event string) {
+	metrics.AddToMaps("total_"+event, 1, c.totals)
+	metrics.AddToMaps("current_"+event, 1, c.minute)
+}
+
+// processAndResetInterval publishes the counts of the past minute as `last_<event>`, and forgets the disconnections
+// older than the reconnect window.
+func (c *churn) processAndResetInterval(m metrics.Map, td time.Duration, t time.Time) {
+	values := make(map[string]expvar.Var, len(churnEvents))
+	for _, event := range churnEvents {
+		values[event] = m.Get("current_" + event)
+	}
+	m.Init()
+	resetChurnInterval(m, t)
+	for event, value := range values {
+		if value == nil {
+			value = new(expvar.Int)
+		}
+		m.Set("last_"+event, value)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for userID, at := range c.lastDisconnect {
+		if t.Sub(at) > reconnectWindow {
+			delete(c.lastDisconnect, userID)
+		}
+	}
+}
+
+func resetChurnInterval(m metrics.Map, t time.Time) {
+	m.Set("current_interval_start", metrics.NewTime(t))
+	for _, event := range churnEvents {
+		metrics.AddToMaps("current_"+event, 0, m)
+	}
+}
@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChurn(t *testing.T) {
+	a := assert.New(t)
+
+	c := newChurn("/churn/")
+	c.connected("user01")
+	c.subscribed()
+	c.subscribed()
+	c.unsubscribed()
+	c.disconnected("user01")
+
+	// the next connection of the user is a reconnection
+	c.connected("user01")
+	c.connected("")
+	c.disconnected("")
+
+	a.Equal("1", c.totals.Get("current_connections").String())
+	a.Equal("3", c.totals.Get("total_connects").String())
+	a.Equal("2", c.totals.Get("total_disconnects").String())
+	a.Equal("1", c.totals.Get("total_reconnects").String())
+	a.Equal("2", c.totals.Get("total_subscribes").String())
+	a.Equal("1", c.totals.Get("total_unsubscribes").String())
+	a.Equal("3", c.minute.Get("current_connects").String())
+
+	// the counts of the minute are published as the last ones
+	c.processAndResetInterval(c.minute, time.Minute, time.Now())
+	a.Equal("3", c.minute.Get("last_connects").String())
+	a.Equal("1", c.minute.Get("last_reconnects").String())
+	a.Equal("0", c.minute.Get("current_connects").String())
+}
+
+func TestChurn_ReconnectWindow(t *testing.T) {
+	a := assert.New(t)
+
+	c := newChurn("/churn-window/")
+	c.connected("user01")
+	c.disconnected("user01")
+
+	// the disconnection is forgotten after the window
+	c.processAndResetInterval(c.minute, time.Minute, time.Now().Add(2*reconnectWindow))
+	c.connected("user01")
+	a.Equal("0", c.totals.Get("total_reconnects").String())
+
+	// a nil churn (e.g. of a handler built by a test) counts nothing
+	var nilChurn *churn
+	nilChurn.connected("user01")
+	nilChurn.subscribed()
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+)
+
+// reference is the reference of a message to an earlier message, which it updates or deletes.
+type reference struct {
+	id      uint64
+	deleted bool
+}
+
+// collapser collapses the messages of a fetched range, given all the messages of the range first:
+// the messages updated or deleted by a later message of the range are skipped,
+// as well as the updates of a message deleted in the range. The tombstones (the deleting messages) are kept,
+// so that the clients remove the messages they received before.
+type collapser struct {
+	refs       map[uint64]reference
+	referenced map[uint64]bool
+	deleted    map[uint64]bool
+}
+
+func newCollapser() *collapser {
+	return &collapser{
+		refs:       make(map[uint64]reference),
+		referenced: make(map[uint64]bool),
+		deleted:    make(map[uint64]bool),
+	}
+}
+
+// add takes a message of the range into account.
+func (c *collapser) add(id uint64, message []byte) {
+	m, err := protocol.ParseMessage(message)
+	if err != nil {
+		return
+	}
+	refID, deleted, err := m.Reference()
+	// only an earlier message can be referenced
+	if err != nil || refID == 0 || refID >= id {
+		return
+	}
+	c.refs[id] = reference{id: refID, deleted: deleted}
+	c.referenced[refID] = true
+}
+
+// finish marks the messages deleted in the range, once all the messages of the range were added.
+func (c *collapser) finish() {
+	for _, ref := range c.refs {
+		if ref.deleted {
+			c.deleted[c.root(ref.id)] = true
+		}
+	}
+}
+
+// root returns the original message of a chain of updates.
+func (c *collapser) root(id uint64) uint64 {
+	for {
+		ref, ok := c.refs[id]
+		if !ok || ref.deleted {
+			return id
+		}
+		id = ref.id
+	}
+}
+
+// skip returns true if the message of the range is collapsed.
+func (c *collapser) skip(id uint64) bool {
+	if c.referenced[id] {
+		return true
+	}
+	if ref, ok := c.refs[id]; ok && ref.deleted {
+		return false
+	}
+	return c.deleted[c.root(id)]
+}
+
+// scanReferences fetches the messages of the range of a fetch request, returning the collapser of the range.
+func (rec *Receiver) scanReferences(fetch *store.FetchRequest) (*collapser, error) {
+	scan := store.NewFetchRequest(fetch.Partition, fetch.StartID, fetch.EndID, fetch.Direction, fetch.Count)
+	scan.Init()
+	rec.messageStore.Fetch(scan)
+
+	c := newCollapser()
+	for {
+		select {
+		case <-scan.StartC:
+		case fetched, open := <-scan.MessageC:
+			if !open {
+				c.finish()
+				return c, nil
+			}
+			c.add(fetched.ID, fetched.Message)
+		case err := <-scan.ErrorC:
+			return nil, err
+		}
+	}
+}
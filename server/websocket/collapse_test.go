@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/testutil"
+)
+
+func collapseTestMessages() [][]byte {
+	return [][]byte{
+		(&protocol.Message{ID: 1, Path: "/foo", Body: []byte("a")}).Bytes(),
+		(&protocol.Message{ID: 2, Path: "/foo", Body: []byte("b")}).Bytes(),
+		(&protocol.Message{ID: 3, Path: "/foo", HeaderJSON: `{"Supersedes": 1}`, Body: []byte("a2")}).Bytes(),
+		(&protocol.Message{ID: 4, Path: "/foo", HeaderJSON: `{"Supersedes": "3"}`, Body: []byte("a3")}).Bytes(),
+		(&protocol.Message{ID: 5, Path: "/foo", HeaderJSON: `{"Deletes": 2}`}).Bytes(),
+		(&protocol.Message{ID: 6, Path: "/foo", HeaderJSON: `{"Supersedes": 2}`, Body: []byte("b2")}).Bytes(),
+		(&protocol.Message{ID: 7, Path: "/foo", HeaderJSON: `{"Deletes": 40}`}).Bytes(),
+	}
+}
+
+func TestCollapser(t *testing.T) {
+	a := assert.New(t)
+
+	c := newCollapser()
+	for i, m := range collapseTestMessages() {
+		c.add(uint64(i+1), m)
+	}
+	c.finish()
+
+	// the updated and deleted messages, as well as the updates of a deleted message
+	for _, id := range []uint64{1, 2, 3, 6} {
+		a.True(c.skip(id), id)
+	}
+	// the latest update and the tombstones
+	for _, id := range []uint64{4, 5, 7} {
+		a.False(c.skip(id), id)
+	}
+}
+
+func Test_Receiver_Fetch_Collapsed(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	rec, msgChannel, _, messageStore, err := aMockedReceiver("/foo 0 7 collapse=true")
+	a.NoError(err)
+	a.True(rec.collapse)
+
+	messages := collapseTestMessages()
+	// the range is fetched twice: for collapsing, and for sending the messages
+	messageStore.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		go func() {
+			r.StartC <- len(messages)
+			for i, m := range messages {
+				r.MessageC <- &store.FetchedMessage{ID: uint64(i + 1), Message: m}
+			}
+			close(r.MessageC)
+		}()
+	}).Times(2)
+
+	go rec.fetchOnlyLoop()
+
+	expectMessages(a, msgChannel, "#"+protocol.SUCCESS_FETCH_START+" /foo 7",
+		string(messages[3]), string(messages[4]), string(messages[6]),
+		"#"+protocol.SUCCESS_FETCH_END+" /foo")
+	a.Equal(uint64(7), rec.lastSentID)
+	ctrl.Finish()
+}
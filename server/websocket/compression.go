@@ -0,0 +1,38 @@
+package websocket
+
+import (
+	"github.com/smancke/guble/protocol"
+)
+
+// accepts returns true if the client of the receiver negotiated the content encoding, by the AcceptEncodingHeader.
+func (rec *Receiver) accepts(encoding string) bool {
+	for _, e := range rec.encodings {
+		if e == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+// encoded returns the serialization of a routed message which is sent to the client:
+// with its compressed body, if it was compressed and the client accepts its encoding.
+func (rec *Receiver) encoded(m *protocol.Message) []byte {
+	if raw, encoding := m.CompressedBytes(); encoding != "" && rec.accepts(encoding) {
+		return raw
+	}
+	return m.Bytes()
+}
+
+// decoded returns the serialization of a fetched message which is sent to the client:
+// with its body decompressed, if it was stored compressed with an encoding not accepted by the client.
+func (rec *Receiver) decoded(raw []byte) []byte {
+	if encoding := protocol.RawEncoding(raw); encoding == "" || rec.accepts(encoding) {
+		return raw
+	}
+	decompressed, err := protocol.Decompressed(raw)
+	if err != nil {
+		logger.WithError(err).WithField("path", rec.path).Error("Could not decompress a fetched message")
+		return raw
+	}
+	return decompressed
+}
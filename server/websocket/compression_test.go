@@ -0,0 +1,34 @@
+package websocket
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+)
+
+func TestReceiver_SendsTheCompressedBodiesIfAccepted(t *testing.T) {
+	a := assert.New(t)
+
+	m := &protocol.Message{ID: 42, Path: "/foo", Body: []byte(strings.Repeat("Hello World ", 20))}
+	compressed, err := m.Compress(protocol.EncodingZstd)
+	a.NoError(err)
+	m.Freeze()
+
+	accepting := &Receiver{path: "/foo", encodings: protocol.AcceptedEncodings("gzip, zstd")}
+	a.Equal(compressed, accepting.encoded(m))
+	a.Equal(compressed, accepting.decoded(compressed))
+
+	// the other clients receive the uncompressed bodies, even when fetched
+	other := &Receiver{path: "/foo", encodings: protocol.AcceptedEncodings("gzip")}
+	a.Equal(m.Bytes(), other.encoded(m))
+	a.Equal(m.Bytes(), other.decoded(compressed))
+	a.Equal(m.Bytes(), (&Receiver{path: "/foo"}).decoded(compressed))
+
+	// the uncompressed messages are sent as they are
+	plain := &protocol.Message{ID: 43, Path: "/foo", Body: []byte("Hello")}
+	a.Equal(plain.Bytes(), accepting.encoded(plain))
+	a.Equal(plain.Bytes(), accepting.decoded(plain.Bytes()))
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/smancke/guble/protocol"
+)
+
+const (
+	// defaultDisconnectReason is the reason sent to the websockets disconnected without a given reason
+	defaultDisconnectReason = "The session was closed by the server."
+
+	// maxCloseReasonLength is the maximum length of the reason of a close frame (125 bytes, less the close code)
+	maxCloseReasonLength = 123
+)
+
+// register adds a connected websocket to the sessions of its user.
+func (handler *WSHandler) register(ws *WebSocket) {
+	handler.sessionsMu.Lock()
+	defer handler.sessionsMu.Unlock()
+	if handler.sessions == nil {
+		handler.sessions = make(map[string]map[*WebSocket]struct{})
+	}
+	sessions, ok := handler.sessions[ws.userID]
+	if !ok {
+		sessions = make(map[*WebSocket]struct{})
+		handler.sessions[ws.userID] = sessions
+	}
+	sessions[ws] = struct{}{}
+}
+
+// unregister removes a closed websocket from the sessions of its user.
+func (handler *WSHandler) unregister(ws *WebSocket) {
+	handler.sessionsMu.Lock()
+	defer handler.sessionsMu.Unlock()
+	sessions := handler.sessions[ws.userID]
+	delete(sessions, ws)
+	if len(sessions) == 0 {
+		delete(handler.sessions, ws.userID)
+	}
+}
+
+// DisconnectUser closes all the websockets of the user connected to this node, between two frames,
+// notifying the clients with the reason (or a default one). It returns the number of closed websockets.
+func (handler *WSHandler) DisconnectUser(userID, reason string) int {
+	if userID == "" {
+		return 0
+	}
+	if reason == "" {
+		reason = defaultDisconnectReason
+	}
+
+	handler.sessionsMu.Lock()
+	defer handler.sessionsMu.Unlock()
+	count := 0
+	for ws := range handler.sessions[userID] {
+		select {
+		case ws.disconnectC <- reason:
+			count++
+		default:
+			// the websocket is already being disconnected
+		}
+	}
+	if count > 0 {
+		logger.WithField("userId", userID).WithField("websockets", count).Info("Disconnecting the websockets of a user")
+	}
+	return count
+}
+
+// closeDisconnected notifies the client that its session was closed by the server, and closes the connection
+// (with the close code 1008): the receive loop then stops the receivers.
+func (ws *WebSocket) closeDisconnected(reason string) {
+	n := &protocol.NotificationMessage{
+		Name:    protocol.ERROR_DISCONNECTED,
+		Arg:     reason,
+		IsError: true,
+	}
+	ws.sendRaw(n.Bytes())
+	if c, ok := ws.WSConnection.(reasonCloser); ok {
+		// the reason is cut between two runes, as it must remain valid UTF-8
+		for len(reason) > maxCloseReasonLength {
+			_, size := utf8.DecodeLastRuneInString(reason)
+			reason = reason[:len(reason)-size]
+		}
+		c.CloseWithReason(websocket.ClosePolicyViolation, reason)
+		return
+	}
+	ws.Close()
+}
@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestWSHandler_DisconnectUser(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	handler := testWSHandler(NewMockRouter(ctrl), auth.NewAllowAllAccessManager(true))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/prefix/user/"
+
+	dial := func(userID string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url+userID, nil)
+		a.NoError(err)
+		_, data, err := conn.ReadMessage()
+		a.NoError(err)
+		a.Contains(string(data), protocol.SUCCESS_CONNECTED)
+		return conn
+	}
+	first, second, other := dial("user01"), dial("user01"), dial("user02")
+	defer first.Close()
+	defer second.Close()
+	defer other.Close()
+
+	a.Equal(0, handler.DisconnectUser("unknown", ""))
+	a.Equal(2, handler.DisconnectUser("user01", "access revoked"))
+
+	// the websockets of the user are notified and closed with the reason
+	for _, conn := range []*websocket.Conn{first, second} {
+		_, data, err := conn.ReadMessage()
+		a.NoError(err)
+		a.True(strings.HasPrefix(string(data), "!"+protocol.ERROR_DISCONNECTED+" access revoked"))
+		_, _, err = conn.ReadMessage()
+		closeErr, ok := err.(*websocket.CloseError)
+		if a.True(ok) {
+			a.Equal(websocket.ClosePolicyViolation, closeErr.Code)
+			a.Equal("access revoked", closeErr.Text)
+		}
+	}
+
+	// the websockets of the other users stay connected
+	handler.sessionsMu.Lock()
+	a.Len(handler.sessions["user02"], 1)
+	handler.sessionsMu.Unlock()
+}
@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// drainPollInterval is the interval at which a draining handler checks whether its connections are closed.
+var drainPollInterval = 50 * time.Millisecond
+
+var errDraining = errors.New("The server is draining: connect to another node.")
+
+// Drain refuses the new websockets, and closes the connected ones between two frames (so that no message is cut),
+// notifying the clients so that they reconnect to another node. It blocks until all the websockets are closed,
+// or until the context is done.
+// It is a part of the service.Drainable implementation.
+func (handler *WSHandler) Drain(ctx context.Context) error {
+	handler.drainMu.Lock()
+	if !handler.draining {
+		handler.draining = true
+		close(handler.drainCLocked())
+		logger.WithField("connections", atomic.LoadInt32(&handler.connections)).Info("Draining the websockets")
+	}
+	handler.drainMu.Unlock()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt32(&handler.connections) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// drained returns the channel closed when the handler drains.
+func (handler *WSHandler) drained() <-chan struct{} {
+	handler.drainMu.Lock()
+	defer handler.drainMu.Unlock()
+	return handler.drainCLocked()
+}
+
+func (handler *WSHandler) drainCLocked() chan struct{} {
+	if handler.drainC == nil {
+		handler.drainC = make(chan struct{})
+	}
+	return handler.drainC
+}
+
+// accept counts a new connection, unless the handler is draining.
+func (handler *WSHandler) accept() bool {
+	handler.drainMu.Lock()
+	defer handler.drainMu.Unlock()
+	if handler.draining {
+		return false
+	}
+	atomic.AddInt32(&handler.connections, 1)
+	return true
+}
+
+// release counts a closed connection.
+func (handler *WSHandler) release() {
+	atomic.AddInt32(&handler.connections, -1)
+}
+
+// closeDraining notifies the client that the server is draining, and closes the connection (with the close code 1001):
+// the receive loop then stops the receivers.
+func (ws *WebSocket) closeDraining() {
+	logger.WithField("userId", ws.userID).Debug("Closing websocket of a draining server")
+	n := &protocol.NotificationMessage{
+		Name:    protocol.ERROR_DRAINING,
+		Arg:     errDraining.Error(),
+		IsError: true,
+	}
+	ws.sendRaw(n.Bytes())
+	if c, ok := ws.WSConnection.(reasonCloser); ok {
+		c.CloseWithReason(websocket.CloseGoingAway, "server draining")
+		return
+	}
+	ws.Close()
+}
@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestWSHandler_Drain(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	handler := testWSHandler(NewMockRouter(ctrl), auth.NewAllowAllAccessManager(true))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/prefix/user/user01"
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	a.NoError(err)
+	defer conn.Close()
+	_, data, err := conn.ReadMessage()
+	a.NoError(err)
+	a.Contains(string(data), protocol.SUCCESS_CONNECTED)
+
+	// the connected websocket is notified and closed, and the draining returns when it is closed
+	drainedC := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		drainedC <- handler.Drain(ctx)
+	}()
+	_, data, err = conn.ReadMessage()
+	a.NoError(err)
+	a.True(strings.HasPrefix(string(data), "!"+protocol.ERROR_DRAINING))
+	_, _, err = conn.ReadMessage()
+	closeErr, ok := err.(*websocket.CloseError)
+	if a.True(ok) {
+		a.Equal(websocket.CloseGoingAway, closeErr.Code)
+	}
+	conn.Close()
+	a.NoError(<-drainedC)
+
+	// the new websockets are refused
+	_, response, err := websocket.DefaultDialer.Dial(url, nil)
+	a.Error(err)
+	if a.NotNil(response) {
+		a.Equal(http.StatusServiceUnavailable, response.StatusCode)
+	}
+}
@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// CreditsParam is the query parameter of the websocket URL, with the message credits initially granted by the client.
+const CreditsParam = "credits"
+
+// flowControl pauses the sending of the messages to a client which ran out of message credits.
+// It is enabled by the first credits granted by the client (at the connection, or with the credit command),
+// so that the clients not granting credits receive the messages as fast as they can.
+// The notifications are sent without credits.
+type flowControl struct {
+	mu      sync.Mutex
+	enabled bool
+	credit  int
+	grantC  chan struct{}
+}
+
+func newFlowControl() *flowControl {
+	return &flowControl{grantC: make(chan struct{}, 1)}
+}
+
+// grant adds n credits, enabling the flow control, without blocking.
+func (f *flowControl) grant(n int) {
+	f.mu.Lock()
+	f.enabled = true
+	f.credit += n
+	f.mu.Unlock()
+
+	select {
+	case f.grantC <- struct{}{}:
+	default:
+	}
+}
+
+// tryAcquire takes a credit for sending a message, returning false if the client has no credits left.
+func (f *flowControl) tryAcquire() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.enabled {
+		return true
+	}
+	if f.credit <= 0 {
+		return false
+	}
+	f.credit--
+	return true
+}
+
+// available returns true if a message can be sent.
+func (f *flowControl) available() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.enabled || f.credit > 0
+}
+
+// parseCredits parses a number of credits granted by the client.
+func parseCredits(value string) (int, error) {
+	credits, err := strconv.Atoi(value)
+	if err != nil || credits <= 0 {
+		return 0, fmt.Errorf("credits have to be a positive int, but were %q", value)
+	}
+	return credits, nil
+}
+
+// isMessage returns true if the raw data sent to the client is a message, and not a notification.
+func isMessage(raw []byte) bool {
+	return len(raw) > 0 && raw[0] == byte('/')
+}
+
+// waitForCredit blocks until the client has a credit for the message, and takes it.
+// It returns false if the connection was closed, or its authentication expired, meanwhile.
+func (ws *WebSocket) waitForCredit(raw []byte) bool {
+	if !isMessage(raw) {
+		return true
+	}
+	for !ws.flow.tryAcquire() {
+		select {
+		case <-ws.flow.grantC:
+		case <-ws.expiredC:
+			if ws.authExpired() {
+				ws.closeExpired()
+				return false
+			}
+		case <-ws.closedC:
+			return false
+		}
+	}
+	return true
+}
+
+func (ws *WebSocket) handleCreditCmd(cmd *protocol.Cmd) {
+	credits, err := parseCredits(cmd.Arg)
+	if err != nil {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "%s", err.Error())
+		return
+	}
+	ws.flow.grant(credits)
+}
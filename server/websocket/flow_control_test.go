@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestFlowControl(t *testing.T) {
+	a := assert.New(t)
+
+	// disabled until the first credits are granted
+	f := newFlowControl()
+	a.True(f.available())
+	a.True(f.tryAcquire())
+
+	f.grant(2)
+	a.True(f.tryAcquire())
+	a.True(f.tryAcquire())
+	a.False(f.available())
+	a.False(f.tryAcquire())
+
+	f.grant(1)
+	a.True(f.tryAcquire())
+}
+
+func TestWebSocket_PausesWithoutCredits(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	sentC := make(chan []byte, 3)
+	wsconn := NewMockWSConnection(testutil.MockCtrl)
+	wsconn.EXPECT().Send(gomock.Any()).Do(func(frame []byte) error {
+		sentC <- frame
+		return nil
+	}).Return(nil).AnyTimes()
+	wsconn.EXPECT().Close()
+
+	ws := NewWebSocket(testWSHandler(nil, auth.NewAllowAllAccessManager(true)), wsconn, "testuser")
+	ws.flow.grant(1)
+	go ws.sendLoop()
+
+	first := aTestMessage.Bytes()
+	second := (&protocol.Message{ID: 43, Path: "/foo", Body: []byte("Second")}).Bytes()
+	notification := (&protocol.NotificationMessage{Name: protocol.SUCCESS_SEND, Arg: "43"}).Bytes()
+	ws.sendChannel <- first
+	ws.sendChannel <- second
+	ws.sendChannel <- notification
+
+	assertSentFrame(a, sentC, first)
+	select {
+	case frame := <-sentC:
+		a.Fail("A message was sent without credits", string(frame))
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// the client tops up its credits
+	ws.handleCreditCmd(&protocol.Cmd{Name: protocol.CmdCredit, Arg: "5"})
+	assertSentFrame(a, sentC, second)
+	assertSentFrame(a, sentC, notification)
+
+	ws.cleanAndClose()
+}
+
+func TestParseJSONCmd_Credit(t *testing.T) {
+	a := assert.New(t)
+
+	cmd, err := parseJSONCmd([]byte(`{"cmd": "credit", "count": 50}`))
+	a.NoError(err)
+	a.Equal(&protocol.Cmd{Name: protocol.CmdCredit, Arg: "50"}, cmd)
+
+	_, err = parseCredits("0")
+	a.Error(err)
+}
+
+func assertSentFrame(a *assert.Assertions, sentC chan []byte, expected []byte) {
+	select {
+	case frame := <-sentC:
+		a.Equal(string(expected), string(frame))
+	case <-time.After(time.Second):
+		a.Fail("The frame was not sent", string(expected))
+	}
+}
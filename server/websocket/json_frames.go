@@ -0,0 +1,218 @@
+package websocket
+
+import (
+	"github.com/smancke/guble/protocol"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONSubprotocol is the websocket subprotocol, in which the commands and the messages are JSON objects
+// instead of the line-based guble format. A client selects it by the Sec-WebSocket-Protocol header.
+const JSONSubprotocol = "guble-json"
+
+// jsonCmdNames maps the commands of the JSON subprotocol to the guble commands
+var jsonCmdNames = map[string]string{
+	"send":    protocol.CmdSend,
+	"receive": protocol.CmdReceive,
+	"cancel":  protocol.CmdCancel,
+	"ack":     protocol.CmdAck,
+	"auth":    protocol.CmdAuth,
+	"credit":  protocol.CmdCredit,
+	"read":    protocol.CmdRead,
+}
+
+// jsonCmd is a command sent by the client in the JSON subprotocol.
+type jsonCmd struct {
+	Cmd        string          `json:"cmd"`
+	Path       string          `json:"path"`
+	Header     json.RawMessage `json:"header"`
+	Body       json.RawMessage `json:"body"`
+	BodyBase64 []byte          `json:"body_base64"`
+	StartID    *int64          `json:"start_id"`
+	MaxCount   *int            `json:"max_count"`
+	Window     *int            `json:"window"`
+	Rate       float64         `json:"rate"`
+	ByteRate   float64         `json:"byte_rate"`
+	Timing     string          `json:"timing"`
+	Subtopics  *bool           `json:"subtopics"`
+	Annotate   bool            `json:"annotate"`
+	Collapse   bool            `json:"collapse"`
+	Count      int             `json:"count"`
+	ID         *uint64         `json:"id"`
+	Token      string          `json:"token"`
+}
+
+// jsonMessage is a message sent to the client in the JSON subprotocol.
+type jsonMessage struct {
+	Type          string          `json:"type"`
+	Path          protocol.Path   `json:"path"`
+	ID            uint64          `json:"id"`
+	UserID        string          `json:"user_id,omitempty"`
+	ApplicationID string          `json:"application_id,omitempty"`
+	Time          int64           `json:"time"`
+	ContentType   string          `json:"content_type,omitempty"`
+	Header        json.RawMessage `json:"header,omitempty"`
+	Body          interface{}     `json:"body,omitempty"`
+	BodyBase64    []byte          `json:"body_base64,omitempty"`
+}
+
+// jsonNotification is a status or error notification sent to the client in the JSON subprotocol.
+type jsonNotification struct {
+	Type string          `json:"type"`
+	Name string          `json:"name"`
+	Arg  string          `json:"arg,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// parseJSONCmd parses a command of the JSON subprotocol into the equivalent guble command.
+func parseJSONCmd(data []byte) (*protocol.Cmd, error) {
+	var jc jsonCmd
+	if err := json.Unmarshal(data, &jc); err != nil {
+		return nil, err
+	}
+	name, ok := jsonCmdNames[jc.Cmd]
+	if !ok {
+		return nil, fmt.Errorf("unknown command %q", jc.Cmd)
+	}
+
+	cmd := &protocol.Cmd{Name: name, Arg: jc.Path}
+	switch name {
+	case protocol.CmdSend:
+		if isJSONValue(jc.Header) {
+			// the header has to fit in a single line of the stored message
+			header := &bytes.Buffer{}
+			if err := json.Compact(header, jc.Header); err != nil {
+				return nil, err
+			}
+			cmd.HeaderJSON = header.String()
+		}
+		body, err := jsonBody(jc.Body, jc.BodyBase64)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Body = body
+	case protocol.CmdReceive:
+		args := []string{jc.Path}
+		if jc.StartID != nil {
+			args = append(args, strconv.FormatInt(*jc.StartID, 10))
+		}
+		if jc.MaxCount != nil {
+			if jc.StartID == nil {
+				return nil, fmt.Errorf("max_count requires a start_id")
+			}
+			args = append(args, strconv.Itoa(*jc.MaxCount))
+		}
+		if jc.Window != nil {
+			if jc.MaxCount == nil {
+				return nil, fmt.Errorf("window requires a max_count")
+			}
+			args = append(args, strconv.Itoa(*jc.Window))
+		}
+		if jc.Rate != 0 {
+			args = append(args, "rate="+strconv.FormatFloat(jc.Rate, 'f', -1, 64))
+		}
+		if jc.ByteRate != 0 {
+			args = append(args, "byterate="+strconv.FormatFloat(jc.ByteRate, 'f', -1, 64))
+		}
+		if jc.Timing != "" {
+			args = append(args, "timing="+jc.Timing)
+		}
+		if jc.Subtopics != nil {
+			args = append(args, "subtopics="+strconv.FormatBool(*jc.Subtopics))
+		}
+		if jc.Annotate {
+			args = append(args, "annotate=true")
+		}
+		if jc.Collapse {
+			args = append(args, "collapse=true")
+		}
+		cmd.Arg = strings.Join(args, " ")
+	case protocol.CmdAck:
+		cmd.Arg = fmt.Sprintf("%s %d", jc.Path, jc.Count)
+	case protocol.CmdAuth:
+		cmd.Arg = jc.Token
+	case protocol.CmdCredit:
+		cmd.Arg = strconv.Itoa(jc.Count)
+	case protocol.CmdRead:
+		if jc.ID != nil {
+			cmd.Arg = fmt.Sprintf("%s %d", jc.Path, *jc.ID)
+		}
+	}
+	return cmd, nil
+}
+
+// jsonBody returns the body of a send command: a JSON string is taken as text,
+// any other JSON value is taken as it is, and body_base64 is taken for binary bodies.
+func jsonBody(body json.RawMessage, bodyBase64 []byte) ([]byte, error) {
+	if bodyBase64 != nil {
+		return bodyBase64, nil
+	}
+	if !isJSONValue(body) {
+		return nil, nil
+	}
+	if body[0] == '"' {
+		var text string
+		if err := json.Unmarshal(body, &text); err != nil {
+			return nil, err
+		}
+		return []byte(text), nil
+	}
+	return body, nil
+}
+
+func isJSONValue(value json.RawMessage) bool {
+	return len(value) > 0 && string(value) != "null"
+}
+
+// toJSONFrame converts a serialized message or notification into its JSON representation.
+func toJSONFrame(raw []byte) ([]byte, error) {
+	decoded, err := protocol.Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	switch m := decoded.(type) {
+	case *protocol.Message:
+		frame := &jsonMessage{
+			Type:          "message",
+			Path:          m.Path,
+			ID:            m.ID,
+			UserID:        m.UserID,
+			ApplicationID: m.ApplicationID,
+			Time:          m.Time,
+			ContentType:   m.ContentType,
+		}
+		if json.Valid([]byte(m.HeaderJSON)) {
+			frame.Header = json.RawMessage(m.HeaderJSON)
+		}
+		switch {
+		case len(m.Body) == 0:
+		case m.IsJSON() && json.Valid(m.Body):
+			frame.Body = json.RawMessage(m.Body)
+		case utf8.Valid(m.Body):
+			frame.Body = string(m.Body)
+		default:
+			frame.BodyBase64 = m.Body
+		}
+		return json.Marshal(frame)
+	case *protocol.NotificationMessage:
+		frame := &jsonNotification{
+			Type: "status",
+			Name: m.Name,
+			Arg:  m.Arg,
+		}
+		if m.IsError {
+			frame.Type = "error"
+		}
+		if json.Valid([]byte(m.Json)) {
+			frame.Data = json.RawMessage(m.Json)
+		}
+		return json.Marshal(frame)
+	}
+	return nil, fmt.Errorf("unexpected frame %T", decoded)
+}
@@ -0,0 +1,184 @@
+package websocket
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/testutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_parseJSONCmd(t *testing.T) {
+	a := assert.New(t)
+
+	cmd, err := parseJSONCmd([]byte(`{"cmd": "send", "path": "/foo", "header": {"Content-Type": "text/plain",
+		"Correlation-Id": "42"}, "body": "Hello World"}`))
+	a.NoError(err)
+	a.Equal(protocol.CmdSend, cmd.Name)
+	a.Equal("/foo", cmd.Arg)
+	a.Equal(`{"Content-Type":"text/plain","Correlation-Id":"42"}`, cmd.HeaderJSON)
+	a.Equal("Hello World", string(cmd.Body))
+
+	cmd, err = parseJSONCmd([]byte(`{"cmd": "send", "path": "/foo", "body": {"score": 42}}`))
+	a.NoError(err)
+	a.Equal("", cmd.HeaderJSON)
+	a.Equal(`{"score": 42}`, string(cmd.Body))
+
+	cmd, err = parseJSONCmd([]byte(`{"cmd": "send", "path": "/foo", "body_base64": "AAEC"}`))
+	a.NoError(err)
+	a.Equal([]byte{0, 1, 2}, cmd.Body)
+
+	cmd, err = parseJSONCmd([]byte(`{"cmd": "receive", "path": "/foo"}`))
+	a.NoError(err)
+	a.Equal(protocol.CmdReceive, cmd.Name)
+	a.Equal("/foo", cmd.Arg)
+
+	cmd, err = parseJSONCmd([]byte(`{"cmd": "receive", "path": "/foo", "start_id": -20, "max_count": 20, "window": 5}`))
+	a.NoError(err)
+	a.Equal("/foo -20 20 5", cmd.Arg)
+
+	cmd, err = parseJSONCmd([]byte(`{"cmd": "receive", "path": "/foo", "start_id": 0, "rate": 100, "byte_rate": 65536.5, "timing": "original", "subtopics": false, "annotate": true, "collapse": true}`))
+	a.NoError(err)
+	a.Equal("/foo 0 rate=100 byterate=65536.5 timing=original subtopics=false annotate=true collapse=true", cmd.Arg)
+
+	cmd, err = parseJSONCmd([]byte(`{"cmd": "ack", "path": "/foo", "count": 5}`))
+	a.NoError(err)
+	a.Equal(protocol.CmdAck, cmd.Name)
+	a.Equal("/foo 5", cmd.Arg)
+
+	cmd, err = parseJSONCmd([]byte(`{"cmd": "cancel", "path": "/foo"}`))
+	a.NoError(err)
+	a.Equal(protocol.CmdCancel, cmd.Name)
+	a.Equal("/foo", cmd.Arg)
+
+	for _, bad := range []string{
+		`> /foo`,
+		`{"cmd": "publish", "path": "/foo"}`,
+		`{"cmd": "receive", "path": "/foo", "max_count": 20}`,
+		`{"cmd": "receive", "path": "/foo", "start_id": 0, "window": 5}`,
+		`{"cmd": "send", "path": "/foo", "body_base64": "no base64"}`,
+	} {
+		_, err := parseJSONCmd([]byte(bad))
+		a.Error(err, bad)
+	}
+}
+
+func Test_toJSONFrame(t *testing.T) {
+	a := assert.New(t)
+
+	cases := []struct {
+		message  *protocol.Message
+		expected string
+	}{
+		{
+			&protocol.Message{ID: 42, Path: "/foo", UserID: "user01", Time: 1420110000,
+				HeaderJSON: `{"Correlation-Id": "7sdks723ksgqn"}`, Body: []byte(`{"score": 42}`)},
+			`{"type": "message", "path": "/foo", "id": 42, "user_id": "user01", "time": 1420110000,
+				"header": {"Correlation-Id": "7sdks723ksgqn"}, "body": {"score": 42}}`,
+		},
+		{
+			&protocol.Message{ID: 43, Path: "/foo", ContentType: protocol.ContentTypeText, Body: []byte("Hello World")},
+			`{"type": "message", "path": "/foo", "id": 43, "time": 0, "content_type": "text/plain", "body": "Hello World"}`,
+		},
+		{
+			&protocol.Message{ID: 44, Path: "/foo", Body: []byte("no json")},
+			`{"type": "message", "path": "/foo", "id": 44, "time": 0, "body": "no json"}`,
+		},
+		{
+			&protocol.Message{ID: 45, Path: "/foo", ContentType: protocol.ContentTypeProtobuf, Body: []byte{0, 1, 0xff}},
+			`{"type": "message", "path": "/foo", "id": 45, "time": 0, "content_type": "application/x-protobuf", "body_base64": "AAH/"}`,
+		},
+	}
+	for _, c := range cases {
+		frame, err := toJSONFrame(c.message.Bytes())
+		a.NoError(err)
+		a.JSONEq(c.expected, string(frame))
+	}
+
+	frame, err := toJSONFrame((&protocol.NotificationMessage{
+		Name: protocol.SUCCESS_CONNECTED,
+		Arg:  "You are connected to the server.",
+		Json: `{"UserId": "user01"}`,
+	}).Bytes())
+	a.NoError(err)
+	a.JSONEq(`{"type": "status", "name": "connected", "arg": "You are connected to the server.", "data": {"UserId": "user01"}}`, string(frame))
+
+	frame, err = toJSONFrame((&protocol.NotificationMessage{
+		Name:    protocol.ERROR_BAD_REQUEST,
+		Arg:     "unknown command",
+		IsError: true,
+	}).Bytes())
+	a.NoError(err)
+	a.JSONEq(`{"type": "error", "name": "error-bad-request", "arg": "unknown command"}`, string(frame))
+}
+
+func Test_WSHandler_JSONSubprotocol(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().HandleMessage(messageMatcher{path: "/foo", message: "Hello World"}).Return(nil)
+
+	server := httptest.NewServer(testWSHandler(routerMock, auth.NewAllowAllAccessManager(true)))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/prefix/user/user01"
+
+	dialer := &websocket.Dialer{Subprotocols: []string{JSONSubprotocol}}
+	conn, _, err := dialer.Dial(url, nil)
+	a.NoError(err)
+	defer conn.Close()
+	a.Equal(JSONSubprotocol, conn.Subprotocol())
+
+	var frame map[string]interface{}
+	a.NoError(conn.ReadJSON(&frame))
+	a.Equal("status", frame["type"])
+	a.Equal(protocol.SUCCESS_CONNECTED, frame["name"])
+
+	a.NoError(conn.WriteJSON(map[string]string{"cmd": "send", "path": "/foo", "body": "Hello World"}))
+	messageType, data, err := conn.ReadMessage()
+	a.NoError(err)
+	a.Equal(websocket.TextMessage, messageType)
+	a.NoError(json.Unmarshal(data, &frame))
+	a.Equal(protocol.SUCCESS_SEND, frame["name"])
+
+	a.NoError(conn.WriteMessage(websocket.TextMessage, []byte("> /foo")))
+	frame = nil
+	a.NoError(conn.ReadJSON(&frame))
+	a.Equal("error", frame["type"])
+	a.Equal(protocol.ERROR_BAD_REQUEST, frame["name"])
+}
+
+func Test_WSHandler_LineProtocolByDefault(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Return(nil)
+
+	server := httptest.NewServer(testWSHandler(routerMock, auth.NewAllowAllAccessManager(true)))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/prefix", nil)
+	a.NoError(err)
+	defer conn.Close()
+	a.Equal("", conn.Subprotocol())
+
+	messageType, data, err := conn.ReadMessage()
+	a.NoError(err)
+	a.Equal(websocket.BinaryMessage, messageType)
+	a.True(strings.HasPrefix(string(data), "#"+protocol.SUCCESS_CONNECTED))
+
+	a.NoError(conn.WriteMessage(websocket.BinaryMessage, []byte("> /foo\n\nHello World")))
+	_, data, err = conn.ReadMessage()
+	a.NoError(err)
+	a.Equal("#"+protocol.SUCCESS_SEND, string(data))
+}
@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+)
+
+// readCursorsSchema is the KVStore schema holding the read cursors of the users, keyed by user and topic.
+const readCursorsSchema = "read_cursors"
+
+// readCursorKey returns the key of the read cursor of a user for a topic.
+func readCursorKey(userID string, path protocol.Path) string {
+	return userID + string(path)
+}
+
+// readCursor returns the ID of the last message of the topic read by the user (0, if none is).
+func readCursor(kvs kvstore.KVStore, userID string, path protocol.Path) (uint64, error) {
+	data, exist, err := kvs.Get(readCursorsSchema, readCursorKey(userID, path))
+	if err != nil || !exist {
+		return 0, err
+	}
+	return strconv.ParseUint(string(data), 10, 64)
+}
+
+// advanceReadCursor moves the read cursor of a user for a topic to the given message ID,
+// unless it is already further (e.g. moved by another device of the user), and returns the resulting cursor.
+func advanceReadCursor(kvs kvstore.KVStore, userID string, path protocol.Path, id uint64) (uint64, error) {
+	current, err := readCursor(kvs, userID, path)
+	if err != nil {
+		return 0, err
+	}
+	if id <= current {
+		return current, nil
+	}
+	if err := kvs.Put(readCursorsSchema, readCursorKey(userID, path), []byte(strconv.FormatUint(id, 10))); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// handleReadCmd moves the read cursor of the user for a topic (`^ <path> <id>`), or queries it (`^ <path>`).
+// The resulting cursor is sent as `#read <path> <id>`, so that the sessions of a user on other devices
+// can sync the messages read up to there.
+func (ws *WebSocket) handleReadCmd(cmd *protocol.Cmd) {
+	args := strings.Fields(cmd.Arg)
+	if len(args) == 0 || len(args) > 2 || args[0][0] != '/' {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "^ command requires a path and an optional message id argument")
+		return
+	}
+	path := protocol.Path(args[0])
+	if ws.userID == "" {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "read cursors require a user")
+		return
+	}
+	if (ws.signedTopic != "" && path != ws.signedTopic) || !ws.accessManager.IsAllowed(auth.READ, ws.userID, path) {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "no read access to %s", path)
+		return
+	}
+
+	kvs, err := ws.router.KVStore()
+	if err != nil {
+		ws.sendError(protocol.ERROR_INTERNAL_SERVER, "%s", err.Error())
+		return
+	}
+	var cursor uint64
+	if len(args) == 1 {
+		cursor, err = readCursor(kvs, ws.userID, path)
+	} else {
+		id, parseErr := strconv.ParseUint(args[1], 10, 64)
+		if parseErr != nil || id == 0 {
+			ws.sendError(protocol.ERROR_BAD_REQUEST, "id has to be a positive int, but was %q", args[1])
+			return
+		}
+		cursor, err = advanceReadCursor(kvs, ws.userID, path, id)
+	}
+	if err != nil {
+		logger.WithError(err).WithField("userId", ws.userID).WithField("path", path).Error("Error accessing the read cursor")
+		ws.sendError(protocol.ERROR_INTERNAL_SERVER, "%s", err.Error())
+		return
+	}
+	ws.sendOK(protocol.SUCCESS_READ, "%s %d", path, cursor)
+}
@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestWebSocket_ReadCursors(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().KVStore().Return(kvs, nil).AnyTimes()
+	handler := testWSHandler(routerMock, auth.NewAllowAllAccessManager(true))
+
+	phone := NewWebSocket(handler, nil, "user01")
+	laptop := NewWebSocket(handler, nil, "user01")
+	other := NewWebSocket(handler, nil, "user02")
+
+	expectRead := func(ws *WebSocket, cmd string, expected string) {
+		ws.handleReadCmd(&protocol.Cmd{Name: protocol.CmdRead, Arg: cmd})
+		a.Equal("#"+protocol.SUCCESS_READ+" "+expected, string(<-ws.sendChannel))
+	}
+
+	expectRead(laptop, "/foo", "/foo 0")
+	expectRead(phone, "/foo 42", "/foo 42")
+
+	// the cursor is shared by the sessions of the user, and only moves forward
+	expectRead(laptop, "/foo", "/foo 42")
+	expectRead(laptop, "/foo 40", "/foo 42")
+	expectRead(laptop, "/foo 43", "/foo 43")
+	expectRead(other, "/foo", "/foo 0")
+
+	for _, arg := range []string{"", "foo", "/foo 0", "/foo x", "/foo 1 2"} {
+		phone.handleReadCmd(&protocol.Cmd{Name: protocol.CmdRead, Arg: arg})
+		a.Contains(string(<-phone.sendChannel), "!"+protocol.ERROR_BAD_REQUEST, arg)
+	}
+}
+
+func TestParseJSONCmd_Read(t *testing.T) {
+	a := assert.New(t)
+
+	cmd, err := parseJSONCmd([]byte(`{"cmd": "read", "path": "/foo", "id": 42}`))
+	a.NoError(err)
+	a.Equal(&protocol.Cmd{Name: protocol.CmdRead, Arg: "/foo 42"}, cmd)
+
+	cmd, err = parseJSONCmd([]byte(`{"cmd": "read", "path": "/foo"}`))
+	a.NoError(err)
+	a.Equal(&protocol.Cmd{Name: protocol.CmdRead, Arg: "/foo"}, cmd)
+}
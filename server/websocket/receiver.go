@@ -10,6 +10,8 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -34,6 +36,108 @@ type Receiver struct {
 	route               *router.Route
 	enableNotifications bool
 	userID              string
+	window              *fetchWindow
+	throttle            *replayThrottle
+
+	// exact is set when the subtopics of the path are not received
+	exact bool
+
+	// annotate is set when the messages are sent with the annotations of their delivery
+	annotate       bool
+	subscriptionID string
+	deliveries     *deliveryLog
+	delivered      *idRange
+
+	// collapse is set when the fetched messages updated or deleted by later ones are skipped
+	collapse bool
+
+	// encodings are the content encodings of the compressed bodies accepted by the client
+	encodings []string
+}
+
+// fetchWindow limits the number of fetched messages sent to the client, which were not acknowledged yet.
+type fetchWindow struct {
+	mutex  sync.Mutex
+	credit int
+	ackC   chan struct{}
+}
+
+func newFetchWindow(size int) *fetchWindow {
+	return &fetchWindow{
+		credit: size,
+		ackC:   make(chan struct{}, 1),
+	}
+}
+
+// ack releases n messages from the window, without blocking.
+func (w *fetchWindow) ack(n int) {
+	w.mutex.Lock()
+	w.credit += n
+	w.mutex.Unlock()
+
+	select {
+	case w.ackC <- struct{}{}:
+	default:
+	}
+}
+
+// tryAcquire takes a message into the window, returning false if the window is full.
+func (w *fetchWindow) tryAcquire() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.credit <= 0 {
+		return false
+	}
+	w.credit--
+	return true
+}
+
+// replayThrottle limits the speed of sending the fetched messages to the client:
+// to a number of messages and/or bytes per second, or to the original timing of their publishing.
+type replayThrottle struct {
+	rate           float64
+	byteRate       float64
+	originalTiming bool
+
+	start     time.Time
+	messages  int
+	bytes     int
+	firstTime int64
+}
+
+// delay returns the time to wait before sending the next message, and takes it into account.
+func (t *replayThrottle) delay(message []byte, now time.Time) time.Duration {
+	if t.start.IsZero() {
+		t.start = now
+	}
+
+	var due time.Duration
+	if t.rate > 0 {
+		due = maxDuration(due, time.Duration(float64(t.messages)/t.rate*float64(time.Second)))
+	}
+	if t.byteRate > 0 {
+		due = maxDuration(due, time.Duration(float64(t.bytes)/t.byteRate*float64(time.Second)))
+	}
+	if t.originalTiming {
+		if m, err := protocol.ParseMessage(message); err == nil {
+			if t.messages == 0 {
+				t.firstTime = m.Time
+			}
+			due = maxDuration(due, time.Duration(m.Time-t.firstTime)*time.Second)
+		}
+	}
+
+	t.messages++
+	t.bytes += len(message)
+	return t.start.Add(due).Sub(now)
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
 }
 
 // NewReceiverFromCmd parses the info in the command
@@ -62,7 +166,21 @@ func NewReceiverFromCmd(
 		return nil, fmt.Errorf("command requires at least a path argument, but non given")
 	}
 
-	args := strings.SplitN(cmd.Arg, " ", 3)
+	// the options (e.g. `rate=100`) can follow the path or the other arguments
+	fields := strings.Fields(cmd.Arg)
+	args := []string{fields[0]}
+	for _, field := range fields[1:] {
+		if strings.Contains(field, "=") {
+			if err := rec.setOption(field); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		args = append(args, field)
+	}
+	if len(args) > 4 {
+		return nil, fmt.Errorf("command accepts at most 4 arguments, but %d given", len(args))
+	}
 	rec.path = protocol.Path(args[0])
 
 	if len(args) > 1 {
@@ -82,9 +200,64 @@ func NewReceiverFromCmd(
 		}
 	}
 
+	if len(args) > 3 {
+		window, err := strconv.Atoi(args[3])
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("window has to be empty or a positive int, but was %q", args[3])
+		}
+		rec.window = newFetchWindow(window)
+	}
+
 	return rec, nil
 }
 
+// setOption sets an option of the subscription or of the replay, given as `name=value`.
+func (rec *Receiver) setOption(option string) error {
+	nameValue := strings.SplitN(option, "=", 2)
+	name, value := nameValue[0], nameValue[1]
+
+	switch name {
+	case "subtopics", "annotate", "collapse":
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s has to be true or false, but was %q", name, value)
+		}
+		switch name {
+		case "subtopics":
+			rec.exact = !flag
+		case "annotate":
+			rec.annotate = flag
+		default:
+			rec.collapse = flag
+		}
+		return nil
+	}
+
+	if rec.throttle == nil {
+		rec.throttle = &replayThrottle{}
+	}
+	switch name {
+	case "rate", "byterate":
+		limit, err := strconv.ParseFloat(value, 64)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("%s has to be a positive number, but was %q", name, value)
+		}
+		if name == "rate" {
+			rec.throttle.rate = limit
+		} else {
+			rec.throttle.byteRate = limit
+		}
+	case "timing":
+		if value != "original" {
+			return fmt.Errorf("timing can only be \"original\", but was %q", value)
+		}
+		rec.throttle.originalTiming = true
+	default:
+		return fmt.Errorf("unknown option %q", name)
+	}
+	return nil
+}
+
 // Start starts the receiver loop
 func (rec *Receiver) Start() error {
 	rec.shouldStop = false
@@ -150,6 +323,7 @@ func (rec *Receiver) subscribe() {
 			RouteParams: router.RouteParams{"application_id": rec.applicationID, "user_id": rec.userID},
 			Path:        rec.path,
 			ChannelSize: 10,
+			Exact:       rec.exact,
 		},
 	)
 
@@ -180,7 +354,8 @@ func (rec *Receiver) receiveFromSubscription() {
 
 			if m.ID > rec.lastSentID {
 				rec.lastSentID = m.ID
-				rec.sendC <- m.Bytes()
+				rec.sendC <- rec.annotated(m.ID, rec.encoded(m))
+				router.RecordDelivery("websocket", m)
 			} else {
 				logger.WithFields(log.Fields{
 					"msgId": m.ID,
@@ -232,6 +407,20 @@ func (rec *Receiver) fetch() error {
 		}
 	}
 
+	if cl := rec.router.Cluster(); cl != nil {
+		// the messages stored by the other nodes only (e.g. before this node joined) are fetched first
+		if _, err := cl.FetchMissing(fetch); err != nil {
+			logger.WithError(err).WithField("partition", fetch.Partition).Error("Error fetching the missing messages from the cluster")
+		}
+	}
+
+	var collapsed *collapser
+	if rec.collapse {
+		var err error
+		if collapsed, err = rec.scanReferences(fetch); err != nil {
+			return err
+		}
+	}
 	rec.messageStore.Fetch(fetch)
 
 	for {
@@ -243,31 +432,96 @@ func (rec *Receiver) fetch() error {
 				rec.sendOK(protocol.SUCCESS_FETCH_END, string(rec.path))
 				return nil
 			}
+			if rec.skip(msgAndID.Message) || (collapsed != nil && collapsed.skip(msgAndID.ID)) {
+				// a message of a subtopic, an expired or a collapsed message is skipped, as if it was sent
+				rec.lastSentID = msgAndID.ID
+				continue
+			}
 			logger.WithFields(log.Fields{
 				"msgId":      msgAndID.ID,
-				"msg":        string(msgAndID.Message),
+				"msg":        protocol.Redact(msgAndID.Message),
 				"lastSendId": rec.lastSentID,
 			}).Info("Reply sent")
 
+			if !rec.waitForWindow() || !rec.waitForThrottle(msgAndID.Message) {
+				rec.cancelFetch()
+				return nil
+			}
 			rec.lastSentID = msgAndID.ID
-			rec.sendC <- msgAndID.Message
+			rec.sendC <- rec.annotated(msgAndID.ID, rec.decoded(msgAndID.Message))
 		case err := <-fetch.ErrorC:
 			return err
 		case <-rec.cancelC:
-			rec.shouldStop = true
-			rec.sendOK(protocol.SUCCESS_CANCELED, string(rec.path))
-			// TODO implement cancellation in message store
+			rec.cancelFetch()
 			return nil
 		}
 	}
 }
 
+// skip returns true if a fetched message is not sent: if it was published to a subtopic of the path,
+// while the subtopics are excluded, or if it is older than the TTL of its topic.
+func (rec *Receiver) skip(message []byte) bool {
+	m, err := protocol.ParseMessage(message)
+	if err != nil {
+		return rec.exact
+	}
+	return (rec.exact && !router.MatchesExactly(m.Path, rec.path)) || router.IsExpired(rec.router, m)
+}
+
+func (rec *Receiver) cancelFetch() {
+	rec.shouldStop = true
+	rec.sendOK(protocol.SUCCESS_CANCELED, string(rec.path))
+	// TODO implement cancellation in message store
+}
+
 // Stop stops/cancels the receiver
 func (rec *Receiver) Stop() error {
 	rec.cancelC <- true
 	return nil
 }
 
+// Ack acknowledges the receiving of count fetched messages by the client,
+// so that as many further messages can be sent. It is ignored, if the receiver has no window.
+func (rec *Receiver) Ack(count int) {
+	if rec.window != nil {
+		rec.window.ack(count)
+	}
+}
+
+// waitForWindow blocks until the window allows sending the next fetched message.
+// It returns false, if the receiver was canceled meanwhile.
+func (rec *Receiver) waitForWindow() bool {
+	if rec.window == nil {
+		return true
+	}
+	for !rec.window.tryAcquire() {
+		select {
+		case <-rec.window.ackC:
+		case <-rec.cancelC:
+			return false
+		}
+	}
+	return true
+}
+
+// waitForThrottle blocks until the throttle allows sending the fetched message.
+// It returns false, if the receiver was canceled meanwhile.
+func (rec *Receiver) waitForThrottle(message []byte) bool {
+	if rec.throttle == nil {
+		return true
+	}
+	delay := rec.throttle.delay(message, time.Now())
+	if delay <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(delay):
+		return true
+	case <-rec.cancelC:
+		return false
+	}
+}
+
 func (rec *Receiver) sendError(name string, argPattern string, params ...interface{}) {
 	notificationMessage := &protocol.NotificationMessage{
 		Name:    name,
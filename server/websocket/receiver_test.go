@@ -21,7 +21,9 @@ func Test_Receiver_error_handling_on_create(t *testing.T) {
 
 	a := assert.New(t)
 
-	badArgs := []string{"", "20", "foo 20 20", "/foo 20 20 20", "/foo a", "/foo 20 b"}
+	badArgs := []string{"", "20", "foo 20 20", "/foo 20 20 20 20", "/foo a", "/foo 20 b", "/foo 20 20 0", "/foo 20 20 b",
+		"/foo 0 rate=0", "/foo 0 rate=x", "/foo 0 byterate=-1", "/foo 0 timing=fast", "/foo 0 speed=2",
+		"/foo subtopics=maybe"}
 	for _, arg := range badArgs {
 		rec, _, _, _, err := aMockedReceiver(arg)
 		a.Nil(rec, "Testing with: "+arg)
@@ -189,6 +191,137 @@ func Test_Receiver_Fetch_Returns_Correct_Messages(t *testing.T) {
 	ctrl.Finish()
 }
 
+func Test_Receiver_Fetch_Waits_For_Acks_Within_Window(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	rec, msgChannel, _, messageStore, err := aMockedReceiver("/foo 0 3 2")
+	a.NoError(err)
+
+	messages := []string{"a", "b", "c"}
+	messageStore.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		go func() {
+			r.StartC <- len(messages)
+			for i, m := range messages {
+				r.MessageC <- &store.FetchedMessage{ID: uint64(i + 1), Message: []byte(m)}
+			}
+			close(r.MessageC)
+		}()
+	})
+
+	fetchHasTerminated := make(chan bool)
+	go func() {
+		rec.fetchOnlyLoop()
+		fetchHasTerminated <- true
+	}()
+
+	expectMessages(a, msgChannel, "#"+protocol.SUCCESS_FETCH_START+" /foo 3", "a", "b")
+
+	// the window is full, until the client acknowledges a message
+	select {
+	case msg := <-msgChannel:
+		a.Fail("unexpected message: " + string(msg))
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	rec.Ack(1)
+	expectMessages(a, msgChannel, "c", "#"+protocol.SUCCESS_FETCH_END+" /foo")
+
+	testutil.ExpectDone(a, fetchHasTerminated)
+	ctrl.Finish()
+}
+
+func Test_Receiver_Fetch_Is_Throttled(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	rec, msgChannel, _, messageStore, err := aMockedReceiver("/foo 0 rate=50 3")
+	a.NoError(err)
+	a.Equal(3, rec.maxCount)
+
+	messages := []string{"a", "b", "c"}
+	messageStore.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		go func() {
+			r.StartC <- len(messages)
+			for i, m := range messages {
+				r.MessageC <- &store.FetchedMessage{ID: uint64(i + 1), Message: []byte(m)}
+			}
+			close(r.MessageC)
+		}()
+	})
+
+	start := time.Now()
+	go rec.fetchOnlyLoop()
+
+	expectMessages(a, msgChannel, "#"+protocol.SUCCESS_FETCH_START+" /foo 3", "a", "b", "c")
+
+	// the third message is sent 2/50 seconds after the first one
+	a.True(time.Since(start) >= 40*time.Millisecond)
+	expectMessages(a, msgChannel, "#"+protocol.SUCCESS_FETCH_END+" /foo")
+	ctrl.Finish()
+}
+
+func Test_Receiver_Fetch_Without_Subtopics(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	rec, msgChannel, _, messageStore, err := aMockedReceiver("/foo 0 3 subtopics=false")
+	a.NoError(err)
+	a.True(rec.exact)
+	a.Nil(rec.throttle)
+
+	first := (&protocol.Message{ID: 1, Path: "/foo", Body: []byte("a")}).Bytes()
+	subtopic := (&protocol.Message{ID: 2, Path: "/foo/bar", Body: []byte("b")}).Bytes()
+	third := (&protocol.Message{ID: 3, Path: "/foo", Body: []byte("c")}).Bytes()
+	messageStore.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		go func() {
+			r.StartC <- 3
+			for i, m := range [][]byte{first, subtopic, third} {
+				r.MessageC <- &store.FetchedMessage{ID: uint64(i + 1), Message: m}
+			}
+			close(r.MessageC)
+		}()
+	})
+
+	go rec.fetchOnlyLoop()
+
+	expectMessages(a, msgChannel, "#"+protocol.SUCCESS_FETCH_START+" /foo 3", string(first), string(third),
+		"#"+protocol.SUCCESS_FETCH_END+" /foo")
+	a.Equal(uint64(3), rec.lastSentID)
+	ctrl.Finish()
+}
+
+func Test_replayThrottle_delay(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now()
+
+	// 10 messages or 100 bytes per second
+	throttle := &replayThrottle{rate: 10, byteRate: 100}
+	a.Equal(time.Duration(0), throttle.delay(make([]byte, 50), now))
+	a.Equal(500*time.Millisecond, throttle.delay(make([]byte, 5), now))
+	a.Equal(550*time.Millisecond, throttle.delay(make([]byte, 5), now))
+	a.Equal(300*time.Millisecond, throttle.delay(make([]byte, 5), now.Add(300*time.Millisecond)))
+
+	// the original timing of the messages
+	message := func(publishingTime int64) []byte {
+		return (&protocol.Message{ID: 1, Path: "/foo", Time: publishingTime}).Bytes()
+	}
+	throttle = &replayThrottle{originalTiming: true}
+	a.Equal(time.Duration(0), throttle.delay(message(1420110000), now))
+	a.Equal(time.Duration(0), throttle.delay(message(1420110000), now))
+	a.Equal(3*time.Second, throttle.delay(message(1420110003), now))
+	a.Equal(time.Second, throttle.delay(message(1420110003), now.Add(2*time.Second)))
+
+	// the messages which cannot be parsed are not delayed
+	a.True(throttle.delay([]byte("no message"), now.Add(time.Second)) <= 0)
+}
+
 func Test_Receiver_Fetch_Produces_Correct_Fetch_Requests(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -295,6 +428,7 @@ func aMockedReceiver(arg string) (*Receiver, chan []byte, *MockRouter, *MockMess
 	routerMock := NewMockRouter(testutil.MockCtrl)
 	messageStore := NewMockMessageStore(testutil.MockCtrl)
 	routerMock.EXPECT().MessageStore().Return(messageStore, nil).AnyTimes()
+	routerMock.EXPECT().Cluster().Return(nil).AnyTimes()
 	sendChannel := make(chan []byte)
 	cmd := &protocol.Cmd{
 		Name: protocol.CmdReceive,
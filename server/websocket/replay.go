@@ -0,0 +1,52 @@
+package websocket
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+)
+
+// ReplayUser sends the messages again to all the websockets of the user connected to this node, in order,
+// skipping the ones filtered for other users or applications (and the ones which the user can not read).
+// It returns the number of websockets to which the messages are sent.
+func (handler *WSHandler) ReplayUser(userID string, messages []*protocol.Message) int {
+	if userID == "" || len(messages) == 0 {
+		return 0
+	}
+
+	handler.sessionsMu.Lock()
+	sessions := make([]*WebSocket, 0, len(handler.sessions[userID]))
+	for ws := range handler.sessions[userID] {
+		sessions = append(sessions, ws)
+	}
+	handler.sessionsMu.Unlock()
+
+	for _, ws := range sessions {
+		go ws.replay(messages)
+	}
+	if len(sessions) > 0 {
+		logger.WithField("userId", userID).WithField("websockets", len(sessions)).
+			WithField("messages", len(messages)).Info("Replaying messages to the websockets of a user")
+	}
+	return len(sessions)
+}
+
+// replay sends the messages matching the filters of the websocket until it is closed.
+// The READ permission of the user is checked by the sendLoop, as for the routed messages.
+func (ws *WebSocket) replay(messages []*protocol.Message) {
+	config := router.RouteConfig{
+		RouteParams: router.RouteParams{"application_id": ws.applicationID, "user_id": ws.userID},
+	}
+	for _, m := range messages {
+		if m.Filters != nil && !config.Filter(m.Filters) {
+			continue
+		}
+		if ws.signedTopic != "" && m.Path != ws.signedTopic {
+			continue
+		}
+		select {
+		case ws.sendChannel <- m.Bytes():
+		case <-ws.closedC:
+			return
+		}
+	}
+}
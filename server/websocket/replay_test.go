@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestWSHandler_ReplayUser(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	handler := testWSHandler(NewMockRouter(ctrl), auth.NewAllowAllAccessManager(true))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/prefix/user/"
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"user01", nil)
+	a.NoError(err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	a.NoError(err)
+	a.Contains(string(data), protocol.SUCCESS_CONNECTED)
+
+	mine := &protocol.Message{ID: 1, Path: "/news", Filters: map[string]string{"user_id": "user01"}, Body: []byte("mine")}
+	others := &protocol.Message{ID: 2, Path: "/news", Filters: map[string]string{"user_id": "user02"}, Body: []byte("others")}
+	public := &protocol.Message{ID: 3, Path: "/news", Body: []byte("public")}
+
+	a.Equal(0, handler.ReplayUser("user02", []*protocol.Message{public}))
+	a.Equal(1, handler.ReplayUser("user01", []*protocol.Message{mine, others, public}))
+
+	// the messages filtered for other users are skipped
+	for _, expected := range []*protocol.Message{mine, public} {
+		_, data, err := conn.ReadMessage()
+		a.NoError(err)
+		a.Equal(string(expected.Bytes()), string(data))
+	}
+}
+
+func TestWSHandler_ReplayUserChecksAccess(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	accessManager := NewMockAccessManager(ctrl)
+	handler := testWSHandler(NewMockRouter(ctrl), accessManager)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/prefix/user/"
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"user01", nil)
+	a.NoError(err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	a.NoError(err)
+	a.Contains(string(data), protocol.SUCCESS_CONNECTED)
+
+	accessManager.EXPECT().IsAllowed(auth.READ, "user01", protocol.Path("/news")).Return(true).AnyTimes()
+	accessManager.EXPECT().IsAllowed(auth.READ, "user01", protocol.Path("/news/secret")).Return(false).AnyTimes()
+
+	news := &protocol.Message{ID: 1, Path: "/news", Body: []byte("news")}
+	secret := &protocol.Message{ID: 2, Path: "/news/secret", Body: []byte("secret")}
+	moreSecret := &protocol.Message{ID: 3, Path: "/news/secret", Body: []byte("more secret")}
+	moreNews := &protocol.Message{ID: 4, Path: "/news", Body: []byte("more news")}
+	a.Equal(1, handler.ReplayUser("user01", []*protocol.Message{news, secret, moreSecret, moreNews}))
+
+	// the messages which the user can not read are skipped
+	for _, expected := range []*protocol.Message{news, moreNews} {
+		_, data, err := conn.ReadMessage()
+		a.NoError(err)
+		a.Equal(string(expected.Bytes()), string(data))
+	}
+}
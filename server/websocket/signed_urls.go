@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+)
+
+var ErrInvalidSignature = errors.New("Forbidden: invalid or expired signed URL.")
+
+// Signature returns the signature of a URL allowing the user (empty, for an anonymous websocket)
+// to subscribe to the topic until the expiration (in seconds since the epoch):
+// the hex-encoded HMAC-SHA256 with the key of `<topic>\n<userID>\n<expires>`.
+func Signature(key []byte, topic protocol.Path, userID string, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%d", topic, userID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedQuery returns the query parameters of a signed URL (`/stream/user/<userID>?<query>`),
+// allowing the user to subscribe to the topic until the expiration, without other credentials.
+func SignedQuery(key []byte, topic protocol.Path, userID string, expires time.Time) url.Values {
+	return url.Values{
+		"topic":     {string(topic)},
+		"expires":   {strconv.FormatInt(expires.Unix(), 10)},
+		"signature": {Signature(key, topic, userID, expires.Unix())},
+	}
+}
+
+// verifySignature returns the topic and the expiration of a signed URL of the user.
+func (handler *WSHandler) verifySignature(query url.Values, userID string) (protocol.Path, time.Time, error) {
+	topic := protocol.Path(query.Get("topic"))
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || topic == "" {
+		return "", time.Time{}, ErrInvalidSignature
+	}
+	signature, err := hex.DecodeString(query.Get("signature"))
+	expected, _ := hex.DecodeString(Signature(handler.signingKey, topic, userID, expires))
+	if err != nil || !hmac.Equal(signature, expected) || !time.Now().Before(time.Unix(expires, 0)) {
+		return "", time.Time{}, ErrInvalidSignature
+	}
+	return topic, time.Unix(expires, 0), nil
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestSignature(t *testing.T) {
+	a := assert.New(t)
+	key := []byte("secret")
+
+	signature := Signature(key, "/news", "user01", 1488362400)
+	a.Len(signature, 64)
+	a.Equal(signature, Signature(key, "/news", "user01", 1488362400))
+	a.NotEqual(signature, Signature(key, "/news", "user02", 1488362400))
+	a.NotEqual(signature, Signature(key, "/news/sport", "user01", 1488362400))
+	a.NotEqual(signature, Signature(key, "/news", "user01", 1488362401))
+	a.NotEqual(signature, Signature([]byte("other"), "/news", "user01", 1488362400))
+
+	query := SignedQuery(key, "/news", "user01", time.Unix(1488362400, 0))
+	a.Equal("/news", query.Get("topic"))
+	a.Equal("1488362400", query.Get("expires"))
+	a.Equal(signature, query.Get("signature"))
+}
+
+func Test_WSHandler_SignedURL(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	handler := testWSHandler(routerMock, auth.NewAllowAllAccessManager(true))
+	handler.authenticator = testAuthenticator
+	handler.signingKey = []byte("secret")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/prefix/user/user01?"
+
+	// the signed URLs of another user, expired or tampered with are refused
+	expires := time.Now().Add(time.Minute)
+	for _, query := range []string{
+		SignedQuery(handler.signingKey, "/news", "user02", expires).Encode(),
+		SignedQuery(handler.signingKey, "/news", "user01", time.Now().Add(-time.Second)).Encode(),
+		strings.Replace(SignedQuery(handler.signingKey, "/news", "user01", expires).Encode(), "news", "admin", 1),
+		"topic=%2Fnews&expires=x&signature=00",
+	} {
+		_, resp, err := websocket.DefaultDialer.Dial(url+query, nil)
+		a.Error(err, query)
+		a.Equal(http.StatusForbidden, resp.StatusCode, query)
+	}
+
+	// a signed URL is sufficient for subscribing to its topic, until it expires
+	conn, _, err := websocket.DefaultDialer.Dial(url+SignedQuery(handler.signingKey, "/news", "user01", expires).Encode(), nil)
+	a.NoError(err)
+	defer conn.Close()
+	read := func() string {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err.Error()
+		}
+		return string(data)
+	}
+	a.Contains(read(), `"UserId": "user01"`)
+	a.Equal("#"+protocol.SUCCESS_AUTHENTICATED+" "+time.Unix(expires.Unix(), 0).UTC().Format(time.RFC3339), read())
+
+	for _, cmd := range []string{"+ /admin", "+ /news/sport", "> /news\n{}\nhello"} {
+		a.NoError(conn.WriteMessage(websocket.BinaryMessage, []byte(cmd)))
+		a.Equal("!"+protocol.ERROR_BAD_REQUEST+" the signed URL only allows subscribing to /news", read(), cmd)
+	}
+
+	routerMock.EXPECT().MessageStore().Return(NewMockMessageStore(ctrl), nil)
+	routerMock.EXPECT().Subscribe(gomock.Any()).Do(func(r *router.Route) {
+		a.Equal(protocol.Path("/news"), r.Path)
+		a.Equal("user01", r.Get("user_id"))
+	}).Return(nil, nil)
+	routerMock.EXPECT().Unsubscribe(gomock.Any()).AnyTimes()
+	a.NoError(conn.WriteMessage(websocket.BinaryMessage, []byte("+ /news")))
+	a.Equal("#"+protocol.SUCCESS_SUBSCRIBED_TO+" /news", read())
+}
@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+)
+
+const (
+	// ticketsSchema is the KVStore schema holding the unused tickets, keyed by ticket.
+	ticketsSchema = "ws_tickets"
+
+	// DefaultTicketTTL is the default time during which a ticket can be used.
+	DefaultTicketTTL = 30 * time.Second
+
+	// ticketsSweepInterval is the interval at which the expired tickets are removed.
+	ticketsSweepInterval = time.Minute
+)
+
+var ErrInvalidTicket = errors.New("Invalid, expired or already used ticket.")
+
+// Redeemer redeems the connection tickets of the websockets.
+type Redeemer interface {
+	// Redeem consumes the ticket, and returns the user for which it is issued (empty, for an anonymous websocket).
+	Redeem(ticket string) (userID string, err error)
+}
+
+// ticket is a stored ticket.
+type ticket struct {
+	UserID  string    `json:"user_id"`
+	Expires time.Time `json:"expires"`
+}
+
+// Tickets issues the one-time tickets required for connecting a websocket, if enabled:
+// the backend of an application requests a ticket for a user through the REST API,
+// and the browser of the user connects with it (`/stream/user/<userID>?ticket=<ticket>`),
+// so that a malicious page can not connect a websocket with the cookies of the user.
+// The tickets are stored in the KVStore, so that they can be used with any guble node of a cluster.
+type Tickets struct {
+	kvstore kvstore.KVStore
+	ttl     time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewTickets returns new Tickets, valid during the given time.
+func NewTickets(router router.Router, ttl time.Duration) (*Tickets, error) {
+	kvs, err := router.KVStore()
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		ttl = DefaultTicketTTL
+	}
+	return &Tickets{kvstore: kvs, ttl: ttl}, nil
+}
+
+// Start removes the expired tickets periodically.
+func (t *Tickets) Start() error {
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	t.wg.Add(1)
+	go t.sweepLoop()
+	return nil
+}
+
+// Stop stops removing the expired tickets.
+func (t *Tickets) Stop() error {
+	t.cancel()
+	t.wg.Wait()
+	return nil
+}
+
+// Issue returns a new ticket for the user (or for an anonymous websocket, if the user is empty),
+// and the time at which it expires.
+func (t *Tickets) Issue(userID string) (string, time.Time, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	key := hex.EncodeToString(b)
+	tk := ticket{UserID: userID, Expires: time.Now().Add(t.ttl)}
+	data, err := json.Marshal(tk)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if err := t.kvstore.Put(ticketsSchema, key, data); err != nil {
+		return "", time.Time{}, err
+	}
+	return key, tk.Expires, nil
+}
+
+// Redeem consumes the ticket, and returns its user, or ErrInvalidTicket if it does not exist or is expired.
+// The ticket is taken from the KVStore atomically, so that it is only redeemed once by concurrent connections.
+// It is a part of the Redeemer implementation.
+func (t *Tickets) Redeem(key string) (string, error) {
+	if key == "" {
+		return "", ErrInvalidTicket
+	}
+	data, taken, err := t.kvstore.Take(ticketsSchema, key)
+	if err != nil {
+		return "", err
+	}
+	if !taken {
+		return "", ErrInvalidTicket
+	}
+	var tk ticket
+	if err := json.Unmarshal(data, &tk); err != nil || time.Now().After(tk.Expires) {
+		return "", ErrInvalidTicket
+	}
+	return tk.UserID, nil
+}
+
+// RevokeUser removes the unused tickets issued for the user, so that they can not be used for connecting
+// (e.g. when the access of the user is revoked), and returns their number.
+func (t *Tickets) RevokeUser(userID string) (int, error) {
+	var revoked []string
+	for entry := range t.kvstore.Iterate(context.Background(), ticketsSchema, "", 0) {
+		var tk ticket
+		if err := json.Unmarshal([]byte(entry[1]), &tk); err == nil && tk.UserID == userID {
+			revoked = append(revoked, entry[0])
+		}
+	}
+	for _, key := range revoked {
+		if err := t.kvstore.Delete(ticketsSchema, key); err != nil {
+			return 0, err
+		}
+	}
+	return len(revoked), nil
+}
+
+func (t *Tickets) sweepLoop() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(ticketsSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.sweep()
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+// sweep removes the expired tickets (after the iteration, which may hold a connection of the KVStore).
+func (t *Tickets) sweep() {
+	now := time.Now()
+	var expired []string
+	for entry := range t.kvstore.Iterate(t.ctx, ticketsSchema, "", 0) {
+		var tk ticket
+		if err := json.Unmarshal([]byte(entry[1]), &tk); err != nil || now.After(tk.Expires) {
+			expired = append(expired, entry[0])
+		}
+	}
+	for _, key := range expired {
+		if err := t.kvstore.Delete(ticketsSchema, key); err != nil {
+			logger.WithError(err).Error("Error removing an expired ticket")
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package websocket
+
+import (
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/testutil"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTickets_IssueAndRedeem(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().KVStore().Return(kvs, nil)
+	tickets, err := NewTickets(routerMock, time.Minute)
+	a.NoError(err)
+
+	key, expires, err := tickets.Issue("user01")
+	a.NoError(err)
+	a.Len(key, 48)
+	a.True(expires.After(time.Now().Add(50 * time.Second)))
+
+	// a ticket is only valid once
+	userID, err := tickets.Redeem(key)
+	a.NoError(err)
+	a.Equal("user01", userID)
+	_, err = tickets.Redeem(key)
+	a.Equal(ErrInvalidTicket, err)
+	_, err = tickets.Redeem("")
+	a.Equal(ErrInvalidTicket, err)
+
+	// the expired tickets are refused, and removed by the sweep
+	data, _ := json.Marshal(ticket{UserID: "user01", Expires: time.Now().Add(-time.Second)})
+	a.NoError(kvs.Put(ticketsSchema, "expired", data))
+	tickets.ctx = context.Background()
+	tickets.sweep()
+	_, exist, _ := kvs.Get(ticketsSchema, "expired")
+	a.False(exist)
+	a.NoError(kvs.Put(ticketsSchema, "expired", data))
+	_, err = tickets.Redeem("expired")
+	a.Equal(ErrInvalidTicket, err)
+}
+
+func TestTickets_RedeemConcurrently(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().KVStore().Return(kvstore.NewMemoryKVStore(), nil)
+	tickets, err := NewTickets(routerMock, time.Minute)
+	a.NoError(err)
+	key, _, err := tickets.Issue("user01")
+	a.NoError(err)
+
+	// only one of the concurrent connections redeems the ticket
+	var wg sync.WaitGroup
+	var redeemed int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := tickets.Redeem(key); err == nil {
+				atomic.AddInt32(&redeemed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	a.Equal(int32(1), redeemed)
+}
+
+// redeemerFunc is a Redeemer redeeming with a function.
+type redeemerFunc func(ticket string) (string, error)
+
+func (f redeemerFunc) Redeem(ticket string) (string, error) {
+	return f(ticket)
+}
+
+func TestTickets_RevokeUser(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	kvs := kvstore.NewMemoryKVStore()
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().KVStore().Return(kvs, nil)
+	tickets, err := NewTickets(routerMock, time.Minute)
+	a.NoError(err)
+
+	first, _, _ := tickets.Issue("user01")
+	second, _, _ := tickets.Issue("user01")
+	other, _, _ := tickets.Issue("user02")
+
+	revoked, err := tickets.RevokeUser("user01")
+	a.NoError(err)
+	a.Equal(2, revoked)
+	for _, key := range []string{first, second} {
+		_, err := tickets.Redeem(key)
+		a.Equal(ErrInvalidTicket, err)
+	}
+	userID, err := tickets.Redeem(other)
+	a.NoError(err)
+	a.Equal("user02", userID)
+}
+
+func Test_WSHandler_RequiresTicket(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	handler := testWSHandler(NewMockRouter(ctrl), auth.NewAllowAllAccessManager(true))
+	handler.redeemer = redeemerFunc(func(ticket string) (string, error) {
+		if ticket == "secret" {
+			return "user01", nil
+		}
+		return "", ErrInvalidTicket
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/prefix/user/user01"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"?ticket=forged", nil)
+	a.Error(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?ticket=secret", nil)
+	a.NoError(err)
+	defer conn.Close()
+	_, data, err := conn.ReadMessage()
+	a.NoError(err)
+	a.Contains(string(data), `"UserId": "user01"`)
+}
+
+func Test_WSHandler_ChecksOrigin(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	routerMock.EXPECT().AccessManager().Return(auth.NewAllowAllAccessManager(true), nil)
+	origins := []string{"https://app.example.com", "https://*.example.org"}
+	handler, err := NewWSHandler(routerMock, "/prefix", Config{Origins: &origins})
+	a.NoError(err)
+
+	request := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/prefix", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+	a.True(handler.checkOrigin(request("https://app.example.com")))
+	a.True(handler.checkOrigin(request("https://Shop.Example.org")))
+	a.True(handler.checkOrigin(request("")))
+	a.False(handler.checkOrigin(request("https://evil.example.net")))
+	a.False(handler.checkOrigin(request("http://app.example.com")))
+
+	handler.origins = nil
+	a.True(handler.checkOrigin(request("https://evil.example.net")))
+
+	routerMock.EXPECT().AccessManager().Return(auth.NewAllowAllAccessManager(true), nil)
+	invalid := []string{"https://[app"}
+	_, err = NewWSHandler(routerMock, "/prefix", Config{Origins: &invalid})
+	a.Error(err)
+}
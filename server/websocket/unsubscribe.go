@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+)
+
+// UnsubscribeUser cancels the subscriptions of the user connected to this node to the topic and its subtopics
+// (all of them, if the topic is empty), notifying the clients with the reason by a protocol.ERROR_UNSUBSCRIBED
+// notification. It returns the number of canceled subscriptions.
+func (handler *WSHandler) UnsubscribeUser(userID string, topic protocol.Path, reason string) int {
+	if userID == "" {
+		return 0
+	}
+	handler.sessionsMu.Lock()
+	sessions := make([]*WebSocket, 0, len(handler.sessions[userID]))
+	for ws := range handler.sessions[userID] {
+		sessions = append(sessions, ws)
+	}
+	handler.sessionsMu.Unlock()
+
+	count := 0
+	for _, ws := range sessions {
+		paths := ws.cancelReceivers(func(path protocol.Path) bool {
+			return topic == "" || path.InTopic(topic)
+		})
+		for _, path := range paths {
+			select {
+			case ws.sendChannel <- unsubscribedNotification(path, reason).Bytes():
+			case <-ws.closedC:
+			}
+		}
+		count += len(paths)
+	}
+	if count > 0 {
+		logger.WithField("userId", userID).WithField("topic", topic).WithField("subscriptions", count).Info("Canceled the subscriptions of a user")
+	}
+	return count
+}
+
+// cancelReceivers stops the receivers whose path is selected, and returns their paths.
+func (ws *WebSocket) cancelReceivers(selected func(path protocol.Path) bool) []protocol.Path {
+	ws.receiversMu.Lock()
+	defer ws.receiversMu.Unlock()
+
+	var paths []protocol.Path
+	for path, rec := range ws.receivers {
+		if selected(path) {
+			rec.Stop()
+			delete(ws.receivers, path)
+			ws.churn.unsubscribed()
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// revokeAccess is called by the sending goroutine for a message the user is not allowed to read anymore:
+// the subscriptions to the topics which the user is not allowed to read anymore are canceled,
+// instead of dropping their messages silently. It returns the notifications to send.
+func (ws *WebSocket) revokeAccess() [][]byte {
+	paths := ws.cancelReceivers(func(path protocol.Path) bool {
+		return !ws.accessManager.IsAllowed(auth.READ, ws.userID, path)
+	})
+	notifications := make([][]byte, 0, len(paths))
+	for _, path := range paths {
+		logger.WithField("userId", ws.userID).WithField("path", path).Info("Canceled a subscription with revoked access")
+		notifications = append(notifications, unsubscribedNotification(path, protocol.UnsubscribedAccessRevoked).Bytes())
+	}
+	return notifications
+}
+
+// sendExpiredSubscriptions notifies the client that its subscriptions are canceled, when its authentication expired.
+func (ws *WebSocket) sendExpiredSubscriptions() {
+	ws.receiversMu.Lock()
+	notifications := make([][]byte, 0, len(ws.receivers))
+	for path := range ws.receivers {
+		notifications = append(notifications, unsubscribedNotification(path, protocol.UnsubscribedExpired).Bytes())
+	}
+	ws.receiversMu.Unlock()
+
+	ws.sendNotifications(notifications)
+}
+
+// sendNotifications sends notifications in the format of the negotiated subprotocol (in a single frame,
+// for a batch subprotocol), and returns false if the connection failed.
+func (ws *WebSocket) sendNotifications(notifications [][]byte) bool {
+	if len(notifications) == 0 {
+		return true
+	}
+	if ws.batchFrames {
+		return ws.sendFrame(ws.encodeBatch(notifications))
+	}
+	for _, n := range notifications {
+		if !ws.sendRaw(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func unsubscribedNotification(path protocol.Path, reason string) *protocol.NotificationMessage {
+	return &protocol.NotificationMessage{
+		Name:    protocol.ERROR_UNSUBSCRIBED,
+		Arg:     string(path) + " " + reason,
+		IsError: true,
+	}
+}
@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/testutil"
+)
+
+func TestWSHandler_UnsubscribeUser(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	wsconn, routerMock, messageStore := createDefaultMocks([]string{"+ /foo", "+ /bar/baz"})
+
+	var wg sync.WaitGroup
+	done := func(bytes []byte) error {
+		wg.Done()
+		return nil
+	}
+
+	wg.Add(2)
+	routerMock.EXPECT().Subscribe(routeMatcher{"/foo"}).Return(nil, nil)
+	wsconn.EXPECT().Send([]byte("#" + protocol.SUCCESS_SUBSCRIBED_TO + " /foo")).Do(done)
+	routerMock.EXPECT().Subscribe(routeMatcher{"/bar/baz"}).Return(nil, nil)
+	wsconn.EXPECT().Send([]byte("#" + protocol.SUCCESS_SUBSCRIBED_TO + " /bar/baz")).Do(done)
+
+	ws := runNewWebSocket(wsconn, routerMock, messageStore, nil)
+	ws.register(ws)
+	wg.Wait()
+
+	// the subscriptions to the subtopics of the topic are canceled, and the client is notified with the reason
+	wg.Add(2)
+	sent := wsconn.EXPECT().Send([]byte("!" + protocol.ERROR_UNSUBSCRIBED + " /bar/baz admin")).Do(done)
+	routerMock.EXPECT().Unsubscribe(routeMatcher{"/bar/baz"})
+	wsconn.EXPECT().Send([]byte("#" + protocol.SUCCESS_CANCELED + " /bar/baz")).After(sent).Do(done)
+
+	a.Equal(0, ws.UnsubscribeUser("unknown", "/bar", protocol.UnsubscribedAdmin))
+	a.Equal(0, ws.UnsubscribeUser("testuser", "/ba", protocol.UnsubscribedAdmin))
+	a.Equal(1, ws.UnsubscribeUser("testuser", "/bar", protocol.UnsubscribedAdmin))
+	wg.Wait()
+
+	ws.receiversMu.Lock()
+	a.Len(ws.receivers, 1)
+	a.NotNil(ws.receivers["/foo"])
+	ws.receiversMu.Unlock()
+}
+
+func TestWebSocket_RevokeAccess(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	wsconn, routerMock, messageStore := createDefaultMocks([]string{"+ /foo", "+ /bar"})
+	tam := NewMockAccessManager(ctrl)
+
+	var wg sync.WaitGroup
+	done := func(bytes []byte) error {
+		wg.Done()
+		return nil
+	}
+
+	wg.Add(2)
+	routerMock.EXPECT().Subscribe(routeMatcher{"/foo"}).Return(nil, nil)
+	wsconn.EXPECT().Send([]byte("#" + protocol.SUCCESS_SUBSCRIBED_TO + " /foo")).Do(done)
+	routerMock.EXPECT().Subscribe(routeMatcher{"/bar"}).Return(nil, nil)
+	wsconn.EXPECT().Send([]byte("#" + protocol.SUCCESS_SUBSCRIBED_TO + " /bar")).Do(done)
+
+	ws := runNewWebSocket(wsconn, routerMock, messageStore, tam)
+	wg.Wait()
+
+	// a message of a topic the user is not allowed to read anymore cancels its subscription
+	wg.Add(2)
+	tam.EXPECT().IsAllowed(auth.READ, "testuser", protocol.Path("/foo")).Return(false).Times(2)
+	tam.EXPECT().IsAllowed(auth.READ, "testuser", protocol.Path("/bar")).Return(true)
+	sent := wsconn.EXPECT().Send([]byte("!" + protocol.ERROR_UNSUBSCRIBED + " /foo access-revoked")).Do(done)
+	routerMock.EXPECT().Unsubscribe(routeMatcher{"/foo"})
+	wsconn.EXPECT().Send([]byte("#" + protocol.SUCCESS_CANCELED + " /foo")).After(sent).Do(done)
+
+	ws.sendChannel <- aTestMessage.Bytes()
+	wg.Wait()
+
+	ws.receiversMu.Lock()
+	a.Len(ws.receivers, 1)
+	a.NotNil(ws.receivers["/bar"])
+	ws.receiversMu.Unlock()
+}
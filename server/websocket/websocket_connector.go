@@ -9,14 +9,49 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/rs/xid"
 
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 var webSocketUpgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: []string{JSONSubprotocol, BatchSubprotocol, JSONBatchSubprotocol},
+}
+
+// Config configures the protection of the websockets against the cross-site connections of malicious pages.
+type Config struct {
+	// Origins are the origins allowed to connect a websocket, as patterns (e.g. `https://*.example.com`);
+	// all the origins are allowed if empty, and the clients not sending an Origin header (i.e. not browsers) always are
+	Origins *[]string
+
+	// Tickets is set, if the websockets can only be connected with a one-time ticket issued by the REST API
+	Tickets   *bool
+	TicketTTL *time.Duration
+
+	// Redeemer redeems the tickets, if they are required.
+	// The user of a websocket is derived from its ticket, so the tickets must only be issued to authenticated backends.
+	Redeemer Redeemer
+
+	// AuthURL is the URL authenticating the tokens of the users (see auth.RestAuthenticator), if required
+	AuthURL *string
+
+	// Authenticator authenticates the websockets by tokens, if not nil
+	Authenticator auth.Authenticator
+
+	// TrustPathUser is set for the deployments in which the clients are trusted to act as the user of their path,
+	// even if it is not the user of their credentials (by default, the user is derived from the credentials)
+	TrustPathUser *bool
+
+	// SigningKey is the key of the signed URLs, allowing to subscribe to a single topic until their expiration
+	// without other credentials (the signed URLs are disabled, if empty)
+	SigningKey *string
 }
 
 // WSHandler is a struct used for handling websocket connections on a certain prefix.
@@ -24,19 +59,51 @@ type WSHandler struct {
 	router        router.Router
 	prefix        string
 	accessManager auth.AccessManager
+	origins       []string
+	redeemer      Redeemer
+	authenticator auth.Authenticator
+	trustPathUser bool
+	signingKey    []byte
+	churn         *churn
+
+	// connections is the number of the connected websockets, and drainC is closed when the handler drains
+	connections int32
+	drainMu     sync.Mutex
+	draining    bool
+	drainC      chan struct{}
+
+	// sessions holds the connected websockets of each user
+	sessionsMu sync.Mutex
+	sessions   map[string]map[*WebSocket]struct{}
 }
 
 // NewWSHandler returns a new WSHandler.
-func NewWSHandler(router router.Router, prefix string) (*WSHandler, error) {
+func NewWSHandler(router router.Router, prefix string, config Config) (*WSHandler, error) {
 	accessManager, err := router.AccessManager()
 	if err != nil {
 		return nil, err
 	}
-	return &WSHandler{
+	handler := &WSHandler{
 		router:        router,
 		prefix:        prefix,
 		accessManager: accessManager,
-	}, nil
+		redeemer:      config.Redeemer,
+		authenticator: config.Authenticator,
+		trustPathUser: config.TrustPathUser != nil && *config.TrustPathUser,
+		churn:         newChurn(prefix),
+	}
+	if config.SigningKey != nil && *config.SigningKey != "" {
+		handler.signingKey = []byte(*config.SigningKey)
+	}
+	if config.Origins != nil {
+		for _, origin := range *config.Origins {
+			if _, err := path.Match(origin, ""); err != nil {
+				return nil, fmt.Errorf("Invalid origin pattern %q: %s", origin, err.Error())
+			}
+			handler.origins = append(handler.origins, strings.ToLower(origin))
+		}
+	}
+	return handler, nil
 }
 
 // GetPrefix returns the prefix.
@@ -48,14 +115,145 @@ func (handler *WSHandler) GetPrefix() string {
 // ServeHTTP is an http.Handler.
 // It is a part of the service.endpoint implementation.
 func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	c, err := webSocketUpgrader.Upgrade(w, r, nil)
+	id, status, err := handler.identify(r)
+	if err != nil {
+		logger.WithError(err).WithField("path", r.URL.Path).Warn("Refusing websocket")
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var credits int
+	if value := r.URL.Query().Get(CreditsParam); value != "" {
+		if credits, err = parseCredits(value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !handler.accept() {
+		http.Error(w, errDraining.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer handler.release()
+
+	upgrader := webSocketUpgrader
+	upgrader.CheckOrigin = handler.checkOrigin
+	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logger.WithError(err).Error("Error on upgrading to websocket")
 		return
 	}
 	defer c.Close()
+	handler.churn.connected(id.userID)
+	defer handler.churn.disconnected(id.userID)
+
+	conn := &wsconn{Conn: c, messageType: websocket.BinaryMessage}
+	subprotocol := c.Subprotocol()
+	jsonFrames := subprotocol == JSONSubprotocol || subprotocol == JSONBatchSubprotocol
+	if jsonFrames {
+		conn.messageType = websocket.TextMessage
+	}
+	ws := NewWebSocket(handler, conn, id.userID)
+	ws.jsonFrames = jsonFrames
+	ws.batchFrames = subprotocol == BatchSubprotocol || subprotocol == JSONBatchSubprotocol
+	// the JSON frames are encoded with the uncompressed bodies
+	if !jsonFrames {
+		ws.encodings = protocol.AcceptedEncodings(r.Header.Get(protocol.AcceptEncodingHeader))
+	}
+	if credits > 0 {
+		ws.flow.grant(credits)
+	}
+	ws.tokenUserID = id.tokenUserID
+	ws.signedTopic = id.topic
+	ws.setExpires(id.expires)
+	handler.register(ws)
+	defer handler.unregister(ws)
+	ws.Start()
+}
+
+// identity is the identity of a websocket connection.
+type identity struct {
+	userID string
+
+	// tokenUserID is the user of the token, if the websocket is authenticated by a token
+	tokenUserID string
+
+	// expires is the time at which the authentication expires (never, if zero)
+	expires time.Time
+
+	// topic is the only topic to which the websocket can subscribe, if it is connected with a signed URL
+	topic protocol.Path
+}
+
+// identify returns the identity of a websocket request, or an error and the HTTP status refusing the request.
+// A signed URL is sufficient for subscribing to its topic, as the user of the path.
+// Otherwise, if the websockets are connected with credentials (tickets or tokens), the user is the user of the credentials,
+// and a path with another user (`/stream/user/<userID>`) is refused, unless the user of the path is trusted.
+// Without credentials, the user of the path is trusted.
+func (handler *WSHandler) identify(r *http.Request) (*identity, int, error) {
+	pathUserID := extractUserID(r.URL.Path)
+	credentialUserIDs := make([]string, 0, 2)
+	id := &identity{}
+
+	if query := r.URL.Query(); handler.signingKey != nil && query.Get("signature") != "" {
+		topic, expires, err := handler.verifySignature(query, pathUserID)
+		if err != nil {
+			return nil, http.StatusForbidden, err
+		}
+		return &identity{userID: pathUserID, expires: expires, topic: topic}, http.StatusOK, nil
+	}
+
+	if handler.redeemer != nil {
+		ticketUserID, err := handler.redeemer.Redeem(r.URL.Query().Get("ticket"))
+		if err != nil {
+			return nil, http.StatusForbidden, errors.New("Forbidden: a valid ticket is required.")
+		}
+		credentialUserIDs = append(credentialUserIDs, ticketUserID)
+	}
+	if handler.authenticator != nil {
+		tokenIdentity, err := handler.authenticator.Authenticate(requestToken(r))
+		if err != nil {
+			return nil, http.StatusUnauthorized, errors.New("Unauthorized: a valid token is required.")
+		}
+		id.tokenUserID, id.expires = tokenIdentity.UserID, tokenIdentity.Expires
+		credentialUserIDs = append(credentialUserIDs, id.tokenUserID)
+	}
+
+	if len(credentialUserIDs) == 0 || (handler.trustPathUser && pathUserID != "") {
+		id.userID = pathUserID
+		return id, http.StatusOK, nil
+	}
+	id.userID = credentialUserIDs[0]
+	for _, userID := range append(credentialUserIDs[1:], pathUserID) {
+		if userID != id.userID && userID != "" {
+			return nil, http.StatusForbidden, errors.New("Forbidden: the credentials are issued for another user.")
+		}
+	}
+	return id, http.StatusOK, nil
+}
 
-	NewWebSocket(handler, &wsconn{c}, extractUserID(r.RequestURI)).Start()
+// requestToken returns the token given as `?token=<token>` (browsers can not set the headers of a websocket),
+// or as `Authorization: Bearer <token>` (`Basic <credentials>`) header.
+func requestToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	return auth.RequestToken(r)
+}
+
+// checkOrigin returns true if the origin of the request is allowed.
+func (handler *WSHandler) checkOrigin(r *http.Request) bool {
+	origin := strings.ToLower(r.Header.Get("Origin"))
+	if len(handler.origins) == 0 || origin == "" {
+		return true
+	}
+	for _, pattern := range handler.origins {
+		if matched, _ := path.Match(pattern, origin); matched {
+			return true
+		}
+	}
+	logger.WithField("origin", origin).Warn("Refusing websocket from a not allowed origin")
+	return false
 }
 
 // WSConnection is a wrapper interface for the needed functions of the websocket.Conn
@@ -70,6 +268,7 @@ type WSConnection interface {
 // implementing the interface WSConn for better testability
 type wsconn struct {
 	*websocket.Conn
+	messageType int
 }
 
 // Close the connection.
@@ -79,7 +278,7 @@ func (conn *wsconn) Close() {
 
 // Send bytes through the connection and possibly return an error.
 func (conn *wsconn) Send(bytes []byte) error {
-	return conn.WriteMessage(websocket.BinaryMessage, bytes)
+	return conn.WriteMessage(conn.messageType, bytes)
 }
 
 // Receive bytes through the connection and possibly return an error.
@@ -88,14 +287,58 @@ func (conn *wsconn) Receive(bytes *[]byte) (err error) {
 	return err
 }
 
+// CloseWithReason sends a close frame with the code and the reason, before closing the connection.
+func (conn *wsconn) CloseWithReason(code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	conn.Conn.Close()
+}
+
+// reasonCloser is implemented by the connections which can be closed gracefully, with a reason.
+type reasonCloser interface {
+	CloseWithReason(code int, reason string)
+}
+
 // WebSocket struct represents a websocket.
 type WebSocket struct {
 	*WSHandler
 	WSConnection
 	applicationID string
 	userID        string
+	tokenUserID   string
+	signedTopic   protocol.Path
 	sendChannel   chan []byte
 	receivers     map[protocol.Path]*Receiver
+
+	// receiversMu guards the receivers, as the server cancels subscriptions as well (see UnsubscribeUser)
+	receiversMu sync.Mutex
+
+	// deliveries counts the redeliveries to the subscriptions annotating their messages
+	deliveries *deliveryLog
+
+	// jsonFrames is set if the client negotiated the JSON subprotocol
+	jsonFrames bool
+
+	// batchFrames is set if the client negotiated a batch subprotocol, coalescing the pending messages in a frame
+	batchFrames bool
+
+	// encodings are the content encodings of the compressed message bodies negotiated by the client
+	encodings []string
+
+	// flow pauses the sending of the messages while the client has no credits, if it granted credits
+	flow *flowControl
+
+	// closedC is closed when the connection is closed
+	closedC   chan struct{}
+	closeOnce sync.Once
+
+	// the authentication of the user expires at expires (never, if zero), and then expiredC is signaled
+	authMu    sync.Mutex
+	expires   time.Time
+	authTimer *time.Timer
+	expiredC  chan struct{}
+
+	// disconnectC receives the reason for which the websocket is disconnected by an admin
+	disconnectC chan string
 }
 
 // NewWebSocket returns a new WebSocket.
@@ -107,6 +350,11 @@ func NewWebSocket(handler *WSHandler, wsConn WSConnection, userID string) *WebSo
 		userID:        userID,
 		sendChannel:   make(chan []byte, 10),
 		receivers:     make(map[protocol.Path]*Receiver),
+		deliveries:    newDeliveryLog(),
+		expiredC:      make(chan struct{}, 1),
+		disconnectC:   make(chan string, 1),
+		flow:          newFlowControl(),
+		closedC:       make(chan struct{}),
 	}
 }
 
@@ -120,21 +368,120 @@ func (ws *WebSocket) Start() error {
 }
 
 func (ws *WebSocket) sendLoop() {
-	for raw := range ws.sendChannel {
-		if !ws.checkAccess(raw) {
-			continue
+	drainC := ws.drained()
+	for {
+		select {
+		case raw, ok := <-ws.sendChannel:
+			if !ok {
+				return
+			}
+			if !ws.checkAccess(raw) {
+				if !ws.sendNotifications(ws.revokeAccess()) {
+					ws.cleanAndClose()
+					return
+				}
+				continue
+			}
+			if !ws.waitForCredit(raw) {
+				return
+			}
+			if ws.batchFrames {
+				ok = ws.sendBatch(raw)
+			} else {
+				ok = ws.sendRaw(raw)
+			}
+			if !ok {
+				ws.cleanAndClose()
+				return
+			}
+		case <-ws.expiredC:
+			// the authentication may have been refreshed in the meantime
+			if ws.authExpired() {
+				ws.closeExpired()
+				return
+			}
+		case <-drainC:
+			ws.closeDraining()
+			return
+		case reason := <-ws.disconnectC:
+			ws.closeDisconnected(reason)
+			return
 		}
-		if err := ws.Send(raw); err != nil {
-			logger.WithFields(log.Fields{
-				"userId":        ws.userID,
-				"applicationID": ws.applicationID,
-				"totalSize":     len(raw),
-				"actualContent": string(raw),
-			}).Error("Could not send")
-			ws.cleanAndClose()
-			break
+	}
+}
+
+// sendRaw sends a message or a notification in the format of the negotiated subprotocol,
+// and returns false if the connection failed.
+func (ws *WebSocket) sendRaw(raw []byte) bool {
+	if ws.jsonFrames {
+		frame, err := toJSONFrame(raw)
+		if err != nil {
+			logger.WithError(err).WithField("actualContent", protocol.Redact(raw)).Error("Could not encode as JSON")
+			return true
 		}
+		raw = frame
+	}
+	return ws.sendFrame(raw)
+}
+
+// sendFrame sends an encoded frame, and returns false if the connection failed.
+func (ws *WebSocket) sendFrame(frame []byte) bool {
+	if err := ws.Send(frame); err != nil {
+		logger.WithFields(log.Fields{
+			"userId":        ws.userID,
+			"applicationID": ws.applicationID,
+			"totalSize":     len(frame),
+			"actualContent": protocol.Redact(frame),
+		}).Error("Could not send")
+		return false
+	}
+	return true
+}
+
+// closeExpired notifies the client that its authentication expired, and closes the connection:
+// the receive loop then stops the receivers.
+func (ws *WebSocket) closeExpired() {
+	logger.WithField("userId", ws.userID).Info("Closing websocket with expired authentication")
+	n := &protocol.NotificationMessage{
+		Name:    protocol.ERROR_AUTH_EXPIRED,
+		Arg:     "The authentication expired.",
+		IsError: true,
+	}
+	ws.sendExpiredSubscriptions()
+	ws.sendRaw(n.Bytes())
+	if c, ok := ws.WSConnection.(reasonCloser); ok {
+		c.CloseWithReason(websocket.ClosePolicyViolation, "authentication expired")
+		return
+	}
+	ws.Close()
+}
+
+// setExpires sets the time at which the authentication expires (never, if zero).
+func (ws *WebSocket) setExpires(expires time.Time) {
+	ws.authMu.Lock()
+	defer ws.authMu.Unlock()
+
+	ws.expires = expires
+	if ws.authTimer != nil {
+		ws.authTimer.Stop()
+		ws.authTimer = nil
+	}
+	if expires.IsZero() {
+		return
 	}
+	ws.authTimer = time.AfterFunc(expires.Sub(time.Now()), func() {
+		select {
+		case ws.expiredC <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// authExpired returns true if the authentication expired.
+func (ws *WebSocket) authExpired() bool {
+	ws.authMu.Lock()
+	defer ws.authMu.Unlock()
+	return !ws.expires.IsZero() && !time.Now().Before(ws.expires)
 }
 
 func (ws *WebSocket) checkAccess(raw []byte) bool {
@@ -172,11 +519,15 @@ func (ws *WebSocket) receiveLoop() {
 		}
 
 		//protocol.Debug("websocket_connector, raw message received: %v", string(message))
-		cmd, err := protocol.ParseCmd(message)
+		cmd, err := ws.parseCmd(message)
 		if err != nil {
 			ws.sendError(protocol.ERROR_BAD_REQUEST, "error parsing command. %v", err.Error())
 			continue
 		}
+		if cmd.Name != protocol.CmdAuth && ws.authExpired() {
+			ws.sendError(protocol.ERROR_AUTH_EXPIRED, "The authentication expired.")
+			continue
+		}
 		switch cmd.Name {
 		case protocol.CmdSend:
 			ws.handleSendCmd(cmd)
@@ -184,12 +535,28 @@ func (ws *WebSocket) receiveLoop() {
 			ws.handleReceiveCmd(cmd)
 		case protocol.CmdCancel:
 			ws.handleCancelCmd(cmd)
+		case protocol.CmdAck:
+			ws.handleAckCmd(cmd)
+		case protocol.CmdAuth:
+			ws.handleAuthCmd(cmd)
+		case protocol.CmdCredit:
+			ws.handleCreditCmd(cmd)
+		case protocol.CmdRead:
+			ws.handleReadCmd(cmd)
 		default:
 			ws.sendError(protocol.ERROR_BAD_REQUEST, "unknown command %v", cmd.Name)
 		}
 	}
 }
 
+// parseCmd parses a command in the format of the negotiated subprotocol.
+func (ws *WebSocket) parseCmd(message []byte) (*protocol.Cmd, error) {
+	if ws.jsonFrames {
+		return parseJSONCmd(message)
+	}
+	return protocol.ParseCmd(message)
+}
+
 func (ws *WebSocket) sendConnectionMessage() {
 	n := &protocol.NotificationMessage{
 		Name: protocol.SUCCESS_CONNECTED,
@@ -197,9 +564,44 @@ func (ws *WebSocket) sendConnectionMessage() {
 		Json: fmt.Sprintf(`{"ApplicationId": "%s", "UserId": "%s", "Time": "%s"}`, ws.applicationID, ws.userID, time.Now().Format(time.RFC3339)),
 	}
 	ws.sendChannel <- n.Bytes()
+	ws.sendAuthenticated()
+}
+
+// sendAuthenticated notifies the client of the time at which its authentication expires, if it does.
+func (ws *WebSocket) sendAuthenticated() {
+	ws.authMu.Lock()
+	expires := ws.expires
+	ws.authMu.Unlock()
+	if !expires.IsZero() {
+		ws.sendOK(protocol.SUCCESS_AUTHENTICATED, "%s", expires.UTC().Format(time.RFC3339))
+	}
+}
+
+// handleAuthCmd refreshes the authentication of the user with a new token,
+// before the authentication given when connecting (or by the last refresh) expires.
+func (ws *WebSocket) handleAuthCmd(cmd *protocol.Cmd) {
+	if ws.authenticator == nil {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "the authentication by token is not enabled")
+		return
+	}
+	identity, err := ws.authenticator.Authenticate(cmd.Arg)
+	if err != nil {
+		ws.sendError(protocol.ERROR_AUTH_FAILED, "%s", err.Error())
+		return
+	}
+	if identity.UserID != ws.tokenUserID {
+		ws.sendError(protocol.ERROR_AUTH_FAILED, "the token is issued for another user")
+		return
+	}
+	ws.setExpires(identity.Expires)
+	ws.sendAuthenticated()
 }
 
 func (ws *WebSocket) handleReceiveCmd(cmd *protocol.Cmd) {
+	if args := strings.Fields(cmd.Arg); ws.signedTopic != "" && (len(args) == 0 || protocol.Path(args[0]) != ws.signedTopic) {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "the signed URL only allows subscribing to %s", ws.signedTopic)
+		return
+	}
 	rec, err := NewReceiverFromCmd(
 		ws.applicationID,
 		cmd,
@@ -212,8 +614,23 @@ func (ws *WebSocket) handleReceiveCmd(cmd *protocol.Cmd) {
 		ws.sendError(protocol.ERROR_BAD_REQUEST, err.Error())
 		return
 	}
-	ws.receivers[rec.path] = rec
+	rec.deliveries = ws.deliveries
+	rec.encodings = ws.encodings
+	ws.addReceiver(rec)
 	rec.Start()
+	ws.churn.subscribed()
+}
+
+// addReceiver registers the receiver of a command, unless it only fetches the messages of a subscribed topic
+// (e.g. a gap fetched again by a client), which must not replace the receiver of the subscription.
+func (ws *WebSocket) addReceiver(rec *Receiver) bool {
+	ws.receiversMu.Lock()
+	defer ws.receiversMu.Unlock()
+	if _, exist := ws.receivers[rec.path]; exist && !rec.doSubscription {
+		return false
+	}
+	ws.receivers[rec.path] = rec
+	return true
 }
 
 func (ws *WebSocket) handleCancelCmd(cmd *protocol.Cmd) {
@@ -222,22 +639,44 @@ func (ws *WebSocket) handleCancelCmd(cmd *protocol.Cmd) {
 		return
 	}
 	path := protocol.Path(cmd.Arg)
+	ws.receiversMu.Lock()
+	defer ws.receiversMu.Unlock()
 	rec, exist := ws.receivers[path]
 	if exist {
 		rec.Stop()
 		delete(ws.receivers, path)
+		ws.churn.unsubscribed()
 	}
 }
 
-func (ws *WebSocket) handleSendCmd(cmd *protocol.Cmd) {
-	logger.WithFields(log.Fields{
-		"cmd": string(cmd.Bytes()),
-	}).Debug("Sending ")
+func (ws *WebSocket) handleAckCmd(cmd *protocol.Cmd) {
+	args := strings.SplitN(cmd.Arg, " ", 2)
+	if len(args) < 2 {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "* command requires a path and a count argument")
+		return
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count <= 0 {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "count has to be a positive int, but was %q", args[1])
+		return
+	}
+	ws.receiversMu.Lock()
+	rec, exist := ws.receivers[protocol.Path(args[0])]
+	ws.receiversMu.Unlock()
+	if exist {
+		rec.Ack(count)
+	}
+}
 
+func (ws *WebSocket) handleSendCmd(cmd *protocol.Cmd) {
 	if len(cmd.Arg) == 0 {
 		ws.sendError(protocol.ERROR_BAD_REQUEST, "send command requires a path argument, but none given")
 		return
 	}
+	if ws.signedTopic != "" {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "the signed URL only allows subscribing to %s", ws.signedTopic)
+		return
+	}
 
 	args := strings.SplitN(cmd.Arg, " ", 2)
 	msg := &protocol.Message{
@@ -245,24 +684,60 @@ func (ws *WebSocket) handleSendCmd(cmd *protocol.Cmd) {
 		ApplicationID: ws.applicationID,
 		UserID:        ws.userID,
 		HeaderJSON:    cmd.HeaderJSON,
+		ContentType:   headerContentType(cmd.HeaderJSON),
 		Body:          cmd.Body,
 	}
+	logger.WithFields(log.Fields{
+		"path":   msg.Path,
+		"header": msg.HeaderJSON,
+		"body":   msg.RedactedBody(),
+	}).Debug("Sending ")
 
-	ws.router.HandleMessage(msg)
+	if err := ws.router.HandleMessage(msg); err != nil {
+		if rejected, ok := err.(*router.MessageRejectedError); ok {
+			ws.sendError(protocol.ERROR_BAD_REQUEST, "%s", rejected.Error())
+			return
+		}
+		if err == router.ErrReadOnlyReplica {
+			ws.sendError(protocol.ERROR_BAD_REQUEST, "%s", err.Error())
+			return
+		}
+	}
 
 	ws.sendOK(protocol.SUCCESS_SEND, "")
 }
 
+// headerContentType returns the Content-Type given in the header line of a send command, if any.
+func headerContentType(headerJSON string) string {
+	if headerJSON == "" {
+		return ""
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal([]byte(headerJSON), &header); err != nil {
+		return ""
+	}
+	for key, value := range header {
+		if contentType, ok := value.(string); ok && strings.EqualFold(key, "Content-Type") {
+			return contentType
+		}
+	}
+	return ""
+}
+
 func (ws *WebSocket) cleanAndClose() {
 
 	logger.WithFields(log.Fields{
 		"applicationID": ws.applicationID,
 	}).Debug("Closing applicationId")
 
+	ws.receiversMu.Lock()
 	for path, rec := range ws.receivers {
 		rec.Stop()
 		delete(ws.receivers, path)
 	}
+	ws.receiversMu.Unlock()
+	ws.setExpires(time.Time{})
+	ws.closeOnce.Do(func() { close(ws.closedC) })
 
 	ws.Close()
 }
@@ -8,9 +8,12 @@ import (
 	"github.com/smancke/guble/testutil"
 
 	"github.com/golang/mock/gomock"
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
@@ -61,6 +64,23 @@ func Test_WebSocket_SubscribeAndUnsubscribe(t *testing.T) {
 	a.Equal(protocol.Path("/bar"), websocket.receivers[protocol.Path("/bar")].path)
 }
 
+func Test_WebSocket_FetchKeepsSubscription(t *testing.T) {
+	a := assert.New(t)
+
+	ws := &WebSocket{receivers: make(map[protocol.Path]*Receiver)}
+	subscription := &Receiver{path: "/foo", doSubscription: true}
+	a.True(ws.addReceiver(subscription))
+
+	// a fetch of the subscribed topic does not replace its subscription
+	a.False(ws.addReceiver(&Receiver{path: "/foo", doFetch: true}))
+	a.Equal(subscription, ws.receivers["/foo"])
+	a.True(ws.addReceiver(&Receiver{path: "/bar", doFetch: true}))
+
+	resubscription := &Receiver{path: "/foo", doFetch: true, doSubscription: true}
+	a.True(ws.addReceiver(resubscription))
+	a.Equal(resubscription, ws.receivers["/foo"])
+}
+
 func Test_SendMessage(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -74,6 +94,17 @@ func Test_SendMessage(t *testing.T) {
 	runNewWebSocket(wsconn, routerMock, messageStore, nil)
 }
 
+func Test_headerContentType(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("text/plain", headerContentType(`{"Content-Type": "text/plain", "Correlation-Id": "7sdks723ksgqn"}`))
+	a.Equal("application/x-protobuf", headerContentType(`{"content-type": "application/x-protobuf"}`))
+	a.Equal("", headerContentType(`{"key": "value"}`))
+	a.Equal("", headerContentType(`{"Content-Type": 42}`))
+	a.Equal("", headerContentType("no json"))
+	a.Equal("", headerContentType(""))
+}
+
 func Test_AnIncomingMessageIsDelivered(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -125,7 +156,7 @@ func Test_BadCommands(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
 
-	badRequests := []string{"XXXX", "", ">", ">/foo", "+", "-", "send /foo"}
+	badRequests := []string{"XXXX", "", ">", ">/foo", "+", "-", "send /foo", "*", "* /foo", "* /foo x", "@ user01:1m"}
 	wsconn, routerMock, messageStore := createDefaultMocks(badRequests)
 
 	counter := 0
@@ -205,6 +236,7 @@ func createDefaultMocks(inputMessages []string) (
 	routerMock := NewMockRouter(testutil.MockCtrl)
 	messageStore := NewMockMessageStore(testutil.MockCtrl)
 	routerMock.EXPECT().MessageStore().Return(messageStore, nil).AnyTimes()
+	routerMock.EXPECT().Cluster().Return(nil).AnyTimes()
 
 	wsconn := NewMockWSConnection(testutil.MockCtrl)
 	wsconn.EXPECT().Receive(gomock.Any()).Do(func(message *[]byte) error {
@@ -260,3 +292,144 @@ func (notify connectedNotificationMatcher) Matches(x interface{}) bool {
 func (notify connectedNotificationMatcher) String() string {
 	return fmt.Sprintf("is connected message")
 }
+
+// authenticatorFunc is an auth.Authenticator authenticating with a function.
+type authenticatorFunc func(token string) (*auth.Identity, error)
+
+func (f authenticatorFunc) Authenticate(token string) (*auth.Identity, error) {
+	return f(token)
+}
+
+// testAuthenticator authenticates the tokens `<userID>:<validity>`.
+var testAuthenticator = authenticatorFunc(func(token string) (*auth.Identity, error) {
+	parts := strings.SplitN(token, ":", 2)
+	if len(parts) != 2 {
+		return nil, auth.ErrInvalidToken
+	}
+	validity, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return nil, auth.ErrInvalidToken
+	}
+	return &auth.Identity{UserID: parts[0], Expires: time.Now().Add(validity)}, nil
+})
+
+func Test_WSHandler_RequiresToken(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	handler := testWSHandler(NewMockRouter(ctrl), auth.NewAllowAllAccessManager(true))
+	handler.authenticator = testAuthenticator
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/prefix/user/user01"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	a.Error(err)
+	a.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	_, resp, err = websocket.DefaultDialer.Dial(url+"?token=user02:1m", nil)
+	a.Error(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": []string{"Bearer user01:1m"}})
+	a.NoError(err)
+	defer conn.Close()
+	_, data, err := conn.ReadMessage()
+	a.NoError(err)
+	a.Contains(string(data), `"UserId": "user01"`)
+	_, data, err = conn.ReadMessage()
+	a.NoError(err)
+	a.True(strings.HasPrefix(string(data), "#"+protocol.SUCCESS_AUTHENTICATED+" "))
+}
+
+func Test_WebSocket_RefreshAndExpireAuthentication(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	handler := testWSHandler(NewMockRouter(ctrl), auth.NewAllowAllAccessManager(true))
+	handler.authenticator = testAuthenticator
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/prefix/user/user01?token=user01:100ms"
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	a.NoError(err)
+	defer conn.Close()
+	read := func() string {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err.Error()
+		}
+		return string(data)
+	}
+	a.Contains(read(), protocol.SUCCESS_CONNECTED)
+	a.Contains(read(), protocol.SUCCESS_AUTHENTICATED)
+
+	// the refresh is refused with an invalid token, or the token of another user
+	a.NoError(conn.WriteMessage(websocket.BinaryMessage, []byte("@ forged")))
+	a.True(strings.HasPrefix(read(), "!"+protocol.ERROR_AUTH_FAILED))
+	a.NoError(conn.WriteMessage(websocket.BinaryMessage, []byte("@ user02:1m")))
+	a.True(strings.HasPrefix(read(), "!"+protocol.ERROR_AUTH_FAILED))
+
+	a.NoError(conn.WriteMessage(websocket.BinaryMessage, []byte("@ user01:200ms")))
+	a.True(strings.HasPrefix(read(), "#"+protocol.SUCCESS_AUTHENTICATED))
+
+	// the connection outlives the first token, and is closed when the refreshed one expires
+	start := time.Now()
+	a.Equal("!"+protocol.ERROR_AUTH_EXPIRED+" The authentication expired.", read())
+	a.True(time.Since(start) >= 150*time.Millisecond)
+	_, _, err = conn.ReadMessage()
+	closeErr, ok := err.(*websocket.CloseError)
+	a.True(ok)
+	if ok {
+		a.Equal(websocket.ClosePolicyViolation, closeErr.Code)
+	}
+}
+
+func Test_WSHandler_Identify(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	handler := testWSHandler(NewMockRouter(ctrl), auth.NewAllowAllAccessManager(true))
+	identify := func(target string) (string, int) {
+		id, status, _ := handler.identify(httptest.NewRequest(http.MethodGet, target, nil))
+		if id == nil {
+			return "", status
+		}
+		return id.userID, status
+	}
+	check := func(target, expectedUserID string, expectedStatus int) {
+		userID, status := identify(target)
+		a.Equal(expectedStatus, status, target)
+		a.Equal(expectedUserID, userID, target)
+	}
+
+	// without credentials, the user of the path is trusted
+	check("/prefix/user/user01", "user01", http.StatusOK)
+
+	// with credentials, the user is derived from them
+	handler.authenticator = testAuthenticator
+	check("/prefix/user/user01?token=user01:1m", "user01", http.StatusOK)
+	check("/prefix?token=user01:1m", "user01", http.StatusOK)
+	check("/prefix/user/admin?token=user01:1m", "", http.StatusForbidden)
+	check("/prefix/user/user01", "", http.StatusUnauthorized)
+
+	handler.redeemer = redeemerFunc(func(ticket string) (string, error) {
+		if strings.HasPrefix(ticket, "for-") {
+			return strings.TrimPrefix(ticket, "for-"), nil
+		}
+		return "", ErrInvalidTicket
+	})
+	check("/prefix?ticket=for-user01&token=user01:1m", "user01", http.StatusOK)
+	check("/prefix?ticket=for-user02&token=user01:1m", "", http.StatusForbidden)
+	check("/prefix?ticket=forged&token=user01:1m", "", http.StatusForbidden)
+
+	// a trusted client acts as the user of its path
+	handler.trustPathUser = true
+	check("/prefix/user/admin?ticket=for-user02&token=user01:1m", "admin", http.StatusOK)
+	check("/prefix?ticket=for-user01&token=user01:1m", "user01", http.StatusOK)
+	check("/prefix/user/admin?ticket=forged&token=user01:1m", "", http.StatusForbidden)
+}
@@ -0,0 +1,10 @@
+package websocket
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns         = metrics.NS("websocket")
+	mEndpoints = ns.NewMap("endpoints")
+)